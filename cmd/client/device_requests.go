@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/spf13/cobra"
+)
+
+var requestsCmd = &cobra.Command{
+	Use:   "requests",
+	Short: "Review devices waiting to join your network",
+	Long: `List devices that asked to join your network with 'roamie auth login --email',
+and approve or deny them from this machine instead of scanning a QR code.
+
+Requests can be referenced by a unique prefix of their ID.`,
+	Run: runRequestsList,
+}
+
+var requestsApproveCmd = &cobra.Command{
+	Use:   "approve <request>",
+	Short: "Let a device join your network",
+	Args:  cobra.ExactArgs(1),
+	Run:   runRequestsReview(true),
+}
+
+var requestsDenyCmd = &cobra.Command{
+	Use:   "deny <request>",
+	Short: "Reject a device's request to join your network",
+	Args:  cobra.ExactArgs(1),
+	Run:   runRequestsReview(false),
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <code>",
+	Short: "Approve a device by the code it displays",
+	Long: `Approve a device that is logging in by typing the code it shows
+(for example BCDF-GHJK), when its QR code can't be scanned.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runVerify,
+}
+
+var (
+	verifyDeny bool
+	verifyYes  bool
+)
+
+func init() {
+	verifyCmd.Flags().BoolVar(&verifyDeny, "deny", false, "Reject the device instead of approving it")
+	verifyCmd.Flags().BoolVarP(&verifyYes, "yes", "y", false, "Skip confirmation prompt")
+
+	requestsCmd.AddCommand(requestsApproveCmd, requestsDenyCmd)
+	authCmd.AddCommand(requestsCmd, verifyCmd)
+}
+
+func runRequestsList(cmd *cobra.Command, args []string) {
+	cfg, apiClient := loadAPIClient()
+
+	challenges, err := apiClient.ListDeviceChallenges(cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: Failed to list device requests: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Pending Device Requests")
+	fmt.Println("=======================")
+
+	if len(challenges) == 0 {
+		fmt.Println("(no pending requests)")
+		return
+	}
+
+	fmt.Printf("  %-10s  %-24s  %-8s  %-16s  %-9s  %s\n", "ID", "HOSTNAME", "OS", "FROM", "APPROVALS", "REQUESTED")
+	for _, c := range challenges {
+		osType := c.OSType
+		if osType == "" {
+			osType = "-"
+		}
+
+		approvals := "-"
+		if c.RequiredApprovals > 1 {
+			approvals = fmt.Sprintf("%d/%d", c.Approvals, c.RequiredApprovals)
+		}
+
+		fmt.Printf("  %-10s  %-24s  %-8s  %-16s  %-9s  %s\n",
+			shortID(c.ID), c.Hostname, osType, c.IPAddress, approvals, formatLastSeen(parseTimestamp(c.CreatedAt)))
+		if c.Username != "" {
+			fmt.Printf("  %-10s  user %s, hardware %s\n", "", c.Username, c.HardwareID)
+		}
+		if len(c.ApprovedBy) > 0 {
+			fmt.Printf("  %-10s  approved by %s\n", "", strings.Join(c.ApprovedBy, ", "))
+		}
+	}
+
+	fmt.Println("\nApprove with: roamie auth requests approve <id>")
+}
+
+func runRequestsReview(approve bool) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		cfg, apiClient := loadAPIClient()
+
+		challenges, err := apiClient.ListDeviceChallenges(cfg.JWT)
+		if err != nil {
+			fmt.Printf("Error: Failed to list device requests: %v\n", err)
+			os.Exit(1)
+		}
+
+		challenge, err := resolveDeviceChallenge(challenges, args[0])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		reviewDeviceChallenge(cfg.JWT, apiClient, challenge, approve)
+	}
+}
+
+func runVerify(cmd *cobra.Command, args []string) {
+	cfg, apiClient := loadAPIClient()
+
+	challenge, err := apiClient.LookupDeviceChallenge(args[0], cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !verifyYes {
+		osType := challenge.OSType
+		if osType == "" {
+			osType = "unknown OS"
+		}
+		fmt.Printf("Device:    %s (%s)\n", challenge.Hostname, osType)
+		if challenge.Username != "" {
+			fmt.Printf("User:      %s\n", challenge.Username)
+		}
+		fmt.Printf("From:      %s\n", challenge.IPAddress)
+		fmt.Printf("Requested: %s\n", formatLastSeen(parseTimestamp(challenge.CreatedAt)))
+		fmt.Println("\nOnly continue if you started this login yourself.")
+
+		action := "Approve"
+		if verifyDeny {
+			action = "Deny"
+		}
+		fmt.Printf("%s this device? [y/N]: ", action)
+
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Println("Cancelled")
+			return
+		}
+	}
+
+	reviewDeviceChallenge(cfg.JWT, apiClient, challenge, !verifyDeny)
+}
+
+func reviewDeviceChallenge(jwt string, apiClient *api.Client, challenge *api.DeviceChallenge, approve bool) {
+	result, err := apiClient.ReviewDeviceChallenge(challenge.ID, approve, jwt)
+	if err != nil {
+		fmt.Printf("Error: Failed to review device request: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch result.Status {
+	case "approved":
+		fmt.Printf("✓ %s approved and joining your network\n", challenge.Hostname)
+	case "pending":
+		fmt.Printf("✓ Approval recorded for %s (%d/%d), waiting for an administrator\n", challenge.Hostname, result.Approvals, result.RequiredApprovals)
+	default:
+		fmt.Printf("✓ %s denied\n", challenge.Hostname)
+	}
+}
+
+// resolveDeviceChallenge finds the request whose ID equals or uniquely starts with query
+func resolveDeviceChallenge(challenges []api.DeviceChallenge, query string) (*api.DeviceChallenge, error) {
+	query = strings.ToLower(query)
+
+	var match *api.DeviceChallenge
+	for i := range challenges {
+		if challenges[i].ID == query {
+			return &challenges[i], nil
+		}
+		if strings.HasPrefix(challenges[i].ID, query) {
+			if match != nil {
+				return nil, fmt.Errorf("request %q is ambiguous, use more characters", query)
+			}
+			match = &challenges[i]
+		}
+	}
+
+	if match == nil {
+		return nil, fmt.Errorf("no pending request matches %q (see 'roamie auth requests')", query)
+	}
+	return match, nil
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/devices"
+	"github.com/kamikazebr/roamie-desktop/internal/client/ssh"
+	"github.com/spf13/cobra"
+)
+
+var (
+	devicesRemoveYes     bool
+	devicesRunArgs       string
+	devicesRunJSON       bool
+	devicesExposureAllow []string
+	sshUser              string
+)
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List and manage your devices",
+	Long: `List and manage all devices registered to your account.
+
+Devices can be referenced by display name, device name, DNS name, VPN IP or
+device ID (a unique prefix of at least 4 characters is enough).
+
+Each device can be reached over the VPN by its DNS name, for example
+ssh laptop.alice.roamie.internal (names come from the device's display name).`,
+	Run: runDevices,
+}
+
+var devicesListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List your devices with status, VPN IPs and DNS names",
+	Run:     runDevices,
+}
+
+var devicesRenameCmd = &cobra.Command{
+	Use:   "rename <device> <name>",
+	Short: "Set a device's display name (also changes its DNS name)",
+	Long: `Set a device's display name. Its DNS name follows the new name.
+Pass an empty name ("") to clear it and fall back to the device name.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runDevicesRename,
+}
+
+var devicesRemoveCmd = &cobra.Command{
+	Use:     "remove <device>",
+	Aliases: []string{"rm"},
+	Short:   "Remove a device and revoke its access",
+	Long: `Remove a device from your account. Its WireGuard peer is removed and its
+refresh tokens are revoked, so it can no longer connect or refresh its login.
+
+To remove this device, use 'roamie auth logout' instead.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runDevicesRemove,
+}
+
+var devicesTunnelCmd = &cobra.Command{
+	Use:   "tunnel",
+	Short: "Enable, disable or expose another device's SSH tunnel",
+}
+
+var devicesTunnelEnableCmd = &cobra.Command{
+	Use:   "enable <device>",
+	Short: "Enable a device's SSH tunnel on the server",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runDevicesTunnel(args[0], true)
+	},
+}
+
+var devicesTunnelDisableCmd = &cobra.Command{
+	Use:   "disable <device>",
+	Short: "Disable a device's SSH tunnel on the server",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runDevicesTunnel(args[0], false)
+	},
+}
+
+var devicesTunnelExposureCmd = &cobra.Command{
+	Use:   "exposure <device> <vpn|loopback|public|default>",
+	Short: "Set where a device's tunnel port listens on the server",
+	Long: `Set where a device's tunnel port listens on the server:
+
+  vpn        The server's VPN address: reachable from your devices over the VPN
+  loopback   Only the server itself: reach it with 'roamie forward' or 'roamie proxy'
+  public     Every interface, from the --allow source CIDRs (all sources if
+             the server allows that)
+  default    The server's setting (TUNNEL_BIND)
+
+  roamie devices tunnel exposure laptop public --allow 203.0.113.0/24
+
+The device's tunnel reconnects to move the port.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runDevicesTunnelExposure,
+}
+
+var devicesRunCmd = &cobra.Command{
+	Use:   "run <device> <command>",
+	Short: "Run a command on a device over its tunnel connection",
+	Long: `Run a command on a device over the connection its tunnel keeps to the
+server. Output is shown as the device sends it.
+
+Commands:
+  doctor           Run diagnostics now
+  sync-keys        Sync authorized SSH keys and the SSH CA now
+  restart-tunnel   Reconnect the device's tunnel
+  logs             Show recent daemon log lines (--args '{"lines": 500}')
+  apply-config     Change settings, e.g. --args '{"tunnel_transport": "websocket"}'
+                   (tunnel_transport, embedded_ssh, embedded_ssh_port,
+                   auto_upgrade_enabled)
+
+The device needs its tunnel connected and a client that supports the
+command ('roamie tunnel status' on the device lists them).`,
+	Args: cobra.ExactArgs(2),
+	Run:  runDevicesRun,
+}
+
+func init() {
+	devicesRunCmd.Flags().StringVar(&devicesRunArgs, "args", "", "Command arguments as JSON")
+	devicesRunCmd.Flags().BoolVar(&devicesRunJSON, "json", false, "Print the command's result as JSON")
+	devicesRemoveCmd.Flags().BoolVarP(&devicesRemoveYes, "yes", "y", false, "Skip confirmation prompt")
+	devicesTunnelExposureCmd.Flags().StringSliceVar(&devicesExposureAllow, "allow", nil, "Source CIDRs allowed to connect to a public port")
+	devicesTunnelCmd.AddCommand(devicesTunnelEnableCmd, devicesTunnelDisableCmd, devicesTunnelExposureCmd)
+	devicesCmd.AddCommand(devicesListCmd, devicesRenameCmd, devicesRemoveCmd, devicesTunnelCmd, devicesRunCmd)
+
+	// roamie ssh <device> connects to a device; the key management
+	// subcommands (sync, status, ...) keep working as before
+	sshCmd.Use = "ssh [device] [-- command]"
+	sshCmd.Long = `Connect to one of your devices over SSH, or manage SSH key sync.
+
+  roamie ssh laptop              Connect to a device (VPN first, then reverse tunnel)
+  roamie ssh laptop -- uptime    Run a command on a device
+
+Each login uses a short-lived certificate from the Roamie SSH CA, which
+devices accept once 'sudo roamie ssh trust' has been run on them.`
+	sshCmd.Run = runSSHConnect
+	sshCmd.Flags().StringVarP(&sshUser, "user", "l", "", "Remote user (defaults to the device owner's username)")
+
+	rootCmd.AddCommand(devicesCmd)
+}
+
+// loadDevices loads config and fetches the device list, exiting on failure
+func loadDevices() (*config.Config, *api.Client, *api.ListDevicesResponse) {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil {
+		fmt.Println("Error: Not authenticated. Please run 'roamie auth login' first.")
+		os.Exit(1)
+	}
+
+	apiClient := api.NewClient(cfg.ServerURL)
+	resp, err := apiClient.ListDevices(cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: Failed to list devices: %v\n", err)
+		os.Exit(1)
+	}
+
+	return cfg, apiClient, resp
+}
+
+// resolveDevice loads the device list and finds the device named by query
+func resolveDevice(query string) (*config.Config, *api.Client, *api.Device) {
+	cfg, apiClient, resp := loadDevices()
+
+	device, err := devices.Resolve(resp.Devices, query)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	return cfg, apiClient, device
+}
+
+func runDevices(cmd *cobra.Command, args []string) {
+	cfg, _, resp := loadDevices()
+
+	fmt.Println("Devices")
+	fmt.Println("=======")
+	fmt.Printf("Subnet: %s\n\n", resp.UserSubnet)
+
+	if len(resp.Devices) == 0 {
+		fmt.Println("(no devices registered)")
+		return
+	}
+
+	fmt.Printf("  %-28s  %-15s  %-7s  %-11s  %-10s  %s\n", "NAME", "VPN IP", "STATUS", "TUNNEL", "LAST SEEN", "DNS NAME")
+	for _, d := range resp.Devices {
+		name := d.Name()
+		if d.ID == cfg.DeviceID {
+			name += " *"
+		}
+
+		status := "offline"
+		if d.IsOnline {
+			status = "online"
+		}
+
+		tunnel := "-"
+		if d.TunnelPort != nil {
+			tunnel = fmt.Sprintf("%d", *d.TunnelPort)
+			if !d.TunnelEnabled {
+				tunnel += " (off)"
+			}
+		}
+
+		fmt.Printf("  %-28s  %-15s  %-7s  %-11s  %-10s  %s\n", name, d.VpnIP, status, tunnel, formatLastSeen(d.LastSeen), d.DNSName)
+	}
+
+	fmt.Println("\n* this device")
+	if resp.DNSZone == "" {
+		fmt.Println("(DNS names are not enabled on this server)")
+	}
+}
+
+func runDevicesRename(cmd *cobra.Command, args []string) {
+	cfg, apiClient, device := resolveDevice(args[0])
+
+	renamed, err := apiClient.RenameDevice(device.ID, cfg.JWT, args[1])
+	if err != nil {
+		fmt.Printf("Error: Failed to rename device: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✓ Renamed %s to %s\n", device.Name(), renamed.Name())
+	if device.DNSName != "" {
+		fmt.Println("  Its DNS name changes accordingly (see 'roamie devices')")
+	}
+}
+
+func runDevicesRemove(cmd *cobra.Command, args []string) {
+	cfg, apiClient, device := resolveDevice(args[0])
+
+	if device.ID == cfg.DeviceID {
+		fmt.Println("Error: This is the device you are using")
+		fmt.Println("To remove it, run: roamie auth logout")
+		os.Exit(1)
+	}
+
+	if !devicesRemoveYes {
+		fmt.Printf("Remove %s (%s)?\n", device.Name(), device.VpnIP)
+		fmt.Println("It will be disconnected from the VPN and must log in again to rejoin.")
+		fmt.Print("\nContinue? [y/N]: ")
+
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Println("Cancelled")
+			return
+		}
+	}
+
+	if err := apiClient.DeleteDevice(device.ID, cfg.JWT); err != nil {
+		fmt.Printf("Error: Failed to remove device: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✓ Removed %s (refresh tokens revoked)\n", device.Name())
+}
+
+func runDevicesTunnel(query string, enable bool) {
+	cfg, apiClient, device := resolveDevice(query)
+
+	if device.TunnelPort == nil {
+		fmt.Printf("Error: %s has no tunnel registered\n", device.Name())
+		fmt.Println("Run 'roamie tunnel register' on that device first")
+		os.Exit(1)
+	}
+
+	if enable {
+		if err := apiClient.EnableTunnel(device.ID, cfg.JWT); err != nil {
+			fmt.Printf("Error: Failed to enable tunnel: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Tunnel enabled for %s (port %d)\n", device.Name(), *device.TunnelPort)
+	} else {
+		if err := apiClient.DisableTunnel(device.ID, cfg.JWT); err != nil {
+			fmt.Printf("Error: Failed to disable tunnel: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Tunnel disabled for %s\n", device.Name())
+	}
+}
+
+func runDevicesTunnelExposure(cmd *cobra.Command, args []string) {
+	bind := args[1]
+	switch bind {
+	case "vpn", "loopback", "public":
+	case "default":
+		bind = ""
+	default:
+		fmt.Printf("Error: invalid exposure %q (vpn, loopback, public or default)\n", bind)
+		os.Exit(1)
+	}
+
+	cfg, apiClient, device := resolveDevice(args[0])
+
+	exposure, err := apiClient.SetTunnelExposure(device.ID, cfg.JWT, bind, devicesExposureAllow)
+	if err != nil {
+		fmt.Printf("Error: Failed to set tunnel exposure: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Tunnel port of %s: %s\n", device.Name(), devices.DescribeExposure(exposure))
+}
+
+func runDevicesRun(cmd *cobra.Command, args []string) {
+	var commandArgs json.RawMessage
+	if devicesRunArgs != "" {
+		if !json.Valid([]byte(devicesRunArgs)) {
+			fmt.Println("Error: --args must be valid JSON")
+			os.Exit(1)
+		}
+		commandArgs = json.RawMessage(devicesRunArgs)
+	}
+
+	cfg, apiClient, device := resolveDevice(args[0])
+
+	result, err := apiClient.RunDeviceCommand(device.ID, cfg.JWT, args[1], commandArgs, func(line string) {
+		fmt.Println(line)
+	})
+	if err != nil {
+		fmt.Printf("Error: Failed to run %s on %s: %v\n", args[1], device.Name(), err)
+		os.Exit(1)
+	}
+
+	if devicesRunJSON && len(result.Result) > 0 {
+		var out bytes.Buffer
+		json.Indent(&out, result.Result, "", "  ")
+		fmt.Println(out.String())
+	}
+	if !result.OK {
+		fmt.Printf("Error: %s\n", result.Error)
+		os.Exit(1)
+	}
+}
+
+func runSSHConnect(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Help()
+		return
+	}
+
+	cfg, apiClient, device := resolveDevice(args[0])
+
+	// The reverse tunnel host is only needed when the VPN path is down
+	tunnelHost := ""
+	var exposure *api.TunnelExposure
+	vpnReachable := devices.VPNReachable(device, 2*time.Second)
+	if !vpnReachable {
+		if status, err := apiClient.GetTunnelStatus(cfg.JWT); err == nil {
+			tunnelHost = status.ServerHost
+			for _, t := range status.Tunnels {
+				if t.DeviceID == device.ID {
+					exposure = t.Exposure
+				}
+			}
+		}
+	}
+
+	route, ok := devices.ChooseSSHRoute(device, tunnelHost, exposure, vpnReachable)
+	if !ok {
+		fmt.Printf("Error: Cannot reach %s\n", device.Name())
+		fmt.Printf("  • Over VPN: %s:22 did not answer (connect with 'sudo roamie connect')\n", device.VpnIP)
+		fmt.Println("  • Over tunnel: not registered or disabled on that device")
+		os.Exit(1)
+	}
+
+	user := sshUser
+	if user == "" && device.Username != nil {
+		user = *device.Username
+	}
+
+	sshArgs := route.Args(user, args[1:])
+	fmt.Printf("→ Connecting to %s via %s (ssh %s)\n", device.Name(), route.Via, strings.Join(sshArgs, " "))
+
+	// A fresh short-lived certificate per login, so access ends as soon as
+	// the device or account is removed. Devices that don't trust the Roamie
+	// CA yet still accept the user's own keys.
+	hostAlias := device.VpnIP
+	if device.DNSName != "" {
+		hostAlias = device.DNSName
+	}
+	sessionKey, err := ssh.NewSessionKey(apiClient, device.ID, hostAlias, cfg.JWT)
+	if err != nil {
+		fmt.Printf("Warning: %v (using your own SSH keys)\n", err)
+	} else {
+		sshArgs = append(sessionKey.Args(hostAlias), sshArgs...)
+	}
+
+	c := exec.Command("ssh", sshArgs...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	err = c.Run()
+	if sessionKey != nil {
+		sessionKey.Close()
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Printf("Error: Failed to run ssh: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// formatLastSeen renders a timestamp as a short relative age
+func formatLastSeen(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	age := time.Since(t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keysCreateDescription string
+	keysCreateReusable    bool
+	keysCreateUses        int
+	keysCreateExpires     time.Duration
+	keysCreateTags        []string
+	keysCreateTunnel      bool
+	keysCreateEphemeral   bool
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage enrollment keys for adding devices without a QR code",
+	Long: `Enrollment keys let servers, containers and other headless machines join your
+network without interactive approval:
+
+  roamie auth login --enroll-key <key>
+
+Keys are single-use unless created with --reusable or --uses, and expire
+after --expires. Revoking a key doesn't affect devices it already enrolled.`,
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your enrollment keys",
+	Run:   runKeysList,
+}
+
+var keysCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create an enrollment key (shown only once)",
+	Run:   runKeysCreate,
+}
+
+var keysRevokeCmd = &cobra.Command{
+	Use:   "revoke <key>",
+	Short: "Stop an enrollment key from enrolling more devices",
+	Long: `Revoke an enrollment key. Keys can be referenced by a unique prefix of their
+ID (see 'roamie auth keys list').`,
+	Args: cobra.ExactArgs(1),
+	Run:  runKeysRevoke,
+}
+
+func init() {
+	keysCreateCmd.Flags().StringVar(&keysCreateDescription, "description", "", "What the key is for")
+	keysCreateCmd.Flags().BoolVar(&keysCreateReusable, "reusable", false, "Allow unlimited enrollments until the key expires")
+	keysCreateCmd.Flags().IntVar(&keysCreateUses, "uses", 1, "Number of devices the key can enroll")
+	keysCreateCmd.Flags().DurationVar(&keysCreateExpires, "expires", 24*time.Hour, "How long the key stays valid (at most 2160h)")
+	keysCreateCmd.Flags().StringSliceVar(&keysCreateTags, "tag", nil, "Tag for enrolled devices (repeatable)")
+	keysCreateCmd.Flags().BoolVar(&keysCreateTunnel, "tunnel", false, "Enrolled devices register an SSH tunnel")
+	keysCreateCmd.Flags().BoolVar(&keysCreateEphemeral, "ephemeral", false, "Remove enrolled devices automatically once they go offline")
+	keysCmd.AddCommand(keysListCmd, keysCreateCmd, keysRevokeCmd)
+	authCmd.AddCommand(keysCmd)
+}
+
+// loadAPIClient loads config and builds an API client, exiting if not logged in
+func loadAPIClient() (*config.Config, *api.Client) {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil {
+		fmt.Println("Error: Not authenticated. Please run 'roamie auth login' first.")
+		os.Exit(1)
+	}
+
+	return cfg, api.NewClient(cfg.ServerURL)
+}
+
+func runKeysList(cmd *cobra.Command, args []string) {
+	cfg, apiClient := loadAPIClient()
+
+	keys, err := apiClient.ListEnrollmentKeys(cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: Failed to list enrollment keys: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Enrollment Keys")
+	fmt.Println("===============")
+
+	if len(keys) == 0 {
+		fmt.Println("(no enrollment keys)")
+		fmt.Println("\nCreate one with: roamie auth keys create")
+		return
+	}
+
+	fmt.Printf("  %-10s  %-18s  %-8s  %-6s  %-16s  %-6s  %s\n", "ID", "KEY", "STATUS", "USES", "EXPIRES", "TUNNEL", "TAGS")
+	for _, k := range keys {
+		uses := fmt.Sprintf("%d/∞", k.Uses)
+		if k.MaxUses != nil {
+			uses = fmt.Sprintf("%d/%d", k.Uses, *k.MaxUses)
+		}
+
+		tunnel := "no"
+		if k.Tunnel {
+			tunnel = "yes"
+		}
+
+		tags := strings.Join(k.Tags, ",")
+		if k.Ephemeral {
+			tags = strings.TrimPrefix(tags+" (ephemeral)", " ")
+		}
+		if tags == "" {
+			tags = "-"
+		}
+
+		expires := parseTimestamp(k.ExpiresAt).Local().Format("2006-01-02 15:04")
+		fmt.Printf("  %-10s  %-18s  %-8s  %-6s  %-16s  %-6s  %s\n", shortID(k.ID), k.KeyPrefix+"…", k.Status, uses, expires, tunnel, tags)
+		if k.Description != "" {
+			fmt.Printf("  %-10s  %s\n", "", k.Description)
+		}
+	}
+}
+
+func runKeysCreate(cmd *cobra.Command, args []string) {
+	cfg, apiClient := loadAPIClient()
+
+	req := api.CreateEnrollmentKeyRequest{
+		Description: keysCreateDescription,
+		Reusable:    keysCreateReusable,
+		MaxUses:     keysCreateUses,
+		ExpiresIn:   int64(keysCreateExpires / time.Second),
+		Tags:        keysCreateTags,
+		Tunnel:      keysCreateTunnel,
+		Ephemeral:   keysCreateEphemeral,
+	}
+	if !keysCreateReusable && keysCreateUses < 1 {
+		fmt.Println("Error: --uses must be at least 1 (or use --reusable)")
+		os.Exit(1)
+	}
+
+	resp, err := apiClient.CreateEnrollmentKey(req, cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: Failed to create enrollment key: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✓ Enrollment key created (expires %s)\n\n", parseTimestamp(resp.EnrollmentKey.ExpiresAt).Local().Format("2006-01-02 15:04"))
+	fmt.Printf("  %s\n\n", resp.Key)
+	fmt.Println("⚠️  This key is only shown once. Anyone with it can add devices to your network.")
+	fmt.Println("\nEnroll a device with:")
+	fmt.Printf("  ROAMIE_ENROLL_KEY=<key> roamie auth login %s\n", cfg.ServerURL)
+}
+
+func runKeysRevoke(cmd *cobra.Command, args []string) {
+	cfg, apiClient := loadAPIClient()
+
+	keys, err := apiClient.ListEnrollmentKeys(cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: Failed to list enrollment keys: %v\n", err)
+		os.Exit(1)
+	}
+
+	key, err := resolveEnrollmentKey(keys, args[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := apiClient.RevokeEnrollmentKey(key.ID, cfg.JWT); err != nil {
+		fmt.Printf("Error: Failed to revoke enrollment key: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✓ Enrollment key %s revoked\n", shortID(key.ID))
+	fmt.Println("  Devices it already enrolled keep working; remove them with 'roamie devices remove'")
+}
+
+// resolveEnrollmentKey finds the key whose ID equals or uniquely starts with query
+func resolveEnrollmentKey(keys []api.EnrollmentKey, query string) (*api.EnrollmentKey, error) {
+	query = strings.ToLower(query)
+
+	var match *api.EnrollmentKey
+	for i := range keys {
+		if keys[i].ID == query {
+			return &keys[i], nil
+		}
+		if strings.HasPrefix(keys[i].ID, query) {
+			if match != nil {
+				return nil, fmt.Errorf("key %q is ambiguous, use more characters", query)
+			}
+			match = &keys[i]
+		}
+	}
+
+	if match == nil {
+		return nil, fmt.Errorf("no enrollment key matches %q (see 'roamie auth keys list')", query)
+	}
+	return match, nil
+}
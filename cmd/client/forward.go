@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/devices"
+	"github.com/kamikazebr/roamie-desktop/internal/client/tunnel"
+	"github.com/kamikazebr/roamie-desktop/pkg/forward"
+	"github.com/spf13/cobra"
+)
+
+var forwardCmd = &cobra.Command{
+	Use:   "forward <device>:<port> [local-port]",
+	Short: "Forward a local port to a port on one of your devices",
+	Long: `Listen on a local port and carry each connection through the tunnel
+server to a port on one of your devices. The server only connects devices of
+the same account; no port is exposed on the server.
+
+  roamie forward laptop:22 2222      ssh -p 2222 localhost reaches the laptop
+  roamie forward nas:5432            Postgres on the NAS at localhost:5432
+
+The local port defaults to the remote port. The target device needs its
+tunnel connected, and this device a registered tunnel key
+('roamie tunnel register').`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  runForward,
+}
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy <device> [port]",
+	Short: "Connect stdin and stdout to a port on a device (ssh ProxyCommand)",
+	Long: `Carry stdin and stdout through the tunnel server to a port on one of
+your devices (22 by default), for use as an ssh ProxyCommand:
+
+  Host laptop
+      ProxyCommand roamie proxy %h
+
+Messages go to stderr so they don't mix with the connection.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  runProxy,
+}
+
+func init() {
+	rootCmd.AddCommand(forwardCmd, proxyCmd)
+}
+
+// newForwarder resolves the target device and prepares a forwarder to it
+func newForwarder(query string, port int) (*tunnel.Forwarder, *api.Device, error) {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil {
+		return nil, nil, errors.New("not authenticated, please run 'roamie auth login' first")
+	}
+
+	resp, err := api.NewClient(cfg.ServerURL).ListDevices(cfg.JWT)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	device, err := devices.Resolve(resp.Devices, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := devices.CheckForwardable(device); err != nil {
+		return nil, nil, err
+	}
+
+	client, err := tunnel.NewClient(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load tunnel key: %w", err)
+	}
+	return tunnel.NewForwarder(client, device.ID, port), device, nil
+}
+
+func runForward(cmd *cobra.Command, args []string) {
+	query, remotePort, err := devices.ParseForwardTarget(args[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	localPort := remotePort
+	if len(args) > 1 {
+		if localPort, err = devices.ParsePort(args[1]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	forwarder, device, err := newForwarder(query, remotePort)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer forwarder.Close()
+
+	// Connect now so problems show up before the first connection
+	if conn, err := forwarder.Dial(); err != nil {
+		fmt.Printf("Error: Failed to reach %s port %d: %v\n", device.Name(), remotePort, err)
+		os.Exit(1)
+	} else {
+		conn.Close()
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)))
+	if err != nil {
+		fmt.Printf("Error: Failed to listen on port %d: %v\n", localPort, err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	fmt.Printf("✓ Forwarding localhost:%d → %s port %d (Ctrl+C to stop)\n", localPort, device.Name(), remotePort)
+
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		go func() {
+			defer local.Close()
+			remote, err := forwarder.Dial()
+			if err != nil {
+				log.Printf("Failed to reach %s port %d: %v", device.Name(), remotePort, err)
+				return
+			}
+			defer remote.Close()
+			forward.Pipe(ctx, local, remote, forward.Options{})
+		}()
+	}
+}
+
+func runProxy(cmd *cobra.Command, args []string) {
+	port := 22
+	if len(args) > 1 {
+		var err error
+		if port, err = devices.ParsePort(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Only errors should reach ssh's output, not the tunnel client's logs
+	log.SetOutput(io.Discard)
+
+	forwarder, device, err := newForwarder(args[0], port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer forwarder.Close()
+
+	remote, err := forwarder.Dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to reach %s port %d: %v\n", device.Name(), port, err)
+		os.Exit(1)
+	}
+	defer remote.Close()
+
+	if _, err := forward.Pipe(context.Background(), stdio{}, remote, forward.Options{}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// stdio is stdin and stdout as one connection; closing the write side closes
+// stdout so the other end of a ProxyCommand sees EOF
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) CloseWrite() error           { return os.Stdout.Close() }
+
+func (stdio) Close() error {
+	os.Stdin.Close()
+	return os.Stdout.Close()
+}
@@ -2,21 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/kamikazebr/roamie-desktop/internal/client/api"
 	"github.com/kamikazebr/roamie-desktop/internal/client/auth"
 	"github.com/kamikazebr/roamie-desktop/internal/client/config"
 	"github.com/kamikazebr/roamie-desktop/internal/client/daemon"
+	"github.com/kamikazebr/roamie-desktop/internal/client/devices"
+	"github.com/kamikazebr/roamie-desktop/internal/client/netscan"
 	"github.com/kamikazebr/roamie-desktop/internal/client/ssh"
 	"github.com/kamikazebr/roamie-desktop/internal/client/sshd"
+	"github.com/kamikazebr/roamie-desktop/internal/client/sshserver"
 	"github.com/kamikazebr/roamie-desktop/internal/client/tunnel"
 	"github.com/kamikazebr/roamie-desktop/internal/client/upgrade"
+	"github.com/kamikazebr/roamie-desktop/internal/client/userspace"
 	"github.com/kamikazebr/roamie-desktop/internal/client/wireguard"
 	"github.com/kamikazebr/roamie-desktop/pkg/utils"
 	"github.com/kamikazebr/roamie-desktop/pkg/version"
@@ -36,6 +43,13 @@ var rootCmd = &cobra.Command{
 			}
 		}
 
+		// Move plaintext secrets left by older versions out of config.json
+		if migrated, err := config.MigrateSecrets(); err != nil {
+			fmt.Printf("Warning: failed to move secrets out of %s: %v\n", config.ConfigFile, err)
+		} else if migrated {
+			fmt.Println("✓ Moved device secrets out of config.json (see: roamie auth secrets)")
+		}
+
 		// Load config and show notifications
 		cfg, err := config.Load()
 		if err != nil || cfg == nil {
@@ -59,6 +73,17 @@ var rootCmd = &cobra.Command{
 			cfg.LastBackgroundUpdate.Shown = true
 			cfg.Save()
 		}
+
+		// Access tokens are short-lived; renew before the command uses one.
+		// Auth commands and the daemon manage the session themselves.
+		switch cmd.Name() {
+		case "login", "logout", "refresh", "daemon":
+			return
+		}
+		if err := auth.EnsureFreshToken(cfg); errors.Is(err, api.ErrDeviceDeleted) {
+			fmt.Println("⚠️  This device was removed from your account. Run 'roamie auth login' to add it again.")
+			fmt.Println()
+		}
 	},
 }
 
@@ -67,11 +92,26 @@ var authCmd = &cobra.Command{
 	Short: "Authentication commands",
 }
 
+var (
+	loginMethod    string
+	loginEnrollKey string
+	loginEmail     string
+)
+
 var loginCmd = &cobra.Command{
 	Use:   "login [server-url]",
-	Short: "Login to Roamie VPN via QR code",
-	Args:  cobra.MaximumNArgs(1),
-	Run:   runLogin,
+	Short: "Login to Roamie VPN",
+	Long: `Register this device with a Roamie server.
+
+By default the device is approved by scanning a QR code with the Roamie app.
+Self-hosted servers may instead offer login with an OpenID Connect provider
+in your browser, or with a local account email and password. The method is
+picked from what the server offers; override it with --method.
+
+Headless machines can enroll without interaction using a pre-authorized key
+from 'roamie auth keys create' (or ROAMIE_ENROLL_KEY in the environment).`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runLogin,
 }
 
 var daemonCmd = &cobra.Command{
@@ -156,18 +196,55 @@ var sshSetIntervalCmd = &cobra.Command{
 	Run:   runSSHSetInterval,
 }
 
+var (
+	connectIgnoreConflicts bool
+	connectUserspace       bool
+	connectSOCKS           string
+	connectHTTP            string
+	connectForwards        []string
+	connectForeground      bool
+	connectExitNode        string
+	connectAcceptRoutes    bool
+	disconnectUserspace    bool
+)
+
 var connectCmd = &cobra.Command{
 	Use:   "connect",
 	Short: "Connect to VPN using saved configuration",
-	Long:  "Connect to VPN using saved configuration. Requires root privileges.\nAlternatively, you can use: sudo wg-quick up roamie",
-	Run:   runConnect,
+	Long: `Connect to VPN using saved configuration. Requires root privileges.
+Alternatively, you can use: sudo wg-quick up roamie
+
+Before connecting, local routes, interfaces and Docker networks are scanned
+for ranges that overlap the VPN subnet. Overlaps are reported to the server
+and the connection is refused (use --ignore-conflicts to connect anyway).
+
+Routes advertised by your other devices (see 'roamie routes') can be used
+once approved. Both settings are remembered for later connects:
+
+  sudo roamie connect --accept-routes        Reach LANs behind subnet routers
+  sudo roamie connect --exit home-server     Send internet traffic via a device
+  sudo roamie connect --exit none            Stop using the exit node
+
+With --userspace, WireGuard runs inside roamie on a userspace network stack.
+No root, TUN device, kernel module or WireGuard install is needed. Apps reach
+the VPN through a local SOCKS5 proxy (and optionally an HTTP proxy) or through
+explicit port forwards:
+
+  roamie connect --userspace
+  roamie connect --userspace --http 127.0.0.1:8118 --forward 2222=10.100.0.5:22
+
+The tunnel runs in the daemon; use --foreground to run it in this process.`,
+	Run: runConnect,
 }
 
 var disconnectCmd = &cobra.Command{
 	Use:   "disconnect",
 	Short: "Disconnect from VPN",
-	Long:  "Disconnect from VPN. Requires root privileges.\nAlternatively, you can use: sudo wg-quick down roamie",
-	Run:   runDisconnect,
+	Long: `Disconnect from VPN. Requires root privileges.
+Alternatively, you can use: sudo wg-quick down roamie
+
+Use --userspace to stop a userspace VPN (no root needed).`,
+	Run: runDisconnect,
 }
 
 var tunnelCmd = &cobra.Command{
@@ -203,10 +280,32 @@ var tunnelStatusCmd = &cobra.Command{
 	Run:   runTunnelStatus,
 }
 
+var (
+	tunnelEmbeddedSSH     bool
+	tunnelEmbeddedSSHPort int
+	tunnelTransport       string
+)
+
 var tunnelRegisterCmd = &cobra.Command{
 	Use:   "register",
 	Short: "Register SSH key and allocate tunnel port",
-	Run:   runTunnelRegister,
+	Long: `Register this device's SSH key and allocate a tunnel port.
+
+The tunnel forwards SSH logins to the local sshd. On hosts without sshd
+(containers, minimal VMs, machines without root), use the SSH server built
+into the daemon instead:
+
+  roamie tunnel register --embedded-ssh
+
+It runs as your user on localhost, supports shells, commands, SFTP and port
+forwarding, and accepts the SSH keys Roamie syncs. sshd is still preferred
+whenever it is running. Use --embedded-ssh=false to turn it off again.
+
+The tunnel connects to port 2222 of the server and falls back to a WebSocket
+on the API URL (port 443 for https) on networks that block it. Use
+--transport websocket to skip the TCP attempt, or --transport tcp to never
+use the WebSocket. 'roamie tunnel status' shows the transport in use.`,
+	Run: runTunnelRegister,
 }
 
 var tunnelDisableCmd = &cobra.Command{
@@ -302,6 +401,32 @@ Examples:
 	Run:  runAutoUpgrade,
 }
 
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Local network conflict detection",
+}
+
+var networkScanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Scan local networks for overlaps with the VPN subnet",
+	Long: `Scan local routes, interfaces and Docker networks for ranges that
+overlap the VPN subnet, and report them to the server.
+
+The daemon runs the same scan every 15 minutes.`,
+	Run: runNetworkScan,
+}
+
+var networkRehomeYes bool
+
+var networkRehomeCmd = &cobra.Command{
+	Use:   "rehome",
+	Short: "Move your VPN subnet away from conflicting local networks",
+	Long: `Ask the server to allocate a new VPN subnet that avoids every network
+reported by your devices. All of your devices get new VPN IPs and must
+reconnect afterwards.`,
+	Run: runNetworkRehome,
+}
+
 var vpnCmd = &cobra.Command{
 	Use:   "vpn",
 	Short: "VPN management commands",
@@ -328,13 +453,30 @@ var vpnStatusCmd = &cobra.Command{
 func init() {
 	setupDaemonCmd.Flags().BoolVarP(&setupDaemonYes, "yes", "y", false, "Skip confirmation prompt")
 	upgradeCmd.Flags().BoolVarP(&upgradeForce, "force", "f", false, "Force upgrade even if already on latest version")
+	tunnelRegisterCmd.Flags().BoolVar(&tunnelEmbeddedSSH, "embedded-ssh", false, "Serve the tunnel with the embedded SSH server when sshd isn't running")
+	tunnelRegisterCmd.Flags().StringVar(&tunnelTransport, "transport", "", "Tunnel transport: auto, tcp or websocket (default auto)")
+	tunnelRegisterCmd.Flags().IntVar(&tunnelEmbeddedSSHPort, "embedded-ssh-port", 0, fmt.Sprintf("Local port of the embedded SSH server (default %d)", sshserver.DefaultPort))
 	upgradeCmd.Flags().BoolVar(&upgradeNoRestart, "no-restart", false, "Do not restart daemon after upgrade")
 	upgradeCmd.AddCommand(upgradeCheckCmd)
+	loginCmd.Flags().StringVar(&loginMethod, "method", "", "Login method: qr, oidc or password (default: picked from the server)")
+	loginCmd.Flags().StringVar(&loginEnrollKey, "enroll-key", "", "Enroll without interaction using an enrollment key (default $ROAMIE_ENROLL_KEY)")
+	loginCmd.Flags().StringVar(&loginEmail, "email", "", "Your account email, to approve this device from your other devices ('roamie auth requests')")
 	authCmd.AddCommand(loginCmd, daemonCmd, statusCmd, refreshCmd, logoutCmd)
 	sshCmd.AddCommand(sshSyncCmd, sshStatusCmd, sshEnableCmd, sshDisableCmd, sshSetIntervalCmd)
 	tunnelCmd.AddCommand(tunnelStartCmd, tunnelStopCmd, tunnelStatusCmd, tunnelRegisterCmd, tunnelDisableCmd, tunnelEnableCmd)
 	vpnCmd.AddCommand(vpnInstallCmd, vpnStatusCmd)
-	rootCmd.AddCommand(authCmd, sshCmd, tunnelCmd, vpnCmd, setupDaemonCmd, uninstallDaemonCmd, versionCmd, connectCmd, disconnectCmd, upgradeCmd, autoUpgradeCmd, doctorCmd)
+	connectCmd.Flags().BoolVar(&connectIgnoreConflicts, "ignore-conflicts", false, "Connect even if local networks overlap the VPN subnet")
+	connectCmd.Flags().BoolVar(&connectUserspace, "userspace", false, "Run WireGuard in userspace (no root or kernel module)")
+	connectCmd.Flags().StringVar(&connectSOCKS, "socks", "", "SOCKS5 proxy listen address in userspace mode (default "+userspace.DefaultSOCKSAddr+")")
+	connectCmd.Flags().StringVar(&connectHTTP, "http", "", "HTTP proxy listen address in userspace mode (disabled by default)")
+	connectCmd.Flags().StringArrayVar(&connectForwards, "forward", nil, "Forward a local port to a VPN address in userspace mode (local=remote, repeatable)")
+	connectCmd.Flags().BoolVar(&connectForeground, "foreground", false, "Run the userspace tunnel in this process instead of the daemon")
+	connectCmd.Flags().StringVar(&connectExitNode, "exit", "", "Send internet traffic through an exit node device (\"none\" to stop)")
+	connectCmd.Flags().BoolVar(&connectAcceptRoutes, "accept-routes", false, "Route approved subnets advertised by your other devices")
+	disconnectCmd.Flags().BoolVar(&disconnectUserspace, "userspace", false, "Stop the userspace VPN")
+	networkRehomeCmd.Flags().BoolVarP(&networkRehomeYes, "yes", "y", false, "Skip confirmation prompt")
+	networkCmd.AddCommand(networkScanCmd, networkRehomeCmd)
+	rootCmd.AddCommand(authCmd, sshCmd, tunnelCmd, vpnCmd, networkCmd, setupDaemonCmd, uninstallDaemonCmd, versionCmd, connectCmd, disconnectCmd, upgradeCmd, autoUpgradeCmd, doctorCmd)
 }
 
 func main() {
@@ -359,7 +501,13 @@ func runLogin(cmd *cobra.Command, args []string) {
 		serverURL = args[0]
 	}
 
-	if err := auth.Login(serverURL); err != nil {
+	// Keys can also come from the environment, which keeps them out of the process list
+	enrollKey := loginEnrollKey
+	if enrollKey == "" {
+		enrollKey = os.Getenv("ROAMIE_ENROLL_KEY")
+	}
+
+	if err := auth.Login(serverURL, loginMethod, enrollKey, loginEmail); err != nil {
 		fmt.Printf("Login failed: %v\n", err)
 		os.Exit(1)
 	}
@@ -415,24 +563,12 @@ func runRefresh(cmd *cobra.Command, args []string) {
 
 	fmt.Println("Refreshing JWT token...")
 
-	client := api.NewClient(cfg.ServerURL)
-	resp, err := client.RefreshJWT(cfg.RefreshToken)
-	if err != nil {
+	if err := auth.RefreshSession(cfg); err != nil {
 		fmt.Printf("Refresh failed: %v\n", err)
 		os.Exit(1)
 	}
 
-	expiresAt, _ := time.Parse("2006-01-02T15:04:05Z", resp.ExpiresAt)
-
-	cfg.JWT = resp.JWT
-	cfg.ExpiresAt = expiresAt
-
-	if err := cfg.Save(); err != nil {
-		fmt.Printf("Failed to save: %v\n", err)
-		os.Exit(1)
-	}
-
-	fmt.Printf("✓ JWT refreshed (expires: %s)\n", expiresAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("✓ JWT refreshed (expires: %s)\n", cfg.ExpiresAt.Format("2006-01-02 15:04:05"))
 }
 
 func runLogout(cmd *cobra.Command, args []string) {
@@ -705,6 +841,11 @@ func runSSHSetInterval(cmd *cobra.Command, args []string) {
 }
 
 func runConnect(cmd *cobra.Command, args []string) {
+	if connectUserspace {
+		runConnectUserspace()
+		return
+	}
+
 	// Check if running as root
 	if os.Geteuid() != 0 {
 		fmt.Println("Error: This command requires root privileges")
@@ -743,6 +884,38 @@ func runConnect(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	// Pre-flight check: Detect local networks overlapping the VPN subnet
+	fmt.Println("→ Checking for network conflicts...")
+	check := netscan.Check(cfg)
+	if check.SubnetMoved {
+		fmt.Printf("→ Server moved your subnet to %s, updating local config...\n", check.Subnet)
+		if err := netscan.SyncAddress(cfg, check.Subnet); err != nil {
+			fmt.Printf("Error: Failed to update VPN address: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(check.Conflicts) > 0 {
+		fmt.Printf("⚠️  %d local network(s) overlap the VPN subnet %s:\n", len(check.Conflicts), check.Subnet)
+		for _, c := range check.Conflicts {
+			fmt.Printf("  • %s (%s)\n", c.CIDR, c.Description)
+		}
+		if !connectIgnoreConflicts {
+			fmt.Println("\nTraffic to these ranges would be misrouted while connected.")
+			fmt.Println("Move your VPN subnet with: roamie network rehome")
+			fmt.Println("Or connect anyway with: sudo roamie connect --ignore-conflicts")
+			os.Exit(1)
+		}
+	} else {
+		fmt.Println("✓ No network conflicts")
+	}
+
+	// Refresh VPN DNS settings (non-fatal: names just won't resolve)
+	if err := auth.SyncDNSSettings(cfg); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+
+	applyConnectRouteFlags(cmd, cfg)
+
 	fmt.Println("Connecting to VPN...")
 	fmt.Printf("  Device: %s\n", cfg.DeviceName)
 	fmt.Printf("  VPN IP: %s\n", cfg.VpnIP)
@@ -754,6 +927,14 @@ func runConnect(cmd *cobra.Command, args []string) {
 		ServerKey:  cfg.ServerPublicKey,
 		Endpoint:   cfg.ServerEndpoint,
 		AllowedIPs: cfg.AllowedIPs,
+		DNS:        cfg.DNSServer,
+		DNSZone:    cfg.DNSZone,
+	}
+
+	// Subnet routes and exit node (non-fatal: falls back to the plain tunnel)
+	if err := auth.ApplyRouteSettings(cfg, &wgConfig); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+		fmt.Println("   Connecting without subnet routes or exit node (clear with --exit none)")
 	}
 
 	// Connect (generates config file and connects)
@@ -767,13 +948,129 @@ func runConnect(cmd *cobra.Command, args []string) {
 	fmt.Printf("   Config: %s\n", configPath)
 	fmt.Printf("   Interface: roamie\n")
 	fmt.Printf("   VPN IP: %s\n", cfg.VpnIP)
+	if cfg.DNSZone != "" {
+		fmt.Printf("   Device names: *.%s (see 'roamie devices')\n", cfg.DNSZone)
+	}
+	if wgConfig.AllowedIPs != cfg.AllowedIPs {
+		fmt.Printf("   Routes: %s\n", wgConfig.AllowedIPs)
+	}
+	if wgConfig.ForwardSubnet != "" {
+		fmt.Println("   Forwarding: enabled for your devices (advertised routes)")
+	}
 	fmt.Println("\nUseful commands:")
 	fmt.Println("  • Check status: sudo wg show roamie")
 	fmt.Println("  • Disconnect: sudo roamie disconnect")
 	fmt.Println("  • Or: sudo wg-quick down roamie")
 }
 
+// runConnectUserspace enables the userspace VPN. The daemon picks the change
+// up from config; --foreground runs the tunnel here until interrupted.
+func runConnectUserspace() {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Error: Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg == nil {
+		fmt.Println("Error: Not logged in")
+		fmt.Println("Please run 'roamie auth login' first")
+		os.Exit(1)
+	}
+
+	if connectSOCKS != "" {
+		cfg.UserspaceSOCKS = connectSOCKS
+	}
+	if connectHTTP != "" {
+		cfg.UserspaceHTTP = connectHTTP
+	}
+	if len(connectForwards) > 0 {
+		cfg.UserspaceForwards = connectForwards
+	}
+
+	// Refresh VPN DNS settings (non-fatal: names just won't resolve)
+	if err := auth.SyncDNSSettings(cfg); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+
+	// Validate before saving so the daemon never sees a broken config
+	usCfg, err := userspace.FromClientConfig(cfg)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		if cfg.PrivateKey == "" || cfg.VpnIP == "" {
+			fmt.Println("Please run 'roamie auth login' first")
+		}
+		os.Exit(1)
+	}
+
+	fmt.Println("Connecting to VPN (userspace mode)...")
+	fmt.Printf("  Device: %s\n", cfg.DeviceName)
+	fmt.Printf("  VPN IP: %s\n", cfg.VpnIP)
+
+	if connectForeground {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		t, err := userspace.Start(ctx, usCfg)
+		if err != nil {
+			fmt.Printf("Error: Failed to start userspace VPN: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("\n✅ Userspace VPN running (Ctrl+C to stop)")
+		printUserspaceEntryPoints(usCfg)
+		<-ctx.Done()
+		t.Close()
+		fmt.Println("\n✓ Disconnected from VPN")
+		return
+	}
+
+	cfg.UserspaceEnabled = true
+	if err := cfg.Save(); err != nil {
+		fmt.Printf("Error: Failed to save config: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("\n✅ Userspace VPN enabled!")
+	fmt.Println("   The daemon will start the tunnel within a few seconds.")
+	printUserspaceEntryPoints(usCfg)
+	fmt.Println("\nUseful commands:")
+	fmt.Println("  • Check status: roamie vpn status")
+	fmt.Println("  • Disconnect: roamie disconnect --userspace")
+	fmt.Println("  • Daemon not running? roamie setup-daemon")
+}
+
+func printUserspaceEntryPoints(usCfg userspace.Config) {
+	fmt.Printf("   SOCKS5 proxy: %s\n", usCfg.SOCKSAddr)
+	if usCfg.HTTPAddr != "" {
+		fmt.Printf("   HTTP proxy: %s\n", usCfg.HTTPAddr)
+	}
+	for _, fwd := range usCfg.Forwards {
+		fmt.Printf("   Forward: %s → %s\n", fwd.Local, fwd.Remote)
+	}
+}
+
 func runDisconnect(cmd *cobra.Command, args []string) {
+	if disconnectUserspace {
+		cfg, err := config.Load()
+		if err != nil {
+			fmt.Printf("Error: Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+		if cfg == nil || !cfg.UserspaceEnabled {
+			fmt.Println("✓ Userspace VPN is not enabled")
+			return
+		}
+
+		cfg.UserspaceEnabled = false
+		if err := cfg.Save(); err != nil {
+			fmt.Printf("Error: Failed to save config: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("✓ Userspace VPN disabled (the daemon stops it within a few seconds)")
+		return
+	}
+
 	// Check if running as root
 	if os.Geteuid() != 0 {
 		fmt.Println("Error: This command requires root privileges")
@@ -792,6 +1089,112 @@ func runDisconnect(cmd *cobra.Command, args []string) {
 	fmt.Println("✓ Disconnected from VPN")
 }
 
+func runNetworkScan(cmd *cobra.Command, args []string) {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Error: Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg == nil {
+		fmt.Println("Error: Not authenticated. Please run 'roamie auth login' first.")
+		os.Exit(1)
+	}
+
+	fmt.Println("Scanning local networks...")
+	check := netscan.Check(cfg)
+
+	fmt.Println("\nLocal Networks")
+	fmt.Println("==============")
+	if len(check.Networks) == 0 {
+		fmt.Println("(none found)")
+	}
+	for _, n := range check.Networks {
+		fmt.Printf("  %-18s  %-9s  %s\n", n.CIDR, n.Source, n.Description)
+	}
+
+	fmt.Printf("\nVPN subnet: %s\n", check.Subnet)
+	if check.Reported {
+		fmt.Println("✓ Scan reported to server")
+	} else if check.ReportError != nil {
+		fmt.Printf("⚠️  Failed to report scan to server: %v\n", check.ReportError)
+	}
+
+	if check.SubnetMoved {
+		if err := netscan.SyncAddress(cfg, check.Subnet); err != nil {
+			fmt.Printf("⚠️  Server moved your subnet but updating local config failed: %v\n", err)
+		} else {
+			fmt.Printf("✓ Local config updated (new VPN IP: %s)\n", cfg.VpnIP)
+			fmt.Println("  Reconnect to apply: sudo roamie connect")
+		}
+	}
+
+	if len(check.Conflicts) == 0 {
+		fmt.Println("✓ No networks overlap the VPN subnet")
+		return
+	}
+
+	fmt.Printf("\n⚠️  %d network(s) overlap the VPN subnet:\n", len(check.Conflicts))
+	for _, c := range check.Conflicts {
+		fmt.Printf("  • %s (%s)\n", c.CIDR, c.Description)
+	}
+	fmt.Println("\nMove your VPN subnet with: roamie network rehome")
+}
+
+func runNetworkRehome(cmd *cobra.Command, args []string) {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Error: Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg == nil {
+		fmt.Println("Error: Not authenticated. Please run 'roamie auth login' first.")
+		os.Exit(1)
+	}
+
+	// Report a fresh scan first so the server re-homes around current networks
+	fmt.Println("Scanning local networks...")
+	check := netscan.Check(cfg)
+	if check.ReportError != nil {
+		fmt.Printf("Error: Failed to report scan to server: %v\n", check.ReportError)
+		os.Exit(1)
+	}
+
+	if !networkRehomeYes {
+		fmt.Printf("\nYour VPN subnet %s will be replaced and all of your devices\n", check.Subnet)
+		fmt.Println("will get new VPN IPs. Connected devices must reconnect.")
+		fmt.Print("\nContinue? [y/N]: ")
+
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Println("Cancelled")
+			return
+		}
+	}
+
+	client := api.NewClient(cfg.ServerURL)
+	resp, err := client.RehomeSubnet(cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: Failed to re-home subnet: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n✓ Subnet moved: %s → %s\n", resp.OldSubnet, resp.NewSubnet)
+	for _, d := range resp.Devices {
+		fmt.Printf("  %s: %s → %s\n", d.DeviceID, d.OldVpnIP, d.NewVpnIP)
+	}
+
+	if err := netscan.SyncAddress(cfg, resp.NewSubnet); err != nil {
+		fmt.Printf("⚠️  Failed to update local config: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("\nReconnect to apply: sudo roamie connect")
+	fmt.Println("Other devices pick up their new address on their next network scan.")
+}
+
 // Tunnel command implementations
 
 func runTunnelRegister(cmd *cobra.Command, args []string) {
@@ -802,19 +1205,41 @@ func runTunnelRegister(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	// Pre-flight check: Ensure SSH daemon is available
-	fmt.Println("→ Checking SSH server availability...")
-	sshdAvailable, err := sshd.PromptInstall()
-	if err != nil {
-		fmt.Printf("Error: SSH check failed: %v\n", err)
-		os.Exit(1)
+	if cmd.Flags().Changed("embedded-ssh") {
+		cfg.EmbeddedSSH = tunnelEmbeddedSSH
 	}
-	if !sshdAvailable {
-		fmt.Println("\nSSH server is required for the tunnel to work.")
-		fmt.Println("Please install and start SSH server, then try again.")
-		os.Exit(1)
+	if cmd.Flags().Changed("embedded-ssh-port") {
+		cfg.EmbeddedSSHPort = tunnelEmbeddedSSHPort
+	}
+	if cmd.Flags().Changed("transport") {
+		if !tunnel.ValidTransport(tunnelTransport) {
+			fmt.Printf("Error: Invalid transport %q (use auto, tcp or websocket)\n", tunnelTransport)
+			os.Exit(1)
+		}
+		cfg.TunnelTransport = tunnelTransport
+		if tunnelTransport == tunnel.TransportAuto {
+			cfg.TunnelTransport = ""
+		}
+	}
+
+	// Pre-flight check: Ensure SSH daemon is available
+	if cfg.EmbeddedSSH {
+		fmt.Printf("✓ Using the embedded SSH server when sshd isn't running (%s)\n", sshserver.ListenAddr(cfg.EmbeddedSSHPort))
+	} else {
+		fmt.Println("→ Checking SSH server availability...")
+		sshdAvailable, err := sshd.PromptInstall()
+		if err != nil {
+			fmt.Printf("Error: SSH check failed: %v\n", err)
+			os.Exit(1)
+		}
+		if !sshdAvailable {
+			fmt.Println("\nSSH server is required for the tunnel to work.")
+			fmt.Println("Please install and start SSH server, then try again.")
+			fmt.Println("Or use the SSH server built into roamie: roamie tunnel register --embedded-ssh")
+			os.Exit(1)
+		}
+		fmt.Println("✓ SSH server is available")
 	}
-	fmt.Println("✓ SSH server is available")
 
 	fmt.Println("\nRegistering SSH tunnel...")
 
@@ -851,6 +1276,14 @@ func runTunnelRegister(cmd *cobra.Command, args []string) {
 	}
 	fmt.Println("✓ Tunnel enabled on server")
 
+	// Pin the server's host keys so every tunnel connection is verified
+	if err := tunnel.PinHostKeys(cfg); err != nil {
+		fmt.Printf("Warning: Failed to pin tunnel server host key: %v\n", err)
+		fmt.Println("  The tunnel will pin it when it first connects")
+	} else {
+		fmt.Println("✓ Tunnel server host key pinned")
+	}
+
 	// Save tunnel config locally
 	cfg.TunnelEnabled = true
 	cfg.TunnelPort = registerResp.TunnelPort
@@ -883,6 +1316,22 @@ func runTunnelStart(cmd *cobra.Command, args []string) {
 
 	fmt.Println("Starting SSH tunnel...")
 
+	// The daemon normally runs the embedded SSH server
+	if cfg.EmbeddedSSH {
+		serverCfg, err := sshserver.FromClientConfig(cfg)
+		if err != nil {
+			fmt.Printf("Error: Failed to configure embedded SSH server: %v\n", err)
+			os.Exit(1)
+		}
+		server, err := sshserver.Start(context.Background(), serverCfg)
+		if err != nil {
+			fmt.Printf("Error: Failed to start embedded SSH server: %v\n", err)
+			fmt.Println("Is the daemon already running it? Check: roamie tunnel status")
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Embedded SSH server listening on %s\n", server.Addr())
+	}
+
 	// Create tunnel client
 	tunnelClient, err := tunnel.NewClient(cfg)
 	if err != nil {
@@ -927,7 +1376,16 @@ func runTunnelStatus(cmd *cobra.Command, args []string) {
 
 	fmt.Println("SSH Tunnel Status")
 	fmt.Println("=================")
-	fmt.Printf("Local config: tunnel_enabled=%v, tunnel_port=%d\n", cfg.TunnelEnabled, cfg.TunnelPort)
+	transport := cfg.TunnelTransport
+	if transport == "" {
+		transport = tunnel.TransportAuto
+	}
+	fmt.Printf("Local config: tunnel_enabled=%v, tunnel_port=%d, transport=%s\n", cfg.TunnelEnabled, cfg.TunnelPort, transport)
+	if cfg.EmbeddedSSH {
+		fmt.Printf("Local SSH: sshd, or the embedded SSH server at %s\n", sshserver.ListenAddr(cfg.EmbeddedSSHPort))
+	} else {
+		fmt.Println("Local SSH: sshd")
+	}
 
 	if len(status.Tunnels) == 0 {
 		fmt.Println("\nNo tunnels registered on server.")
@@ -940,7 +1398,15 @@ func runTunnelStatus(cmd *cobra.Command, args []string) {
 			fmt.Printf("\nDevice: %s\n", t.DeviceName)
 			fmt.Printf("Port: %d\n", t.Port)
 			fmt.Printf("Server enabled: %v\n", t.Enabled)
-			fmt.Printf("Connected: %v\n", t.Connected)
+			if t.Connected && t.Transport != "" {
+				fmt.Printf("Connected: true (over %s)\n", t.Transport)
+			} else {
+				fmt.Printf("Connected: %v\n", t.Connected)
+			}
+			if len(t.Commands) > 0 {
+				fmt.Printf("Remote commands: %s\n", strings.Join(t.Commands, ", "))
+			}
+			fmt.Printf("Exposure: %s\n", devices.DescribeExposure(t.Exposure))
 
 			if cfg.TunnelEnabled && t.Enabled {
 				fmt.Println("\n✓ Tunnel is enabled (daemon will manage it)")
@@ -1184,6 +1650,21 @@ func runVPNStatus(cmd *cobra.Command, args []string) {
 	fmt.Println("VPN Status")
 	fmt.Println("==========")
 
+	// Userspace mode needs neither WireGuard nor root
+	if cfg.UserspaceEnabled {
+		fmt.Println("VPN Mode: Userspace (no root or kernel module)")
+		if cfg.VpnIP != "" {
+			fmt.Printf("VPN IP: %s\n", cfg.VpnIP)
+			fmt.Printf("Device: %s\n", cfg.DeviceName)
+		}
+		if usCfg, err := userspace.FromClientConfig(cfg); err == nil {
+			fmt.Println("\nEntry points (served by the daemon):")
+			printUserspaceEntryPoints(usCfg)
+		}
+		fmt.Println("\nDisconnect with: roamie disconnect --userspace")
+		return
+	}
+
 	// VPN enabled in config?
 	if cfg.VPNEnabled {
 		fmt.Println("VPN Mode: Enabled")
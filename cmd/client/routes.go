@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/devices"
+	"github.com/kamikazebr/roamie-desktop/internal/client/wireguard"
+	"github.com/spf13/cobra"
+)
+
+var routesAdvertiseExitNode bool
+
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Share local networks or this device's internet connection",
+	Long: `Let a device act as a subnet router or exit node for your other devices.
+
+A subnet router makes LAN ranges behind it reachable over the VPN. An exit
+node carries all internet traffic of the devices that select it. Advertised
+routes only take effect after the server admin approves them.
+
+  roamie routes advertise 192.168.1.0/24     Share a LAN (on the router device)
+  roamie routes advertise --exit-node        Offer this device as an exit node
+  sudo roamie connect --accept-routes        Use approved subnet routes
+  sudo roamie connect --exit home-server     Send internet traffic via a device`,
+	Run: runRoutesList,
+}
+
+var routesListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List routes advertised by your devices",
+	Run:     runRoutesList,
+}
+
+var routesAdvertiseCmd = &cobra.Command{
+	Use:   "advertise [cidr...]",
+	Short: "Advertise LAN ranges (and optionally an exit node) from this device",
+	Long: `Advertise the LAN ranges reachable from this device, replacing any routes
+advertised before. With --exit-node the device also offers itself as an exit
+node. New routes stay pending until the server admin approves them.
+
+Forwarding and NAT are enabled when the VPN is (re)connected with
+'sudo roamie connect' (Linux and macOS).`,
+	Run: runRoutesAdvertise,
+}
+
+var routesClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Stop advertising routes from this device",
+	Run: func(cmd *cobra.Command, args []string) {
+		advertiseRoutes(nil, false)
+	},
+}
+
+func init() {
+	routesAdvertiseCmd.Flags().BoolVar(&routesAdvertiseExitNode, "exit-node", false, "Offer this device as an exit node")
+	routesCmd.AddCommand(routesListCmd, routesAdvertiseCmd, routesClearCmd)
+	rootCmd.AddCommand(routesCmd)
+}
+
+func runRoutesList(cmd *cobra.Command, args []string) {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil {
+		fmt.Println("Error: Not authenticated. Please run 'roamie auth login' first.")
+		os.Exit(1)
+	}
+
+	routes, err := api.NewClient(cfg.ServerURL).ListRoutes(cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: Failed to list routes: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Routes")
+	fmt.Println("======")
+	if len(routes) == 0 {
+		fmt.Println("No routes advertised")
+		fmt.Println("\nAdvertise a LAN with: roamie routes advertise <cidr>")
+	}
+	printRoutes(routes, cfg.DeviceID)
+
+	fmt.Println()
+	if cfg.ExitNodeID != "" {
+		fmt.Printf("Exit node:     %s\n", routeDeviceName(routes, cfg.ExitNodeID))
+	} else {
+		fmt.Println("Exit node:     none")
+	}
+	fmt.Printf("Accept routes: %v\n", cfg.AcceptRoutes)
+}
+
+func runRoutesAdvertise(cmd *cobra.Command, args []string) {
+	if len(args) == 0 && !routesAdvertiseExitNode {
+		fmt.Println("Error: Specify at least one CIDR or --exit-node")
+		fmt.Println("To stop advertising, use: roamie routes clear")
+		os.Exit(1)
+	}
+	advertiseRoutes(args, routesAdvertiseExitNode)
+}
+
+// advertiseRoutes sends this device's routes to the server and saves them so
+// the next connect enables forwarding
+func advertiseRoutes(cidrs []string, exitNode bool) {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil {
+		fmt.Println("Error: Not authenticated. Please run 'roamie auth login' first.")
+		os.Exit(1)
+	}
+
+	routes, err := api.NewClient(cfg.ServerURL).AdvertiseRoutes(cfg.DeviceID, cfg.JWT, cidrs, exitNode)
+	if err != nil {
+		fmt.Printf("Error: Failed to advertise routes: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Save the server's canonical CIDRs so the daemon can compare them later
+	wasForwarding := len(cfg.AdvertiseRoutes) > 0 || cfg.AdvertiseExitNode
+	cfg.AdvertiseRoutes = nil
+	for _, route := range routes {
+		if !route.ExitNode {
+			cfg.AdvertiseRoutes = append(cfg.AdvertiseRoutes, route.CIDR)
+		}
+	}
+	cfg.AdvertiseExitNode = exitNode
+	if err := cfg.Save(); err != nil {
+		fmt.Printf("Error: Failed to save config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(routes) == 0 {
+		fmt.Println("✓ No longer advertising routes")
+	} else {
+		fmt.Printf("✓ Advertising %d route(s):\n", len(routes))
+		printRoutes(routes, cfg.DeviceID)
+	}
+
+	forwarding := len(cidrs) > 0 || exitNode
+	if forwarding && !wireguard.ForwardingSupported() {
+		fmt.Println("\n⚠️  Forwarding is not supported on this platform; other devices won't be able to use these routes")
+		return
+	}
+	if forwarding != wasForwarding && cfg.VPNEnabled {
+		fmt.Println("\n→ Reconnect to apply forwarding settings: sudo roamie connect")
+	}
+	for _, route := range routes {
+		if route.Status == "pending" {
+			fmt.Println("\nPending routes take effect once the server admin approves them.")
+			break
+		}
+	}
+}
+
+func printRoutes(routes []api.Route, selfID string) {
+	for _, route := range routes {
+		name := route.DeviceName
+		if route.DeviceID == selfID {
+			name += " (this device)"
+		}
+		cidr := route.CIDR
+		if route.ExitNode {
+			cidr = "exit node"
+		}
+
+		status := "⏳ pending"
+		switch route.Status {
+		case "approved":
+			status = "✓ approved"
+		case "rejected":
+			status = "✗ rejected"
+		}
+
+		fmt.Printf("  %-20s %-12s %s (%s)\n", cidr, status, name, route.VpnIP)
+	}
+}
+
+// routeDeviceName finds a device name in the route list, falling back to its ID
+func routeDeviceName(routes []api.Route, deviceID string) string {
+	for _, route := range routes {
+		if route.DeviceID == deviceID {
+			return route.DeviceName
+		}
+	}
+	return deviceID
+}
+
+// applyConnectRouteFlags handles connect --exit and --accept-routes. Both
+// are saved so later connects (and auto-connect) keep them.
+func applyConnectRouteFlags(cmd *cobra.Command, cfg *config.Config) {
+	changed := false
+
+	if cmd.Flags().Changed("exit") {
+		apiClient := api.NewClient(cfg.ServerURL)
+		exitNodeID := ""
+		if connectExitNode != "" && connectExitNode != "none" {
+			resp, err := apiClient.ListDevices(cfg.JWT)
+			if err != nil {
+				fmt.Printf("Error: Failed to list devices: %v\n", err)
+				os.Exit(1)
+			}
+			device, err := devices.Resolve(resp.Devices, connectExitNode)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			exitNodeID = device.ID
+		}
+
+		if err := apiClient.SetExitNode(cfg.DeviceID, cfg.JWT, exitNodeID); err != nil {
+			fmt.Printf("Error: Failed to set exit node: %v\n", err)
+			fmt.Println("List exit nodes with: roamie routes")
+			os.Exit(1)
+		}
+		cfg.ExitNodeID = exitNodeID
+		changed = true
+	}
+
+	if cmd.Flags().Changed("accept-routes") {
+		cfg.AcceptRoutes = connectAcceptRoutes
+		changed = true
+	}
+
+	if changed {
+		if err := cfg.Save(); err != nil {
+			fmt.Printf("Error: Failed to save config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/secrets"
+	"github.com/kamikazebr/roamie-desktop/internal/client/storage"
+	"github.com/kamikazebr/roamie-desktop/internal/client/tunnel"
+	"github.com/spf13/cobra"
+)
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Show where device secrets are stored",
+	Long: `Device secrets (WireGuard private key, session tokens, tunnel SSH key) are
+kept in the OS keyring when one is available (Secret Service on Linux) and
+otherwise in files under ~/.roamie/secrets encrypted with a key derived from
+the machine ID, or from ROAMIE_SECRETS_PASSPHRASE if it is set when the
+files are first created.
+
+Set ROAMIE_SECRETS_BACKEND to keyring, file or plaintext to choose the
+backend, then run 'roamie auth secrets migrate' to move existing secrets.`,
+	Run: runSecretsStatus,
+}
+
+var secretsMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move existing secrets into the configured secrets backend",
+	Run:   runSecretsMigrate,
+}
+
+func init() {
+	secretsCmd.AddCommand(secretsMigrateCmd)
+	authCmd.AddCommand(secretsCmd)
+}
+
+func openSecretsStore() *secrets.Store {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	store, err := secrets.Open(configDir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+func runSecretsStatus(cmd *cobra.Command, args []string) {
+	store := openSecretsStore()
+	fmt.Printf("Backend: %s\n", store.Backend())
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Error: Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg == nil {
+		fmt.Println("\nNot logged in. Run: roamie auth login")
+		return
+	}
+
+	names := make([]string, 0, len(cfg.SecretRefs))
+	for name := range cfg.SecretRefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println()
+	for _, name := range names {
+		fmt.Printf("  %-15s %s\n", name, cfg.SecretRefs[name])
+	}
+	if store.Enabled() {
+		if _, ref, err := store.Lookup(tunnel.TunnelKeyFile); err == nil {
+			fmt.Printf("  %-15s %s\n", tunnel.TunnelKeyFile, ref)
+		}
+	}
+
+	if plaintext, _ := config.HasPlaintextSecrets(); plaintext {
+		fmt.Printf("\n⚠️  %s holds secrets in plaintext\n", config.ConfigFile)
+		if store.Enabled() {
+			fmt.Println("Move them with: roamie auth secrets migrate")
+		}
+	}
+}
+
+func runSecretsMigrate(cmd *cobra.Command, args []string) {
+	store := openSecretsStore()
+
+	if err := config.MoveSecrets(); err != nil {
+		fmt.Printf("Error: Failed to move config secrets: %v\n", err)
+		os.Exit(1)
+	}
+
+	if store.Enabled() {
+		if _, err := tunnel.MigrateKeyFile(); err != nil {
+			fmt.Printf("Error: Failed to move tunnel key: %v\n", err)
+			os.Exit(1)
+		}
+		if err := storage.MigrateCredentials(); err != nil {
+			fmt.Printf("Error: Failed to move stored credentials: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("✓ Secrets stored in %s\n", store.Backend())
+}
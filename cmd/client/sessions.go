@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/spf13/cobra"
+)
+
+var revokeOthers bool
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List the devices and logins with access to your account",
+	Long: `List your active logins. Each login keeps working until it is revoked or
+its refresh token expires unused.
+
+Revoke a login with 'roamie auth revoke <session>'.`,
+	Run: runSessions,
+}
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke [session]",
+	Short: "Sign out a login, or every login but this one with --others",
+	Long: `Sign out a login. Its refresh token stops working immediately and access
+tokens already issued to it expire within minutes.
+
+Sessions can be referenced by a unique prefix of their ID (see 'roamie auth sessions').`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runRevoke,
+}
+
+func init() {
+	revokeCmd.Flags().BoolVar(&revokeOthers, "others", false, "Sign out everywhere except this login")
+	authCmd.AddCommand(sessionsCmd, revokeCmd)
+}
+
+// loadSessions loads config and fetches the session list, exiting on failure
+func loadSessions() (*config.Config, *api.Client, []api.Session) {
+	cfg, err := config.Load()
+	if err != nil || cfg == nil {
+		fmt.Println("Error: Not authenticated. Please run 'roamie auth login' first.")
+		os.Exit(1)
+	}
+
+	apiClient := api.NewClient(cfg.ServerURL)
+	sessions, err := apiClient.ListSessions(cfg.JWT)
+	if err != nil {
+		fmt.Printf("Error: Failed to list sessions: %v\n", err)
+		os.Exit(1)
+	}
+
+	return cfg, apiClient, sessions
+}
+
+func runSessions(cmd *cobra.Command, args []string) {
+	_, _, sessions := loadSessions()
+
+	fmt.Println("Sessions")
+	fmt.Println("========")
+
+	if len(sessions) == 0 {
+		fmt.Println("(no active sessions)")
+		return
+	}
+
+	fmt.Printf("  %-10s  %-28s  %-10s  %-10s  %s\n", "ID", "DEVICE", "STARTED", "LAST USED", "LAST IP")
+	for _, s := range sessions {
+		id := shortID(s.ID)
+		if s.Current {
+			id += " *"
+		}
+
+		device := s.DeviceName
+		if device == "" {
+			device = shortID(s.DeviceID)
+		}
+
+		lastUsed := "never"
+		if s.LastUsedAt != nil {
+			lastUsed = formatLastSeen(parseTimestamp(*s.LastUsedAt))
+		}
+
+		lastIP := s.LastUsedIP
+		if lastIP == "" {
+			lastIP = "-"
+		}
+
+		fmt.Printf("  %-10s  %-28s  %-10s  %-10s  %s\n", id, device, formatLastSeen(parseTimestamp(s.CreatedAt)), lastUsed, lastIP)
+	}
+
+	fmt.Println("\n* this login")
+}
+
+func runRevoke(cmd *cobra.Command, args []string) {
+	if revokeOthers == (len(args) == 1) {
+		fmt.Println("Error: Pass either a session ID or --others")
+		os.Exit(1)
+	}
+
+	cfg, apiClient, sessions := loadSessions()
+
+	if revokeOthers {
+		revoked, err := apiClient.RevokeSession("", cfg.JWT)
+		if err != nil {
+			fmt.Printf("Error: Failed to revoke sessions: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Signed out %d other session(s)\n", revoked)
+		return
+	}
+
+	session, err := resolveSession(sessions, args[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if session.Current {
+		fmt.Println("⚠️  This is the login you are using; this device will need 'roamie auth login' again.")
+	}
+
+	if _, err := apiClient.RevokeSession(session.ID, cfg.JWT); err != nil {
+		fmt.Printf("Error: Failed to revoke session: %v\n", err)
+		os.Exit(1)
+	}
+
+	name := session.DeviceName
+	if name == "" {
+		name = shortID(session.DeviceID)
+	}
+	fmt.Printf("✓ Signed out session %s (%s)\n", shortID(session.ID), name)
+}
+
+// resolveSession finds the session whose ID equals or uniquely starts with query
+func resolveSession(sessions []api.Session, query string) (*api.Session, error) {
+	query = strings.ToLower(query)
+
+	var match *api.Session
+	for i := range sessions {
+		if sessions[i].ID == query {
+			return &sessions[i], nil
+		}
+		if strings.HasPrefix(sessions[i].ID, query) {
+			if match != nil {
+				return nil, fmt.Errorf("session %q is ambiguous, use more characters", query)
+			}
+			match = &sessions[i]
+		}
+	}
+
+	if match == nil {
+		return nil, fmt.Errorf("no session matches %q (see 'roamie auth sessions')", query)
+	}
+	return match, nil
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// parseTimestamp parses a server timestamp; zero if malformed
+func parseTimestamp(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/ssh"
+	"github.com/kamikazebr/roamie-desktop/internal/client/sshd"
+	"github.com/spf13/cobra"
+)
+
+var sshTrustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Trust the Roamie SSH CA in this machine's sshd",
+	Long: `Configures sshd to accept short-lived user certificates from the Roamie SSH
+CA (TrustedUserCAKeys) and to present a host certificate signed by it, so
+'roamie ssh' can log in without syncing keys and without host key prompts.
+Certificates are issued per login: removing a device or account stops new
+logins immediately.
+
+The settings go to /etc/ssh/sshd_config.d/roamie.conf. The daemon renews the
+host certificate when it runs as root; otherwise run this again before it
+expires (roamie doctor warns).`,
+	Run: runSSHTrust,
+}
+
+func init() {
+	sshCmd.AddCommand(sshTrustCmd)
+}
+
+func runSSHTrust(cmd *cobra.Command, args []string) {
+	if os.Geteuid() != 0 {
+		fmt.Println("Error: Changing sshd settings requires root, run: sudo roamie ssh trust")
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load()
+	if err != nil || cfg == nil {
+		fmt.Println("Error: Not authenticated. Please run 'roamie auth login' first.")
+		os.Exit(1)
+	}
+
+	sshManager, err := ssh.NewManager(cfg.ServerURL)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	trust := sshd.DefaultTrust()
+	changed, err := sshManager.SyncSSHDTrust(cfg, trust)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		if errors.Is(err, sshd.ErrNoConfigInclude) {
+			fmt.Println("  Then run 'sudo roamie ssh trust' again")
+		}
+		os.Exit(1)
+	}
+	if changed {
+		fmt.Println("✓ sshd now accepts Roamie SSH certificates")
+	} else {
+		fmt.Println("✓ sshd already trusts the Roamie SSH CA")
+	}
+
+	if cert, err := trust.HostCertificate(); err == nil && cert != nil {
+		fmt.Printf("  Host certificate: %s (valid until %s)\n",
+			strings.Join(cert.ValidPrincipals, ", "), time.Unix(int64(cert.ValidBefore), 0).Format("2006-01-02"))
+	} else {
+		fmt.Println("  No host certificate: clients verify this machine by its host key as before")
+	}
+
+	if _, err := sshManager.SyncKnownHosts(cfg.JWT); err != nil {
+		fmt.Printf("Warning: failed to trust host certificates in known_hosts: %v\n", err)
+	} else {
+		fmt.Println("✓ known_hosts trusts host certificates of your devices")
+	}
+}
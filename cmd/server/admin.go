@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"log"
@@ -10,11 +11,13 @@ import (
 
 	"github.com/kamikazebr/roamie-desktop/internal/server/services"
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/kamikazebr/roamie-desktop/internal/server/tunnel"
 	"github.com/kamikazebr/roamie-desktop/internal/server/wireguard"
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 )
 
 var adminCmd = &cobra.Command{
@@ -65,6 +68,122 @@ var approveDeviceCmd = &cobra.Command{
 	Run:   runApproveDeviceCommand,
 }
 
+var denyDeviceCmd = &cobra.Command{
+	Use:   "deny-device",
+	Short: "Deny a pending device challenge",
+	Run:   runDenyDeviceCommand,
+}
+
+var listRoutesCmd = &cobra.Command{
+	Use:   "list-routes",
+	Short: "List subnet routes and exit nodes waiting for approval",
+	Run:   runListRoutesCommand,
+}
+
+var approveRouteCmd = &cobra.Command{
+	Use:   "approve-route",
+	Short: "Approve an advertised subnet route or exit node",
+	Run:   runReviewRouteCommand,
+}
+
+var rejectRouteCmd = &cobra.Command{
+	Use:   "reject-route",
+	Short: "Reject (or revoke) an advertised subnet route or exit node",
+	Run:   runReviewRouteCommand,
+}
+
+var listJWTKeysCmd = &cobra.Command{
+	Use:   "list-jwt-keys",
+	Short: "List JWT signing keys (current and retired keys still verifying)",
+	Run:   runListJWTKeysCommand,
+}
+
+var rotateJWTKeyCmd = &cobra.Command{
+	Use:   "rotate-jwt-key",
+	Short: "Create a new JWT signing key and retire the current one",
+	Long: `Creates a new Ed25519 signing key for access tokens. The current key keeps
+verifying tokens for JWT_KEY_OVERLAP (default 30 days), so nobody is logged out.
+A running server picks up the new key within a minute.`,
+	Run: runRotateJWTKeyCommand,
+}
+
+var listTunnelHostKeysCmd = &cobra.Command{
+	Use:   "list-tunnel-host-keys",
+	Short: "List the SSH tunnel server's host keys and their fingerprints",
+	Run:   runListTunnelHostKeysCommand,
+}
+
+var prepareTunnelHostKeyCmd = &cobra.Command{
+	Use:   "prepare-tunnel-host-key",
+	Short: "Announce a new SSH tunnel host key (step 1 of a rotation)",
+	Long: `Generates a new Ed25519 host key for the SSH tunnel server. It is published to
+clients, which pin it next to the current key, but not used yet. Wait until
+clients have synced (they refresh their pins every time they connect), then
+run promote-tunnel-host-key.`,
+	Run: runPrepareTunnelHostKeyCommand,
+}
+
+var promoteTunnelHostKeyCmd = &cobra.Command{
+	Use:   "promote-tunnel-host-key",
+	Short: "Switch the SSH tunnel server to the announced host key (step 2)",
+	Long: `Makes the announced host key current. The old key stays published, and is
+still served if its algorithm differs from the new one, until
+retire-tunnel-host-key. A running server switches within a minute.`,
+	Run: runPromoteTunnelHostKeyCommand,
+}
+
+var retireTunnelHostKeyCmd = &cobra.Command{
+	Use:   "retire-tunnel-host-key",
+	Short: "Stop publishing the previous SSH tunnel host key (step 3)",
+	Run:   runRetireTunnelHostKeyCommand,
+}
+
+var showSSHCACmd = &cobra.Command{
+	Use:   "show-ssh-ca",
+	Short: "Show the SSH certificate authority public keys",
+	Long: `Shows the public keys of the SSH CA. Devices trust the user CA in sshd
+(TrustedUserCAKeys) and the host CA in known_hosts (@cert-authority); 'sudo
+roamie ssh trust' installs both. The server creates the CA on first start.`,
+	Run: runShowSSHCACommand,
+}
+
+var setPasswordCmd = &cobra.Command{
+	Use:   "set-password",
+	Short: "Create a local account or change its password",
+	Long: `Sets the password of a local account, creating the user (with a subnet) if
+needed. The password is read from stdin. Users log in with 'roamie auth login
+--method password' when the server runs with LOCAL_ACCOUNTS=true.`,
+	Run: runSetPasswordCommand,
+}
+
+var clearPasswordCmd = &cobra.Command{
+	Use:   "clear-password",
+	Short: "Disable password login for a user",
+	Run:   runClearPasswordCommand,
+}
+
+var createEnrollmentKeyCmd = &cobra.Command{
+	Use:   "create-enrollment-key",
+	Short: "Create a key that enrolls devices without interactive approval",
+	Long: `Creates an enrollment key for a user. Devices run 'roamie auth login
+--enroll-key <key>' to join the user's network without a QR code. Keys are
+single-use unless --reusable or --uses is given, and expire after --expires.
+The key is only shown once.`,
+	Run: runCreateEnrollmentKeyCommand,
+}
+
+var listEnrollmentKeysCmd = &cobra.Command{
+	Use:   "list-enrollment-keys",
+	Short: "List enrollment keys that haven't been revoked",
+	Run:   runListEnrollmentKeysCommand,
+}
+
+var revokeEnrollmentKeyCmd = &cobra.Command{
+	Use:   "revoke-enrollment-key",
+	Short: "Stop an enrollment key from enrolling more devices",
+	Run:   runRevokeEnrollmentKeyCommand,
+}
+
 var validateKeyDecryptionCmd = &cobra.Command{
 	Use:   "validate-key-decryption",
 	Short: "Validate encrypted SSH keys can be decrypted from Firestore",
@@ -100,6 +219,31 @@ func init() {
 	approveDeviceCmd.Flags().String("email", "", "User email to associate with device (required)")
 	approveDeviceCmd.MarkFlagRequired("challenge-id")
 	approveDeviceCmd.MarkFlagRequired("email")
+	denyDeviceCmd.Flags().String("challenge-id", "", "Challenge ID to deny (required)")
+	denyDeviceCmd.MarkFlagRequired("challenge-id")
+
+	approveRouteCmd.Flags().String("route-id", "", "Route ID to approve (required)")
+	approveRouteCmd.MarkFlagRequired("route-id")
+	rejectRouteCmd.Flags().String("route-id", "", "Route ID to reject (required)")
+	rejectRouteCmd.MarkFlagRequired("route-id")
+
+	setPasswordCmd.Flags().String("email", "", "User email (required)")
+	setPasswordCmd.MarkFlagRequired("email")
+	clearPasswordCmd.Flags().String("email", "", "User email (required)")
+	clearPasswordCmd.MarkFlagRequired("email")
+
+	createEnrollmentKeyCmd.Flags().String("email", "", "User whose network devices join (required)")
+	createEnrollmentKeyCmd.Flags().String("description", "", "What the key is for")
+	createEnrollmentKeyCmd.Flags().Bool("reusable", false, "Allow unlimited enrollments until the key expires")
+	createEnrollmentKeyCmd.Flags().Int("uses", 1, "Number of devices the key can enroll")
+	createEnrollmentKeyCmd.Flags().Duration("expires", services.DefaultEnrollmentKeyTTL, "How long the key stays valid")
+	createEnrollmentKeyCmd.Flags().StringSlice("tag", nil, "Tag for enrolled devices (repeatable)")
+	createEnrollmentKeyCmd.Flags().Bool("tunnel", false, "Enrolled devices register an SSH tunnel")
+	createEnrollmentKeyCmd.Flags().Bool("ephemeral", false, "Delete enrolled devices once they go offline (EPHEMERAL_DEVICE_TIMEOUT)")
+	createEnrollmentKeyCmd.MarkFlagRequired("email")
+	listEnrollmentKeysCmd.Flags().String("email", "", "Only list this user's keys")
+	revokeEnrollmentKeyCmd.Flags().String("id", "", "Enrollment key ID (required)")
+	revokeEnrollmentKeyCmd.MarkFlagRequired("id")
 
 	validateKeyDecryptionCmd.Flags().String("email", "", "User email (required)")
 	validateKeyDecryptionCmd.Flags().String("password", "", "User's encryption password (required)")
@@ -118,6 +262,22 @@ func init() {
 		syncPeersCmd,
 		listChallengesCmd,
 		approveDeviceCmd,
+		denyDeviceCmd,
+		listRoutesCmd,
+		approveRouteCmd,
+		rejectRouteCmd,
+		listJWTKeysCmd,
+		rotateJWTKeyCmd,
+		listTunnelHostKeysCmd,
+		prepareTunnelHostKeyCmd,
+		promoteTunnelHostKeyCmd,
+		retireTunnelHostKeyCmd,
+		showSSHCACmd,
+		setPasswordCmd,
+		clearPasswordCmd,
+		createEnrollmentKeyCmd,
+		listEnrollmentKeysCmd,
+		revokeEnrollmentKeyCmd,
 		validateKeyDecryptionCmd,
 		listFirestoreDataCmd,
 	)
@@ -529,8 +689,9 @@ func runListChallengesCommand(cmd *cobra.Command, args []string) {
 	}
 	defer db.Close()
 
-	// Initialize repository
+	// Initialize repositories
 	deviceAuthRepo := storage.NewDeviceAuthRepository(db)
+	userRepo := storage.NewUserRepository(db)
 
 	ctx := context.Background()
 
@@ -546,19 +707,68 @@ func runListChallengesCommand(cmd *cobra.Command, args []string) {
 	}
 
 	fmt.Printf("Pending Device Authorization Challenges (%d):\n", len(challenges))
-	fmt.Println(strings.Repeat("=", 110))
-	fmt.Printf("%-36s %-20s %-30s %-20s\n", "Challenge ID", "Device ID", "Hostname", "Created At")
-	fmt.Println(strings.Repeat("=", 110))
+	fmt.Println(strings.Repeat("=", 150))
+	fmt.Printf("%-36s %-24s %-8s %-16s %-30s %-9s %-20s\n", "Challenge ID", "Hostname", "OS", "IP Address", "Requested User", "Approvals", "Created At")
+	fmt.Println(strings.Repeat("=", 150))
 
 	for _, challenge := range challenges {
-		fmt.Printf("%-36s %-20s %-30s %-20s\n",
+		osType := "-"
+		if challenge.OSType != nil {
+			osType = *challenge.OSType
+		}
+
+		requestedUser := "-"
+		if challenge.RequestedUserID != nil {
+			if user, err := userRepo.GetByID(ctx, *challenge.RequestedUserID); err == nil && user != nil {
+				requestedUser = user.Email
+			}
+		}
+
+		approvals := "-"
+		if challenge.RequiredApprovals > 1 {
+			approvers, _ := deviceAuthRepo.ListChallengeApprovers(ctx, challenge.ID)
+			approvals = fmt.Sprintf("%d/%d", len(approvers), challenge.RequiredApprovals)
+		}
+
+		fmt.Printf("%-36s %-24s %-8s %-16s %-30s %-9s %-20s\n",
 			challenge.ID,
-			challenge.DeviceID,
 			challenge.Hostname,
+			osType,
+			challenge.IPAddress,
+			requestedUser,
+			approvals,
 			challenge.CreatedAt.Format("2006-01-02 15:04:05"),
 		)
 	}
-	fmt.Println(strings.Repeat("=", 110))
+	fmt.Println(strings.Repeat("=", 150))
+}
+
+func runDenyDeviceCommand(cmd *cobra.Command, args []string) {
+	challengeID, _ := cmd.Flags().GetString("challenge-id")
+
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	challengeUUID, err := uuid.Parse(challengeID)
+	if err != nil {
+		log.Fatalf("Invalid challenge ID: %v", err)
+	}
+
+	deviceAuthRepo := storage.NewDeviceAuthRepository(db)
+	if err := deviceAuthRepo.UpdateChallengeStatus(context.Background(), challengeUUID, "denied", nil); err != nil {
+		log.Fatalf("Failed to deny challenge: %v", err)
+	}
+
+	fmt.Printf("✓ Challenge %s denied\n", challengeUUID)
 }
 
 func runApproveDeviceCommand(cmd *cobra.Command, args []string) {
@@ -715,6 +925,502 @@ func runApproveDeviceCommand(cmd *cobra.Command, args []string) {
 	fmt.Println("Client can now poll and receive JWT token")
 }
 
+// newAdminSigningKeyService loads the JWT signing keys from the database
+func newAdminSigningKeyService(db *storage.DB) *services.SigningKeyService {
+	keyEncryption, err := services.KeyEncryptionFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load key encryption key: %v", err)
+	}
+	signingKeyService := services.NewSigningKeyService(storage.NewSigningKeyRepository(db))
+	signingKeyService.SetEncryption(keyEncryption)
+	if err := signingKeyService.Reload(context.Background()); err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	return signingKeyService
+}
+
+func runListJWTKeysCommand(cmd *cobra.Command, args []string) {
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	keys := newAdminSigningKeyService(db).Keys()
+	if len(keys) == 0 {
+		fmt.Println("No signing keys yet (the server creates one on startup)")
+		return
+	}
+
+	fmt.Printf("JWT Signing Keys (%d):\n", len(keys))
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Printf("%-18s %-8s %-20s %-20s\n", "Key ID", "Alg", "Created", "Status")
+	fmt.Println(strings.Repeat("=", 80))
+
+	for _, key := range keys {
+		status := "signing"
+		if !key.IsCurrent() {
+			status = "verifies until " + key.RetiresAt.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-18s %-8s %-20s %s\n", key.KID, key.Algorithm, key.CreatedAt.Format("2006-01-02 15:04"), status)
+	}
+}
+
+func runRotateJWTKeyCommand(cmd *cobra.Command, args []string) {
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	key, err := newAdminSigningKeyService(db).Rotate(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to rotate signing key: %v", err)
+	}
+
+	fmt.Printf("✓ New signing key: %s\n", key.KID)
+	fmt.Println("  Previous keys keep verifying existing tokens until they retire")
+	fmt.Println("  A running server switches to the new key within a minute")
+}
+
+func runListTunnelHostKeysCommand(cmd *cobra.Command, args []string) {
+	hostKeys, err := tunnel.LoadHostKeys(tunnel.ServerConfigPath)
+	if err != nil {
+		log.Fatalf("Failed to load host keys: %v", err)
+	}
+
+	keys := hostKeys.Published()
+	fmt.Printf("SSH Tunnel Host Keys (%d):\n", len(keys))
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Printf("%-10s %-20s %s\n", "Status", "Algorithm", "Fingerprint")
+	fmt.Println(strings.Repeat("=", 80))
+
+	for _, key := range keys {
+		fmt.Printf("%-10s %-20s %s\n", key.Status, key.Algorithm, key.Fingerprint)
+	}
+}
+
+func runPrepareTunnelHostKeyCommand(cmd *cobra.Command, args []string) {
+	signer, err := tunnel.PrepareHostKey(tunnel.ServerConfigPath)
+	if err != nil {
+		log.Fatalf("Failed to prepare host key: %v", err)
+	}
+
+	fmt.Printf("✓ Announced host key: %s\n", ssh.FingerprintSHA256(signer.PublicKey()))
+	fmt.Println("  Clients pin it the next time they connect")
+	fmt.Println("  Once they have, switch to it with: roamie-server admin promote-tunnel-host-key")
+}
+
+func runPromoteTunnelHostKeyCommand(cmd *cobra.Command, args []string) {
+	if err := tunnel.PromoteHostKey(tunnel.ServerConfigPath); err != nil {
+		log.Fatalf("Failed to promote host key: %v", err)
+	}
+
+	fmt.Println("✓ Announced host key is now current")
+	fmt.Println("  A running server switches to it within a minute")
+	fmt.Println("  Stop publishing the old key with: roamie-server admin retire-tunnel-host-key")
+}
+
+func runRetireTunnelHostKeyCommand(cmd *cobra.Command, args []string) {
+	if err := tunnel.RetireHostKey(tunnel.ServerConfigPath); err != nil {
+		log.Fatalf("Failed to retire host key: %v", err)
+	}
+
+	fmt.Println("✓ Previous host key retired")
+	fmt.Println("  Clients drop it from their pins the next time they sync")
+}
+
+func runShowSSHCACommand(cmd *cobra.Command, args []string) {
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	repo := storage.NewSSHCARepository(db)
+	for _, kind := range []string{models.SSHCAKindUser, models.SSHCAKindHost} {
+		key, err := repo.Get(context.Background(), kind)
+		if err != nil {
+			log.Fatalf("Failed to load SSH %s CA: %v", kind, err)
+		}
+		if key == nil {
+			fmt.Printf("No SSH %s CA yet (the server creates it on startup)\n", kind)
+			continue
+		}
+
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+		if err != nil {
+			log.Fatalf("Invalid SSH %s CA public key: %v", kind, err)
+		}
+		fmt.Printf("SSH %s CA (created %s):\n", kind, key.CreatedAt.Format("2006-01-02 15:04"))
+		fmt.Println(strings.Repeat("=", 80))
+		fmt.Printf("Fingerprint: %s\n", ssh.FingerprintSHA256(publicKey))
+		fmt.Println(key.PublicKey)
+		fmt.Println()
+	}
+}
+
+func runSetPasswordCommand(cmd *cobra.Command, args []string) {
+	email, _ := cmd.Flags().GetString("email")
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	userRepo := storage.NewUserRepository(db)
+	subnetPool, err := services.NewSubnetPool(userRepo, storage.NewConflictRepository(db))
+	if err != nil {
+		log.Fatalf("Failed to initialize subnet pool: %v", err)
+	}
+	authService := services.NewAuthService(storage.NewAuthRepository(db), userRepo, nil, subnetPool)
+	localAccounts := services.NewLocalAccountProvider(userRepo)
+
+	fmt.Printf("Password for %s: ", email)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Fatalf("Failed to read password: %v", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) < services.MinPasswordLength {
+		log.Fatalf("Password must be at least %d characters", services.MinPasswordLength)
+	}
+
+	ctx := context.Background()
+	user, err := authService.GetOrCreateUserByEmail(ctx, email)
+	if err != nil {
+		log.Fatalf("Failed to get or create user: %v", err)
+	}
+
+	if err := localAccounts.SetPassword(ctx, user.ID, password); err != nil {
+		log.Fatalf("Failed to set password: %v", err)
+	}
+
+	fmt.Printf("✓ Password set for %s (subnet: %s)\n", user.Email, user.Subnet)
+	if os.Getenv("LOCAL_ACCOUNTS") != "true" {
+		fmt.Println("⚠️  Password login is disabled; set LOCAL_ACCOUNTS=true and restart the server")
+	}
+}
+
+func runClearPasswordCommand(cmd *cobra.Command, args []string) {
+	email, _ := cmd.Flags().GetString("email")
+
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	userRepo := storage.NewUserRepository(db)
+	user, err := userRepo.GetByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		log.Fatalf("Failed to find user: %v", err)
+	}
+	if user == nil {
+		log.Fatalf("User not found: %s", email)
+	}
+
+	if err := services.NewLocalAccountProvider(userRepo).ClearPassword(ctx, user.ID); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fmt.Printf("✓ Password login disabled for %s\n", user.Email)
+}
+
+// newAdminDeviceAuthService builds a device auth service with enrollment keys enabled
+func newAdminDeviceAuthService(db *storage.DB) *services.DeviceAuthService {
+	deviceAuthService := services.NewDeviceAuthService(storage.NewDeviceAuthRepository(db), storage.NewUserRepository(db))
+	deviceAuthService.SetEnrollmentKeys(storage.NewEnrollmentKeyRepository(db))
+	return deviceAuthService
+}
+
+func runCreateEnrollmentKeyCommand(cmd *cobra.Command, args []string) {
+	email, _ := cmd.Flags().GetString("email")
+	description, _ := cmd.Flags().GetString("description")
+	reusable, _ := cmd.Flags().GetBool("reusable")
+	uses, _ := cmd.Flags().GetInt("uses")
+	expires, _ := cmd.Flags().GetDuration("expires")
+	tags, _ := cmd.Flags().GetStringSlice("tag")
+	tunnel, _ := cmd.Flags().GetBool("tunnel")
+	ephemeral, _ := cmd.Flags().GetBool("ephemeral")
+
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	user, err := storage.NewUserRepository(db).GetByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		log.Fatalf("Failed to find user: %v", err)
+	}
+	if user == nil {
+		log.Fatalf("User not found: %s", email)
+	}
+
+	opts := services.EnrollmentKeyOptions{
+		Description: description,
+		TTL:         expires,
+		Tags:        tags,
+		Tunnel:      tunnel,
+		Ephemeral:   ephemeral,
+	}
+	if !reusable {
+		opts.MaxUses = &uses
+	}
+
+	key, secret, err := newAdminDeviceAuthService(db).CreateEnrollmentKey(ctx, user.ID, opts)
+	if err != nil {
+		log.Fatalf("Failed to create enrollment key: %v", err)
+	}
+
+	fmt.Printf("✓ Enrollment key created for %s (ID: %s)\n", user.Email, key.ID)
+	fmt.Printf("  Expires: %s\n", key.ExpiresAt.Format("2006-01-02 15:04"))
+	fmt.Println()
+	fmt.Printf("  %s\n", secret)
+	fmt.Println()
+	fmt.Println("  This key is only shown once. Enroll a device with:")
+	fmt.Println("  roamie auth login --enroll-key <key>")
+}
+
+func runListEnrollmentKeysCommand(cmd *cobra.Command, args []string) {
+	email, _ := cmd.Flags().GetString("email")
+
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	userRepo := storage.NewUserRepository(db)
+	keyRepo := storage.NewEnrollmentKeyRepository(db)
+
+	var keys []models.EnrollmentKey
+	if email != "" {
+		user, err := userRepo.GetByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+		if err != nil {
+			log.Fatalf("Failed to find user: %v", err)
+		}
+		if user == nil {
+			log.Fatalf("User not found: %s", email)
+		}
+		keys, err = keyRepo.ListByUser(ctx, user.ID)
+		if err != nil {
+			log.Fatalf("Failed to list enrollment keys: %v", err)
+		}
+	} else {
+		keys, err = keyRepo.ListAll(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list enrollment keys: %v", err)
+		}
+	}
+
+	if len(keys) == 0 {
+		fmt.Println("No enrollment keys")
+		return
+	}
+
+	fmt.Printf("Enrollment Keys (%d):\n", len(keys))
+	fmt.Println(strings.Repeat("=", 120))
+	fmt.Printf("%-36s %-18s %-8s %-8s %-17s %-6s %-9s %s\n", "Key ID", "Prefix", "Status", "Uses", "Expires", "Tunnel", "Ephemeral", "Tags")
+	fmt.Println(strings.Repeat("=", 120))
+
+	for _, key := range keys {
+		uses := fmt.Sprintf("%d/∞", key.Uses)
+		if key.MaxUses != nil {
+			uses = fmt.Sprintf("%d/%d", key.Uses, *key.MaxUses)
+		}
+		fmt.Printf("%-36s %-18s %-8s %-8s %-17s %-6v %-9v %s\n",
+			key.ID,
+			key.KeyPrefix+"…",
+			key.Status(),
+			uses,
+			key.ExpiresAt.Format("2006-01-02 15:04"),
+			key.Tunnel,
+			key.Ephemeral,
+			strings.Join(key.Tags, ","),
+		)
+	}
+}
+
+func runRevokeEnrollmentKeyCommand(cmd *cobra.Command, args []string) {
+	keyID, _ := cmd.Flags().GetString("id")
+
+	keyUUID, err := uuid.Parse(keyID)
+	if err != nil {
+		log.Fatalf("Invalid key ID: %v", err)
+	}
+
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	if err := newAdminDeviceAuthService(db).RevokeEnrollmentKey(context.Background(), keyUUID, nil); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fmt.Printf("✓ Enrollment key %s revoked (devices it enrolled keep working)\n", keyID)
+}
+
+// newAdminRouteService builds a route service that programs the local WireGuard interface
+func newAdminRouteService(db *storage.DB) (*services.RouteService, *wireguard.Manager) {
+	userRepo := storage.NewUserRepository(db)
+	deviceRepo := storage.NewDeviceRepository(db)
+	deviceAuthRepo := storage.NewDeviceAuthRepository(db)
+	conflictRepo := storage.NewConflictRepository(db)
+
+	subnetPool, err := services.NewSubnetPool(userRepo, conflictRepo)
+	if err != nil {
+		log.Fatalf("Failed to initialize subnet pool: %v", err)
+	}
+	deviceService := services.NewDeviceService(deviceRepo, userRepo, subnetPool, deviceAuthRepo)
+
+	wgManager, err := wireguard.NewManager()
+	if err != nil {
+		log.Fatalf("Failed to initialize WireGuard manager: %v", err)
+	}
+
+	routeService := services.NewRouteService(storage.NewRouteRepository(db), deviceRepo, deviceService, subnetPool.Networks(), wgManager)
+	return routeService, wgManager
+}
+
+func runListRoutesCommand(cmd *cobra.Command, args []string) {
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	routeService, wgManager := newAdminRouteService(db)
+	defer wgManager.Close()
+
+	routes, err := routeService.ListPending(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to get routes: %v", err)
+	}
+
+	if len(routes) == 0 {
+		fmt.Println("No routes waiting for approval")
+		return
+	}
+
+	fmt.Printf("Pending Routes (%d):\n", len(routes))
+	fmt.Println(strings.Repeat("=", 110))
+	fmt.Printf("%-36s %-30s %-16s %-20s\n", "Route ID", "Device", "VPN IP", "Route")
+	fmt.Println(strings.Repeat("=", 110))
+
+	for _, route := range routes {
+		cidr := route.CIDR
+		if route.ExitNode {
+			cidr = "exit node"
+		}
+		fmt.Printf("%-36s %-30s %-16s %-20s\n",
+			route.ID,
+			truncateString(route.DeviceName, 30),
+			route.VpnIP,
+			cidr,
+		)
+	}
+	fmt.Println(strings.Repeat("=", 110))
+}
+
+func runReviewRouteCommand(cmd *cobra.Command, args []string) {
+	routeIDStr, _ := cmd.Flags().GetString("route-id")
+	routeID, err := uuid.Parse(routeIDStr)
+	if err != nil {
+		log.Fatalf("Invalid route ID: %v", err)
+	}
+
+	// Load environment
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found, using environment variables")
+	}
+
+	// Initialize database
+	db, err := storage.NewPostgresDB()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	routeService, wgManager := newAdminRouteService(db)
+	defer wgManager.Close()
+
+	ctx := context.Background()
+	var route *models.RouteInfo
+	if cmd.Name() == "approve-route" {
+		route, err = routeService.Approve(ctx, routeID)
+	} else {
+		route, err = routeService.Reject(ctx, routeID)
+	}
+	if err != nil {
+		log.Fatalf("Failed to review route: %v", err)
+	}
+
+	fmt.Printf("✓ Route %s via %s (%s) is now %s\n", route.CIDR, route.DeviceName, route.VpnIP, route.Status)
+}
+
 func runValidateKeyDecryptionCommand(cmd *cobra.Command, args []string) {
 	// Get flags
 	email, _ := cmd.Flags().GetString("email")
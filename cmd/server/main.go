@@ -18,7 +18,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/kamikazebr/roamie-desktop/internal/server/activation"
 	"github.com/kamikazebr/roamie-desktop/internal/server/api"
+	"github.com/kamikazebr/roamie-desktop/internal/server/dns"
+	"github.com/kamikazebr/roamie-desktop/internal/server/ratelimit"
 	"github.com/kamikazebr/roamie-desktop/internal/server/services"
 	"github.com/kamikazebr/roamie-desktop/internal/server/setup"
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
@@ -111,6 +114,12 @@ func runServe(cmd *cobra.Command, args []string) {
 	conflictRepo := storage.NewConflictRepository(db)
 	biometricAuthRepo := storage.NewBiometricAuthRepository(db)
 	deviceAuthRepo := storage.NewDeviceAuthRepository(db)
+	routeRepo := storage.NewRouteRepository(db)
+	signingKeyRepo := storage.NewSigningKeyRepository(db)
+	identityRepo := storage.NewIdentityRepository(db)
+	enrollmentKeyRepo := storage.NewEnrollmentKeyRepository(db)
+	sshCARepo := storage.NewSSHCARepository(db)
+	tunnelUsageRepo := storage.NewTunnelUsageRepository(db)
 
 	// Step 4: Setup WireGuard (auto-install + configure)
 	log.Println("=== WireGuard Setup ===")
@@ -133,9 +142,12 @@ func runServe(cmd *cobra.Command, args []string) {
 	log.Printf("WireGuard manager initialized (public key: %s)", wgManager.GetPublicKey())
 
 	// Initialize services
+	// Email login codes are optional: self-hosted deployments can log in
+	// with OIDC or local accounts instead
 	emailService, err := services.NewEmailService()
 	if err != nil {
-		log.Fatalf("Failed to initialize email service: %v", err)
+		log.Printf("Warning: Email not configured: %v", err)
+		log.Println("Email login codes will not be available")
 	}
 
 	subnetPool, err := services.NewSubnetPool(userRepo, conflictRepo)
@@ -149,9 +161,47 @@ func runServe(cmd *cobra.Command, args []string) {
 	biometricAuthService := services.NewBiometricAuthService(biometricAuthRepo, userRepo, deviceRepo)
 	deviceAuthService := services.NewDeviceAuthService(deviceAuthRepo, userRepo)
 
+	// Link logins to users by provider subject, not just email
+	authService.SetIdentities(identityRepo)
+
 	// Link device service to device auth service (for auto-registration)
 	deviceAuthService.SetDeviceService(deviceService)
 
+	// Pre-authorized keys for enrolling headless devices
+	deviceAuthService.SetEnrollmentKeys(enrollmentKeyRepo)
+	deviceAuthService.SetApprovalPolicy(services.ApprovalPolicyFromEnv())
+
+	// Rate limit counters are per instance unless RATE_LIMIT_STORE=postgres
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		rateLimitStore = storage.NewRateLimitRepository(db)
+		log.Println("Rate limits shared through PostgreSQL")
+	}
+	authService.SetRateLimitStore(rateLimitStore)
+	deviceAuthService.SetRateLimitStore(rateLimitStore)
+
+	// Sign access tokens with rotating Ed25519 keys, encrypted at rest with
+	// KEY_ENCRYPTION_KEY (legacy HS256 tokens are still accepted while
+	// JWT_SECRET is set, until the cutoff)
+	keyEncryption, err := services.KeyEncryptionFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load key encryption key: %v", err)
+	}
+	signingKeyService := services.NewSigningKeyService(signingKeyRepo)
+	signingKeyService.SetEncryption(keyEncryption)
+	if err := signingKeyService.Init(context.Background()); err != nil {
+		log.Fatalf("Failed to initialize JWT signing keys: %v", err)
+	}
+	authService.SetSigningKeys(signingKeyService)
+	api.SetTokenVerifier(signingKeyService)
+
+	// SSH certificate authority for device logins and tunnel authentication
+	sshCA := services.NewSSHCertificateAuthority(sshCARepo)
+	sshCA.SetEncryption(keyEncryption)
+	if err := sshCA.Init(context.Background()); err != nil {
+		log.Fatalf("Failed to initialize SSH certificate authority: %v", err)
+	}
+
 	// Initialize Firebase service (optional - only if configured)
 	var firebaseService *services.FirebaseService
 	ctx := context.Background()
@@ -163,6 +213,17 @@ func runServe(cmd *cobra.Command, args []string) {
 		log.Println("Firebase authentication initialized")
 	}
 
+	// Generic OpenID Connect login (optional - only if OIDC_ISSUER is set)
+	var oidcProvider *services.OIDCProvider
+	if os.Getenv("OIDC_ISSUER") != "" {
+		if provider, err := services.NewOIDCProviderFromEnv(ctx); err != nil {
+			log.Printf("Warning: OIDC login not available: %v", err)
+		} else {
+			oidcProvider = provider
+			log.Printf("OIDC authentication initialized (issuer: %s)", provider.Issuer())
+		}
+	}
+
 	// Initialize SSH service (optional - only if Firebase configured)
 	var sshService *services.SSHService
 	if firebaseService != nil {
@@ -211,8 +272,32 @@ func runServe(cmd *cobra.Command, args []string) {
 	adminHandler := api.NewAdminHandler(networkScanner)
 	biometricAuthHandler := api.NewBiometricAuthHandler(biometricAuthService)
 	deviceAuthHandler := api.NewDeviceAuthHandler(deviceAuthService, authService, firebaseService, deviceService, wgManager, userRepo, deviceRepo)
+	if oidcProvider != nil {
+		deviceAuthHandler.AddIdentityProvider(oidcProvider)
+	}
+	if os.Getenv("LOCAL_ACCOUNTS") == "true" {
+		deviceAuthHandler.AddIdentityProvider(services.NewLocalAccountProvider(userRepo))
+		log.Println("Local account (password) authentication enabled")
+	}
 	tunnelService := services.NewTunnelService(deviceRepo)
-	tunnelHandler := api.NewTunnelHandler(deviceRepo, deviceService, tunnelPortPool, tunnelService)
+	conflictService := services.NewConflictService(conflictRepo, userRepo, deviceRepo, subnetPool, wgManager)
+	networkHandler := api.NewNetworkHandler(conflictService, deviceService)
+	routeService := services.NewRouteService(routeRepo, deviceRepo, deviceService, subnetPool.Networks(), wgManager)
+	conflictService.SetRouteService(routeService)
+	routeHandler := api.NewRouteHandler(routeService, deviceService)
+	tunnelHandler := api.NewTunnelHandler(deviceRepo, deviceService, tunnelPortPool, tunnelService, tunnelUsageRepo)
+	jwksHandler := api.NewJWKSHandler(signingKeyService)
+	sessionHandler := api.NewSessionHandler(deviceAuthService, deviceService)
+	enrollmentKeyHandler := api.NewEnrollmentKeyHandler(deviceAuthService)
+	sshCertificateHandler := api.NewSSHCertificateHandler(sshCA, deviceService, userRepo)
+
+	// Reject device-bound access tokens once their device is deleted or deactivated
+	api.SetDeviceStatusChecker(deviceRepo)
+
+	// Restore approved subnet routes and exit nodes (kernel state is lost on reboot)
+	if err := routeService.ApplyAll(ctx); err != nil {
+		log.Printf("Warning: Failed to apply device routes: %v", err)
+	}
 
 	// SSH handler (only if SSH service initialized)
 	var sshHandler *api.SSHHandler
@@ -227,7 +312,9 @@ func runServe(cmd *cobra.Command, args []string) {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	// No middleware.RealIP: it trusts X-Forwarded-For from any client, which
+	// would let them dodge per-IP rate limits. Handlers use getClientIP, which
+	// only believes TRUSTED_PROXIES.
 	r.Use(api.CORSMiddleware)
 
 	// Health check
@@ -237,18 +324,50 @@ func runServe(cmd *cobra.Command, args []string) {
 		w.Write([]byte(`{"status":"healthy","service":"roamie-desktop"}`))
 	})
 
+	// Public keys for verifying access tokens
+	r.Get("/.well-known/jwks.json", jwksHandler.JWKS)
+
+	// Rate limits for the public endpoints (override with RATE_LIMIT_<NAME>)
+	rateLimit := func(name string, requests int, window time.Duration, key api.RateLimitKeyFunc) func(http.Handler) http.Handler {
+		return api.RateLimit(ratelimit.NewFromEnv(rateLimitStore, name, ratelimit.Limit{Requests: requests, Window: window}), key)
+	}
+
+	// Device verification page (users enter the code a device displays and
+	// sign in with a password or an emailed login code)
+	r.Get("/device", deviceAuthHandler.VerificationPage)
+	r.With(
+		rateLimit("device_verification_ip", 30, 15*time.Minute, api.RateLimitByIP),
+		rateLimit("device_verification_email", 10, 15*time.Minute, api.RateLimitByFormField("email")),
+	).Post("/device", deviceAuthHandler.VerificationSubmit)
+
 	// Public routes
 	r.Route("/api/auth", func(r chi.Router) {
-		r.Post("/request-code", authHandler.RequestCode)
-		r.Post("/verify-code", authHandler.VerifyCode)
+		r.With(
+			rateLimit("request_code_ip", 10, 15*time.Minute, api.RateLimitByIP),
+			rateLimit("request_code_email", 5, 15*time.Minute, api.RateLimitByJSONField("email")),
+		).Post("/request-code", authHandler.RequestCode)
+		r.With(rateLimit("verify_code_ip", 30, 15*time.Minute, api.RateLimitByIP)).
+			Post("/verify-code", authHandler.VerifyCode)
 
 		// Device authorization (public endpoints)
-		r.Post("/device-request", deviceAuthHandler.CreateDeviceRequest)
-		r.Get("/device-poll/{challenge_id}", deviceAuthHandler.PollChallenge)
-		r.Post("/refresh", deviceAuthHandler.RefreshJWT)
-		r.Post("/login", deviceAuthHandler.Login)
+		r.With(rateLimit("device_request_ip", 20, 10*time.Minute, api.RateLimitByIP)).
+			Post("/device-request", deviceAuthHandler.CreateDeviceRequest)
+		r.With(
+			rateLimit("device_poll_ip", 600, 5*time.Minute, api.RateLimitByIP),
+			rateLimit("device_poll_challenge", 120, 5*time.Minute, api.RateLimitByURLParam("challenge_id")),
+		).Get("/device-poll/{challenge_id}", deviceAuthHandler.PollChallenge)
+		r.With(rateLimit("refresh_ip", 120, 15*time.Minute, api.RateLimitByIP)).
+			Post("/refresh", deviceAuthHandler.RefreshJWT)
+		r.With(rateLimit("login_ip", 30, 15*time.Minute, api.RateLimitByIP)).
+			Post("/login", deviceAuthHandler.Login)
+		r.Get("/providers", deviceAuthHandler.Providers)
 	})
 
+	// Tunnel transport for networks that only allow HTTPS (the SSH handshake
+	// inside authenticates the device)
+	r.With(rateLimit("tunnel_ws_ip", 60, 5*time.Minute, api.RateLimitByIP)).
+		Get("/api/tunnel/ws", tunnelHandler.ServeWebSocket)
+
 	// Protected routes
 	r.Route("/api", func(r chi.Router) {
 		r.Use(api.AuthMiddleware)
@@ -258,8 +377,11 @@ func runServe(cmd *cobra.Command, args []string) {
 			r.Get("/", deviceHandler.ListDevices)
 			r.Post("/", deviceHandler.RegisterDevice)
 			r.Get("/validate", deviceHandler.ValidateDevice)
+			r.Patch("/{device_id}", deviceHandler.RenameDevice)
 			r.Delete("/{device_id}", deviceHandler.DeleteDevice)
 			r.Get("/{device_id}/config", deviceHandler.GetDeviceConfig)
+			r.Post("/{device_id}/routes", routeHandler.AdvertiseRoutes)
+			r.Put("/{device_id}/exit-node", routeHandler.SetExitNode)
 			r.Post("/heartbeat", deviceHandler.Heartbeat)
 
 			// Diagnostics endpoints
@@ -270,6 +392,9 @@ func runServe(cmd *cobra.Command, args []string) {
 			// Daemon diagnostics endpoints (server-as-proxy)
 			r.Get("/diagnostics/pending", deviceHandler.GetPendingDiagnostics)
 			r.Post("/diagnostics/report", deviceHandler.UploadDiagnosticsReport)
+
+			// Commands pushed to the device over its tunnel connection
+			r.Post("/{device_id}/commands", tunnelHandler.RunDeviceCommand)
 		})
 
 		// SSH Tunnel management
@@ -278,12 +403,41 @@ func runServe(cmd *cobra.Command, args []string) {
 			r.Post("/register-key", tunnelHandler.RegisterKey)
 			r.Get("/status", tunnelHandler.GetStatus)
 			r.Get("/authorized-keys", tunnelHandler.GetAuthorizedKeys)
+			r.Get("/host-keys", tunnelHandler.GetHostKeys)
+			r.Get("/usage", tunnelHandler.GetUsage)
+			r.Post("/certificate", sshCertificateHandler.IssueTunnelCertificate)
 		})
 
 		// Device-specific tunnel control
 		r.Route("/devices/{device_id}/tunnel", func(r chi.Router) {
 			r.Patch("/enable", tunnelHandler.EnableTunnel)
 			r.Patch("/disable", tunnelHandler.DisableTunnel)
+			r.Patch("/exposure", tunnelHandler.SetTunnelExposure)
+			r.Get("/usage", tunnelHandler.GetDeviceUsage)
+		})
+
+		// Client-side network conflict reporting
+		r.Route("/network", func(r chi.Router) {
+			r.Post("/conflicts", networkHandler.ReportConflicts)
+			r.Get("/conflicts", networkHandler.ListConflicts)
+			r.Post("/rehome", networkHandler.RehomeSubnet)
+		})
+
+		// Subnet routes and exit nodes advertised by the user's devices
+		r.Get("/routes", routeHandler.ListRoutes)
+
+		// Active logins (refresh token families)
+		r.Route("/sessions", func(r chi.Router) {
+			r.Get("/", sessionHandler.ListSessions)
+			r.Delete("/", sessionHandler.RevokeOtherSessions)
+			r.Delete("/{session_id}", sessionHandler.RevokeSession)
+		})
+
+		// Pre-authorized keys for enrolling devices without approval
+		r.Route("/enrollment-keys", func(r chi.Router) {
+			r.Get("/", enrollmentKeyHandler.ListKeys)
+			r.Post("/", enrollmentKeyHandler.CreateKey)
+			r.Delete("/{key_id}", enrollmentKeyHandler.RevokeKey)
 		})
 
 		// Biometric authentication
@@ -298,6 +452,17 @@ func runServe(cmd *cobra.Command, args []string) {
 		// Device authorization (protected endpoints)
 		// Note: Mobile app gets challenge_id from QR code scan, not from listing endpoint
 		r.Post("/device-auth/approve", deviceAuthHandler.ApproveDevice)
+		r.Route("/device-auth/challenges", func(r chi.Router) {
+			r.Get("/", deviceAuthHandler.ListChallenges)
+			r.Post("/lookup", deviceAuthHandler.LookupChallenge)
+			r.Post("/{challenge_id}/approve", deviceAuthHandler.ApproveChallenge)
+			r.Post("/{challenge_id}/deny", deviceAuthHandler.DenyChallenge)
+		})
+
+		// SSH certificates signed by the Roamie CA
+		r.Get("/ssh/ca", sshCertificateHandler.GetCA)
+		r.Post("/ssh/certificates", sshCertificateHandler.IssueUserCertificate)
+		r.Post("/ssh/host-certificate", sshCertificateHandler.IssueHostCertificate)
 
 		// SSH key management
 		if sshHandler != nil {
@@ -313,6 +478,17 @@ func runServe(cmd *cobra.Command, args []string) {
 			r.Get("/conflicts", adminHandler.ListConflicts)
 			r.Post("/conflicts", adminHandler.AddConflict)
 		})
+		r.Post("/users/{user_id}/rehome", networkHandler.RehomeUserSubnet)
+		r.Route("/device-challenges", func(r chi.Router) {
+			r.Get("/", deviceAuthHandler.AdminListChallenges)
+			r.Post("/{challenge_id}/approve", deviceAuthHandler.AdminApproveChallenge)
+			r.Post("/{challenge_id}/deny", deviceAuthHandler.AdminDenyChallenge)
+		})
+		r.Route("/routes", func(r chi.Router) {
+			r.Get("/", routeHandler.ListPendingRoutes)
+			r.Post("/{route_id}/approve", routeHandler.ApproveRoute)
+			r.Post("/{route_id}/reject", routeHandler.RejectRoute)
+		})
 	})
 
 	// Get server config
@@ -326,9 +502,19 @@ func runServe(cmd *cobra.Command, args []string) {
 		port = "8080"
 	}
 
-	// Find available port
-	port = findAvailableAPIPort(port)
-	addr := fmt.Sprintf("%s:%s", host, port)
+	// A socket passed by systemd stays open across restarts; otherwise find
+	// an available port
+	apiListener, err := activation.Listener(activation.API)
+	if err != nil {
+		log.Fatalf("Failed to use passed API socket: %v", err)
+	}
+	var addr string
+	if apiListener != nil {
+		addr = apiListener.Addr().String()
+	} else {
+		port = findAvailableAPIPort(port)
+		addr = fmt.Sprintf("%s:%s", host, port)
+	}
 
 	// Create server
 	srv := &http.Server{
@@ -343,6 +529,9 @@ func runServe(cmd *cobra.Command, args []string) {
 	go cleanupExpiredCodes(authService)
 	go cleanupExpiredBiometricRequests(biometricAuthService)
 	go cleanupExpiredDeviceChallenges(deviceAuthService)
+	go signingKeyService.Run(context.Background())
+	go ratelimit.RunCleanup(context.Background(), rateLimitStore, 5*time.Minute)
+	go services.NewDeviceReaper(deviceRepo, deviceService, deviceCache, wgManager).Run(context.Background())
 
 	// Initialize and start SSH tunnel server (unless disabled for testing)
 	var tunnelServer *tunnel.Server
@@ -366,6 +555,16 @@ func runServe(cmd *cobra.Command, args []string) {
 			log.Println("Firewall not active, skipping firewall configuration")
 		}
 
+		// Accept tunnel certificates as well as registered keys
+		tunnelServer.SetUserCA(sshCA.UserCA())
+
+		// Connection caps, bandwidth limits and timeouts, with usage saved per device per day
+		tunnelServer.SetPolicy(tunnel.PolicyFromEnv())
+		tunnelServer.SetUsageStore(tunnelUsageRepo)
+
+		// Tunnel ports listen on the VPN address unless configured otherwise
+		tunnelServer.SetExposurePolicy(tunnel.ExposurePolicyFromEnv(setup.ServerIP()))
+
 		// Start tunnel server
 		if err := tunnelServer.Start(); err != nil {
 			log.Printf("Warning: SSH tunnel server failed to start: %v", err)
@@ -373,17 +572,63 @@ func runServe(cmd *cobra.Command, args []string) {
 			log.Println("✓ SSH tunnel server started successfully")
 		}
 		defer tunnelServer.Stop()
+		tunnelHandler.SetHostKeys(tunnelServer)
+		tunnelHandler.SetTransport(tunnelServer)
+		tunnelHandler.SetCommander(tunnelServer)
+		tunnelHandler.SetExposure(tunnelServer)
 	} else {
 		log.Println("=== SSH Tunnel Server ===")
 		log.Println("Tunnel server disabled (DISABLE_TUNNEL_SERVER=true)")
 		log.Println("Using external SSH server on port 2222")
+
+		// Clients pin the external server's host keys
+		if path := os.Getenv("TUNNEL_HOST_KEYS_FILE"); path != "" {
+			hostKeys, err := tunnel.LoadStaticHostKeys(path)
+			if err != nil {
+				log.Fatalf("Failed to load tunnel host keys: %v", err)
+			}
+			tunnelHandler.SetHostKeys(hostKeys)
+		} else {
+			log.Println("Warning: TUNNEL_HOST_KEYS_FILE not set, clients can't verify the tunnel server")
+		}
+	}
+
+	// Start embedded DNS resolver on the WireGuard interface. Devices are only
+	// told to use it (and given DNS names) once it is actually serving.
+	dnsConfig := dns.ConfigFromEnv(setup.ServerIP())
+	if dnsConfig.Enabled {
+		log.Println("=== DNS Resolver Setup ===")
+		dnsConfig.Networks = subnetPool.Networks()
+		dnsServer := dns.NewServer(dnsConfig, deviceRepo, userRepo)
+		if err := dnsServer.Start(); err != nil {
+			log.Printf("Warning: DNS resolver failed to start: %v", err)
+			log.Println("Device names will not resolve and devices keep their own DNS")
+		} else {
+			log.Printf("✓ DNS resolver started (*.%s)", dnsConfig.Zone)
+			deviceHandler.SetDNS(dnsConfig)
+			sshCertificateHandler.SetDNS(dnsConfig)
+			if isFirewallActive() {
+				if err := allowDNSOnInterface(os.Getenv("WG_INTERFACE")); err != nil {
+					log.Printf("Warning: failed to allow DNS through firewall: %v", err)
+				}
+			}
+			defer dnsServer.Stop()
+		}
+	} else {
+		log.Println("DNS resolver disabled (set DNS_ENABLED=true to enable)")
 	}
 
 	// Start server
 	go func() {
 		log.Printf("Server starting on %s", addr)
 		log.Printf("WireGuard endpoint: %s", wgManager.GetEndpoint())
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if apiListener != nil {
+			err = srv.Serve(apiListener)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
@@ -395,6 +640,12 @@ func runServe(cmd *cobra.Command, args []string) {
 
 	log.Println("Server shutting down...")
 
+	// Hand devices to the next instance while the API still serves their
+	// WebSocket tunnels and logins
+	if tunnelServer != nil {
+		tunnelServer.Drain(tunnel.DrainConfigFromEnv())
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -547,6 +798,22 @@ func isFirewallActive() bool {
 	return strings.Contains(string(output), "Status: active")
 }
 
+// allowDNSOnInterface opens port 53 (TCP and UDP) to VPN clients only
+func allowDNSOnInterface(iface string) error {
+	if iface == "" {
+		iface = "wg0"
+	}
+
+	cmd := exec.Command("ufw", "allow", "in", "on", iface, "to", "any", "port", "53")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to open DNS on %s: %w (output: %s)", iface, err, string(output))
+	}
+
+	log.Printf("Allowed DNS on %s: %s", iface, strings.TrimSpace(string(output)))
+	return nil
+}
+
 // openFirewallPort opens a port in the ufw firewall
 func openFirewallPort(port int) error {
 	// Open the port
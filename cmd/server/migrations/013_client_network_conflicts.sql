@@ -0,0 +1,14 @@
+-- Migration 013: Client-reported network conflicts
+-- Clients scan their local routes, interfaces and Docker networks and report
+-- ranges that overlap the user's VPN subnet. These conflicts are scoped to the
+-- reporting user so they don't block subnet allocation for everyone else.
+
+ALTER TABLE network_conflicts
+ADD COLUMN IF NOT EXISTS user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+ADD COLUMN IF NOT EXISTS device_id UUID REFERENCES devices(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_conflicts_user ON network_conflicts(user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_conflicts_device ON network_conflicts(device_id) WHERE device_id IS NOT NULL;
+
+COMMENT ON COLUMN network_conflicts.user_id IS 'User that reported the conflict (NULL for server-wide conflicts)';
+COMMENT ON COLUMN network_conflicts.device_id IS 'Device that reported the conflict (NULL for server-wide conflicts)';
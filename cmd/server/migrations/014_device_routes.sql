@@ -0,0 +1,23 @@
+-- Migration 014: Subnet routes and exit nodes
+-- A device can advertise LAN ranges (subnet router) or 0.0.0.0/0 (exit node).
+-- Advertised routes only take effect once an admin approves them; the server
+-- then adds them to the device's WireGuard AllowedIPs and kernel routes.
+
+CREATE TABLE IF NOT EXISTS device_routes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    cidr CIDR NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP,
+    UNIQUE(device_id, cidr)
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_routes_device ON device_routes(device_id);
+CREATE INDEX IF NOT EXISTS idx_device_routes_status ON device_routes(status);
+
+ALTER TABLE devices
+ADD COLUMN IF NOT EXISTS exit_node_id UUID REFERENCES devices(id) ON DELETE SET NULL;
+
+COMMENT ON COLUMN device_routes.status IS 'pending, approved or rejected';
+COMMENT ON COLUMN devices.exit_node_id IS 'Device whose approved exit route carries this device''s internet traffic';
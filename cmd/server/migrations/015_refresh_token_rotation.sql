@@ -0,0 +1,17 @@
+-- Migration 015: Refresh token rotation
+-- Every refresh replaces the refresh token. Rotated tokens are kept (marked
+-- with rotated_at) until they expire so that reuse of a stolen token can be
+-- detected; reuse revokes every token in the family.
+
+ALTER TABLE refresh_tokens
+ADD COLUMN IF NOT EXISTS family_id UUID,
+ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP;
+
+-- Existing tokens start their own family
+UPDATE refresh_tokens SET family_id = id WHERE family_id IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+
+COMMENT ON COLUMN refresh_tokens.family_id IS 'Tokens descended from the same login share a family';
+COMMENT ON COLUMN refresh_tokens.rotated_at IS 'Set when the token was exchanged; presenting it again is reuse';
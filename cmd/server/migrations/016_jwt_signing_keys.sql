@@ -0,0 +1,19 @@
+-- Migration 016: JWT signing keys
+-- Access tokens are signed with rotating Ed25519 keys. The newest key without
+-- retires_at signs new tokens; retired keys keep verifying (and stay in the
+-- JWKS) until retires_at so tokens issued before a rotation remain valid.
+
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+    kid TEXT PRIMARY KEY,
+    algorithm TEXT NOT NULL DEFAULT 'EdDSA',
+    private_key TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    retires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_jwt_signing_keys_retires_at ON jwt_signing_keys(retires_at);
+
+COMMENT ON TABLE jwt_signing_keys IS 'Ed25519 keys used to sign access tokens, published at /.well-known/jwks.json';
+COMMENT ON COLUMN jwt_signing_keys.private_key IS 'Base64 Ed25519 private key';
+COMMENT ON COLUMN jwt_signing_keys.retires_at IS 'NULL for the current signing key; verification stops after this time';
@@ -0,0 +1,14 @@
+-- Migration 017: Session tracking
+-- A session is a refresh token family. Track when it started (carried across
+-- rotations) and the client IP of its last refresh so users can review and
+-- revoke their sessions.
+
+ALTER TABLE refresh_tokens
+ADD COLUMN IF NOT EXISTS session_started_at TIMESTAMP DEFAULT NOW(),
+ADD COLUMN IF NOT EXISTS last_used_ip TEXT;
+
+UPDATE refresh_tokens SET session_started_at = created_at WHERE session_started_at IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN session_started_at SET NOT NULL;
+
+COMMENT ON COLUMN refresh_tokens.session_started_at IS 'Login time of the token family, kept on rotation';
+COMMENT ON COLUMN refresh_tokens.last_used_ip IS 'Client IP of the last login or refresh';
@@ -0,0 +1,27 @@
+-- Migration 018: User identities
+-- Users log in through pluggable identity providers (Firebase, any OIDC
+-- issuer, local accounts). Each provider subject is linked to one user; the
+-- Firebase UIDs stored on users are carried over.
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+
+INSERT INTO user_identities (provider, subject, user_id, email)
+SELECT 'firebase', firebase_uid, id, email FROM users WHERE firebase_uid IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+-- Local accounts (LOCAL_ACCOUNTS=true) log in with a password
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT;
+
+COMMENT ON TABLE user_identities IS 'Identity provider subjects linked to users';
+COMMENT ON COLUMN user_identities.provider IS 'firebase, oidc or local';
+COMMENT ON COLUMN users.password_hash IS 'bcrypt hash for local accounts, NULL otherwise';
@@ -0,0 +1,36 @@
+-- Migration 019: Enrollment keys
+-- Pre-authorized keys let headless machines enroll without interactive
+-- approval. Only a SHA-256 hash of each key is stored; the key itself is
+-- shown once when it is created.
+
+CREATE TABLE IF NOT EXISTS enrollment_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key_hash TEXT NOT NULL UNIQUE,
+    key_prefix TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    max_uses INT,
+    uses INT NOT NULL DEFAULT 0,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    tunnel BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_enrollment_keys_user ON enrollment_keys(user_id);
+
+-- Challenges approved with a key remember which one
+ALTER TABLE device_auth_challenges
+ADD COLUMN IF NOT EXISTS enrollment_key_id UUID REFERENCES enrollment_keys(id) ON DELETE SET NULL;
+
+-- Devices carry the tags of the key they enrolled with
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+COMMENT ON TABLE enrollment_keys IS 'Pre-authorized keys for non-interactive device enrollment';
+COMMENT ON COLUMN enrollment_keys.key_hash IS 'Hex SHA-256 of the key';
+COMMENT ON COLUMN enrollment_keys.key_prefix IS 'First characters of the key, for identifying it in listings';
+COMMENT ON COLUMN enrollment_keys.max_uses IS 'Enrollments allowed, NULL for unlimited until expiry';
+COMMENT ON COLUMN enrollment_keys.tunnel IS 'Enrolled devices register an SSH tunnel automatically';
+COMMENT ON COLUMN devices.tags IS 'Tags from the enrollment key the device enrolled with';
@@ -0,0 +1,11 @@
+-- Migration 020: Ephemeral devices
+-- Devices enrolled with an ephemeral key (CI jobs, short-lived containers)
+-- are deleted automatically once they stop sending heartbeats, freeing their
+-- VPN IP and tunnel port.
+
+ALTER TABLE enrollment_keys ADD COLUMN IF NOT EXISTS ephemeral BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS ephemeral BOOLEAN NOT NULL DEFAULT false;
+
+COMMENT ON COLUMN enrollment_keys.ephemeral IS 'Devices enrolled with this key are ephemeral';
+COMMENT ON COLUMN devices.ephemeral IS 'Deleted after EPHEMERAL_DEVICE_TIMEOUT without a heartbeat';
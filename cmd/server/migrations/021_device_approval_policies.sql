@@ -0,0 +1,22 @@
+-- Migration 021: Device approval policies
+-- Device requests may name the user they're for, which lets that user review
+-- them from any logged-in device and lets policies auto-approve them. Devices
+-- of organization users can require more than one approver.
+
+ALTER TABLE device_auth_challenges
+ADD COLUMN IF NOT EXISTS requested_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+ADD COLUMN IF NOT EXISTS required_approvals INT NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_device_auth_requested_user ON device_auth_challenges(requested_user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_device_auth_pending_ip ON device_auth_challenges(ip_address) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS device_auth_approvals (
+    challenge_id UUID NOT NULL REFERENCES device_auth_challenges(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    approved_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (challenge_id, user_id)
+);
+
+COMMENT ON COLUMN device_auth_challenges.requested_user_id IS 'User the device asked to join, if it named one';
+COMMENT ON COLUMN device_auth_challenges.required_approvals IS 'Distinct approvers needed before the device is approved';
+COMMENT ON TABLE device_auth_approvals IS 'Approvals recorded for challenges that need more than one approver';
@@ -0,0 +1,11 @@
+-- Migration 022: Device user codes
+-- Each challenge gets a short code (RFC 8628 device authorization grant) that
+-- users can type on the verification page or another device when they can't
+-- scan the QR code.
+
+ALTER TABLE device_auth_challenges ADD COLUMN IF NOT EXISTS user_code TEXT;
+
+-- Codes only need to be unique while they can be entered
+CREATE UNIQUE INDEX IF NOT EXISTS idx_device_auth_user_code ON device_auth_challenges(user_code) WHERE status = 'pending';
+
+COMMENT ON COLUMN device_auth_challenges.user_code IS 'Normalized user code (8 characters, no separator)';
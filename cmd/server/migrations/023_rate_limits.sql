@@ -0,0 +1,17 @@
+-- Migration 023: Rate limit counters
+-- Shared counters for the rate limits on public auth endpoints, used when
+-- RATE_LIMIT_STORE=postgres so every server instance enforces the same limits.
+
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    key TEXT NOT NULL,
+    window_start TIMESTAMP NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_expires_at ON rate_limit_counters(expires_at);
+
+COMMENT ON TABLE rate_limit_counters IS 'Hits per rate limit key and fixed window';
+COMMENT ON COLUMN rate_limit_counters.key IS 'Limiter name and key, e.g. request_code_email:user@example.com';
+COMMENT ON COLUMN rate_limit_counters.expires_at IS 'When the counter stops affecting the sliding window (two windows after it starts)';
@@ -0,0 +1,16 @@
+-- Migration 024: SSH certificate authority
+-- The server signs short-lived SSH certificates: user certificates for
+-- connecting to a device (and for the reverse tunnel) and host certificates
+-- for each device's sshd. Devices trust the CA once instead of syncing keys.
+
+CREATE TABLE IF NOT EXISTS ssh_ca_keys (
+    kind TEXT PRIMARY KEY CHECK (kind IN ('user', 'host')),
+    private_key TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE ssh_ca_keys IS 'Ed25519 CA keys that sign SSH user and host certificates';
+COMMENT ON COLUMN ssh_ca_keys.kind IS 'user signs certificates sshd accepts (TrustedUserCAKeys), host signs device host keys (@cert-authority)';
+COMMENT ON COLUMN ssh_ca_keys.private_key IS 'OpenSSH PEM private key';
+COMMENT ON COLUMN ssh_ca_keys.public_key IS 'authorized_keys format public key';
@@ -0,0 +1,20 @@
+-- Migration 025: Tunnel usage
+-- The tunnel server counts forwarded bytes and connections per device and
+-- adds them to a row per device per day (UTC) about once a minute.
+
+CREATE TABLE IF NOT EXISTS tunnel_usage (
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    bytes_in BIGINT NOT NULL DEFAULT 0,
+    bytes_out BIGINT NOT NULL DEFAULT 0,
+    connections BIGINT NOT NULL DEFAULT 0,
+    rejected_connections BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (device_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tunnel_usage_day ON tunnel_usage(day);
+
+COMMENT ON TABLE tunnel_usage IS 'Tunnel traffic per device per day (UTC)';
+COMMENT ON COLUMN tunnel_usage.bytes_in IS 'Bytes from clients connecting to the tunnel port to the device';
+COMMENT ON COLUMN tunnel_usage.bytes_out IS 'Bytes from the device back to those clients';
+COMMENT ON COLUMN tunnel_usage.rejected_connections IS 'Connections refused by the per-device or per-user connection caps';
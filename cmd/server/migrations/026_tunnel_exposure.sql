@@ -0,0 +1,9 @@
+-- Migration 026: Tunnel port exposure
+-- Tunnel ports listen where TUNNEL_BIND says (the WireGuard address by
+-- default). A device can override where its own port listens.
+
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS tunnel_bind VARCHAR(16);
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS tunnel_allowed_cidrs TEXT[];
+
+COMMENT ON COLUMN devices.tunnel_bind IS 'Where the tunnel port listens: vpn, loopback or public (NULL uses TUNNEL_BIND)';
+COMMENT ON COLUMN devices.tunnel_allowed_cidrs IS 'Source CIDRs allowed to connect when the tunnel port is public (replaces TUNNEL_ALLOWED_CIDRS; empty allows all)';
@@ -0,0 +1,11 @@
+-- Migration 027: Remember whether a refresh token is bound to a device
+-- Tunnel-only clients never register a device, so their tokens name a device
+-- that doesn't exist. A bound token whose device is gone must not fall back
+-- to an unbound token, so the binding is recorded when the token is issued.
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS device_bound BOOLEAN NOT NULL DEFAULT false;
+
+-- Existing tokens whose device is still there were issued bound
+UPDATE refresh_tokens SET device_bound = true WHERE device_id IN (SELECT id FROM devices);
+
+COMMENT ON COLUMN refresh_tokens.device_bound IS 'Issued for a registered device: once the device is deleted the token is revoked';
@@ -0,0 +1,15 @@
+-- Migration 028: JWT signing key encryption and HS256 cutoff
+-- Private keys are encrypted with KEY_ENCRYPTION_KEY when it is set; keys
+-- stored before that are encrypted on the next startup. HS256 tokens signed
+-- with JWT_SECRET are accepted until hs256_until, fixed once per deployment
+-- to cover the longest lifetime of tokens issued before the switch to
+-- signing keys.
+
+CREATE TABLE IF NOT EXISTS jwt_settings (
+    id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+    hs256_until TIMESTAMP NOT NULL
+);
+
+COMMENT ON TABLE jwt_settings IS 'Single row of deployment-wide JWT settings';
+COMMENT ON COLUMN jwt_settings.hs256_until IS 'Legacy HS256 tokens are rejected after this time';
+COMMENT ON COLUMN jwt_signing_keys.private_key IS 'Base64 Ed25519 private key, or enc:v1: followed by the key encrypted with KEY_ENCRYPTION_KEY';
@@ -0,0 +1,6 @@
+-- Migration 029: SSH CA key encryption
+-- CA private keys are encrypted with KEY_ENCRYPTION_KEY when it is set, like
+-- the JWT signing keys; keys stored before that are encrypted on the next
+-- startup.
+
+COMMENT ON COLUMN ssh_ca_keys.private_key IS 'OpenSSH PEM private key, or enc:v1: followed by the key encrypted with KEY_ENCRYPTION_KEY';
@@ -8,11 +8,22 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
 )
 
 // ErrDeviceDeleted is returned when the device has been deleted from the server
 var ErrDeviceDeleted = errors.New("device_deleted")
 
+// ErrRefreshTokenRotated is returned when another process on this machine
+// already exchanged the refresh token; reload the config to pick up its result
+var ErrRefreshTokenRotated = errors.New("refresh token already rotated")
+
+// isDeviceRevoked reports whether a 401 response says the token's device is gone
+func isDeviceRevoked(statusCode int, body []byte) bool {
+	return statusCode == http.StatusUnauthorized && bytes.Contains(body, []byte("device revoked"))
+}
+
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
@@ -22,7 +33,8 @@ func NewClient(baseURL string) *Client {
 	return &Client{
 		baseURL: baseURL,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: &retryAfterTransport{next: http.DefaultTransport},
 		},
 	}
 }
@@ -42,8 +54,9 @@ func (c *Client) HealthCheck() error {
 	return nil
 }
 
-// CreateDeviceRequest creates a new device authorization challenge
-func (c *Client) CreateDeviceRequest(deviceID, hostname, username, publicKey, osType, hardwareID string) (*DeviceRequestResponse, error) {
+// CreateDeviceRequest creates a new device authorization challenge. With an
+// enrollment key the server approves it right away.
+func (c *Client) CreateDeviceRequest(deviceID, hostname, username, publicKey, osType, hardwareID, enrollKey, email string) (*DeviceRequestResponse, error) {
 	reqBody := map[string]interface{}{
 		"device_id": deviceID,
 		"hostname":  hostname,
@@ -69,6 +82,16 @@ func (c *Client) CreateDeviceRequest(deviceID, hostname, username, publicKey, os
 		reqBody["hardware_id"] = hardwareID
 	}
 
+	if enrollKey != "" {
+		reqBody["enroll_key"] = enrollKey
+	}
+
+	// Name the user whose network the device joins, so they can approve it
+	// from their other devices and approval policies can apply
+	if email != "" {
+		reqBody["email"] = email
+	}
+
 	body, _ := json.Marshal(reqBody)
 
 	resp, err := c.httpClient.Post(
@@ -82,7 +105,13 @@ func (c *Client) CreateDeviceRequest(deviceID, hostname, username, publicKey, os
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Message string `json:"message"`
+		}
 		bodyBytes, _ := io.ReadAll(resp.Body)
+		if enrollKey != "" && json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Message != "" {
+			return nil, fmt.Errorf("%s", errResp.Message)
+		}
 		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
@@ -130,8 +159,15 @@ func (c *Client) RefreshJWT(refreshToken string) (*RefreshResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return nil, ErrRefreshTokenRotated
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
+		if isDeviceRevoked(resp.StatusCode, bodyBytes) {
+			return nil, ErrDeviceDeleted
+		}
 		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
@@ -252,6 +288,9 @@ func (c *Client) ValidateDevice(deviceID, jwt string) (*ValidateDeviceResponse,
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
+		if isDeviceRevoked(resp.StatusCode, bodyBytes) {
+			return nil, ErrDeviceDeleted
+		}
 		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
@@ -266,9 +305,18 @@ func (c *Client) ValidateDevice(deviceID, jwt string) (*ValidateDeviceResponse,
 // Response types
 
 type DeviceRequestResponse struct {
-	ChallengeID string `json:"challenge_id"`
-	QRData      string `json:"qr_data"`
-	ExpiresIn   int    `json:"expires_in"`
+	ChallengeID  string `json:"challenge_id"`
+	QRData       string `json:"qr_data"`
+	ExpiresIn    int    `json:"expires_in"`
+	Enrolled     bool   `json:"enrolled,omitempty"`      // Approved with an enrollment key
+	AutoApproved bool   `json:"auto_approved,omitempty"` // Approved by the server's approval policy
+
+	RequiredApprovals int `json:"required_approvals,omitempty"`
+
+	// RFC 8628 user code, for approving without scanning the QR code
+	UserCode                string `json:"user_code,omitempty"`
+	VerificationURI         string `json:"verification_uri,omitempty"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
 }
 
 type PollResponse struct {
@@ -282,6 +330,13 @@ type PollResponse struct {
 	ServerPublicKey string      `json:"server_public_key,omitempty"`
 	ServerEndpoint  string      `json:"server_endpoint,omitempty"`
 	AllowedIPs      string      `json:"allowed_ips,omitempty"`
+	Enrollment      *Enrollment `json:"enrollment,omitempty"`
+}
+
+// Enrollment is what the enrollment key a device joined with set up for it
+type Enrollment struct {
+	Tags   []string `json:"tags"`
+	Tunnel bool     `json:"tunnel"`
 }
 
 type DeviceInfo struct {
@@ -293,8 +348,9 @@ type DeviceInfo struct {
 }
 
 type RefreshResponse struct {
-	JWT       string `json:"jwt"`
-	ExpiresAt string `json:"expires_at"`
+	JWT          string `json:"jwt"`
+	RefreshToken string `json:"refresh_token,omitempty"` // Rotated token; replaces the one sent
+	ExpiresAt    string `json:"expires_at"`
 }
 
 type SSHKey struct {
@@ -395,18 +451,39 @@ func (c *Client) RegisterTunnelKey(deviceID, publicKey, jwt string) error {
 
 // TunnelInfo contains information about a tunnel
 type TunnelInfo struct {
-	DeviceID   string `json:"device_id"`
-	DeviceName string `json:"device_name"`
-	Port       int    `json:"tunnel_port"`
-	VpnIP      string `json:"vpn_ip"`
-	LastSeen   string `json:"last_seen"`
-	Enabled    bool   `json:"enabled"`
-	Connected  bool   `json:"connected"`
+	DeviceID   string   `json:"device_id"`
+	DeviceName string   `json:"device_name"`
+	Port       int      `json:"tunnel_port"`
+	VpnIP      string   `json:"vpn_ip"`
+	LastSeen   string   `json:"last_seen"`
+	Enabled    bool     `json:"enabled"`
+	Connected  bool     `json:"connected"`
+	Transport  string   `json:"transport,omitempty"` // tcp or websocket while connected
+	Commands   []string `json:"commands,omitempty"`  // Remote commands the connected client handles
+
+	// Where the tunnel port listens; nil from servers that always listened
+	// publicly
+	Exposure *TunnelExposure `json:"exposure,omitempty"`
+}
+
+// TunnelExposure is where a device's tunnel port listens and who may
+// connect to it
+type TunnelExposure struct {
+	Bind         string   `json:"bind"` // vpn, loopback or public
+	Address      string   `json:"address,omitempty"`
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"` // Sources allowed when public (empty allows all)
+	Override     bool     `json:"override"`                // Set for this device rather than by the server
+}
+
+// Public reports whether the tunnel port is reachable from outside the server
+func (e *TunnelExposure) Public() bool {
+	return e == nil || e.Bind == "public"
 }
 
 // TunnelStatusResponse contains the tunnel status
 type TunnelStatusResponse struct {
-	Tunnels []TunnelInfo `json:"tunnels"`
+	Tunnels    []TunnelInfo `json:"tunnels"`
+	ServerHost string       `json:"server_host"`
 }
 
 // GetTunnelStatus gets the tunnel status from the server
@@ -437,6 +514,143 @@ func (c *Client) GetTunnelStatus(jwt string) (*TunnelStatusResponse, error) {
 	return &result, nil
 }
 
+// TunnelHostKey is an SSH host key of the tunnel server
+type TunnelHostKey struct {
+	Algorithm   string `json:"algorithm"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"` // authorized_keys format
+	Status      string `json:"status"`     // current, next or previous
+}
+
+// GetTunnelHostKeys fetches the tunnel server's host keys for pinning
+func (c *Client) GetTunnelHostKeys(jwt string) ([]TunnelHostKey, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/tunnel/host-keys", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result struct {
+		HostKeys []TunnelHostKey `json:"host_keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.HostKeys, nil
+}
+
+// SSHCA holds the server's SSH certificate authority public keys
+type SSHCA struct {
+	UserCA string `json:"user_ca"` // Trusted by sshd for user certificates
+	HostCA string `json:"host_ca"` // Trusted by ssh clients for host certificates
+}
+
+// SSHCertificate is a certificate signed by the server's SSH CA
+type SSHCertificate struct {
+	Certificate string    `json:"certificate"` // authorized_keys format
+	Principals  []string  `json:"principals"`
+	ValidBefore time.Time `json:"valid_before"`
+	HostCA      string    `json:"host_ca,omitempty"`
+}
+
+// GetSSHCA fetches the SSH CA public keys
+func (c *Client) GetSSHCA(jwt string) (*SSHCA, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/ssh/ca", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result SSHCA
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// IssueSSHCertificate asks for a short-lived certificate that lets publicKey
+// log in to deviceID as the device owner's OS user
+func (c *Client) IssueSSHCertificate(deviceID, publicKey, jwt string) (*SSHCertificate, error) {
+	return c.requestCertificate("/api/ssh/certificates", deviceID, publicKey, jwt)
+}
+
+// IssueSSHHostCertificate asks for a certificate of this device's sshd host key
+func (c *Client) IssueSSHHostCertificate(deviceID, publicKey, jwt string) (*SSHCertificate, error) {
+	return c.requestCertificate("/api/ssh/host-certificate", deviceID, publicKey, jwt)
+}
+
+// IssueTunnelCertificate asks for a certificate of the device's registered
+// tunnel key
+func (c *Client) IssueTunnelCertificate(deviceID, jwt string) (*SSHCertificate, error) {
+	return c.requestCertificate("/api/tunnel/certificate", deviceID, "", jwt)
+}
+
+func (c *Client) requestCertificate(path, deviceID, publicKey, jwt string) (*SSHCertificate, error) {
+	reqBody := map[string]string{
+		"device_id": deviceID,
+	}
+	if publicKey != "" {
+		reqBody["public_key"] = publicKey
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result SSHCertificate
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
 // TunnelRegisterResponse contains the response from tunnel registration
 type TunnelRegisterResponse struct {
 	TunnelPort int    `json:"tunnel_port"`
@@ -505,6 +719,43 @@ func (c *Client) EnableTunnel(deviceID, jwt string) error {
 	return nil
 }
 
+// SetTunnelExposure sets where a device's tunnel port listens; an empty bind
+// goes back to the server's setting
+func (c *Client) SetTunnelExposure(deviceID, jwt, bind string, allowedCIDRs []string) (*TunnelExposure, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"bind":          bind,
+		"allowed_cidrs": allowedCIDRs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("PATCH", c.baseURL+"/api/devices/"+deviceID+"/tunnel/exposure", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var exposure TunnelExposure
+	if err := json.NewDecoder(resp.Body).Decode(&exposure); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &exposure, nil
+}
+
 // DisableTunnel disables the tunnel for a device
 func (c *Client) DisableTunnel(deviceID, jwt string) error {
 	req, err := http.NewRequest("PATCH", c.baseURL+"/api/devices/"+deviceID+"/tunnel/disable", nil)
@@ -597,3 +848,817 @@ func (c *Client) UploadDiagnosticsReport(jwt string, report interface{}) error {
 
 	return nil
 }
+
+// LocalNetwork is a network found on the client by the pre-connect scan
+type LocalNetwork struct {
+	CIDR        string `json:"cidr"`
+	Source      string `json:"source"`
+	Description string `json:"description,omitempty"`
+}
+
+// ReportConflictsResponse represents the response from POST /api/network/conflicts
+type ReportConflictsResponse struct {
+	UserSubnet  string   `json:"user_subnet"`
+	Overlapping []string `json:"overlapping"`
+	Recorded    int      `json:"recorded"`
+}
+
+// ReportNetworkConflicts sends the local networks scanned on this device to the server
+func (c *Client) ReportNetworkConflicts(deviceID, jwt string, networks []LocalNetwork) (*ReportConflictsResponse, error) {
+	reqBody := map[string]interface{}{
+		"device_id": deviceID,
+		"networks":  networks,
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/network/conflicts", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result ReportConflictsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// RehomedDevice describes a device whose VPN IP changed during a re-home
+type RehomedDevice struct {
+	DeviceID string `json:"device_id"`
+	OldVpnIP string `json:"old_vpn_ip"`
+	NewVpnIP string `json:"new_vpn_ip"`
+}
+
+// RehomeSubnetResponse represents the response from POST /api/network/rehome
+type RehomeSubnetResponse struct {
+	OldSubnet string          `json:"old_subnet"`
+	NewSubnet string          `json:"new_subnet"`
+	Devices   []RehomedDevice `json:"devices"`
+}
+
+// RehomeSubnet asks the server to move the user's subnet away from reported conflicts
+func (c *Client) RehomeSubnet(jwt string) (*RehomeSubnetResponse, error) {
+	req, err := http.NewRequest("POST", c.baseURL+"/api/network/rehome", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result RehomeSubnetResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Device describes one of the user's devices as listed by the server
+type Device struct {
+	ID            string    `json:"id"`
+	DeviceName    string    `json:"device_name"`
+	DisplayName   *string   `json:"display_name,omitempty"`
+	OSType        string    `json:"os_type"`
+	VpnIP         string    `json:"vpn_ip"`
+	Username      *string   `json:"username,omitempty"`
+	DNSName       string    `json:"dns_name,omitempty"`
+	IsOnline      bool      `json:"is_online"`
+	LastSeen      time.Time `json:"last_seen"`
+	TunnelPort    *int      `json:"tunnel_port,omitempty"`
+	TunnelEnabled bool      `json:"tunnel_enabled"`
+}
+
+// ListDevicesResponse contains the user's devices and VPN DNS settings
+type ListDevicesResponse struct {
+	UserSubnet string   `json:"user_subnet"`
+	DNSServer  string   `json:"dns_server,omitempty"`
+	DNSZone    string   `json:"dns_zone,omitempty"`
+	Devices    []Device `json:"devices"`
+}
+
+// ListDevices lists all devices of the authenticated user
+func (c *Client) ListDevices(jwt string) (*ListDevicesResponse, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/devices", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result ListDevicesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Name returns the display name of a device, falling back to its device name
+func (d *Device) Name() string {
+	if d.DisplayName != nil && *d.DisplayName != "" {
+		return *d.DisplayName
+	}
+	return d.DeviceName
+}
+
+// RenameDevice sets the display name of a device (empty clears it)
+func (c *Client) RenameDevice(deviceID, jwt, displayName string) (*Device, error) {
+	reqBody := map[string]string{
+		"display_name": displayName,
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("PATCH", c.baseURL+"/api/devices/"+deviceID, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result Device
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// RunDeviceCommand runs a command on a device over its tunnel connection,
+// passing output lines to onOutput as the device sends them
+func (c *Client) RunDeviceCommand(deviceID, jwt, command string, args json.RawMessage, onOutput func(line string)) (*control.Event, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"command": command,
+		"args":    args,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/devices/"+deviceID+"/commands?stream=true", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	// Commands run for longer than the client's usual timeout
+	httpClient := *c.httpClient
+	httpClient.Timeout = 3 * time.Minute
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event control.Event
+		if err := decoder.Decode(&event); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		switch event.Type {
+		case control.EventOutput:
+			if onOutput != nil {
+				onOutput(event.Data)
+			}
+		case control.EventResult:
+			return &event, nil
+		}
+	}
+}
+
+// Route is a subnet route or exit node advertised by one of the user's devices
+type Route struct {
+	ID         string `json:"id"`
+	DeviceID   string `json:"device_id"`
+	DeviceName string `json:"device_name"`
+	VpnIP      string `json:"vpn_ip"`
+	CIDR       string `json:"cidr"`
+	Status     string `json:"status"` // "pending", "approved" or "rejected"
+	ExitNode   bool   `json:"exit_node"`
+}
+
+type routesResponse struct {
+	Routes []Route `json:"routes"`
+}
+
+// AdvertiseRoutes replaces the subnet routes (and exit node offer) of a device.
+// New routes stay pending until an admin approves them.
+func (c *Client) AdvertiseRoutes(deviceID, jwt string, routes []string, exitNode bool) ([]Route, error) {
+	if routes == nil {
+		routes = []string{}
+	}
+	reqBody := map[string]interface{}{
+		"routes":    routes,
+		"exit_node": exitNode,
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/devices/"+deviceID+"/routes", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result routesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Routes, nil
+}
+
+// ListRoutes lists the routes advertised by all of the user's devices
+func (c *Client) ListRoutes(jwt string) ([]Route, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/routes", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result routesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Routes, nil
+}
+
+// SetExitNode routes a device's internet traffic through another device.
+// An empty exitNodeID clears the exit node.
+func (c *Client) SetExitNode(deviceID, jwt, exitNodeID string) error {
+	reqBody := map[string]string{
+		"exit_node_id": exitNodeID,
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("PUT", c.baseURL+"/api/devices/"+deviceID+"/exit-node", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
+
+// Session is an active login of the user (one refresh token family)
+type Session struct {
+	ID         string  `json:"id"`
+	DeviceID   string  `json:"device_id"`
+	DeviceName string  `json:"device_name,omitempty"`
+	CreatedAt  string  `json:"created_at"`
+	LastUsedAt *string `json:"last_used_at,omitempty"`
+	LastUsedIP string  `json:"last_used_ip,omitempty"`
+	ExpiresAt  string  `json:"expires_at"`
+	Current    bool    `json:"current"` // The session this client is using
+}
+
+type sessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+type revokeSessionsResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// ListSessions lists the user's active logins
+func (c *Client) ListSessions(jwt string) ([]Session, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/sessions", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result sessionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Sessions, nil
+}
+
+// RevokeSession signs out one session. An empty sessionID signs out every
+// session except the caller's. Returns the number of sessions ended.
+func (c *Client) RevokeSession(sessionID, jwt string) (int64, error) {
+	url := c.baseURL + "/api/sessions"
+	if sessionID != "" {
+		url += "/" + sessionID
+	}
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result revokeSessionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Revoked, nil
+}
+
+// AuthProvider is a login method offered by the server
+type AuthProvider struct {
+	Name     string   `json:"name"` // firebase, oidc or local
+	Issuer   string   `json:"issuer,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+type AuthProvidersResponse struct {
+	Providers  []AuthProvider `json:"providers"`
+	EmailCodes bool           `json:"email_codes"`
+}
+
+// Provider returns the provider with the given name, or nil
+func (r *AuthProvidersResponse) Provider(name string) *AuthProvider {
+	for i := range r.Providers {
+		if r.Providers[i].Name == name {
+			return &r.Providers[i]
+		}
+	}
+	return nil
+}
+
+// LoginRequest logs in with an identity provider: an OIDC ID token (with the
+// nonce sent to the issuer) or a local account email and password
+type LoginRequest struct {
+	Provider string `json:"provider"`
+	IDToken  string `json:"id_token,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type LoginResponse struct {
+	JWT       string `json:"jwt"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// GetAuthProviders lists the login methods the server accepts
+func (c *Client) GetAuthProviders() (*AuthProvidersResponse, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/api/auth/providers")
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result AuthProvidersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Login exchanges identity provider credentials for a user JWT
+func (c *Client) Login(req LoginRequest) (*LoginResponse, error) {
+	body, _ := json.Marshal(req)
+
+	resp, err := c.httpClient.Post(
+		c.baseURL+"/api/auth/login",
+		"application/json",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Message string `json:"message"`
+		}
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Message != "" {
+			return nil, fmt.Errorf("%s", errResp.Message)
+		}
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result LoginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// ApproveDevice approves a device challenge as the user the JWT belongs to
+func (c *Client) ApproveDevice(challengeID, jwt string) (*ReviewChallengeResponse, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"challenge_id": challengeID,
+		"approved":     true,
+	})
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/device-auth/approve", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result ReviewChallengeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// DeviceChallenge is a pending request from a device to join the user's network
+type DeviceChallenge struct {
+	ID                string   `json:"id"`
+	DeviceID          string   `json:"device_id"`
+	Hostname          string   `json:"hostname"`
+	OSType            string   `json:"os_type,omitempty"`
+	Username          string   `json:"username,omitempty"`
+	HardwareID        string   `json:"hardware_id,omitempty"`
+	IPAddress         string   `json:"ip_address"`
+	RequestedUser     string   `json:"requested_user,omitempty"`
+	UserCode          string   `json:"user_code,omitempty"`
+	Approvals         int      `json:"approvals"`
+	RequiredApprovals int      `json:"required_approvals"`
+	ApprovedBy        []string `json:"approved_by"`
+	CreatedAt         string   `json:"created_at"`
+	ExpiresAt         string   `json:"expires_at"`
+}
+
+// ReviewChallengeResponse is the state of a device challenge after approving or denying it
+type ReviewChallengeResponse struct {
+	Status            string `json:"status"` // pending (more approvers needed), approved or denied
+	Approvals         int    `json:"approvals"`
+	RequiredApprovals int    `json:"required_approvals"`
+}
+
+// ListDeviceChallenges lists pending device requests for the user's network
+func (c *Client) ListDeviceChallenges(jwt string) ([]DeviceChallenge, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/device-auth/challenges", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result struct {
+		Challenges []DeviceChallenge `json:"challenges"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Challenges, nil
+}
+
+// LookupDeviceChallenge finds a pending device request by the code the device displays
+func (c *Client) LookupDeviceChallenge(userCode, jwt string) (*DeviceChallenge, error) {
+	reqBody, err := json.Marshal(map[string]string{"user_code": userCode})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/device-auth/challenges/lookup", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result struct {
+		Challenges []DeviceChallenge `json:"challenges"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(result.Challenges) == 0 {
+		return nil, fmt.Errorf("no device request for code %s", userCode)
+	}
+
+	return &result.Challenges[0], nil
+}
+
+// ReviewDeviceChallenge approves or denies a pending device request
+func (c *Client) ReviewDeviceChallenge(challengeID string, approve bool, jwt string) (*ReviewChallengeResponse, error) {
+	action := "deny"
+	if approve {
+		action = "approve"
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/device-auth/challenges/"+challengeID+"/"+action, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result ReviewChallengeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// EnrollmentKey is a pre-authorized key for enrolling devices
+type EnrollmentKey struct {
+	ID          string   `json:"id"`
+	KeyPrefix   string   `json:"key_prefix"`
+	Description string   `json:"description,omitempty"`
+	MaxUses     *int     `json:"max_uses,omitempty"` // nil for reusable keys
+	Uses        int      `json:"uses"`
+	Tags        []string `json:"tags"`
+	Tunnel      bool     `json:"tunnel"`
+	Ephemeral   bool     `json:"ephemeral"`
+	Status      string   `json:"status"`
+	CreatedAt   string   `json:"created_at"`
+	ExpiresAt   string   `json:"expires_at"`
+	LastUsedAt  *string  `json:"last_used_at,omitempty"`
+}
+
+type CreateEnrollmentKeyRequest struct {
+	Description string   `json:"description,omitempty"`
+	Reusable    bool     `json:"reusable,omitempty"`
+	MaxUses     int      `json:"max_uses,omitempty"`
+	ExpiresIn   int64    `json:"expires_in,omitempty"` // Seconds
+	Tags        []string `json:"tags,omitempty"`
+	Tunnel      bool     `json:"tunnel,omitempty"`
+	Ephemeral   bool     `json:"ephemeral,omitempty"`
+}
+
+type CreateEnrollmentKeyResponse struct {
+	Key           string        `json:"key"`
+	EnrollmentKey EnrollmentKey `json:"enrollment_key"`
+}
+
+type enrollmentKeysResponse struct {
+	Keys []EnrollmentKey `json:"keys"`
+}
+
+// ListEnrollmentKeys lists the user's enrollment keys that haven't been revoked
+func (c *Client) ListEnrollmentKeys(jwt string) ([]EnrollmentKey, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/enrollment-keys", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result enrollmentKeysResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Keys, nil
+}
+
+// CreateEnrollmentKey creates an enrollment key. The key is only returned once.
+func (c *Client) CreateEnrollmentKey(keyReq CreateEnrollmentKeyRequest, jwt string) (*CreateEnrollmentKeyResponse, error) {
+	body, _ := json.Marshal(keyReq)
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/enrollment-keys", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result CreateEnrollmentKeyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// RevokeEnrollmentKey stops a key from enrolling more devices
+func (c *Client) RevokeEnrollmentKey(keyID, jwt string) error {
+	req, err := http.NewRequest("DELETE", c.baseURL+"/api/enrollment-keys/"+keyID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfterWait is the longest Retry-After the client waits out on its
+// own before retrying; longer delays are returned as a RateLimitError
+const maxRetryAfterWait = 3 * time.Second
+
+// RateLimitError is returned when the server rate limits a request
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited by server, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// retryAfterTransport honors 429 and 503 responses with a Retry-After header:
+// short delays are waited out and the request retried once, longer ones fail
+// with a RateLimitError instead of hammering the server
+type retryAfterTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	retryAfter, ok := parseRetryAfter(resp)
+	if !ok {
+		return resp, nil
+	}
+
+	// Retry once if the delay is short and the body can be sent again
+	if retryAfter <= maxRetryAfterWait && (req.Body == nil || req.GetBody != nil) {
+		resp.Body.Close()
+
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retry.Body = body
+		}
+
+		resp, err = t.next.RoundTrip(retry)
+		if err != nil {
+			return resp, err
+		}
+		if retryAfter, ok = parseRetryAfter(resp); !ok {
+			return resp, nil
+		}
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, &RateLimitError{RetryAfter: retryAfter}
+}
+
+// parseRetryAfter returns the delay of a rate limited (429) or unavailable
+// (503) response with a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
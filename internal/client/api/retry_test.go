@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterTransport(t *testing.T) {
+	var calls int
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+
+		switch r.URL.Path {
+		case "/short":
+			if calls == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "/long":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).httpClient
+
+	// Test: short delays are waited out and the request is sent again, body included
+	resp, err := client.Post(server.URL+"/short", "application/json", strings.NewReader(`{"a":1}`))
+	if err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls != 2 {
+		t.Fatalf("Expected 200 after one retry, got %d after %d calls", resp.StatusCode, calls)
+	}
+	if bodies[1] != `{"a":1}` {
+		t.Errorf("Retry lost the request body: %q", bodies[1])
+	}
+
+	// Test: long delays are returned to the caller
+	calls = 0
+	_, err = client.Get(server.URL + "/long")
+	var rateLimited *RateLimitError
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("Expected a RateLimitError, got %v", err)
+	}
+	if rateLimited.RetryAfter != 120*time.Second || calls != 1 {
+		t.Errorf("Expected a 120s delay without retrying, got %s after %d calls", rateLimited.RetryAfter, calls)
+	}
+}
@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+)
+
+// SyncDNSSettings fetches the VPN resolver address and zone from the server
+// and saves them when they changed. Servers without DNS clear the settings.
+func SyncDNSSettings(cfg *config.Config) error {
+	client := api.NewClient(cfg.ServerURL)
+	resp, err := client.ListDevices(cfg.JWT)
+	if err != nil {
+		return fmt.Errorf("failed to fetch DNS settings: %w", err)
+	}
+
+	if resp.DNSServer == cfg.DNSServer && resp.DNSZone == cfg.DNSZone {
+		return nil
+	}
+
+	cfg.DNSServer = resp.DNSServer
+	cfg.DNSZone = resp.DNSZone
+	return cfg.Save()
+}
@@ -1,13 +1,17 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/kamikazebr/roamie-desktop/internal/client/api"
 	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/oidc"
 	"github.com/kamikazebr/roamie-desktop/internal/client/sshd"
 	"github.com/kamikazebr/roamie-desktop/internal/client/tunnel"
 	"github.com/kamikazebr/roamie-desktop/internal/client/ui"
@@ -18,7 +22,17 @@ import (
 	"github.com/skip2/go-qrcode"
 )
 
-func Login(serverURL string) error {
+// Login methods
+const (
+	LoginMethodQR       = "qr"       // Approve from the Roamie app
+	LoginMethodOIDC     = "oidc"     // Log in with the server's OIDC issuer in a browser
+	LoginMethodPassword = "password" // Local account email and password
+)
+
+// Login registers this device, approving it with the given method. An empty
+// method picks one from what the server offers. With an enrollment key the
+// device is approved without any interaction and method is ignored.
+func Login(serverURL, method, enrollKey, email string) error {
 	// Use default if not provided
 	if serverURL == "" {
 		serverURL = "http://10.100.0.1:8081"
@@ -32,6 +46,10 @@ func Login(serverURL string) error {
 	if wireguard.CheckInstalled() {
 		fmt.Println("\n✓ WireGuard is installed - VPN will be enabled")
 		enableVPN = true
+	} else if enrollKey != "" {
+		// Unattended: never prompt or install packages
+		fmt.Println("\n→ WireGuard not installed - continuing with SSH Tunnel only")
+		fmt.Println("  To enable VPN later: roamie vpn install")
 	} else {
 		// WireGuard not installed - ask user if they want to install it
 		fmt.Println("\n→ SSH Tunnel will be enabled automatically.")
@@ -58,16 +76,23 @@ func Login(serverURL string) error {
 
 	// Check SSH server availability (for tunnel)
 	fmt.Println("\n→ Checking SSH server availability...")
-	enableSSHTunnel, err := sshd.PromptInstall()
-	if err != nil {
-		fmt.Printf("⚠️  SSH check error: %v\n", err)
-		enableSSHTunnel = false
+	var enableSSHTunnel bool
+	var err error
+	if enrollKey != "" {
+		enableSSHTunnel = sshd.IsRunning()
+	} else {
+		enableSSHTunnel, err = sshd.PromptInstall()
+		if err != nil {
+			fmt.Printf("⚠️  SSH check error: %v\n", err)
+			enableSSHTunnel = false
+		}
 	}
 	if enableSSHTunnel {
 		fmt.Println("✓ SSH server is available")
 	} else {
 		fmt.Println("⚠️  SSH server not available. SSH tunnel will be skipped.")
 		fmt.Println("   You can enable it later with: roamie tunnel register")
+		fmt.Println("   (or without sshd: roamie tunnel register --embedded-ssh)")
 	}
 
 	// Generate device ID
@@ -108,26 +133,142 @@ func Login(serverURL string) error {
 
 	// Request device challenge with public key, username, os_type, and hardware_id
 	fmt.Println("→ Requesting device authorization...")
-	challenge, err := client.CreateDeviceRequest(deviceID.String(), hostname, username, publicKey, osType, hardwareID)
+	challenge, err := client.CreateDeviceRequest(deviceID.String(), hostname, username, publicKey, osType, hardwareID, enrollKey, email)
 	if err != nil {
+		if enrollKey != "" {
+			return fmt.Errorf("enrollment failed: %w", err)
+		}
 		return fmt.Errorf("failed to create challenge: %w", err)
 	}
 
+	if challenge.Enrolled {
+		fmt.Println("✓ Device enrolled with enrollment key")
+		return pollForApproval(client, challenge.ChallengeID, deviceID.String(), privateKey, publicKey, serverURL, enableVPN, enableSSHTunnel)
+	}
+	if challenge.AutoApproved {
+		fmt.Println("✓ Device approved automatically (trusted network)")
+		return pollForApproval(client, challenge.ChallengeID, deviceID.String(), privateKey, publicKey, serverURL, enableVPN, enableSSHTunnel)
+	}
+
 	fmt.Printf("✓ Challenge created: %s\n", challenge.ChallengeID)
 	fmt.Printf("✓ Expires in: %d seconds\n\n", challenge.ExpiresIn)
-
-	// Display QR code
-	fmt.Println("Scan this QR code with Roamie app:")
+	if email != "" {
+		fmt.Printf("  %s can also approve it from another device: roamie auth requests\n", email)
+	}
+	if challenge.RequiredApprovals > 1 {
+		fmt.Printf("  This device needs %d approvals (you and an administrator)\n", challenge.RequiredApprovals)
+	}
 	fmt.Println()
-	displayQRCode(challenge.QRData)
 
-	fmt.Printf("\nOr open this URL manually:\n%s\n\n", challenge.QRData)
+	providers, err := client.GetAuthProviders()
+	if err != nil {
+		// Older servers only support QR approval
+		providers = &api.AuthProvidersResponse{}
+	}
+	if method == "" {
+		method = chooseLoginMethod(providers)
+	}
+
+	switch method {
+	case LoginMethodQR:
+		// Display QR code
+		fmt.Println("Scan this QR code with Roamie app:")
+		fmt.Println()
+		displayQRCode(challenge.QRData)
+
+		fmt.Printf("\nOr open this URL manually:\n%s\n\n", challenge.QRData)
+
+		if challenge.UserCode != "" {
+			fmt.Printf("Can't scan it? Enter code %s at %s\n", challenge.UserCode, challenge.VerificationURI)
+			fmt.Printf("or run 'roamie auth verify %s' on a device that is already logged in\n\n", challenge.UserCode)
+		}
+
+	case LoginMethodOIDC, LoginMethodPassword:
+		if err := approveChallenge(client, providers, method, challenge.ChallengeID); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown login method %q (use qr, oidc or password)", method)
+	}
+
 	fmt.Println("Waiting for authorization...")
 
 	// Poll for approval with private key for auto-connection
 	return pollForApproval(client, challenge.ChallengeID, deviceID.String(), privateKey, publicKey, serverURL, enableVPN, enableSSHTunnel)
 }
 
+// chooseLoginMethod prefers the Roamie app when the server has Firebase, then
+// OIDC, then local accounts
+func chooseLoginMethod(providers *api.AuthProvidersResponse) string {
+	switch {
+	case providers.Provider("firebase") != nil:
+		return LoginMethodQR
+	case providers.Provider("oidc") != nil:
+		return LoginMethodOIDC
+	case providers.Provider("local") != nil:
+		return LoginMethodPassword
+	}
+	return LoginMethodQR
+}
+
+// approveChallenge logs in with an identity provider and approves this
+// device's challenge as that user, so the poll below picks up the tokens
+func approveChallenge(client *api.Client, providers *api.AuthProvidersResponse, method, challengeID string) error {
+	var req api.LoginRequest
+
+	switch method {
+	case LoginMethodOIDC:
+		provider := providers.Provider("oidc")
+		if provider == nil {
+			return fmt.Errorf("server does not support OIDC login")
+		}
+
+		fmt.Printf("→ Logging in with %s\n", provider.Issuer)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		result, err := oidc.Authorize(ctx, provider.Issuer, provider.ClientID, provider.Scopes, func(authURL string) {
+			fmt.Printf("\nOpen this URL in your browser to log in:\n%s\n\n", authURL)
+			oidc.OpenBrowser(authURL)
+		})
+		if err != nil {
+			return err
+		}
+		req = api.LoginRequest{Provider: "oidc", IDToken: result.IDToken, Nonce: result.Nonce}
+
+	case LoginMethodPassword:
+		if providers.Provider("local") == nil {
+			return fmt.Errorf("server does not support password login")
+		}
+
+		email, err := ui.Input("Email", false)
+		if err != nil {
+			return err
+		}
+		password, err := ui.Input("Password", true)
+		if err != nil {
+			return err
+		}
+		req = api.LoginRequest{Provider: "local", Email: strings.TrimSpace(email), Password: password}
+	}
+
+	login, err := client.Login(req)
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	fmt.Println("✓ Logged in")
+
+	result, err := client.ApproveDevice(challengeID, login.JWT)
+	if err != nil {
+		return fmt.Errorf("failed to approve device: %w", err)
+	}
+	if result.Status == "pending" {
+		fmt.Printf("✓ Approval recorded (%d/%d), waiting for an administrator to approve\n", result.Approvals, result.RequiredApprovals)
+	}
+	return nil
+}
+
 func displayQRCode(data string) {
 	qr, err := qrcode.New(data, qrcode.Medium)
 	if err != nil {
@@ -140,8 +281,10 @@ func displayQRCode(data string) {
 }
 
 func pollForApproval(client *api.Client, challengeID, deviceID, privateKey, publicKey, serverURL string, enableVPN, enableSSHTunnel bool) error {
-	ticker := time.NewTicker(5 * time.Second)
+	const pollInterval = 5 * time.Second
+	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
+	backedOff := false
 
 	timeout := time.After(5 * time.Minute)
 
@@ -153,9 +296,25 @@ func pollForApproval(client *api.Client, challengeID, deviceID, privateKey, publ
 		case <-ticker.C:
 			resp, err := client.PollChallenge(challengeID)
 			if err != nil {
+				var rateLimited *api.RateLimitError
+				if errors.As(err, &rateLimited) {
+					// Back off as the server asked, then resume the usual pace
+					wait := rateLimited.RetryAfter
+					if wait < pollInterval {
+						wait = pollInterval
+					}
+					fmt.Printf("Server is busy, polling again in %s\n", wait.Round(time.Second))
+					ticker.Reset(wait)
+					backedOff = true
+					continue
+				}
 				fmt.Printf("Poll error: %v\n", err)
 				continue
 			}
+			if backedOff {
+				ticker.Reset(pollInterval)
+				backedOff = false
+			}
 
 			switch resp.Status {
 			case "approved":
@@ -168,14 +327,12 @@ func pollForApproval(client *api.Client, challengeID, deviceID, privateKey, publ
 				cfg := &config.Config{
 					ServerURL:       serverURL,
 					DeviceID:        deviceID,
-					JWT:             resp.JWT,
-					RefreshToken:    resp.RefreshToken,
-					ExpiresAt:       expiresAt,
 					CreatedAt:       time.Now(),
 					SSHSyncEnabled:  true,             // Enable SSH sync by default
 					SSHSyncInterval: 5 * time.Minute,  // Default 5 minute interval
 					VPNEnabled:      enableVPN,        // User's VPN choice
 				}
+				cfg.SetSession(resp.JWT, resp.RefreshToken, expiresAt)
 
 				// Check if device was auto-registered and save device info
 				if resp.AutoRegistered && resp.Device != nil {
@@ -197,7 +354,7 @@ func pollForApproval(client *api.Client, challengeID, deviceID, privateKey, publ
 				fmt.Printf("✓ Configuration saved to %s\n", configDir)
 				fmt.Printf("✓ JWT expires: %s (%s)\n",
 					expiresAt.Format("2006-01-02 15:04:05"),
-					time.Until(expiresAt).Round(time.Hour),
+					time.Until(expiresAt).Round(time.Minute),
 				)
 
 				// Show device registration info
@@ -206,8 +363,12 @@ func pollForApproval(client *api.Client, challengeID, deviceID, privateKey, publ
 					fmt.Printf("  Device Name: %s\n", resp.Device.DeviceName)
 					fmt.Printf("  VPN IP: %s\n", resp.Device.VpnIP)
 
-					// Auto-register SSH tunnel (only if SSH was available during preflight)
-					if enableSSHTunnel {
+					// Auto-register SSH tunnel (only if SSH was available during
+					// preflight, and the enrollment key asks for it)
+					if resp.Enrollment != nil && !resp.Enrollment.Tunnel {
+						fmt.Println("\n→ SSH tunnel not enabled by the enrollment key")
+						fmt.Println("  Enable later with: roamie tunnel register")
+					} else if enableSSHTunnel {
 						tunnelPort, err := autoRegisterTunnel(cfg)
 						if err != nil {
 							fmt.Printf("\n⚠️  Failed to register SSH tunnel: %v\n", err)
@@ -274,12 +435,22 @@ func pollForApproval(client *api.Client, challengeID, deviceID, privateKey, publ
 func autoConnectVPN(cfg *config.Config) error {
 	fmt.Println("\n→ Connecting to VPN...")
 
+	if err := SyncDNSSettings(cfg); err != nil {
+		fmt.Printf("⚠️  %v (device names will not resolve)\n", err)
+	}
+
 	wgConfig := wireguard.WireGuardConfig{
 		PrivateKey: cfg.PrivateKey,
 		Address:    cfg.VpnIP,
 		ServerKey:  cfg.ServerPublicKey,
 		Endpoint:   cfg.ServerEndpoint,
 		AllowedIPs: cfg.AllowedIPs,
+		DNS:        cfg.DNSServer,
+		DNSZone:    cfg.DNSZone,
+	}
+
+	if err := ApplyRouteSettings(cfg, &wgConfig); err != nil {
+		fmt.Printf("⚠️  %v (connecting without subnet routes or exit node)\n", err)
 	}
 
 	if err := wireguard.Connect("roamie", wgConfig); err != nil {
@@ -345,5 +516,11 @@ func autoRegisterTunnel(cfg *config.Config) (int, error) {
 		return 0, fmt.Errorf("failed to enable tunnel: %w", err)
 	}
 
+	// Pin the server's host keys (saved with the tunnel config) so every
+	// tunnel connection is verified
+	if err := tunnel.PinHostKeys(cfg); err != nil {
+		fmt.Printf("⚠️  Failed to pin tunnel server host key: %v\n", err)
+	}
+
 	return registerResp.TunnelPort, nil
 }
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+)
+
+// RefreshMargin is how long before expiry an access token gets renewed.
+// Access tokens live for minutes, so this is checked before every command
+// and every minute by the daemon.
+const RefreshMargin = 5 * time.Minute
+
+// RefreshSession exchanges the refresh token for a new access token and the
+// rotated refresh token, and saves both. Returns api.ErrDeviceDeleted when the
+// server revoked this device.
+func RefreshSession(cfg *config.Config) error {
+	if cfg.RefreshToken == "" {
+		return fmt.Errorf("no refresh token, please run 'roamie auth login'")
+	}
+
+	client := api.NewClient(cfg.ServerURL)
+	resp, err := client.RefreshJWT(cfg.RefreshToken)
+	if errors.Is(err, api.ErrRefreshTokenRotated) {
+		// The daemon and a CLI command refreshed at the same time; the
+		// other one holds the new token and saves it shortly
+		return adoptConcurrentRefresh(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	expiresAt, _ := time.Parse("2006-01-02T15:04:05Z", resp.ExpiresAt)
+	cfg.SetSession(resp.JWT, resp.RefreshToken, expiresAt)
+
+	if err := cfg.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
+
+// EnsureFreshToken refreshes the access token if it expires within RefreshMargin
+func EnsureFreshToken(cfg *config.Config) error {
+	if cfg.JWT == "" || cfg.ExpiresIn() > RefreshMargin {
+		return nil
+	}
+	return RefreshSession(cfg)
+}
+
+// adoptConcurrentRefresh waits for the process that won the refresh race to
+// save its session and loads it
+func adoptConcurrentRefresh(cfg *config.Config) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		latest, err := config.Load()
+		if err == nil && latest != nil && latest.RefreshToken != cfg.RefreshToken {
+			cfg.SetSession(latest.JWT, latest.RefreshToken, latest.ExpiresAt)
+			cfg.SessionUpdatedAt = latest.SessionUpdatedAt
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return api.ErrRefreshTokenRotated
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/devices"
+	"github.com/kamikazebr/roamie-desktop/internal/client/wireguard"
+)
+
+// ApplyRouteSettings adjusts a WireGuard config for subnet routes and exit
+// nodes. A device advertising routes forwards and NATs traffic from the
+// user's subnet; a device using an exit node or accepting routes gets the
+// extra AllowedIPs from the server's approved routes.
+func ApplyRouteSettings(cfg *config.Config, wgConfig *wireguard.WireGuardConfig) error {
+	if (len(cfg.AdvertiseRoutes) > 0 || cfg.AdvertiseExitNode) && cfg.Subnet != "" {
+		wgConfig.ForwardSubnet = cfg.Subnet
+	}
+
+	if cfg.ExitNodeID == "" && !cfg.AcceptRoutes {
+		return nil
+	}
+
+	client := api.NewClient(cfg.ServerURL)
+	routes, err := client.ListRoutes(cfg.JWT)
+	if err != nil {
+		return fmt.Errorf("failed to fetch routes: %w", err)
+	}
+
+	allowedIPs, err := devices.AllowedIPs(wgConfig.AllowedIPs, routes, cfg.DeviceID, cfg.ExitNodeID, cfg.AcceptRoutes)
+	if err != nil {
+		return err
+	}
+	wgConfig.AllowedIPs = allowedIPs
+	return nil
+}
@@ -3,10 +3,12 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/kamikazebr/roamie-desktop/internal/client/secrets"
 	"github.com/kamikazebr/roamie-desktop/pkg/utils"
 )
 
@@ -36,6 +38,10 @@ type Config struct {
 	SSHSyncEnabled  bool          `json:"ssh_sync_enabled"`
 	SSHSyncInterval time.Duration `json:"ssh_sync_interval"`
 
+	// When JWT/RefreshToken were last issued. Refresh tokens rotate on every
+	// use, so Save never replaces a newer session with an older copy.
+	SessionUpdatedAt time.Time `json:"session_updated_at,omitempty"`
+
 	// WireGuard Device Info
 	DeviceName      string `json:"device_name,omitempty"`
 	PrivateKey      string `json:"private_key,omitempty"`
@@ -46,13 +52,35 @@ type Config struct {
 	ServerEndpoint  string `json:"server_endpoint,omitempty"`
 	AllowedIPs      string `json:"allowed_ips,omitempty"`
 
+	// VPN DNS (device names like laptop.alice.roamie.internal)
+	DNSServer string `json:"dns_server,omitempty"`
+	DNSZone   string `json:"dns_zone,omitempty"`
+
+	// Subnet routes and exit nodes
+	AdvertiseRoutes   []string `json:"advertise_routes,omitempty"`    // LAN ranges this device offers to route
+	AdvertiseExitNode bool     `json:"advertise_exit_node,omitempty"` // Offer this device as an internet exit
+	ExitNodeID        string   `json:"exit_node_id,omitempty"`        // Device carrying this device's internet traffic
+	AcceptRoutes      bool     `json:"accept_routes,omitempty"`       // Route approved subnets of other devices
+
 	// SSH Tunnel Configuration
-	TunnelEnabled bool `json:"tunnel_enabled"`
-	TunnelPort    int  `json:"tunnel_port,omitempty"`
+	TunnelEnabled   bool     `json:"tunnel_enabled"`
+	TunnelPort      int      `json:"tunnel_port,omitempty"`
+	TunnelHostKeys  []string `json:"tunnel_host_keys,omitempty"` // Pinned server host keys (authorized_keys format)
+	TunnelTransport string   `json:"tunnel_transport,omitempty"` // auto (default), tcp or websocket
+
+	// Embedded SSH server for hosts without sshd (the tunnel falls back to it)
+	EmbeddedSSH     bool `json:"embedded_ssh,omitempty"`
+	EmbeddedSSHPort int  `json:"embedded_ssh_port,omitempty"`
 
 	// VPN Configuration (optional, user can opt-in)
 	VPNEnabled bool `json:"vpn_enabled"`
 
+	// Userspace VPN mode (no root, TUN device or kernel module; reached via proxies)
+	UserspaceEnabled  bool     `json:"userspace_enabled"`
+	UserspaceSOCKS    string   `json:"userspace_socks,omitempty"`
+	UserspaceHTTP     string   `json:"userspace_http,omitempty"`
+	UserspaceForwards []string `json:"userspace_forwards,omitempty"`
+
 	// Auto-upgrade settings
 	AutoUpgradeEnabled bool      `json:"auto_upgrade_enabled"`
 	LastUpgradeCheck   time.Time `json:"last_upgrade_check,omitempty"`
@@ -60,6 +88,36 @@ type Config struct {
 	// For notification after background update
 	LastBackgroundUpdate *BackgroundUpdateInfo `json:"last_background_update,omitempty"`
 	InfoMessageShown     bool                  `json:"info_message_shown"`
+
+	// Where JWT, RefreshToken and PrivateKey are kept (e.g. "keyring:jwt"),
+	// so config.json doesn't hold them in plaintext
+	SecretRefs map[string]string `json:"secret_refs,omitempty"`
+
+	// Secret values as loaded, so Save only writes the ones that changed
+	storedSecrets map[string]string
+}
+
+// secretFields returns the secret fields of c by secret name
+func (c *Config) secretFields() map[string]*string {
+	return map[string]*string{
+		"jwt":           &c.JWT,
+		"refresh_token": &c.RefreshToken,
+		"private_key":   &c.PrivateKey,
+	}
+}
+
+// HasPlaintextSecrets reports whether config.json holds secrets in plaintext
+func HasPlaintextSecrets() (bool, error) {
+	config, err := loadFile()
+	if err != nil || config == nil {
+		return false, err
+	}
+	for _, field := range config.secretFields() {
+		if *field != "" {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 // BackgroundUpdateInfo stores info about a background update for notification
@@ -79,8 +137,20 @@ func DefaultConfig() *Config {
 	}
 }
 
-// Load loads the configuration from disk
+// Load loads the configuration from disk, reading secrets from the secrets store
 func Load() (*Config, error) {
+	config, err := loadFile()
+	if err != nil || config == nil {
+		return config, err
+	}
+	if err := config.loadSecrets(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadFile loads config.json without resolving secret references
+func loadFile() (*Config, error) {
 	configDir, err := GetConfigDir()
 	if err != nil {
 		return nil, err
@@ -132,7 +202,27 @@ func (c *Config) Save() error {
 
 	configPath := filepath.Join(configDir, ConfigFile)
 
-	data, err := json.MarshalIndent(c, "", "  ")
+	// Another process (daemon or CLI) may have refreshed the session since
+	// this copy was loaded; writing back the old, already rotated refresh
+	// token would log the device out the next time it is used
+	if onDisk, err := loadFile(); err == nil && onDisk != nil && onDisk.SessionUpdatedAt.After(c.SessionUpdatedAt) {
+		if err := onDisk.loadSecrets(); err == nil {
+			c.JWT = onDisk.JWT
+			c.RefreshToken = onDisk.RefreshToken
+			c.ExpiresAt = onDisk.ExpiresAt
+			c.SessionUpdatedAt = onDisk.SessionUpdatedAt
+			for _, name := range []string{"jwt", "refresh_token"} {
+				c.setStoredSecret(name, onDisk.SecretRefs[name], onDisk.storedSecrets[name])
+			}
+		}
+	}
+
+	onDiskConfig, err := c.saveSecrets(configDir)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(onDiskConfig, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -149,13 +239,163 @@ func (c *Config) Save() error {
 	return nil
 }
 
-// Delete deletes the configuration file
+// loadSecrets fills in the secret fields from SecretRefs
+func (c *Config) loadSecrets() error {
+	if len(c.SecretRefs) == 0 {
+		return nil
+	}
+
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return err
+	}
+	store, err := secrets.Open(configDir)
+	if err != nil {
+		return err
+	}
+
+	fields := c.secretFields()
+	for name, ref := range c.SecretRefs {
+		field, ok := fields[name]
+		if !ok || *field != "" {
+			continue
+		}
+		value, err := store.Get(ref)
+		if err != nil {
+			return fmt.Errorf("failed to read %s from secrets (%s): %w", name, ref, err)
+		}
+		*field = value
+		c.setStoredSecret(name, ref, value)
+	}
+	return nil
+}
+
+// saveSecrets moves changed secret fields into the secrets store and returns
+// the copy of c to write to config.json, which holds only their references
+func (c *Config) saveSecrets(configDir string) (*Config, error) {
+	store, err := secrets.Open(configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	onDisk := *c
+	onDisk.SecretRefs = make(map[string]string)
+	onDiskFields := onDisk.secretFields()
+
+	for name, field := range c.secretFields() {
+		value, ref := *field, c.SecretRefs[name]
+
+		switch {
+		case value == "" || !store.Enabled():
+			// Cleared (e.g. logout), or secrets are kept in plaintext
+			if ref != "" {
+				if err := store.Delete(ref); err != nil {
+					log.Printf("Warning: failed to delete %s from secrets: %v", name, err)
+				}
+				c.setStoredSecret(name, "", "")
+			}
+			continue
+
+		case ref != "" && c.storedSecrets[name] == value:
+			// Unchanged
+			*onDiskFields[name] = ""
+			onDisk.SecretRefs[name] = ref
+			continue
+		}
+
+		newRef, err := store.Put(name, value)
+		if err != nil {
+			return nil, err
+		}
+		if ref != "" && ref != newRef {
+			store.Delete(ref)
+		}
+		c.setStoredSecret(name, newRef, value)
+		*onDiskFields[name] = ""
+		onDisk.SecretRefs[name] = newRef
+	}
+
+	if len(onDisk.SecretRefs) == 0 {
+		onDisk.SecretRefs = nil
+	}
+	return &onDisk, nil
+}
+
+// setStoredSecret records that value is stored at ref ("" forgets the secret)
+func (c *Config) setStoredSecret(name, ref, value string) {
+	if c.SecretRefs == nil {
+		c.SecretRefs = make(map[string]string)
+	}
+	if c.storedSecrets == nil {
+		c.storedSecrets = make(map[string]string)
+	}
+	if ref == "" {
+		delete(c.SecretRefs, name)
+		delete(c.storedSecrets, name)
+		return
+	}
+	c.SecretRefs[name] = ref
+	c.storedSecrets[name] = value
+}
+
+// MigrateSecrets moves plaintext secrets out of config.json into the
+// secrets store. It returns true if anything was migrated.
+func MigrateSecrets() (bool, error) {
+	plaintext, err := HasPlaintextSecrets()
+	if err != nil || !plaintext {
+		return false, err
+	}
+
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return false, err
+	}
+	store, err := secrets.Open(configDir)
+	if err != nil || !store.Enabled() {
+		return false, err
+	}
+
+	return true, MoveSecrets()
+}
+
+// MoveSecrets stores all secrets again in the current secrets backend (or in
+// config.json if it is "plaintext"), removing them from where they were
+func MoveSecrets() error {
+	cfg, err := Load()
+	if err != nil || cfg == nil {
+		return err
+	}
+	cfg.storedSecrets = nil
+	return cfg.Save()
+}
+
+// SetSession stores a newly issued access token and refresh token
+func (c *Config) SetSession(jwt, refreshToken string, expiresAt time.Time) {
+	c.JWT = jwt
+	if refreshToken != "" {
+		c.RefreshToken = refreshToken
+	}
+	c.ExpiresAt = expiresAt
+	c.SessionUpdatedAt = time.Now()
+}
+
+// Delete deletes the configuration file and the secrets it refers to
 func Delete() error {
 	configDir, err := GetConfigDir()
 	if err != nil {
 		return err
 	}
 
+	if cfg, err := loadFile(); err == nil && cfg != nil && len(cfg.SecretRefs) > 0 {
+		if store, err := secrets.Open(configDir); err == nil {
+			for name, ref := range cfg.SecretRefs {
+				if err := store.Delete(ref); err != nil {
+					log.Printf("Warning: failed to delete %s from secrets: %v", name, err)
+				}
+			}
+		}
+	}
+
 	configPath := filepath.Join(configDir, ConfigFile)
 	return os.Remove(configPath)
 }
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/secrets"
+)
+
+func setupTestHome(t *testing.T, backend string) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SUDO_USER", "")
+	t.Setenv(secrets.BackendEnv, backend)
+	t.Setenv(secrets.PassphraseEnv, "")
+	return filepath.Join(home, ".roamie")
+}
+
+func TestSave_KeepsSecretsOutOfConfigFile(t *testing.T) {
+	configDir := setupTestHome(t, secrets.BackendFile)
+
+	cfg := DefaultConfig()
+	cfg.ServerURL = "https://roamie.example.com"
+	cfg.PrivateKey = "wg-private-key"
+	cfg.SetSession("access-token", "refresh-token", time.Now().Add(time.Hour))
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(configDir, ConfigFile))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	for _, secret := range []string{"wg-private-key", "access-token", "refresh-token"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("config.json should not contain %q", secret)
+		}
+	}
+	if plaintext, _ := HasPlaintextSecrets(); plaintext {
+		t.Error("HasPlaintextSecrets should be false after Save")
+	}
+
+	loaded, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.PrivateKey != "wg-private-key" || loaded.JWT != "access-token" || loaded.RefreshToken != "refresh-token" {
+		t.Errorf("Secrets not restored: %+v", loaded)
+	}
+
+	// Test: clearing a secret removes it from the store
+	loaded.JWT = ""
+	if err := loaded.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "secrets", "jwt.enc")); !os.IsNotExist(err) {
+		t.Error("Cleared JWT should be deleted from the secrets store")
+	}
+}
+
+func TestMigrateSecrets(t *testing.T) {
+	configDir := setupTestHome(t, secrets.BackendFile)
+
+	// A config.json written by an older version
+	os.MkdirAll(configDir, 0700)
+	legacy := `{"server_url": "https://roamie.example.com", "jwt": "access-token", "refresh_token": "refresh-token", "private_key": "wg-private-key"}`
+	if err := os.WriteFile(filepath.Join(configDir, ConfigFile), []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := MigrateSecrets()
+	if err != nil || !migrated {
+		t.Fatalf("MigrateSecrets = %v, %v", migrated, err)
+	}
+	if plaintext, _ := HasPlaintextSecrets(); plaintext {
+		t.Error("Secrets should have been moved out of config.json")
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.JWT != "access-token" || cfg.SecretRefs["private_key"] != "file:private_key" {
+		t.Errorf("Unexpected config after migration: %+v", cfg)
+	}
+
+	// Test: nothing left to migrate
+	if migrated, _ := MigrateSecrets(); migrated {
+		t.Error("Second migration should be a no-op")
+	}
+}
+
+func TestMoveSecrets_ToPlaintext(t *testing.T) {
+	configDir := setupTestHome(t, secrets.BackendFile)
+
+	cfg := DefaultConfig()
+	cfg.PrivateKey = "wg-private-key"
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	t.Setenv(secrets.BackendEnv, secrets.BackendPlaintext)
+	if err := MoveSecrets(); err != nil {
+		t.Fatalf("MoveSecrets failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(configDir, ConfigFile))
+	if !strings.Contains(string(data), "wg-private-key") {
+		t.Error("Plaintext mode should keep secrets in config.json")
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "secrets", "private_key.enc")); !os.IsNotExist(err) {
+		t.Error("Moved secret should be deleted from the file backend")
+	}
+}
@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/diagnostics"
+	"github.com/kamikazebr/roamie-desktop/internal/client/tunnel"
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+)
+
+const (
+	// Daemon log lines kept for the logs command
+	logRingSize = 2000
+	// Lines the logs command returns by default
+	defaultLogLines = 200
+)
+
+// newControlMux returns the handlers for commands the server sends over the
+// tunnel. Restarting the tunnel is left to the main loop: the command sends
+// on restartTunnel after replying.
+func newControlMux(logs *logRing, restartTunnel chan<- struct{}) *control.Mux {
+	mux := control.NewMux()
+
+	mux.Handle(control.CommandDoctor, func(ctx context.Context, call *control.Call) (any, error) {
+		report := diagnostics.RunDoctorProgrammatic()
+		for _, check := range report.Checks {
+			fmt.Fprintf(call.Output, "[%s] %s: %s\n", check.Status, check.Name, check.Message)
+		}
+		return report, nil
+	})
+
+	mux.Handle(control.CommandSyncKeys, func(ctx context.Context, call *control.Call) (any, error) {
+		var errs []error
+		if err := syncSSH(); err != nil {
+			errs = append(errs, fmt.Errorf("SSH sync failed: %w", err))
+		} else {
+			fmt.Fprintln(call.Output, "✓ Authorized keys synced")
+		}
+		if err := syncSSHCA(); err != nil {
+			errs = append(errs, fmt.Errorf("SSH CA sync failed: %w", err))
+		} else {
+			fmt.Fprintln(call.Output, "✓ SSH CA synced")
+		}
+		return nil, errors.Join(errs...)
+	})
+
+	mux.Handle(control.CommandRestartTunnel, func(ctx context.Context, call *control.Call) (any, error) {
+		// The restart takes down the connection the reply goes over
+		call.AfterReply(func() {
+			select {
+			case restartTunnel <- struct{}{}:
+			default: // A restart is already pending
+			}
+		})
+		fmt.Fprintln(call.Output, "Restarting tunnel...")
+		return nil, nil
+	})
+
+	mux.Handle(control.CommandLogs, func(ctx context.Context, call *control.Call) (any, error) {
+		args := struct {
+			Lines int `json:"lines"`
+		}{Lines: defaultLogLines}
+		if err := call.DecodeArgs(&args); err != nil {
+			return nil, err
+		}
+		for _, line := range logs.Lines(args.Lines) {
+			fmt.Fprintln(call.Output, line)
+		}
+		return nil, nil
+	})
+
+	mux.Handle(control.CommandApplyConfig, func(ctx context.Context, call *control.Call) (any, error) {
+		return applyConfig(call)
+	})
+
+	return mux
+}
+
+// remoteConfig is the part of the config the server may change. The daemon
+// picks up the saved config on its next config check.
+type remoteConfig struct {
+	TunnelTransport    *string `json:"tunnel_transport,omitempty"`
+	EmbeddedSSH        *bool   `json:"embedded_ssh,omitempty"`
+	EmbeddedSSHPort    *int    `json:"embedded_ssh_port,omitempty"`
+	AutoUpgradeEnabled *bool   `json:"auto_upgrade_enabled,omitempty"`
+}
+
+func applyConfig(call *control.Call) (*remoteConfig, error) {
+	var changes remoteConfig
+	decoder := json.NewDecoder(bytes.NewReader(call.Args))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&changes); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+	if changes.TunnelTransport != nil && !tunnel.ValidTransport(*changes.TunnelTransport) {
+		return nil, fmt.Errorf("invalid tunnel_transport %q", *changes.TunnelTransport)
+	}
+	if changes.EmbeddedSSHPort != nil && (*changes.EmbeddedSSHPort < 0 || *changes.EmbeddedSSHPort > 65535) {
+		return nil, fmt.Errorf("invalid embedded_ssh_port %d", *changes.EmbeddedSSHPort)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg == nil {
+		return nil, errors.New("no configuration found")
+	}
+
+	if changes.TunnelTransport != nil {
+		cfg.TunnelTransport = *changes.TunnelTransport
+		fmt.Fprintf(call.Output, "tunnel_transport = %q\n", cfg.TunnelTransport)
+	}
+	if changes.EmbeddedSSH != nil {
+		cfg.EmbeddedSSH = *changes.EmbeddedSSH
+		fmt.Fprintf(call.Output, "embedded_ssh = %v\n", cfg.EmbeddedSSH)
+	}
+	if changes.EmbeddedSSHPort != nil {
+		cfg.EmbeddedSSHPort = *changes.EmbeddedSSHPort
+		fmt.Fprintf(call.Output, "embedded_ssh_port = %d\n", cfg.EmbeddedSSHPort)
+	}
+	if changes.AutoUpgradeEnabled != nil {
+		cfg.AutoUpgradeEnabled = *changes.AutoUpgradeEnabled
+		fmt.Fprintf(call.Output, "auto_upgrade_enabled = %v\n", cfg.AutoUpgradeEnabled)
+	}
+
+	if err := cfg.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+
+	// Report the settings as they are now
+	return &remoteConfig{
+		TunnelTransport:    &cfg.TunnelTransport,
+		EmbeddedSSH:        &cfg.EmbeddedSSH,
+		EmbeddedSSHPort:    &cfg.EmbeddedSSHPort,
+		AutoUpgradeEnabled: &cfg.AutoUpgradeEnabled,
+	}, nil
+}
+
+// logRing keeps the daemon's recent log lines for the logs command
+type logRing struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	partial string
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{lines: make([]string, 0, size)}
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	text := r.partial + string(p)
+	lines := strings.Split(text, "\n")
+	r.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if len(r.lines) < cap(r.lines) {
+			r.lines = append(r.lines, line)
+		} else {
+			r.lines[r.next] = line
+		}
+		r.next = (r.next + 1) % cap(r.lines)
+	}
+	return len(p), nil
+}
+
+// Lines returns up to the last n lines, oldest first
+func (r *logRing) Lines(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n = min(max(n, 0), len(r.lines))
+	lines := make([]string, 0, n)
+	for i := len(r.lines) - n; i < len(r.lines); i++ {
+		lines = append(lines, r.lines[(r.next+i)%len(r.lines)])
+	}
+	return lines
+}
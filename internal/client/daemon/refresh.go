@@ -4,25 +4,32 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"time"
 
 	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/auth"
 	"github.com/kamikazebr/roamie-desktop/internal/client/config"
 	"github.com/kamikazebr/roamie-desktop/internal/client/diagnostics"
+	"github.com/kamikazebr/roamie-desktop/internal/client/netscan"
 	"github.com/kamikazebr/roamie-desktop/internal/client/ssh"
+	"github.com/kamikazebr/roamie-desktop/internal/client/sshd"
+	"github.com/kamikazebr/roamie-desktop/internal/client/sshserver"
 	"github.com/kamikazebr/roamie-desktop/internal/client/tunnel"
 	"github.com/kamikazebr/roamie-desktop/internal/client/upgrade"
+	"github.com/kamikazebr/roamie-desktop/internal/client/userspace"
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
 	"github.com/kamikazebr/roamie-desktop/pkg/version"
 )
 
 func Run(ctx context.Context) error {
 	log.Println("Roamie VPN auth refresh daemon started")
 
-	// JWT refresh ticker (fixed at 1 hour)
-	jwtTicker := time.NewTicker(1 * time.Hour)
+	// JWT refresh ticker (every minute; access tokens are short-lived)
+	jwtTicker := time.NewTicker(1 * time.Minute)
 	defer jwtTicker.Stop()
 
 	// Heartbeat ticker (fixed at 30 seconds)
@@ -37,6 +44,11 @@ func Run(ctx context.Context) error {
 	tunnelHealthTicker := time.NewTicker(30 * time.Second)
 	defer tunnelHealthTicker.Stop()
 
+	// Renew the access token first so the tunnel starts with a valid one
+	if err := checkAndRefresh(); err != nil {
+		log.Printf("Initial JWT refresh check failed: %v", err)
+	}
+
 	// Load config to get SSH sync interval
 	cfg, err := config.Load()
 	if err != nil {
@@ -59,6 +71,17 @@ func Run(ctx context.Context) error {
 	diagnosticsTicker := time.NewTicker(30 * time.Second)
 	defer diagnosticsTicker.Stop()
 
+	// Network conflict scan ticker (every 15 minutes to catch LAN changes)
+	networkScanTicker := time.NewTicker(15 * time.Minute)
+	defer networkScanTicker.Stop()
+
+	// Commands from the server over the tunnel; logs come from the daemon's
+	// own recent log output
+	logs := newLogRing(logRingSize)
+	log.SetOutput(io.MultiWriter(log.Writer(), logs))
+	restartTunnel := make(chan struct{}, 1)
+	controlMux := newControlMux(logs, restartTunnel)
+
 	// Tunnel state management
 	var tunnelClient *tunnel.Client
 	var tunnelCancel context.CancelFunc
@@ -67,23 +90,46 @@ func Run(ctx context.Context) error {
 	// Start tunnel if enabled in config
 	if cfg != nil && cfg.TunnelEnabled {
 		log.Println("Tunnel enabled in config, starting...")
-		tunnelClient, tunnelCancel = startTunnel(ctx, cfg)
+		tunnelClient, tunnelCancel = startTunnel(ctx, cfg, controlMux)
 		if tunnelClient != nil {
 			tunnelEnabled = true
 		}
 	}
 
-	// Do initial checks immediately
-	if err := checkAndRefresh(); err != nil {
-		log.Printf("Initial JWT refresh check failed: %v", err)
+	// Userspace VPN state management
+	var vpnTunnel *userspace.Tunnel
+	vpnFingerprint := ""
+
+	// Start userspace VPN if enabled in config
+	if cfg != nil && cfg.UserspaceEnabled {
+		log.Println("Userspace VPN enabled in config, starting...")
+		vpnTunnel, vpnFingerprint = startUserspace(ctx, cfg)
 	}
+
+	// Embedded SSH server state management (serves the tunnel without sshd)
+	var sshServer *sshserver.Server
+	if cfg != nil && embeddedSSHAddr(cfg) != "" {
+		log.Println("Embedded SSH server enabled in config, starting...")
+		sshServer = startEmbeddedSSH(ctx, cfg)
+	}
+
+	// Do initial checks immediately
 	if err := syncSSH(); err != nil {
 		log.Printf("Initial SSH sync failed: %v", err)
 	}
+	if err := syncSSHCA(); err != nil {
+		log.Printf("Initial SSH CA sync failed: %v", err)
+	}
 	// Send initial heartbeat
 	if err := sendHeartbeat(); err != nil {
 		log.Printf("Initial heartbeat failed: %v", err)
 	}
+	if err := scanNetworks(); err != nil {
+		log.Printf("Initial network scan failed: %v", err)
+	}
+	if err := syncRoutes(); err != nil {
+		log.Printf("Initial route sync failed: %v", err)
+	}
 
 	for {
 		select {
@@ -96,6 +142,12 @@ func Run(ctx context.Context) error {
 			if tunnelClient != nil {
 				tunnelClient.Disconnect()
 			}
+			if vpnTunnel != nil {
+				vpnTunnel.Close()
+			}
+			if sshServer != nil {
+				sshServer.Close()
+			}
 			log.Println("Daemon stopped")
 			return nil
 
@@ -115,7 +167,7 @@ func Run(ctx context.Context) error {
 				if newCfg.TunnelEnabled {
 					// Start tunnel
 					log.Println("Tunnel enabled, starting...")
-					tunnelClient, tunnelCancel = startTunnel(ctx, newCfg)
+					tunnelClient, tunnelCancel = startTunnel(ctx, newCfg, controlMux)
 					if tunnelClient != nil {
 						tunnelEnabled = true
 					}
@@ -132,6 +184,51 @@ func Run(ctx context.Context) error {
 					tunnelCancel = nil
 					tunnelEnabled = false
 				}
+			} else if tunnelClient != nil && cfg != nil && newCfg.TunnelTransport != cfg.TunnelTransport {
+				log.Println("Tunnel transport changed, restarting...")
+				if tunnelCancel != nil {
+					tunnelCancel()
+				}
+				tunnelClient.Disconnect()
+				tunnelClient, tunnelCancel = startTunnel(ctx, newCfg, controlMux)
+				tunnelEnabled = tunnelClient != nil
+			}
+
+			// Check if userspace VPN state or settings changed
+			if newCfg.UserspaceEnabled {
+				wanted := ""
+				if usCfg, err := userspace.FromClientConfig(newCfg); err == nil {
+					wanted = usCfg.Fingerprint()
+				}
+				if vpnTunnel == nil || wanted != vpnFingerprint {
+					if vpnTunnel != nil {
+						log.Println("Userspace VPN settings changed, restarting...")
+						vpnTunnel.Close()
+					} else {
+						log.Println("Userspace VPN enabled, starting...")
+					}
+					vpnTunnel, vpnFingerprint = startUserspace(ctx, newCfg)
+				}
+			} else if vpnTunnel != nil {
+				log.Println("Userspace VPN disabled, stopping...")
+				vpnTunnel.Close()
+				vpnTunnel = nil
+				vpnFingerprint = ""
+			}
+
+			// Check if the embedded SSH server was enabled, disabled or moved
+			wantedSSH := embeddedSSHAddr(newCfg)
+			if sshServer != nil && sshServer.Addr() != wantedSSH {
+				log.Println("Embedded SSH server settings changed, stopping...")
+				sshServer.Close()
+				sshServer = nil
+			}
+			if sshServer == nil && wantedSSH != "" {
+				log.Println("Embedded SSH server enabled, starting...")
+				sshServer = startEmbeddedSSH(ctx, newCfg)
+			}
+			if tunnelClient != nil {
+				tunnelClient.SetEmbeddedSSHAddr(wantedSSH)
 			}
 
 			// Update cfg reference for other operations
@@ -152,6 +249,9 @@ func Run(ctx context.Context) error {
 			if err := syncSSH(); err != nil {
 				log.Printf("SSH sync failed: %v", err)
 			}
+			if err := syncSSHCA(); err != nil {
+				log.Printf("SSH CA sync failed: %v", err)
+			}
 
 		case <-tunnelHealthTicker.C:
 			// Check if tunnel should be running but isn't connected
@@ -169,7 +269,7 @@ func Run(ctx context.Context) error {
 					if err != nil {
 						log.Printf("Failed to reload config for tunnel restart: %v", err)
 					} else if newCfg != nil && newCfg.TunnelEnabled {
-						tunnelClient, tunnelCancel = startTunnel(ctx, newCfg)
+						tunnelClient, tunnelCancel = startTunnel(ctx, newCfg, controlMux)
 						if tunnelClient == nil {
 							tunnelEnabled = false
 							log.Println("Tunnel restart failed")
@@ -180,6 +280,29 @@ func Run(ctx context.Context) error {
 				}
 			}
 
+		case <-restartTunnel:
+			// Requested by the server over the tunnel
+			if tunnelEnabled && tunnelClient != nil {
+				log.Println("Tunnel restart requested by server, restarting...")
+				if tunnelCancel != nil {
+					tunnelCancel()
+				}
+				tunnelClient.Disconnect()
+
+				newCfg, err := config.Load()
+				if err != nil {
+					log.Printf("Failed to reload config for tunnel restart: %v", err)
+				} else if newCfg != nil && newCfg.TunnelEnabled {
+					tunnelClient, tunnelCancel = startTunnel(ctx, newCfg, controlMux)
+					if tunnelClient == nil {
+						tunnelEnabled = false
+						log.Println("Tunnel restart failed")
+					} else {
+						log.Println("✓ Tunnel restarted successfully")
+					}
+				}
+			}
+
 		case <-upgradeTicker.C:
 			// Reload config to get latest auto-upgrade setting
 			upgradeCfg, err := config.Load()
@@ -193,6 +316,14 @@ func Run(ctx context.Context) error {
 				}
 			}
 
+		case <-networkScanTicker.C:
+			if err := scanNetworks(); err != nil {
+				log.Printf("Network scan failed: %v", err)
+			}
+			if err := syncRoutes(); err != nil {
+				log.Printf("Route sync failed: %v", err)
+			}
+
 		case <-diagnosticsTicker.C:
 			// Check for pending diagnostics requests
 			if err := checkAndRunDiagnostics(); err != nil {
@@ -212,6 +343,12 @@ func checkAndRefresh() error {
 		return fmt.Errorf("no configuration found, please run 'roamie auth login'")
 	}
 
+	// Access tokens live for minutes; renew when fewer than RefreshMargin remain
+	expiresIn := cfg.ExpiresIn()
+	if expiresIn > auth.RefreshMargin {
+		return nil
+	}
+
 	// Grace period: Skip device validation if config was created recently (within 2 minutes)
 	// This prevents race conditions where the daemon starts before the login flow completes
 	gracePeriod := 2 * time.Minute
@@ -219,52 +356,40 @@ func checkAndRefresh() error {
 	if configAge < gracePeriod {
 		log.Printf("Config created %s ago (grace period: %s), skipping device validation",
 			configAge.Round(time.Second), gracePeriod)
-	} else {
-		// Validate device still exists on server (with retry for transient failures)
+	} else if expiresIn > 0 {
+		// Validate device still exists on server (with retry for transient failures).
+		// Only possible while the current token is still valid.
 		if err := validateDeviceExistsWithRetry(cfg, 3); err != nil {
 			if errors.Is(err, api.ErrDeviceDeleted) {
-				log.Println("Device was deleted remotely. Cleaning up local configuration...")
-				if err := performLocalCleanup(cfg); err != nil {
-					log.Printf("Warning: cleanup failed: %v", err)
-				}
-				return fmt.Errorf("device was deleted remotely")
+				return cleanupDeletedDevice(cfg)
 			}
 			log.Printf("Warning: device validation check failed: %v", err)
 			// Don't return error - allow refresh to continue even if validation fails
 		}
 	}
 
-	// Check if JWT expires in < 24 hours
-	expiresIn := cfg.ExpiresIn()
-
-	if expiresIn < 24*time.Hour {
-		log.Printf("JWT expires in %s, refreshing...", expiresIn.Round(time.Hour))
-
-		client := api.NewClient(cfg.ServerURL)
-		resp, err := client.RefreshJWT(cfg.RefreshToken)
-		if err != nil {
-			return fmt.Errorf("failed to refresh JWT: %w", err)
-		}
+	log.Printf("JWT expires in %s, refreshing...", expiresIn.Round(time.Second))
 
-		// Parse new expires_at
-		expiresAt, _ := time.Parse("2006-01-02T15:04:05Z", resp.ExpiresAt)
-
-		// Update config
-		cfg.JWT = resp.JWT
-		cfg.ExpiresAt = expiresAt
-
-		if err := cfg.Save(); err != nil {
-			return fmt.Errorf("failed to save config: %w", err)
+	if err := auth.RefreshSession(cfg); err != nil {
+		// The server revokes the refresh token family of deleted devices
+		if errors.Is(err, api.ErrDeviceDeleted) {
+			return cleanupDeletedDevice(cfg)
 		}
-
-		log.Printf("✓ JWT refreshed successfully (expires: %s)", expiresAt.Format("2006-01-02 15:04:05"))
-	} else {
-		log.Printf("JWT valid for %s, no refresh needed", expiresIn.Round(time.Hour))
+		return fmt.Errorf("failed to refresh JWT: %w", err)
 	}
 
+	log.Printf("✓ JWT refreshed successfully (expires: %s)", cfg.ExpiresAt.Format("2006-01-02 15:04:05"))
 	return nil
 }
 
+func cleanupDeletedDevice(cfg *config.Config) error {
+	log.Println("Device was deleted remotely. Cleaning up local configuration...")
+	if err := performLocalCleanup(cfg); err != nil {
+		log.Printf("Warning: cleanup failed: %v", err)
+	}
+	return fmt.Errorf("device was deleted remotely")
+}
+
 func validateDeviceExists(cfg *config.Config) error {
 	if cfg.DeviceID == "" {
 		return nil // Skip validation if device ID not set
@@ -371,6 +496,38 @@ func syncSSH() error {
 	return nil
 }
 
+// syncSSHCA trusts the server's SSH CA: host certificates in known_hosts
+// and, when the daemon runs as root, user certificates in sshd
+func syncSSHCA() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Skip if not authenticated
+	if cfg == nil || cfg.JWT == "" {
+		return nil
+	}
+
+	sshManager, err := ssh.NewManager(cfg.ServerURL)
+	if err != nil {
+		return fmt.Errorf("failed to create SSH manager: %w", err)
+	}
+
+	if _, err := sshManager.SyncKnownHosts(cfg.JWT); err != nil {
+		return err
+	}
+
+	// sshd is configured by 'sudo roamie ssh trust' when the daemon runs as
+	// the user; it is only kept up to date here with root
+	if os.Geteuid() == 0 {
+		if _, err := sshManager.SyncSSHDTrust(cfg, sshd.DefaultTrust()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func sendHeartbeat() error {
 	cfg, err := config.Load()
 	if err != nil {
@@ -394,9 +551,134 @@ func sendHeartbeat() error {
 	return nil
 }
 
-// startTunnel starts the SSH tunnel with the given config
+// scanNetworks scans local networks for overlaps with the VPN subnet and reports them
+func scanNetworks() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if cfg == nil {
+		return fmt.Errorf("no configuration found")
+	}
+
+	// Nothing to conflict with until the device has a VPN address
+	if cfg.JWT == "" || cfg.DeviceID == "" || cfg.VpnIP == "" {
+		return nil
+	}
+
+	check := netscan.Check(cfg)
+	if check.ReportError != nil {
+		return fmt.Errorf("failed to report scan: %w", check.ReportError)
+	}
+
+	if check.SubnetMoved {
+		if err := netscan.SyncAddress(cfg, check.Subnet); err != nil {
+			return fmt.Errorf("failed to sync re-homed address: %w", err)
+		}
+		log.Printf("VPN subnet moved to %s (new VPN IP: %s), reconnect to apply", check.Subnet, cfg.VpnIP)
+	}
+
+	if len(check.Conflicts) > 0 {
+		log.Printf("Warning: %d local network(s) overlap VPN subnet %s, run 'roamie network rehome'",
+			len(check.Conflicts), check.Subnet)
+	}
+
+	return nil
+}
+
+// syncRoutes re-advertises this device's routes when the server lost them
+// (e.g. after the device was re-registered) and checks that forwarding is
+// enabled once they are approved
+func syncRoutes() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if cfg == nil || cfg.JWT == "" || cfg.DeviceID == "" {
+		return nil
+	}
+
+	advertising := len(cfg.AdvertiseRoutes) > 0 || cfg.AdvertiseExitNode
+	if !advertising {
+		return nil
+	}
+
+	client := api.NewClient(cfg.ServerURL)
+	routes, err := client.ListRoutes(cfg.JWT)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	var own []api.Route
+	for _, route := range routes {
+		if route.DeviceID == cfg.DeviceID {
+			own = append(own, route)
+		}
+	}
+
+	if !advertisedRoutesMatch(own, cfg.AdvertiseRoutes, cfg.AdvertiseExitNode) {
+		log.Printf("Server routes differ from config, re-advertising %v (exit node: %v)", cfg.AdvertiseRoutes, cfg.AdvertiseExitNode)
+		if own, err = client.AdvertiseRoutes(cfg.DeviceID, cfg.JWT, cfg.AdvertiseRoutes, cfg.AdvertiseExitNode); err != nil {
+			return fmt.Errorf("failed to advertise routes: %w", err)
+		}
+	}
+
+	approved := false
+	for _, route := range own {
+		switch route.Status {
+		case "approved":
+			approved = true
+		case "rejected":
+			log.Printf("Warning: route %s was rejected by the server admin", route.CIDR)
+		}
+	}
+
+	// Forwarding is enabled by the WireGuard interface hooks, which need root
+	if approved && cfg.VPNEnabled && !forwardingEnabled() {
+		log.Println("Warning: routes are approved but IP forwarding is off, reconnect with 'sudo roamie connect'")
+	}
+
+	return nil
+}
+
+// advertisedRoutesMatch reports whether the server has exactly the routes in config
+func advertisedRoutesMatch(routes []api.Route, cidrs []string, exitNode bool) bool {
+	want := make(map[string]bool)
+	for _, cidr := range cidrs {
+		want[cidr] = true
+	}
+
+	hasExit := false
+	count := 0
+	for _, route := range routes {
+		if route.ExitNode {
+			hasExit = true
+			continue
+		}
+		if !want[route.CIDR] {
+			return false
+		}
+		count++
+	}
+	return hasExit == exitNode && count == len(want)
+}
+
+// forwardingEnabled reports whether the kernel forwards IPv4 packets.
+// Only Linux exposes this as a file; other platforms are assumed enabled.
+func forwardingEnabled() bool {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	if err != nil {
+		return true
+	}
+	return len(data) > 0 && data[0] == '1'
+}
+
+// startTunnel starts the SSH tunnel with the given config, serving commands
+// from the server with mux
 // Returns the tunnel client and a cancel function to stop it
-func startTunnel(ctx context.Context, cfg *config.Config) (*tunnel.Client, context.CancelFunc) {
+func startTunnel(ctx context.Context, cfg *config.Config, mux *control.Mux) (*tunnel.Client, context.CancelFunc) {
 	// Create a cancellable context for the tunnel
 	tunnelCtx, cancel := context.WithCancel(ctx)
 
@@ -407,6 +689,7 @@ func startTunnel(ctx context.Context, cfg *config.Config) (*tunnel.Client, conte
 		cancel()
 		return nil, nil
 	}
+	client.SetControl(mux)
 
 	// Start the tunnel connection in background
 	go func() {
@@ -419,6 +702,59 @@ func startTunnel(ctx context.Context, cfg *config.Config) (*tunnel.Client, conte
 	return client, cancel
 }
 
+// embeddedSSHAddr returns where the embedded SSH server should listen, or ""
+// if it shouldn't run. It only serves the tunnel, so it follows the tunnel.
+func embeddedSSHAddr(cfg *config.Config) string {
+	if !cfg.EmbeddedSSH || !cfg.TunnelEnabled {
+		return ""
+	}
+	return sshserver.ListenAddr(cfg.EmbeddedSSHPort)
+}
+
+// startEmbeddedSSH starts the embedded SSH server. Certificates from the
+// Roamie SSH CA are accepted when the CA can be fetched.
+func startEmbeddedSSH(ctx context.Context, cfg *config.Config) *sshserver.Server {
+	serverCfg, err := sshserver.FromClientConfig(cfg)
+	if err != nil {
+		log.Printf("Failed to configure embedded SSH server: %v", err)
+		return nil
+	}
+
+	if ca, err := api.NewClient(cfg.ServerURL).GetSSHCA(cfg.JWT); err != nil {
+		log.Printf("Warning: embedded SSH server won't accept certificates: %v", err)
+	} else if serverCfg.UserCA, err = sshserver.ParseUserCA(ca.UserCA); err != nil {
+		log.Printf("Warning: embedded SSH server won't accept certificates: %v", err)
+	}
+
+	s, err := sshserver.Start(ctx, serverCfg)
+	if err != nil {
+		log.Printf("Failed to start embedded SSH server: %v", err)
+		return nil
+	}
+
+	log.Printf("✓ Embedded SSH server started on %s (user %s)", s.Addr(), serverCfg.Username)
+	return s
+}
+
+// startUserspace brings up the userspace WireGuard tunnel and returns it with
+// the fingerprint of the settings it was started with
+func startUserspace(ctx context.Context, cfg *config.Config) (*userspace.Tunnel, string) {
+	usCfg, err := userspace.FromClientConfig(cfg)
+	if err != nil {
+		log.Printf("Failed to configure userspace VPN: %v", err)
+		return nil, ""
+	}
+
+	t, err := userspace.Start(ctx, usCfg)
+	if err != nil {
+		log.Printf("Failed to start userspace VPN: %v", err)
+		return nil, ""
+	}
+
+	log.Printf("✓ Userspace VPN started (VPN IP %s, SOCKS5 %s)", usCfg.Address, usCfg.SOCKSAddr)
+	return t, usCfg.Fingerprint()
+}
+
 // checkAndAutoUpgrade checks for updates and performs automatic upgrade if available
 func checkAndAutoUpgrade(cfg *config.Config) error {
 	currentVersion := version.Version
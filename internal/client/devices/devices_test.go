@@ -0,0 +1,191 @@
+package devices
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+)
+
+func strPtr(s string) *string { return &s }
+func intPtr(i int) *int       { return &i }
+
+var testDevices = []api.Device{
+	{ID: "a1b2c3d4-0000-0000-0000-000000000001", DeviceName: "linux-alice-a1b2", DisplayName: strPtr("Work Laptop"), VpnIP: "10.100.0.2", DNSName: "work-laptop.alice.roamie.internal"},
+	{ID: "b2c3d4e5-0000-0000-0000-000000000002", DeviceName: "desktop", VpnIP: "10.100.0.3", DNSName: "desktop.alice.roamie.internal"},
+	{ID: "b2c3ffff-0000-0000-0000-000000000003", DeviceName: "Desktop", VpnIP: "10.100.0.4", DNSName: "desktop-b2c3.alice.roamie.internal"},
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantIP  string
+		wantErr string
+	}{
+		{"work laptop", "10.100.0.2", ""},
+		{"work-laptop", "10.100.0.2", ""},
+		{"linux-alice-a1b2", "10.100.0.2", ""},
+		{"10.100.0.3", "10.100.0.3", ""},
+		{"desktop.alice.roamie.internal.", "10.100.0.3", ""},
+		{"desktop-b2c3", "10.100.0.4", ""},
+		{"b2c3d4e5-0000-0000-0000-000000000002", "10.100.0.3", ""},
+		{"a1b2", "10.100.0.2", ""},
+		{"desktop", "", "matches 2 devices"},
+		{"b2c3", "", "matches 2 devices"},
+		{"a1b", "", "no device matches"},
+		{"phone", "", "no device matches"},
+		{"", "", "no device specified"},
+	}
+
+	for _, tt := range tests {
+		d, err := Resolve(testDevices, tt.query)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Resolve(%q) error = %v, want %q", tt.query, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q) unexpected error: %v", tt.query, err)
+			continue
+		}
+		if d.VpnIP != tt.wantIP {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.query, d.VpnIP, tt.wantIP)
+		}
+	}
+}
+
+func TestChooseSSHRoute(t *testing.T) {
+	d := &api.Device{VpnIP: "10.100.0.3", DNSName: "desktop.alice.roamie.internal", TunnelEnabled: true, TunnelPort: intPtr(10005)}
+
+	route, ok := ChooseSSHRoute(d, "vpn.example.com", nil, true)
+	if !ok || route.Via != "vpn" || route.Host != "desktop.alice.roamie.internal" {
+		t.Errorf("reachable over VPN: got %+v, %v", route, ok)
+	}
+
+	// Servers that don't report exposure listen publicly
+	route, ok = ChooseSSHRoute(d, "vpn.example.com", nil, false)
+	if !ok || route.Via != "tunnel" || route.Host != "vpn.example.com" || route.Port != 10005 {
+		t.Errorf("tunnel fallback: got %+v, %v", route, ok)
+	}
+	if got, want := route.Args("alice", []string{"uptime"}), []string{"-p", "10005", "alice@vpn.example.com", "uptime"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Args = %v, want %v", got, want)
+	}
+
+	public := &api.TunnelExposure{Bind: "public", AllowedCIDRs: []string{"203.0.113.0/24"}}
+	if route, ok = ChooseSSHRoute(d, "vpn.example.com", public, false); !ok || route.Via != "tunnel" || route.Port != 10005 {
+		t.Errorf("public tunnel: got %+v, %v", route, ok)
+	}
+
+	d.ID = "desktop-id"
+	route, ok = ChooseSSHRoute(d, "vpn.example.com", &api.TunnelExposure{Bind: "vpn"}, false)
+	if !ok || route.Via != "tunnel server" || route.Host != "desktop.alice.roamie.internal" || route.Port != 22 {
+		t.Errorf("VPN-bound tunnel: got %+v, %v", route, ok)
+	}
+	if got, want := route.Args("alice", nil), []string{"-o", "ProxyCommand=roamie proxy desktop-id", "alice@desktop.alice.roamie.internal"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Args = %v, want %v", got, want)
+	}
+
+	d.TunnelEnabled = false
+	if _, ok := ChooseSSHRoute(d, "vpn.example.com", nil, false); ok {
+		t.Error("expected no route with VPN down and tunnel disabled")
+	}
+}
+
+func TestAllowedIPs(t *testing.T) {
+	routes := []api.Route{
+		{DeviceID: "home", CIDR: "192.168.1.0/24", Status: "approved"},
+		{DeviceID: "home", CIDR: "0.0.0.0/0", Status: "approved", ExitNode: true},
+		{DeviceID: "office", CIDR: "172.16.0.0/16", Status: "pending"},
+		{DeviceID: "self", CIDR: "192.168.2.0/24", Status: "approved"},
+		{DeviceID: "office", CIDR: "0.0.0.0/0", Status: "rejected", ExitNode: true},
+	}
+	base := "10.100.0.0/29, 10.100.0.1/32"
+
+	tests := []struct {
+		name     string
+		exitNode string
+		accept   bool
+		want     string
+		wantErr  bool
+	}{
+		{"split tunnel", "", false, "10.100.0.0/29, 10.100.0.1/32", false},
+		{"accept routes", "", true, "10.100.0.0/29, 10.100.0.1/32, 192.168.1.0/24", false},
+		{"exit node", "home", true, "0.0.0.0/0", false},
+		{"rejected exit node", "office", false, "", true},
+		{"unknown exit node", "nowhere", false, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := AllowedIPs(base, routes, "self", tt.exitNode, tt.accept)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: AllowedIPs() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if exits := ExitNodes(routes); len(exits) != 1 || exits[0].DeviceID != "home" {
+		t.Errorf("ExitNodes() = %v, want only home", exits)
+	}
+}
+
+func TestParseForwardTarget(t *testing.T) {
+	tests := []struct {
+		spec   string
+		device string
+		port   int
+		ok     bool
+	}{
+		{"laptop:22", "laptop", 22, true},
+		{"work-laptop.alice.roamie.internal:8080", "work-laptop.alice.roamie.internal", 8080, true},
+		{"10.100.0.2:5432", "10.100.0.2", 5432, true},
+		{"laptop", "", 0, false},
+		{":22", "", 0, false},
+		{"laptop:0", "", 0, false},
+		{"laptop:65536", "", 0, false},
+		{"laptop:ssh", "", 0, false},
+	}
+	for _, tt := range tests {
+		device, port, err := ParseForwardTarget(tt.spec)
+		if (err == nil) != tt.ok || device != tt.device || port != tt.port {
+			t.Errorf("ParseForwardTarget(%q) = %q, %d, %v", tt.spec, device, port, err)
+		}
+	}
+}
+
+func TestCheckForwardable(t *testing.T) {
+	d := &api.Device{DeviceName: "desktop"}
+	if err := CheckForwardable(d); err == nil {
+		t.Error("expected an error without a tunnel")
+	}
+	d.TunnelPort = intPtr(10005)
+	if err := CheckForwardable(d); err == nil {
+		t.Error("expected an error with the tunnel disabled")
+	}
+	d.TunnelEnabled = true
+	if err := CheckForwardable(d); err != nil {
+		t.Errorf("CheckForwardable = %v", err)
+	}
+}
+
+func TestDescribeExposure(t *testing.T) {
+	tests := []struct {
+		exposure *api.TunnelExposure
+		want     string
+	}{
+		{nil, "public (server doesn't report exposure)"},
+		{&api.TunnelExposure{Bind: "vpn", Address: "10.100.0.1:10005"}, "VPN only, 10.100.0.1:10005"},
+		{&api.TunnelExposure{Bind: "loopback"}, "server only (roamie forward/proxy)"},
+		{&api.TunnelExposure{Bind: "public", Address: "0.0.0.0:10005", AllowedCIDRs: []string{"203.0.113.0/24"}, Override: true},
+			"public from 203.0.113.0/24, 0.0.0.0:10005 (set for this device)"},
+	}
+	for _, tt := range tests {
+		if got := DescribeExposure(tt.exposure); got != tt.want {
+			t.Errorf("DescribeExposure(%+v) = %q, want %q", tt.exposure, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package devices
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+)
+
+// DescribeExposure says where a tunnel port listens, for status output
+func DescribeExposure(e *api.TunnelExposure) string {
+	if e == nil {
+		return "public (server doesn't report exposure)"
+	}
+
+	var desc string
+	switch e.Bind {
+	case "vpn":
+		desc = "VPN only"
+	case "loopback":
+		desc = "server only (roamie forward/proxy)"
+	case "public":
+		desc = "public"
+		if len(e.AllowedCIDRs) > 0 {
+			desc += " from " + strings.Join(e.AllowedCIDRs, ", ")
+		}
+	default:
+		desc = e.Bind
+	}
+	if e.Address != "" {
+		desc = fmt.Sprintf("%s, %s", desc, e.Address)
+	}
+	if e.Override {
+		desc += " (set for this device)"
+	}
+	return desc
+}
@@ -0,0 +1,43 @@
+package devices
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+)
+
+// ParseForwardTarget splits "<device>:<port>" (roamie forward)
+func ParseForwardTarget(spec string) (string, int, error) {
+	i := strings.LastIndex(spec, ":")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("invalid target %q, expected <device>:<port>", spec)
+	}
+	port, err := ParsePort(spec[i+1:])
+	if err != nil {
+		return "", 0, err
+	}
+	return spec[:i], port, nil
+}
+
+// ParsePort parses a TCP port number
+func ParsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// CheckForwardable reports why connections can't be forwarded to a device
+// through the tunnel server: it needs an enabled tunnel
+func CheckForwardable(d *api.Device) error {
+	if d.TunnelPort == nil {
+		return fmt.Errorf("%s has no tunnel registered (run 'roamie tunnel register' on it)", d.Name())
+	}
+	if !d.TunnelEnabled {
+		return fmt.Errorf("%s has its tunnel disabled", d.Name())
+	}
+	return nil
+}
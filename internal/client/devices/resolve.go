@@ -0,0 +1,79 @@
+package devices
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+)
+
+// minIDPrefix is the shortest device ID prefix accepted as a reference
+const minIDPrefix = 4
+
+// Resolve finds the device the user refers to. A query may be a device ID (or
+// unique ID prefix), VPN IP, DNS name (full or first label), display name or
+// device name. Name matches are case-insensitive.
+func Resolve(devices []api.Device, query string) (*api.Device, error) {
+	q := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(query), "."))
+	if q == "" {
+		return nil, fmt.Errorf("no device specified")
+	}
+
+	// Exact identifiers first: these can never be ambiguous
+	for i := range devices {
+		d := &devices[i]
+		if strings.ToLower(d.ID) == q || d.VpnIP == q || strings.ToLower(d.DNSName) == q {
+			return d, nil
+		}
+	}
+
+	var matches []*api.Device
+	for i := range devices {
+		if matchesName(&devices[i], q) {
+			matches = append(matches, &devices[i])
+		}
+	}
+
+	if len(matches) == 0 && len(q) >= minIDPrefix {
+		for i := range devices {
+			if strings.HasPrefix(strings.ToLower(devices[i].ID), q) {
+				matches = append(matches, &devices[i])
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no device matches %q (see 'roamie devices')", query)
+	case 1:
+		return matches[0], nil
+	default:
+		names := make([]string, len(matches))
+		for i, d := range matches {
+			names[i] = fmt.Sprintf("%s (%s)", d.Name(), shortID(d.ID))
+		}
+		return nil, fmt.Errorf("%q matches %d devices: %s; use the device ID instead",
+			query, len(matches), strings.Join(names, ", "))
+	}
+}
+
+func matchesName(d *api.Device, q string) bool {
+	if d.DisplayName != nil && strings.ToLower(*d.DisplayName) == q {
+		return true
+	}
+	if strings.ToLower(d.DeviceName) == q {
+		return true
+	}
+	if label, _, _ := strings.Cut(d.DNSName, "."); label != "" && strings.ToLower(label) == q {
+		return true
+	}
+	return false
+}
+
+// shortID abbreviates a device ID for display
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
@@ -0,0 +1,57 @@
+package devices
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+)
+
+// exitRouteCIDR is the route advertised by exit nodes
+const exitRouteCIDR = "0.0.0.0/0"
+
+// AllowedIPs builds the WireGuard AllowedIPs for this device. Using an exit
+// node sends everything through the tunnel; accepting routes adds approved
+// subnet routes advertised by the user's other devices to the base ranges.
+func AllowedIPs(base string, routes []api.Route, selfID, exitNodeID string, acceptRoutes bool) (string, error) {
+	if exitNodeID != "" {
+		for _, route := range routes {
+			if route.ExitNode && route.DeviceID == exitNodeID && route.Status == "approved" {
+				return exitRouteCIDR, nil
+			}
+		}
+		return "", fmt.Errorf("exit node %s is not an approved exit node", exitNodeID)
+	}
+
+	allowed := []string{}
+	seen := make(map[string]bool)
+	for _, cidr := range strings.Split(base, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" && !seen[cidr] {
+			seen[cidr] = true
+			allowed = append(allowed, cidr)
+		}
+	}
+
+	if acceptRoutes {
+		for _, route := range routes {
+			if route.ExitNode || route.DeviceID == selfID || route.Status != "approved" || seen[route.CIDR] {
+				continue
+			}
+			seen[route.CIDR] = true
+			allowed = append(allowed, route.CIDR)
+		}
+	}
+
+	return strings.Join(allowed, ", "), nil
+}
+
+// ExitNodes returns the devices that are approved exit nodes
+func ExitNodes(routes []api.Route) []api.Route {
+	var exits []api.Route
+	for _, route := range routes {
+		if route.ExitNode && route.Status == "approved" {
+			exits = append(exits, route)
+		}
+	}
+	return exits
+}
@@ -0,0 +1,69 @@
+package devices
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+)
+
+// SSHRoute is how to reach a device's SSH server
+type SSHRoute struct {
+	Host  string
+	Port  int
+	Via   string // "vpn", "tunnel" or "tunnel server"
+	Proxy string // ssh ProxyCommand, when the route goes through roamie proxy
+}
+
+// Args returns the ssh command line arguments for this route
+func (r SSHRoute) Args(user string, extra []string) []string {
+	var args []string
+	if r.Proxy != "" {
+		args = append(args, "-o", "ProxyCommand="+r.Proxy)
+	}
+	if r.Port != 0 && r.Port != 22 {
+		args = append(args, "-p", strconv.Itoa(r.Port))
+	}
+	target := r.Host
+	if user != "" {
+		target = user + "@" + r.Host
+	}
+	args = append(args, target)
+	return append(args, extra...)
+}
+
+// ChooseSSHRoute prefers a direct VPN connection and falls back to the
+// device's reverse tunnel on the server: its public port when the server
+// exposes one, otherwise 'roamie proxy' through the tunnel server.
+// vpnReachable reports whether the device's SSH port answered over the VPN.
+func ChooseSSHRoute(d *api.Device, tunnelHost string, exposure *api.TunnelExposure, vpnReachable bool) (SSHRoute, bool) {
+	host := d.VpnIP
+	if d.DNSName != "" {
+		host = d.DNSName
+	}
+	if vpnReachable {
+		return SSHRoute{Host: host, Port: 22, Via: "vpn"}, true
+	}
+
+	if d.TunnelEnabled && d.TunnelPort != nil && tunnelHost != "" {
+		if exposure.Public() {
+			return SSHRoute{Host: tunnelHost, Port: *d.TunnelPort, Via: "tunnel"}, true
+		}
+		// The port only listens on the server's VPN address or loopback
+		return SSHRoute{Host: host, Port: 22, Via: "tunnel server", Proxy: "roamie proxy " + d.ID}, true
+	}
+
+	return SSHRoute{}, false
+}
+
+// VPNReachable reports whether the device accepts TCP connections on port 22
+// at its VPN IP
+func VPNReachable(d *api.Device, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(d.VpnIP, "22"), timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
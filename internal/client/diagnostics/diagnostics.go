@@ -4,14 +4,21 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/kamikazebr/roamie-desktop/internal/client/api"
 	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/secrets"
+	"github.com/kamikazebr/roamie-desktop/internal/client/sshd"
+	"github.com/kamikazebr/roamie-desktop/internal/client/storage"
+	"github.com/kamikazebr/roamie-desktop/internal/client/tunnel"
 	"github.com/kamikazebr/roamie-desktop/internal/client/upgrade"
 	"github.com/kamikazebr/roamie-desktop/internal/client/wireguard"
 	"github.com/kamikazebr/roamie-desktop/pkg/version"
+	"golang.org/x/crypto/ssh"
 )
 
 // CheckStatus represents the result status of a health check
@@ -109,6 +116,67 @@ func checkConfigLoaded(cfg *config.Config) CheckResult {
 	}
 }
 
+// checkSecretsStorage flags device secrets kept in plaintext files
+func checkSecretsStorage(cfg *config.Config) CheckResult {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return CheckResult{
+			Name:     "Secrets storage",
+			Category: "Authentication",
+			Status:   CheckError,
+			Message:  fmt.Sprintf("Failed to find config directory: %v", err),
+		}
+	}
+
+	store, err := secrets.Open(configDir)
+	if err != nil {
+		return CheckResult{
+			Name:     "Secrets storage",
+			Category: "Authentication",
+			Status:   CheckError,
+			Message:  fmt.Sprintf("Secrets store unavailable: %v", err),
+			Fixes:    []string{"Unset " + secrets.BackendEnv + " or set it to file"},
+		}
+	}
+
+	var plaintext []string
+	if found, _ := config.HasPlaintextSecrets(); found {
+		plaintext = append(plaintext, filepath.Join(configDir, config.ConfigFile))
+	}
+	if keyPath := filepath.Join(configDir, tunnel.TunnelKeyFile); fileExists(keyPath) {
+		plaintext = append(plaintext, keyPath)
+	}
+	if found, _ := storage.HasPlaintextCredentials(); found {
+		plaintext = append(plaintext, "~/.roamie-desktop/credentials.json")
+	}
+
+	if len(plaintext) == 0 {
+		return CheckResult{
+			Name:     "Secrets storage",
+			Category: "Authentication",
+			Status:   CheckPassed,
+			Message:  fmt.Sprintf("Secrets stored in %s", store.Backend()),
+		}
+	}
+
+	result := CheckResult{
+		Name:     "Secrets storage",
+		Category: "Authentication",
+		Status:   CheckWarning,
+		Message:  fmt.Sprintf("Secrets in plaintext: %s", strings.Join(plaintext, ", ")),
+		Fixes:    []string{"Run: roamie auth secrets migrate"},
+	}
+	if !store.Enabled() {
+		result.Fixes = []string{"Unset " + secrets.BackendEnv + " (currently plaintext), then run: roamie auth secrets migrate"}
+	}
+	return result
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 // checkJWTValidity validates JWT token expiration
 func checkJWTValidity(cfg *config.Config) CheckResult {
 	if cfg == nil {
@@ -267,6 +335,14 @@ func checkTunnelStatus(cfg *config.Config) CheckResult {
 	}
 
 	if cfg.TunnelEnabled && cfg.TunnelPort > 0 {
+		if cfg.EmbeddedSSH {
+			return CheckResult{
+				Name:     "Tunnel status",
+				Category: "Services",
+				Status:   CheckPassed,
+				Message:  fmt.Sprintf("Tunnel enabled (port %d, embedded SSH server when sshd isn't running)", cfg.TunnelPort),
+			}
+		}
 		return CheckResult{
 			Name:     "Tunnel status",
 			Category: "Services",
@@ -294,6 +370,131 @@ func checkTunnelStatus(cfg *config.Config) CheckResult {
 	}
 }
 
+// checkTunnelHostKey compares the tunnel server's host key with the pinned keys
+func checkTunnelHostKey(cfg *config.Config) CheckResult {
+	if cfg == nil || !cfg.TunnelEnabled {
+		return CheckResult{
+			Name:     "Tunnel host key",
+			Category: "Services",
+			Status:   CheckInfo,
+			Message:  "Tunnel not enabled",
+		}
+	}
+
+	pins := tunnel.ParseHostKeys(cfg.TunnelHostKeys)
+	presented, err := tunnel.ProbeHostKey(cfg.ServerURL, pins)
+	if err != nil {
+		return CheckResult{
+			Name:     "Tunnel host key",
+			Category: "Services",
+			Status:   CheckWarning,
+			Message:  err.Error(),
+		}
+	}
+	fingerprint := ssh.FingerprintSHA256(presented)
+
+	if len(pins) == 0 {
+		return CheckResult{
+			Name:     "Tunnel host key",
+			Category: "Services",
+			Status:   CheckWarning,
+			Message:  fmt.Sprintf("Server host key not pinned yet (server presents %s)", fingerprint),
+			Fixes:    []string{"The tunnel pins the server's host keys the next time it starts"},
+		}
+	}
+
+	if tunnel.IsPinned(pins, presented) {
+		return CheckResult{
+			Name:     "Tunnel host key",
+			Category: "Services",
+			Status:   CheckPassed,
+			Message:  fmt.Sprintf("Server host key matches pin (%s)", fingerprint),
+		}
+	}
+
+	// A planned rotation: the API already publishes the new key
+	if keys, err := api.NewClient(cfg.ServerURL).GetTunnelHostKeys(cfg.JWT); err == nil {
+		for _, key := range keys {
+			if key.Fingerprint == fingerprint {
+				return CheckResult{
+					Name:     "Tunnel host key",
+					Category: "Services",
+					Status:   CheckWarning,
+					Message:  fmt.Sprintf("Server host key rotated to %s, not pinned yet", fingerprint),
+					Fixes:    []string{"The tunnel pins the new key when it next connects"},
+				}
+			}
+		}
+	}
+
+	pinned := make([]string, len(pins))
+	for i, pin := range pins {
+		pinned[i] = ssh.FingerprintSHA256(pin)
+	}
+	return CheckResult{
+		Name:     "Tunnel host key",
+		Category: "Services",
+		Status:   CheckError,
+		Message:  fmt.Sprintf("Host key MISMATCH: server presents %s, pinned %s. The connection may be intercepted", fingerprint, strings.Join(pinned, ", ")),
+		Fixes: []string{
+			"Don't use the tunnel from this network until the server's admin confirms the fingerprint",
+			"Admins list the server's keys with: roamie-server admin list-tunnel-host-keys",
+		},
+	}
+}
+
+// checkSSHCertificates reports whether sshd trusts the Roamie SSH CA and
+// when the host certificate expires
+func checkSSHCertificates(cfg *config.Config) CheckResult {
+	trust := sshd.DefaultTrust()
+	if runtime.GOOS == "windows" || !trust.Installed() {
+		return CheckResult{
+			Name:     "SSH certificates",
+			Category: "Services",
+			Status:   CheckInfo,
+			Message:  "sshd doesn't trust the Roamie SSH CA, logins use synced keys",
+			Fixes:    []string{"Accept short-lived certificates from 'roamie ssh' with: sudo roamie ssh trust"},
+		}
+	}
+
+	cert, err := trust.HostCertificate()
+	if err != nil || cert == nil {
+		return CheckResult{
+			Name:     "SSH certificates",
+			Category: "Services",
+			Status:   CheckPassed,
+			Message:  "sshd accepts Roamie certificates (no host certificate)",
+		}
+	}
+
+	expires := time.Unix(int64(cert.ValidBefore), 0)
+	remaining := time.Until(expires)
+	switch {
+	case remaining <= 0:
+		return CheckResult{
+			Name:     "SSH certificates",
+			Category: "Services",
+			Status:   CheckError,
+			Message:  fmt.Sprintf("Host certificate expired on %s, clients can't verify this machine", expires.Format("2006-01-02")),
+			Fixes:    []string{"Renew it with: sudo roamie ssh trust"},
+		}
+	case remaining < 14*24*time.Hour:
+		return CheckResult{
+			Name:     "SSH certificates",
+			Category: "Services",
+			Status:   CheckWarning,
+			Message:  fmt.Sprintf("Host certificate expires on %s", expires.Format("2006-01-02")),
+			Fixes:    []string{"Renew it with: sudo roamie ssh trust"},
+		}
+	}
+	return CheckResult{
+		Name:     "SSH certificates",
+		Category: "Services",
+		Status:   CheckPassed,
+		Message:  fmt.Sprintf("sshd accepts Roamie certificates, host certificate valid until %s", expires.Format("2006-01-02")),
+	}
+}
+
 // checkAutoUpgrade validates auto-upgrade status
 func checkAutoUpgrade(cfg *config.Config) CheckResult {
 	if cfg == nil {
@@ -360,6 +561,7 @@ func GetDoctorChecks() []CheckCategory {
 			Name: "Authentication",
 			Checks: []func(*config.Config) CheckResult{
 				checkConfigLoaded,
+				checkSecretsStorage,
 				checkJWTValidity,
 				checkServerReachable,
 			},
@@ -376,6 +578,8 @@ func GetDoctorChecks() []CheckCategory {
 			Checks: []func(*config.Config) CheckResult{
 				checkDaemonRunning,
 				checkTunnelStatus,
+				checkTunnelHostKey,
+				checkSSHCertificates,
 				checkAutoUpgrade,
 			},
 		},
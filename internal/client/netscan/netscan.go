@@ -0,0 +1,201 @@
+package netscan
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// VPNInterface is the name of the WireGuard interface created by roamie on Linux.
+// Networks on this interface belong to the VPN itself and are never conflicts.
+const VPNInterface = "roamie"
+
+// Network is a local IPv4 network discovered on this machine
+type Network struct {
+	CIDR        string `json:"cidr"`
+	Source      string `json:"source"` // "route", "interface", "docker"
+	Description string `json:"description,omitempty"`
+
+	iface string // Interface (or interface address on Windows) the network is reachable through
+}
+
+// Scan collects the local routes, interface addresses and Docker networks
+// that could collide with the VPN subnet. Networks reachable through the VPN
+// interface itself are excluded; vpnIP identifies it on platforms where the
+// interface name is not fixed (utun on macOS, Wintun on Windows).
+// Sources that are unavailable on this machine (e.g. Docker not installed) are silently skipped.
+func Scan(vpnIP string) []Network {
+	var networks []Network
+
+	if routes, err := scanRoutes(); err == nil {
+		networks = append(networks, routes...)
+	}
+	if ifaces, err := scanInterfaces(); err == nil {
+		networks = append(networks, ifaces...)
+	}
+	if docker, err := scanDockerNetworks(); err == nil {
+		networks = append(networks, docker...)
+	}
+
+	excluded := vpnInterfaces(vpnIP)
+	filtered := networks[:0]
+	for _, n := range networks {
+		if !excluded[n.iface] {
+			filtered = append(filtered, n)
+		}
+	}
+
+	return dedupe(filtered)
+}
+
+// FindConflicts returns the networks that overlap the given VPN subnet
+func FindConflicts(networks []Network, vpnSubnet string) []Network {
+	_, vpnNet, err := net.ParseCIDR(vpnSubnet)
+	if err != nil {
+		return nil
+	}
+
+	var conflicts []Network
+	for _, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.CIDR)
+		if err != nil {
+			continue
+		}
+		if vpnNet.Contains(ipNet.IP) || ipNet.Contains(vpnNet.IP) {
+			conflicts = append(conflicts, n)
+		}
+	}
+	return conflicts
+}
+
+// scanInterfaces lists the IPv4 networks assigned to local interfaces
+func scanInterfaces() ([]Network, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []Network
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			if n, ok := normalize(ipNet.String()); ok {
+				networks = append(networks, Network{
+					CIDR:        n,
+					Source:      "interface",
+					Description: fmt.Sprintf("Interface %s", iface.Name),
+					iface:       iface.Name,
+				})
+			}
+		}
+	}
+
+	return networks, nil
+}
+
+// scanDockerNetworks lists the subnets of local Docker networks
+func scanDockerNetworks() ([]Network, error) {
+	output, err := exec.Command("docker", "network", "ls", "--format", "{{.Name}}").Output()
+	if err != nil {
+		return nil, err // Docker not available or not running
+	}
+
+	var networks []Network
+	for _, name := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if name == "" {
+			continue
+		}
+
+		inspect, err := exec.Command("docker", "network", "inspect", name,
+			"--format", "{{range .IPAM.Config}}{{.Subnet}} {{end}}").Output()
+		if err != nil {
+			continue
+		}
+
+		for _, subnet := range strings.Fields(string(inspect)) {
+			if n, ok := normalize(subnet); ok {
+				networks = append(networks, Network{
+					CIDR:        n,
+					Source:      "docker",
+					Description: fmt.Sprintf("Docker network: %s", name),
+				})
+			}
+		}
+	}
+
+	return networks, nil
+}
+
+// normalize parses a CIDR and returns its network form, skipping anything
+// that cannot conflict: IPv6, default routes, loopback and link-local ranges
+func normalize(cidr string) (string, bool) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil || ipNet.IP.To4() == nil {
+		return "", false
+	}
+
+	ones, _ := ipNet.Mask.Size()
+	if ones == 0 || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+		return "", false
+	}
+
+	return ipNet.String(), true
+}
+
+// vpnInterfaces returns the interface names (and the VPN IP itself, which
+// Windows route tables use instead of names) that belong to the VPN
+func vpnInterfaces(vpnIP string) map[string]bool {
+	excluded := map[string]bool{VPNInterface: true}
+	if vpnIP == "" {
+		return excluded
+	}
+	excluded[vpnIP] = true
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return excluded
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.String() == vpnIP {
+				excluded[iface.Name] = true
+			}
+		}
+	}
+	return excluded
+}
+
+// dedupe removes duplicate CIDRs, keeping the first source seen
+func dedupe(networks []Network) []Network {
+	seen := make(map[string]bool)
+	var result []Network
+	for _, n := range networks {
+		if seen[n.CIDR] {
+			continue
+		}
+		seen[n.CIDR] = true
+		result = append(result, n)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CIDR < result[j].CIDR
+	})
+	return result
+}
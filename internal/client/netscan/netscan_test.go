@@ -0,0 +1,80 @@
+package netscan
+
+import (
+	"testing"
+)
+
+func TestFindConflicts(t *testing.T) {
+	networks := []Network{
+		{CIDR: "192.168.1.0/24", Source: "interface"},
+		{CIDR: "10.100.0.0/24", Source: "route"},  // Contains the VPN subnet
+		{CIDR: "10.100.0.4/30", Source: "docker"}, // Inside the VPN subnet
+		{CIDR: "10.0.0.0/8", Source: "route"},     // Corp LAN covering everything
+		{CIDR: "10.101.0.0/16", Source: "docker"}, // Adjacent, no overlap
+		{CIDR: "not-a-cidr", Source: "route"},
+	}
+
+	conflicts := FindConflicts(networks, "10.100.0.0/29")
+
+	expected := map[string]bool{
+		"10.100.0.0/24": true,
+		"10.100.0.4/30": true,
+		"10.0.0.0/8":    true,
+	}
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Expected %d conflicts, got %d: %v", len(expected), len(conflicts), conflicts)
+	}
+	for _, c := range conflicts {
+		if !expected[c.CIDR] {
+			t.Errorf("Unexpected conflict: %s", c.CIDR)
+		}
+	}
+}
+
+func TestFindConflicts_InvalidSubnet(t *testing.T) {
+	networks := []Network{{CIDR: "10.100.0.0/24", Source: "route"}}
+	if conflicts := FindConflicts(networks, ""); conflicts != nil {
+		t.Errorf("Expected no conflicts for empty subnet, got %v", conflicts)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"192.168.1.10/24", "192.168.1.0/24", true},
+		{"10.100.0.0/16", "10.100.0.0/16", true},
+		{"0.0.0.0/0", "", false},      // Default route
+		{"127.0.0.0/8", "", false},    // Loopback
+		{"169.254.0.0/16", "", false}, // Link-local
+		{"fd00::/64", "", false},      // IPv6
+		{"invalid", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, ok := normalize(tt.input)
+			if ok != tt.ok || result != tt.expected {
+				t.Errorf("normalize(%q) = (%q, %v), expected (%q, %v)", tt.input, result, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	networks := []Network{
+		{CIDR: "192.168.1.0/24", Source: "route"},
+		{CIDR: "172.17.0.0/16", Source: "docker"},
+		{CIDR: "192.168.1.0/24", Source: "interface"},
+	}
+
+	result := dedupe(networks)
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 networks, got %d", len(result))
+	}
+	if result[0].CIDR != "172.17.0.0/16" || result[1].Source != "route" {
+		t.Errorf("Unexpected dedupe result: %v", result)
+	}
+}
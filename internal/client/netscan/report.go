@@ -0,0 +1,76 @@
+package netscan
+
+import (
+	"fmt"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+)
+
+// CheckResult is the outcome of scanning local networks and reporting them
+type CheckResult struct {
+	Networks    []Network // Every local network found
+	Conflicts   []Network // Networks overlapping the VPN subnet
+	Subnet      string    // VPN subnet the conflicts were computed against
+	Reported    bool      // True if the server accepted the report
+	SubnetMoved bool      // True if the server's subnet differs from the local config
+	ReportError error     // Why the report failed (scan results are still valid)
+}
+
+// Check scans local networks, finds overlaps with the VPN subnet and reports
+// the scan to the server so it can re-home the user's subnet if needed
+func Check(cfg *config.Config) *CheckResult {
+	subnet := cfg.Subnet
+	if subnet == "" {
+		subnet = cfg.AllowedIPs
+	}
+
+	result := &CheckResult{
+		Networks: Scan(cfg.VpnIP),
+		Subnet:   subnet,
+	}
+
+	if cfg.JWT != "" && cfg.DeviceID != "" {
+		networks := make([]api.LocalNetwork, len(result.Networks))
+		for i, n := range result.Networks {
+			networks[i] = api.LocalNetwork{CIDR: n.CIDR, Source: n.Source, Description: n.Description}
+		}
+
+		client := api.NewClient(cfg.ServerURL)
+		resp, err := client.ReportNetworkConflicts(cfg.DeviceID, cfg.JWT, networks)
+		if err != nil {
+			result.ReportError = err
+		} else {
+			result.Reported = true
+			if resp.UserSubnet != "" && resp.UserSubnet != subnet {
+				result.SubnetMoved = true
+				result.Subnet = resp.UserSubnet
+			}
+		}
+	}
+
+	result.Conflicts = FindConflicts(result.Networks, result.Subnet)
+	return result
+}
+
+// SyncAddress refreshes the local VPN address after the server re-homed the
+// user's subnet. The VPN must be reconnected for the change to take effect.
+func SyncAddress(cfg *config.Config, newSubnet string) error {
+	client := api.NewClient(cfg.ServerURL)
+	resp, err := client.ValidateDevice(cfg.DeviceID, cfg.JWT)
+	if err != nil {
+		return fmt.Errorf("failed to fetch device: %w", err)
+	}
+	if resp.Device == nil || resp.Device.VpnIP == "" {
+		return fmt.Errorf("server did not return the device address")
+	}
+
+	// Full-tunnel configs route everything and don't need updating
+	if cfg.AllowedIPs == cfg.Subnet || cfg.AllowedIPs == "" {
+		cfg.AllowedIPs = newSubnet
+	}
+	cfg.Subnet = newSubnet
+	cfg.VpnIP = resp.Device.VpnIP
+
+	return cfg.Save()
+}
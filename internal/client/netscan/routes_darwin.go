@@ -0,0 +1,73 @@
+//go:build darwin
+// +build darwin
+
+package netscan
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// scanRoutes lists IPv4 routes from the macOS routing table
+func scanRoutes() ([]Network, error) {
+	output, err := exec.Command("netstat", "-rn", "-f", "inet").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseNetstat(string(output)), nil
+}
+
+// parseNetstat parses the output of `netstat -rn -f inet`
+// Example line: "192.168.1          link#6             UCS                 en0       !"
+func parseNetstat(output string) []Network {
+	var networks []Network
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "default" || fields[0] == "Destination" {
+			continue
+		}
+
+		dest := expandDarwinDestination(fields[0])
+		if dest == "" {
+			continue
+		}
+
+		iface := fields[3]
+		if n, ok := normalize(dest); ok {
+			networks = append(networks, Network{
+				CIDR:        n,
+				Source:      "route",
+				Description: fmt.Sprintf("Route via %s", iface),
+				iface:       iface,
+			})
+		}
+	}
+	return networks
+}
+
+// expandDarwinDestination converts netstat's abbreviated destinations
+// ("10.1", "192.168.1", "172.16/12") to CIDR notation
+func expandDarwinDestination(dest string) string {
+	prefix := ""
+	if i := strings.Index(dest, "/"); i >= 0 {
+		prefix = dest[i+1:]
+		dest = dest[:i]
+	}
+
+	octets := strings.Split(dest, ".")
+	if len(octets) == 0 || len(octets) > 4 {
+		return ""
+	}
+	if prefix == "" {
+		if len(octets) == 4 {
+			return "" // Host route
+		}
+		prefix = fmt.Sprintf("%d", len(octets)*8)
+	}
+	for len(octets) < 4 {
+		octets = append(octets, "0")
+	}
+
+	return strings.Join(octets, ".") + "/" + prefix
+}
@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package netscan
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// scanRoutes lists IPv4 routes from the kernel routing table
+func scanRoutes() ([]Network, error) {
+	output, err := exec.Command("ip", "-4", "route", "show").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseIPRoute(string(output)), nil
+}
+
+// parseIPRoute parses the output of `ip -4 route show`
+// Example line: "192.168.1.0/24 dev wlan0 proto kernel scope link src 192.168.1.10"
+func parseIPRoute(output string) []Network {
+	var networks []Network
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		dev := ""
+		for i := 0; i < len(fields)-1; i++ {
+			if fields[i] == "dev" {
+				dev = fields[i+1]
+			}
+		}
+		if n, ok := normalize(fields[0]); ok {
+			networks = append(networks, Network{
+				CIDR:        n,
+				Source:      "route",
+				Description: fmt.Sprintf("Route via %s", dev),
+				iface:       dev,
+			})
+		}
+	}
+	return networks
+}
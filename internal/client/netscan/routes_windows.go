@@ -0,0 +1,49 @@
+//go:build windows
+// +build windows
+
+package netscan
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// scanRoutes lists IPv4 routes from the Windows routing table
+func scanRoutes() ([]Network, error) {
+	output, err := exec.Command("route", "print", "-4").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseRoutePrint(string(output)), nil
+}
+
+// parseRoutePrint parses the "Active Routes" table of `route print -4`
+// Example line: "    192.168.1.0    255.255.255.0         On-link     192.168.1.10    281"
+func parseRoutePrint(output string) []Network {
+	var networks []Network
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0]).To4()
+		mask := net.ParseIP(fields[1]).To4()
+		if ip == nil || mask == nil {
+			continue
+		}
+
+		ones, _ := net.IPMask(mask).Size()
+		if n, ok := normalize(fmt.Sprintf("%s/%d", ip, ones)); ok {
+			networks = append(networks, Network{
+				CIDR:        n,
+				Source:      "route",
+				Description: fmt.Sprintf("Route via %s", fields[3]),
+				iface:       fields[3],
+			})
+		}
+	}
+	return networks
+}
@@ -0,0 +1,189 @@
+// Package oidc logs in to an OpenID Connect issuer from the command line using
+// the authorization code flow with PKCE and a loopback redirect (RFC 8252)
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Result is what the issuer returned, ready to present to the Roamie server
+type Result struct {
+	IDToken string
+	Nonce   string // Sent in the authorization request, echoed in the ID token
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type callback struct {
+	code string
+	err  error
+}
+
+// Authorize runs the authorization code flow in the user's browser. openURL is
+// called with the authorization URL (use OpenBrowser); it returns once the
+// issuer redirects back with a code or ctx is done.
+func Authorize(ctx context.Context, issuer, clientID string, scopes []string, openURL func(string)) (*Result, error) {
+	endpoints, err := discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Loopback redirect on a random port; issuers must accept any port for
+	// native apps
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the login callback: %w", err)
+	}
+	defer listener.Close()
+
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	conf := &oauth2.Config{
+		ClientID: clientID,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   endpoints.AuthorizationEndpoint,
+			TokenURL:  endpoints.TokenEndpoint,
+			AuthStyle: oauth2.AuthStyleInParams, // Public client, no secret
+		},
+		RedirectURL: fmt.Sprintf("http://%s/callback", listener.Addr()),
+		Scopes:      scopes,
+	}
+
+	state := randomString()
+	nonce := randomString()
+	verifier := oauth2.GenerateVerifier()
+
+	results := make(chan callback, 1)
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/callback" {
+				http.NotFound(w, r)
+				return
+			}
+
+			query := r.URL.Query()
+			var result callback
+			switch {
+			case query.Get("state") != state:
+				result.err = fmt.Errorf("login callback has an unexpected state")
+			case query.Get("error") != "":
+				result.err = fmt.Errorf("login failed: %s %s", query.Get("error"), query.Get("error_description"))
+			case query.Get("code") == "":
+				result.err = fmt.Errorf("login callback has no code")
+			default:
+				result.code = query.Get("code")
+			}
+
+			if result.err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "<html><body><h3>Login failed</h3><p>%s</p></body></html>", html.EscapeString(result.err.Error()))
+			} else {
+				fmt.Fprint(w, "<html><body><h3>Login complete</h3><p>You can close this window and return to the terminal.</p></body></html>")
+			}
+
+			select {
+			case results <- result:
+			default:
+			}
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	openURL(conf.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	))
+
+	var result callback
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("login not completed: %w", ctx.Err())
+	case result = <-results:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	token, err := conf.Exchange(ctx, result.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return nil, fmt.Errorf("issuer returned no ID token (is the openid scope allowed?)")
+	}
+
+	return &Result{IDToken: idToken, Nonce: nonce}, nil
+}
+
+func discover(ctx context.Context, issuer string) (*discovery, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach identity provider: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity provider discovery returned %d", resp.StatusCode)
+	}
+
+	var d discovery
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" {
+		return nil, fmt.Errorf("identity provider does not support the authorization code flow")
+	}
+	return &d, nil
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// OpenBrowser opens url in the default browser, best effort
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,112 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMockIdP serves discovery, an authorization endpoint that approves
+// immediately and a token endpoint that enforces PKCE
+func newMockIdP(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var challenge, nonce string
+	mux := http.NewServeMux()
+	var server *httptest.Server
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "roamie-cli" || q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		challenge = q.Get("code_challenge")
+		nonce = q.Get("nonce")
+
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"auth-code"}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "auth-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     "id-token-for-" + nonce,
+		})
+	})
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAuthorize(t *testing.T) {
+	idp := newMockIdP(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Stand-in for the browser: follow the redirect back to the loopback listener
+	openURL := func(authURL string) {
+		if !strings.HasPrefix(authURL, idp.URL+"/authorize?") {
+			t.Errorf("Unexpected authorization URL %s", authURL)
+		}
+		resp, err := http.Get(authURL)
+		if err != nil {
+			t.Errorf("Browser request failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}
+
+	result, err := Authorize(ctx, idp.URL, "roamie-cli", nil, openURL)
+	if err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+
+	if result.Nonce == "" || result.IDToken != "id-token-for-"+result.Nonce {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
+
+func TestAuthorize_StateMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A callback that didn't come from our authorization request
+	openURL := func(authURL string) {
+		u, _ := url.Parse(authURL)
+		redirect := u.Query().Get("redirect_uri") + "?code=auth-code&state=forged"
+		if resp, err := http.Get(redirect); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	if _, err := Authorize(ctx, idp.URL, "roamie-cli", nil, openURL); err == nil {
+		t.Error("Expected a forged state to be rejected")
+	}
+}
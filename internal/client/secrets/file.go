@@ -0,0 +1,306 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// PassphraseEnv protects the encrypted file backend with a passphrase
+// instead of the machine key
+const PassphraseEnv = "ROAMIE_SECRETS_PASSPHRASE"
+
+const (
+	secretsDir     = "secrets"
+	keyInfoFile    = "key.json"
+	machineKeyFile = "machine.key"
+
+	kdfMachine    = "machine"
+	kdfPassphrase = "passphrase"
+
+	// keyCheck is encrypted into key.json so a wrong passphrase (or a
+	// changed machine ID) is reported as such rather than as corrupt secrets
+	keyCheck = "roamie-secrets"
+)
+
+var ErrWrongPassphrase = errors.New("cannot decrypt secrets: wrong passphrase or machine key")
+
+// keyInfo records how the file backend's key is derived
+type keyInfo struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Check   []byte `json:"check"`
+}
+
+// fileBackend keeps each secret in its own file under <dir>/secrets,
+// encrypted with XChaCha20-Poly1305. One file per secret means the daemon
+// and the CLI never overwrite each other's secrets.
+type fileBackend struct {
+	dir string
+
+	mu  sync.Mutex
+	key []byte
+}
+
+func newFileBackend(configDir string) *fileBackend {
+	return &fileBackend{dir: filepath.Join(configDir, secretsDir)}
+}
+
+func (b *fileBackend) Name() string {
+	return BackendFile
+}
+
+func (b *fileBackend) Get(name string) (string, error) {
+	data, err := os.ReadFile(b.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+
+	key, err := b.loadKey(false)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(key, data, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+	}
+	return string(plaintext), nil
+}
+
+func (b *fileBackend) Set(name, value string) error {
+	key, err := b.loadKey(true)
+	if err != nil {
+		return err
+	}
+	data, err := seal(key, []byte(value), name)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.path(name), data)
+}
+
+func (b *fileBackend) Delete(name string) error {
+	if err := os.Remove(b.path(name)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *fileBackend) path(name string) string {
+	return filepath.Join(b.dir, name+".enc")
+}
+
+// loadKey derives the encryption key described by key.json, creating
+// key.json first if create is set
+func (b *fileBackend) loadKey(create bool) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.key != nil {
+		return b.key, nil
+	}
+
+	infoPath := filepath.Join(b.dir, keyInfoFile)
+	key, err := b.readKey(infoPath)
+	if os.IsNotExist(err) && create {
+		key, err = b.createKey(infoPath)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read secrets key info: %w", err)
+		}
+		return nil, err
+	}
+
+	b.key = key
+	return key, nil
+}
+
+func (b *fileBackend) readKey(infoPath string) ([]byte, error) {
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var info keyInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets key info: %w", err)
+	}
+	key, err := b.deriveKey(info.KDF, info.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := open(key, info.Check, keyInfoFile); err != nil || string(check) != keyCheck {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+func (b *fileBackend) createKey(infoPath string) ([]byte, error) {
+	if err := utils.MkdirAllWithOwnership(b.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+
+	info := keyInfo{Version: 1, KDF: kdfMachine, Salt: make([]byte, 16)}
+	if os.Getenv(PassphraseEnv) != "" {
+		info.KDF = kdfPassphrase
+	}
+	if _, err := rand.Read(info.Salt); err != nil {
+		return nil, err
+	}
+
+	key, err := b.deriveKey(info.KDF, info.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if info.Check, err = seal(key, []byte(keyCheck), keyInfoFile); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// Another process may be creating the key at the same time; whichever
+	// links key.json first wins and the other uses its key
+	if err := writeFileExclusive(infoPath, data); err != nil {
+		if os.IsExist(err) {
+			return b.readKey(infoPath)
+		}
+		return nil, fmt.Errorf("failed to write secrets key info: %w", err)
+	}
+	return key, nil
+}
+
+func (b *fileBackend) deriveKey(kdf string, salt []byte) ([]byte, error) {
+	switch kdf {
+	case kdfPassphrase:
+		passphrase := os.Getenv(PassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("secrets are protected by a passphrase: set %s", PassphraseEnv)
+		}
+		return argon2.IDKey([]byte(passphrase), salt, 3, 64*1024, 4, chacha20poly1305.KeySize), nil
+
+	case kdfMachine:
+		secret, err := b.machineSecret()
+		if err != nil {
+			return nil, err
+		}
+		username, _, _ := utils.GetActualUser()
+		key := make([]byte, chacha20poly1305.KeySize)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte("roamie secrets v1 "+username)), key); err != nil {
+			return nil, err
+		}
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("unknown secrets key derivation %q", kdf)
+	}
+}
+
+// machineSecret returns the machine ID, or where there is none a random key
+// kept next to the secrets (which only guards against copying the encrypted
+// files on their own)
+func (b *fileBackend) machineSecret() ([]byte, error) {
+	if id, err := utils.MachineID(); err == nil {
+		return []byte(id), nil
+	}
+
+	keyPath := filepath.Join(b.dir, machineKeyFile)
+	if data, err := os.ReadFile(keyPath); err == nil {
+		if key, err := base64.StdEncoding.DecodeString(string(data)); err == nil && len(key) == 32 {
+			return key, nil
+		}
+		return nil, fmt.Errorf("invalid machine key in %s", keyPath)
+	}
+
+	if err := utils.MkdirAllWithOwnership(b.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(keyPath, []byte(base64.StdEncoding.EncodeToString(key))); err != nil {
+		return nil, fmt.Errorf("failed to write machine key: %w", err)
+	}
+	return key, nil
+}
+
+// seal encrypts plaintext with a random nonce, binding it to name so
+// secret files can't be swapped for one another
+func seal(key, plaintext []byte, name string) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(name)), nil
+}
+
+func open(key, data []byte, name string) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(name))
+}
+
+// writeFileExclusive is writeFileAtomic that fails if path already exists
+func writeFileExclusive(path string, data []byte) error {
+	return writeTemp(path, data, os.Link)
+}
+
+// writeFileAtomic writes data with owner-only permissions via a temporary
+// file, so readers never see a partially written secret
+func writeFileAtomic(path string, data []byte) error {
+	return writeTemp(path, data, os.Rename)
+}
+
+func writeTemp(path string, data []byte, place func(oldpath, newpath string) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	utils.FixFileOwnership(tmp.Name())
+	return place(tmp.Name(), path)
+}
@@ -0,0 +1,108 @@
+//go:build linux
+// +build linux
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// keyringTimeout bounds secret-tool calls, which can block on an unlock
+// prompt nobody will answer when running in the background
+const keyringTimeout = 10 * time.Second
+
+// keyringBackend stores secrets in the Secret Service (GNOME Keyring,
+// KWallet) through secret-tool, under service "roamie"
+type keyringBackend struct {
+	// prefix runs secret-tool as the actual user when under sudo, where
+	// root can't reach the user's session bus
+	prefix []string
+	env    []string
+}
+
+// newKeyringBackend returns nil if secret-tool or a session bus is missing
+func newKeyringBackend() Backend {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil
+	}
+
+	sudoUser, uid := os.Getenv("SUDO_USER"), os.Getenv("SUDO_UID")
+	if sudoUser != "" && uid != "" {
+		if _, err := os.Stat("/run/user/" + uid + "/bus"); err != nil {
+			return nil
+		}
+		return &keyringBackend{prefix: []string{"sudo", "-u", sudoUser,
+			"XDG_RUNTIME_DIR=/run/user/" + uid,
+			"DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/" + uid + "/bus",
+		}}
+	}
+
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+		return &keyringBackend{}
+	}
+	// systemd user services may not get the bus address
+	bus := fmt.Sprintf("/run/user/%d/bus", os.Getuid())
+	if _, err := os.Stat(bus); err != nil {
+		return nil
+	}
+	return &keyringBackend{env: []string{"DBUS_SESSION_BUS_ADDRESS=unix:path=" + bus}}
+}
+
+func (b *keyringBackend) Name() string {
+	return BackendKeyring
+}
+
+func (b *keyringBackend) Get(name string) (string, error) {
+	stdout, err := b.run("", "lookup", "service", "roamie", "account", name)
+	if err != nil {
+		return "", err
+	}
+	return stdout, nil
+}
+
+func (b *keyringBackend) Set(name, value string) error {
+	_, err := b.run(value, "store", "--label=Roamie "+name, "service", "roamie", "account", name)
+	return err
+}
+
+func (b *keyringBackend) Delete(name string) error {
+	_, err := b.run("", "clear", "service", "roamie", "account", name)
+	return err
+}
+
+// run runs secret-tool with stdin. Secrets are passed on stdin and read from
+// stdout, never on the command line where other users could see them.
+func (b *keyringBackend) run(stdin string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+	defer cancel()
+
+	argv := append(append([]string{}, b.prefix...), "secret-tool")
+	argv = append(argv, args...)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), b.env...)
+	cmd.Stdin = strings.NewReader(stdin)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("secret-tool timed out (is the keyring locked?)")
+		}
+		var exitErr *exec.ExitError
+		// lookup and clear exit 1 without a message when nothing matches
+		if errors.As(err, &exitErr) && stderr.Len() == 0 && args[0] != "store" {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("secret-tool %s: %s", args[0], strings.TrimSpace(stderr.String()+" "+err.Error()))
+	}
+	return stdout.String(), nil
+}
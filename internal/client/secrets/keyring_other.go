@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package secrets
+
+// newKeyringBackend returns nil: only the Linux Secret Service is supported
+// so far, other platforms use the encrypted file
+func newKeyringBackend() Backend {
+	return nil
+}
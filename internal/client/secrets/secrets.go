@@ -0,0 +1,214 @@
+// Package secrets keeps device secrets (the WireGuard private key, session
+// tokens, the tunnel SSH key) out of plaintext files.
+//
+// Secrets go to the OS keyring when one is available and otherwise to files
+// encrypted with a key derived from a passphrase or the machine ID. Callers
+// store the reference returned by Put ("backend:name") instead of the value.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Backend names, also used as the prefix of references
+const (
+	BackendKeyring   = "keyring"
+	BackendFile      = "file"
+	BackendPlaintext = "plaintext"
+)
+
+// BackendEnv selects the backend: keyring, file, plaintext, or auto (default)
+const BackendEnv = "ROAMIE_SECRETS_BACKEND"
+
+var (
+	ErrNotFound    = errors.New("secret not found")
+	ErrInvalidRef  = errors.New("invalid secret reference")
+	ErrUnavailable = errors.New("secrets backend not available")
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Backend stores secrets by name
+type Backend interface {
+	Name() string
+	Get(name string) (string, error)
+	Set(name, value string) error
+	Delete(name string) error
+}
+
+// Store puts secrets into the configured backend and reads them back from
+// whichever backend a reference points to, so switching backends doesn't
+// lose secrets stored earlier
+type Store struct {
+	dir      string
+	mode     string
+	primary  Backend // nil in plaintext mode
+	backends map[string]Backend
+}
+
+// Open opens the secrets store for the config directory dir
+func Open(dir string) (*Store, error) {
+	s := &Store{
+		dir:      dir,
+		mode:     strings.ToLower(strings.TrimSpace(os.Getenv(BackendEnv))),
+		backends: make(map[string]Backend),
+	}
+
+	switch s.mode {
+	case "", "auto":
+		s.mode = "auto"
+		if keyring := newKeyringBackend(); keyring != nil {
+			s.primary = keyring
+		} else {
+			s.primary = newFileBackend(dir)
+		}
+	case BackendKeyring:
+		keyring := newKeyringBackend()
+		if keyring == nil {
+			return nil, fmt.Errorf("%w: %s is %q but no keyring was found (install secret-tool and run inside a desktop session)", ErrUnavailable, BackendEnv, s.mode)
+		}
+		s.primary = keyring
+	case BackendFile:
+		s.primary = newFileBackend(dir)
+	case BackendPlaintext:
+		// Secrets stay in the config file
+	default:
+		return nil, fmt.Errorf("invalid %s %q (expected keyring, file, plaintext or auto)", BackendEnv, s.mode)
+	}
+
+	if s.primary != nil {
+		s.backends[s.primary.Name()] = s.primary
+	}
+	return s, nil
+}
+
+// Enabled reports whether secrets are kept out of config files. It is false
+// when BackendEnv is "plaintext".
+func (s *Store) Enabled() bool {
+	return s.primary != nil
+}
+
+// Backend returns the name of the backend new secrets go to
+func (s *Store) Backend() string {
+	if s.primary == nil {
+		return BackendPlaintext
+	}
+	return s.primary.Name()
+}
+
+// Put stores value under name and returns its reference. In auto mode a
+// failing keyring (e.g. locked, or no daemon behind the bus) falls back to
+// the encrypted file.
+func (s *Store) Put(name, value string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	if s.primary == nil {
+		return "", ErrUnavailable
+	}
+
+	err := s.primary.Set(name, value)
+	if err != nil && s.mode == "auto" && s.primary.Name() == BackendKeyring {
+		log.Printf("Warning: keyring unavailable (%v), using encrypted file for secrets", err)
+		s.primary = s.backend(BackendFile)
+		err = s.primary.Set(name, value)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to store %s in %s: %w", name, s.primary.Name(), err)
+	}
+	return Ref(s.primary.Name(), name), nil
+}
+
+// Get returns the secret ref points to
+func (s *Store) Get(ref string) (string, error) {
+	backendName, name, err := ParseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	backend := s.backend(backendName)
+	if backend == nil {
+		return "", fmt.Errorf("%w: %s", ErrUnavailable, backendName)
+	}
+	return backend.Get(name)
+}
+
+// Delete removes the secret ref points to. Missing secrets are not an error.
+func (s *Store) Delete(ref string) error {
+	backendName, name, err := ParseRef(ref)
+	if err != nil {
+		return err
+	}
+	backend := s.backend(backendName)
+	if backend == nil {
+		return fmt.Errorf("%w: %s", ErrUnavailable, backendName)
+	}
+	if err := backend.Delete(name); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Lookup finds a secret stored under name without a reference, trying the
+// current backend first. It returns the secret's reference as well.
+func (s *Store) Lookup(name string) (value, ref string, err error) {
+	candidates := []string{BackendKeyring, BackendFile}
+	if s.primary != nil {
+		candidates = append([]string{s.primary.Name()}, candidates...)
+	}
+
+	for _, backendName := range candidates {
+		backend := s.backend(backendName)
+		if backend == nil {
+			continue
+		}
+		value, err := backend.Get(name)
+		if err == nil {
+			return value, Ref(backendName, name), nil
+		}
+		if !errors.Is(err, ErrNotFound) && backendName == s.Backend() {
+			return "", "", err
+		}
+	}
+	return "", "", ErrNotFound
+}
+
+// backend returns the named backend, creating it on first use, or nil if it
+// is not available on this machine
+func (s *Store) backend(name string) Backend {
+	if b, ok := s.backends[name]; ok {
+		return b
+	}
+
+	var b Backend
+	switch name {
+	case BackendKeyring:
+		if keyring := newKeyringBackend(); keyring != nil {
+			b = keyring
+		}
+	case BackendFile:
+		b = newFileBackend(s.dir)
+	}
+	if b != nil {
+		s.backends[name] = b
+	}
+	return b
+}
+
+// Ref returns the reference of secret name in backend
+func Ref(backend, name string) string {
+	return backend + ":" + name
+}
+
+// ParseRef splits a reference into its backend and secret name
+func ParseRef(ref string) (backend, name string, err error) {
+	backend, name, ok := strings.Cut(ref, ":")
+	if !ok || (backend != BackendKeyring && backend != BackendFile) || !validName.MatchString(name) {
+		return "", "", fmt.Errorf("%w %q", ErrInvalidRef, ref)
+	}
+	return backend, name, nil
+}
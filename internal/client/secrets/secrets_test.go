@@ -0,0 +1,164 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestStore(t *testing.T, backend string) (*Store, string) {
+	t.Helper()
+	t.Setenv(BackendEnv, backend)
+	t.Setenv(PassphraseEnv, "")
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return store, dir
+}
+
+func TestStore_FileRoundTrip(t *testing.T) {
+	store, dir := openTestStore(t, BackendFile)
+
+	ref, err := store.Put("jwt", "token-value")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if ref != "file:jwt" {
+		t.Errorf("Expected ref file:jwt, got %s", ref)
+	}
+
+	// Test: the value is not stored in plaintext
+	data, err := os.ReadFile(filepath.Join(dir, secretsDir, "jwt.enc"))
+	if err != nil {
+		t.Fatalf("Secret file missing: %v", err)
+	}
+	if bytes.Contains(data, []byte("token-value")) {
+		t.Error("Secret should be encrypted on disk")
+	}
+
+	// Test: a new store (another process) reads it back
+	other, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	value, err := other.Get(ref)
+	if err != nil || value != "token-value" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	if err := store.Delete(ref); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Get(ref); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := store.Delete(ref); err != nil {
+		t.Errorf("Deleting a missing secret should succeed, got %v", err)
+	}
+}
+
+func TestStore_SecretsCannotBeSwapped(t *testing.T) {
+	store, dir := openTestStore(t, BackendFile)
+	store.Put("jwt", "access")
+	store.Put("refresh_token", "refresh")
+
+	secretsPath := filepath.Join(dir, secretsDir)
+	data, _ := os.ReadFile(filepath.Join(secretsPath, "refresh_token.enc"))
+	os.WriteFile(filepath.Join(secretsPath, "jwt.enc"), data, 0600)
+
+	if _, err := store.Get("file:jwt"); err == nil {
+		t.Error("A secret copied over another should fail to decrypt")
+	}
+}
+
+func TestStore_Passphrase(t *testing.T) {
+	store, dir := openTestStore(t, BackendFile)
+	t.Setenv(PassphraseEnv, "correct horse")
+
+	ref, err := store.Put("private_key", "wg-key")
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Test: a wrong passphrase is reported as such
+	t.Setenv(PassphraseEnv, "wrong")
+	other, _ := Open(dir)
+	if _, err := other.Get(ref); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+
+	// Test: a missing passphrase is reported
+	t.Setenv(PassphraseEnv, "")
+	other, _ = Open(dir)
+	if _, err := other.Get(ref); err == nil {
+		t.Error("Expected an error without the passphrase")
+	}
+
+	t.Setenv(PassphraseEnv, "correct horse")
+	other, _ = Open(dir)
+	if value, err := other.Get(ref); err != nil || value != "wg-key" {
+		t.Errorf("Get = %q, %v", value, err)
+	}
+}
+
+func TestStore_Plaintext(t *testing.T) {
+	store, _ := openTestStore(t, BackendPlaintext)
+
+	if store.Enabled() {
+		t.Error("Plaintext mode should not be enabled")
+	}
+	if _, err := store.Put("jwt", "value"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+}
+
+func TestOpen_InvalidBackend(t *testing.T) {
+	t.Setenv(BackendEnv, "vault")
+	if _, err := Open(t.TempDir()); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+}
+
+func TestStore_Lookup(t *testing.T) {
+	store, _ := openTestStore(t, BackendFile)
+
+	if _, _, err := store.Lookup("tunnel_key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	store.Put("tunnel_key", "pem")
+	value, ref, err := store.Lookup("tunnel_key")
+	if err != nil || value != "pem" || ref != "file:tunnel_key" {
+		t.Errorf("Lookup = %q, %q, %v", value, ref, err)
+	}
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		backend string
+		name    string
+		wantErr bool
+	}{
+		{"keyring:jwt", BackendKeyring, "jwt", false},
+		{"file:refresh_token", BackendFile, "refresh_token", false},
+		{"plaintext:jwt", "", "", true},
+		{"file:../config", "", "", true},
+		{"jwt", "", "", true},
+	}
+
+	for _, tt := range tests {
+		backend, name, err := ParseRef(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRef(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if backend != tt.backend || name != tt.name {
+			t.Errorf("ParseRef(%q) = %q, %q", tt.ref, backend, name)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package ssh
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/sshd"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// SyncKnownHosts trusts the server's host CA in known_hosts for the names of
+// the user's devices. It reports whether known_hosts changed.
+func (m *Manager) SyncKnownHosts(jwt string) (bool, error) {
+	if jwt == "" {
+		return false, fmt.Errorf("not authenticated: JWT token required")
+	}
+
+	ca, err := m.apiClient.GetSSHCA(jwt)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch SSH CA: %w", err)
+	}
+	devices, err := m.apiClient.ListDevices(jwt)
+	if err != nil {
+		return false, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	var patterns []string
+	for _, d := range devices.Devices {
+		if d.DNSName != "" {
+			patterns = append(patterns, d.DNSName)
+		}
+		if d.VpnIP != "" {
+			patterns = append(patterns, d.VpnIP)
+		}
+	}
+	if len(patterns) == 0 {
+		return false, nil
+	}
+
+	knownHosts, err := NewKnownHostsManager()
+	if err != nil {
+		return false, err
+	}
+	changed, err := knownHosts.UpdateCertAuthority(patterns, ca.HostCA)
+	if err != nil {
+		return false, fmt.Errorf("failed to update known_hosts: %w", err)
+	}
+	if changed {
+		log.Printf("SSH CA: trusted host certificates for %d device name(s) in known_hosts", len(patterns))
+	}
+	return changed, nil
+}
+
+// SyncSSHDTrust makes sshd accept certificates from the user CA for this
+// device, as the OS user the server recorded for it, and present a host
+// certificate, renewing it when a third of its lifetime is left. It needs
+// root and reloads sshd when anything changed.
+func (m *Manager) SyncSSHDTrust(cfg *config.Config, trust *sshd.Trust) (bool, error) {
+	if cfg == nil || cfg.JWT == "" || cfg.DeviceID == "" {
+		return false, fmt.Errorf("not authenticated: JWT token required")
+	}
+
+	ca, err := m.apiClient.GetSSHCA(cfg.JWT)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch SSH CA: %w", err)
+	}
+	login, err := m.deviceLogin(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	// A missing host key only costs the host certificate
+	var hostCert string
+	if trust.NeedsHostCertificate(ca.HostCA, time.Now()) {
+		if hostKey, err := trust.HostPublicKey(); err != nil {
+			log.Printf("Warning: no host certificate: %v", err)
+		} else {
+			publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey)))
+			cert, err := m.apiClient.IssueSSHHostCertificate(cfg.DeviceID, publicKey, cfg.JWT)
+			if err != nil {
+				log.Printf("Warning: failed to get host certificate: %v", err)
+			} else {
+				hostCert = cert.Certificate
+				log.Printf("SSH CA: host certificate for %s valid until %s",
+					strings.Join(cert.Principals, ", "), cert.ValidBefore.Local().Format("2006-01-02"))
+			}
+		}
+	}
+
+	changed, err := trust.Install(ca.UserCA, hostCert, login)
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		if err := sshd.Reload(); err != nil {
+			return true, fmt.Errorf("installed SSH CA but failed to reload sshd: %w", err)
+		}
+		log.Println("SSH CA: sshd now accepts Roamie certificates")
+	}
+	return changed, nil
+}
+
+// deviceLogin returns the account user certificates for this device log in
+// as: the one the server issues them for
+func (m *Manager) deviceLogin(cfg *config.Config) (sshd.Login, error) {
+	devices, err := m.apiClient.ListDevices(cfg.JWT)
+	if err != nil {
+		return sshd.Login{}, fmt.Errorf("failed to list devices: %w", err)
+	}
+	for _, d := range devices.Devices {
+		if d.ID != cfg.DeviceID {
+			continue
+		}
+		if d.Username == nil || *d.Username == "" {
+			return sshd.Login{}, fmt.Errorf("no username recorded for this device, log in again")
+		}
+		return sshd.Login{Username: *d.Username, Principal: models.SSHDevicePrincipal(d.ID)}, nil
+	}
+	return sshd.Login{}, fmt.Errorf("device %s not found", cfg.DeviceID)
+}
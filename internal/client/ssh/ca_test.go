@@ -0,0 +1,151 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestKnownHostsManager_UpdateCertAuthority(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	m := &KnownHostsManager{filePath: path}
+	hostCA := authorizedKey(newTestSigner(t).PublicKey())
+
+	own := "github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n"
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(own), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := m.UpdateCertAuthority([]string{"laptop.alice.roamie.internal", "10.100.0.2"}, hostCA)
+	if err != nil || !changed {
+		t.Fatalf("Expected known_hosts to change, got changed=%v err=%v", changed, err)
+	}
+	data, _ := os.ReadFile(path)
+	want := own + "\n" + KnownHostsStartMarker + "\n@cert-authority laptop.alice.roamie.internal,10.100.0.2 " + hostCA + "\n" + KnownHostsEndMarker + "\n"
+	if string(data) != want {
+		t.Errorf("known_hosts =\n%s\nwant\n%s", data, want)
+	}
+
+	// Test: unchanged patterns leave the file alone
+	if changed, err := m.UpdateCertAuthority([]string{"laptop.alice.roamie.internal", "10.100.0.2"}, hostCA); err != nil || changed {
+		t.Errorf("Expected no change, got changed=%v err=%v", changed, err)
+	}
+
+	// Test: new devices replace the section instead of adding another
+	if _, err := m.UpdateCertAuthority([]string{"10.100.0.2", "10.100.0.3"}, hostCA); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(path)
+	if strings.Count(string(data), KnownHostsStartMarker) != 1 || !strings.Contains(string(data), "10.100.0.3") || !strings.HasPrefix(string(data), own) {
+		t.Errorf("Unexpected known_hosts after update:\n%s", data)
+	}
+
+	if _, err := m.UpdateCertAuthority(nil, hostCA); err == nil {
+		t.Error("Expected an error without host patterns")
+	}
+}
+
+func TestNewSessionKey(t *testing.T) {
+	userCA, hostCA := newTestSigner(t), newTestSigner(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			DeviceID  string `json:"device_id"`
+			PublicKey string `json:"public_key"`
+		}
+		if r.URL.Path != "/api/ssh/certificates" || json.NewDecoder(r.Body).Decode(&req) != nil || req.DeviceID != "device-1" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+		if err != nil {
+			http.Error(w, "bad key", http.StatusBadRequest)
+			return
+		}
+		cert := &ssh.Certificate{
+			Key:             key,
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"alice"},
+			ValidBefore:     uint64(time.Now().Add(5 * time.Minute).Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, userCA); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(api.SSHCertificate{
+			Certificate: authorizedKey(cert),
+			Principals:  cert.ValidPrincipals,
+			HostCA:      authorizedKey(hostCA.PublicKey()),
+		})
+	}))
+	defer server.Close()
+
+	k, err := NewSessionKey(api.NewClient(server.URL), "device-1", "laptop.alice.roamie.internal", "token")
+	if err != nil {
+		t.Fatalf("NewSessionKey failed: %v", err)
+	}
+
+	// The certificate is for the generated key
+	keyData, err := os.ReadFile(k.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		t.Fatalf("Session key isn't a valid private key: %v", err)
+	}
+	certData, _ := os.ReadFile(k.CertFile)
+	certKey, _, _, _, err := ssh.ParseAuthorizedKey(certData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, ok := certKey.(*ssh.Certificate); !ok || authorizedKey(cert.Key) != authorizedKey(signer.PublicKey()) {
+		t.Error("Certificate should certify the session key")
+	}
+
+	knownHosts, _ := os.ReadFile(k.KnownHostsFile)
+	if !strings.HasPrefix(string(knownHosts), "@cert-authority laptop.alice.roamie.internal ") {
+		t.Errorf("Unexpected session known_hosts: %s", knownHosts)
+	}
+	args := strings.Join(k.Args("laptop.alice.roamie.internal"), " ")
+	if !strings.Contains(args, "CertificateFile="+k.CertFile) || !strings.Contains(args, "HostKeyAlias=laptop.alice.roamie.internal") {
+		t.Errorf("Unexpected ssh args: %s", args)
+	}
+
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(k.KeyFile); !os.IsNotExist(err) {
+		t.Error("Close should remove the session key")
+	}
+}
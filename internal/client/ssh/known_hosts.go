@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	KnownHostsStartMarker = "# >>> ROAMIE SSH CA - DO NOT EDIT THIS SECTION >>>"
+	KnownHostsEndMarker   = "# <<< ROAMIE SSH CA - END <<<"
+)
+
+// KnownHostsManager keeps the Roamie host CA in ~/.ssh/known_hosts, so ssh
+// verifies devices by their host certificates instead of asking on first
+// connect
+type KnownHostsManager struct {
+	filePath string
+}
+
+// NewKnownHostsManager creates a known_hosts manager for the actual user
+func NewKnownHostsManager() (*KnownHostsManager, error) {
+	_, home, err := utils.GetActualUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	return &KnownHostsManager{
+		filePath: filepath.Join(home, ".ssh", "known_hosts"),
+	}, nil
+}
+
+// CertAuthorityLine returns a known_hosts line trusting hostCA to certify
+// the hosts matching patterns
+func CertAuthorityLine(patterns []string, hostCA string) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostCA))
+	if err != nil {
+		return "", fmt.Errorf("invalid host CA: %w", err)
+	}
+	if len(patterns) == 0 {
+		return "", fmt.Errorf("no host patterns")
+	}
+	return "@cert-authority " + strings.Join(patterns, ",") + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))), nil
+}
+
+// UpdateCertAuthority replaces the Roamie section with a line trusting
+// hostCA for patterns, keeping the user's own entries. It reports whether the
+// file changed.
+func (m *KnownHostsManager) UpdateCertAuthority(patterns []string, hostCA string) (bool, error) {
+	line, err := CertAuthorityLine(patterns, hostCA)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := os.ReadFile(m.filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	var lines []string
+	inRoamieSection := false
+	for _, existing := range strings.Split(strings.TrimRight(string(current), "\n"), "\n") {
+		switch strings.TrimSpace(existing) {
+		case KnownHostsStartMarker:
+			inRoamieSection = true
+			continue
+		case KnownHostsEndMarker:
+			inRoamieSection = false
+			continue
+		}
+		if !inRoamieSection && (existing != "" || len(lines) > 0) {
+			lines = append(lines, existing)
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, KnownHostsStartMarker, line, KnownHostsEndMarker)
+
+	content := strings.Join(lines, "\n") + "\n"
+	if content == string(current) {
+		return false, nil
+	}
+
+	if err := utils.MkdirAllWithOwnership(filepath.Dir(m.filePath), 0700); err != nil {
+		return false, fmt.Errorf("failed to create .ssh directory: %w", err)
+	}
+	tmpFile := m.filePath + ".tmp"
+	if err := utils.WriteFileWithOwnership(tmpFile, []byte(content), 0644); err != nil {
+		return false, fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := os.Rename(tmpFile, m.filePath); err != nil {
+		os.Remove(tmpFile)
+		return false, fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionKey is a throwaway key with a short-lived certificate for one
+// `roamie ssh` login. It lives in a private temporary directory until Close.
+type SessionKey struct {
+	dir            string
+	KeyFile        string
+	CertFile       string
+	KnownHostsFile string // Trusts the host CA for the device; empty if the server sent none
+	Certificate    *api.SSHCertificate
+}
+
+// NewSessionKey generates a key and has the server certify it for logging
+// in to deviceID. hostAlias is the name the device's host certificate is
+// checked against.
+func NewSessionKey(apiClient *api.Client, deviceID, hostAlias, jwt string) (*SessionKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+	cert, err := apiClient.IssueSSHCertificate(deviceID, publicKey, jwt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSH certificate: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, "roamie ssh session")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "roamie-ssh-")
+	if err != nil {
+		return nil, err
+	}
+	k := &SessionKey{
+		dir:         dir,
+		KeyFile:     filepath.Join(dir, "id_ed25519"),
+		CertFile:    filepath.Join(dir, "id_ed25519-cert.pub"),
+		Certificate: cert,
+	}
+
+	files := map[string][]byte{
+		k.KeyFile:  pem.EncodeToMemory(block),
+		k.CertFile: []byte(cert.Certificate + "\n"),
+	}
+	if cert.HostCA != "" && hostAlias != "" {
+		line, err := CertAuthorityLine([]string{hostAlias}, cert.HostCA)
+		if err != nil {
+			k.Close()
+			return nil, err
+		}
+		k.KnownHostsFile = filepath.Join(dir, "known_hosts")
+		files[k.KnownHostsFile] = []byte(line + "\n")
+	}
+
+	for path, data := range files {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			k.Close()
+			return nil, fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+		}
+	}
+	return k, nil
+}
+
+// Args returns ssh options that log in with the certificate and verify the
+// device's host certificate as hostAlias. The user's own keys and
+// known_hosts still work for devices that don't trust the CA yet.
+func (k *SessionKey) Args(hostAlias string) []string {
+	args := []string{"-i", k.KeyFile, "-o", "CertificateFile=" + k.CertFile}
+	if k.KnownHostsFile != "" {
+		// New host keys are still recorded in the first file
+		args = append(args,
+			"-o", "UserKnownHostsFile=~/.ssh/known_hosts "+k.KnownHostsFile,
+			"-o", "HostKeyAlias="+hostAlias,
+		)
+	}
+	return args
+}
+
+// Close removes the key and certificate
+func (k *SessionKey) Close() error {
+	return os.RemoveAll(k.dir)
+}
@@ -118,3 +118,9 @@ Option 2 - System Preferences:
   2. Go to Sharing
   3. Check 'Remote Login'`
 }
+
+// reloadSSHD is a no-op: launchd starts sshd for every connection, so new
+// settings apply to the next login
+func reloadSSHD() error {
+	return nil
+}
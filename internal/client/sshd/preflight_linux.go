@@ -337,3 +337,19 @@ Then enable and start the service:
   sudo systemctl enable --now sshd`
 	}
 }
+
+// reloadSSHD reloads the sshd service (named ssh on Debian/Ubuntu)
+func reloadSSHD() error {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return fmt.Errorf("systemctl not found, restart sshd to apply the Roamie settings")
+	}
+	for _, service := range []string{"ssh", "sshd"} {
+		if exec.Command("systemctl", "is-active", "--quiet", service).Run() == nil {
+			if output, err := runCommand("systemctl", "reload", service).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to reload %s: %s", service, strings.TrimSpace(string(output)))
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("sshd service is not running")
+}
@@ -228,3 +228,8 @@ Option 2 - Settings:
   Settings > Apps > Optional features > Add a feature
   Search for 'OpenSSH Server' and install`
 }
+
+// reloadSSHD is not needed: the SSH CA isn't installed on Windows
+func reloadSSHD() error {
+	return fmt.Errorf("not supported on Windows")
+}
@@ -0,0 +1,256 @@
+package sshd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Files the Roamie SSH CA trust is installed as, relative to the sshd
+// config directory
+const (
+	UserCAFile      = "roamie_user_ca.pub"
+	TrustConfigFile = "sshd_config.d/roamie.conf"
+	HostKeyFile     = "ssh_host_ed25519_key.pub"
+	HostCertFile    = "ssh_host_ed25519_key-cert.pub"
+	PrincipalsDir   = "roamie_principals"
+)
+
+const (
+	mainConfigFile   = "sshd_config"
+	trustConfigMagic = "# Managed by Roamie: trust the Roamie SSH CA"
+)
+
+// sshdCommand checks new settings before sshd is reloaded; empty skips the
+// check
+var sshdCommand = "sshd"
+
+// ErrNoConfigInclude means sshd_config doesn't read sshd_config.d, so the
+// Roamie settings would be ignored
+var ErrNoConfigInclude = errors.New("sshd_config does not include sshd_config.d/*.conf")
+
+// Trust installs the Roamie SSH CA into sshd: sshd accepts user
+// certificates signed by the user CA and presents a host certificate signed
+// by the host CA
+type Trust struct {
+	Dir string // sshd config directory, e.g. /etc/ssh
+}
+
+// Login is the local account certificates log in as and the principal they
+// must carry for it (the device's principal)
+type Login struct {
+	Username  string
+	Principal string
+}
+
+// DefaultTrust returns the trust for the system sshd
+func DefaultTrust() *Trust {
+	return &Trust{Dir: "/etc/ssh"}
+}
+
+// HostPublicKey returns sshd's Ed25519 host public key, which is certified
+func (t *Trust) HostPublicKey() (ssh.PublicKey, error) {
+	data, err := os.ReadFile(filepath.Join(t.Dir, HostKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sshd host key: %w", err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sshd host key: %w", err)
+	}
+	return key, nil
+}
+
+// HostCertificate returns the installed host certificate, or nil if there
+// is none
+func (t *Trust) HostCertificate() (*ssh.Certificate, error) {
+	data, err := os.ReadFile(filepath.Join(t.Dir, HostCertFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host certificate: %w", err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", HostCertFile)
+	}
+	return cert, nil
+}
+
+// NeedsHostCertificate reports whether the host certificate is missing, for
+// another key, signed by another CA, or has less than a third of its
+// lifetime left
+func (t *Trust) NeedsHostCertificate(hostCA string, now time.Time) bool {
+	cert, err := t.HostCertificate()
+	if err != nil || cert == nil {
+		return true
+	}
+	hostKey, err := t.HostPublicKey()
+	if err != nil || !bytes.Equal(cert.Key.Marshal(), hostKey.Marshal()) {
+		return true
+	}
+	ca, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostCA))
+	if err != nil || !bytes.Equal(cert.SignatureKey.Marshal(), ca.Marshal()) {
+		return true
+	}
+
+	validAfter := time.Unix(int64(cert.ValidAfter), 0)
+	validBefore := time.Unix(int64(cert.ValidBefore), 0)
+	return now.After(validBefore.Add(-validBefore.Sub(validAfter) / 3))
+}
+
+// Install writes the user CA, the host certificate (if hostCert isn't empty)
+// and the sshd settings that use them. User certificates only log in as
+// login.Username, and only when they carry login.Principal: the user CA signs
+// certificates for every user's devices, so the username alone would let one
+// user log in to another's device. It reports whether anything changed, in
+// which case sshd must be reloaded. Settings sshd rejects are removed again.
+func (t *Trust) Install(userCA, hostCert string, login Login) (bool, error) {
+	if runtime.GOOS == "windows" {
+		return false, fmt.Errorf("installing the SSH CA is not supported on Windows")
+	}
+	if login.Username == "" || strings.ContainsAny(login.Username, "/\\") || strings.HasPrefix(login.Username, ".") {
+		return false, fmt.Errorf("invalid username %q", login.Username)
+	}
+	if login.Principal == "" {
+		return false, fmt.Errorf("no principal for %s", login.Username)
+	}
+	if err := t.checkInclude(); err != nil {
+		return false, err
+	}
+
+	settings := []string{
+		trustConfigMagic,
+		"TrustedUserCAKeys " + filepath.Join(t.Dir, UserCAFile),
+		"AuthorizedPrincipalsFile " + filepath.Join(t.Dir, PrincipalsDir, "%u"),
+	}
+	principalsFile := filepath.Join(PrincipalsDir, login.Username)
+	files := map[string]string{
+		UserCAFile:     strings.TrimSpace(userCA) + "\n",
+		principalsFile: login.Principal + "\n",
+	}
+	if hostCert != "" {
+		files[HostCertFile] = strings.TrimSpace(hostCert) + "\n"
+	}
+	// Keep presenting an installed certificate when this sync didn't renew it
+	if hostCert != "" || fileExists(filepath.Join(t.Dir, HostCertFile)) {
+		settings = append(settings, "HostCertificate "+filepath.Join(t.Dir, HostCertFile))
+	}
+	files[TrustConfigFile] = strings.Join(settings, "\n") + "\n"
+
+	changed := false
+	for name, content := range files {
+		path := filepath.Join(t.Dir, name)
+		if existing, err := os.ReadFile(path); err == nil && string(existing) == content {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return changed, err
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return changed, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		changed = true
+	}
+
+	// Only the current account can log in with a certificate
+	stale, err := t.removeOtherPrincipals(login.Username)
+	if err != nil {
+		return changed, err
+	}
+	changed = changed || stale
+
+	if changed {
+		if err := t.validate(); err != nil {
+			os.Remove(filepath.Join(t.Dir, TrustConfigFile))
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+// removeOtherPrincipals removes the principals files of other accounts, e.g.
+// after the device was logged in again as another user
+func (t *Trust) removeOtherPrincipals(username string) (bool, error) {
+	entries, err := os.ReadDir(filepath.Join(t.Dir, PrincipalsDir))
+	if err != nil {
+		return false, err
+	}
+	removed := false
+	for _, entry := range entries {
+		if entry.Name() == username {
+			continue
+		}
+		if err := os.Remove(filepath.Join(t.Dir, PrincipalsDir, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed = true
+	}
+	return removed, nil
+}
+
+// Installed reports whether sshd is configured to trust the Roamie CA
+func (t *Trust) Installed() bool {
+	data, err := os.ReadFile(filepath.Join(t.Dir, TrustConfigFile))
+	return err == nil && strings.HasPrefix(string(data), trustConfigMagic)
+}
+
+// checkInclude makes sure sshd reads the drop-in directory (the default on
+// Debian, Ubuntu, Fedora and recent macOS)
+func (t *Trust) checkInclude() error {
+	file, err := os.Open(filepath.Join(t.Dir, mainConfigFile))
+	if err != nil {
+		return fmt.Errorf("failed to read sshd config: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && strings.EqualFold(fields[0], "Include") && strings.Contains(fields[1], "sshd_config.d") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: add 'Include %s' at the top of %s",
+		ErrNoConfigInclude, filepath.Join(t.Dir, "sshd_config.d", "*.conf"), filepath.Join(t.Dir, mainConfigFile))
+}
+
+// validate runs sshd's config check when sshd is available
+func (t *Trust) validate() error {
+	if sshdCommand == "" {
+		return nil
+	}
+	sshdPath, err := exec.LookPath(sshdCommand)
+	if err != nil {
+		return nil
+	}
+	output, err := exec.Command(sshdPath, "-t", "-f", filepath.Join(t.Dir, mainConfigFile)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sshd rejected the Roamie settings: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Reload makes the running sshd pick up new settings
+func Reload() error {
+	return reloadSSHD()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
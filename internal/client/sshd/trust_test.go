@@ -0,0 +1,149 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// newTestTrust returns a trust for a temporary sshd config directory with an
+// Ed25519 host key
+func newTestTrust(t *testing.T, config string) (*Trust, ssh.Signer) {
+	t.Helper()
+	sshdCommand = ""
+	t.Cleanup(func() { sshdCommand = "sshd" })
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, mainConfigFile), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hostKey := newTestSigner(t)
+	if err := os.WriteFile(filepath.Join(dir, HostKeyFile), []byte(authorizedKey(hostKey.PublicKey())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &Trust{Dir: dir}, hostKey
+}
+
+func signHostCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, validAfter, validBefore time.Time) string {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"10.100.0.2"},
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return authorizedKey(cert)
+}
+
+func TestTrustInstall(t *testing.T) {
+	trust, hostKey := newTestTrust(t, "Include /etc/ssh/sshd_config.d/*.conf\nPasswordAuthentication no\n")
+	userCA, hostCA := newTestSigner(t), newTestSigner(t)
+	now := time.Now()
+
+	if !trust.NeedsHostCertificate(authorizedKey(hostCA.PublicKey()), now) {
+		t.Error("Expected a host certificate to be needed before one is installed")
+	}
+
+	login := Login{Username: "alice", Principal: "roamie-device:device-a"}
+	hostCert := signHostCert(t, hostCA, hostKey.PublicKey(), now.Add(-time.Minute), now.Add(90*24*time.Hour))
+	changed, err := trust.Install(authorizedKey(userCA.PublicKey()), hostCert, login)
+	if err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	if !changed || !trust.Installed() {
+		t.Fatal("Expected the trust to be installed")
+	}
+
+	settings, err := os.ReadFile(filepath.Join(trust.Dir, TrustConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"TrustedUserCAKeys " + filepath.Join(trust.Dir, UserCAFile),
+		"AuthorizedPrincipalsFile " + filepath.Join(trust.Dir, PrincipalsDir, "%u"),
+		"HostCertificate " + filepath.Join(trust.Dir, HostCertFile),
+	} {
+		if !strings.Contains(string(settings), want) {
+			t.Errorf("Settings missing %q:\n%s", want, settings)
+		}
+	}
+
+	// Test: certificates only log in as alice, and only with this device's principal
+	if principals, err := os.ReadFile(filepath.Join(trust.Dir, PrincipalsDir, "alice")); err != nil || string(principals) != "roamie-device:device-a\n" {
+		t.Errorf("Principals of alice = %q, %v", principals, err)
+	}
+
+	// Test: a fresh certificate doesn't need renewing, and reinstalling the
+	// same CA without a new certificate changes nothing
+	if trust.NeedsHostCertificate(authorizedKey(hostCA.PublicKey()), now) {
+		t.Error("Fresh host certificate shouldn't need renewing")
+	}
+	if changed, err := trust.Install(authorizedKey(userCA.PublicKey()), "", login); err != nil || changed {
+		t.Errorf("Expected no change on reinstall, got changed=%v err=%v", changed, err)
+	}
+
+	// Test: renew when a third of the lifetime is left, or the CA changed
+	if !trust.NeedsHostCertificate(authorizedKey(hostCA.PublicKey()), now.Add(61*24*time.Hour)) {
+		t.Error("Expected renewal with less than a third of the lifetime left")
+	}
+	if !trust.NeedsHostCertificate(authorizedKey(newTestSigner(t).PublicKey()), now) {
+		t.Error("Expected renewal after the host CA changed")
+	}
+
+	// Test: after logging in as another user, alice can't log in with certificates
+	changed, err = trust.Install(authorizedKey(userCA.PublicKey()), "", Login{Username: "bob", Principal: "roamie-device:device-a"})
+	if err != nil || !changed {
+		t.Fatalf("Expected a change for another user, got changed=%v err=%v", changed, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(trust.Dir, PrincipalsDir))
+	if len(entries) != 1 || entries[0].Name() != "bob" {
+		t.Errorf("Expected only bob's principals, got %v", entries)
+	}
+
+	// Test: usernames can't escape the principals directory
+	for _, username := range []string{"", "../sshd_config", ".."} {
+		if _, err := trust.Install(authorizedKey(userCA.PublicKey()), "", Login{Username: username, Principal: "roamie-device:device-a"}); err == nil {
+			t.Errorf("Expected username %q to be rejected", username)
+		}
+	}
+}
+
+func TestTrustInstall_RequiresInclude(t *testing.T) {
+	trust, _ := newTestTrust(t, "PasswordAuthentication no\n")
+
+	_, err := trust.Install(authorizedKey(newTestSigner(t).PublicKey()), "", Login{Username: "alice", Principal: "roamie-device:device-a"})
+	if !errors.Is(err, ErrNoConfigInclude) {
+		t.Fatalf("Expected ErrNoConfigInclude, got %v", err)
+	}
+	if trust.Installed() {
+		t.Error("Nothing should be installed when sshd ignores sshd_config.d")
+	}
+}
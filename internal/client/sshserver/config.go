@@ -0,0 +1,154 @@
+package sshserver
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	sshpkg "github.com/kamikazebr/roamie-desktop/internal/client/ssh"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// FromClientConfig builds the embedded server configuration from the saved
+// client config: the daemon user logs in with the keys Roamie syncs into
+// authorized_keys, or with certificates for this device
+func FromClientConfig(cfg *config.Config) (Config, error) {
+	username, home, err := utils.GetActualUser()
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	if username == "" {
+		return Config{}, fmt.Errorf("failed to get username")
+	}
+
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return Config{}, err
+	}
+	hostKey, err := LoadOrGenerateHostKey(filepath.Join(configDir, HostKeyFile))
+	if err != nil {
+		return Config{}, err
+	}
+
+	keysManager, err := sshpkg.NewAuthorizedKeysManager()
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Without a device ID no certificate is accepted
+	var principal string
+	if cfg.DeviceID != "" {
+		principal = models.SSHDevicePrincipal(cfg.DeviceID)
+	}
+
+	return Config{
+		Addr:      ListenAddr(cfg.EmbeddedSSHPort),
+		HostKey:   hostKey,
+		Username:  username,
+		HomeDir:   home,
+		Shell:     loginShell(username),
+		Principal: principal,
+		AuthorizedKeys: func() ([]ssh.PublicKey, error) {
+			roamieKeys, _, err := keysManager.ReadKeys()
+			if err != nil {
+				return nil, err
+			}
+			return parseKeys(roamieKeys), nil
+		},
+	}, nil
+}
+
+// LoadOrGenerateHostKey loads the host key from path, generating an Ed25519
+// key on first use
+func LoadOrGenerateHostKey(path string) (ssh.Signer, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host key %s: %w", path, err)
+		}
+		return signer, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "roamie embedded ssh")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode host key: %w", err)
+	}
+	if err := utils.MkdirAllWithOwnership(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := utils.WriteFileWithOwnership(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save host key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("✓ Generated embedded SSH host key: %s", ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
+}
+
+// ParseUserCA parses the user CA in authorized_keys format
+func ParseUserCA(userCA string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(userCA))
+	if err != nil {
+		return nil, fmt.Errorf("invalid user CA: %w", err)
+	}
+	return key, nil
+}
+
+// parseKeys parses authorized_keys lines, skipping invalid ones
+func parseKeys(lines []string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	for _, line := range lines {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// loginShell returns the user's shell from /etc/passwd, falling back to
+// $SHELL and then the platform default
+func loginShell(username string) string {
+	if runtime.GOOS == "windows" {
+		if comspec := os.Getenv("COMSPEC"); comspec != "" {
+			return comspec
+		}
+		return "cmd.exe"
+	}
+
+	if file, err := os.Open("/etc/passwd"); err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) == 7 && fields[0] == username && fields[6] != "" {
+				return fields[6]
+			}
+		}
+	}
+
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
@@ -0,0 +1,174 @@
+package sshserver
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/forward"
+	"golang.org/x/crypto/ssh"
+)
+
+// directTCPIP is the payload of a direct-tcpip channel (ssh -L, RFC 4254 7.2)
+type directTCPIP struct {
+	Host       string
+	Port       uint32
+	OriginHost string
+	OriginPort uint32
+}
+
+// tcpipForward is the payload of tcpip-forward requests (ssh -R, RFC 4254 7.1)
+type tcpipForward struct {
+	Host string
+	Port uint32
+}
+
+// forwardedTCPIP is the payload of channels opened for remote forwards
+type forwardedTCPIP struct {
+	Host       string
+	Port       uint32
+	OriginHost string
+	OriginPort uint32
+}
+
+// handleDirectTCPIP connects a local forward to its target
+func handleDirectTCPIP(newChannel ssh.NewChannel) {
+	var req directTCPIP
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &req); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	target := net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port)))
+	conn, err := net.DialTimeout("tcp", target, 10*time.Second)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	forward.Pipe(context.Background(), channel, conn, forward.Options{})
+}
+
+// remoteForwards are the listeners a connection opened with ssh -R
+type remoteForwards struct {
+	conn      *ssh.ServerConn
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+}
+
+func newRemoteForwards(conn *ssh.ServerConn) *remoteForwards {
+	return &remoteForwards{
+		conn:      conn,
+		listeners: make(map[string]net.Listener),
+	}
+}
+
+// handleRequest opens a remote forward. Like sshd with GatewayPorts off,
+// forwards only listen on localhost.
+func (f *remoteForwards) handleRequest(req *ssh.Request) {
+	var payload tcpipForward
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		req.Reply(false, nil)
+		return
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(bindHost(payload.Host), strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		log.Printf("Embedded SSH: remote forward %s:%d failed: %v", payload.Host, payload.Port, err)
+		req.Reply(false, nil)
+		return
+	}
+
+	// Port 0 asks us to pick one, which the client needs to know
+	port := uint32(l.Addr().(*net.TCPAddr).Port)
+	f.mu.Lock()
+	f.listeners[forwardKey(payload.Host, port)] = l
+	f.mu.Unlock()
+
+	var reply []byte
+	if payload.Port == 0 {
+		reply = ssh.Marshal(struct{ Port uint32 }{port})
+	}
+	req.Reply(true, reply)
+
+	go f.serve(l, payload.Host, port)
+}
+
+// handleCancel closes a remote forward
+func (f *remoteForwards) handleCancel(req *ssh.Request) {
+	var payload tcpipForward
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		req.Reply(false, nil)
+		return
+	}
+
+	key := forwardKey(payload.Host, payload.Port)
+	f.mu.Lock()
+	l, ok := f.listeners[key]
+	delete(f.listeners, key)
+	f.mu.Unlock()
+
+	if ok {
+		l.Close()
+	}
+	req.Reply(ok, nil)
+}
+
+// serve opens a forwarded-tcpip channel to the client for every connection
+func (f *remoteForwards) serve(l net.Listener, host string, port uint32) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			origin := conn.RemoteAddr().(*net.TCPAddr)
+			payload := ssh.Marshal(forwardedTCPIP{
+				Host:       host,
+				Port:       port,
+				OriginHost: origin.IP.String(),
+				OriginPort: uint32(origin.Port),
+			})
+			channel, reqs, err := f.conn.OpenChannel("forwarded-tcpip", payload)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			forward.Pipe(context.Background(), channel, conn, forward.Options{})
+		}()
+	}
+}
+
+func (f *remoteForwards) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, l := range f.listeners {
+		l.Close()
+		delete(f.listeners, key)
+	}
+}
+
+// bindHost keeps loopback addresses and maps everything else to 127.0.0.1
+func bindHost(host string) string {
+	switch host {
+	case "localhost", "127.0.0.1", "::1":
+		return host
+	}
+	return "127.0.0.1"
+}
+
+func forwardKey(host string, port uint32) string {
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
@@ -0,0 +1,220 @@
+// Package sshserver is an SSH server embedded in the daemon for hosts without
+// sshd. It runs as the daemon user and only lets that user log in, with the
+// keys synced by Roamie or a certificate from the Roamie SSH CA.
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultPort is where the embedded server listens unless configured
+	// otherwise. It only listens on localhost: remote logins come in through
+	// the reverse tunnel.
+	DefaultPort = 2022
+
+	// HostKeyFile is the embedded server's host key, in the config directory
+	HostKeyFile = "embedded_ssh_host_key"
+)
+
+// Config describes the embedded SSH server
+type Config struct {
+	Addr     string     // Listen address, e.g. 127.0.0.1:2022
+	HostKey  ssh.Signer // Host key presented to clients
+	Username string     // The only user that can log in (the daemon user)
+	HomeDir  string     // Working directory for sessions
+	Shell    string     // Login shell
+
+	// AuthorizedKeys returns the keys allowed to log in. It is called on every
+	// login, so keys synced in the meantime take effect immediately.
+	AuthorizedKeys func() ([]ssh.PublicKey, error)
+
+	// UserCA, if set, also admits user certificates it signed for Principal
+	// (the device's principal, so certificates for other devices don't work)
+	UserCA    ssh.PublicKey
+	Principal string
+}
+
+// ListenAddr returns the local address the embedded server listens on for a
+// configured port (0 means DefaultPort)
+func ListenAddr(port int) string {
+	if port == 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+// Server is a running embedded SSH server
+type Server struct {
+	cfg       Config
+	sshConfig *ssh.ServerConfig
+	listener  net.Listener
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start listens on cfg.Addr and serves SSH connections until Close
+func Start(ctx context.Context, cfg Config) (*Server, error) {
+	if cfg.HostKey == nil {
+		return nil, fmt.Errorf("no host key")
+	}
+	if cfg.Username == "" {
+		return nil, fmt.Errorf("no username")
+	}
+
+	serverCtx, cancel := context.WithCancel(ctx)
+	s := &Server{
+		cfg:    cfg,
+		ctx:    serverCtx,
+		cancel: cancel,
+	}
+	s.sshConfig = &ssh.ServerConfig{
+		PublicKeyCallback: s.authenticate,
+		ServerVersion:     "SSH-2.0-Roamie",
+	}
+	s.sshConfig.AddHostKey(cfg.HostKey)
+
+	l, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.listener = l
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return s, nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops accepting connections and closes the open ones
+func (s *Server) Close() {
+	s.cancel()
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				log.Printf("Embedded SSH: accept failed: %v", err)
+			}
+			return
+		}
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn runs the SSH handshake and serves the connection's channels
+func (s *Server) handleConn(nConn net.Conn) {
+	defer s.wg.Done()
+
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, s.sshConfig)
+	if err != nil {
+		log.Printf("Embedded SSH: handshake with %s failed: %v", nConn.RemoteAddr(), err)
+		nConn.Close()
+		return
+	}
+	defer conn.Close()
+	log.Printf("Embedded SSH: %s logged in from %s (%s)", conn.User(), conn.RemoteAddr(), conn.Permissions.Extensions["key-id"])
+
+	// Closing the connection on shutdown ends the loops below
+	stop := context.AfterFunc(s.ctx, func() { conn.Close() })
+	defer stop()
+
+	forwards := newRemoteForwards(conn)
+	defer forwards.closeAll()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for req := range reqs {
+			switch req.Type {
+			case "tcpip-forward":
+				forwards.handleRequest(req)
+			case "cancel-tcpip-forward":
+				forwards.handleCancel(req)
+			default:
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+			}
+		}
+	}()
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.handleSession(newChannel)
+		case "direct-tcpip":
+			go handleDirectTCPIP(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// authenticate admits the daemon user with an authorized key or a
+// certificate from the user CA
+func (s *Server) authenticate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if conn.User() != s.cfg.Username {
+		return nil, fmt.Errorf("user %q can't log in here", conn.User())
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		if err := checkUserCertificate(s.cfg.UserCA, cert, s.cfg.Principal); err != nil {
+			return nil, err
+		}
+		return &ssh.Permissions{Extensions: map[string]string{"key-id": cert.KeyId}}, nil
+	}
+
+	if s.cfg.AuthorizedKeys == nil {
+		return nil, fmt.Errorf("no authorized keys")
+	}
+	keys, err := s.cfg.AuthorizedKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return &ssh.Permissions{Extensions: map[string]string{"key-id": ssh.FingerprintSHA256(key)}}, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s is not authorized", ssh.FingerprintSHA256(key))
+}
+
+// checkUserCertificate verifies a certificate was signed by userCA for
+// principal and is currently valid. CertChecker alone doesn't check who
+// signed it.
+func checkUserCertificate(userCA ssh.PublicKey, cert *ssh.Certificate, principal string) error {
+	if userCA == nil || principal == "" {
+		return fmt.Errorf("certificates are not accepted")
+	}
+	if cert.CertType != ssh.UserCert {
+		return fmt.Errorf("not a user certificate")
+	}
+	if !bytes.Equal(cert.SignatureKey.Marshal(), userCA.Marshal()) {
+		return fmt.Errorf("certificate signed by an unknown authority")
+	}
+	checker := &ssh.CertChecker{}
+	return checker.CheckCert(principal, cert)
+}
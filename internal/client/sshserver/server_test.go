@@ -0,0 +1,327 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Devices of two different users
+const (
+	deviceA = "6f1c2a4e-0d7b-4c1e-9a55-2f8e3b7d1a01"
+	deviceB = "b3d94e70-5a2c-4f86-8e13-7c0a9d6f2b02"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+	return signer
+}
+
+// startServer runs an embedded server that admits userKey and certificates
+// from userCA for device A, as "alice"
+func startServer(t *testing.T, userKey, userCA ssh.Signer) *Server {
+	t.Helper()
+	home := t.TempDir()
+	s, err := Start(context.Background(), Config{
+		Addr:     "127.0.0.1:0",
+		HostKey:  newSigner(t),
+		Username: "alice",
+		HomeDir:  home,
+		Shell:    "/bin/sh",
+		AuthorizedKeys: func() ([]ssh.PublicKey, error) {
+			return []ssh.PublicKey{userKey.PublicKey()}, nil
+		},
+		UserCA:    userCA.PublicKey(),
+		Principal: models.SSHDevicePrincipal(deviceA),
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func dial(s *Server, user string, signer ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", s.Addr(), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func certSigner(t *testing.T, ca ssh.Signer, principal string) ssh.Signer {
+	t.Helper()
+	key := newSigner(t)
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(5 * time.Minute).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("SignCert: %v", err)
+	}
+	signer, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		t.Fatalf("NewCertSigner: %v", err)
+	}
+	return signer
+}
+
+func TestAuthentication(t *testing.T) {
+	userKey, userCA := newSigner(t), newSigner(t)
+	s := startServer(t, userKey, userCA)
+
+	tests := []struct {
+		name   string
+		user   string
+		signer ssh.Signer
+		ok     bool
+	}{
+		{"authorized key", "alice", userKey, true},
+		{"unknown key", "alice", newSigner(t), false},
+		{"other user", "bob", userKey, false},
+		{"certificate", "alice", certSigner(t, userCA, models.SSHDevicePrincipal(deviceA)), true},
+		// Another user's device with the same OS username: the same CA signs
+		// its certificates, but for its own principal
+		{"certificate for other device", "alice", certSigner(t, userCA, models.SSHDevicePrincipal(deviceB)), false},
+		{"certificate for username", "alice", certSigner(t, userCA, "alice"), false},
+		{"certificate from other CA", "alice", certSigner(t, newSigner(t), models.SSHDevicePrincipal(deviceA)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := dial(s, tt.user, tt.signer)
+			if client != nil {
+				client.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("dial error = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	userKey := newSigner(t)
+	s := startServer(t, userKey, newSigner(t))
+
+	client, err := dial(s, "alice", userKey)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	session.Stdin = strings.NewReader("hello")
+	out, err := session.Output("cat; echo \" $USER $(pwd)\"")
+	if err != nil {
+		t.Fatalf("Output: %v", err)
+	}
+	want := "hello alice " + s.cfg.HomeDir + "\n"
+	if string(out) != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+
+	session, err = client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+	var exitErr *ssh.ExitError
+	if err := session.Run("exit 3"); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("Run(exit 3) = %v, want exit status 3", err)
+	}
+}
+
+func TestPTY(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no PTY support on Windows")
+	}
+	userKey := newSigner(t)
+	s := startServer(t, userKey, newSigner(t))
+
+	client, err := dial(s, "alice", userKey)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.RequestPty("xterm", 40, 100, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("RequestPty: %v", err)
+	}
+	out, err := session.Output("echo $TERM; stty size")
+	if err != nil {
+		t.Fatalf("Output: %v", err)
+	}
+	if got := strings.ReplaceAll(string(out), "\r", ""); got != "xterm\n40 100\n" {
+		t.Errorf("output = %q", out)
+	}
+}
+
+func TestLocalForward(t *testing.T) {
+	userKey := newSigner(t)
+	s := startServer(t, userKey, newSigner(t))
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	client, err := dial(s, "alice", userKey)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	conn, err := client.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("echo = %q, %v", buf, err)
+	}
+}
+
+func TestRemoteForward(t *testing.T) {
+	userKey := newSigner(t)
+	s := startServer(t, userKey, newSigner(t))
+
+	client, err := dial(s, "alice", userKey)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	l, err := client.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("remote forward: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte("pong"))
+		c.Close()
+	}()
+
+	// Remote forwards only listen on localhost
+	port := l.Addr().(*net.TCPAddr).Port
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("connect to forward: %v", err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "pong" {
+		t.Errorf("read = %q, %v", data, err)
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	userKey := newSigner(t)
+	s := startServer(t, userKey, newSigner(t))
+
+	client, err := dial(s, "alice", userKey)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatalf("sftp: %v", err)
+	}
+	defer sftpClient.Close()
+
+	// Relative paths resolve in the home directory
+	f, err := sftpClient.Create("notes.txt")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.Write([]byte("roamie"))
+	f.Close()
+
+	data, err := os.ReadFile(filepath.Join(s.cfg.HomeDir, "notes.txt"))
+	if err != nil || string(data) != "roamie" {
+		t.Errorf("file = %q, %v", data, err)
+	}
+}
+
+func TestLoadOrGenerateHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HostKeyFile)
+
+	first, err := LoadOrGenerateHostKey(path)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	second, err := LoadOrGenerateHostKey(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if ssh.FingerprintSHA256(first.PublicKey()) != ssh.FingerprintSHA256(second.PublicKey()) {
+		t.Error("host key changed between loads")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("host key mode = %v, %v", info.Mode().Perm(), err)
+	}
+}
@@ -0,0 +1,257 @@
+package sshserver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// ptyRequest is the payload of a pty-req request (RFC 4254 6.2)
+type ptyRequest struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	Width    uint32
+	Height   uint32
+	Modelist string
+}
+
+// windowChange is the payload of a window-change request (RFC 4254 6.7)
+type windowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// session is one session channel: an optional PTY, then a shell, a command
+// or the SFTP subsystem
+type session struct {
+	server  *Server
+	channel ssh.Channel
+	env     []string
+
+	mu      sync.Mutex
+	pty     *ptyRequest
+	ptyFile *os.File
+	started bool
+}
+
+func (s *Server) handleSession(newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+
+	sess := &session{server: s, channel: channel}
+	for req := range reqs {
+		ok := sess.handleRequest(req)
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
+
+func (sess *session) handleRequest(req *ssh.Request) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	switch req.Type {
+	case "env":
+		var kv struct{ Name, Value string }
+		if ssh.Unmarshal(req.Payload, &kv) != nil || !acceptEnv(kv.Name) {
+			return false
+		}
+		sess.env = append(sess.env, kv.Name+"="+kv.Value)
+		return true
+
+	case "pty-req":
+		var p ptyRequest
+		if ssh.Unmarshal(req.Payload, &p) != nil || sess.started {
+			return false
+		}
+		sess.pty = &p
+		return true
+
+	case "window-change":
+		var w windowChange
+		if ssh.Unmarshal(req.Payload, &w) != nil || sess.ptyFile == nil {
+			return false
+		}
+		pty.Setsize(sess.ptyFile, &pty.Winsize{Rows: uint16(w.Rows), Cols: uint16(w.Columns)})
+		return true
+
+	case "shell", "exec":
+		if sess.started {
+			return false
+		}
+		var command string
+		if req.Type == "exec" {
+			var payload struct{ Command string }
+			if ssh.Unmarshal(req.Payload, &payload) != nil {
+				return false
+			}
+			command = payload.Command
+		}
+		cmd := sess.command(command)
+		if err := sess.start(cmd); err != nil {
+			log.Printf("Embedded SSH: failed to start %s: %v", req.Type, err)
+			return false
+		}
+		sess.started = true
+		return true
+
+	case "subsystem":
+		var payload struct{ Name string }
+		if ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" || sess.started {
+			return false
+		}
+		sess.started = true
+		go sess.serveSFTP()
+		return true
+	}
+
+	return false
+}
+
+// command builds the process for a shell (command == "") or a command run
+// through the user's shell
+func (sess *session) command(command string) *exec.Cmd {
+	cfg := sess.server.cfg
+	shell := cfg.Shell
+
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "windows" && command != "":
+		cmd = exec.Command(shell, "/C", command)
+	case runtime.GOOS == "windows":
+		cmd = exec.Command(shell)
+	case command != "":
+		cmd = exec.Command(shell, "-c", command)
+	default:
+		// A leading dash makes it a login shell, like sshd does
+		cmd = exec.Command(shell)
+		cmd.Args = []string{"-" + filepath.Base(shell)}
+	}
+
+	cmd.Dir = cfg.HomeDir
+	cmd.Env = append(sessionEnv(cfg), sess.env...)
+	if sess.pty != nil {
+		cmd.Env = append(cmd.Env, "TERM="+sess.pty.Term)
+	}
+	return cmd
+}
+
+// start runs cmd attached to the channel (through a PTY if one was
+// requested) and reports its exit status when it ends
+func (sess *session) start(cmd *exec.Cmd) error {
+	channel := sess.channel
+
+	if sess.pty != nil {
+		f, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: uint16(sess.pty.Rows), Cols: uint16(sess.pty.Columns)})
+		if err != nil {
+			return err
+		}
+		sess.ptyFile = f
+
+		output := make(chan struct{})
+		go io.Copy(f, channel)
+		go func() {
+			io.Copy(channel, f)
+			close(output)
+		}()
+		go func() {
+			err := cmd.Wait()
+			// Background jobs can hold the terminal open; don't wait for them
+			select {
+			case <-output:
+			case <-time.After(time.Second):
+			}
+			f.Close()
+			sess.exit(err)
+		}()
+		return nil
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		io.Copy(stdin, channel)
+		stdin.Close()
+	}()
+	go func() {
+		sess.exit(cmd.Wait())
+	}()
+	return nil
+}
+
+// serveSFTP serves the SFTP subsystem, rooted at the home directory
+func (sess *session) serveSFTP() {
+	server, err := sftp.NewServer(sess.channel, sftp.WithServerWorkingDirectory(sess.server.cfg.HomeDir))
+	if err != nil {
+		log.Printf("Embedded SSH: failed to start SFTP: %v", err)
+		sess.exit(err)
+		return
+	}
+	err = server.Serve()
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	sess.exit(err)
+}
+
+// exit reports the exit status and closes the channel
+func (sess *session) exit(err error) {
+	status := 0
+	if err != nil {
+		status = 255
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+			status = exitErr.ExitCode()
+		}
+	}
+	sess.channel.CloseWrite()
+	sess.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+	sess.channel.Close()
+}
+
+// sessionEnv is the environment sessions start with
+func sessionEnv(cfg Config) []string {
+	env := []string{
+		"HOME=" + cfg.HomeDir,
+		"USER=" + cfg.Username,
+		"LOGNAME=" + cfg.Username,
+		"SHELL=" + cfg.Shell,
+	}
+	for _, name := range []string{"PATH", "LANG", "TZ", "SYSTEMROOT", "COMSPEC", "PATHEXT", "TEMP", "TMP"} {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// acceptEnv lists the variables clients may set, matching the AcceptEnv
+// defaults of common sshd configs
+func acceptEnv(name string) bool {
+	return name == "LANG" || strings.HasPrefix(name, "LC_") || name == "COLORTERM"
+}
@@ -7,15 +7,30 @@ import (
 	"os/user"
 	"path/filepath"
 
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/secrets"
 	"github.com/kamikazebr/roamie-desktop/pkg/utils"
 )
 
 type Credentials struct {
-	Token     string `json:"token"`
+	Token     string `json:"token,omitempty"`
+	TokenRef  string `json:"token_ref,omitempty"` // Token's location in the secrets store
 	Email     string `json:"email"`
 	ExpiresAt string `json:"expires_at"`
 }
 
+// credentialsTokenSecret is the secrets store name of Credentials.Token
+const credentialsTokenSecret = "credentials_token"
+
+// openSecrets opens the secrets store shared with config.Config
+func openSecrets() (*secrets.Store, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Open(configDir)
+}
+
 type DeviceInfo struct {
 	DeviceID        string `json:"device_id"`
 	DeviceName      string `json:"device_name"`
@@ -67,8 +82,21 @@ func SaveCredentials(creds *Credentials) error {
 		return err
 	}
 
+	// Keep the token itself in the secrets store
+	onDisk := *creds
+	store, err := openSecrets()
+	if err != nil {
+		return err
+	}
+	if store.Enabled() && creds.Token != "" {
+		if onDisk.TokenRef, err = store.Put(credentialsTokenSecret, creds.Token); err != nil {
+			return err
+		}
+		onDisk.Token = ""
+	}
+
 	filePath := filepath.Join(configDir, "credentials.json")
-	data, err := json.MarshalIndent(creds, "", "  ")
+	data, err := json.MarshalIndent(&onDisk, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -96,9 +124,58 @@ func LoadCredentials() (*Credentials, error) {
 		return nil, err
 	}
 
+	if creds.Token == "" && creds.TokenRef != "" {
+		store, err := openSecrets()
+		if err != nil {
+			return nil, err
+		}
+		if creds.Token, err = store.Get(creds.TokenRef); err != nil {
+			return nil, fmt.Errorf("failed to read token from secrets: %w", err)
+		}
+	}
+
 	return &creds, nil
 }
 
+// HasPlaintextCredentials reports whether credentials.json holds a token in
+// plaintext
+func HasPlaintextCredentials() (bool, error) {
+	// Not getConfigDir, which would create the directory
+	_, home, err := utils.GetActualUser()
+	if err != nil {
+		return false, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(home, ".roamie-desktop", "credentials.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return false, err
+	}
+	return creds.Token != "", nil
+}
+
+// MigrateCredentials moves a plaintext token in credentials.json into the
+// secrets store
+func MigrateCredentials() error {
+	plaintext, err := HasPlaintextCredentials()
+	if err != nil || !plaintext {
+		return err
+	}
+
+	creds, err := LoadCredentials()
+	if err != nil {
+		return err
+	}
+	return SaveCredentials(creds)
+}
+
 func DeleteCredentials() error {
 	configDir, err := getConfigDir()
 	if err != nil {
@@ -106,6 +183,14 @@ func DeleteCredentials() error {
 	}
 
 	filePath := filepath.Join(configDir, "credentials.json")
+	if data, err := os.ReadFile(filePath); err == nil {
+		var creds Credentials
+		if json.Unmarshal(data, &creds) == nil && creds.TokenRef != "" {
+			if store, err := openSecrets(); err == nil {
+				store.Delete(creds.TokenRef)
+			}
+		}
+	}
 	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
 		return err
 	}
@@ -0,0 +1,54 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// authMethods offers a short-lived tunnel certificate first, then the
+// registered key for servers without the SSH CA or while the API can't be
+// reached
+func (c *Client) authMethods() []ssh.AuthMethod {
+	signers := []ssh.Signer{}
+	if certSigner, err := c.certificateSigner(); err != nil {
+		log.Printf("Tunnel certificate unavailable, using registered key: %v", err)
+	} else {
+		signers = append(signers, certSigner)
+	}
+	signers = append(signers, c.privateKey)
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}
+}
+
+// certificateSigner asks the server to certify the tunnel key. It reloads
+// config for the newest access token, like refreshHostKeys.
+func (c *Client) certificateSigner() (ssh.Signer, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+
+	issued, err := api.NewClient(c.serverURL).IssueTunnelCertificate(c.deviceID, cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(issued.Certificate))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tunnel certificate: %w", err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("server returned a key instead of a certificate")
+	}
+	if !bytes.Equal(cert.Key.Marshal(), c.privateKey.PublicKey().Marshal()) {
+		return nil, fmt.Errorf("certificate is for another key, run 'roamie tunnel register' to register this one")
+	}
+	return ssh.NewCertSigner(cert, c.privateKey)
+}
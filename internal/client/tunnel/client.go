@@ -5,9 +5,10 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"os"
@@ -18,8 +19,13 @@ import (
 
 	"github.com/kamikazebr/roamie-desktop/internal/client/api"
 	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"github.com/kamikazebr/roamie-desktop/internal/client/secrets"
 	sshpkg "github.com/kamikazebr/roamie-desktop/internal/client/ssh"
+	"github.com/kamikazebr/roamie-desktop/internal/client/sshserver"
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+	"github.com/kamikazebr/roamie-desktop/pkg/forward"
 	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+	"github.com/kamikazebr/roamie-desktop/pkg/version"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -47,6 +53,12 @@ type Client struct {
 	reconnectDelay time.Duration
 	mu             sync.Mutex
 	connected      bool
+	hostKeys       []ssh.PublicKey // Pinned server host keys
+	embeddedSSH    string          // Embedded SSH server used when sshd isn't running
+	transportMode  string          // Configured transport (TransportAuto if empty)
+	transport      string          // Transport of the current or last connection
+	control        *control.Mux    // Handles commands from the server; nil disables them
+	reconnectNow   bool            // The server asked for a new connection before it stops
 }
 
 // NewClient creates a new SSH tunnel client
@@ -78,6 +90,11 @@ func NewClientWithContext(ctx context.Context, cfg *config.Config) (*Client, err
 		ctx:            tunnelCtx,
 		cancel:         cancel,
 		reconnectDelay: InitialReconnectDelay,
+		hostKeys:       ParseHostKeys(cfg.TunnelHostKeys),
+		transportMode:  cfg.TunnelTransport,
+	}
+	if cfg.EmbeddedSSH {
+		c.embeddedSSH = sshserver.ListenAddr(cfg.EmbeddedSSHPort)
 	}
 
 	// Load or generate SSH key
@@ -91,16 +108,41 @@ func NewClientWithContext(ctx context.Context, cfg *config.Config) (*Client, err
 	return c, nil
 }
 
-// loadOrGenerateKey loads existing SSH key or generates a new one
+// loadOrGenerateKey loads the SSH key from the secrets store or generates a
+// new one. A key file left by older versions is moved into the store.
 func (c *Client) loadOrGenerateKey() (ssh.Signer, error) {
 	configDir, err := config.GetConfigDir()
 	if err != nil {
 		return nil, err
 	}
 
+	store, err := secrets.Open(configDir)
+	if err != nil {
+		return nil, err
+	}
+
 	keyPath := filepath.Join(configDir, TunnelKeyFile)
 
 	// Try to load existing key
+	if store.Enabled() {
+		if _, err := moveKeyFile(store, keyPath); err != nil {
+			log.Printf("Warning: failed to move tunnel key into secrets: %v", err)
+		}
+
+		data, ref, err := store.Lookup(TunnelKeyFile)
+		switch {
+		case err == nil:
+			signer, err := ssh.ParsePrivateKey([]byte(data))
+			if err == nil {
+				log.Printf("✓ Loaded existing SSH tunnel key from: %s", ref)
+				return signer, nil
+			}
+			log.Printf("Warning: failed to parse existing tunnel key: %v", err)
+		case !errors.Is(err, secrets.ErrNotFound):
+			return nil, fmt.Errorf("failed to read tunnel key from secrets: %w", err)
+		}
+	}
+
 	if data, err := os.ReadFile(keyPath); err == nil {
 		signer, err := ssh.ParsePrivateKey(data)
 		if err == nil {
@@ -124,24 +166,68 @@ func (c *Client) loadOrGenerateKey() (ssh.Signer, error) {
 	}
 	privateKeyBytes := pem.EncodeToMemory(privateKeyPEM)
 
-	// Save to disk
-	if err := os.WriteFile(keyPath, privateKeyBytes, 0600); err != nil {
-		return nil, fmt.Errorf("failed to save tunnel key: %w", err)
-	}
-
-	// Fix ownership if running under sudo
-	utils.FixFileOwnership(keyPath)
-
 	// Parse to ssh.Signer
 	signer, err := ssh.ParsePrivateKey(privateKeyBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse generated key: %w", err)
 	}
 
-	log.Printf("✓ Generated and saved new SSH tunnel key to: %s", keyPath)
+	// Save to the secrets store, or to disk if secrets are kept in plaintext
+	location := keyPath
+	if store.Enabled() {
+		if location, err = store.Put(TunnelKeyFile, string(privateKeyBytes)); err != nil {
+			return nil, fmt.Errorf("failed to save tunnel key: %w", err)
+		}
+	} else {
+		if err := os.WriteFile(keyPath, privateKeyBytes, 0600); err != nil {
+			return nil, fmt.Errorf("failed to save tunnel key: %w", err)
+		}
+
+		// Fix ownership if running under sudo
+		utils.FixFileOwnership(keyPath)
+	}
+
+	log.Printf("✓ Generated and saved new SSH tunnel key to: %s", location)
 	return signer, nil
 }
 
+// MigrateKeyFile moves a tunnel key file left by older versions into the
+// secrets store and returns its reference ("" if there was no file)
+func MigrateKeyFile() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	store, err := secrets.Open(configDir)
+	if err != nil || !store.Enabled() {
+		return "", err
+	}
+	return moveKeyFile(store, filepath.Join(configDir, TunnelKeyFile))
+}
+
+func moveKeyFile(store *secrets.Store, keyPath string) (string, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if _, err := ssh.ParsePrivateKey(data); err != nil {
+		return "", fmt.Errorf("invalid tunnel key in %s: %w", keyPath, err)
+	}
+
+	ref, err := store.Put(TunnelKeyFile, string(data))
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(keyPath); err != nil {
+		return "", err
+	}
+	log.Printf("✓ Moved SSH tunnel key from %s to %s", keyPath, ref)
+	return ref, nil
+}
+
 // GetPublicKey returns the SSH public key in authorized_keys format
 func (c *Client) GetPublicKey() string {
 	if c.privateKey == nil {
@@ -205,6 +291,18 @@ func (c *Client) Connect() error {
 	c.tunnelPort = tunnelPort
 	log.Printf("Tunnel port allocated: %d", tunnelPort)
 
+	// Re-pin the server's host keys, picking up announced and retired keys.
+	// Without any pinned key the server can't be verified, so don't connect.
+	if err := c.refreshHostKeys(); err != nil {
+		c.mu.Lock()
+		pinned := len(c.hostKeys) > 0
+		c.mu.Unlock()
+		if !pinned {
+			return fmt.Errorf("failed to pin tunnel server host key: %w", err)
+		}
+		log.Printf("Warning: failed to refresh tunnel host keys, using pinned keys: %v", err)
+	}
+
 	// Start connection loop
 	c.wg.Add(1)
 	go c.connectionLoop()
@@ -225,12 +323,26 @@ func (c *Client) connectionLoop() {
 		}
 
 		if err := c.establishConnection(); err != nil {
-			log.Printf("Connection failed: %v", err)
+			if c.takeReconnectNow() {
+				// The server is handing devices over to its next instance
+				c.setConnected(false)
+				c.reconnectDelay = InitialReconnectDelay
+				continue
+			}
+
+			var mismatch *HostKeyMismatchError
+			if errors.As(err, &mismatch) {
+				log.Printf("⚠️  SECURITY WARNING: %v", mismatch)
+				log.Printf("⚠️  If the server's host key was replaced on purpose, check with its admin (roamie doctor shows the fingerprints)")
+			} else {
+				log.Printf("Connection failed: %v", err)
+			}
 			c.setConnected(false)
 
-			// Exponential backoff
-			log.Printf("Reconnecting in %s...", c.reconnectDelay)
-			time.Sleep(c.reconnectDelay)
+			// Exponential backoff, with jitter
+			delay := backoff(c.reconnectDelay)
+			log.Printf("Reconnecting in %s...", delay.Round(time.Millisecond))
+			time.Sleep(delay)
 
 			// Increase delay for next attempt (max 30s)
 			c.reconnectDelay *= 2
@@ -246,35 +358,39 @@ func (c *Client) connectionLoop() {
 
 // establishConnection creates a single SSH connection attempt
 func (c *Client) establishConnection() error {
+	c.mu.Lock()
+	pins := c.hostKeys
+	c.mu.Unlock()
+
 	// SSH client configuration
 	sshConfig := &ssh.ClientConfig{
-		User: "tunnel",
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(c.privateKey),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: Implement proper host key verification
-		Timeout:         10 * time.Second,
+		User:              "tunnel",
+		Auth:              c.authMethods(),
+		HostKeyCallback:   c.verifyHostKey,
+		HostKeyAlgorithms: hostKeyAlgorithms(pins),
+		Timeout:           10 * time.Second,
 	}
 
 	// Connect to SSH server
-	addr := fmt.Sprintf("%s:%d", c.serverHost, TunnelServerPort)
-	log.Printf("Connecting to SSH tunnel server: %s", addr)
+	log.Printf("Connecting to SSH tunnel server: %s (%s)", c.serverHost, strings.Join(c.transportOrder(), ", then "))
 
 	log.Printf("DEBUG: About to dial SSH...")
-	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
+	sshConn, chans, reqs, transport, err := c.dialConn(sshConfig)
 	if err != nil {
 		log.Printf("DEBUG: SSH dial failed: %v", err)
 		return fmt.Errorf("SSH dial failed: %w", err)
 	}
+	sshClient := ssh.NewClient(sshConn, chans, c.watchReconnect(sshConn, reqs))
 	defer sshClient.Close()
 	log.Printf("DEBUG: SSH dial succeeded")
 
 	c.mu.Lock()
 	c.sshClient = sshClient
 	c.connected = true // Set directly to avoid deadlock (setConnected also locks)
+	c.transport = transport
 	c.mu.Unlock()
 
-	log.Printf("✓ SSH tunnel connected")
+	log.Printf("✓ SSH tunnel connected over %s", transport)
 
 	// Setup reverse port forward
 	log.Printf("DEBUG: About to setup reverse port forward on port %d...", c.tunnelPort)
@@ -287,11 +403,20 @@ func (c *Client) establishConnection() error {
 	log.Printf("DEBUG: Listener created successfully")
 
 	log.Printf("✓ Reverse tunnel established: server port %d → localhost:%d", c.tunnelPort, LocalSSHPort)
+	c.reconnectDelay = InitialReconnectDelay
+	if addr := c.embeddedSSHAddr(); addr != "" {
+		log.Printf("  (embedded SSH server at %s when sshd isn't running)", addr)
+	}
 
 	// Start keepalive
 	c.wg.Add(1)
 	go c.keepalive(sshClient)
 
+	c.startControl(sshClient)
+
+	// Connections other devices forward to this device's local ports
+	go c.handleDirectChannels(sshClient.HandleChannelOpen(directChannelType))
+
 	// Accept connections and forward to local SSH
 	for {
 		select {
@@ -310,6 +435,43 @@ func (c *Client) establishConnection() error {
 	}
 }
 
+// startControl serves commands from the server on this connection and
+// announces them. Servers without commands reject the hello and never open
+// control channels.
+func (c *Client) startControl(sshClient *ssh.Client) {
+	c.mu.Lock()
+	mux := c.control
+	c.mu.Unlock()
+	if mux == nil {
+		return
+	}
+
+	go mux.Serve(c.ctx, sshClient.HandleChannelOpen(control.ChannelType))
+
+	hello, err := json.Marshal(mux.Hello(version.Version))
+	if err != nil {
+		log.Printf("Failed to encode hello: %v", err)
+		return
+	}
+	ok, _, err := sshClient.SendRequest(control.HelloRequest, true, hello)
+	switch {
+	case err != nil:
+		log.Printf("Failed to send hello: %v", err)
+	case !ok:
+		log.Printf("Tunnel server doesn't support remote commands")
+	default:
+		log.Printf("✓ Remote commands enabled")
+	}
+}
+
+// SetControl sets the handlers for commands the server sends over the tunnel
+// (takes effect on the next connection)
+func (c *Client) SetControl(mux *control.Mux) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.control = mux
+}
+
 // keepalive sends periodic keepalive packets
 func (c *Client) keepalive(client *ssh.Client) {
 	defer c.wg.Done()
@@ -338,28 +500,51 @@ func (c *Client) handleForward(remoteConn net.Conn) {
 	defer remoteConn.Close()
 
 	// Connect to local SSH server
-	localConn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", LocalSSHPort), 10*time.Second)
+	localConn, err := c.dialLocalSSH()
 	if err != nil {
 		log.Printf("Failed to connect to local SSH: %v", err)
 		return
 	}
 	defer localConn.Close()
 
-	// Bidirectional copy
-	var wg sync.WaitGroup
-	wg.Add(2)
+	// Bidirectional copy, passing half-closes through
+	forward.Pipe(c.ctx, remoteConn, localConn, forward.Options{})
+}
 
-	go func() {
-		defer wg.Done()
-		io.Copy(localConn, remoteConn)
-	}()
+// dialLocalSSH connects to sshd, or to the embedded SSH server when sshd
+// isn't running and the embedded server is enabled
+func (c *Client) dialLocalSSH() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", LocalSSHPort), 10*time.Second)
+	if err == nil {
+		return conn, nil
+	}
+	addr := c.embeddedSSHAddr()
+	if addr == "" {
+		return nil, err
+	}
+	return net.DialTimeout("tcp", addr, 10*time.Second)
+}
 
-	go func() {
-		defer wg.Done()
-		io.Copy(remoteConn, localConn)
-	}()
+// SetEmbeddedSSHAddr changes where connections go when sshd isn't running
+// ("" disables the fallback)
+func (c *Client) SetEmbeddedSSHAddr(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.embeddedSSH = addr
+}
+
+// Transport returns the transport of the current or last connection ("" if
+// the tunnel hasn't connected yet)
+func (c *Client) Transport() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.transport
+}
 
-	wg.Wait()
+func (c *Client) embeddedSSHAddr() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.embeddedSSH
 }
 
 // Disconnect stops the SSH tunnel
@@ -408,11 +593,13 @@ func (c *Client) setConnected(connected bool) {
 // GetStatus returns the current tunnel status
 func (c *Client) GetStatus() map[string]interface{} {
 	return map[string]interface{}{
-		"connected":   c.IsConnected(),
-		"server":      c.serverHost,
-		"server_port": TunnelServerPort,
-		"tunnel_port": c.tunnelPort,
-		"local_port":  LocalSSHPort,
+		"connected":    c.IsConnected(),
+		"server":       c.serverHost,
+		"server_port":  TunnelServerPort,
+		"tunnel_port":  c.tunnelPort,
+		"local_port":   LocalSSHPort,
+		"embedded_ssh": c.embeddedSSHAddr(),
+		"transport":    c.Transport(),
 	}
 }
 
@@ -0,0 +1,164 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/forward"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// ForwardUser is the SSH user for connections that forward to other
+	// devices (roamie forward) instead of serving this device's tunnel
+	ForwardUser = "forward"
+
+	// directChannelType carries a connection to a local port, from a forward
+	// client to the server and from the server to the target device
+	directChannelType = "direct-tcpip"
+)
+
+// directTCPIP is the payload of a direct-tcpip channel (RFC 4254 7.2)
+type directTCPIP struct {
+	Host       string
+	Port       uint32
+	OriginHost string
+	OriginPort uint32
+}
+
+// Forwarder opens connections to a port on one of the user's other devices
+// through the tunnel server, which checks the device belongs to the same
+// user. It reconnects when the connection to the server drops.
+type Forwarder struct {
+	client *Client
+	addr   string // <device id>:<port>
+
+	mu   sync.Mutex
+	conn *ssh.Client
+}
+
+// NewForwarder returns a forwarder to port on the device with deviceID,
+// authenticating as this device with the tunnel key
+func NewForwarder(client *Client, deviceID string, port int) *Forwarder {
+	return &Forwarder{client: client, addr: net.JoinHostPort(deviceID, strconv.Itoa(port))}
+}
+
+// Dial opens a connection to the device's port
+func (f *Forwarder) Dial() (net.Conn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn != nil {
+		conn, err := f.conn.Dial("tcp", f.addr)
+		var rejected *ssh.OpenChannelError
+		if err == nil || errors.As(err, &rejected) {
+			return conn, err
+		}
+		// The connection to the server is gone
+		f.conn.Close()
+		f.conn = nil
+	}
+
+	sshClient, err := f.client.dialForward()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to tunnel server: %w", err)
+	}
+	f.conn = sshClient
+	return sshClient.Dial("tcp", f.addr)
+}
+
+// Close closes the connection to the tunnel server
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn == nil {
+		return nil
+	}
+	err := f.conn.Close()
+	f.conn = nil
+	return err
+}
+
+// dialForward connects to the tunnel server to reach other devices
+func (c *Client) dialForward() (*ssh.Client, error) {
+	if err := c.refreshHostKeys(); err != nil {
+		c.mu.Lock()
+		pinned := len(c.hostKeys) > 0
+		c.mu.Unlock()
+		if !pinned {
+			return nil, fmt.Errorf("failed to pin tunnel server host key: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	pins := c.hostKeys
+	c.mu.Unlock()
+
+	sshClient, _, err := c.dialSSH(&ssh.ClientConfig{
+		User:              ForwardUser,
+		Auth:              c.authMethods(),
+		HostKeyCallback:   c.verifyHostKey,
+		HostKeyAlgorithms: hostKeyAlgorithms(pins),
+		Timeout:           10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sshClient, nil
+}
+
+// handleDirectChannels serves connections other devices forward to this
+// device's local ports (roamie forward)
+func (c *Client) handleDirectChannels(chans <-chan ssh.NewChannel) {
+	for newChannel := range chans {
+		c.wg.Add(1)
+		go c.handleDirect(newChannel)
+	}
+}
+
+func (c *Client) handleDirect(newChannel ssh.NewChannel) {
+	defer c.wg.Done()
+
+	var target directTCPIP
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip request")
+		return
+	}
+
+	// Forwards only reach this device's own services
+	switch target.Host {
+	case "localhost", "127.0.0.1", "::1":
+	default:
+		newChannel.Reject(ssh.Prohibited, "only local ports can be forwarded")
+		return
+	}
+
+	var localConn net.Conn
+	var err error
+	if target.Port == LocalSSHPort {
+		localConn, err = c.dialLocalSSH()
+	} else {
+		localConn, err = net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(int(target.Port))), 10*time.Second)
+	}
+	if err != nil {
+		log.Printf("Failed to connect to local port %d for forward from %s: %v", target.Port, target.OriginHost, err)
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer localConn.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	log.Printf("Forwarded connection to local port %d (via %s)", target.Port, target.OriginHost)
+	forward.Pipe(c.ctx, channel, localConn, forward.Options{})
+}
@@ -0,0 +1,198 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyMismatchError is returned when the tunnel server presents a host key
+// that is neither pinned nor published by the (TLS-authenticated) API.
+// Someone may be intercepting the connection.
+type HostKeyMismatchError struct {
+	Host        string
+	Fingerprint string
+	Pinned      []string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("tunnel server %s presented host key %s, which does not match the pinned keys (%s); refusing to connect, the connection may be intercepted",
+		e.Host, e.Fingerprint, strings.Join(e.Pinned, ", "))
+}
+
+// PinHostKeys pins the host keys the server publishes (current, announced
+// and retiring) in cfg. The caller saves cfg.
+func PinHostKeys(cfg *config.Config) error {
+	keys, err := api.NewClient(cfg.ServerURL).GetTunnelHostKeys(cfg.JWT)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tunnel host keys: %w", err)
+	}
+
+	var pins []string
+	for _, key := range keys {
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+		if err != nil {
+			return fmt.Errorf("server published an invalid host key: %w", err)
+		}
+		if fingerprint := ssh.FingerprintSHA256(publicKey); fingerprint != key.Fingerprint {
+			return fmt.Errorf("server published host key %s with fingerprint %s", fingerprint, key.Fingerprint)
+		}
+		pins = append(pins, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))))
+	}
+	if len(pins) == 0 {
+		return fmt.Errorf("server published no tunnel host keys")
+	}
+
+	cfg.TunnelHostKeys = pins
+	return nil
+}
+
+// ParseHostKeys parses pinned host keys, skipping invalid ones
+func ParseHostKeys(pins []string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	for _, pin := range pins {
+		if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pin)); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// IsPinned reports whether key is one of pins
+func IsPinned(pins []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, pin := range pins {
+		if bytes.Equal(pin.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func fingerprints(keys []ssh.PublicKey) []string {
+	var prints []string
+	for _, key := range keys {
+		prints = append(prints, ssh.FingerprintSHA256(key))
+	}
+	return prints
+}
+
+// hostKeyAlgorithms prefers the algorithms of the pinned keys, so a server
+// offering both an old and a new key presents one the client trusts
+func hostKeyAlgorithms(pins []ssh.PublicKey) []string {
+	var algorithms []string
+	seen := make(map[string]bool)
+	add := func(names ...string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				algorithms = append(algorithms, name)
+			}
+		}
+	}
+
+	for _, pin := range pins {
+		if pin.Type() == ssh.KeyAlgoRSA {
+			add(ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		} else {
+			add(pin.Type())
+		}
+	}
+	add(ssh.SupportedAlgorithms().HostKeys...)
+	return algorithms
+}
+
+// refreshHostKeys re-pins the keys the server publishes and saves them.
+// It reloads config for the newest access token, since the daemon's copy
+// may have been refreshed since the tunnel started.
+func (c *Client) refreshHostKeys() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		return fmt.Errorf("not logged in")
+	}
+
+	if err := PinHostKeys(cfg); err != nil {
+		return err
+	}
+	if err := cfg.Save(); err != nil {
+		log.Printf("Warning: failed to save pinned tunnel host keys: %v", err)
+	}
+
+	c.mu.Lock()
+	c.hostKeys = ParseHostKeys(cfg.TunnelHostKeys)
+	c.mu.Unlock()
+	return nil
+}
+
+// verifyHostKey is the tunnel's ssh.HostKeyCallback. A key that isn't pinned
+// is accepted only if the API now publishes it (a planned rotation).
+func (c *Client) verifyHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	c.mu.Lock()
+	pins := c.hostKeys
+	c.mu.Unlock()
+
+	if IsPinned(pins, key) {
+		return nil
+	}
+
+	if err := c.refreshHostKeys(); err != nil {
+		log.Printf("Warning: failed to refresh tunnel host keys: %v", err)
+	} else {
+		c.mu.Lock()
+		pins = c.hostKeys
+		c.mu.Unlock()
+		if IsPinned(pins, key) {
+			log.Printf("✓ Tunnel server host key rotated, pinned %s", ssh.FingerprintSHA256(key))
+			return nil
+		}
+	}
+
+	return &HostKeyMismatchError{
+		Host:        hostname,
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Pinned:      fingerprints(pins),
+	}
+}
+
+// errProbeDone aborts a probe handshake once the host key is known
+var errProbeDone = errors.New("host key received")
+
+// ProbeHostKey returns the host key the tunnel server at serverURL presents,
+// preferring the algorithms of pins. It doesn't authenticate.
+func ProbeHostKey(serverURL string, pins []ssh.PublicKey) (ssh.PublicKey, error) {
+	host, err := extractHost(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var presented ssh.PublicKey
+	sshConfig := &ssh.ClientConfig{
+		User:              "tunnel",
+		HostKeyAlgorithms: hostKeyAlgorithms(pins),
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			presented = key
+			return errProbeDone
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	addr := fmt.Sprintf("%s:%d", host, TunnelServerPort)
+	client, err := ssh.Dial("tcp", addr, sshConfig)
+	if client != nil {
+		client.Close()
+	}
+	if presented == nil {
+		return nil, fmt.Errorf("failed to reach tunnel server %s: %w", addr, err)
+	}
+	return presented, nil
+}
@@ -0,0 +1,112 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/api"
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func serveHostKeys(t *testing.T, keys []api.TunnelHostKey) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tunnel/host-keys" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"host_keys": keys})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func published(key ssh.PublicKey, status string) api.TunnelHostKey {
+	return api.TunnelHostKey{
+		Algorithm:   key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Status:      status,
+	}
+}
+
+func TestPinHostKeys(t *testing.T) {
+	current, next, other := newTestHostKey(t), newTestHostKey(t), newTestHostKey(t)
+	srv := serveHostKeys(t, []api.TunnelHostKey{published(current, "current"), published(next, "next")})
+
+	cfg := &config.Config{ServerURL: srv.URL, JWT: "token"}
+	if err := PinHostKeys(cfg); err != nil {
+		t.Fatalf("PinHostKeys failed: %v", err)
+	}
+
+	pins := ParseHostKeys(cfg.TunnelHostKeys)
+	if len(pins) != 2 {
+		t.Fatalf("Expected 2 pinned keys, got %d", len(pins))
+	}
+	if !IsPinned(pins, current) || !IsPinned(pins, next) {
+		t.Error("Current and announced keys should be pinned")
+	}
+	if IsPinned(pins, other) {
+		t.Error("Unpublished keys should not be pinned")
+	}
+}
+
+func TestPinHostKeys_RejectsInconsistentFingerprint(t *testing.T) {
+	key := newTestHostKey(t)
+	bad := published(key, "current")
+	bad.Fingerprint = ssh.FingerprintSHA256(newTestHostKey(t))
+	srv := serveHostKeys(t, []api.TunnelHostKey{bad})
+
+	cfg := &config.Config{ServerURL: srv.URL, JWT: "token"}
+	if err := PinHostKeys(cfg); err == nil {
+		t.Error("Expected an error for a fingerprint that doesn't match the key")
+	}
+	if len(cfg.TunnelHostKeys) != 0 {
+		t.Error("Nothing should be pinned on error")
+	}
+}
+
+func TestHostKeyAlgorithms_PrefersPinned(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, _ := ssh.NewPublicKey(&rsaKey.PublicKey)
+
+	algorithms := hostKeyAlgorithms([]ssh.PublicKey{rsaPublic})
+	if algorithms[0] != ssh.KeyAlgoRSASHA512 || algorithms[1] != ssh.KeyAlgoRSASHA256 {
+		t.Errorf("Pinned RSA algorithms should come first, got %v", algorithms[:2])
+	}
+
+	// Other algorithms stay allowed, so a rotated key still reaches the
+	// callback (and the API check) instead of failing negotiation
+	found := false
+	for _, algorithm := range algorithms {
+		if algorithm == ssh.KeyAlgoED25519 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Unpinned algorithms should still be offered")
+	}
+}
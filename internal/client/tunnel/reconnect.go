@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+	"golang.org/x/crypto/ssh"
+)
+
+// watchReconnect passes the server's global requests on, except its request
+// to reconnect before it stops: the connection is closed at a random moment
+// within the server's window, so that devices don't all hit the next server
+// instance at once, and the connection loop reconnects without backing off.
+func (c *Client) watchReconnect(conn ssh.Conn, in <-chan *ssh.Request) <-chan *ssh.Request {
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+		for req := range in {
+			if req.Type != control.ReconnectRequest {
+				out <- req
+				continue
+			}
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+
+			var msg control.Reconnect
+			json.Unmarshal(req.Payload, &msg) // Without a window, reconnect now
+			delay := jitter(msg.Window())
+			log.Printf("Tunnel server is restarting, reconnecting in %s", delay.Round(time.Millisecond))
+
+			c.mu.Lock()
+			c.reconnectNow = true
+			c.mu.Unlock()
+			time.AfterFunc(delay, func() { conn.Close() })
+		}
+	}()
+	return out
+}
+
+// takeReconnectNow reports whether the server asked for the last connection
+// to be replaced, and clears it
+func (c *Client) takeReconnectNow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.reconnectNow
+	c.reconnectNow = false
+	return now
+}
+
+// jitter returns a random duration in [0, window)
+func jitter(window time.Duration) time.Duration {
+	if window <= 0 {
+		return 0
+	}
+	return rand.N(window)
+}
+
+// backoff returns a random duration in [delay/2, delay], so that devices
+// dropped together don't retry together
+func backoff(delay time.Duration) time.Duration {
+	return delay/2 + jitter(delay/2+1)
+}
@@ -0,0 +1,110 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWatchReconnect(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	serverConns := make(chan *ssh.ServerConn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		sshConn, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			for ch := range chans {
+				ch.Reject(ssh.Prohibited, "")
+			}
+		}()
+		serverConns <- sshConn
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, l.Addr().String(), &ssh.ClientConfig{
+		User:            "device",
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{}
+	client := ssh.NewClient(sshConn, chans, c.watchReconnect(sshConn, reqs))
+	defer client.Close()
+	server := <-serverConns
+
+	// Other global requests still reach the client, which refuses them
+	if ok, _, err := server.SendRequest("other@example.com", true, nil); ok || err != nil {
+		t.Errorf("other request = %v, %v", ok, err)
+	}
+	if c.takeReconnectNow() {
+		t.Error("reconnect requested before the server asked")
+	}
+
+	reconnect, _ := json.Marshal(control.Reconnect{WindowMS: 50})
+	if _, _, err := server.SendRequest(control.ReconnectRequest, false, reconnect); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't closed after the reconnect window")
+	}
+	if !c.takeReconnectNow() {
+		t.Error("connection loop wasn't told to reconnect now")
+	}
+	if c.takeReconnectNow() {
+		t.Error("reconnect request wasn't cleared")
+	}
+}
+
+func TestJitterAndBackoff(t *testing.T) {
+	if d := jitter(0); d != 0 {
+		t.Errorf("jitter(0) = %v", d)
+	}
+	for range 100 {
+		if d := jitter(time.Second); d < 0 || d >= time.Second {
+			t.Fatalf("jitter(1s) = %v", d)
+		}
+		if d := backoff(4 * time.Second); d < 2*time.Second || d > 4*time.Second {
+			t.Fatalf("backoff(4s) = %v", d)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package tunnel
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/websocket"
+)
+
+// Transports the tunnel's SSH connection can use
+const (
+	TransportAuto      = "auto"      // TCP, falling back to WebSocket when TCP fails
+	TransportTCP       = "tcp"       // Raw SSH to TunnelServerPort
+	TransportWebSocket = "websocket" // SSH over WebSocket on the API URL (wss:// for https)
+
+	// WebSocketPath is where the server accepts tunnels over WebSocket
+	WebSocketPath = "/api/tunnel/ws"
+
+	dialTimeout = 10 * time.Second
+)
+
+// ValidTransport reports whether t names a transport ("" means auto)
+func ValidTransport(t string) bool {
+	switch t {
+	case "", TransportAuto, TransportTCP, TransportWebSocket:
+		return true
+	}
+	return false
+}
+
+// transportOrder lists the transports to try: the configured one, or in auto
+// mode the one that worked last, then the other
+func (c *Client) transportOrder() []string {
+	switch c.transportMode {
+	case TransportTCP, TransportWebSocket:
+		return []string{c.transportMode}
+	}
+	if c.Transport() == TransportWebSocket {
+		return []string{TransportWebSocket, TransportTCP}
+	}
+	return []string{TransportTCP, TransportWebSocket}
+}
+
+// dialSSH connects to the tunnel server over the first transport that
+// completes an SSH handshake. A host key mismatch is never retried over
+// another transport.
+func (c *Client) dialSSH(config *ssh.ClientConfig) (*ssh.Client, string, error) {
+	sshConn, chans, reqs, transport, err := c.dialConn(config)
+	if err != nil {
+		return nil, "", err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), transport, nil
+}
+
+// dialConn is dialSSH before the connection is wrapped in a client, for
+// callers that handle some global requests themselves
+func (c *Client) dialConn(config *ssh.ClientConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, string, error) {
+	addr := net.JoinHostPort(c.serverHost, strconv.Itoa(TunnelServerPort))
+
+	var errs []error
+	for _, transport := range c.transportOrder() {
+		var conn net.Conn
+		var err error
+		if transport == TransportWebSocket {
+			conn, err = dialWebSocket(c.serverURL)
+		} else {
+			conn, err = net.DialTimeout("tcp", addr, dialTimeout)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", transport, err))
+			continue
+		}
+
+		conn.SetDeadline(time.Now().Add(dialTimeout))
+		sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+		if err != nil {
+			conn.Close()
+			var mismatch *HostKeyMismatchError
+			if errors.As(err, &mismatch) {
+				return nil, nil, nil, "", err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", transport, err))
+			continue
+		}
+		conn.SetDeadline(time.Time{})
+
+		return sshConn, chans, reqs, transport, nil
+	}
+	return nil, nil, nil, "", errors.Join(errs...)
+}
+
+// WebSocketURL returns the tunnel WebSocket URL for the API server URL
+func WebSocketURL(serverURL string) (*url.URL, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	default:
+		return nil, fmt.Errorf("invalid server URL: %s", serverURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + WebSocketPath
+	u.RawQuery = ""
+	return u, nil
+}
+
+// dialWebSocket opens a binary WebSocket to the tunnel endpoint, through the
+// HTTPS proxy from the environment if there is one
+func dialWebSocket(serverURL string) (net.Conn, error) {
+	wsURL, err := WebSocketURL(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	config, err := websocket.NewConfig(wsURL.String(), serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	secure := wsURL.Scheme == "wss"
+	host := wsURL.Host
+	if wsURL.Port() == "" {
+		port := "80"
+		if secure {
+			port = "443"
+		}
+		host = net.JoinHostPort(wsURL.Hostname(), port)
+	}
+
+	conn, err := dialViaProxy(host, secure)
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: wsURL.Hostname()})
+		tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake failed: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// dialViaProxy connects to host directly, or with HTTP CONNECT through the
+// proxy configured in HTTPS_PROXY/HTTP_PROXY (honoring NO_PROXY)
+func dialViaProxy(host string, secure bool) (net.Conn, error) {
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: scheme, Host: host}})
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy settings: %w", err)
+	}
+	if proxyURL == nil {
+		return net.DialTimeout("tcp", host, dialTimeout)
+	}
+
+	proxyHost := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyHost = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := net.DialTimeout("tcp", proxyHost, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach proxy %s: %w", proxyHost, err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: host},
+		Host:   host,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT failed: %w", err)
+	}
+	// Nothing follows the response until we send, so the reader can't
+	// swallow tunnel data
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT failed: %s", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocketURL(t *testing.T) {
+	tests := []struct {
+		serverURL string
+		want      string
+		ok        bool
+	}{
+		{"https://roamie.example.com", "wss://roamie.example.com/api/tunnel/ws", true},
+		{"https://roamie.example.com:8443/", "wss://roamie.example.com:8443/api/tunnel/ws", true},
+		{"http://10.0.0.1:8080", "ws://10.0.0.1:8080/api/tunnel/ws", true},
+		{"https://example.com/roamie?x=1", "wss://example.com/roamie/api/tunnel/ws", true},
+		{"ftp://example.com", "", false},
+	}
+	for _, tt := range tests {
+		got, err := WebSocketURL(tt.serverURL)
+		if (err == nil) != tt.ok {
+			t.Errorf("WebSocketURL(%q) error = %v, want ok=%v", tt.serverURL, err, tt.ok)
+			continue
+		}
+		if tt.ok && got.String() != tt.want {
+			t.Errorf("WebSocketURL(%q) = %q, want %q", tt.serverURL, got, tt.want)
+		}
+	}
+}
+
+func TestDialSSH_FallsBackToWebSocket(t *testing.T) {
+	if conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(TunnelServerPort)), time.Second); err == nil {
+		conn.Close()
+		t.Skipf("port %d is in use", TunnelServerPort)
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostKey)
+
+	server := httptest.NewServer(websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			sshConn, chans, reqs, err := ssh.NewServerConn(ws, serverConfig)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "")
+				}
+			}()
+			sshConn.Wait()
+		},
+	})
+	defer server.Close()
+
+	c := &Client{serverURL: server.URL, serverHost: "127.0.0.1"}
+	config := &ssh.ClientConfig{
+		User:            "device",
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	}
+
+	client, transport, err := c.dialSSH(config)
+	if err != nil {
+		t.Fatalf("dialSSH: %v", err)
+	}
+	client.Close()
+	if transport != TransportWebSocket {
+		t.Errorf("transport = %q, want %q", transport, TransportWebSocket)
+	}
+
+	// Pinned to TCP, there is no fallback
+	c.transportMode = TransportTCP
+	if client, _, err := c.dialSSH(config); err == nil {
+		client.Close()
+		t.Error("dialSSH over TCP succeeded with the tunnel port closed")
+	}
+}
@@ -0,0 +1,108 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// InputModel is the Bubble Tea model for single-line text prompts
+type InputModel struct {
+	title    string
+	value    []rune
+	masked   bool
+	quitting bool
+	aborted  bool
+}
+
+// NewInput creates a new text prompt. Masked prompts hide what is typed.
+func NewInput(title string, masked bool) InputModel {
+	return InputModel{
+		title:  title,
+		masked: masked,
+	}
+}
+
+// Init implements tea.Model
+func (m InputModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model
+func (m InputModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyEsc:
+			m.aborted = true
+			m.quitting = true
+			return m, tea.Quit
+
+		case tea.KeyEnter:
+			m.quitting = true
+			return m, tea.Quit
+
+		case tea.KeyBackspace:
+			if len(m.value) > 0 {
+				m.value = m.value[:len(m.value)-1]
+			}
+
+		case tea.KeyCtrlU:
+			m.value = nil
+
+		case tea.KeyRunes, tea.KeySpace:
+			m.value = append(m.value, msg.Runes...)
+		}
+	}
+	return m, nil
+}
+
+// View implements tea.Model
+func (m InputModel) View() string {
+	if m.quitting {
+		return ""
+	}
+
+	shown := string(m.value)
+	if m.masked {
+		shown = strings.Repeat("•", len(m.value))
+	}
+
+	var b strings.Builder
+	b.WriteString(TitleStyle.Render(m.title))
+	b.WriteString("\n\n")
+	b.WriteString(CursorStyle.Render("▸ "))
+	b.WriteString(shown)
+	b.WriteString("\n\n")
+	b.WriteString(HelpStyle.Render("Enter confirm • Esc cancel"))
+
+	return b.String()
+}
+
+// Value returns what was typed
+func (m InputModel) Value() string {
+	return string(m.value)
+}
+
+// Aborted returns true if the user cancelled
+func (m InputModel) Aborted() bool {
+	return m.aborted
+}
+
+// Input runs a text prompt and returns what was typed
+func Input(title string, masked bool) (string, error) {
+	m := NewInput(title, masked)
+	p := tea.NewProgram(m)
+
+	result, err := p.Run()
+	if err != nil {
+		return "", fmt.Errorf("failed to run input: %w", err)
+	}
+
+	finalModel := result.(InputModel)
+	if finalModel.Aborted() {
+		return "", fmt.Errorf("cancelled")
+	}
+	return finalModel.Value(), nil
+}
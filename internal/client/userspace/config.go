@@ -0,0 +1,55 @@
+package userspace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/client/config"
+)
+
+// FromClientConfig builds a tunnel configuration from the saved client config
+func FromClientConfig(cfg *config.Config) (Config, error) {
+	if cfg.PrivateKey == "" || cfg.VpnIP == "" {
+		return Config{}, fmt.Errorf("no device registration found")
+	}
+
+	socksAddr := cfg.UserspaceSOCKS
+	if socksAddr == "" {
+		socksAddr = DefaultSOCKSAddr
+	}
+
+	forwards := make([]Forward, 0, len(cfg.UserspaceForwards))
+	for _, s := range cfg.UserspaceForwards {
+		fwd, err := ParseForward(s)
+		if err != nil {
+			return Config{}, err
+		}
+		forwards = append(forwards, fwd)
+	}
+
+	return Config{
+		PrivateKey: cfg.PrivateKey,
+		Address:    cfg.VpnIP,
+		ServerKey:  cfg.ServerPublicKey,
+		Endpoint:   cfg.ServerEndpoint,
+		AllowedIPs: cfg.AllowedIPs,
+		DNSServer:  cfg.DNSServer,
+		DNSZone:    cfg.DNSZone,
+		SOCKSAddr:  socksAddr,
+		HTTPAddr:   cfg.UserspaceHTTP,
+		Forwards:   forwards,
+	}, nil
+}
+
+// Fingerprint identifies the settings a running tunnel was started with, so
+// callers can tell when a config change requires a restart
+func (c Config) Fingerprint() string {
+	fwds := make([]string, len(c.Forwards))
+	for i, f := range c.Forwards {
+		fwds[i] = f.String()
+	}
+	return strings.Join([]string{
+		c.Address, c.ServerKey, c.Endpoint, c.AllowedIPs, c.DNSServer, c.DNSZone,
+		c.SOCKSAddr, c.HTTPAddr, strings.Join(fwds, ","),
+	}, "|")
+}
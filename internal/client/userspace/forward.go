@@ -0,0 +1,71 @@
+package userspace
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Forward maps a local TCP listen address to an address reachable over the VPN
+type Forward struct {
+	Local  string // e.g. "127.0.0.1:8080"
+	Remote string // e.g. "10.100.0.3:80"
+}
+
+func (f Forward) String() string {
+	return f.Local + "=" + f.Remote
+}
+
+// ParseForward parses "local=remote". A bare port on the local side binds to
+// 127.0.0.1 so forwards are never exposed to the LAN by accident.
+func ParseForward(s string) (Forward, error) {
+	local, remote, ok := strings.Cut(s, "=")
+	if !ok || local == "" || remote == "" {
+		return Forward{}, fmt.Errorf("invalid forward %q (expected local=remote, e.g. 8080=10.100.0.3:80)", s)
+	}
+
+	if !strings.Contains(local, ":") {
+		local = "127.0.0.1:" + local
+	}
+	if _, _, err := net.SplitHostPort(local); err != nil {
+		return Forward{}, fmt.Errorf("invalid local address %q: %w", local, err)
+	}
+	if _, _, err := net.SplitHostPort(remote); err != nil {
+		return Forward{}, fmt.Errorf("invalid remote address %q: %w", remote, err)
+	}
+
+	return Forward{Local: local, Remote: remote}, nil
+}
+
+func (t *Tunnel) handleForward(c net.Conn, remote string) {
+	defer c.Close()
+
+	upstream, err := t.DialContext(t.ctx, "tcp", remote)
+	if err != nil {
+		log.Printf("userspace: forward to %s failed: %v", remote, err)
+		return
+	}
+	defer upstream.Close()
+
+	pipe(c, upstream)
+}
+
+// pipe copies data in both directions until either side closes
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(a, b)
+		a.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(b, a)
+		b.Close()
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,237 @@
+package userspace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// SOCKS5 protocol constants (RFC 1928)
+const (
+	socksVersion       = 0x05
+	socksNoAuth        = 0x00
+	socksNoAcceptable  = 0xff
+	socksCmdConnect    = 0x01
+	socksAtypIPv4      = 0x01
+	socksAtypDomain    = 0x03
+	socksAtypIPv6      = 0x04
+	socksReplySuccess  = 0x00
+	socksReplyFailure  = 0x01
+	socksReplyNotAllow = 0x07
+)
+
+// handleSOCKS serves a single SOCKS5 client (CONNECT only, no authentication)
+func (t *Tunnel) handleSOCKS(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	// Greeting: VER NMETHODS METHODS...
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != socksVersion {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	c.Write([]byte{socksVersion, method})
+	if method != socksNoAuth {
+		return
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil {
+		return
+	}
+	if req[1] != socksCmdConnect {
+		writeSOCKSReply(c, socksReplyNotAllow)
+		return
+	}
+
+	host, err := readSOCKSAddr(r, req[3])
+	if err != nil {
+		writeSOCKSReply(c, socksReplyFailure)
+		return
+	}
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBytes); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes))))
+
+	upstream, err := t.DialContext(t.ctx, "tcp", target)
+	if err != nil {
+		log.Printf("userspace: SOCKS5 connect to %s failed: %v", target, err)
+		writeSOCKSReply(c, socksReplyFailure)
+		return
+	}
+	defer upstream.Close()
+
+	writeSOCKSReply(c, socksReplySuccess)
+	pipe(&bufferedConn{Conn: c, r: r}, upstream)
+}
+
+func readSOCKSAddr(r io.Reader, atyp byte) (string, error) {
+	switch atyp {
+	case socksAtypIPv4:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		return net.IP(ip).String(), nil
+	case socksAtypIPv6:
+		ip := make([]byte, 16)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		return net.IP(ip).String(), nil
+	case socksAtypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return "", err
+		}
+		domain := make([]byte, l[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		return string(domain), nil
+	default:
+		return "", fmt.Errorf("unsupported address type %d", atyp)
+	}
+}
+
+func writeSOCKSReply(c net.Conn, reply byte) {
+	// Bound address is not meaningful for a userspace stack, report 0.0.0.0:0
+	c.Write([]byte{socksVersion, reply, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+}
+
+// handleHTTP serves a single HTTP proxy client. CONNECT requests are tunneled,
+// plain requests are forwarded one at a time with the absolute URL they carry,
+// each over its own upstream connection, so a kept-alive client connection
+// can reach a different host with every request.
+func (t *Tunnel) handleHTTP(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+
+		if req.Method == http.MethodConnect {
+			upstream, err := t.DialContext(t.ctx, "tcp", req.Host)
+			if err != nil {
+				log.Printf("userspace: HTTP CONNECT to %s failed: %v", req.Host, err)
+				fmt.Fprint(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+				return
+			}
+			defer upstream.Close()
+
+			fmt.Fprint(c, "HTTP/1.1 200 Connection Established\r\n\r\n")
+			pipe(&bufferedConn{Conn: c, r: r}, upstream)
+			return
+		}
+
+		if !t.forwardHTTP(c, req) {
+			return
+		}
+	}
+}
+
+// forwardHTTP sends a plain proxy request to its host and relays the
+// response. It reports whether the client connection can take another request.
+func (t *Tunnel) forwardHTTP(c net.Conn, req *http.Request) bool {
+	host := req.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	upstream, err := t.DialContext(t.ctx, "tcp", host)
+	if err != nil {
+		log.Printf("userspace: HTTP proxy to %s failed: %v", host, err)
+		fmt.Fprint(c, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+		return false
+	}
+	defer upstream.Close()
+
+	// Send the request in origin form, without the headers meant for us
+	keepAlive := !req.Close
+	removeHopHeaders(req.Header)
+	req.RequestURI = ""
+	req.Close = true
+	if err := req.Write(upstream); err != nil {
+		log.Printf("userspace: HTTP proxy to %s failed: %v", host, err)
+		fmt.Fprint(c, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+		return false
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(upstream), req)
+	if err != nil {
+		log.Printf("userspace: HTTP proxy to %s failed: %v", host, err)
+		fmt.Fprint(c, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")
+		return false
+	}
+	defer resp.Body.Close()
+
+	// A body that ends when the upstream closes can only be relayed the same way
+	if resp.ContentLength < 0 && len(resp.TransferEncoding) == 0 && resp.Body != http.NoBody {
+		keepAlive = false
+	}
+	removeHopHeaders(resp.Header)
+	resp.Close = !keepAlive
+	if err := resp.Write(c); err != nil {
+		return false
+	}
+	return keepAlive
+}
+
+// hopHeaders apply to a single connection and are not forwarded (RFC 9110 7.6.1)
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders deletes hop-by-hop headers, including those listed in Connection
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// bufferedConn lets pipe drain bytes already read into a bufio.Reader
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
@@ -0,0 +1,302 @@
+package userspace
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// DefaultMTU matches the MTU wg-quick picks for typical links
+	DefaultMTU = 1420
+
+	// DefaultSOCKSAddr is where the SOCKS5 proxy listens unless configured otherwise
+	DefaultSOCKSAddr = "127.0.0.1:1080"
+
+	// DefaultHTTPAddr is where the HTTP proxy listens unless configured otherwise
+	DefaultHTTPAddr = "127.0.0.1:8118"
+)
+
+// Config describes a userspace WireGuard tunnel and the local entry points into it
+type Config struct {
+	PrivateKey string // Base64 WireGuard private key
+	Address    string // VPN IP of this device
+	ServerKey  string // Base64 WireGuard public key of the server
+	Endpoint   string // host:port of the server
+	AllowedIPs string // Comma-separated CIDRs routed through the tunnel
+	ListenPort int    // Local UDP port (0 picks a random port)
+	MTU        int
+	DNSServer  string // VPN resolver; names under DNSZone are resolved through it
+	DNSZone    string
+
+	SOCKSAddr string    // SOCKS5 proxy listen address ("" disables it)
+	HTTPAddr  string    // HTTP proxy listen address ("" disables it)
+	Forwards  []Forward // Explicit local-to-VPN port forwards
+}
+
+// Tunnel is a running wireguard-go device backed by a gVisor netstack.
+// No TUN device, kernel module or root privileges are required: local apps
+// reach the VPN through the proxies and port forwards.
+type Tunnel struct {
+	dev      *device.Device
+	tnet     *netstack.Net
+	prefixes []netip.Prefix
+	dnsZone  string
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	listeners []net.Listener
+	wg        sync.WaitGroup
+}
+
+// Start brings up the userspace tunnel and its local listeners
+func Start(ctx context.Context, cfg Config) (*Tunnel, error) {
+	addr, err := netip.ParseAddr(cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VPN address %q: %w", cfg.Address, err)
+	}
+
+	prefixes, err := parseAllowedIPs(cfg.AllowedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The resolver lives on the server, outside the user subnet
+	var dnsServers []netip.Addr
+	if cfg.DNSServer != "" && cfg.DNSZone != "" {
+		dnsAddr, err := netip.ParseAddr(cfg.DNSServer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS server %q: %w", cfg.DNSServer, err)
+		}
+		dnsServers = append(dnsServers, dnsAddr)
+		prefixes = append(prefixes, netip.PrefixFrom(dnsAddr, dnsAddr.BitLen()))
+	}
+
+	ipc, err := buildIpcConfig(cfg, prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	mtu := cfg.MTU
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+
+	tunDev, tnet, err := netstack.CreateNetTUN([]netip.Addr{addr}, dnsServers, mtu)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netstack: %w", err)
+	}
+
+	logger := &device.Logger{
+		Verbosef: device.DiscardLogf,
+		Errorf: func(format string, args ...any) {
+			log.Printf("userspace wireguard: "+format, args...)
+		},
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), logger)
+
+	if err := dev.IpcSet(ipc); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("failed to configure device: %w", err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("failed to bring device up: %w", err)
+	}
+
+	tunnelCtx, cancel := context.WithCancel(ctx)
+	t := &Tunnel{
+		dev:      dev,
+		tnet:     tnet,
+		prefixes: prefixes,
+		dnsZone:  strings.ToLower(cfg.DNSZone),
+		ctx:      tunnelCtx,
+		cancel:   cancel,
+	}
+
+	if cfg.SOCKSAddr != "" {
+		if err := t.serve(cfg.SOCKSAddr, t.handleSOCKS); err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to start SOCKS5 proxy: %w", err)
+		}
+	}
+	if cfg.HTTPAddr != "" {
+		if err := t.serve(cfg.HTTPAddr, t.handleHTTP); err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to start HTTP proxy: %w", err)
+		}
+	}
+	for _, fwd := range cfg.Forwards {
+		fwd := fwd
+		if err := t.serve(fwd.Local, func(c net.Conn) { t.handleForward(c, fwd.Remote) }); err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to start forward %s: %w", fwd, err)
+		}
+	}
+
+	return t, nil
+}
+
+// Net exposes the tunnel's network stack (used by tests and embedders)
+func (t *Tunnel) Net() *netstack.Net {
+	return t.tnet
+}
+
+// DialContext dials through the tunnel when the destination is inside the
+// VPN's allowed IPs, and directly otherwise, so a single proxy works for both
+func (t *Tunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		ip, err = t.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	target := net.JoinHostPort(ip.Unmap().String(), port)
+	if t.routesThroughTunnel(ip) {
+		return t.tnet.DialContext(ctx, network, target)
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, network, target)
+}
+
+// Close stops the listeners and tears down the WireGuard device
+func (t *Tunnel) Close() {
+	t.cancel()
+	for _, l := range t.listeners {
+		l.Close()
+	}
+	t.wg.Wait()
+	t.dev.Close()
+}
+
+// lookup resolves VPN device names through the tunnel's resolver and
+// everything else through the system resolver
+func (t *Tunnel) lookup(ctx context.Context, host string) (netip.Addr, error) {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if t.dnsZone != "" && strings.HasSuffix(name, "."+t.dnsZone) {
+		addrs, err := t.tnet.LookupContextHost(ctx, name)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("failed to resolve %s over VPN: %w", host, err)
+		}
+		if len(addrs) == 0 {
+			return netip.Addr{}, fmt.Errorf("no addresses for %s", host)
+		}
+		return netip.ParseAddr(addrs[0])
+	}
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	if len(ips) == 0 {
+		return netip.Addr{}, fmt.Errorf("no addresses for %s", host)
+	}
+	return ips[0], nil
+}
+
+func (t *Tunnel) routesThroughTunnel(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range t.prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// serve accepts connections on a local address and hands each one to handler
+func (t *Tunnel) serve(addr string, handler func(net.Conn)) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	t.listeners = append(t.listeners, l)
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				if t.ctx.Err() == nil {
+					log.Printf("userspace: accept on %s failed: %v", addr, err)
+				}
+				return
+			}
+			go handler(c)
+		}
+	}()
+
+	return nil
+}
+
+func parseAllowedIPs(allowedIPs string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(allowedIPs, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed IP %q: %w", s, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("no allowed IPs configured")
+	}
+	return prefixes, nil
+}
+
+// buildIpcConfig renders the wireguard-go UAPI configuration for the device
+func buildIpcConfig(cfg Config, prefixes []netip.Prefix) (string, error) {
+	privateKey, err := wgtypes.ParseKey(cfg.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	serverKey, err := wgtypes.ParseKey(cfg.ServerKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid server public key: %w", err)
+	}
+
+	// The UAPI only accepts literal IP endpoints, and IPv4 must not be v4-mapped
+	// or it is sent over the IPv6 socket
+	endpoint, err := net.ResolveUDPAddr("udp", cfg.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve endpoint %q: %w", cfg.Endpoint, err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "private_key=%s\n", hex.EncodeToString(privateKey[:]))
+	if cfg.ListenPort != 0 {
+		fmt.Fprintf(&b, "listen_port=%d\n", cfg.ListenPort)
+	}
+	fmt.Fprintf(&b, "public_key=%s\n", hex.EncodeToString(serverKey[:]))
+	ap := endpoint.AddrPort()
+	fmt.Fprintf(&b, "endpoint=%s\n", netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()))
+	fmt.Fprintf(&b, "persistent_keepalive_interval=25\n")
+	for _, p := range prefixes {
+		fmt.Fprintf(&b, "allowed_ip=%s\n", p)
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,261 @@
+package userspace
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// startPeer brings up a bare netstack WireGuard peer playing the server role
+func startPeer(t *testing.T, key, clientKey wgtypes.Key, addr string, port int) *netstack.Net {
+	t.Helper()
+
+	tunDev, tnet, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr(addr)}, nil, DefaultMTU)
+	if err != nil {
+		t.Fatalf("CreateNetTUN: %v", err)
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+
+	pub := clientKey.PublicKey()
+	ipc := fmt.Sprintf("private_key=%s\nlisten_port=%d\npublic_key=%s\nallowed_ip=10.200.0.2/32\n",
+		hex.EncodeToString(key[:]), port, hex.EncodeToString(pub[:]))
+	if err := dev.IpcSet(ipc); err != nil {
+		t.Fatalf("IpcSet: %v", err)
+	}
+	if err := dev.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	return tnet
+}
+
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+func freeTCPAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// TestTunnelEndToEnd runs two userspace WireGuard peers over loopback UDP and
+// reaches a TCP service on the "server" side through every client entry point.
+// No TUN device or privileges are required.
+func TestTunnelEndToEnd(t *testing.T) {
+	serverKey, _ := wgtypes.GeneratePrivateKey()
+	clientKey, _ := wgtypes.GeneratePrivateKey()
+	port := freeUDPPort(t)
+
+	serverNet := startPeer(t, serverKey, clientKey, "10.200.0.1", port)
+
+	// Echo service reachable only inside the VPN
+	l, err := serverNet.ListenTCP(&net.TCPAddr{IP: net.ParseIP("10.200.0.1"), Port: 7000})
+	if err != nil {
+		t.Fatalf("ListenTCP: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				line, err := bufio.NewReader(c).ReadString('\n')
+				if err != nil {
+					return
+				}
+				io.WriteString(c, "echo: "+line)
+			}()
+		}
+	}()
+
+	// Two web services, each reporting who it is and the proxy headers it got
+	for _, port := range []int{8001, 8002} {
+		wl, err := serverNet.ListenTCP(&net.TCPAddr{IP: net.ParseIP("10.200.0.1"), Port: port})
+		if err != nil {
+			t.Fatalf("ListenTCP: %v", err)
+		}
+		defer wl.Close()
+		name := fmt.Sprintf("web-%d", port)
+		go http.Serve(wl, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s proxy-authorization=%q", name, r.Header.Get("Proxy-Authorization"))
+		}))
+	}
+
+	socksAddr := freeTCPAddr(t)
+	httpAddr := freeTCPAddr(t)
+	fwdAddr := freeTCPAddr(t)
+
+	tun, err := Start(context.Background(), Config{
+		PrivateKey: clientKey.String(),
+		Address:    "10.200.0.2",
+		ServerKey:  serverKey.PublicKey().String(),
+		Endpoint:   fmt.Sprintf("127.0.0.1:%d", port),
+		AllowedIPs: "10.200.0.0/24",
+		SOCKSAddr:  socksAddr,
+		HTTPAddr:   httpAddr,
+		Forwards:   []Forward{{Local: fwdAddr, Remote: "10.200.0.1:7000"}},
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer tun.Close()
+
+	echo := func(t *testing.T, c net.Conn) {
+		t.Helper()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		io.WriteString(c, "hello\n")
+		got, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil {
+			t.Fatalf("read echo: %v", err)
+		}
+		if got != "echo: hello\n" {
+			t.Fatalf("echo = %q", got)
+		}
+	}
+
+	t.Run("socks5", func(t *testing.T) {
+		c, err := net.Dial("tcp", socksAddr)
+		if err != nil {
+			t.Fatalf("dial socks: %v", err)
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		c.Write([]byte{socksVersion, 1, socksNoAuth})
+		resp := make([]byte, 2)
+		if _, err := io.ReadFull(c, resp); err != nil || resp[1] != socksNoAuth {
+			t.Fatalf("greeting: %v %v", resp, err)
+		}
+		c.Write([]byte{socksVersion, socksCmdConnect, 0, socksAtypIPv4, 10, 200, 0, 1, 0x1b, 0x58})
+		reply := make([]byte, 10)
+		if _, err := io.ReadFull(c, reply); err != nil || reply[1] != socksReplySuccess {
+			t.Fatalf("connect reply: %v %v", reply, err)
+		}
+		echo(t, c)
+	})
+
+	t.Run("http connect", func(t *testing.T) {
+		c, err := net.Dial("tcp", httpAddr)
+		if err != nil {
+			t.Fatalf("dial http proxy: %v", err)
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		io.WriteString(c, "CONNECT 10.200.0.1:7000 HTTP/1.1\r\nHost: 10.200.0.1:7000\r\n\r\n")
+		r := bufio.NewReader(c)
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("CONNECT response: %v %v", resp, err)
+		}
+		io.WriteString(c, "hello\n")
+		got, err := r.ReadString('\n')
+		if err != nil || got != "echo: hello\n" {
+			t.Fatalf("echo = %q, %v", got, err)
+		}
+	})
+
+	t.Run("http keep-alive", func(t *testing.T) {
+		c, err := net.Dial("tcp", httpAddr)
+		if err != nil {
+			t.Fatalf("dial http proxy: %v", err)
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		// Requests on one client connection go to the host each one names
+		r := bufio.NewReader(c)
+		for _, port := range []int{8001, 8002, 8001} {
+			fmt.Fprintf(c, "GET http://10.200.0.1:%d/ HTTP/1.1\r\nHost: 10.200.0.1:%d\r\nProxy-Authorization: Basic c2VjcmV0\r\n\r\n", port, port)
+			resp, err := http.ReadResponse(r, nil)
+			if err != nil {
+				t.Fatalf("response from %d: %v", port, err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("body from %d: %v", port, err)
+			}
+			if want := fmt.Sprintf("web-%d proxy-authorization=\"\"", port); string(body) != want {
+				t.Errorf("body = %q, want %q", body, want)
+			}
+			if resp.Close {
+				t.Fatal("proxy closed a kept-alive connection")
+			}
+		}
+	})
+
+	t.Run("forward", func(t *testing.T) {
+		c, err := net.Dial("tcp", fwdAddr)
+		if err != nil {
+			t.Fatalf("dial forward: %v", err)
+		}
+		defer c.Close()
+		echo(t, c)
+	})
+}
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Forward
+		wantErr bool
+	}{
+		{"8080=10.100.0.3:80", Forward{"127.0.0.1:8080", "10.100.0.3:80"}, false},
+		{"0.0.0.0:2222=10.100.0.5:22", Forward{"0.0.0.0:2222", "10.100.0.5:22"}, false},
+		{"8080", Forward{}, true},
+		{"8080=10.100.0.3", Forward{}, true},
+		{"=10.100.0.3:80", Forward{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseForward(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseForward(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseForward(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseAllowedIPs(t *testing.T) {
+	prefixes, err := parseAllowedIPs("10.100.0.0/24, 192.168.1.5/24")
+	if err != nil {
+		t.Fatalf("parseAllowedIPs: %v", err)
+	}
+	if len(prefixes) != 2 || prefixes[1].String() != "192.168.1.0/24" {
+		t.Errorf("prefixes = %v", prefixes)
+	}
+
+	if _, err := parseAllowedIPs(""); err == nil {
+		t.Error("expected error for empty allowed IPs")
+	}
+}
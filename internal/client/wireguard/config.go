@@ -16,18 +16,35 @@ type WireGuardConfig struct {
 	Endpoint   string
 	AllowedIPs string
 	DNS        string
+	DNSZone    string // VPN DNS zone; with DNS set, enables split DNS in split tunnel mode
+
+	// ForwardSubnet enables forwarding and NAT for traffic from this VPN subnet
+	// (set when the device is a subnet router or exit node)
+	ForwardSubnet string
 }
 
 func GenerateConfigFile(config WireGuardConfig) string {
 	// Only set DNS for full tunnel (0.0.0.0/0)
-	// Split tunnel uses system DNS which avoids systemd-resolved conflicts on Ubuntu
+	// Split tunnel uses system DNS which avoids systemd-resolved conflicts on Ubuntu;
+	// only the VPN zone is sent to the VPN resolver via PostUp/PostDown hooks
 	dnsLine := ""
+	allowedIPs := config.AllowedIPs
 	if strings.Contains(config.AllowedIPs, "0.0.0.0/0") {
 		dns := config.DNS
 		if dns == "" {
 			dns = "1.1.1.1, 8.8.8.8"
 		}
 		dnsLine = fmt.Sprintf("DNS = %s\n", dns)
+	} else if config.DNS != "" && config.DNSZone != "" {
+		dnsLine = splitDNSHooks(runtime.GOOS, config.DNS, config.DNSZone)
+		if dnsLine != "" && !strings.Contains(allowedIPs, config.DNS+"/32") {
+			// The resolver lives on the server, outside the user subnet
+			allowedIPs += ", " + config.DNS + "/32"
+		}
+	}
+
+	if config.ForwardSubnet != "" {
+		dnsLine += forwardingHooks(runtime.GOOS, config.ForwardSubnet)
 	}
 
 	return fmt.Sprintf(`[Interface]
@@ -39,7 +56,55 @@ PublicKey = %s
 Endpoint = %s
 AllowedIPs = %s
 PersistentKeepalive = 25
-`, config.PrivateKey, config.Address, dnsLine, config.ServerKey, config.Endpoint, config.AllowedIPs)
+`, config.PrivateKey, config.Address, dnsLine, config.ServerKey, config.Endpoint, allowedIPs)
+}
+
+// splitDNSHooks returns wg-quick hooks that send only the VPN zone to the VPN
+// resolver. Linux uses systemd-resolved (skipped if resolvectl is missing),
+// macOS uses /etc/resolver. Windows is not supported: WireGuard for Windows
+// disables hooks by default and a DNS line would capture every query.
+func splitDNSHooks(goos, dns, zone string) string {
+	switch goos {
+	case "linux":
+		return fmt.Sprintf("PostUp = command -v resolvectl >/dev/null && resolvectl dns %%i %s && resolvectl domain %%i ~%s || true\n"+
+			"PostDown = command -v resolvectl >/dev/null && resolvectl revert %%i || true\n", dns, zone)
+	case "darwin":
+		return fmt.Sprintf("PostUp = mkdir -p /etc/resolver && echo 'nameserver %s' > /etc/resolver/%s\n"+
+			"PostDown = rm -f /etc/resolver/%s\n", dns, zone, zone)
+	default:
+		return ""
+	}
+}
+
+// forwardingHooks returns wg-quick hooks that let other devices of the same
+// user reach the LAN or internet through this device. Traffic arriving from
+// any other source is dropped so other users can't use the route. Windows is
+// not supported (hooks are disabled by default there).
+func forwardingHooks(goos, subnet string) string {
+	switch goos {
+	case "linux":
+		return fmt.Sprintf("PostUp = sysctl -w net.ipv4.ip_forward=1\n"+
+			"PostUp = iptables -I FORWARD -i %%i -j DROP; iptables -I FORWARD -i %%i -s %[1]s -j ACCEPT; "+
+			"iptables -I FORWARD -o %%i -d %[1]s -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT; "+
+			"iptables -t nat -A POSTROUTING -s %[1]s ! -o %%i -j MASQUERADE\n"+
+			"PostDown = iptables -D FORWARD -i %%i -j DROP; iptables -D FORWARD -i %%i -s %[1]s -j ACCEPT; "+
+			"iptables -D FORWARD -o %%i -d %[1]s -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT; "+
+			"iptables -t nat -D POSTROUTING -s %[1]s ! -o %%i -j MASQUERADE\n", subnet)
+	case "darwin":
+		// The default pf.conf loads anchors under com.apple/*
+		return fmt.Sprintf("PostUp = sysctl -w net.inet.ip.forwarding=1 && "+
+			"IF=$(route -n get default | awk '/interface:/{print $2}') && "+
+			"echo \"nat on $IF from %s to any -> ($IF)\" | pfctl -a com.apple/roamie -f - && pfctl -E\n"+
+			"PostDown = pfctl -a com.apple/roamie -F all || true\n", subnet)
+	default:
+		return ""
+	}
+}
+
+// ForwardingSupported reports whether this platform can act as a subnet
+// router or exit node
+func ForwardingSupported() bool {
+	return forwardingHooks(runtime.GOOS, "0.0.0.0/0") != ""
 }
 
 // getWireGuardConfigDir returns the WireGuard configuration directory for the current platform
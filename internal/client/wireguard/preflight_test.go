@@ -143,3 +143,91 @@ func TestGenerateConfigFile_FullTunnel(t *testing.T) {
 
 	t.Logf("Full tunnel config (with DNS):\n%s", result)
 }
+
+// TestGenerateConfigFile_SplitDNS tests that split tunnel with a VPN zone routes only that zone to the VPN resolver
+func TestGenerateConfigFile_SplitDNS(t *testing.T) {
+	config := WireGuardConfig{
+		PrivateKey: "test-private-key",
+		Address:    "10.100.0.2",
+		ServerKey:  "test-server-key",
+		Endpoint:   "vpn.example.com:51820",
+		AllowedIPs: "10.100.0.0/29",
+		DNS:        "10.100.0.1",
+		DNSZone:    "roamie.internal",
+	}
+
+	result := GenerateConfigFile(config)
+
+	// Never a global DNS line in split tunnel mode
+	if strings.Contains(result, "DNS =") {
+		t.Errorf("Split DNS config should NOT contain DNS line.\nConfig:\n%s", result)
+	}
+
+	if splitDNSHooks(runtime.GOOS, config.DNS, config.DNSZone) != "" {
+		if !strings.Contains(result, "AllowedIPs = 10.100.0.0/29, 10.100.0.1/32") {
+			t.Errorf("Resolver address should be routed through the tunnel.\nConfig:\n%s", result)
+		}
+	}
+
+	linux := splitDNSHooks("linux", "10.100.0.1", "roamie.internal")
+	if !strings.Contains(linux, "resolvectl dns %i 10.100.0.1") || !strings.Contains(linux, "resolvectl domain %i ~roamie.internal") {
+		t.Errorf("Linux hooks should configure systemd-resolved for the zone:\n%s", linux)
+	}
+	if !strings.Contains(linux, "PostDown = command -v resolvectl >/dev/null && resolvectl revert %i") {
+		t.Errorf("Linux hooks should revert on teardown:\n%s", linux)
+	}
+
+	darwin := splitDNSHooks("darwin", "10.100.0.1", "roamie.internal")
+	if !strings.Contains(darwin, "/etc/resolver/roamie.internal") {
+		t.Errorf("macOS hooks should use /etc/resolver:\n%s", darwin)
+	}
+
+	if windows := splitDNSHooks("windows", "10.100.0.1", "roamie.internal"); windows != "" {
+		t.Errorf("Windows should not get hooks, got:\n%s", windows)
+	}
+}
+
+func TestGenerateConfigFile_Forwarding(t *testing.T) {
+	config := WireGuardConfig{
+		PrivateKey:    "test-private-key",
+		Address:       "10.100.0.2",
+		ServerKey:     "test-server-key",
+		Endpoint:      "vpn.example.com:51820",
+		AllowedIPs:    "10.100.0.0/29",
+		ForwardSubnet: "10.100.0.0/29",
+	}
+
+	result := GenerateConfigFile(config)
+	if hooks := forwardingHooks(runtime.GOOS, config.ForwardSubnet); hooks != "" && !strings.Contains(result, hooks) {
+		t.Errorf("Config should contain forwarding hooks.\nConfig:\n%s", result)
+	}
+
+	linux := forwardingHooks("linux", "10.100.0.0/29")
+	if !strings.Contains(linux, "net.ipv4.ip_forward=1") {
+		t.Errorf("Linux hooks should enable forwarding:\n%s", linux)
+	}
+	if !strings.Contains(linux, "POSTROUTING -s 10.100.0.0/29 ! -o %i -j MASQUERADE") {
+		t.Errorf("Linux hooks should NAT the user subnet:\n%s", linux)
+	}
+	if !strings.Contains(linux, "iptables -I FORWARD -i %i -j DROP") {
+		t.Errorf("Linux hooks should drop traffic from other subnets:\n%s", linux)
+	}
+	if strings.Count(linux, "PostUp") != 2 || strings.Count(linux, "PostDown") != 1 {
+		t.Errorf("Unexpected hook lines:\n%s", linux)
+	}
+
+	darwin := forwardingHooks("darwin", "10.100.0.0/29")
+	if !strings.Contains(darwin, "nat on $IF from 10.100.0.0/29") {
+		t.Errorf("macOS hooks should NAT through pf:\n%s", darwin)
+	}
+
+	if windows := forwardingHooks("windows", "10.100.0.0/29"); windows != "" {
+		t.Errorf("Windows should not get hooks, got:\n%s", windows)
+	}
+
+	// No forwarding unless requested
+	config.ForwardSubnet = ""
+	if strings.Contains(GenerateConfigFile(config), "MASQUERADE") {
+		t.Error("Config should not forward without ForwardSubnet")
+	}
+}
@@ -0,0 +1,91 @@
+// Package activation picks up listening sockets passed to the server by
+// systemd socket activation, or by anything that passes file descriptors the
+// same way (LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES, descriptors from 3).
+//
+// The sockets stay open in the parent while the server restarts, so
+// connections wait in the kernel's queue instead of being refused. Sockets
+// are looked up by name (FileDescriptorName= in the socket unit): "tunnel"
+// for the SSH tunnel port and "api" for the HTTP API.
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Socket names
+const (
+	Tunnel = "tunnel"
+	API    = "api"
+)
+
+// First passed file descriptor (after stdin, stdout and stderr)
+const firstFD = 3
+
+var (
+	once      sync.Once
+	passed    map[string]int
+	passedErr error
+)
+
+// Listener returns the passed socket named name, or nil if there is none.
+// Each socket can be taken once.
+func Listener(name string) (net.Listener, error) {
+	once.Do(func() {
+		passed, passedErr = parse(os.Getpid(), os.Getenv)
+		// Not for child processes
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	if passedErr != nil {
+		return nil, passedErr
+	}
+
+	fd, ok := passed[name]
+	if !ok {
+		return nil, nil
+	}
+	delete(passed, name)
+
+	file := os.NewFile(uintptr(fd), name)
+	defer file.Close() // FileListener keeps its own copy
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("passed socket %q: %w", name, err)
+	}
+	return listener, nil
+}
+
+// parse returns the passed descriptors by name. The variables are only meant
+// for the process LISTEN_PID names.
+func parse(pid int, getenv func(string) string) (map[string]int, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+
+	var names []string
+	if raw := getenv("LISTEN_FDNAMES"); raw != "" {
+		names = strings.Split(raw, ":")
+	}
+	if len(names) != count {
+		return nil, fmt.Errorf("LISTEN_FDNAMES names %d sockets, LISTEN_FDS passes %d", len(names), count)
+	}
+
+	fds := make(map[string]int, count)
+	for i, name := range names {
+		if _, dup := fds[name]; dup {
+			return nil, fmt.Errorf("socket %q passed twice", name)
+		}
+		fds[name] = firstFD + i
+	}
+	return fds, nil
+}
@@ -0,0 +1,37 @@
+package activation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]int
+		err  string
+	}{
+		{"not activated", map[string]string{}, nil, ""},
+		{"other process", map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1", "LISTEN_FDNAMES": "tunnel"}, nil, ""},
+		{"named sockets", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "tunnel:api"}, map[string]int{"tunnel": 3, "api": 4}, ""},
+		{"missing names", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "1"}, nil, "names 0 sockets"},
+		{"invalid count", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "x"}, nil, "invalid LISTEN_FDS"},
+		{"duplicate name", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "api:api"}, nil, "passed twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse(42, func(key string) string { return tt.env[key] })
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("parse = %v, %v, want error containing %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
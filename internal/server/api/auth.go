@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/kamikazebr/roamie-desktop/internal/server/services"
@@ -31,6 +32,10 @@ func (h *AuthHandler) RequestCode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	expiresIn, err := h.authService.RequestCode(r.Context(), req.Email)
+	if errors.Is(err, services.ErrEmailLoginDisabled) {
+		respondErrorJSON(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
 	if err != nil {
 		respondErrorJSON(w, http.StatusInternalServerError, err.Error())
 		return
@@ -59,6 +64,9 @@ func (h *AuthHandler) VerifyCode(w http.ResponseWriter, r *http.Request) {
 
 	token, expiresAt, err := h.authService.VerifyCode(r.Context(), req.Email, req.Code)
 	if err != nil {
+		if respondRateLimitError(w, err) {
+			return
+		}
 		respondErrorJSON(w, http.StatusUnauthorized, err.Error())
 		return
 	}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"net"
 	"net/http"
 	"strconv"
 
@@ -45,15 +44,8 @@ func (h *BiometricAuthHandler) CreateRequest(w http.ResponseWriter, r *http.Requ
 	// Get client IP if not provided
 	ipAddress := req.IPAddress
 	if ipAddress == "" {
-		// Strip port from RemoteAddr (e.g., "10.100.0.4:58852" -> "10.100.0.4")
-		// PostgreSQL INET type requires IP address only, not IP:PORT
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			// If no port in RemoteAddr, use as-is
-			ipAddress = r.RemoteAddr
-		} else {
-			ipAddress = host
-		}
+		// IP only (no port), PostgreSQL INET type requires IP address only
+		ipAddress = getClientIP(r)
 	}
 
 	// Create auth request
@@ -1,9 +1,16 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/kamikazebr/roamie-desktop/internal/server/services"
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
@@ -16,7 +23,7 @@ import (
 type DeviceAuthHandler struct {
 	deviceAuthService *services.DeviceAuthService
 	authService       *services.AuthService
-	firebaseService   *services.FirebaseService
+	identityProviders map[string]services.IdentityProvider
 	deviceService     *services.DeviceService
 	wgManager         *wireguard.Manager
 	userRepo          *storage.UserRepository
@@ -32,15 +39,24 @@ func NewDeviceAuthHandler(
 	userRepo *storage.UserRepository,
 	deviceRepo *storage.DeviceRepository,
 ) *DeviceAuthHandler {
-	return &DeviceAuthHandler{
+	h := &DeviceAuthHandler{
 		deviceAuthService: deviceAuthService,
 		authService:       authService,
-		firebaseService:   firebaseService,
+		identityProviders: make(map[string]services.IdentityProvider),
 		deviceService:     deviceService,
 		wgManager:         wgManager,
 		userRepo:          userRepo,
 		deviceRepo:        deviceRepo,
 	}
+	if firebaseService != nil {
+		h.AddIdentityProvider(firebaseService)
+	}
+	return h
+}
+
+// AddIdentityProvider lets users log in through provider (POST /api/auth/login)
+func (h *DeviceAuthHandler) AddIdentityProvider(provider services.IdentityProvider) {
+	h.identityProviders[provider.Name()] = provider
 }
 
 // CreateDeviceRequest handles POST /api/auth/device-request (public, no auth required)
@@ -53,6 +69,8 @@ func (h *DeviceAuthHandler) CreateDeviceRequest(w http.ResponseWriter, r *http.R
 		PublicKey  *string `json:"public_key,omitempty"`  // Optional: for auto-registration
 		OSType     *string `json:"os_type,omitempty"`     // Optional: OS type (linux, macos, windows, etc.)
 		HardwareID *string `json:"hardware_id,omitempty"` // Optional: 8-char hardware identifier
+		EnrollKey  string  `json:"enroll_key,omitempty"`  // Optional: approves the request without user interaction
+		Email      string  `json:"email,omitempty"`       // Optional: user whose network the device wants to join
 	}
 
 	if err := decodeJSON(r, &req); err != nil {
@@ -75,20 +93,73 @@ func (h *DeviceAuthHandler) CreateDeviceRequest(w http.ResponseWriter, r *http.R
 	ipAddress := getClientIP(r)
 
 	// Create challenge
-	challenge, err := h.deviceAuthService.CreateChallenge(r.Context(), deviceID, req.Hostname, ipAddress, req.Username, req.PublicKey, req.OSType, req.HardwareID)
+	challenge, err := h.deviceAuthService.CreateChallenge(r.Context(), deviceID, req.Hostname, ipAddress, req.Username, req.PublicKey, req.OSType, req.HardwareID, req.Email)
 	if err != nil {
+		if errors.Is(err, services.ErrTooManyPendingChallenges) {
+			respondErrorJSON(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
 		respondErrorJSON(w, http.StatusInternalServerError, "failed to create challenge")
 		return
 	}
 
+	// Enrollment keys approve the challenge right away; the client picks up
+	// its tokens with the usual poll
+	if req.EnrollKey != "" {
+		if _, err := h.deviceAuthService.EnrollChallenge(r.Context(), challenge.ID, req.EnrollKey, h.wgManager, h.deviceRepo); err != nil {
+			switch {
+			case errors.Is(err, services.ErrInvalidEnrollmentKey):
+				respondErrorJSON(w, http.StatusUnauthorized, err.Error())
+			case errors.Is(err, services.ErrEnrollmentKeysDisabled):
+				respondErrorJSON(w, http.StatusServiceUnavailable, err.Error())
+			default:
+				respondErrorJSON(w, http.StatusInternalServerError, "failed to enroll device")
+			}
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"challenge_id": challenge.ID.String(),
+			"expires_in":   300,
+			"enrolled":     true,
+		})
+		return
+	}
+
+	// Requests from addresses the approval policy trusts skip interactive approval
+	autoApproved, err := h.deviceAuthService.AutoApproveChallenge(r.Context(), challenge, h.wgManager, h.deviceRepo)
+	if err != nil {
+		log.Printf("Warning: failed to apply approval policy to challenge %s: %v", challenge.ID, err)
+	}
+	if autoApproved {
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"challenge_id":  challenge.ID.String(),
+			"expires_in":    300,
+			"auto_approved": true,
+		})
+		return
+	}
+
 	// Generate QR data - only challenge ID needed (mobile app already knows server)
 	qrData := fmt.Sprintf("roamie://auth?challenge=%s", challenge.ID)
 
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"challenge_id": challenge.ID.String(),
-		"qr_data":      qrData,
-		"expires_in":   300, // 5 minutes in seconds
-	})
+	response := map[string]interface{}{
+		"challenge_id":       challenge.ID.String(),
+		"qr_data":            qrData,
+		"expires_in":         300, // 5 minutes in seconds
+		"required_approvals": challenge.RequiredApprovals,
+	}
+
+	// RFC 8628 user code for users who can't scan the QR code
+	if challenge.UserCode != nil {
+		userCode := services.FormatUserCode(*challenge.UserCode)
+		verificationURI := requestBaseURL(r) + "/device"
+		response["user_code"] = userCode
+		response["verification_uri"] = verificationURI
+		response["verification_uri_complete"] = verificationURI + "?user_code=" + url.QueryEscape(userCode)
+	}
+
+	respondJSON(w, http.StatusOK, response)
 }
 
 // PollChallenge handles GET /api/auth/device-poll/{challenge_id} (public, no auth required)
@@ -116,26 +187,37 @@ func (h *DeviceAuthHandler) PollChallenge(w http.ResponseWriter, r *http.Request
 			return
 		}
 
-		// Generate JWT (30 days)
-		jwt, expiresAt, err := h.authService.GenerateToken(user.ID, user.Email)
+		deviceIDForToken := resolveRefreshTokenDeviceID(challenge)
+
+		// Short-lived access token, bound to the device when it was registered
+		boundDeviceID, revoked, err := tokenDeviceID(r.Context(), h.deviceRepo, deviceIDForToken, challenge.WgDeviceID != nil)
 		if err != nil {
-			respondErrorJSON(w, http.StatusInternalServerError, "failed to generate JWT")
+			respondErrorJSON(w, http.StatusInternalServerError, "failed to check device")
+			return
+		}
+		if revoked {
+			respondErrorJSON(w, http.StatusForbidden, "device revoked")
 			return
 		}
 
-		deviceIDForToken := resolveRefreshTokenDeviceID(challenge)
-
-		// Generate refresh token bound to the persisted device record
-		refreshToken, err := h.deviceAuthService.GenerateRefreshToken(r.Context(), user.ID, deviceIDForToken)
+		// Generate refresh token bound to the persisted device record; it
+		// starts the session the access token belongs to
+		refreshToken, err := h.deviceAuthService.GenerateRefreshToken(r.Context(), user.ID, deviceIDForToken, boundDeviceID != uuid.Nil, getClientIP(r))
 		if err != nil {
 			respondErrorJSON(w, http.StatusInternalServerError, "failed to generate refresh token")
 			return
 		}
 
+		jwt, expiresAt, err := h.authService.GenerateDeviceToken(user.ID, boundDeviceID, refreshToken.FamilyID, user.Email)
+		if err != nil {
+			respondErrorJSON(w, http.StatusInternalServerError, "failed to generate JWT")
+			return
+		}
+
 		response := map[string]interface{}{
 			"status":        "approved",
 			"jwt":           jwt,
-			"refresh_token": refreshToken,
+			"refresh_token": refreshToken.Token,
 			"expires_at":    expiresAt.Format("2006-01-02T15:04:05Z"),
 		}
 
@@ -165,6 +247,17 @@ func (h *DeviceAuthHandler) PollChallenge(w http.ResponseWriter, r *http.Request
 			}
 		}
 
+		// Tell devices enrolled with a key what the key set up for them
+		if challenge.EnrollmentKeyID != nil {
+			if key, err := h.deviceAuthService.GetEnrollmentKey(r.Context(), *challenge.EnrollmentKeyID); err == nil {
+				response["enrollment"] = map[string]interface{}{
+					"tags":      key.Tags,
+					"tunnel":    key.Tunnel,
+					"ephemeral": key.Ephemeral,
+				}
+			}
+		}
+
 		respondJSON(w, http.StatusOK, response)
 
 	case "denied":
@@ -225,34 +318,49 @@ func (h *DeviceAuthHandler) ApproveDevice(w http.ResponseWriter, r *http.Request
 	}
 
 	// Approve or deny the challenge
-	if err := h.deviceAuthService.ApproveChallenge(r.Context(), challengeID, claims.UserID, req.Approved, h.wgManager, h.deviceRepo); err != nil {
-		errMsg := err.Error()
-
-		// Return proper HTTP status codes based on error type
-		if errMsg == "challenge not found" {
-			respondErrorJSON(w, http.StatusNotFound, errMsg)
-			return
-		}
-		if errMsg == "challenge has expired" {
-			respondErrorJSON(w, http.StatusGone, errMsg)
-			return
-		}
-		if errMsg == "challenge already processed with different decision" {
-			respondErrorJSON(w, http.StatusConflict, errMsg)
-			return
-		}
-
-		// Generic internal server error for unexpected errors
-		respondErrorJSON(w, http.StatusInternalServerError, "failed to process device authorization")
+	result, err := h.deviceAuthService.ReviewChallenge(r.Context(), challengeID, services.ChallengeReview{
+		ReviewerID: claims.UserID,
+		Approved:   req.Approved,
+	}, h.wgManager, h.deviceRepo)
+	if err != nil {
+		respondReviewError(w, err)
 		return
 	}
 
+	message := "Device authorization recorded"
+	if result.Status == "pending" {
+		message = fmt.Sprintf("Approval recorded, waiting for %d more approver(s)", result.RequiredApprovals-result.Approvals)
+	}
+
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"message": "Device authorization recorded",
-		"status":  map[bool]string{true: "approved", false: "denied"}[req.Approved],
+		"message":            message,
+		"status":             result.Status,
+		"approvals":          result.Approvals,
+		"required_approvals": result.RequiredApprovals,
 	})
 }
 
+// respondReviewError maps ReviewChallenge errors to HTTP status codes
+func respondReviewError(w http.ResponseWriter, err error) {
+	errMsg := err.Error()
+
+	switch {
+	case errMsg == "challenge not found":
+		respondErrorJSON(w, http.StatusNotFound, errMsg)
+	case errMsg == "challenge has expired":
+		respondErrorJSON(w, http.StatusGone, errMsg)
+	case errMsg == "challenge already processed with different decision":
+		respondErrorJSON(w, http.StatusConflict, errMsg)
+	case errors.Is(err, services.ErrChallengeForAnotherUser):
+		respondErrorJSON(w, http.StatusForbidden, errMsg)
+	case errors.Is(err, services.ErrChallengeOwnerRequired):
+		respondErrorJSON(w, http.StatusBadRequest, errMsg)
+	default:
+		// Generic internal server error for unexpected errors
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to process device authorization")
+	}
+}
+
 // RefreshJWT handles POST /api/auth/refresh (public, uses refresh token)
 // Called from roamie daemon to refresh JWT
 func (h *DeviceAuthHandler) RefreshJWT(w http.ResponseWriter, r *http.Request) {
@@ -270,12 +378,37 @@ func (h *DeviceAuthHandler) RefreshJWT(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate refresh token
-	token, err := h.deviceAuthService.ValidateRefreshToken(r.Context(), req.RefreshToken)
+	// Exchange the refresh token; every refresh rotates it
+	token, err := h.deviceAuthService.RotateRefreshToken(r.Context(), req.RefreshToken, getClientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRefreshTokenRotated):
+			respondErrorJSON(w, http.StatusConflict, "refresh token already rotated")
+		case errors.Is(err, services.ErrRefreshTokenReused), errors.Is(err, services.ErrInvalidRefreshToken):
+			respondErrorJSON(w, http.StatusUnauthorized, "invalid or expired refresh token")
+		default:
+			respondErrorJSON(w, http.StatusInternalServerError, "failed to refresh token")
+		}
+		return
+	}
+
+	boundDeviceID, revoked, err := tokenDeviceID(r.Context(), h.deviceRepo, token.DeviceID, token.DeviceBound)
 	if err != nil {
-		respondErrorJSON(w, http.StatusUnauthorized, "invalid or expired refresh token")
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to check device")
 		return
 	}
+	if revoked {
+		h.deviceAuthService.RevokeRefreshTokenFamily(r.Context(), token.FamilyID)
+		respondErrorJSON(w, http.StatusUnauthorized, "device revoked")
+		return
+	}
+	if boundDeviceID != uuid.Nil && !token.DeviceBound {
+		// The client registered its device since logging in
+		if err := h.deviceAuthService.BindRefreshTokenFamily(r.Context(), token.FamilyID); err != nil {
+			respondErrorJSON(w, http.StatusInternalServerError, "failed to refresh token")
+			return
+		}
+	}
 
 	// Get user info
 	user, err := h.authService.GetUserByID(r.Context(), token.UserID)
@@ -284,24 +417,52 @@ func (h *DeviceAuthHandler) RefreshJWT(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate new JWT (30 days)
-	jwt, expiresAt, err := h.authService.GenerateToken(user.ID, user.Email)
+	jwt, expiresAt, err := h.authService.GenerateDeviceToken(user.ID, boundDeviceID, token.FamilyID, user.Email)
 	if err != nil {
 		respondErrorJSON(w, http.StatusInternalServerError, "failed to generate JWT")
 		return
 	}
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"jwt":        jwt,
-		"expires_at": expiresAt.Format("2006-01-02T15:04:05Z"),
+		"jwt":           jwt,
+		"refresh_token": token.Token,
+		"expires_at":    expiresAt.Format("2006-01-02T15:04:05Z"),
 	})
 }
 
-// Login handles POST /api/auth/login (public, Firebase token exchange)
-// Called from Flutter app after Firebase authentication
+// tokenDeviceID returns the device an access token should be bound to.
+// Clients that never registered a WireGuard device (tunnel-only) get a token
+// bound to no device (uuid.Nil). A session that was issued for a registered
+// device (bound) is revoked once that device is deactivated or deleted.
+func tokenDeviceID(ctx context.Context, devices DeviceStatusChecker, deviceID uuid.UUID, bound bool) (boundID uuid.UUID, revoked bool, err error) {
+	if deviceID == uuid.Nil {
+		return uuid.Nil, false, nil
+	}
+
+	exists, active, err := devices.GetStatus(ctx, deviceID)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	if !exists {
+		return uuid.Nil, bound, nil
+	}
+	if !active {
+		return uuid.Nil, true, nil
+	}
+	return deviceID, false, nil
+}
+
+// Login handles POST /api/auth/login (public, identity provider login)
+// Called from the Flutter app after Firebase authentication, and from roamie
+// with an OIDC ID token or a local account password
 func (h *DeviceAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		FirebaseToken string `json:"firebase_token"`
+		Provider      string `json:"provider,omitempty"` // firebase (default), oidc or local
+		FirebaseToken string `json:"firebase_token,omitempty"`
+		IDToken       string `json:"id_token,omitempty"`
+		Nonce         string `json:"nonce,omitempty"`
+		Email         string `json:"email,omitempty"`
+		Password      string `json:"password,omitempty"`
 		DeviceInfo    *struct {
 			DeviceName string `json:"device_name"`
 			PublicKey  string `json:"public_key"`
@@ -313,37 +474,71 @@ func (h *DeviceAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.FirebaseToken == "" {
-		respondErrorJSON(w, http.StatusBadRequest, "firebase_token is required")
-		return
+	creds := services.Credentials{
+		IDToken:  req.IDToken,
+		Nonce:    req.Nonce,
+		Email:    req.Email,
+		Password: req.Password,
 	}
 
-	// Check if Firebase is configured
-	if h.firebaseService == nil {
-		respondErrorJSON(w, http.StatusServiceUnavailable, "Firebase authentication is not configured")
-		return
+	providerName := req.Provider
+	if providerName == "" {
+		providerName = "firebase"
 	}
 
-	// Verify Firebase ID token
-	token, err := h.firebaseService.VerifyIDToken(r.Context(), req.FirebaseToken)
-	if err != nil {
-		respondErrorJSON(w, http.StatusUnauthorized, "invalid Firebase token")
+	switch providerName {
+	case "firebase":
+		if creds.IDToken == "" {
+			creds.IDToken = req.FirebaseToken
+		}
+		if creds.IDToken == "" {
+			respondErrorJSON(w, http.StatusBadRequest, "firebase_token is required")
+			return
+		}
+	case "oidc":
+		if creds.IDToken == "" {
+			respondErrorJSON(w, http.StatusBadRequest, "id_token is required")
+			return
+		}
+	case "local":
+		if creds.Email == "" || creds.Password == "" {
+			respondErrorJSON(w, http.StatusBadRequest, "email and password are required")
+			return
+		}
+	default:
+		respondErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("unknown provider %q", providerName))
 		return
 	}
 
-	// Get email from Firebase token
-	email := ""
-	if emailClaim, ok := token.Claims["email"].(string); ok {
-		email = emailClaim
+	provider, ok := h.identityProviders[providerName]
+	if !ok {
+		if providerName == "firebase" {
+			respondErrorJSON(w, http.StatusServiceUnavailable, "Firebase authentication is not configured")
+			return
+		}
+		respondErrorJSON(w, http.StatusServiceUnavailable, fmt.Sprintf("%s login is not configured", providerName))
+		return
 	}
-	if email == "" {
-		respondErrorJSON(w, http.StatusBadRequest, "email not found in Firebase token")
+
+	identity, err := provider.Authenticate(r.Context(), creds)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidCredentials) && providerName == "firebase":
+			respondErrorJSON(w, http.StatusUnauthorized, "invalid Firebase token")
+		case errors.Is(err, services.ErrInvalidCredentials):
+			respondErrorJSON(w, http.StatusUnauthorized, "invalid credentials")
+		default:
+			respondErrorJSON(w, http.StatusBadRequest, err.Error())
+		}
 		return
 	}
 
-	// Get or create user by Firebase UID
-	user, err := h.authService.GetOrCreateUserByFirebaseUID(r.Context(), token.UID, email)
+	user, err := h.authService.GetOrCreateUserByIdentity(r.Context(), identity)
 	if err != nil {
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			respondErrorJSON(w, http.StatusForbidden, err.Error())
+			return
+		}
 		respondErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to get or create user: %v", err))
 		return
 	}
@@ -401,6 +596,31 @@ func (h *DeviceAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, response)
 }
 
+// Providers handles GET /api/auth/providers (public)
+// Lists the login methods this server accepts so clients can pick one
+func (h *DeviceAuthHandler) Providers(w http.ResponseWriter, r *http.Request) {
+	response := models.AuthProvidersResponse{
+		Providers:  []models.AuthProviderInfo{},
+		EmailCodes: h.authService.EmailLoginEnabled(),
+	}
+
+	for _, name := range []string{"firebase", "oidc", "local"} {
+		provider, ok := h.identityProviders[name]
+		if !ok {
+			continue
+		}
+		info := models.AuthProviderInfo{Name: name}
+		if oidc, ok := provider.(*services.OIDCProvider); ok {
+			info.Issuer = oidc.Issuer()
+			info.ClientID = oidc.ClientID()
+			info.Scopes = oidc.Scopes()
+		}
+		response.Providers = append(response.Providers, info)
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
 func resolveRefreshTokenDeviceID(challenge *models.DeviceAuthChallenge) uuid.UUID {
 	if challenge == nil {
 		return uuid.Nil
@@ -413,22 +633,105 @@ func resolveRefreshTokenDeviceID(challenge *models.DeviceAuthChallenge) uuid.UUI
 
 // Helper function to extract client IP address
 func getClientIP(r *http.Request) string {
-	// Try X-Forwarded-For header first (for proxies)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// If no port in RemoteAddr, use as-is
+		host = r.RemoteAddr
+	}
+
+	// Forwarding headers are only believed from trusted proxies; anyone else
+	// could claim any address
+	trustedProxiesOnce.Do(loadTrustedProxies)
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	// X-Forwarded-For lists every hop; the client is the last address that
+	// wasn't added by one of our proxies
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			if i == 0 || !isTrustedProxy(hop) {
+				return hop
+			}
+		}
 	}
 
-	// Try X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
 		return xri
 	}
 
-	// Fall back to RemoteAddr, stripping port
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	return host
+}
+
+// requestBaseURL returns the scheme and host clients used to reach the server,
+// as seen through trusted proxies
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		// If no port in RemoteAddr, use as-is
-		return r.RemoteAddr
+		remote = r.RemoteAddr
+	}
+	trustedProxiesOnce.Do(loadTrustedProxies)
+	if isTrustedProxy(remote) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" && !strings.ContainsAny(forwardedHost, "/ ") {
+			host = forwardedHost
+		}
 	}
 
-	return host
+	return scheme + "://" + host
+}
+
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxies     []*net.IPNet
+)
+
+// loadTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of CIDRs
+// whose X-Forwarded-For and X-Real-IP headers are believed (default loopback)
+func loadTrustedProxies() {
+	raw := os.Getenv("TRUSTED_PROXIES")
+	if raw == "" {
+		raw = "127.0.0.0/8,::1/128"
+	}
+
+	trustedProxies = nil
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			trustedProxies = append(trustedProxies, network)
+		}
+	}
+}
+
+func isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,6 +1,12 @@
 package api
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
@@ -40,3 +46,164 @@ func TestResolveRefreshTokenDeviceID_NilChallenge(t *testing.T) {
 		t.Fatalf("expected uuid.Nil, got %s", got)
 	}
 }
+
+func TestTokenDeviceID(t *testing.T) {
+	active, deactivated, deleted := uuid.New(), uuid.New(), uuid.New()
+	devices := fakeDeviceStatus{active: true, deactivated: false}
+
+	tests := []struct {
+		name        string
+		deviceID    uuid.UUID
+		bound       bool
+		wantBound   uuid.UUID
+		wantRevoked bool
+	}{
+		{"registered device", active, true, active, false},
+		{"device registered after login", active, false, active, false},
+		{"deactivated device", deactivated, true, uuid.Nil, true},
+		{"deleted device", deleted, true, uuid.Nil, true},
+		{"tunnel-only client", deleted, false, uuid.Nil, false},
+		{"no device", uuid.Nil, false, uuid.Nil, false},
+	}
+	for _, tt := range tests {
+		boundID, revoked, err := tokenDeviceID(context.Background(), devices, tt.deviceID, tt.bound)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if boundID != tt.wantBound || revoked != tt.wantRevoked {
+			t.Errorf("%s: got (%s, %v), want (%s, %v)", tt.name, boundID, revoked, tt.wantBound, tt.wantRevoked)
+		}
+	}
+}
+
+func TestGetClientIP_OnlyTrustsForwardingHeadersFromProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	trustedProxiesOnce = sync.Once{}
+	defer func() { trustedProxiesOnce = sync.Once{} }()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:4000", "", "", "203.0.113.5"},
+		{"spoofed header from client", "203.0.113.5:4000", "198.51.100.1", "198.51.100.1", "203.0.113.5"},
+		{"through proxy", "10.0.0.2:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"client-supplied hops are skipped", "10.0.0.2:4000", "192.0.2.9, 198.51.100.1, 10.0.0.3", "", "198.51.100.1"},
+		{"X-Real-IP from proxy", "10.0.0.2:4000", "", "198.51.100.1", "198.51.100.1"},
+		{"garbage header", "10.0.0.2:4000", "unknown", "", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := getClientIP(req); got != tt.want {
+				t.Errorf("getClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestBaseURL(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	trustedProxiesOnce = sync.Once{}
+	defer func() { trustedProxiesOnce = sync.Once{} }()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		host       string
+		want       string
+	}{
+		{"direct", "203.0.113.5:4000", "", "", "http://vpn.example.com"},
+		{"spoofed headers from client", "203.0.113.5:4000", "https", "evil.example.com", "http://vpn.example.com"},
+		{"through proxy", "10.0.0.2:4000", "https", "roamie.example.com", "https://roamie.example.com"},
+		{"bad host from proxy", "10.0.0.2:4000", "https", "evil.example.com/path", "https://vpn.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://vpn.example.com/api/auth/device-request", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.host != "" {
+				req.Header.Set("X-Forwarded-Host", tt.host)
+			}
+
+			if got := requestBaseURL(req); got != tt.want {
+				t.Errorf("requestBaseURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerificationPage_EscapesUserCode(t *testing.T) {
+	h := &DeviceAuthHandler{}
+	req := httptest.NewRequest("GET", `/device?user_code="><script>alert(1)</script>`, nil)
+	rec := httptest.NewRecorder()
+
+	h.VerificationPage(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("X-Frame-Options") != "DENY" {
+		t.Error("Verification page must not be framed")
+	}
+	if strings.Contains(rec.Body.String(), "<script>") {
+		t.Error("User code was not escaped")
+	}
+}
+
+func TestVerificationPage_CSRFToken(t *testing.T) {
+	h := &DeviceAuthHandler{}
+	rec := httptest.NewRecorder()
+	h.VerificationPage(rec, httptest.NewRequest("GET", "/device", nil))
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != verificationCSRFCookie || cookies[0].SameSite != http.SameSiteStrictMode || !cookies[0].HttpOnly {
+		t.Fatalf("Expected a strict, HTTP-only CSRF cookie, got %+v", cookies)
+	}
+	token := cookies[0].Value
+	if !strings.Contains(rec.Body.String(), `name="csrf_token" value="`+token+`"`) {
+		t.Error("Form doesn't carry the CSRF token")
+	}
+
+	submit := func(cookie, field string) bool {
+		req := httptest.NewRequest("POST", "/device", strings.NewReader(url.Values{"csrf_token": {field}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: verificationCSRFCookie, Value: cookie})
+		}
+		req.ParseForm()
+		return validVerificationCSRF(req)
+	}
+	if !submit(token, token) {
+		t.Error("Form with the browser's token should be accepted")
+	}
+	if submit("", token) || submit(token, "") || submit(token, "forged") {
+		t.Error("Form without the browser's token should be rejected")
+	}
+
+	// The token is kept while the cookie lives
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/device", nil)
+	req.AddCookie(&http.Cookie{Name: verificationCSRFCookie, Value: token})
+	h.VerificationPage(rec, req)
+	if len(rec.Result().Cookies()) != 0 || !strings.Contains(rec.Body.String(), token) {
+		t.Error("Existing CSRF token should be reused")
+	}
+}
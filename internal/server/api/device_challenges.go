@@ -0,0 +1,213 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/services"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ListChallenges lists pending device requests that asked to join the user's network
+// GET /api/device-auth/challenges
+func (h *DeviceAuthHandler) ListChallenges(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	challenges, err := h.deviceAuthService.ListChallengesForUser(r.Context(), claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to list device requests")
+		return
+	}
+
+	h.respondChallenges(w, r, challenges)
+}
+
+// ApproveChallenge approves a pending device request for the user's network
+// POST /api/device-auth/challenges/{challenge_id}/approve
+func (h *DeviceAuthHandler) ApproveChallenge(w http.ResponseWriter, r *http.Request) {
+	h.reviewChallenge(w, r, true, false)
+}
+
+// DenyChallenge denies a pending device request for the user's network
+// POST /api/device-auth/challenges/{challenge_id}/deny
+func (h *DeviceAuthHandler) DenyChallenge(w http.ResponseWriter, r *http.Request) {
+	h.reviewChallenge(w, r, false, false)
+}
+
+// LookupChallenge finds a pending device request by the user code the device
+// displays, so it can be approved like any other request
+// POST /api/device-auth/challenges/lookup
+func (h *DeviceAuthHandler) LookupChallenge(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req models.LookupDeviceChallengeRequest
+	if err := decodeJSON(r, &req); err != nil || req.UserCode == "" {
+		respondErrorJSON(w, http.StatusBadRequest, "user_code is required")
+		return
+	}
+
+	challenge, err := h.deviceAuthService.FindChallengeByUserCode(r.Context(), "user:"+claims.UserID.String(), req.UserCode)
+	if err != nil {
+		if respondRateLimitError(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrInvalidUserCode):
+			respondErrorJSON(w, http.StatusNotFound, err.Error())
+		default:
+			respondErrorJSON(w, http.StatusInternalServerError, "failed to look up device request")
+		}
+		return
+	}
+
+	if challenge.RequestedUserID != nil && *challenge.RequestedUserID != claims.UserID {
+		respondErrorJSON(w, http.StatusForbidden, services.ErrChallengeForAnotherUser.Error())
+		return
+	}
+
+	h.respondChallenges(w, r, []*models.DeviceAuthChallenge{challenge})
+}
+
+// AdminListChallenges lists every pending device request
+// GET /api/admin/device-challenges
+func (h *DeviceAuthHandler) AdminListChallenges(w http.ResponseWriter, r *http.Request) {
+	challenges, err := h.deviceAuthService.ListPendingChallenges(r.Context())
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to list device requests")
+		return
+	}
+
+	h.respondChallenges(w, r, challenges)
+}
+
+// AdminApproveChallenge approves a device request on behalf of the user it
+// joins. Requests that didn't name a user need {"email": "..."}.
+// POST /api/admin/device-challenges/{challenge_id}/approve
+func (h *DeviceAuthHandler) AdminApproveChallenge(w http.ResponseWriter, r *http.Request) {
+	h.reviewChallenge(w, r, true, true)
+}
+
+// AdminDenyChallenge denies a device request
+// POST /api/admin/device-challenges/{challenge_id}/deny
+func (h *DeviceAuthHandler) AdminDenyChallenge(w http.ResponseWriter, r *http.Request) {
+	h.reviewChallenge(w, r, false, true)
+}
+
+func (h *DeviceAuthHandler) reviewChallenge(w http.ResponseWriter, r *http.Request, approved, admin bool) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	challengeID, err := uuid.Parse(chi.URLParam(r, "challenge_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid challenge_id format")
+		return
+	}
+
+	review := services.ChallengeReview{
+		ReviewerID: claims.UserID,
+		Approved:   approved,
+		Admin:      admin,
+	}
+
+	// The body is optional; admins may use it to pick the user the device joins
+	var req models.ReviewDeviceChallengeRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if admin && req.Email != "" {
+		owner, err := h.userRepo.GetByEmail(r.Context(), strings.ToLower(strings.TrimSpace(req.Email)))
+		if err != nil {
+			respondErrorJSON(w, http.StatusInternalServerError, "failed to get user")
+			return
+		}
+		if owner == nil {
+			respondErrorJSON(w, http.StatusNotFound, "user not found")
+			return
+		}
+		review.OwnerID = &owner.ID
+	}
+
+	result, err := h.deviceAuthService.ReviewChallenge(r.Context(), challengeID, review, h.wgManager, h.deviceRepo)
+	if err != nil {
+		respondReviewError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.ReviewDeviceChallengeResponse{
+		Status:            result.Status,
+		Approvals:         result.Approvals,
+		RequiredApprovals: result.RequiredApprovals,
+	})
+}
+
+func (h *DeviceAuthHandler) respondChallenges(w http.ResponseWriter, r *http.Request, challenges []*models.DeviceAuthChallenge) {
+	emails := make(map[uuid.UUID]string)
+	infos := make([]models.DeviceChallengeInfo, 0, len(challenges))
+	for _, challenge := range challenges {
+		info := deviceChallengeInfo(challenge)
+
+		if challenge.RequestedUserID != nil {
+			email, ok := emails[*challenge.RequestedUserID]
+			if !ok {
+				if user, err := h.userRepo.GetByID(r.Context(), *challenge.RequestedUserID); err == nil && user != nil {
+					email = user.Email
+				}
+				emails[*challenge.RequestedUserID] = email
+			}
+			info.RequestedUser = email
+		}
+
+		if challenge.RequiredApprovals > 1 {
+			if approvers, err := h.deviceAuthService.ListChallengeApprovers(r.Context(), challenge.ID); err == nil && approvers != nil {
+				info.ApprovedBy = approvers
+				info.Approvals = len(approvers)
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	respondJSON(w, http.StatusOK, models.ListDeviceChallengesResponse{Challenges: infos})
+}
+
+func deviceChallengeInfo(challenge *models.DeviceAuthChallenge) models.DeviceChallengeInfo {
+	info := models.DeviceChallengeInfo{
+		ID:                challenge.ID.String(),
+		DeviceID:          challenge.DeviceID.String(),
+		Hostname:          challenge.Hostname,
+		IPAddress:         challenge.IPAddress,
+		RequiredApprovals: challenge.RequiredApprovals,
+		ApprovedBy:        []string{},
+		CreatedAt:         challenge.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		ExpiresAt:         challenge.ExpiresAt.Format("2006-01-02T15:04:05Z"),
+	}
+	if challenge.OSType != nil {
+		info.OSType = *challenge.OSType
+	}
+	if challenge.Username != nil {
+		info.Username = *challenge.Username
+	}
+	if challenge.HardwareID != nil {
+		info.HardwareID = *challenge.HardwareID
+	}
+	if challenge.UserCode != nil {
+		info.UserCode = services.FormatUserCode(*challenge.UserCode)
+	}
+	return info
+}
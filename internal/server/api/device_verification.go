@@ -0,0 +1,313 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/ratelimit"
+	"github.com/kamikazebr/roamie-desktop/internal/server/services"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+)
+
+// The page's forms carry a CSRF token that must match this cookie, so other
+// sites can't submit them in the user's browser
+const (
+	verificationCSRFCookie = "roamie_device_csrf"
+	verificationCSRFMaxAge = 15 * time.Minute
+)
+
+// verificationPage is the data rendered by verificationTemplate
+type verificationPage struct {
+	UserCode  string
+	Error     string
+	CSRFToken string
+	Device    *models.DeviceChallengeInfo
+	Password  bool // Local accounts are enabled, so the page can sign users in
+	EmailCode bool // Login codes can be sent by email, so the page can sign users in
+	Email     string
+	CodeSent  bool // A login code was sent to Email
+	Done      bool
+	Approved  bool
+	Pending   bool // Approval recorded, more approvers needed
+	Approvals int
+	Required  int
+}
+
+var verificationTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Roamie - Connect a device</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 28rem; margin: 3rem auto; padding: 0 1rem; color: #222; }
+input { display: block; width: 100%; box-sizing: border-box; margin: .25rem 0 1rem; padding: .5rem; font-size: 1rem; }
+input[name=user_code] { font-family: monospace; font-size: 1.5rem; letter-spacing: .2rem; text-transform: uppercase; }
+button { padding: .5rem 1rem; font-size: 1rem; margin-right: .5rem; }
+dl { display: grid; grid-template-columns: max-content auto; gap: .25rem 1rem; }
+dt { color: #666; }
+.error { color: #b00020; }
+code { background: #eee; padding: .1rem .3rem; }
+</style>
+</head>
+<body>
+<h1>Connect a device</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Done}}
+  {{if .Pending}}
+  <p>Your approval was recorded ({{.Approvals}}/{{.Required}}). The device joins your network once an administrator approves it too.</p>
+  {{else if .Approved}}
+  <p>{{.Device.Hostname}} was approved and is joining your network. You can close this page.</p>
+  {{else}}
+  <p>{{.Device.Hostname}} was denied.</p>
+  {{end}}
+{{else if .Device}}
+  <p>Only continue if you started this login yourself.</p>
+  <dl>
+    <dt>Device</dt><dd>{{.Device.Hostname}}</dd>
+    {{if .Device.OSType}}<dt>System</dt><dd>{{.Device.OSType}}</dd>{{end}}
+    {{if .Device.Username}}<dt>User</dt><dd>{{.Device.Username}}</dd>{{end}}
+    <dt>From</dt><dd>{{.Device.IPAddress}}</dd>
+    <dt>Requested</dt><dd>{{.Device.CreatedAt}}</dd>
+  </dl>
+  {{if .CodeSent}}
+  <p>We sent a login code to {{.Email}}.</p>
+  <form method="post" action="/device" autocomplete="off">
+    <input type="hidden" name="user_code" value="{{.UserCode}}">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <input type="hidden" name="email" value="{{.Email}}">
+    <label>Login code<input name="code" inputmode="numeric" autocomplete="one-time-code" autofocus required></label>
+    <button type="submit" name="action" value="approve">Approve</button>
+    <button type="submit" name="action" value="deny">Deny</button>
+  </form>
+  {{else if or .Password .EmailCode}}
+  {{if .Password}}
+  <form method="post" action="/device" autocomplete="on">
+    <input type="hidden" name="user_code" value="{{.UserCode}}">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <label>Email<input type="email" name="email" autocomplete="username" required></label>
+    <label>Password<input type="password" name="password" autocomplete="current-password" required></label>
+    <button type="submit" name="action" value="approve">Approve</button>
+    <button type="submit" name="action" value="deny">Deny</button>
+  </form>
+  {{end}}
+  {{if .EmailCode}}
+  <form method="post" action="/device" autocomplete="on">
+    {{if .Password}}<p>Or sign in with a code sent to your email:</p>{{end}}
+    <input type="hidden" name="user_code" value="{{.UserCode}}">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <label>Email<input type="email" name="email" autocomplete="username" required></label>
+    <button type="submit" name="action" value="send_code">Email me a code</button>
+  </form>
+  {{end}}
+  {{else}}
+  <p>Approve it from the Roamie app, or on a computer that is already logged in:</p>
+  <p><code>roamie auth verify {{.UserCode}}</code></p>
+  {{end}}
+{{else}}
+  <form method="post" action="/device">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <label>Enter the code shown on your device<input name="user_code" value="{{.UserCode}}" placeholder="XXXX-XXXX" autocomplete="off" autofocus required></label>
+    <button type="submit">Continue</button>
+  </form>
+{{end}}
+</body>
+</html>
+`))
+
+// VerificationPage handles GET /device (public)
+// RFC 8628 verification page where users enter the code a device displays
+func (h *DeviceAuthHandler) VerificationPage(w http.ResponseWriter, r *http.Request) {
+	h.renderVerification(w, r, http.StatusOK, &verificationPage{UserCode: r.URL.Query().Get("user_code")})
+}
+
+// VerificationSubmit handles POST /device (public)
+// Looks up the code, then approves or denies the device once the user signs
+// in with a local password or a login code sent by email
+func (h *DeviceAuthHandler) VerificationSubmit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.renderVerification(w, r, http.StatusBadRequest, &verificationPage{Error: "Invalid form."})
+		return
+	}
+
+	page := &verificationPage{UserCode: r.PostForm.Get("user_code")}
+	attemptKey := "ip:" + getClientIP(r)
+
+	challenge, err := h.deviceAuthService.FindChallengeByUserCode(r.Context(), attemptKey, page.UserCode)
+	if err != nil {
+		var exceeded *ratelimit.ExceededError
+		switch {
+		case errors.As(err, &exceeded):
+			setRetryAfter(w, exceeded.RetryAfter)
+			page.Error = "Too many invalid codes. Try again later."
+			h.renderVerification(w, r, http.StatusTooManyRequests, page)
+		case errors.Is(err, services.ErrInvalidUserCode):
+			page.Error = "That code is invalid or has expired. Check the code on your device."
+			h.renderVerification(w, r, http.StatusNotFound, page)
+		default:
+			page.Error = "Something went wrong. Try again."
+			h.renderVerification(w, r, http.StatusInternalServerError, page)
+		}
+		return
+	}
+
+	info := deviceChallengeInfo(challenge)
+	page.Device = &info
+	page.UserCode = info.UserCode
+	provider, ok := h.identityProviders["local"]
+	page.Password = ok
+	page.EmailCode = h.authService != nil && h.authService.EmailLoginEnabled()
+
+	action := r.PostForm.Get("action")
+	if action != "approve" && action != "deny" && action != "send_code" {
+		h.renderVerification(w, r, http.StatusOK, page)
+		return
+	}
+	if !page.Password && !page.EmailCode {
+		page.Error = "Signing in on this page isn't enabled on this server."
+		h.renderVerification(w, r, http.StatusForbidden, page)
+		return
+	}
+	if !validVerificationCSRF(r) {
+		page.Error = "This page expired. Try again."
+		h.renderVerification(w, r, http.StatusForbidden, page)
+		return
+	}
+
+	email := strings.TrimSpace(r.PostForm.Get("email"))
+	if action == "send_code" {
+		if !page.EmailCode {
+			page.Error = "Login codes can't be sent by email on this server."
+			h.renderVerification(w, r, http.StatusForbidden, page)
+			return
+		}
+		if _, err := h.authService.RequestCode(r.Context(), email); err != nil {
+			log.Printf("Device verification: failed to send login code: %v", err)
+			page.Error = "A login code couldn't be sent to that email."
+			h.renderVerification(w, r, http.StatusBadRequest, page)
+			return
+		}
+		page.Email = email
+		page.CodeSent = true
+		h.renderVerification(w, r, http.StatusOK, page)
+		return
+	}
+
+	var user *models.User
+	if code := r.PostForm.Get("code"); code != "" && page.EmailCode {
+		user, err = h.authService.AuthenticateCode(r.Context(), email, code)
+		if err != nil {
+			// Wrong login codes count towards the same lockout as wrong user codes
+			h.deviceAuthService.RecordUserCodeFailure(r.Context(), attemptKey)
+			page.Email = email
+			page.CodeSent = true
+			page.Error = "Invalid or expired login code."
+			h.renderVerification(w, r, http.StatusUnauthorized, page)
+			return
+		}
+	} else {
+		if !page.Password {
+			page.Error = "Enter the login code sent to your email."
+			h.renderVerification(w, r, http.StatusBadRequest, page)
+			return
+		}
+		identity, err := provider.Authenticate(r.Context(), services.Credentials{
+			Email:    email,
+			Password: r.PostForm.Get("password"),
+		})
+		if err != nil {
+			// Wrong passwords count towards the same lockout as wrong codes
+			h.deviceAuthService.RecordUserCodeFailure(r.Context(), attemptKey)
+			page.Error = "Invalid email or password."
+			h.renderVerification(w, r, http.StatusUnauthorized, page)
+			return
+		}
+
+		user, err = h.authService.GetOrCreateUserByIdentity(r.Context(), identity)
+		if err != nil {
+			page.Error = "Something went wrong. Try again."
+			h.renderVerification(w, r, http.StatusInternalServerError, page)
+			return
+		}
+	}
+
+	result, err := h.deviceAuthService.ReviewChallenge(r.Context(), challenge.ID, services.ChallengeReview{
+		ReviewerID: user.ID,
+		Approved:   action == "approve",
+	}, h.wgManager, h.deviceRepo)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrChallengeForAnotherUser):
+			page.Error = "This device asked to join another user's network."
+			h.renderVerification(w, r, http.StatusForbidden, page)
+		default:
+			log.Printf("Device verification for challenge %s failed: %v", challenge.ID, err)
+			page.Error = "The device request could not be processed. Start the login again on your device."
+			h.renderVerification(w, r, http.StatusConflict, page)
+		}
+		return
+	}
+
+	page.Done = true
+	page.Approved = result.Status == "approved"
+	page.Pending = result.Status == "pending"
+	page.Approvals = result.Approvals
+	page.Required = result.RequiredApprovals
+	h.renderVerification(w, r, http.StatusOK, page)
+}
+
+func (h *DeviceAuthHandler) renderVerification(w http.ResponseWriter, r *http.Request, statusCode int, page *verificationPage) {
+	page.CSRFToken = verificationCSRFToken(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'")
+	w.WriteHeader(statusCode)
+
+	if err := verificationTemplate.Execute(w, page); err != nil {
+		log.Printf("Failed to render device verification page: %v", err)
+	}
+}
+
+// verificationCSRFToken returns the CSRF token of the browser, setting a new
+// one if it has none
+func verificationCSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(verificationCSRFCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate CSRF token: %v", err)
+		return ""
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     verificationCSRFCookie,
+		Value:    token,
+		Path:     "/device",
+		MaxAge:   int(verificationCSRFMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(requestBaseURL(r), "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// validVerificationCSRF reports whether a submitted form carries the
+// browser's CSRF token
+func validVerificationCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(verificationCSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf_token"))) == 1
+}
@@ -1,9 +1,11 @@
 package api
 
 import (
+	"errors"
 	"log"
 	"net/http"
 
+	"github.com/kamikazebr/roamie-desktop/internal/server/dns"
 	"github.com/kamikazebr/roamie-desktop/internal/server/services"
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
 	"github.com/kamikazebr/roamie-desktop/internal/server/wireguard"
@@ -19,6 +21,7 @@ type DeviceHandler struct {
 	wgManager           *wireguard.Manager
 	deviceCache         *services.DeviceCache
 	diagnosticsService  *services.DiagnosticsService
+	dnsConfig           *dns.Config
 }
 
 func NewDeviceHandler(
@@ -39,6 +42,11 @@ func NewDeviceHandler(
 	}
 }
 
+// SetDNS enables DNS names in device listings and generated configs
+func (h *DeviceHandler) SetDNS(cfg *dns.Config) {
+	h.dnsConfig = cfg
+}
+
 func (h *DeviceHandler) RegisterDevice(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserClaims(r)
 	if claims == nil {
@@ -123,6 +131,12 @@ func (h *DeviceHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
 		Devices:    devices,
 	}
 
+	if h.dnsConfig != nil {
+		dns.AssignNames(user, devices, h.dnsConfig.Zone)
+		response.DNSServer = h.dnsConfig.ServerIP
+		response.DNSZone = h.dnsConfig.Zone
+	}
+
 	respondJSON(w, http.StatusOK, response)
 }
 
@@ -169,6 +183,46 @@ func (h *DeviceHandler) DeleteDevice(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// RenameDevice sets the display name of one of the user's devices
+// PATCH /api/devices/{device_id}
+// Body: {"display_name": "Work Laptop"} (empty clears it)
+func (h *DeviceHandler) RenameDevice(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	deviceID, err := uuid.Parse(chi.URLParam(r, "device_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid device ID")
+		return
+	}
+
+	var req models.RenameDeviceRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	device, err := h.deviceService.RenameDevice(r.Context(), deviceID, claims.UserID, req.DisplayName)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDeviceNotFound):
+			respondErrorJSON(w, http.StatusNotFound, "device not found")
+		case errors.Is(err, services.ErrInvalidDisplayName):
+			respondErrorJSON(w, http.StatusBadRequest, err.Error())
+		default:
+			log.Printf("Error: Failed to rename device %s for user %s: %v", deviceID, claims.UserID, err)
+			respondErrorJSON(w, http.StatusInternalServerError, "failed to rename device")
+		}
+		return
+	}
+
+	device.IsOnline = h.deviceCache.IsOnline(device.ID.String())
+	respondJSON(w, http.StatusOK, device)
+}
+
 func (h *DeviceHandler) GetDeviceConfig(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserClaims(r)
 	if claims == nil {
@@ -195,13 +249,23 @@ func (h *DeviceHandler) GetDeviceConfig(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Route DNS through the embedded resolver when enabled (it forwards
+	// everything outside the VPN zone upstream)
+	allowedIPs := user.Subnet
+	dnsServers := "1.1.1.1, 8.8.8.8"
+	if h.dnsConfig != nil {
+		allowedIPs += ", " + h.dnsConfig.ServerIP + "/32"
+		dnsServers = h.dnsConfig.ServerIP
+	}
+
 	// Generate config (client will fill in private key)
 	config := wireguard.GenerateClientConfig(
 		"<INSERT_YOUR_PRIVATE_KEY_HERE>",
 		device.VpnIP,
 		h.wgManager.GetPublicKey(),
 		h.wgManager.GetEndpoint(),
-		user.Subnet,
+		allowedIPs,
+		dnsServers,
 	)
 
 	w.Header().Set("Content-Type", "text/plain")
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/services"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type EnrollmentKeyHandler struct {
+	deviceAuthService *services.DeviceAuthService
+}
+
+func NewEnrollmentKeyHandler(deviceAuthService *services.DeviceAuthService) *EnrollmentKeyHandler {
+	return &EnrollmentKeyHandler{deviceAuthService: deviceAuthService}
+}
+
+// ListKeys returns the user's enrollment keys that haven't been revoked
+// GET /api/enrollment-keys
+func (h *EnrollmentKeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	keys, err := h.deviceAuthService.ListEnrollmentKeys(r.Context(), claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to list enrollment keys")
+		return
+	}
+
+	infos := make([]models.EnrollmentKeyInfo, 0, len(keys))
+	for i := range keys {
+		infos = append(infos, enrollmentKeyInfo(&keys[i]))
+	}
+
+	respondJSON(w, http.StatusOK, models.ListEnrollmentKeysResponse{Keys: infos})
+}
+
+// CreateKey creates an enrollment key; the key itself is only returned here
+// POST /api/enrollment-keys
+func (h *EnrollmentKeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req models.CreateEnrollmentKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	opts := services.EnrollmentKeyOptions{
+		Description: req.Description,
+		TTL:         time.Duration(req.ExpiresIn) * time.Second,
+		Tags:        req.Tags,
+		Tunnel:      req.Tunnel,
+		Ephemeral:   req.Ephemeral,
+	}
+	if !req.Reusable {
+		maxUses := req.MaxUses
+		if maxUses == 0 {
+			maxUses = 1
+		}
+		opts.MaxUses = &maxUses
+	}
+
+	key, secret, err := h.deviceAuthService.CreateEnrollmentKey(r.Context(), claims.UserID, opts)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidEnrollmentKeyOptions) {
+			respondErrorJSON(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to create enrollment key")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, models.CreateEnrollmentKeyResponse{
+		Key:           secret,
+		EnrollmentKey: enrollmentKeyInfo(key),
+	})
+}
+
+// RevokeKey stops a key from enrolling more devices
+// DELETE /api/enrollment-keys/{key_id}
+func (h *EnrollmentKeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	keyID, err := uuid.Parse(chi.URLParam(r, "key_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid key ID")
+		return
+	}
+
+	if err := h.deviceAuthService.RevokeEnrollmentKey(r.Context(), keyID, &claims.UserID); err != nil {
+		if errors.Is(err, services.ErrEnrollmentKeyNotFound) {
+			respondErrorJSON(w, http.StatusNotFound, "enrollment key not found")
+			return
+		}
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to revoke enrollment key")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Enrollment key revoked"})
+}
+
+func enrollmentKeyInfo(key *models.EnrollmentKey) models.EnrollmentKeyInfo {
+	info := models.EnrollmentKeyInfo{
+		ID:          key.ID.String(),
+		KeyPrefix:   key.KeyPrefix,
+		Description: key.Description,
+		MaxUses:     key.MaxUses,
+		Uses:        key.Uses,
+		Tags:        key.Tags,
+		Tunnel:      key.Tunnel,
+		Ephemeral:   key.Ephemeral,
+		Status:      key.Status(),
+		CreatedAt:   key.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		ExpiresAt:   key.ExpiresAt.Format("2006-01-02T15:04:05Z"),
+	}
+	if info.Tags == nil {
+		info.Tags = []string{}
+	}
+	if key.LastUsedAt != nil {
+		lastUsed := key.LastUsedAt.Format("2006-01-02T15:04:05Z")
+		info.LastUsedAt = &lastUsed
+	}
+	return info
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/services"
+)
+
+type JWKSHandler struct {
+	signingKeys *services.SigningKeyService
+}
+
+func NewJWKSHandler(signingKeys *services.SigningKeyService) *JWKSHandler {
+	return &JWKSHandler{signingKeys: signingKeys}
+}
+
+// JWKS handles GET /.well-known/jwks.json (public)
+// Lists the public keys that verify Roamie access tokens, including retired
+// keys whose tokens may still be valid
+func (h *JWKSHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, http.StatusOK, h.signingKeys.JWKS())
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+	"github.com/google/uuid"
 )
 
 type contextKey string
@@ -17,6 +18,33 @@ const (
 	userClaimsKey contextKey = "userClaims"
 )
 
+// TokenVerifier validates access tokens and returns their claims
+type TokenVerifier interface {
+	Verify(tokenString string) (*utils.Claims, error)
+}
+
+var tokenVerifier TokenVerifier
+
+// SetTokenVerifier makes AuthMiddleware verify tokens against the signing
+// keys. Without a verifier tokens are checked with the shared JWT_SECRET.
+func SetTokenVerifier(verifier TokenVerifier) {
+	tokenVerifier = verifier
+}
+
+// DeviceStatusChecker looks up whether the device a token was issued to still exists
+type DeviceStatusChecker interface {
+	GetStatus(ctx context.Context, id uuid.UUID) (exists, active bool, err error)
+}
+
+var deviceStatusChecker DeviceStatusChecker
+
+// SetDeviceStatusChecker enables rejecting device-bound tokens once their
+// device is deleted or deactivated. Without a checker tokens are only
+// verified cryptographically.
+func SetDeviceStatusChecker(checker DeviceStatusChecker) {
+	deviceStatusChecker = checker
+}
+
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -31,20 +59,36 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		token := parts[1]
-		jwtSecret := os.Getenv("JWT_SECRET")
-
-		claims, err := utils.ValidateJWT(token, jwtSecret)
+		claims, err := verifyToken(parts[1])
 		if err != nil {
 			respondError(w, http.StatusUnauthorized, "invalid token")
 			return
 		}
 
+		if claims.HasDevice() && deviceStatusChecker != nil {
+			exists, active, err := deviceStatusChecker.GetStatus(r.Context(), claims.DeviceID)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "failed to verify device")
+				return
+			}
+			if !exists || !active {
+				respondError(w, http.StatusUnauthorized, "device revoked")
+				return
+			}
+		}
+
 		ctx := context.WithValue(r.Context(), userClaimsKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+func verifyToken(token string) (*utils.Claims, error) {
+	if tokenVerifier != nil {
+		return tokenVerifier.Verify(token)
+	}
+	return utils.ValidateJWT(token, os.Getenv("JWT_SECRET"))
+}
+
 func GetUserClaims(r *http.Request) *utils.Claims {
 	claims, ok := r.Context().Value(userClaimsKey).(*utils.Claims)
 	if !ok {
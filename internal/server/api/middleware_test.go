@@ -4,10 +4,15 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/kamikazebr/roamie-desktop/internal/server/ratelimit"
 	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+	"github.com/google/uuid"
 )
 
 func resetAdminEmailsForTest() {
@@ -61,3 +66,123 @@ func TestAdminMiddleware_RejectsNonAdmin(t *testing.T) {
 		t.Fatalf("expected 403 status for non-admin, got %d", rec.Code)
 	}
 }
+
+type fakeDeviceStatus map[uuid.UUID]bool
+
+func (f fakeDeviceStatus) GetStatus(ctx context.Context, id uuid.UUID) (bool, bool, error) {
+	active, exists := f[id]
+	return exists, active, nil
+}
+
+func TestAuthMiddleware_DeviceTokens(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	activeDevice := uuid.New()
+	deactivatedDevice := uuid.New()
+	SetDeviceStatusChecker(fakeDeviceStatus{activeDevice: true, deactivatedDevice: false})
+	defer SetDeviceStatusChecker(nil)
+
+	tests := []struct {
+		name     string
+		deviceID uuid.UUID
+		want     int
+	}{
+		{"user token", uuid.Nil, http.StatusNoContent},
+		{"active device", activeDevice, http.StatusNoContent},
+		{"deactivated device", deactivatedDevice, http.StatusUnauthorized},
+		{"deleted device", uuid.New(), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := utils.GenerateDeviceJWT(uuid.New(), tt.deviceID, "user@example.com", "test-secret", time.Minute)
+			if err != nil {
+				t.Fatalf("failed to generate token: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/devices", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+
+			handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRateLimit_RejectsWithRetryAfter(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), "test", ratelimit.Limit{Requests: 2, Window: time.Minute})
+	handler := RateLimit(limiter, RateLimitByJSONField("email"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler still sees the whole body
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := decodeJSON(r, &req); err != nil || req.Email == "" {
+			t.Errorf("body was not preserved: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	send := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/request-code", strings.NewReader(`{"email":"`+email+`"}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := send("User@Example.com"); rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d: expected 204, got %d", i+1, rec.Code)
+		}
+	}
+
+	// Emails are compared case-insensitively
+	rec := send("user@example.com")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if seconds, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || seconds < 1 {
+		t.Errorf("expected Retry-After in seconds, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	if rec := send("other@example.com"); rec.Code != http.StatusNoContent {
+		t.Errorf("other emails should not be limited, got %d", rec.Code)
+	}
+}
+
+func TestRateLimitByFormField(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), "test", ratelimit.Limit{Requests: 1, Window: time.Minute})
+	handler := RateLimit(limiter, RateLimitByFormField("email"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler still sees the form
+		if r.ParseForm() != nil || r.PostForm.Get("user_code") == "" {
+			t.Error("form was not preserved")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	send := func(email string) int {
+		body := "user_code=ABCD-EFGH&email=" + email
+		req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("User@Example.com"); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if code := send("user@example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", code)
+	}
+	// Forms without an email (entering the user code) aren't limited by it
+	if code := send(""); code != http.StatusNoContent {
+		t.Errorf("expected 204 without an email, got %d", code)
+	}
+}
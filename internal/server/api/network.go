@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/services"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type NetworkHandler struct {
+	conflictService *services.ConflictService
+	deviceService   *services.DeviceService
+}
+
+func NewNetworkHandler(conflictService *services.ConflictService, deviceService *services.DeviceService) *NetworkHandler {
+	return &NetworkHandler{
+		conflictService: conflictService,
+		deviceService:   deviceService,
+	}
+}
+
+// ReportConflicts stores the local networks scanned by a client device
+// POST /api/network/conflicts
+// Body: {"device_id": "uuid", "networks": [{"cidr": "...", "source": "...", "description": "..."}]}
+func (h *NetworkHandler) ReportConflicts(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req models.ReportConflictsRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	deviceID, err := uuid.Parse(req.DeviceID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid device_id")
+		return
+	}
+
+	// Verify device belongs to user
+	if _, err := h.deviceService.GetDevice(r.Context(), deviceID, claims.UserID); err != nil {
+		respondErrorJSON(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	resp, err := h.conflictService.ReportConflicts(r.Context(), claims.UserID, deviceID, req.Networks)
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// ListConflicts returns the networks reported by the user's devices
+// GET /api/network/conflicts
+func (h *NetworkHandler) ListConflicts(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	conflicts, err := h.conflictService.GetUserConflicts(r.Context(), claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to get conflicts")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, conflicts)
+}
+
+// RehomeSubnet moves the caller's subnet away from their reported conflicts
+// POST /api/network/rehome
+func (h *NetworkHandler) RehomeSubnet(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	h.rehome(w, r, claims.UserID)
+}
+
+// RehomeUserSubnet is the admin variant of RehomeSubnet
+// POST /api/admin/users/{user_id}/rehome
+func (h *NetworkHandler) RehomeUserSubnet(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	h.rehome(w, r, userID)
+}
+
+func (h *NetworkHandler) rehome(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	resp, err := h.conflictService.RehomeUserSubnet(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, services.ErrNoSubnetConflict) {
+			respondErrorJSON(w, http.StatusConflict, err.Error())
+			return
+		}
+		respondErrorJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
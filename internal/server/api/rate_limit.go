@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/ratelimit"
+	"github.com/go-chi/chi/v5"
+)
+
+// RateLimitKeyFunc extracts what a request is rate limited by. Requests
+// without a key ("") are not limited.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimit rejects requests over limiter's limit with 429 and a Retry-After
+// header. Store errors let requests through rather than taking auth down.
+func RateLimit(limiter *ratelimit.Limiter, key RateLimitKeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), k)
+			if err != nil {
+				log.Printf("Warning: rate limit check failed for %s: %v", r.URL.Path, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !result.Allowed {
+				respondRateLimited(w, result.RetryAfter, "too many requests, try again later")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitByIP limits by client IP
+func RateLimitByIP(r *http.Request) string {
+	return getClientIP(r)
+}
+
+// RateLimitByURLParam limits by a chi URL parameter, e.g. a challenge ID.
+// The middleware must be added with With() on the route so the parameter is set.
+func RateLimitByURLParam(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return chi.URLParam(r, name)
+	}
+}
+
+// maxRateLimitBody caps how much of a request body RateLimitByJSONField reads
+const maxRateLimitBody = 64 << 10
+
+// RateLimitByJSONField limits by a string field of the JSON body, such as an
+// email (lowercased). The body is left intact for the handler.
+func RateLimitByJSONField(field string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		if r.Body == nil {
+			return ""
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRateLimitBody))
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return ""
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return ""
+		}
+		value, _ := fields[field].(string)
+		return strings.ToLower(strings.TrimSpace(value))
+	}
+}
+
+// RateLimitByFormField limits by a field of a form body, such as an email
+// (lowercased). The parsed form stays available to the handler.
+func RateLimitByFormField(field string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return strings.ToLower(strings.TrimSpace(r.PostFormValue(field)))
+	}
+}
+
+// respondRateLimitError responds 429 if err is a *ratelimit.ExceededError
+func respondRateLimitError(w http.ResponseWriter, err error) bool {
+	var exceeded *ratelimit.ExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+	respondRateLimited(w, exceeded.RetryAfter, exceeded.Error())
+	return true
+}
+
+func respondRateLimited(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	setRetryAfter(w, retryAfter)
+	respondErrorJSON(w, http.StatusTooManyRequests, message)
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds (at least 1)
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/services"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type RouteHandler struct {
+	routeService  *services.RouteService
+	deviceService *services.DeviceService
+}
+
+func NewRouteHandler(routeService *services.RouteService, deviceService *services.DeviceService) *RouteHandler {
+	return &RouteHandler{
+		routeService:  routeService,
+		deviceService: deviceService,
+	}
+}
+
+// AdvertiseRoutes replaces the subnet routes and exit node offer of a device
+// POST /api/devices/{device_id}/routes
+// Body: {"routes": ["192.168.1.0/24"], "exit_node": true}
+func (h *RouteHandler) AdvertiseRoutes(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	deviceID, err := uuid.Parse(chi.URLParam(r, "device_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid device ID")
+		return
+	}
+
+	// Verify device belongs to user
+	if _, err := h.deviceService.GetDevice(r.Context(), deviceID, claims.UserID); err != nil {
+		respondErrorJSON(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	var req models.AdvertiseRoutesRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	routes, err := h.routeService.Advertise(r.Context(), claims.UserID, deviceID, req.Routes, req.ExitNode)
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.ListRoutesResponse{Routes: routes})
+}
+
+// ListRoutes returns the routes advertised by the user's devices
+// GET /api/routes
+func (h *RouteHandler) ListRoutes(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	routes, err := h.routeService.ListForUser(r.Context(), claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to get routes")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.ListRoutesResponse{Routes: routes})
+}
+
+// SetExitNode selects (or clears) the exit node used by a device
+// PUT /api/devices/{device_id}/exit-node
+// Body: {"exit_node_id": "uuid"} (empty clears)
+func (h *RouteHandler) SetExitNode(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	deviceID, err := uuid.Parse(chi.URLParam(r, "device_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid device ID")
+		return
+	}
+
+	// Verify device belongs to user
+	if _, err := h.deviceService.GetDevice(r.Context(), deviceID, claims.UserID); err != nil {
+		respondErrorJSON(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	var req models.SetExitNodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var exitNodeID *uuid.UUID
+	if req.ExitNodeID != "" {
+		id, err := uuid.Parse(req.ExitNodeID)
+		if err != nil {
+			respondErrorJSON(w, http.StatusBadRequest, "invalid exit_node_id")
+			return
+		}
+		exitNodeID = &id
+	}
+
+	resp, err := h.routeService.SetExitNode(r.Context(), claims.UserID, deviceID, exitNodeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNotExitNode), errors.Is(err, services.ErrSelfExitNode):
+			respondErrorJSON(w, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrNoExitDevice):
+			respondErrorJSON(w, http.StatusNotFound, err.Error())
+		default:
+			respondErrorJSON(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// ListPendingRoutes returns advertised routes waiting for review (admin)
+// GET /api/admin/routes
+func (h *RouteHandler) ListPendingRoutes(w http.ResponseWriter, r *http.Request) {
+	routes, err := h.routeService.ListPending(r.Context())
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to get routes")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.ListRoutesResponse{Routes: routes})
+}
+
+// ApproveRoute enables an advertised route (admin)
+// POST /api/admin/routes/{route_id}/approve
+func (h *RouteHandler) ApproveRoute(w http.ResponseWriter, r *http.Request) {
+	routeID, err := uuid.Parse(chi.URLParam(r, "route_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid route ID")
+		return
+	}
+
+	route, err := h.routeService.Approve(r.Context(), routeID)
+	if err != nil {
+		h.respondRouteError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, route)
+}
+
+// RejectRoute disables an advertised route (admin)
+// POST /api/admin/routes/{route_id}/reject
+func (h *RouteHandler) RejectRoute(w http.ResponseWriter, r *http.Request) {
+	routeID, err := uuid.Parse(chi.URLParam(r, "route_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid route ID")
+		return
+	}
+
+	route, err := h.routeService.Reject(r.Context(), routeID)
+	if err != nil {
+		h.respondRouteError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, route)
+}
+
+func (h *RouteHandler) respondRouteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrRouteNotFound):
+		respondErrorJSON(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrRouteConflict), errors.Is(err, services.ErrExitNodeTaken):
+		respondErrorJSON(w, http.StatusConflict, err.Error())
+	default:
+		respondErrorJSON(w, http.StatusInternalServerError, err.Error())
+	}
+}
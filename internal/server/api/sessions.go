@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/services"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type SessionHandler struct {
+	deviceAuthService *services.DeviceAuthService
+	deviceService     *services.DeviceService
+}
+
+func NewSessionHandler(deviceAuthService *services.DeviceAuthService, deviceService *services.DeviceService) *SessionHandler {
+	return &SessionHandler{
+		deviceAuthService: deviceAuthService,
+		deviceService:     deviceService,
+	}
+}
+
+// ListSessions returns the user's active logins (one per refresh token family)
+// GET /api/sessions
+func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	tokens, err := h.deviceAuthService.ListSessions(r.Context(), claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to list sessions")
+		return
+	}
+
+	devices, err := h.deviceService.GetUserDevices(r.Context(), claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to get devices")
+		return
+	}
+	names := make(map[uuid.UUID]string, len(devices))
+	for i := range devices {
+		names[devices[i].ID] = devices[i].Name()
+	}
+
+	sessions := make([]models.SessionInfo, 0, len(tokens))
+	for _, token := range tokens {
+		session := models.SessionInfo{
+			ID:         token.FamilyID.String(),
+			DeviceID:   token.DeviceID.String(),
+			DeviceName: names[token.DeviceID],
+			CreatedAt:  token.SessionStartedAt.Format("2006-01-02T15:04:05Z"),
+			ExpiresAt:  token.ExpiresAt.Format("2006-01-02T15:04:05Z"),
+			Current:    claims.SessionID != uuid.Nil && claims.SessionID == token.FamilyID,
+		}
+		if token.LastUsedAt != nil {
+			lastUsed := token.LastUsedAt.Format("2006-01-02T15:04:05Z")
+			session.LastUsedAt = &lastUsed
+		}
+		if token.LastUsedIP != nil {
+			session.LastUsedIP = *token.LastUsedIP
+		}
+		sessions = append(sessions, session)
+	}
+
+	respondJSON(w, http.StatusOK, models.ListSessionsResponse{Sessions: sessions})
+}
+
+// RevokeSession signs out one session; its refresh token stops working and
+// access tokens already issued expire within minutes
+// DELETE /api/sessions/{session_id}
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	sessionID, err := uuid.Parse(chi.URLParam(r, "session_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid session ID")
+		return
+	}
+
+	if err := h.deviceAuthService.RevokeSession(r.Context(), claims.UserID, sessionID); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			respondErrorJSON(w, http.StatusNotFound, "session not found")
+			return
+		}
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to revoke session")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.RevokeSessionsResponse{Revoked: 1})
+}
+
+// RevokeOtherSessions signs out everywhere except the calling session
+// DELETE /api/sessions
+func (h *SessionHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	revoked, err := h.deviceAuthService.RevokeOtherSessions(r.Context(), claims.UserID, claims.SessionID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to revoke sessions")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.RevokeSessionsResponse{Revoked: revoked})
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/dns"
+	"github.com/kamikazebr/roamie-desktop/internal/server/services"
+	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHCertificateHandler issues SSH certificates signed by the Roamie CA
+type SSHCertificateHandler struct {
+	ca            *services.SSHCertificateAuthority
+	deviceService *services.DeviceService
+	userRepo      *storage.UserRepository
+	dnsConfig     *dns.Config
+}
+
+func NewSSHCertificateHandler(
+	ca *services.SSHCertificateAuthority,
+	deviceService *services.DeviceService,
+	userRepo *storage.UserRepository,
+) *SSHCertificateHandler {
+	return &SSHCertificateHandler{
+		ca:            ca,
+		deviceService: deviceService,
+		userRepo:      userRepo,
+	}
+}
+
+// SetDNS adds device DNS names to host certificate principals
+func (h *SSHCertificateHandler) SetDNS(cfg *dns.Config) {
+	h.dnsConfig = cfg
+}
+
+// GetCA returns the CA public keys
+// GET /api/ssh/ca
+func (h *SSHCertificateHandler) GetCA(w http.ResponseWriter, r *http.Request) {
+	if GetUserClaims(r) == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	respondJSON(w, http.StatusOK, h.ca.PublicKeys())
+}
+
+// IssueUserCertificate signs a key to log in to one of the user's devices as
+// the OS user recorded for that device. The certificate's only principal
+// names the device, which maps it to that OS user.
+// POST /api/ssh/certificates
+// Body: {"device_id": "uuid", "public_key": "ssh-ed25519 ..."}
+func (h *SSHCertificateHandler) IssueUserCertificate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req models.SSHCertificateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	publicKey, ok := parseCertificateKey(w, req.PublicKey)
+	if !ok {
+		return
+	}
+	device, ok := h.lookupDevice(w, r, claims, req.DeviceID)
+	if !ok {
+		return
+	}
+
+	if device.Username == nil || *device.Username == "" {
+		respondErrorJSON(w, http.StatusConflict, "device has no username recorded, log in again on that device")
+		return
+	}
+
+	keyID := "user=" + claims.Email + " device=" + device.ID.String()
+	cert, err := h.ca.SignUserCertificate(publicKey, keyID, []string{models.SSHDevicePrincipal(device.ID.String())})
+	if err != nil {
+		log.Printf("Failed to sign SSH user certificate for device %s: %v", device.ID, err)
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to sign certificate")
+		return
+	}
+
+	log.Printf("Issued SSH certificate for %s on device %s (serial %d)", claims.Email, device.ID, cert.Serial)
+
+	response := certificateResponse(cert)
+	response.HostCA = h.ca.PublicKeys().HostCA
+	respondJSON(w, http.StatusOK, response)
+}
+
+// IssueHostCertificate signs a device's sshd host key
+// POST /api/ssh/host-certificate
+// Body: {"device_id": "uuid", "public_key": "ssh-ed25519 ..."}
+func (h *SSHCertificateHandler) IssueHostCertificate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req models.SSHCertificateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	publicKey, ok := parseCertificateKey(w, req.PublicKey)
+	if !ok {
+		return
+	}
+	device, ok := h.lookupOwnDevice(w, r, claims, req.DeviceID)
+	if !ok {
+		return
+	}
+
+	principals, err := h.hostPrincipals(r, claims, device)
+	if err != nil {
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to get device names")
+		return
+	}
+
+	cert, err := h.ca.SignHostCertificate(publicKey, "host device="+device.ID.String(), principals)
+	if err != nil {
+		log.Printf("Failed to sign SSH host certificate for device %s: %v", device.ID, err)
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to sign certificate")
+		return
+	}
+
+	log.Printf("Issued SSH host certificate for device %s (%s)", device.ID, strings.Join(principals, ", "))
+	respondJSON(w, http.StatusOK, certificateResponse(cert))
+}
+
+// IssueTunnelCertificate signs the device's registered tunnel key
+// POST /api/tunnel/certificate
+// Body: {"device_id": "uuid"}
+func (h *SSHCertificateHandler) IssueTunnelCertificate(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req models.SSHCertificateRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	device, ok := h.lookupOwnDevice(w, r, claims, req.DeviceID)
+	if !ok {
+		return
+	}
+
+	if device.TunnelSSHKey == nil {
+		respondErrorJSON(w, http.StatusConflict, "no tunnel key registered for this device")
+		return
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(*device.TunnelSSHKey))
+	if err != nil {
+		respondErrorJSON(w, http.StatusConflict, "registered tunnel key is invalid")
+		return
+	}
+
+	cert, err := h.ca.SignTunnelCertificate(publicKey, device.ID.String())
+	if err != nil {
+		log.Printf("Failed to sign tunnel certificate for device %s: %v", device.ID, err)
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to sign certificate")
+		return
+	}
+	respondJSON(w, http.StatusOK, certificateResponse(cert))
+}
+
+// lookupDevice returns one of the user's devices, responding with an error
+// if it doesn't exist
+func (h *SSHCertificateHandler) lookupDevice(w http.ResponseWriter, r *http.Request, claims *utils.Claims, id string) (*models.Device, bool) {
+	deviceID, err := uuid.Parse(id)
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid device_id")
+		return nil, false
+	}
+	device, err := h.deviceService.GetDevice(r.Context(), deviceID, claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusNotFound, "device not found")
+		return nil, false
+	}
+	return device, true
+}
+
+// lookupOwnDevice is lookupDevice for certificates that vouch for the device
+// itself: a token issued to another device can't request them
+func (h *SSHCertificateHandler) lookupOwnDevice(w http.ResponseWriter, r *http.Request, claims *utils.Claims, id string) (*models.Device, bool) {
+	device, ok := h.lookupDevice(w, r, claims, id)
+	if !ok {
+		return nil, false
+	}
+	if claims.HasDevice() && claims.DeviceID != device.ID {
+		respondErrorJSON(w, http.StatusForbidden, "a device can only request certificates for itself")
+		return nil, false
+	}
+	return device, true
+}
+
+// hostPrincipals are the names a device is reached by: its VPN IP and, with
+// DNS enabled, its DNS name
+func (h *SSHCertificateHandler) hostPrincipals(r *http.Request, claims *utils.Claims, device *models.Device) ([]string, error) {
+	principals := []string{device.VpnIP}
+	if h.dnsConfig == nil {
+		return principals, nil
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), claims.UserID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	devices, err := h.deviceService.GetUserDevices(r.Context(), claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	dns.AssignNames(user, devices, h.dnsConfig.Zone)
+	for _, d := range devices {
+		if d.ID == device.ID && d.DNSName != "" {
+			principals = append(principals, d.DNSName)
+		}
+	}
+	return principals, nil
+}
+
+// parseCertificateKey parses the public key to certify, responding with an
+// error if it is invalid
+func parseCertificateKey(w http.ResponseWriter, key string) (ssh.PublicKey, bool) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid public_key")
+		return nil, false
+	}
+	if _, isCert := publicKey.(*ssh.Certificate); isCert {
+		respondErrorJSON(w, http.StatusBadRequest, "public_key must be a plain key, not a certificate")
+		return nil, false
+	}
+	return publicKey, true
+}
+
+func certificateResponse(cert *ssh.Certificate) models.SSHCertificateResponse {
+	return models.SSHCertificateResponse{
+		Certificate: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		Principals:  cert.ValidPrincipals,
+		ValidBefore: time.Unix(int64(cert.ValidBefore), 0).UTC(),
+	}
+}
@@ -1,13 +1,22 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kamikazebr/roamie-desktop/internal/server/services"
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/kamikazebr/roamie-desktop/internal/server/tunnel"
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/ssh"
@@ -19,6 +28,36 @@ type TunnelHandler struct {
 	deviceService  *services.DeviceService
 	tunnelPortPool *services.TunnelPortPool
 	tunnelService  *services.TunnelService
+	usageRepo      *storage.TunnelUsageRepository
+	hostKeys       TunnelHostKeyProvider
+	transport      TunnelTransport
+	commander      TunnelCommander
+	exposure       TunnelExposure
+}
+
+// TunnelHostKeyProvider publishes the tunnel server's SSH host keys
+type TunnelHostKeyProvider interface {
+	HostKeys() []models.TunnelHostKey
+}
+
+// TunnelTransport is the embedded tunnel server: it accepts tunnels over
+// WebSocket and knows which devices are connected
+type TunnelTransport interface {
+	ServeWebSocket(w http.ResponseWriter, r *http.Request)
+	SessionTransport(deviceID uuid.UUID) string
+}
+
+// TunnelCommander runs commands on devices over their tunnel connection
+type TunnelCommander interface {
+	DeviceCommands(deviceID uuid.UUID) []string
+	RunCommand(ctx context.Context, deviceID uuid.UUID, req control.Request, onOutput func(line string)) (*control.Event, error)
+}
+
+// TunnelExposure decides where devices' tunnel ports listen
+type TunnelExposure interface {
+	Exposure(device *models.Device) models.TunnelExposure
+	CheckExposureOverride(bind string, allowedCIDRs []string) error
+	DisconnectDevice(deviceID uuid.UUID)
 }
 
 func NewTunnelHandler(
@@ -26,13 +65,47 @@ func NewTunnelHandler(
 	deviceService *services.DeviceService,
 	tunnelPortPool *services.TunnelPortPool,
 	tunnelService *services.TunnelService,
+	usageRepo *storage.TunnelUsageRepository,
 ) *TunnelHandler {
 	return &TunnelHandler{
 		deviceRepo:     deviceRepo,
 		deviceService:  deviceService,
 		tunnelPortPool: tunnelPortPool,
 		tunnelService:  tunnelService,
+		usageRepo:      usageRepo,
+	}
+}
+
+// SetHostKeys sets where the tunnel host keys published to clients come from
+func (h *TunnelHandler) SetHostKeys(provider TunnelHostKeyProvider) {
+	h.hostKeys = provider
+}
+
+// SetTransport sets the tunnel server that WebSocket tunnels are handed to
+func (h *TunnelHandler) SetTransport(transport TunnelTransport) {
+	h.transport = transport
+}
+
+// SetCommander sets the tunnel server that runs device commands
+func (h *TunnelHandler) SetCommander(commander TunnelCommander) {
+	h.commander = commander
+}
+
+// SetExposure sets the tunnel server that decides where tunnel ports listen
+func (h *TunnelHandler) SetExposure(exposure TunnelExposure) {
+	h.exposure = exposure
+}
+
+// ServeWebSocket carries the tunnel's SSH connection over a WebSocket for
+// networks that block the tunnel port. It is public: the SSH handshake
+// authenticates the device.
+// GET /api/tunnel/ws
+func (h *TunnelHandler) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	if h.transport == nil {
+		respondErrorJSON(w, http.StatusServiceUnavailable, "tunnel server not available over WebSocket")
+		return
 	}
+	h.transport.ServeWebSocket(w, r)
 }
 
 // Register allocates a tunnel port for a device
@@ -154,6 +227,19 @@ func (h *TunnelHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	var tunnelDevices []map[string]interface{}
 	for _, device := range devices {
 		if device.TunnelPort != nil {
+			transport := ""
+			if h.transport != nil {
+				transport = h.transport.SessionTransport(device.ID)
+			}
+			var commands []string
+			if h.commander != nil {
+				commands = h.commander.DeviceCommands(device.ID)
+			}
+			var exposure *models.TunnelExposure
+			if h.exposure != nil {
+				e := h.exposure.Exposure(&device)
+				exposure = &e
+			}
 			tunnelDevices = append(tunnelDevices, map[string]interface{}{
 				"device_id":   device.ID.String(),
 				"device_name": device.DeviceName,
@@ -161,6 +247,10 @@ func (h *TunnelHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 				"vpn_ip":      device.VpnIP,
 				"last_seen":   device.LastSeen,
 				"enabled":     device.TunnelEnabled,
+				"connected":   transport != "",
+				"transport":   transport,
+				"commands":    commands,
+				"exposure":    exposure,
 			})
 		}
 	}
@@ -289,6 +379,66 @@ func (h *TunnelHandler) EnableTunnel(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// SetTunnelExposure sets where a device's tunnel port listens, or clears the
+// override with an empty bind. A connected device is disconnected so it
+// reconnects with the new setting.
+// PATCH /api/devices/{device_id}/tunnel/exposure
+// Body: {"bind": "public", "allowed_cidrs": ["203.0.113.0/24"]}
+func (h *TunnelHandler) SetTunnelExposure(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	deviceID, err := uuid.Parse(chi.URLParam(r, "device_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid device_id")
+		return
+	}
+
+	var req models.SetTunnelExposureRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	// Verify device belongs to user
+	device, err := h.deviceService.GetDevice(r.Context(), deviceID, claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	if h.exposure == nil {
+		respondErrorJSON(w, http.StatusServiceUnavailable, "tunnel server not available")
+		return
+	}
+	if err := h.exposure.CheckExposureOverride(req.Bind, req.AllowedCIDRs); err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var bind *string
+	if req.Bind != "" {
+		bind = &req.Bind
+	}
+	if err := h.deviceRepo.UpdateTunnelExposure(r.Context(), device.ID, bind, req.AllowedCIDRs); err != nil {
+		log.Printf("Failed to set tunnel exposure for device %s: %v", device.ID, err)
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to set tunnel exposure")
+		return
+	}
+	device.TunnelBind = bind
+	device.TunnelAllowedCIDRs = req.AllowedCIDRs
+
+	// The listener only moves when the device reconnects
+	h.exposure.DisconnectDevice(device.ID)
+
+	log.Printf("Set tunnel exposure for device %s to %q %v (user: %s)", device.ID, req.Bind, req.AllowedCIDRs, claims.UserID)
+
+	respondJSON(w, http.StatusOK, h.exposure.Exposure(device))
+}
+
 // DisableTunnel disables the tunnel for a specific device
 // PATCH /api/devices/{device_id}/tunnel/disable
 func (h *TunnelHandler) DisableTunnel(w http.ResponseWriter, r *http.Request) {
@@ -355,3 +505,188 @@ func (h *TunnelHandler) GetAuthorizedKeys(w http.ResponseWriter, r *http.Request
 		"keys": keys,
 	})
 }
+
+// GetHostKeys returns the tunnel server's SSH host keys for clients to pin,
+// including a key announced for rotation and one being retired
+// GET /api/tunnel/host-keys
+func (h *TunnelHandler) GetHostKeys(w http.ResponseWriter, r *http.Request) {
+	if GetUserClaims(r) == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var keys []models.TunnelHostKey
+	if h.hostKeys != nil {
+		keys = h.hostKeys.HostKeys()
+	}
+	if len(keys) == 0 {
+		respondErrorJSON(w, http.StatusServiceUnavailable, "tunnel host keys not available")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.TunnelHostKeysResponse{HostKeys: keys})
+}
+
+// GetUsage returns daily tunnel usage of the user's devices
+// GET /api/tunnel/usage?days=30
+func (h *TunnelHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	since := usageSince(r)
+	usage, err := h.usageRepo.GetByUser(r.Context(), claims.UserID, since)
+	if err != nil {
+		log.Printf("Failed to get tunnel usage for user %s: %v", claims.UserID, err)
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to get tunnel usage")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.TunnelUsageResponse{Usage: usage, Since: since})
+}
+
+// GetDeviceUsage returns daily tunnel usage of one device
+// GET /api/devices/{device_id}/tunnel/usage?days=30
+func (h *TunnelHandler) GetDeviceUsage(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	deviceID, err := uuid.Parse(chi.URLParam(r, "device_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid device_id")
+		return
+	}
+
+	// Verify device belongs to user
+	device, err := h.deviceService.GetDevice(r.Context(), deviceID, claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	since := usageSince(r)
+	usage, err := h.usageRepo.GetByDevice(r.Context(), device.ID, since)
+	if err != nil {
+		log.Printf("Failed to get tunnel usage for device %s: %v", device.ID, err)
+		respondErrorJSON(w, http.StatusInternalServerError, "failed to get tunnel usage")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.TunnelUsageResponse{Usage: usage, Since: since})
+}
+
+// usageSince returns the first day (UTC) of the ?days= period, 30 days by
+// default and at most a year
+func usageSince(r *http.Request) time.Time {
+	days := 30
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && parsed > 0 {
+		days = min(parsed, 366)
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	return today.AddDate(0, 0, -(days - 1))
+}
+
+// How long a device command may run before the server gives up on it
+const deviceCommandTimeout = 2 * time.Minute
+
+// RunDeviceCommand runs a command on a device over its tunnel connection.
+// With ?stream=true the response is the device's events as they arrive, one
+// JSON object per line (output lines, then the result).
+// POST /api/devices/{device_id}/commands
+// Body: {"command": "doctor", "args": {...}}
+func (h *TunnelHandler) RunDeviceCommand(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserClaims(r)
+	if claims == nil {
+		respondErrorJSON(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	deviceID, err := uuid.Parse(chi.URLParam(r, "device_id"))
+	if err != nil {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid device_id")
+		return
+	}
+
+	var req models.RunDeviceCommandRequest
+	if err := decodeJSON(r, &req); err != nil || req.Command == "" {
+		respondErrorJSON(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	// Verify device belongs to user
+	device, err := h.deviceService.GetDevice(r.Context(), deviceID, claims.UserID)
+	if err != nil {
+		respondErrorJSON(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	if h.commander == nil {
+		respondErrorJSON(w, http.StatusServiceUnavailable, "tunnel server not available")
+		return
+	}
+	if !slices.Contains(h.commander.DeviceCommands(device.ID), req.Command) {
+		if h.transport != nil && h.transport.SessionTransport(device.ID) == "" {
+			respondErrorJSON(w, http.StatusConflict, "device is not connected to the tunnel server")
+			return
+		}
+		respondErrorJSON(w, http.StatusBadRequest, "device doesn't support command "+req.Command)
+		return
+	}
+
+	// Commands outlive the server's write timeout
+	ctx, cancel := context.WithTimeout(r.Context(), deviceCommandTimeout)
+	defer cancel()
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(deviceCommandTimeout + 10*time.Second))
+
+	log.Printf("User %s running %s on device %s", claims.UserID, req.Command, device.ID)
+	controlReq := control.Request{Command: req.Command, Args: req.Args}
+
+	if r.URL.Query().Get("stream") == "true" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		rc.Flush()
+
+		result, err := h.commander.RunCommand(ctx, device.ID, controlReq, func(line string) {
+			encoder.Encode(control.Event{Type: control.EventOutput, Data: line})
+			rc.Flush()
+		})
+		if err != nil {
+			result = &control.Event{Type: control.EventResult, Error: err.Error()}
+		}
+		encoder.Encode(result)
+		rc.Flush()
+		return
+	}
+
+	output := []string{}
+	result, err := h.commander.RunCommand(ctx, device.ID, controlReq, func(line string) {
+		output = append(output, line)
+	})
+	switch {
+	case errors.Is(err, tunnel.ErrDeviceNotConnected):
+		respondErrorJSON(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, tunnel.ErrCommandNotSupported):
+		respondErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Printf("Command %s on device %s failed: %v", req.Command, device.ID, err)
+		respondErrorJSON(w, http.StatusBadGateway, "command failed: "+err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.RunDeviceCommandResponse{
+		Command: req.Command,
+		OK:      result.OK,
+		Output:  output,
+		Result:  result.Result,
+		Error:   result.Error,
+	})
+}
@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+// maxLabelLen is the DNS limit for a single label (RFC 1035)
+const maxLabelLen = 63
+
+// Label turns free-form text into a DNS label: lowercase letters, digits and
+// single hyphens, never starting or ending with a hyphen
+func Label(s string) string {
+	var b strings.Builder
+	lastHyphen := true // suppresses leading hyphens
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			lastHyphen = false
+		default:
+			if !lastHyphen {
+				b.WriteByte('-')
+				lastHyphen = true
+			}
+		}
+	}
+
+	label := strings.TrimSuffix(b.String(), "-")
+	if len(label) > maxLabelLen {
+		label = strings.TrimSuffix(label[:maxLabelLen], "-")
+	}
+	return label
+}
+
+// UserLabel is the zone label for a user, derived from the email local part
+func UserLabel(user *models.User) string {
+	local, _, _ := strings.Cut(user.Email, "@")
+	if label := Label(local); label != "" {
+		return label
+	}
+	return "user-" + user.ID.String()[:8]
+}
+
+// DeviceLabel is the preferred label for a device: its display name when set,
+// otherwise its device name
+func DeviceLabel(device *models.Device) string {
+	if device.DisplayName != nil {
+		if label := Label(*device.DisplayName); label != "" {
+			return label
+		}
+	}
+	if label := Label(device.DeviceName); label != "" {
+		return label
+	}
+	return "device-" + device.ID.String()[:8]
+}
+
+// DeviceNames assigns every device a unique label within the user's zone. The
+// oldest device keeps a contested label; later ones get a short ID suffix so
+// names stay stable as devices are added.
+func DeviceNames(devices []models.Device) map[uuid.UUID]string {
+	sorted := make([]models.Device, len(devices))
+	copy(sorted, devices)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	names := make(map[uuid.UUID]string, len(sorted))
+	taken := make(map[string]bool, len(sorted))
+	for i := range sorted {
+		label := DeviceLabel(&sorted[i])
+		if taken[label] {
+			suffix := "-" + sorted[i].ID.String()[:4]
+			if len(label)+len(suffix) > maxLabelLen {
+				label = strings.TrimSuffix(label[:maxLabelLen-len(suffix)], "-")
+			}
+			label += suffix
+		}
+		taken[label] = true
+		names[sorted[i].ID] = label
+	}
+	return names
+}
+
+// FQDN joins a device label, user label and zone into a fully qualified name
+func FQDN(deviceLabel, userLabel, zone string) string {
+	return deviceLabel + "." + userLabel + "." + zone
+}
+
+// AssignNames fills DNSName on each device of a user
+func AssignNames(user *models.User, devices []models.Device, zone string) {
+	names := DeviceNames(devices)
+	userLabel := UserLabel(user)
+	for i := range devices {
+		devices[i].DNSName = FQDN(names[devices[i].ID], userLabel, zone)
+	}
+}
@@ -0,0 +1,487 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultZone is the domain device names live under
+	DefaultZone = "roamie.internal"
+
+	// recordTTL is short so renames and re-homes propagate quickly
+	recordTTL = 60
+
+	upstreamTimeout = 3 * time.Second
+	maxUDPSize      = 4096
+
+	// maxUDPHandlers caps the UDP queries handled at once; further queries
+	// wait in the socket buffer
+	maxUDPHandlers = 256
+
+	// requesterTTL is how long the device behind a VPN IP is cached, so a
+	// removed or re-homed device stops resolving names shortly after
+	requesterTTL      = 10 * time.Second
+	maxCachedRequests = 4096
+)
+
+// DeviceStore is the subset of the device repository the resolver needs
+type DeviceStore interface {
+	GetByVpnIP(ctx context.Context, vpnIP string) (*models.Device, error)
+	GetByUserID(ctx context.Context, userID uuid.UUID) ([]models.Device, error)
+}
+
+// UserStore is the subset of the user repository the resolver needs
+type UserStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+}
+
+// Config controls the embedded resolver
+type Config struct {
+	Enabled   bool
+	Zone      string   // e.g. "roamie.internal"
+	ServerIP  string   // Address clients send queries to (the WireGuard interface IP)
+	Listen    string   // host:port to bind (defaults to ServerIP:53)
+	Upstreams []string // host:port resolvers for everything outside the zone
+	Networks  []string // VPN networks queries are accepted from
+}
+
+// ConfigFromEnv reads DNS_ENABLED (off unless "true"), DNS_ZONE, DNS_LISTEN and
+// DNS_UPSTREAM. serverIP is the WireGuard interface address of this server.
+func ConfigFromEnv(serverIP string) *Config {
+	cfg := &Config{
+		Enabled:   os.Getenv("DNS_ENABLED") == "true",
+		Zone:      DefaultZone,
+		ServerIP:  serverIP,
+		Listen:    net.JoinHostPort(serverIP, "53"),
+		Upstreams: []string{"1.1.1.1:53", "8.8.8.8:53"},
+	}
+
+	if zone := strings.Trim(strings.ToLower(os.Getenv("DNS_ZONE")), "."); zone != "" {
+		cfg.Zone = zone
+	}
+	if listen := os.Getenv("DNS_LISTEN"); listen != "" {
+		cfg.Listen = listen
+	}
+	if upstream := os.Getenv("DNS_UPSTREAM"); upstream != "" {
+		cfg.Upstreams = nil
+		for _, u := range strings.Split(upstream, ",") {
+			u = strings.TrimSpace(u)
+			if u == "" {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(u); err != nil {
+				u = net.JoinHostPort(u, "53")
+			}
+			cfg.Upstreams = append(cfg.Upstreams, u)
+		}
+	}
+
+	return cfg
+}
+
+// Server answers <device>.<user>.<zone> from the devices table and forwards
+// all other queries upstream. Only queries from the VPN networks are answered,
+// and zone names only to devices, identified by the VPN source address of the
+// query, for their own user.
+type Server struct {
+	cfg      *Config
+	devices  DeviceStore
+	users    UserStore
+	networks []netip.Prefix
+	udpSlots chan struct{}
+
+	requestersMu sync.Mutex
+	requesters   map[netip.Addr]cachedRequester
+
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// NewServer creates a resolver; call Start to begin serving
+func NewServer(cfg *Config, devices DeviceStore, users UserStore) *Server {
+	var networks []netip.Prefix
+	for _, network := range cfg.Networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			log.Printf("Warning: invalid DNS network %q, ignoring", network)
+			continue
+		}
+		networks = append(networks, prefix.Masked())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		cfg:        cfg,
+		devices:    devices,
+		users:      users,
+		networks:   networks,
+		udpSlots:   make(chan struct{}, maxUDPHandlers),
+		requesters: make(map[netip.Addr]cachedRequester),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// cachedRequester is the device (nil if none) behind a VPN IP
+type cachedRequester struct {
+	device  *models.Device
+	expires time.Time
+}
+
+// Start binds UDP and TCP on the configured address
+func (s *Server) Start() error {
+	udpConn, err := net.ListenPacket("udp", s.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on udp %s: %w", s.cfg.Listen, err)
+	}
+
+	tcpListener, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("failed to listen on tcp %s: %w", s.cfg.Listen, err)
+	}
+
+	s.udpConn = udpConn
+	s.tcpListener = tcpListener
+
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+
+	log.Printf("DNS resolver listening on %s (zone %s)", udpConn.LocalAddr(), s.cfg.Zone)
+	return nil
+}
+
+// Addr returns the bound UDP address (useful when listening on port 0)
+func (s *Server) Addr() string {
+	return s.udpConn.LocalAddr().String()
+}
+
+// Stop closes the listeners and waits for in-flight queries
+func (s *Server) Stop() {
+	s.cancel()
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+	s.wg.Wait()
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, maxUDPSize)
+	for {
+		n, addr, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				log.Printf("DNS: udp read failed: %v", err)
+			}
+			return
+		}
+
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		s.udpSlots <- struct{}{}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-s.udpSlots }()
+			if resp := s.handle(query, addr, "udp"); resp != nil {
+				s.udpConn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcpListener.Accept()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				log.Printf("DNS: tcp accept failed: %v", err)
+			}
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+			query, err := readTCPMessage(conn)
+			if err != nil {
+				return
+			}
+			if resp := s.handle(query, conn.RemoteAddr(), "tcp"); resp != nil {
+				writeTCPMessage(conn, resp)
+			}
+		}()
+	}
+}
+
+// handle answers a raw DNS query; network is the transport it arrived on
+func (s *Server) handle(query []byte, from net.Addr, network string) []byte {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return reply(hdr, nil, dnsmessage.RCodeFormatError, nil)
+	}
+
+	// Only the VPN may use the resolver, so it can't be used as an open
+	// resolver by anything else that reaches the port
+	src, ok := sourceIP(from)
+	if !ok || !s.fromVPN(src) {
+		return reply(hdr, &q, dnsmessage.RCodeRefused, nil)
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))
+	if name != s.cfg.Zone && !strings.HasSuffix(name, "."+s.cfg.Zone) {
+		resp, err := s.forward(query, network)
+		if err != nil {
+			log.Printf("DNS: forwarding %s failed: %v", name, err)
+			return reply(hdr, &q, dnsmessage.RCodeServerFailure, nil)
+		}
+		return resp
+	}
+
+	requester, rcode := s.requester(src)
+	if rcode != dnsmessage.RCodeSuccess {
+		return reply(hdr, &q, rcode, nil)
+	}
+	ip, rcode := s.resolve(name, requester)
+	if rcode != dnsmessage.RCodeSuccess || q.Type != dnsmessage.TypeA {
+		// Non-A queries for existing names get an empty NOERROR answer
+		return reply(hdr, &q, rcode, nil)
+	}
+	return reply(hdr, &q, rcode, &ip)
+}
+
+// fromVPN reports whether a query source is inside the VPN networks
+func (s *Server) fromVPN(src netip.Addr) bool {
+	for _, network := range s.networks {
+		if network.Contains(src) {
+			return true
+		}
+	}
+	return false
+}
+
+// requester returns the device a query came from, identified by its VPN
+// source address. Lookups are cached for requesterTTL.
+func (s *Server) requester(src netip.Addr) (*models.Device, dnsmessage.RCode) {
+	now := time.Now()
+	s.requestersMu.Lock()
+	cached, ok := s.requesters[src]
+	s.requestersMu.Unlock()
+
+	device := cached.device
+	if !ok || now.After(cached.expires) {
+		ctx, cancel := context.WithTimeout(s.ctx, upstreamTimeout)
+		defer cancel()
+
+		var err error
+		device, err = s.devices.GetByVpnIP(ctx, src.String())
+		if err != nil {
+			log.Printf("DNS: failed to look up device %s: %v", src, err)
+			return nil, dnsmessage.RCodeServerFailure
+		}
+		s.cacheRequester(src, cachedRequester{device: device, expires: now.Add(requesterTTL)})
+	}
+
+	if device == nil {
+		return nil, dnsmessage.RCodeRefused
+	}
+	return device, dnsmessage.RCodeSuccess
+}
+
+// cacheRequester stores a lookup, dropping expired entries once the cache is
+// full
+func (s *Server) cacheRequester(src netip.Addr, entry cachedRequester) {
+	s.requestersMu.Lock()
+	defer s.requestersMu.Unlock()
+
+	if len(s.requesters) >= maxCachedRequests {
+		now := time.Now()
+		for addr, cached := range s.requesters {
+			if now.After(cached.expires) {
+				delete(s.requesters, addr)
+			}
+		}
+		if len(s.requesters) >= maxCachedRequests {
+			clear(s.requesters)
+		}
+	}
+	s.requesters[src] = entry
+}
+
+// resolve looks a zone name up among the devices of the querying device's user
+func (s *Server) resolve(name string, requester *models.Device) (netip.Addr, dnsmessage.RCode) {
+	ctx, cancel := context.WithTimeout(s.ctx, upstreamTimeout)
+	defer cancel()
+
+	user, err := s.users.GetByID(ctx, requester.UserID)
+	if err != nil || user == nil {
+		return netip.Addr{}, dnsmessage.RCodeServerFailure
+	}
+	devices, err := s.devices.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return netip.Addr{}, dnsmessage.RCodeServerFailure
+	}
+
+	userLabel := UserLabel(user)
+	for id, label := range DeviceNames(devices) {
+		if FQDN(label, userLabel, s.cfg.Zone) != name {
+			continue
+		}
+		for _, d := range devices {
+			if d.ID != id {
+				continue
+			}
+			addr, err := netip.ParseAddr(d.VpnIP)
+			if err != nil || !addr.Is4() {
+				return netip.Addr{}, dnsmessage.RCodeServerFailure
+			}
+			return addr, dnsmessage.RCodeSuccess
+		}
+	}
+
+	return netip.Addr{}, dnsmessage.RCodeNameError
+}
+
+// forward relays a query to the first upstream that answers
+func (s *Server) forward(query []byte, network string) ([]byte, error) {
+	var lastErr error
+	for _, upstream := range s.cfg.Upstreams {
+		resp, err := exchange(s.ctx, network, upstream, query)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no upstream resolvers configured")
+	}
+	return nil, lastErr
+}
+
+func exchange(ctx context.Context, network, upstream string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxUDPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// reply builds an authoritative response, optionally carrying one A record
+func reply(req dnsmessage.Header, q *dnsmessage.Question, rcode dnsmessage.RCode, a *netip.Addr) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 req.ID,
+		Response:           true,
+		OpCode:             req.OpCode,
+		Authoritative:      true,
+		RecursionDesired:   req.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	b.EnableCompression()
+
+	if q != nil {
+		b.StartQuestions()
+		b.Question(*q)
+		if a != nil {
+			b.StartAnswers()
+			b.AResource(dnsmessage.ResourceHeader{
+				Name:  q.Name,
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+				TTL:   recordTTL,
+			}, dnsmessage.AResource{A: a.As4()})
+		}
+	}
+
+	msg, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+func sourceIP(addr net.Addr) (netip.Addr, bool) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip, ok := netip.AddrFromSlice(a.IP)
+		return ip.Unmap(), ok
+	case *net.TCPAddr:
+		ip, ok := netip.AddrFromSlice(a.IP)
+		return ip.Unmap(), ok
+	}
+	return netip.Addr{}, false
+}
+
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
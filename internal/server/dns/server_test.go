@@ -0,0 +1,305 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type fakeStore struct {
+	users   map[uuid.UUID]*models.User
+	devices []models.Device
+	lookups atomic.Int32
+}
+
+func (f *fakeStore) GetByVpnIP(ctx context.Context, vpnIP string) (*models.Device, error) {
+	f.lookups.Add(1)
+	for i := range f.devices {
+		if f.devices[i].VpnIP == vpnIP {
+			return &f.devices[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeStore) GetByUserID(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
+	var out []models.Device
+	for _, d := range f.devices {
+		if d.UserID == userID {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeStore) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return f.users[id], nil
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Work Laptop", "work-laptop"},
+		{"linux-alice-a1b2c3d4", "linux-alice-a1b2c3d4"},
+		{"  --Félix's  PC--  ", "f-lix-s-pc"},
+		{"john.doe", "john-doe"},
+		{"!!!", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Label(tt.in); got != tt.want {
+			t.Errorf("Label(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceNames_Disambiguates(t *testing.T) {
+	now := time.Now()
+	older := models.Device{ID: uuid.New(), DeviceName: "laptop", CreatedAt: now.Add(-time.Hour)}
+	newer := models.Device{ID: uuid.New(), DeviceName: "other", DisplayName: strPtr("Laptop"), CreatedAt: now}
+
+	// Order must not matter: the oldest device keeps the plain label
+	names := DeviceNames([]models.Device{newer, older})
+	if names[older.ID] != "laptop" {
+		t.Errorf("older device name = %q, want laptop", names[older.ID])
+	}
+	if want := "laptop-" + newer.ID.String()[:4]; names[newer.ID] != want {
+		t.Errorf("newer device name = %q, want %q", names[newer.ID], want)
+	}
+}
+
+// startResolver runs a resolver for two users and a fake upstream on loopback.
+// alice's laptop queries from 127.0.0.1, which is registered as its VPN IP.
+func startResolver(t *testing.T) (*Server, *fakeStore) {
+	t.Helper()
+
+	alice := &models.User{ID: uuid.New(), Email: "alice@example.com"}
+	bob := &models.User{ID: uuid.New(), Email: "bob@example.com"}
+	store := &fakeStore{
+		users: map[uuid.UUID]*models.User{alice.ID: alice, bob.ID: bob},
+		devices: []models.Device{
+			{ID: uuid.New(), UserID: alice.ID, DeviceName: "laptop", VpnIP: "127.0.0.1"},
+			{ID: uuid.New(), UserID: alice.ID, DeviceName: "desktop", DisplayName: strPtr("Home PC"), VpnIP: "10.100.0.3"},
+			{ID: uuid.New(), UserID: bob.ID, DeviceName: "phone", VpnIP: "10.100.0.10"},
+		},
+	}
+
+	upstream := startFakeUpstream(t)
+
+	srv := NewServer(&Config{
+		Enabled:   true,
+		Zone:      DefaultZone,
+		Listen:    "127.0.0.1:0",
+		Upstreams: []string{upstream},
+		Networks:  []string{"127.0.0.0/8", "10.100.0.0/16"},
+	}, store, store)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+	return srv, store
+}
+
+// startFakeUpstream answers every A query with 192.0.2.1
+func startFakeUpstream(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen upstream: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			hdr, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: hdr.ID, Response: true})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			b.AResource(dnsmessage.ResourceHeader{
+				Name:  q.Name,
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			}, dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})
+			resp, err := b.Finish()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func query(t *testing.T, addr, name string) (dnsmessage.RCode, []dnsmessage.Resource) {
+	t.Helper()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatalf("build query: %v", err)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf[:n]); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	if resp.ID != 42 {
+		t.Errorf("response ID = %d, want 42", resp.ID)
+	}
+	return resp.RCode, resp.Answers
+}
+
+func TestServer_ResolvesOwnDevices(t *testing.T) {
+	srv, _ := startResolver(t)
+
+	rcode, answers := query(t, srv.Addr(), "home-pc.alice.roamie.internal.")
+	if rcode != dnsmessage.RCodeSuccess || len(answers) != 1 {
+		t.Fatalf("rcode = %v, answers = %v", rcode, answers)
+	}
+	if a := answers[0].Body.(*dnsmessage.AResource).A; a != [4]byte{10, 100, 0, 3} {
+		t.Errorf("A = %v, want 10.100.0.3", a)
+	}
+
+	// Lookups are case-insensitive
+	if rcode, _ := query(t, srv.Addr(), "LAPTOP.Alice.roamie.internal."); rcode != dnsmessage.RCodeSuccess {
+		t.Errorf("mixed case rcode = %v", rcode)
+	}
+}
+
+func TestServer_HidesOtherUsers(t *testing.T) {
+	srv, _ := startResolver(t)
+
+	rcode, answers := query(t, srv.Addr(), "phone.bob.roamie.internal.")
+	if rcode != dnsmessage.RCodeNameError || len(answers) != 0 {
+		t.Errorf("rcode = %v, answers = %v, want NXDOMAIN", rcode, answers)
+	}
+}
+
+func TestServer_ForwardsOutsideZone(t *testing.T) {
+	srv, _ := startResolver(t)
+
+	rcode, answers := query(t, srv.Addr(), "example.com.")
+	if rcode != dnsmessage.RCodeSuccess || len(answers) != 1 {
+		t.Fatalf("rcode = %v, answers = %v", rcode, answers)
+	}
+	if a := answers[0].Body.(*dnsmessage.AResource).A; a != [4]byte{192, 0, 2, 1} {
+		t.Errorf("A = %v, want upstream answer 192.0.2.1", a)
+	}
+}
+
+func TestServer_ForwardsWithoutDeviceLookup(t *testing.T) {
+	srv, store := startResolver(t)
+
+	if rcode, _ := query(t, srv.Addr(), "example.com."); rcode != dnsmessage.RCodeSuccess {
+		t.Fatalf("rcode = %v", rcode)
+	}
+	if n := store.lookups.Load(); n != 0 {
+		t.Errorf("looked up the requester %d times for a name outside the zone", n)
+	}
+}
+
+func TestServer_CachesRequester(t *testing.T) {
+	srv, store := startResolver(t)
+
+	for range 3 {
+		if rcode, _ := query(t, srv.Addr(), "home-pc.alice.roamie.internal."); rcode != dnsmessage.RCodeSuccess {
+			t.Fatalf("rcode = %v", rcode)
+		}
+	}
+	if n := store.lookups.Load(); n != 1 {
+		t.Errorf("looked up the requester %d times, want 1", n)
+	}
+}
+
+func TestServer_RefusesUnknownSources(t *testing.T) {
+	// No device has the loopback address the test queries come from
+	store := &fakeStore{devices: []models.Device{{ID: uuid.New(), DeviceName: "laptop", VpnIP: "10.100.0.2"}}}
+	upstream := startFakeUpstream(t)
+	start := func(networks ...string) *Server {
+		srv := NewServer(&Config{
+			Enabled:   true,
+			Zone:      DefaultZone,
+			Listen:    "127.0.0.1:0",
+			Upstreams: []string{upstream},
+			Networks:  networks,
+		}, store, store)
+		if err := srv.Start(); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		t.Cleanup(srv.Stop)
+		return srv
+	}
+
+	// Outside the VPN networks nothing is answered
+	outside := start("10.100.0.0/16")
+	for _, name := range []string{"example.com.", "laptop.alice.roamie.internal."} {
+		if rcode, answers := query(t, outside.Addr(), name); rcode != dnsmessage.RCodeRefused || len(answers) != 0 {
+			t.Errorf("%s: rcode = %v, answers = %v, want REFUSED", name, rcode, answers)
+		}
+	}
+
+	// Inside them, zone names are only answered to devices
+	inside := start("127.0.0.0/8")
+	if rcode, answers := query(t, inside.Addr(), "laptop.alice.roamie.internal."); rcode != dnsmessage.RCodeRefused || len(answers) != 0 {
+		t.Errorf("rcode = %v, answers = %v, want REFUSED", rcode, answers)
+	}
+}
+
+func TestConfigFromEnv_OptIn(t *testing.T) {
+	t.Setenv("DNS_ENABLED", "")
+	if ConfigFromEnv("10.100.0.1").Enabled {
+		t.Error("resolver enabled without DNS_ENABLED")
+	}
+	t.Setenv("DNS_ENABLED", "true")
+	if cfg := ConfigFromEnv("10.100.0.1"); !cfg.Enabled || cfg.Listen != "10.100.0.1:53" {
+		t.Errorf("config = %+v", cfg)
+	}
+}
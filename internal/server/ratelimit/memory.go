@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in memory. Limits are per server instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*memoryCounter
+}
+
+type memoryCounter struct {
+	windowStart time.Time
+	window      time.Duration
+	current     int
+	previous    int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]*memoryCounter)}
+}
+
+// Add implements Store
+func (s *MemoryStore) Add(ctx context.Context, key string, windowStart time.Time, window time.Duration, n int) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	switch {
+	case !ok:
+		counter = &memoryCounter{windowStart: windowStart, window: window}
+		s.counters[key] = counter
+	case counter.windowStart.Equal(windowStart):
+		// Same window
+	case counter.windowStart.Equal(windowStart.Add(-window)):
+		counter.previous, counter.current = counter.current, 0
+		counter.windowStart = windowStart
+	default:
+		counter.previous, counter.current = 0, 0
+		counter.windowStart = windowStart
+	}
+
+	counter.current += n
+	return counter.current, counter.previous, nil
+}
+
+// Reset implements Store
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	return nil
+}
+
+// Cleanup implements Store
+func (s *MemoryStore) Cleanup(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, counter := range s.counters {
+		// Once two windows have passed neither count is used again
+		if counter.windowStart.Add(2 * counter.window).Before(now) {
+			delete(s.counters, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Package ratelimit implements sliding window rate limits on top of a
+// pluggable counter store, so limits can be shared between server instances.
+//
+// Windows use the sliding window counter approximation: hits are counted in
+// fixed windows and the previous window's count is weighted by how much of
+// it still overlaps the sliding window.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store keeps hit counters per key and fixed window
+type Store interface {
+	// Add adds n hits (n may be 0) to key in the window starting at
+	// windowStart and returns the hits in that window and the one before it
+	Add(ctx context.Context, key string, windowStart time.Time, window time.Duration, n int) (current, previous int, err error)
+
+	// Reset forgets all hits for key
+	Reset(ctx context.Context, key string) error
+
+	// Cleanup drops counters that can no longer affect a limit
+	Cleanup(ctx context.Context, now time.Time) error
+}
+
+// Limit allows Requests hits per sliding Window. A zero limit is unlimited.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+func (l Limit) String() string {
+	if l.Requests == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Window)
+}
+
+// ParseLimit parses limits written as "requests/window" (e.g. "5/15m"), or
+// "off" for no limit
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return Limit{}, nil
+	}
+
+	requests, window, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("expected requests/window, e.g. 5/15m")
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("invalid request count %q", requests)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid window %q", window)
+	}
+	return Limit{Requests: n, Window: d}, nil
+}
+
+// Result is the outcome of a rate limit check
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // When the next hit will be allowed, if it isn't now
+}
+
+// ExceededError is returned by services when a limit is exceeded. It wraps a
+// sentinel error so callers can keep using errors.Is.
+type ExceededError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExceededError) Unwrap() error {
+	return e.Err
+}
+
+// Limiter applies one limit to many keys (client IPs, emails, challenges...)
+type Limiter struct {
+	store Store
+	name  string
+	limit Limit
+	now   func() time.Time
+}
+
+// New creates a limiter. name namespaces its keys in the store.
+func New(store Store, name string, limit Limit) *Limiter {
+	return &Limiter{
+		store: store,
+		name:  name,
+		limit: limit,
+		now:   time.Now,
+	}
+}
+
+// NewFromEnv creates a limiter whose limit can be overridden with
+// RATE_LIMIT_<NAME> (e.g. RATE_LIMIT_REQUEST_CODE_EMAIL=5/15m or "off")
+func NewFromEnv(store Store, name string, def Limit) *Limiter {
+	limit := def
+	envName := "RATE_LIMIT_" + strings.ToUpper(name)
+	if raw := os.Getenv(envName); raw != "" {
+		if parsed, err := ParseLimit(raw); err == nil {
+			limit = parsed
+		} else {
+			log.Printf("Warning: invalid %s %q (%v), using %s", envName, raw, err, def)
+		}
+	}
+	return New(store, name, limit)
+}
+
+// Limit returns the limit the limiter applies
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow counts a hit for key and reports whether it is within the limit
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.hit(ctx, key, 1)
+}
+
+// Check reports whether a hit for key would be allowed without counting one.
+// Lockouts use Check before an attempt and Allow to count failures.
+func (l *Limiter) Check(ctx context.Context, key string) (Result, error) {
+	return l.hit(ctx, key, 0)
+}
+
+// Reset forgets the hits for key, e.g. after a successful login
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	if l.limit.Requests == 0 {
+		return nil
+	}
+	return l.store.Reset(ctx, l.name+":"+key)
+}
+
+func (l *Limiter) hit(ctx context.Context, key string, n int) (Result, error) {
+	if l.limit.Requests == 0 {
+		return Result{Allowed: true, Remaining: math.MaxInt32}, nil
+	}
+
+	now := l.now().UTC()
+	windowStart := now.Truncate(l.limit.Window)
+	current, previous, err := l.store.Add(ctx, l.name+":"+key, windowStart, l.limit.Window, n)
+	if err != nil {
+		return Result{}, fmt.Errorf("rate limit store: %w", err)
+	}
+
+	elapsed := now.Sub(windowStart)
+	weight := 1 - float64(elapsed)/float64(l.limit.Window)
+	estimate := float64(previous)*weight + float64(current)
+
+	// Allow counts the hit itself; Check asks whether one more would fit
+	limit := float64(l.limit.Requests)
+	if estimate+float64(1-n) <= limit {
+		return Result{Allowed: true, Remaining: int(limit - estimate)}, nil
+	}
+
+	return Result{
+		Allowed:    false,
+		RetryAfter: retryAfter(l.limit, current, previous, elapsed),
+	}, nil
+}
+
+// retryAfter returns how long until one more hit fits, assuming no other hits
+func retryAfter(limit Limit, current, previous int, elapsed time.Duration) time.Duration {
+	n := float64(limit.Requests)
+	window := float64(limit.Window)
+
+	var wait float64
+	if float64(current)+1 > n {
+		// The current window alone is full: wait for the next one, until
+		// enough of this window's hits slide out of it
+		wait = (window - float64(elapsed)) + window*(1-(n-1)/float64(current))
+	} else {
+		// previous*(1-f) + current + 1 <= n
+		wait = window*(1-(n-float64(current)-1)/float64(previous)) - float64(elapsed)
+	}
+
+	if wait < 0 {
+		return 0
+	}
+	return time.Duration(wait)
+}
+
+// RunCleanup drops expired counters from store every interval until ctx is done
+func RunCleanup(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.Cleanup(ctx, time.Now().UTC()); err != nil {
+				log.Printf("Warning: failed to clean up rate limit counters: %v", err)
+			}
+		}
+	}
+}
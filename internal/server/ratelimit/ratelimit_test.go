@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Limit
+		wantErr bool
+	}{
+		{"5/15m", Limit{Requests: 5, Window: 15 * time.Minute}, false},
+		{" 100/1h ", Limit{Requests: 100, Window: time.Hour}, false},
+		{"off", Limit{}, false},
+		{"0", Limit{}, false},
+		{"5", Limit{}, true},
+		{"x/15m", Limit{}, true},
+		{"5/soon", Limit{}, true},
+		{"5/-1m", Limit{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func newTestLimiter(limit Limit, now *time.Time) *Limiter {
+	l := New(NewMemoryStore(), "test", limit)
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestLimiter_SlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestLimiter(Limit{Requests: 3, Window: time.Minute}, &now)
+
+	for i := 0; i < 3; i++ {
+		result, err := l.Allow(ctx, "192.0.2.1")
+		if err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("Hit %d should be allowed", i+1)
+		}
+	}
+
+	result, _ := l.Allow(ctx, "192.0.2.1")
+	if result.Allowed {
+		t.Fatal("Fourth hit should be rejected")
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > 2*time.Minute {
+		t.Errorf("Unexpected retry delay %s", result.RetryAfter)
+	}
+
+	// Test: keys are limited independently
+	if result, _ := l.Allow(ctx, "192.0.2.2"); !result.Allowed {
+		t.Error("Other keys should not be limited")
+	}
+
+	// Test: early in the next window the previous hits still count
+	now = now.Add(time.Minute + 5*time.Second)
+	if result, _ := l.Check(ctx, "192.0.2.1"); result.Allowed {
+		t.Error("Hits from the previous window should still count")
+	}
+
+	// Test: once they have slid out, hits are allowed again
+	now = now.Add(time.Minute)
+	if result, _ := l.Allow(ctx, "192.0.2.1"); !result.Allowed {
+		t.Error("Hits should be allowed after two windows")
+	}
+}
+
+func TestLimiter_RetryAfterIsAccurate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 40, 0, time.UTC)
+	l := newTestLimiter(Limit{Requests: 4, Window: time.Minute}, &now)
+
+	for i := 0; i < 4; i++ {
+		l.Allow(ctx, "key")
+	}
+	result, _ := l.Check(ctx, "key")
+	if result.Allowed {
+		t.Fatal("Limit should be reached")
+	}
+
+	now = now.Add(result.RetryAfter - time.Second)
+	if result, _ := l.Check(ctx, "key"); result.Allowed {
+		t.Error("Should still be limited just before RetryAfter")
+	}
+
+	now = now.Add(2 * time.Second)
+	if result, _ := l.Check(ctx, "key"); !result.Allowed {
+		t.Error("Should be allowed right after RetryAfter")
+	}
+}
+
+func TestLimiter_Lockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestLimiter(Limit{Requests: 2, Window: 15 * time.Minute}, &now)
+
+	// Check never counts
+	for i := 0; i < 5; i++ {
+		if result, _ := l.Check(ctx, "user@example.com"); !result.Allowed {
+			t.Fatal("Check should not count hits")
+		}
+	}
+
+	// Failures are counted with Allow until Check reports a lockout
+	l.Allow(ctx, "user@example.com")
+	l.Allow(ctx, "user@example.com")
+	if result, _ := l.Check(ctx, "user@example.com"); result.Allowed {
+		t.Fatal("Should be locked out after two failures")
+	}
+
+	// Test: a reset (successful attempt) lifts the lockout
+	if err := l.Reset(ctx, "user@example.com"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if result, _ := l.Check(ctx, "user@example.com"); !result.Allowed {
+		t.Error("Reset should lift the lockout")
+	}
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := newTestLimiter(Limit{}, &now)
+
+	for i := 0; i < 1000; i++ {
+		if result, _ := l.Allow(ctx, "key"); !result.Allowed {
+			t.Fatal("A zero limit should not limit")
+		}
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	t.Setenv("RATE_LIMIT_LOGIN_IP", "7/2m")
+	if got := NewFromEnv(NewMemoryStore(), "login_ip", Limit{Requests: 30, Window: 15 * time.Minute}).Limit(); got != (Limit{Requests: 7, Window: 2 * time.Minute}) {
+		t.Errorf("Expected the environment override, got %v", got)
+	}
+
+	t.Setenv("RATE_LIMIT_LOGIN_IP", "lots")
+	if got := NewFromEnv(NewMemoryStore(), "login_ip", Limit{Requests: 30, Window: 15 * time.Minute}).Limit(); got.Requests != 30 {
+		t.Errorf("Invalid overrides should fall back to the default, got %v", got)
+	}
+}
+
+func TestMemoryStore_Cleanup(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store.Add(ctx, "short", start, time.Minute, 1)
+	store.Add(ctx, "long", start, time.Hour, 1)
+
+	if err := store.Cleanup(ctx, start.Add(3*time.Minute)); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, ok := store.counters["short"]; ok {
+		t.Error("Expired counter should be removed")
+	}
+	if _, ok := store.counters["long"]; !ok {
+		t.Error("Live counter should be kept")
+	}
+}
@@ -2,22 +2,43 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/kamikazebr/roamie-desktop/internal/server/ratelimit"
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/kamikazebr/roamie-desktop/pkg/utils"
 	"github.com/google/uuid"
 )
 
+// ErrEmailLoginDisabled is returned by RequestCode when no email service is
+// configured (self-hosted deployments without Resend)
+var ErrEmailLoginDisabled = errors.New("email login is not configured")
+
+// ErrTooManyCodeAttempts is returned by VerifyCode once an email has had too
+// many invalid codes; it comes wrapped in a *ratelimit.ExceededError
+var ErrTooManyCodeAttempts = errors.New("too many invalid codes, try again later")
+
+// Invalid email codes allowed per email before VerifyCode locks it out
+const (
+	maxVerifyCodeFailures   = 5
+	verifyCodeFailureWindow = 15 * time.Minute
+)
+
 type AuthService struct {
 	authRepo     *storage.AuthRepository
 	userRepo     *storage.UserRepository
-	emailService *EmailService
+	identityRepo *storage.IdentityRepository
+	emailService *EmailService // nil when email is not configured
 	subnetPool   *SubnetPool
+	signingKeys  *SigningKeyService
+	codeAttempts *ratelimit.Limiter
 }
 
 func NewAuthService(
@@ -31,10 +52,65 @@ func NewAuthService(
 		userRepo:     userRepo,
 		emailService: emailService,
 		subnetPool:   subnetPool,
+		codeAttempts: newCodeAttemptsLimiter(ratelimit.NewMemoryStore()),
+	}
+}
+
+func newCodeAttemptsLimiter(store ratelimit.Store) *ratelimit.Limiter {
+	return ratelimit.New(store, "verify_code_failures", ratelimit.Limit{Requests: maxVerifyCodeFailures, Window: verifyCodeFailureWindow})
+}
+
+// SetRateLimitStore shares the invalid code lockout through store (optional,
+// in memory by default)
+func (s *AuthService) SetRateLimitStore(store ratelimit.Store) {
+	s.codeAttempts = newCodeAttemptsLimiter(store)
+}
+
+// SetSigningKeys switches token issuing from the shared JWT_SECRET (HS256) to
+// rotating Ed25519 signing keys
+func (s *AuthService) SetSigningKeys(signingKeys *SigningKeyService) {
+	s.signingKeys = signingKeys
+}
+
+// SetIdentities enables linking identity provider subjects to users, so a
+// user is found by provider subject even after their email changes
+func (s *AuthService) SetIdentities(identityRepo *storage.IdentityRepository) {
+	s.identityRepo = identityRepo
+}
+
+// issueToken signs an access token with the current signing key, or with
+// JWT_SECRET when no signing keys are configured
+func (s *AuthService) issueToken(claims utils.Claims) (string, error) {
+	if s.signingKeys != nil {
+		token, err := s.signingKeys.Sign(claims)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate JWT: %w", err)
+		}
+		return token, nil
 	}
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", fmt.Errorf("JWT_SECRET not configured")
+	}
+
+	token, err := utils.SignHS256(claims, jwtSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate JWT: %w", err)
+	}
+	return token, nil
+}
+
+// EmailLoginEnabled reports whether login codes can be sent by email
+func (s *AuthService) EmailLoginEnabled() bool {
+	return s.emailService != nil
 }
 
 func (s *AuthService) RequestCode(ctx context.Context, email string) (int, error) {
+	if s.emailService == nil {
+		return 0, ErrEmailLoginDisabled
+	}
+
 	if !utils.IsValidEmail(email) {
 		return 0, fmt.Errorf("invalid email format")
 	}
@@ -73,97 +149,98 @@ func (s *AuthService) RequestCode(ctx context.Context, email string) (int, error
 }
 
 func (s *AuthService) VerifyCode(ctx context.Context, email, code string) (string, time.Time, error) {
+	user, err := s.AuthenticateCode(ctx, email, code)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Generate JWT
+	expirationStr := os.Getenv("JWT_EXPIRATION")
+	if expirationStr == "" {
+		expirationStr = "168h" // 7 days default
+	}
+
+	expiration, err := time.ParseDuration(expirationStr)
+	if err != nil {
+		expiration = 168 * time.Hour
+	}
+
+	token, err := s.issueToken(utils.NewClaims(user.ID, uuid.Nil, user.Email, expiration))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().UTC().Add(expiration)
+	return token, expiresAt, nil
+}
+
+// AuthenticateCode consumes a login code sent by email and returns its user,
+// creating the user on first login
+func (s *AuthService) AuthenticateCode(ctx context.Context, email, code string) (*models.User, error) {
 	if !utils.IsValidEmail(email) {
-		return "", time.Time{}, fmt.Errorf("invalid email format")
+		return nil, fmt.Errorf("invalid email format")
+	}
+
+	// Lock the email out after repeated invalid codes so they can't be guessed
+	attemptKey := strings.ToLower(strings.TrimSpace(email))
+	attempts, err := s.codeAttempts.Check(ctx, attemptKey)
+	if err != nil {
+		return nil, err
+	}
+	if !attempts.Allowed {
+		return nil, &ratelimit.ExceededError{Err: ErrTooManyCodeAttempts, RetryAfter: attempts.RetryAfter}
 	}
 
 	// Get valid code
 	authCode, err := s.authRepo.GetValidCode(ctx, email, code)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("database error: %w", err)
+		return nil, fmt.Errorf("database error: %w", err)
 	}
 
 	if authCode == nil {
+		if result, err := s.codeAttempts.Allow(ctx, attemptKey); err != nil {
+			log.Printf("Warning: failed to record invalid code: %v", err)
+		} else if !result.Allowed {
+			log.Printf("🚨 SECURITY: %s locked out of code login for %s after invalid codes", attemptKey, result.RetryAfter.Round(time.Second))
+		}
+
 		// Check if code exists but is invalid (expired or used)
 		anyCode, err := s.authRepo.GetCode(ctx, email, code)
 		if err != nil {
-			return "", time.Time{}, fmt.Errorf("database error: %w", err)
+			return nil, fmt.Errorf("database error: %w", err)
 		}
 
 		if anyCode != nil {
 			if anyCode.Used {
-				return "", time.Time{}, fmt.Errorf("code has already been used")
+				return nil, fmt.Errorf("code has already been used")
 			}
 			if anyCode.ExpiresAt.Before(time.Now().UTC()) {
-				return "", time.Time{}, fmt.Errorf("code has expired")
+				return nil, fmt.Errorf("code has expired")
 			}
 		}
-		return "", time.Time{}, fmt.Errorf("invalid code")
+		return nil, fmt.Errorf("invalid code")
 	}
 
 	// Mark code as used
 	if err := s.authRepo.MarkCodeUsed(ctx, authCode.ID.String()); err != nil {
-		return "", time.Time{}, fmt.Errorf("failed to mark code as used: %w", err)
+		return nil, fmt.Errorf("failed to mark code as used: %w", err)
 	}
+	s.codeAttempts.Reset(ctx, attemptKey)
 
 	// Get or create user
 	user, err := s.userRepo.GetByEmail(ctx, email)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	if user == nil {
-		// Create new user with allocated subnet
-		subnet, err := s.subnetPool.AllocateSubnet(ctx)
+		user, err = s.createUser(ctx, email, "")
 		if err != nil {
-			return "", time.Time{}, fmt.Errorf("failed to allocate subnet: %w", err)
+			return nil, err
 		}
-
-		maxDevices := 5
-		if envMax := os.Getenv("MAX_DEVICES_PER_USER"); envMax != "" {
-			if max, err := strconv.Atoi(envMax); err == nil {
-				maxDevices = max
-			}
-		}
-
-		user = &models.User{
-			Email:      email,
-			Subnet:     subnet,
-			MaxDevices: maxDevices,
-			Active:     true,
-		}
-
-		if err := s.userRepo.Create(ctx, user); err != nil {
-			return "", time.Time{}, fmt.Errorf("failed to create user: %w", err)
-		}
-
-		// Send welcome email (non-blocking, ignore errors)
-		go s.emailService.SendWelcomeEmail(email, subnet)
-	}
-
-	// Generate JWT
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", time.Time{}, fmt.Errorf("JWT_SECRET not configured")
 	}
 
-	expirationStr := os.Getenv("JWT_EXPIRATION")
-	if expirationStr == "" {
-		expirationStr = "168h" // 7 days default
-	}
-
-	expiration, err := time.ParseDuration(expirationStr)
-	if err != nil {
-		expiration = 168 * time.Hour
-	}
-
-	token, err := utils.GenerateJWT(user.ID, user.Email, jwtSecret, expiration)
-	if err != nil {
-		return "", time.Time{}, fmt.Errorf("failed to generate JWT: %w", err)
-	}
-
-	expiresAt := time.Now().UTC().Add(expiration)
-	return token, expiresAt, nil
+	return user, nil
 }
 
 func (s *AuthService) CleanupExpiredCodes(ctx context.Context) error {
@@ -182,40 +259,134 @@ func (s *AuthService) GetUserByID(ctx context.Context, userID uuid.UUID) (*model
 	return user, nil
 }
 
-// GenerateToken generates a JWT token for a user
+// GenerateToken generates a JWT token for a user that is not bound to a device
 func (s *AuthService) GenerateToken(userID uuid.UUID, email string) (string, time.Time, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", time.Time{}, fmt.Errorf("JWT_SECRET not configured")
+	// Use 30 days expiration for user tokens (no refresh token to renew them)
+	expiration := 30 * 24 * time.Hour
+
+	token, err := s.issueToken(utils.NewClaims(userID, uuid.Nil, email, expiration))
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
-	// Use 30 days expiration for device tokens
-	expiration := 30 * 24 * time.Hour
+	expiresAt := time.Now().UTC().Add(expiration)
+	return token, expiresAt, nil
+}
+
+// GenerateDeviceToken generates a short-lived access token for a session,
+// bound to a device (uuid.Nil for clients without a registered device).
+// Clients renew it with the session's refresh token; the lifetime comes from
+// ACCESS_TOKEN_TTL (default 15m).
+func (s *AuthService) GenerateDeviceToken(userID, deviceID, sessionID uuid.UUID, email string) (string, time.Time, error) {
+	expiration := AccessTokenTTL()
 
-	token, err := utils.GenerateJWT(userID, email, jwtSecret, expiration)
+	claims := utils.NewClaims(userID, deviceID, email, expiration)
+	claims.SessionID = sessionID
+
+	token, err := s.issueToken(claims)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("failed to generate JWT: %w", err)
+		return "", time.Time{}, err
 	}
 
 	expiresAt := time.Now().UTC().Add(expiration)
 	return token, expiresAt, nil
 }
 
-// GetOrCreateUserByFirebaseUID gets or creates a user by email (primary identifier)
-// Firebase UID is stored but not used for lookup to support account switching on same device
+// AccessTokenTTL returns the lifetime of device access tokens
+func AccessTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 15 * time.Minute
+}
+
+// GetOrCreateUserByFirebaseUID gets or creates the user of a Firebase account,
+// see GetOrCreateUserByIdentity
 func (s *AuthService) GetOrCreateUserByFirebaseUID(ctx context.Context, firebaseUID, email string) (*models.User, error) {
-	// Try to get existing user by EMAIL (not Firebase UID)
-	// This ensures each email gets a unique subnet, even if Firebase UID is reused
+	return s.GetOrCreateUserByIdentity(ctx, &Identity{
+		Provider:      "firebase",
+		Subject:       firebaseUID,
+		Email:         email,
+		EmailVerified: true,
+	})
+}
+
+// GetOrCreateUserByIdentity finds the user an identity provider vouched for:
+// first by linked provider subject, then by email (the primary identifier,
+// which only links when the provider verified it). New users get a subnet.
+// The identity is linked to the user for the next login.
+func (s *AuthService) GetOrCreateUserByIdentity(ctx context.Context, identity *Identity) (*models.User, error) {
+	var user *models.User
+	var err error
+
+	if s.identityRepo != nil {
+		user, err = s.identityRepo.GetUser(ctx, identity.Provider, identity.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user by identity: %w", err)
+		}
+	}
+
+	if user == nil {
+		// Unverified emails could claim someone else's account (or reserve
+		// it before they sign up), so they never match or create a user
+		if !identity.EmailVerified {
+			return nil, ErrEmailNotVerified
+		}
+
+		// Lookup by EMAIL (not provider subject) ensures each email gets a
+		// unique subnet, even if a subject is reused
+		user, err = s.userRepo.GetByEmail(ctx, identity.Email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user by email: %w", err)
+		}
+	}
+
+	if user == nil {
+		firebaseUID := ""
+		if identity.Provider == "firebase" {
+			firebaseUID = identity.Subject
+		}
+		user, err = s.createUser(ctx, identity.Email, firebaseUID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.identityRepo != nil {
+		link := &models.UserIdentity{
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			UserID:   user.ID,
+			Email:    identity.Email,
+		}
+		if err := s.identityRepo.Link(ctx, link); err != nil {
+			return nil, fmt.Errorf("failed to link identity: %w", err)
+		}
+	}
+
+	return user, nil
+}
+
+// GetOrCreateUserByEmail gets a user by email, creating it with a subnet if
+// needed (used to create local accounts)
+func (s *AuthService) GetOrCreateUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if !utils.IsValidEmail(email) {
+		return nil, fmt.Errorf("invalid email format")
+	}
+
 	user, err := s.userRepo.GetByEmail(ctx, email)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user by email: %w", err)
 	}
-
 	if user != nil {
 		return user, nil
 	}
+	return s.createUser(ctx, email, "")
+}
 
-	// User doesn't exist, create new user with allocated subnet
+// createUser creates a user with an allocated subnet and sends the welcome
+// email. firebaseUID is stored on the user when not empty.
+func (s *AuthService) createUser(ctx context.Context, email, firebaseUID string) (*models.User, error) {
 	subnet, err := s.subnetPool.AllocateSubnet(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate subnet: %w", err)
@@ -228,19 +399,26 @@ func (s *AuthService) GetOrCreateUserByFirebaseUID(ctx context.Context, firebase
 		}
 	}
 
-	user = &models.User{
+	user := &models.User{
 		Email:      email,
 		Subnet:     subnet,
 		MaxDevices: maxDevices,
 		Active:     true,
 	}
 
-	if err := s.userRepo.CreateWithFirebaseUID(ctx, user, firebaseUID); err != nil {
+	if firebaseUID != "" {
+		err = s.userRepo.CreateWithFirebaseUID(ctx, user, firebaseUID)
+	} else {
+		err = s.userRepo.Create(ctx, user)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
 	// Send welcome email (non-blocking, ignore errors)
-	go s.emailService.SendWelcomeEmail(email, subnet)
+	if s.emailService != nil {
+		go s.emailService.SendWelcomeEmail(email, subnet)
+	}
 
 	return user, nil
 }
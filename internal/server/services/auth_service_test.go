@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/kamikazebr/roamie-desktop/internal/testutil"
@@ -220,3 +221,68 @@ func TestAuthService_GetUserByID_NotFound(t *testing.T) {
 		t.Errorf("Expected error '%s', got '%v'", expectedErr, err)
 	}
 }
+
+// --- GetOrCreateUserByIdentity tests ---
+
+func TestAuthService_GetOrCreateUserByIdentity_LinksSubject(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	service := setupAuthService(t, tdb)
+	service.SetIdentities(tdb.Repositories().Identities)
+
+	identity := &Identity{
+		Provider:      "oidc",
+		Subject:       "oidc-" + uuid.New().String(),
+		Email:         testutil.GenerateTestEmail(),
+		EmailVerified: true,
+	}
+
+	user, err := service.GetOrCreateUserByIdentity(ctx, identity)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	defer tdb.DeleteTestUser(ctx, user.ID)
+
+	// The provider subject keeps finding the user after the email changes,
+	// even while the new email is unverified
+	identity.Email = testutil.GenerateTestEmail()
+	identity.EmailVerified = false
+	again, err := service.GetOrCreateUserByIdentity(ctx, identity)
+	if err != nil {
+		t.Fatalf("Failed to get user by subject: %v", err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("Expected user %s, got %s", user.ID, again.ID)
+	}
+}
+
+func TestAuthService_GetOrCreateUserByIdentity_UnverifiedEmail(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	service := setupAuthService(t, tdb)
+	service.SetIdentities(tdb.Repositories().Identities)
+
+	email := testutil.GenerateTestEmail()
+	existingUser := tdb.CreateTestUser(ctx, email, testutil.GenerateTestSubnet(104))
+	defer tdb.DeleteTestUser(ctx, existingUser.ID)
+
+	// An unverified email must not take over the existing account
+	_, err := service.GetOrCreateUserByIdentity(ctx, &Identity{
+		Provider: "oidc",
+		Subject:  "oidc-" + uuid.New().String(),
+		Email:    email,
+	})
+	if !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("Expected ErrEmailNotVerified, got %v", err)
+	}
+}
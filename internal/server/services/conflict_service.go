@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+// maxReportedNetworks caps how many networks a single client report may contain
+const maxReportedNetworks = 256
+
+// ErrNoSubnetConflict is returned when re-homing a subnet that has no reported conflicts
+var ErrNoSubnetConflict = errors.New("subnet has no reported conflicts")
+
+// ConflictService handles network conflicts reported by client devices and
+// re-homes a user's subnet when it overlaps their local networks
+type ConflictService struct {
+	conflictRepo *storage.ConflictRepository
+	userRepo     *storage.UserRepository
+	deviceRepo   *storage.DeviceRepository
+	subnetPool   *SubnetPool
+	wgManager    WireGuardManager
+	routeService *RouteService
+}
+
+func NewConflictService(
+	conflictRepo *storage.ConflictRepository,
+	userRepo *storage.UserRepository,
+	deviceRepo *storage.DeviceRepository,
+	subnetPool *SubnetPool,
+	wgManager WireGuardManager,
+) *ConflictService {
+	return &ConflictService{
+		conflictRepo: conflictRepo,
+		userRepo:     userRepo,
+		deviceRepo:   deviceRepo,
+		subnetPool:   subnetPool,
+		wgManager:    wgManager,
+	}
+}
+
+// SetRouteService sets the route service (used to restore subnet routes and
+// exit node rules after re-homing)
+func (s *ConflictService) SetRouteService(routeService *RouteService) {
+	s.routeService = routeService
+}
+
+// ReportConflicts stores the local networks scanned by a device and returns
+// the ones that overlap the user's current subnet
+func (s *ConflictService) ReportConflicts(ctx context.Context, userID, deviceID uuid.UUID, networks []models.LocalNetwork) (*models.ReportConflictsResponse, error) {
+	if len(networks) > maxReportedNetworks {
+		return nil, fmt.Errorf("too many networks reported (max %d)", maxReportedNetworks)
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	conflicts := make([]models.NetworkConflict, 0, len(networks))
+	cidrs := make([]string, 0, len(networks))
+	for _, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.CIDR)
+		if err != nil || ipNet.IP.To4() == nil {
+			continue // Only IPv4 ranges can collide with the VPN pool
+		}
+		cidr := ipNet.String()
+		conflicts = append(conflicts, models.NetworkConflict{
+			CIDR:        cidr,
+			Source:      "client-" + n.Source,
+			Description: n.Description,
+			Active:      true,
+		})
+		cidrs = append(cidrs, cidr)
+	}
+
+	if err := s.conflictRepo.ReplaceForDevice(ctx, userID, deviceID, conflicts); err != nil {
+		return nil, fmt.Errorf("failed to save conflicts: %w", err)
+	}
+
+	overlapping := OverlappingCIDRs(user.Subnet, cidrs)
+	if len(overlapping) > 0 {
+		log.Printf("Device %s reported %d network(s) overlapping subnet %s: %v",
+			deviceID, len(overlapping), user.Subnet, overlapping)
+	}
+
+	return &models.ReportConflictsResponse{
+		UserSubnet:  user.Subnet,
+		Overlapping: overlapping,
+		Recorded:    len(conflicts),
+	}, nil
+}
+
+// RehomeUserSubnet moves a user to a new subnet that avoids every network
+// reported by their devices, re-addressing each device and its WireGuard peer
+func (s *ConflictService) RehomeUserSubnet(ctx context.Context, userID uuid.UUID) (*models.RehomeSubnetResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	reported, err := s.conflictRepo.GetCIDRsByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reported conflicts: %w", err)
+	}
+	if len(OverlappingCIDRs(user.Subnet, reported)) == 0 {
+		return nil, ErrNoSubnetConflict
+	}
+
+	newSubnet, err := s.subnetPool.AllocateSubnetAvoiding(ctx, reported)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate subnet: %w", err)
+	}
+
+	devices, err := s.deviceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user devices: %w", err)
+	}
+
+	// Plan new addresses before touching anything
+	var assigned []string
+	result := &models.RehomeSubnetResponse{
+		OldSubnet: user.Subnet,
+		NewSubnet: newSubnet,
+	}
+	for _, device := range devices {
+		ip, err := s.subnetPool.GetNextAvailableIP(ctx, newSubnet, assigned)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate IP for device %s: %w", device.ID, err)
+		}
+		assigned = append(assigned, ip)
+		result.Devices = append(result.Devices, models.RehomedDevice{
+			DeviceID: device.ID.String(),
+			OldVpnIP: device.VpnIP,
+			NewVpnIP: ip,
+		})
+	}
+
+	// Re-address the WireGuard peers first (AddPeer replaces the allowed IPs
+	// of an existing peer) so that a failure leaves the database untouched
+	vpnIPs := make(map[uuid.UUID]string, len(devices))
+	for i, device := range devices {
+		newIP := result.Devices[i].NewVpnIP
+		vpnIPs[device.ID] = newIP
+		if s.wgManager == nil {
+			continue
+		}
+		if err := s.wgManager.AddPeer(device.PublicKey, newIP); err != nil {
+			s.restorePeers(ctx, userID, devices[:i])
+			return nil, fmt.Errorf("failed to update WireGuard peer for device %s: %w", device.ID, err)
+		}
+	}
+
+	if err := s.userRepo.RehomeSubnet(ctx, userID, newSubnet, vpnIPs); err != nil {
+		s.restorePeers(ctx, userID, devices)
+		return nil, fmt.Errorf("failed to re-home user subnet: %w", err)
+	}
+
+	// Re-addressing reset peer allowed IPs and invalidated exit node rules
+	if s.routeService != nil {
+		s.routeService.ReapplyUser(ctx, userID, result.Devices)
+	}
+
+	log.Printf("Re-homed user %s from %s to %s (%d devices)", userID, user.Subnet, newSubnet, len(devices))
+	return result, nil
+}
+
+// restorePeers puts devices' WireGuard peers back on their current VPN IPs
+// after a failed re-home, along with their approved routes
+func (s *ConflictService) restorePeers(ctx context.Context, userID uuid.UUID, devices []models.Device) {
+	if s.wgManager == nil {
+		return
+	}
+	for _, device := range devices {
+		if err := s.wgManager.AddPeer(device.PublicKey, device.VpnIP); err != nil {
+			log.Printf("Warning: failed to restore WireGuard peer for device %s: %v", device.ID, err)
+		}
+	}
+	if s.routeService != nil {
+		s.routeService.ReapplyUser(ctx, userID, nil)
+	}
+}
+
+// GetUserConflicts returns the active networks reported by a user's devices
+func (s *ConflictService) GetUserConflicts(ctx context.Context, userID uuid.UUID) ([]models.NetworkConflict, error) {
+	return s.conflictRepo.GetByUser(ctx, userID)
+}
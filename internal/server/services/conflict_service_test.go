@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+)
+
+// fakeWireGuard records peers and fails for one public key
+type fakeWireGuard struct {
+	peers   map[string]string
+	failKey string
+}
+
+func (f *fakeWireGuard) AddPeer(publicKey, vpnIP string) error {
+	if publicKey == f.failKey {
+		return errors.New("wg set failed")
+	}
+	f.peers[publicKey] = vpnIP
+	return nil
+}
+
+func (f *fakeWireGuard) RemovePeer(publicKey string) error {
+	delete(f.peers, publicKey)
+	return nil
+}
+
+func TestConflictService_RehomeUserSubnet(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+
+	t.Setenv("WG_BASE_NETWORK", "10.200.0.0/16")
+	t.Setenv("WG_SUBNET_SIZE", "29")
+
+	subnetPool, err := NewSubnetPool(repos.Users, repos.Conflicts)
+	if err != nil {
+		t.Fatalf("Failed to create subnet pool: %v", err)
+	}
+
+	wg := &fakeWireGuard{peers: make(map[string]string)}
+	service := NewConflictService(repos.Conflicts, repos.Users, repos.Devices, subnetPool, wg)
+
+	testUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(30))
+	defer tdb.DeleteTestUser(ctx, testUser.ID)
+
+	laptop := tdb.CreateTestDevice(ctx, testUser.ID, "laptop", "10.200.30.2")
+	phone := tdb.CreateTestDevice(ctx, testUser.ID, "phone", "10.200.30.3")
+
+	// Test: nothing to do without a reported conflict
+	if _, err := service.RehomeUserSubnet(ctx, testUser.ID); !errors.Is(err, ErrNoSubnetConflict) {
+		t.Fatalf("Expected ErrNoSubnetConflict, got %v", err)
+	}
+
+	_, err = service.ReportConflicts(ctx, testUser.ID, laptop.ID, []models.LocalNetwork{
+		{CIDR: "10.200.30.0/24", Source: "interface", Description: "office"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to report conflicts: %v", err)
+	}
+
+	// Test: a WireGuard failure leaves the database and the other peers as they were
+	wg.failKey = phone.PublicKey
+	if _, err := service.RehomeUserSubnet(ctx, testUser.ID); err == nil {
+		t.Fatal("Expected re-home to fail when WireGuard fails")
+	}
+	user, _ := repos.Users.GetByID(ctx, testUser.ID)
+	if user.Subnet != testUser.Subnet {
+		t.Errorf("Expected subnet %s after failed re-home, got %s", testUser.Subnet, user.Subnet)
+	}
+	for _, device := range []*models.Device{laptop, phone} {
+		stored, _ := repos.Devices.GetByID(ctx, device.ID)
+		if stored.VpnIP != device.VpnIP {
+			t.Errorf("Expected %s to keep %s, got %s", device.DeviceName, device.VpnIP, stored.VpnIP)
+		}
+		if ip, ok := wg.peers[device.PublicKey]; ok && ip != device.VpnIP {
+			t.Errorf("Expected peer of %s restored to %s, got %s", device.DeviceName, device.VpnIP, ip)
+		}
+	}
+
+	// Test: a successful re-home moves the user and every device together
+	wg.failKey = ""
+	result, err := service.RehomeUserSubnet(ctx, testUser.ID)
+	if err != nil {
+		t.Fatalf("Failed to re-home: %v", err)
+	}
+	if len(OverlappingCIDRs(result.NewSubnet, []string{"10.200.30.0/24"})) != 0 {
+		t.Errorf("New subnet %s still overlaps the reported network", result.NewSubnet)
+	}
+	user, _ = repos.Users.GetByID(ctx, testUser.ID)
+	if user.Subnet != result.NewSubnet {
+		t.Errorf("Expected subnet %s, got %s", result.NewSubnet, user.Subnet)
+	}
+	for _, rehomed := range result.Devices {
+		if len(OverlappingCIDRs(result.NewSubnet, []string{rehomed.NewVpnIP + "/32"})) == 0 {
+			t.Errorf("Device IP %s is outside %s", rehomed.NewVpnIP, result.NewSubnet)
+		}
+	}
+	for _, device := range []*models.Device{laptop, phone} {
+		stored, _ := repos.Devices.GetByID(ctx, device.ID)
+		if wg.peers[device.PublicKey] != stored.VpnIP {
+			t.Errorf("Expected peer of %s on %s, got %s", device.DeviceName, stored.VpnIP, wg.peers[device.PublicKey])
+		}
+	}
+}
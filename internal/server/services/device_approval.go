@@ -0,0 +1,380 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMaxPendingChallengesPerIP = 5
+	defaultOrgApprovers              = 2
+)
+
+var (
+	ErrTooManyPendingChallenges = errors.New("too many pending device requests from this address")
+	ErrChallengeForAnotherUser  = errors.New("device request is for another user")
+	ErrChallengeOwnerRequired   = errors.New("device request doesn't name a user, an email is required")
+)
+
+// ApprovalPolicy decides how device challenges are approved. The zero value
+// keeps the original behavior: one approval by the user, no auto-approval and
+// no cap on pending requests.
+type ApprovalPolicy struct {
+	// Requests naming a known user are approved automatically when they come
+	// from a network trusted for that user
+	TrustedNetworks []TrustedNetwork
+
+	// Also auto-approve from an IP one of the user's live sessions was last used from
+	TrustSessionIPs bool
+
+	// Devices of users whose email is in one of these domains need
+	// OrgApprovers distinct approvers: the user and admins
+	OrgDomains   []string
+	OrgApprovers int
+
+	// Pending requests allowed per client IP (0 = unlimited)
+	MaxPendingPerIP int
+}
+
+// TrustedNetwork trusts a network for the devices of one user, or of every
+// user in an email domain. Trust is never global: anyone on the network could
+// otherwise name any user and join their network.
+type TrustedNetwork struct {
+	Network *net.IPNet
+	Scope   string // a user's email ("alice@example.com") or a domain ("@example.com")
+}
+
+// Trusts reports whether the network is trusted for the user with email
+func (n TrustedNetwork) Trusts(email string) bool {
+	email = strings.ToLower(email)
+	if strings.HasPrefix(n.Scope, "@") {
+		return strings.HasSuffix(email, n.Scope)
+	}
+	return email == n.Scope
+}
+
+// ApprovalPolicyFromEnv reads the DEVICE_APPROVAL_* settings
+func ApprovalPolicyFromEnv() *ApprovalPolicy {
+	policy := &ApprovalPolicy{
+		TrustSessionIPs: os.Getenv("DEVICE_APPROVAL_TRUST_SESSION_IPS") == "true",
+		OrgApprovers:    defaultOrgApprovers,
+		MaxPendingPerIP: defaultMaxPendingChallengesPerIP,
+	}
+
+	if raw := os.Getenv("DEVICE_APPROVAL_TRUSTED_NETWORKS"); raw != "" {
+		for _, entry := range strings.Split(raw, ",") {
+			trusted, err := parseTrustedNetwork(strings.TrimSpace(entry))
+			if err != nil {
+				log.Printf("Warning: ignoring DEVICE_APPROVAL_TRUSTED_NETWORKS entry %q: %v", entry, err)
+				continue
+			}
+			policy.TrustedNetworks = append(policy.TrustedNetworks, trusted)
+		}
+	}
+
+	if raw := os.Getenv("DEVICE_APPROVAL_ORG_DOMAINS"); raw != "" {
+		for _, domain := range strings.Split(raw, ",") {
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+				policy.OrgDomains = append(policy.OrgDomains, domain)
+			}
+		}
+	}
+
+	if raw := os.Getenv("DEVICE_APPROVAL_ORG_APPROVERS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 1 {
+			policy.OrgApprovers = n
+		} else {
+			log.Printf("Warning: invalid DEVICE_APPROVAL_ORG_APPROVERS %q, using %d", raw, defaultOrgApprovers)
+		}
+	}
+
+	if raw := os.Getenv("DEVICE_REQUEST_MAX_PENDING_PER_IP"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			policy.MaxPendingPerIP = n
+		} else {
+			log.Printf("Warning: invalid DEVICE_REQUEST_MAX_PENDING_PER_IP %q, using %d", raw, defaultMaxPendingChallengesPerIP)
+		}
+	}
+
+	return policy
+}
+
+// parseTrustedNetwork parses "<email or @domain>=<CIDR or IP>"
+func parseTrustedNetwork(value string) (TrustedNetwork, error) {
+	scope, address, ok := strings.Cut(value, "=")
+	scope = strings.ToLower(strings.TrimSpace(scope))
+	if !ok || !strings.Contains(scope, "@") || strings.HasSuffix(scope, "@") {
+		return TrustedNetwork{}, fmt.Errorf("expected <email or @domain>=<network>")
+	}
+	network, err := parseNetwork(strings.TrimSpace(address))
+	if err != nil {
+		return TrustedNetwork{}, err
+	}
+	return TrustedNetwork{Network: network, Scope: scope}, nil
+}
+
+// parseNetwork parses a CIDR or a single IP address
+func parseNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("not an IP address or CIDR")
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+// RequiredApprovals returns how many distinct approvers a device joining the
+// network of the user with this email needs
+func (p *ApprovalPolicy) RequiredApprovals(email string) int {
+	if p == nil || p.OrgApprovers <= 1 {
+		return 1
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return 1
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, orgDomain := range p.OrgDomains {
+		if domain == orgDomain {
+			return p.OrgApprovers
+		}
+	}
+	return 1
+}
+
+// IsTrustedNetwork reports whether ipAddress is in a network trusted for the
+// user with email
+func (p *ApprovalPolicy) IsTrustedNetwork(email, ipAddress string) bool {
+	if p == nil {
+		return false
+	}
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return false
+	}
+	for _, trusted := range p.TrustedNetworks {
+		if trusted.Network.Contains(ip) && trusted.Trusts(email) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChallengeReview is a user's or admin's decision on a device challenge
+type ChallengeReview struct {
+	ReviewerID uuid.UUID
+	Approved   bool
+
+	// Admins review on behalf of the user the device joins. OwnerID picks
+	// that user for challenges that didn't name one.
+	Admin   bool
+	OwnerID *uuid.UUID
+}
+
+// ChallengeReviewResult is the state of a challenge after a review
+type ChallengeReviewResult struct {
+	Status            string // pending (waiting for more approvers), approved or denied
+	Approvals         int
+	RequiredApprovals int
+}
+
+// SetApprovalPolicy sets the policy for approving device challenges (optional)
+func (s *DeviceAuthService) SetApprovalPolicy(policy *ApprovalPolicy) {
+	s.approvalPolicy = policy
+}
+
+// resolveRequestedUser looks up the user a device request names. Unknown
+// emails are ignored so requests can't be used to probe for accounts.
+func (s *DeviceAuthService) resolveRequestedUser(ctx context.Context, email string) *models.User {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || s.userRepo == nil {
+		return nil
+	}
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil || user == nil || !user.Active {
+		return nil
+	}
+	return user
+}
+
+// ReviewChallenge records a decision on a pending challenge. Users may only
+// review challenges for their own network; a challenge that didn't name a
+// user joins the network of the first user to approve it. A single denial
+// denies the challenge; approval completes once the required number of
+// distinct approvers is reached.
+func (s *DeviceAuthService) ReviewChallenge(
+	ctx context.Context,
+	challengeID uuid.UUID,
+	review ChallengeReview,
+	wgManager WireGuardManager,
+	deviceRepo DeviceRepository,
+) (*ChallengeReviewResult, error) {
+	challenge, err := s.deviceAuthRepo.GetChallenge(ctx, challengeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenge: %w", err)
+	}
+	if challenge == nil {
+		return nil, fmt.Errorf("challenge not found")
+	}
+
+	status := "denied"
+	if review.Approved {
+		status = "approved"
+	}
+
+	// Idempotency: If already processed with same decision, return success
+	if challenge.Status == status {
+		fmt.Printf("[DeviceAuth] Challenge %s already %s, returning success (idempotent)\n", challengeID, status)
+		return &ChallengeReviewResult{Status: status, RequiredApprovals: challenge.RequiredApprovals}, nil
+	}
+
+	// If expired, return specific error
+	if challenge.Status == "expired" || time.Now().After(challenge.ExpiresAt) {
+		return nil, fmt.Errorf("challenge has expired")
+	}
+
+	// If already processed with different decision, return error
+	if challenge.Status != "pending" {
+		return nil, fmt.Errorf("challenge already processed with different decision")
+	}
+
+	// Work out whose network the device joins
+	var ownerID uuid.UUID
+	switch {
+	case challenge.RequestedUserID != nil:
+		ownerID = *challenge.RequestedUserID
+		if !review.Admin && review.ReviewerID != ownerID {
+			return nil, ErrChallengeForAnotherUser
+		}
+		if review.Admin && review.OwnerID != nil && *review.OwnerID != ownerID {
+			return nil, ErrChallengeForAnotherUser
+		}
+	case review.Admin:
+		if review.OwnerID == nil {
+			return nil, ErrChallengeOwnerRequired
+		}
+		ownerID = *review.OwnerID
+	default:
+		ownerID = review.ReviewerID
+	}
+
+	if !review.Approved {
+		if err := s.deviceAuthRepo.UpdateChallengeStatus(ctx, challengeID, "denied", &ownerID); err != nil {
+			return nil, fmt.Errorf("failed to update challenge status: %w", err)
+		}
+		return &ChallengeReviewResult{Status: "denied", RequiredApprovals: challenge.RequiredApprovals}, nil
+	}
+
+	// The first approval of a challenge that didn't name a user fixes the owner
+	if challenge.RequestedUserID == nil {
+		required := 1
+		if s.userRepo != nil {
+			if owner, err := s.userRepo.GetByID(ctx, ownerID); err == nil && owner != nil {
+				required = s.approvalPolicy.RequiredApprovals(owner.Email)
+			}
+		}
+		set, err := s.deviceAuthRepo.SetChallengeOwner(ctx, challengeID, ownerID, required)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set challenge owner: %w", err)
+		}
+		if !set {
+			// Someone else claimed it first
+			return nil, ErrChallengeForAnotherUser
+		}
+		challenge.RequiredApprovals = required
+	}
+
+	approvals := 1
+	if challenge.RequiredApprovals > 1 {
+		approvals, err = s.deviceAuthRepo.AddChallengeApproval(ctx, challengeID, review.ReviewerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record approval: %w", err)
+		}
+		if approvals < challenge.RequiredApprovals {
+			log.Printf("[DeviceAuth] Challenge %s approved by %s (%d/%d approvals)", challengeID, review.ReviewerID, approvals, challenge.RequiredApprovals)
+			return &ChallengeReviewResult{Status: "pending", Approvals: approvals, RequiredApprovals: challenge.RequiredApprovals}, nil
+		}
+	}
+
+	if err := s.completeApproval(ctx, challengeID, ownerID, wgManager, deviceRepo); err != nil {
+		return nil, err
+	}
+
+	return &ChallengeReviewResult{Status: "approved", Approvals: approvals, RequiredApprovals: challenge.RequiredApprovals}, nil
+}
+
+// AutoApproveChallenge approves a challenge without user interaction when the
+// approval policy trusts the address it came from for the user it names. Only
+// challenges that name a user and need a single approver qualify.
+func (s *DeviceAuthService) AutoApproveChallenge(
+	ctx context.Context,
+	challenge *models.DeviceAuthChallenge,
+	wgManager WireGuardManager,
+	deviceRepo DeviceRepository,
+) (bool, error) {
+	policy := s.approvalPolicy
+	if policy == nil || challenge.RequestedUserID == nil || challenge.RequiredApprovals > 1 {
+		return false, nil
+	}
+
+	ownerID := *challenge.RequestedUserID
+	trusted := false
+	if len(policy.TrustedNetworks) > 0 && s.userRepo != nil {
+		owner, err := s.userRepo.GetByID(ctx, ownerID)
+		if err != nil {
+			return false, fmt.Errorf("failed to get user: %w", err)
+		}
+		trusted = owner != nil && policy.IsTrustedNetwork(owner.Email, challenge.IPAddress)
+	}
+	if !trusted && policy.TrustSessionIPs {
+		fromSession, err := s.deviceAuthRepo.HasSessionFromIP(ctx, ownerID, challenge.IPAddress)
+		if err != nil {
+			return false, fmt.Errorf("failed to check sessions: %w", err)
+		}
+		trusted = fromSession
+	}
+	if !trusted {
+		return false, nil
+	}
+
+	if err := s.completeApproval(ctx, challenge.ID, ownerID, wgManager, deviceRepo); err != nil {
+		return false, err
+	}
+
+	log.Printf("[DeviceAuth] Challenge %s auto-approved for user %s from trusted address %s", challenge.ID, ownerID, challenge.IPAddress)
+	return true, nil
+}
+
+// ListChallengesForUser lists pending challenges that asked to join userID's network
+func (s *DeviceAuthService) ListChallengesForUser(ctx context.Context, userID uuid.UUID) ([]*models.DeviceAuthChallenge, error) {
+	s.deviceAuthRepo.ExpireOldChallenges(ctx)
+
+	challenges, err := s.deviceAuthRepo.ListPendingChallengesForUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending challenges: %w", err)
+	}
+
+	return challenges, nil
+}
+
+// ListChallengeApprovers returns the emails of users who approved a challenge so far
+func (s *DeviceAuthService) ListChallengeApprovers(ctx context.Context, challengeID uuid.UUID) ([]string, error) {
+	return s.deviceAuthRepo.ListChallengeApprovers(ctx, challengeID)
+}
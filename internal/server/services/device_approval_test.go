@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/google/uuid"
+)
+
+func TestApprovalPolicyFromEnv(t *testing.T) {
+	t.Setenv("DEVICE_APPROVAL_TRUSTED_NETWORKS", "@Example.com=10.0.0.0/8, alice@example.org=203.0.113.7, 192.168.1.0/24, bob@example.org=not-a-network")
+	t.Setenv("DEVICE_APPROVAL_TRUST_SESSION_IPS", "true")
+	t.Setenv("DEVICE_APPROVAL_ORG_DOMAINS", "Example.com")
+	t.Setenv("DEVICE_APPROVAL_ORG_APPROVERS", "")
+	t.Setenv("DEVICE_REQUEST_MAX_PENDING_PER_IP", "3")
+
+	policy := ApprovalPolicyFromEnv()
+
+	if len(policy.TrustedNetworks) != 2 {
+		t.Fatalf("Expected 2 trusted networks, got %d", len(policy.TrustedNetworks))
+	}
+	for _, tc := range []struct {
+		email, ip string
+		want      bool
+	}{
+		{"carol@example.com", "10.1.2.3", true},
+		{"alice@example.org", "203.0.113.7", true},
+		{"alice@example.org", "203.0.113.8", false},
+		{"alice@example.org", "10.1.2.3", false},
+		{"bob@example.org", "203.0.113.7", false},
+		{"carol@notexample.com", "10.1.2.3", false},
+		{"bob@example.org", "192.168.1.10", false},
+		{"carol@example.com", "garbage", false},
+	} {
+		if got := policy.IsTrustedNetwork(tc.email, tc.ip); got != tc.want {
+			t.Errorf("IsTrustedNetwork(%q, %q) = %v, want %v", tc.email, tc.ip, got, tc.want)
+		}
+	}
+
+	if !policy.TrustSessionIPs {
+		t.Error("Expected session IPs to be trusted")
+	}
+	if policy.MaxPendingPerIP != 3 {
+		t.Errorf("MaxPendingPerIP = %d, want 3", policy.MaxPendingPerIP)
+	}
+
+	if got := policy.RequiredApprovals("alice@EXAMPLE.com"); got != defaultOrgApprovers {
+		t.Errorf("Org user needs %d approvals, want %d", got, defaultOrgApprovers)
+	}
+	if got := policy.RequiredApprovals("bob@example.org"); got != 1 {
+		t.Errorf("Non-org user needs %d approvals, want 1", got)
+	}
+}
+
+func TestApprovalPolicy_NilKeepsDefaults(t *testing.T) {
+	var policy *ApprovalPolicy
+	if policy.RequiredApprovals("alice@example.com") != 1 {
+		t.Error("Nil policy should require a single approval")
+	}
+	if policy.IsTrustedNetwork("alice@example.com", "10.0.0.1") {
+		t.Error("Nil policy should trust no networks")
+	}
+}
+
+func TestDeviceAuthService_ReviewChallenge(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+
+	t.Setenv("WG_BASE_NETWORK", "10.200.0.0/16")
+	t.Setenv("WG_SUBNET_SIZE", "29")
+
+	subnetPool, err := NewSubnetPool(repos.Users, repos.Conflicts)
+	if err != nil {
+		t.Fatalf("Failed to create subnet pool: %v", err)
+	}
+
+	service := NewDeviceAuthService(repos.DeviceAuth, repos.Users)
+	service.SetDeviceService(NewDeviceService(repos.Devices, repos.Users, subnetPool, repos.DeviceAuth))
+
+	// GenerateTestEmail uses example.com, the org domain here
+	orgUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(107))
+	defer tdb.DeleteTestUser(ctx, orgUser.ID)
+	admin := tdb.CreateTestUser(ctx, strings.Replace(testutil.GenerateTestEmail(), "example.com", "example.org", 1), testutil.GenerateTestSubnet(108))
+	defer tdb.DeleteTestUser(ctx, admin.ID)
+	other := tdb.CreateTestUser(ctx, strings.Replace(testutil.GenerateTestEmail(), "example.com", "example.org", 1), testutil.GenerateTestSubnet(34))
+	defer tdb.DeleteTestUser(ctx, other.ID)
+
+	// The network is trusted for the admin's devices only
+	trusted, _ := parseTrustedNetwork(admin.Email + "=198.51.100.0/24")
+	service.SetApprovalPolicy(&ApprovalPolicy{
+		TrustedNetworks: []TrustedNetwork{trusted},
+		OrgDomains:      []string{"example.com"},
+		OrgApprovers:    2,
+		MaxPendingPerIP: 2,
+	})
+
+	newChallenge := func(ip, email string) uuid.UUID {
+		publicKey := testutil.GenerateTestWireGuardKey()
+		challenge, err := service.CreateChallenge(ctx, uuid.New(), "laptop", ip, nil, &publicKey, nil, nil, email)
+		if err != nil {
+			t.Fatalf("CreateChallenge failed: %v", err)
+		}
+		return challenge.ID
+	}
+
+	// Test: org devices need the user and an admin
+	challengeID := newChallenge("192.0.2.10", orgUser.Email)
+	if _, err := service.ReviewChallenge(ctx, challengeID, ChallengeReview{ReviewerID: admin.ID, Approved: true}, nil, nil); !errors.Is(err, ErrChallengeForAnotherUser) {
+		t.Errorf("Expected ErrChallengeForAnotherUser for another user, got %v", err)
+	}
+
+	result, err := service.ReviewChallenge(ctx, challengeID, ChallengeReview{ReviewerID: orgUser.ID, Approved: true}, nil, nil)
+	if err != nil {
+		t.Fatalf("ReviewChallenge failed: %v", err)
+	}
+	if result.Status != "pending" || result.Approvals != 1 || result.RequiredApprovals != 2 {
+		t.Errorf("Expected 1/2 pending approvals, got %+v", result)
+	}
+
+	// Approving twice doesn't count twice
+	if result, _ := service.ReviewChallenge(ctx, challengeID, ChallengeReview{ReviewerID: orgUser.ID, Approved: true}, nil, nil); result.Status != "pending" {
+		t.Errorf("Expected a repeated approval to stay pending, got %s", result.Status)
+	}
+
+	result, err = service.ReviewChallenge(ctx, challengeID, ChallengeReview{ReviewerID: admin.ID, Approved: true, Admin: true}, nil, nil)
+	if err != nil {
+		t.Fatalf("Admin ReviewChallenge failed: %v", err)
+	}
+	if result.Status != "approved" {
+		t.Errorf("Expected approved after the admin, got %s", result.Status)
+	}
+	challenge, _ := service.GetChallenge(ctx, challengeID)
+	if challenge.UserID == nil || *challenge.UserID != orgUser.ID {
+		t.Error("Device should join the requested user's network")
+	}
+
+	// Test: admins must pick a user for challenges that don't name one
+	challengeID = newChallenge("192.0.2.11", "")
+	if _, err := service.ReviewChallenge(ctx, challengeID, ChallengeReview{ReviewerID: admin.ID, Approved: true, Admin: true}, nil, nil); !errors.Is(err, ErrChallengeOwnerRequired) {
+		t.Errorf("Expected ErrChallengeOwnerRequired, got %v", err)
+	}
+	if result, err := service.ReviewChallenge(ctx, challengeID, ChallengeReview{ReviewerID: admin.ID, Approved: false, Admin: true, OwnerID: &admin.ID}, nil, nil); err != nil || result.Status != "denied" {
+		t.Errorf("Expected admin denial, got %+v, %v", result, err)
+	}
+
+	// Test: requests from a trusted network are approved automatically for
+	// the user it is trusted for; requests naming another user stay pending,
+	// as do org users who still need a second approver
+	for _, tc := range []struct {
+		email string
+		want  bool
+	}{
+		{admin.Email, true},
+		{other.Email, false},
+		{orgUser.Email, false},
+		{"", false},
+	} {
+		challengeID := newChallenge("198.51.100.20", tc.email)
+		challenge, _ := service.GetChallenge(ctx, challengeID)
+		approved, err := service.AutoApproveChallenge(ctx, challenge, nil, nil)
+		if err != nil {
+			t.Fatalf("AutoApproveChallenge failed: %v", err)
+		}
+		if approved != tc.want {
+			t.Errorf("AutoApproveChallenge(%q) = %v, want %v", tc.email, approved, tc.want)
+		}
+		if challenge, _ := service.GetChallenge(ctx, challengeID); !tc.want && challenge.Status != "pending" {
+			t.Errorf("Request naming %q should stay pending, got %s", tc.email, challenge.Status)
+		}
+	}
+
+	// Test: pending requests per IP are capped
+	newChallenge("192.0.2.12", "")
+	newChallenge("192.0.2.12", "")
+	if _, err := service.CreateChallenge(ctx, uuid.New(), "laptop", "192.0.2.12", nil, nil, nil, nil, ""); !errors.Is(err, ErrTooManyPendingChallenges) {
+		t.Errorf("Expected ErrTooManyPendingChallenges, got %v", err)
+	}
+}
@@ -4,18 +4,25 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"log"
+	"os"
 	"time"
 
+	"github.com/kamikazebr/roamie-desktop/internal/server/ratelimit"
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/google/uuid"
 )
 
 type DeviceAuthService struct {
-	deviceAuthRepo *storage.DeviceAuthRepository
-	userRepo       *storage.UserRepository
-	deviceService  *DeviceService
+	deviceAuthRepo    *storage.DeviceAuthRepository
+	userRepo          *storage.UserRepository
+	deviceService     *DeviceService
+	enrollmentKeyRepo *storage.EnrollmentKeyRepository
+	approvalPolicy    *ApprovalPolicy
+	userCodeAttempts  *ratelimit.Limiter
 }
 
 func NewDeviceAuthService(
@@ -23,9 +30,10 @@ func NewDeviceAuthService(
 	userRepo *storage.UserRepository,
 ) *DeviceAuthService {
 	return &DeviceAuthService{
-		deviceAuthRepo: deviceAuthRepo,
-		userRepo:       userRepo,
-		deviceService:  nil, // Will be set later to avoid circular dependency
+		deviceAuthRepo:   deviceAuthRepo,
+		userRepo:         userRepo,
+		deviceService:    nil, // Will be set later to avoid circular dependency
+		userCodeAttempts: newUserCodeAttemptsLimiter(ratelimit.NewMemoryStore()),
 	}
 }
 
@@ -34,23 +42,65 @@ func (s *DeviceAuthService) SetDeviceService(deviceService *DeviceService) {
 	s.deviceService = deviceService
 }
 
-// CreateChallenge creates a new device authorization challenge
-func (s *DeviceAuthService) CreateChallenge(ctx context.Context, deviceID uuid.UUID, hostname, ipAddress string, username *string, publicKey *string, osType *string, hardwareID *string) (*models.DeviceAuthChallenge, error) {
+// SetRateLimitStore shares the invalid user code lockout through store
+// (optional, in memory by default)
+func (s *DeviceAuthService) SetRateLimitStore(store ratelimit.Store) {
+	s.userCodeAttempts = newUserCodeAttemptsLimiter(store)
+}
+
+// SetEnrollmentKeys enables enrollment keys (optional)
+func (s *DeviceAuthService) SetEnrollmentKeys(enrollmentKeyRepo *storage.EnrollmentKeyRepository) {
+	s.enrollmentKeyRepo = enrollmentKeyRepo
+}
+
+// CreateChallenge creates a new device authorization challenge. requestedEmail
+// optionally names the user whose network the device wants to join.
+func (s *DeviceAuthService) CreateChallenge(ctx context.Context, deviceID uuid.UUID, hostname, ipAddress string, username *string, publicKey *string, osType *string, hardwareID *string, requestedEmail string) (*models.DeviceAuthChallenge, error) {
+	if s.approvalPolicy != nil && s.approvalPolicy.MaxPendingPerIP > 0 {
+		pending, err := s.deviceAuthRepo.CountPendingChallengesByIP(ctx, ipAddress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count pending challenges: %w", err)
+		}
+		if pending >= s.approvalPolicy.MaxPendingPerIP {
+			return nil, ErrTooManyPendingChallenges
+		}
+	}
+
 	challenge := &models.DeviceAuthChallenge{
-		ID:         uuid.New(),
-		DeviceID:   deviceID,
-		Hostname:   hostname,
-		IPAddress:  ipAddress,
-		Username:   username,
-		PublicKey:  publicKey,
-		OSType:     osType,
-		HardwareID: hardwareID,
-		Status:     "pending",
-		ExpiresAt:  time.Now().Add(5 * time.Minute),
+		ID:                uuid.New(),
+		DeviceID:          deviceID,
+		Hostname:          hostname,
+		IPAddress:         ipAddress,
+		Username:          username,
+		PublicKey:         publicKey,
+		OSType:            osType,
+		HardwareID:        hardwareID,
+		Status:            "pending",
+		ExpiresAt:         time.Now().Add(5 * time.Minute),
+		RequiredApprovals: 1,
 	}
 
-	if err := s.deviceAuthRepo.CreateChallenge(ctx, challenge); err != nil {
-		return nil, fmt.Errorf("failed to create challenge: %w", err)
+	if user := s.resolveRequestedUser(ctx, requestedEmail); user != nil {
+		challenge.RequestedUserID = &user.ID
+		challenge.RequiredApprovals = s.approvalPolicy.RequiredApprovals(user.Email)
+	}
+
+	// Retry on the rare collision with another pending challenge's user code
+	for attempt := 1; ; attempt++ {
+		userCode, err := GenerateUserCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate user code: %w", err)
+		}
+		normalized := NormalizeUserCode(userCode)
+		challenge.UserCode = &normalized
+
+		err = s.deviceAuthRepo.CreateChallenge(ctx, challenge)
+		if err == nil {
+			break
+		}
+		if !storage.IsUniqueViolation(err) || attempt == 3 {
+			return nil, fmt.Errorf("failed to create challenge: %w", err)
+		}
 	}
 
 	return challenge, nil
@@ -89,7 +139,8 @@ func (s *DeviceAuthService) ListPendingChallenges(ctx context.Context) ([]*model
 	return challenges, nil
 }
 
-// ApproveChallenge approves or denies a challenge and auto-registers device if public_key present
+// ApproveChallenge approves or denies a challenge as userID, the user whose
+// network the device joins. See ReviewChallenge for multi-approver challenges.
 func (s *DeviceAuthService) ApproveChallenge(
 	ctx context.Context,
 	challengeID, userID uuid.UUID,
@@ -97,43 +148,25 @@ func (s *DeviceAuthService) ApproveChallenge(
 	wgManager WireGuardManager,
 	deviceRepo DeviceRepository,
 ) error {
-	// First, check current challenge status for idempotency
-	challenge, err := s.deviceAuthRepo.GetChallenge(ctx, challengeID)
-	if err != nil {
-		return fmt.Errorf("failed to get challenge: %w", err)
-	}
-	if challenge == nil {
-		return fmt.Errorf("challenge not found")
-	}
-
-	status := "denied"
-	if approved {
-		status = "approved"
-	}
-
-	// Idempotency: If already processed with same decision, return success
-	if challenge.Status == status {
-		fmt.Printf("[DeviceAuth] Challenge %s already %s, returning success (idempotent)\n", challengeID, status)
-		return nil
-	}
-
-	// If expired, return specific error
-	if challenge.Status == "expired" || time.Now().After(challenge.ExpiresAt) {
-		return fmt.Errorf("challenge has expired")
-	}
-
-	// If already processed with different decision, return error
-	if challenge.Status != "pending" {
-		return fmt.Errorf("challenge already processed with different decision")
-	}
+	_, err := s.ReviewChallenge(ctx, challengeID, ChallengeReview{ReviewerID: userID, Approved: approved}, wgManager, deviceRepo)
+	return err
+}
 
+// completeApproval marks a pending challenge approved for userID and
+// auto-registers the device if the challenge has a public key
+func (s *DeviceAuthService) completeApproval(
+	ctx context.Context,
+	challengeID, userID uuid.UUID,
+	wgManager WireGuardManager,
+	deviceRepo DeviceRepository,
+) error {
 	// Update challenge status
-	if err := s.deviceAuthRepo.UpdateChallengeStatus(ctx, challengeID, status, &userID); err != nil {
+	if err := s.deviceAuthRepo.UpdateChallengeStatus(ctx, challengeID, "approved", &userID); err != nil {
 		return fmt.Errorf("failed to update challenge status: %w", err)
 	}
 
 	// If approved and has public_key, auto-register WireGuard device
-	if approved && s.deviceService != nil {
+	if s.deviceService != nil {
 		challenge, err := s.deviceAuthRepo.GetChallenge(ctx, challengeID)
 		if err == nil && challenge != nil && challenge.PublicKey != nil && *challenge.PublicKey != "" {
 			// Register device with hostname as device name and fields from challenge
@@ -169,45 +202,133 @@ func (s *DeviceAuthService) ApproveChallenge(
 	return nil
 }
 
-// GenerateRefreshToken generates a new secure refresh token
-func (s *DeviceAuthService) GenerateRefreshToken(ctx context.Context, userID, deviceID uuid.UUID) (string, error) {
-	// Generate secure random token (64 bytes = 512 bits)
-	tokenBytes := make([]byte, 64)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		return "", fmt.Errorf("failed to generate random token: %w", err)
-	}
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrRefreshTokenRotated = errors.New("refresh token was already rotated")
+	ErrSessionNotFound     = errors.New("session not found")
+)
 
-	tokenString := base64.URLEncoding.EncodeToString(tokenBytes)
+// rotationGracePeriod tolerates two processes on the same device (daemon and
+// CLI) refreshing with the same token at once; the loser is told to reload
+// rather than having the whole family revoked.
+const rotationGracePeriod = 30 * time.Second
 
-	token := &models.RefreshToken{
-		ID:        uuid.New(),
-		UserID:    userID,
-		DeviceID:  deviceID,
-		Token:     tokenString,
-		ExpiresAt: time.Now().Add(365 * 24 * time.Hour), // 1 year
+// RefreshTokenTTL returns the refresh token lifetime. It slides: every
+// rotation issues a token valid for the full period.
+func RefreshTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 90 * 24 * time.Hour
+}
+
+// GenerateRefreshToken generates a new secure refresh token starting a new
+// session (token family) for a login from clientIP. deviceBound records that
+// deviceID is a registered device rather than a tunnel-only client.
+func (s *DeviceAuthService) GenerateRefreshToken(ctx context.Context, userID, deviceID uuid.UUID, deviceBound bool, clientIP string) (*models.RefreshToken, error) {
+	token, err := newRefreshToken(userID, deviceID, clientIP)
+	if err != nil {
+		return nil, err
 	}
+	token.DeviceBound = deviceBound
 
 	if err := s.deviceAuthRepo.CreateRefreshToken(ctx, token); err != nil {
-		return "", fmt.Errorf("failed to create refresh token: %w", err)
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
 	}
 
-	return tokenString, nil
+	return token, nil
 }
 
-// ValidateRefreshToken validates a refresh token and returns the associated data
-func (s *DeviceAuthService) ValidateRefreshToken(ctx context.Context, tokenString string) (*models.RefreshToken, error) {
-	token, err := s.deviceAuthRepo.GetRefreshToken(ctx, tokenString)
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. Presenting a token that was already rotated is treated as theft and
+// revokes the whole family (ErrRefreshTokenReused), unless it happened within
+// the grace period (ErrRefreshTokenRotated).
+func (s *DeviceAuthService) RotateRefreshToken(ctx context.Context, tokenString, clientIP string) (*models.RefreshToken, error) {
+	current, err := s.deviceAuthRepo.GetRefreshToken(ctx, tokenString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get refresh token: %w", err)
 	}
+	if current == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if current.RotatedAt != nil {
+		return nil, s.handleReuse(ctx, current)
+	}
+
+	next, err := newRefreshToken(current.UserID, current.DeviceID, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	next.FamilyID = current.FamilyID
+	next.SessionStartedAt = current.SessionStartedAt
+	next.DeviceBound = current.DeviceBound
+
+	rotated, err := s.deviceAuthRepo.RotateRefreshToken(ctx, current.ID, next)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		// Lost a race with a concurrent refresh of the same token
+		return nil, ErrRefreshTokenRotated
+	}
 
-	if token == nil {
-		return nil, fmt.Errorf("invalid or expired refresh token")
+	return next, nil
+}
+
+// handleReuse decides what a second use of a rotated token means
+func (s *DeviceAuthService) handleReuse(ctx context.Context, token *models.RefreshToken) error {
+	if time.Since(*token.RotatedAt) < rotationGracePeriod {
+		return ErrRefreshTokenRotated
 	}
 
-	// Update last used timestamp
-	s.deviceAuthRepo.UpdateRefreshTokenLastUsed(ctx, token.ID)
+	log.Printf("[DeviceAuth] Refresh token reuse for device %s (user %s), revoking token family %s",
+		token.DeviceID, token.UserID, token.FamilyID)
+	if err := s.deviceAuthRepo.DeleteRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+	return ErrRefreshTokenReused
+}
 
+// BindRefreshTokenFamily records that a session's device has been registered,
+// so that deleting the device revokes the session
+func (s *DeviceAuthService) BindRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	if err := s.deviceAuthRepo.BindRefreshTokenFamily(ctx, familyID); err != nil {
+		return fmt.Errorf("failed to bind token family: %w", err)
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token descended from the same login
+func (s *DeviceAuthService) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	if err := s.deviceAuthRepo.DeleteRefreshTokenFamily(ctx, familyID); err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+	return nil
+}
+
+// newRefreshToken builds the first token of a new session
+func newRefreshToken(userID, deviceID uuid.UUID, clientIP string) (*models.RefreshToken, error) {
+	// Generate secure random token (64 bytes = 512 bits)
+	tokenBytes := make([]byte, 64)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate random token: %w", err)
+	}
+
+	id := uuid.New()
+	token := &models.RefreshToken{
+		ID:               id,
+		UserID:           userID,
+		DeviceID:         deviceID,
+		Token:            base64.URLEncoding.EncodeToString(tokenBytes),
+		ExpiresAt:        time.Now().Add(RefreshTokenTTL()),
+		FamilyID:         id,
+		SessionStartedAt: time.Now(),
+	}
+	if clientIP != "" {
+		token.LastUsedIP = &clientIP
+	}
 	return token, nil
 }
 
@@ -220,12 +341,36 @@ func (s *DeviceAuthService) RevokeRefreshToken(ctx context.Context, tokenString
 	return nil
 }
 
-// ListUserDevices lists all devices (refresh tokens) for a user
-func (s *DeviceAuthService) ListUserDevices(ctx context.Context, userID uuid.UUID) ([]*models.RefreshToken, error) {
+// ListSessions lists a user's active sessions (the current refresh token of each family)
+func (s *DeviceAuthService) ListSessions(ctx context.Context, userID uuid.UUID) ([]*models.RefreshToken, error) {
 	tokens, err := s.deviceAuthRepo.ListUserRefreshTokens(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list user devices: %w", err)
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
 	}
 
 	return tokens, nil
 }
+
+// RevokeSession ends one of a user's sessions by revoking its token family
+func (s *DeviceAuthService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	deleted, err := s.deviceAuthRepo.DeleteUserRefreshTokenFamily(ctx, userID, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if !deleted {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions ends every session of a user except keepSessionID
+// (uuid.Nil ends all of them). Returns the number of sessions ended.
+func (s *DeviceAuthService) RevokeOtherSessions(ctx context.Context, userID, keepSessionID uuid.UUID) (int64, error) {
+	revoked, err := s.deviceAuthRepo.DeleteUserRefreshTokensExcept(ctx, userID, keepSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/google/uuid"
+)
+
+func TestDeviceAuthService_RotateRefreshToken(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+	service := NewDeviceAuthService(repos.DeviceAuth, repos.Users)
+
+	testUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(22))
+	defer tdb.DeleteTestUser(ctx, testUser.ID)
+
+	deviceID := uuid.New()
+	login, err := service.GenerateRefreshToken(ctx, testUser.ID, deviceID, false, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+	first := login.Token
+
+	// Test: rotation issues a new token in the same family
+	second, err := service.RotateRefreshToken(ctx, first, "192.0.2.10")
+	if err != nil {
+		t.Fatalf("Failed to rotate refresh token: %v", err)
+	}
+	if second.Token == first {
+		t.Error("Rotation should issue a new token")
+	}
+	if second.DeviceID != deviceID || second.UserID != testUser.ID {
+		t.Errorf("Rotated token lost its binding: %+v", second)
+	}
+	if second.FamilyID != login.FamilyID || !second.SessionStartedAt.Equal(login.SessionStartedAt) {
+		t.Errorf("Rotated token should continue the session: %+v", second)
+	}
+	if second.LastUsedIP == nil || *second.LastUsedIP != "192.0.2.10" {
+		t.Errorf("Expected last used IP to be tracked, got %v", second.LastUsedIP)
+	}
+
+	if second.DeviceBound {
+		t.Error("Rotated token of a tunnel-only client became device-bound")
+	}
+
+	// Test: reusing the old token within the grace period does not revoke
+	if _, err := service.RotateRefreshToken(ctx, first, "192.0.2.10"); !errors.Is(err, ErrRefreshTokenRotated) {
+		t.Errorf("Expected ErrRefreshTokenRotated, got %v", err)
+	}
+
+	// Test: reuse after the grace period revokes the whole family
+	tdb.Exec(ctx, `UPDATE refresh_tokens SET rotated_at = NOW() - INTERVAL '1 hour' WHERE family_id = $1 AND rotated_at IS NOT NULL`, second.FamilyID)
+	if _, err := service.RotateRefreshToken(ctx, first, "192.0.2.10"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := service.RotateRefreshToken(ctx, second.Token, "192.0.2.10"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected the newest token to be revoked with its family, got %v", err)
+	}
+
+	// Test: a device-bound session stays bound across rotations and once bound later
+	bound, err := service.GenerateRefreshToken(ctx, testUser.ID, uuid.New(), true, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+	if rotated, err := service.RotateRefreshToken(ctx, bound.Token, "192.0.2.1"); err != nil || !rotated.DeviceBound {
+		t.Errorf("Expected rotated token to stay device-bound, got %+v, %v", rotated, err)
+	}
+	unbound, err := service.GenerateRefreshToken(ctx, testUser.ID, uuid.New(), false, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+	if err := service.BindRefreshTokenFamily(ctx, unbound.FamilyID); err != nil {
+		t.Fatalf("Failed to bind token family: %v", err)
+	}
+	if rotated, err := service.RotateRefreshToken(ctx, unbound.Token, "192.0.2.1"); err != nil || !rotated.DeviceBound {
+		t.Errorf("Expected token to be device-bound after binding its family, got %+v, %v", rotated, err)
+	}
+
+	// Test: unknown tokens are rejected
+	if _, err := service.RotateRefreshToken(ctx, "not-a-token", "192.0.2.10"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestDeviceAuthService_Sessions(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+	service := NewDeviceAuthService(repos.DeviceAuth, repos.Users)
+
+	testUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(23))
+	defer tdb.DeleteTestUser(ctx, testUser.ID)
+	otherUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(24))
+	defer tdb.DeleteTestUser(ctx, otherUser.ID)
+
+	laptop, desktop, phone := uuid.New(), uuid.New(), uuid.New()
+	for _, deviceID := range []uuid.UUID{laptop, desktop, phone} {
+		if _, err := service.GenerateRefreshToken(ctx, testUser.ID, deviceID, false, "192.0.2.1"); err != nil {
+			t.Fatalf("Failed to generate refresh token: %v", err)
+		}
+	}
+
+	// Test: rotation keeps one session per login
+	sessions, err := service.ListSessions(ctx, testUser.ID)
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("Expected 3 sessions, got %d", len(sessions))
+	}
+	if _, err := service.RotateRefreshToken(ctx, sessions[0].Token, "192.0.2.2"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if sessions, _ = service.ListSessions(ctx, testUser.ID); len(sessions) != 3 {
+		t.Fatalf("Expected 3 sessions after rotation, got %d", len(sessions))
+	}
+
+	// Test: another user can't revoke the session
+	if err := service.RevokeSession(ctx, otherUser.ID, sessions[0].FamilyID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	if err := service.RevokeSession(ctx, testUser.ID, sessions[0].FamilyID); err != nil {
+		t.Fatalf("Failed to revoke session: %v", err)
+	}
+
+	// Test: sign out everywhere else keeps the caller's session
+	keep := sessions[1].FamilyID
+	revoked, err := service.RevokeOtherSessions(ctx, testUser.ID, keep)
+	if err != nil {
+		t.Fatalf("Failed to revoke other sessions: %v", err)
+	}
+	if revoked != 1 {
+		t.Errorf("Expected 1 revoked session, got %d", revoked)
+	}
+	sessions, _ = service.ListSessions(ctx, testUser.ID)
+	if len(sessions) != 1 || sessions[0].FamilyID != keep {
+		t.Errorf("Expected only the caller's session to remain, got %+v", sessions)
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+)
+
+const (
+	defaultEphemeralDeviceTimeout = 30 * time.Minute
+
+	// Anything shorter would reap devices between heartbeats (every 30s,
+	// online for 90s in DeviceCache)
+	minDeviceReapTimeout = 5 * time.Minute
+
+	deviceReapInterval = time.Minute
+)
+
+// DeviceReaper deletes devices that stopped checking in: ephemeral devices
+// after EPHEMERAL_DEVICE_TIMEOUT offline (default 30m), and any device after
+// DEVICE_MAX_AGE without a heartbeat (disabled by default). Deleting a device
+// removes its WireGuard peer and refresh tokens and frees its VPN IP and
+// tunnel port.
+type DeviceReaper struct {
+	deviceRepo    *storage.DeviceRepository
+	deviceService *DeviceService
+	deviceCache   *DeviceCache
+	wgManager     WireGuardManager
+
+	ephemeralTimeout time.Duration
+	maxAge           time.Duration // 0 = only ephemeral devices are reaped
+}
+
+func NewDeviceReaper(
+	deviceRepo *storage.DeviceRepository,
+	deviceService *DeviceService,
+	deviceCache *DeviceCache,
+	wgManager WireGuardManager,
+) *DeviceReaper {
+	ephemeralTimeout, maxAge := deviceReaperConfigFromEnv()
+	return &DeviceReaper{
+		deviceRepo:       deviceRepo,
+		deviceService:    deviceService,
+		deviceCache:      deviceCache,
+		wgManager:        wgManager,
+		ephemeralTimeout: ephemeralTimeout,
+		maxAge:           maxAge,
+	}
+}
+
+// deviceReaperConfigFromEnv reads EPHEMERAL_DEVICE_TIMEOUT and DEVICE_MAX_AGE
+func deviceReaperConfigFromEnv() (ephemeralTimeout, maxAge time.Duration) {
+	ephemeralTimeout = defaultEphemeralDeviceTimeout
+	if value := os.Getenv("EPHEMERAL_DEVICE_TIMEOUT"); value != "" {
+		if d, err := time.ParseDuration(value); err != nil {
+			log.Printf("Warning: invalid EPHEMERAL_DEVICE_TIMEOUT %q, using %s", value, ephemeralTimeout)
+		} else {
+			ephemeralTimeout = d
+		}
+	}
+	if ephemeralTimeout < minDeviceReapTimeout {
+		log.Printf("Warning: EPHEMERAL_DEVICE_TIMEOUT below %s, using %s", minDeviceReapTimeout, minDeviceReapTimeout)
+		ephemeralTimeout = minDeviceReapTimeout
+	}
+
+	if value := os.Getenv("DEVICE_MAX_AGE"); value != "" {
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			log.Printf("Warning: invalid DEVICE_MAX_AGE %q, not expiring devices", value)
+		} else if d > 0 && d < minDeviceReapTimeout {
+			log.Printf("Warning: DEVICE_MAX_AGE below %s, using %s", minDeviceReapTimeout, minDeviceReapTimeout)
+			maxAge = minDeviceReapTimeout
+		} else {
+			maxAge = d
+		}
+	}
+
+	return ephemeralTimeout, maxAge
+}
+
+// Run reaps offline devices every minute until ctx is done
+func (r *DeviceReaper) Run(ctx context.Context) {
+	if r.maxAge > 0 {
+		log.Printf("Device reaper: ephemeral devices expire after %s offline, all devices after %s", r.ephemeralTimeout, r.maxAge)
+	} else {
+		log.Printf("Device reaper: ephemeral devices expire after %s offline", r.ephemeralTimeout)
+	}
+
+	ticker := time.NewTicker(deviceReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Reap(ctx); err != nil {
+				log.Printf("Warning: device reaper failed: %v", err)
+			}
+		}
+	}
+}
+
+// Reap deletes the devices that have been offline too long, returning how
+// many were deleted
+func (r *DeviceReaper) Reap(ctx context.Context) (int, error) {
+	now := time.Now()
+	var anyBefore *time.Time
+	if r.maxAge > 0 {
+		cutoff := now.Add(-r.maxAge)
+		anyBefore = &cutoff
+	}
+
+	devices, err := r.deviceRepo.GetStale(ctx, now.Add(-r.ephemeralTimeout), anyBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get stale devices: %w", err)
+	}
+
+	reaped := 0
+	for i := range devices {
+		device := &devices[i]
+
+		// A heartbeat may have landed after the query
+		if r.deviceCache != nil && r.deviceCache.IsOnline(device.ID.String()) {
+			continue
+		}
+
+		if r.wgManager != nil {
+			if err := r.wgManager.RemovePeer(device.PublicKey); err != nil {
+				log.Printf("Warning: Failed to remove WireGuard peer for device %s: %v", device.ID, err)
+			}
+		}
+
+		if err := r.deviceService.DeleteDevice(ctx, device.ID, device.UserID); err != nil {
+			log.Printf("Warning: failed to reap device %s: %v", device.ID, err)
+			continue
+		}
+
+		kind := "device"
+		if device.Ephemeral {
+			kind = "ephemeral device"
+		}
+		log.Printf("Device reaper: deleted %s %s (%s, user %s), last seen %s ago",
+			kind, device.ID, device.Name(), device.UserID, now.Sub(device.LastSeen).Round(time.Second))
+		reaped++
+	}
+
+	return reaped, nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/google/uuid"
+)
+
+func TestDeviceReaperConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name             string
+		ephemeralTimeout string
+		maxAge           string
+		wantEphemeral    time.Duration
+		wantMaxAge       time.Duration
+	}{
+		{"defaults", "", "", defaultEphemeralDeviceTimeout, 0},
+		{"configured", "10m", "720h", 10 * time.Minute, 720 * time.Hour},
+		{"clamped to minimum", "30s", "1m", minDeviceReapTimeout, minDeviceReapTimeout},
+		{"invalid values", "soon", "-1h", defaultEphemeralDeviceTimeout, 0},
+		{"max age disabled", "", "0", defaultEphemeralDeviceTimeout, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EPHEMERAL_DEVICE_TIMEOUT", tt.ephemeralTimeout)
+			t.Setenv("DEVICE_MAX_AGE", tt.maxAge)
+
+			ephemeralTimeout, maxAge := deviceReaperConfigFromEnv()
+			if ephemeralTimeout != tt.wantEphemeral {
+				t.Errorf("ephemeral timeout = %s, want %s", ephemeralTimeout, tt.wantEphemeral)
+			}
+			if maxAge != tt.wantMaxAge {
+				t.Errorf("max age = %s, want %s", maxAge, tt.wantMaxAge)
+			}
+		})
+	}
+}
+
+func TestDeviceReaper_Reap(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+
+	t.Setenv("WG_BASE_NETWORK", "10.200.0.0/16")
+	t.Setenv("WG_SUBNET_SIZE", "29")
+	t.Setenv("EPHEMERAL_DEVICE_TIMEOUT", "")
+	t.Setenv("DEVICE_MAX_AGE", "")
+
+	subnetPool, err := NewSubnetPool(repos.Users, repos.Conflicts)
+	if err != nil {
+		t.Fatalf("Failed to create subnet pool: %v", err)
+	}
+
+	deviceService := NewDeviceService(repos.Devices, repos.Users, subnetPool, repos.DeviceAuth)
+	deviceCache := NewDeviceCache()
+	reaper := NewDeviceReaper(repos.Devices, deviceService, deviceCache, nil)
+
+	testUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(106))
+	defer tdb.DeleteTestUser(ctx, testUser.ID)
+
+	offline := tdb.CreateTestDevice(ctx, testUser.ID, "ci-offline", "10.200.106.2")
+	online := tdb.CreateTestDevice(ctx, testUser.ID, "ci-online", "10.200.106.3")
+	recent := tdb.CreateTestDevice(ctx, testUser.ID, "ci-recent", "10.200.106.4")
+	permanent := tdb.CreateTestDevice(ctx, testUser.ID, "laptop", "10.200.106.5")
+	defer tdb.DeleteTestDevice(ctx, online.ID)
+	defer tdb.DeleteTestDevice(ctx, recent.ID)
+	defer tdb.DeleteTestDevice(ctx, permanent.ID)
+
+	stale := time.Now().Add(-2 * defaultEphemeralDeviceTimeout)
+	tdb.Exec(ctx, `UPDATE devices SET ephemeral = true, last_seen = $1 WHERE id = ANY($2::uuid[])`,
+		stale, "{"+offline.ID.String()+","+online.ID.String()+"}")
+	tdb.Exec(ctx, `UPDATE devices SET ephemeral = true WHERE id = $1`, recent.ID)
+	tdb.Exec(ctx, `UPDATE devices SET last_seen = $1 WHERE id = $2`, stale, permanent.ID)
+
+	// A heartbeat the database hasn't seen yet keeps a device alive
+	deviceCache.MarkOnline(online.ID.String())
+
+	// Test: only the offline ephemeral device is reaped while DEVICE_MAX_AGE is unset
+	reaped, err := reaper.Reap(ctx)
+	if err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if reaped != 1 {
+		t.Errorf("Expected 1 device reaped, got %d", reaped)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		deviceID  uuid.UUID
+		wantExist bool
+	}{
+		{"offline ephemeral", offline.ID, false},
+		{"online ephemeral", online.ID, true},
+		{"recent ephemeral", recent.ID, true},
+		{"permanent", permanent.ID, true},
+	} {
+		device, err := repos.Devices.GetByID(ctx, tc.deviceID)
+		if err != nil {
+			t.Fatalf("GetByID failed: %v", err)
+		}
+		if (device != nil) != tc.wantExist {
+			t.Errorf("%s device: exists = %v, want %v", tc.name, device != nil, tc.wantExist)
+		}
+	}
+
+	// Test: DEVICE_MAX_AGE reaps any device without a recent heartbeat
+	reaper.maxAge = defaultEphemeralDeviceTimeout
+	if _, err := reaper.Reap(ctx); err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if device, _ := repos.Devices.GetByID(ctx, permanent.ID); device != nil {
+		t.Error("Expected device past DEVICE_MAX_AGE to be reaped")
+	}
+}
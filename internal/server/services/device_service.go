@@ -2,8 +2,10 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
@@ -11,6 +13,13 @@ import (
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrDeviceNotFound is returned for devices that don't exist or belong to
+	// another user
+	ErrDeviceNotFound     = errors.New("device not found")
+	ErrInvalidDisplayName = errors.New("display name must be at most 100 characters")
+)
+
 type DeviceService struct {
 	deviceRepo *storage.DeviceRepository
 	userRepo   *storage.UserRepository
@@ -218,13 +227,9 @@ func (s *DeviceService) GetDevice(ctx context.Context, deviceID uuid.UUID, userI
 	if err != nil {
 		return nil, err
 	}
-	if device == nil {
-		return nil, fmt.Errorf("device not found")
-	}
-
-	// Verify device belongs to user
-	if device.UserID != userID {
-		return nil, fmt.Errorf("device does not belong to user")
+	// Devices of other users are reported as missing too
+	if device == nil || device.UserID != userID {
+		return nil, ErrDeviceNotFound
 	}
 
 	return device, nil
@@ -242,6 +247,41 @@ func (s *DeviceService) GetDeviceByID(ctx context.Context, deviceID uuid.UUID) (
 	return device, nil
 }
 
+// RenameDevice sets a device's display name. An empty name clears it so the
+// device falls back to its device name.
+func (s *DeviceService) RenameDevice(ctx context.Context, deviceID uuid.UUID, userID uuid.UUID, displayName string) (*models.Device, error) {
+	device, err := s.GetDevice(ctx, deviceID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName = strings.TrimSpace(displayName)
+	if len(displayName) > 100 {
+		return nil, ErrInvalidDisplayName
+	}
+
+	var name *string
+	if displayName != "" {
+		name = &displayName
+	}
+
+	if err := s.deviceRepo.UpdateDisplayName(ctx, device.ID, name); err != nil {
+		return nil, fmt.Errorf("failed to rename device: %w", err)
+	}
+
+	device.DisplayName = name
+	log.Printf("Renamed device %s to %q for user %s", device.ID, displayName, userID)
+	return device, nil
+}
+
+// ApplyEnrollmentKey gives a device the tags and ephemeral flag of the key it enrolled with
+func (s *DeviceService) ApplyEnrollmentKey(ctx context.Context, deviceID uuid.UUID, key *models.EnrollmentKey) error {
+	if err := s.deviceRepo.UpdateEnrollment(ctx, deviceID, key.Tags, key.Ephemeral); err != nil {
+		return fmt.Errorf("failed to apply enrollment key to device: %w", err)
+	}
+	return nil
+}
+
 func (s *DeviceService) DeleteDevice(ctx context.Context, deviceID uuid.UUID, userID uuid.UUID) error {
 	device, err := s.GetDevice(ctx, deviceID, userID)
 	if err != nil {
@@ -2,6 +2,8 @@ package services
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/kamikazebr/roamie-desktop/internal/testutil"
@@ -182,3 +184,63 @@ func TestDeviceService_GetDevice_FailsForWrongUser(t *testing.T) {
 		t.Error("Expected error when accessing another user's device, got nil")
 	}
 }
+
+func TestDeviceService_RenameDevice(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+
+	t.Setenv("WG_BASE_NETWORK", "10.200.0.0/16")
+	t.Setenv("WG_SUBNET_SIZE", "29")
+
+	subnetPool, err := NewSubnetPool(repos.Users, repos.Conflicts)
+	if err != nil {
+		t.Fatalf("Failed to create subnet pool: %v", err)
+	}
+
+	service := NewDeviceService(repos.Devices, repos.Users, subnetPool, repos.DeviceAuth)
+
+	testUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(20))
+	defer tdb.DeleteTestUser(ctx, testUser.ID)
+
+	testDevice := tdb.CreateTestDevice(ctx, testUser.ID, "test-device", "10.200.0.2")
+	defer tdb.DeleteTestDevice(ctx, testDevice.ID)
+
+	// Test: rename sets the display name
+	device, err := service.RenameDevice(ctx, testDevice.ID, testUser.ID, "  Work Laptop ")
+	if err != nil {
+		t.Fatalf("Failed to rename device: %v", err)
+	}
+	if device.DisplayName == nil || *device.DisplayName != "Work Laptop" {
+		t.Errorf("Expected display name 'Work Laptop', got %v", device.DisplayName)
+	}
+
+	stored, _ := repos.Devices.GetByID(ctx, testDevice.ID)
+	if stored.DisplayName == nil || *stored.DisplayName != "Work Laptop" {
+		t.Errorf("Display name not persisted, got %v", stored.DisplayName)
+	}
+
+	// Test: empty name clears it
+	device, err = service.RenameDevice(ctx, testDevice.ID, testUser.ID, "")
+	if err != nil {
+		t.Fatalf("Failed to clear display name: %v", err)
+	}
+	if device.DisplayName != nil {
+		t.Errorf("Expected display name to be cleared, got %q", *device.DisplayName)
+	}
+
+	// Test: another user's device cannot be renamed
+	if _, err := service.RenameDevice(ctx, testDevice.ID, uuid.New(), "stolen"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Expected ErrDeviceNotFound renaming another user's device, got %v", err)
+	}
+
+	// Test: overlong names are rejected
+	if _, err := service.RenameDevice(ctx, testDevice.ID, testUser.ID, strings.Repeat("x", 101)); !errors.Is(err, ErrInvalidDisplayName) {
+		t.Errorf("Expected ErrInvalidDisplayName, got %v", err)
+	}
+}
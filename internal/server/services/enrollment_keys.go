@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// EnrollmentKeyPrefix starts every enrollment key so leaked keys are easy to spot
+	EnrollmentKeyPrefix = "roamie_ek_"
+
+	DefaultEnrollmentKeyTTL = 24 * time.Hour
+	MaxEnrollmentKeyTTL     = 90 * 24 * time.Hour
+
+	maxEnrollmentKeyTags = 10
+)
+
+var (
+	ErrEnrollmentKeysDisabled = errors.New("enrollment keys are not enabled")
+	ErrInvalidEnrollmentKey   = errors.New("invalid, expired or used up enrollment key")
+	ErrEnrollmentKeyNotFound  = errors.New("enrollment key not found")
+
+	// ErrInvalidEnrollmentKeyOptions wraps validation errors when creating a key
+	ErrInvalidEnrollmentKeyOptions = errors.New("invalid enrollment key options")
+)
+
+var enrollmentTagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// EnrollmentKeyOptions configures a new enrollment key
+type EnrollmentKeyOptions struct {
+	Description string
+	MaxUses     *int          // nil = unlimited until the key expires
+	TTL         time.Duration // 0 = DefaultEnrollmentKeyTTL
+	Tags        []string      // Applied to every device enrolled with the key
+	Tunnel      bool          // Enrolled devices register an SSH tunnel
+	Ephemeral   bool          // Enrolled devices are deleted once offline
+}
+
+// HashEnrollmentKey returns the hash stored for an enrollment key
+func HashEnrollmentKey(key string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeEnrollmentTags lowercases, validates and de-duplicates tags
+func normalizeEnrollmentTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if !enrollmentTagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("%w: tag %q must be 1-32 lowercase letters, digits, '-' or '_'", ErrInvalidEnrollmentKeyOptions, tag)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxEnrollmentKeyTags {
+		return nil, fmt.Errorf("%w: at most %d tags", ErrInvalidEnrollmentKeyOptions, maxEnrollmentKeyTags)
+	}
+	return normalized, nil
+}
+
+// CreateEnrollmentKey creates a key that enrolls devices into userID's
+// network. The returned secret is not stored and can't be shown again.
+func (s *DeviceAuthService) CreateEnrollmentKey(ctx context.Context, userID uuid.UUID, opts EnrollmentKeyOptions) (*models.EnrollmentKey, string, error) {
+	if s.enrollmentKeyRepo == nil {
+		return nil, "", ErrEnrollmentKeysDisabled
+	}
+
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = DefaultEnrollmentKeyTTL
+	}
+	if ttl < 0 || ttl > MaxEnrollmentKeyTTL {
+		return nil, "", fmt.Errorf("%w: expiry must be between now and %s", ErrInvalidEnrollmentKeyOptions, MaxEnrollmentKeyTTL)
+	}
+	if opts.MaxUses != nil && *opts.MaxUses < 1 {
+		return nil, "", fmt.Errorf("%w: max uses must be at least 1", ErrInvalidEnrollmentKeyOptions)
+	}
+
+	description := strings.TrimSpace(opts.Description)
+	if len(description) > 100 {
+		return nil, "", fmt.Errorf("%w: description must be at most 100 characters", ErrInvalidEnrollmentKeyOptions)
+	}
+
+	tags, err := normalizeEnrollmentTags(opts.Tags)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate enrollment key: %w", err)
+	}
+	secret := EnrollmentKeyPrefix + base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	key := &models.EnrollmentKey{
+		ID:          uuid.New(),
+		UserID:      userID,
+		KeyHash:     HashEnrollmentKey(secret),
+		KeyPrefix:   secret[:len(EnrollmentKeyPrefix)+6],
+		Description: description,
+		MaxUses:     opts.MaxUses,
+		Tags:        tags,
+		Tunnel:      opts.Tunnel,
+		Ephemeral:   opts.Ephemeral,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+
+	if err := s.enrollmentKeyRepo.Create(ctx, key); err != nil {
+		return nil, "", fmt.Errorf("failed to create enrollment key: %w", err)
+	}
+
+	return key, secret, nil
+}
+
+// ListEnrollmentKeys lists a user's enrollment keys that haven't been revoked
+func (s *DeviceAuthService) ListEnrollmentKeys(ctx context.Context, userID uuid.UUID) ([]models.EnrollmentKey, error) {
+	if s.enrollmentKeyRepo == nil {
+		return nil, ErrEnrollmentKeysDisabled
+	}
+
+	keys, err := s.enrollmentKeyRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list enrollment keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// GetEnrollmentKey gets an enrollment key by ID
+func (s *DeviceAuthService) GetEnrollmentKey(ctx context.Context, keyID uuid.UUID) (*models.EnrollmentKey, error) {
+	if s.enrollmentKeyRepo == nil {
+		return nil, ErrEnrollmentKeysDisabled
+	}
+
+	key, err := s.enrollmentKeyRepo.GetByID(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enrollment key: %w", err)
+	}
+	if key == nil {
+		return nil, ErrEnrollmentKeyNotFound
+	}
+
+	return key, nil
+}
+
+// RevokeEnrollmentKey stops a key from enrolling more devices. Devices it
+// already enrolled are not affected. A nil userID revokes any user's key.
+func (s *DeviceAuthService) RevokeEnrollmentKey(ctx context.Context, keyID uuid.UUID, userID *uuid.UUID) error {
+	if s.enrollmentKeyRepo == nil {
+		return ErrEnrollmentKeysDisabled
+	}
+
+	revoked, err := s.enrollmentKeyRepo.Revoke(ctx, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke enrollment key: %w", err)
+	}
+	if !revoked {
+		return ErrEnrollmentKeyNotFound
+	}
+
+	return nil
+}
+
+// EnrollChallenge approves a pending challenge on behalf of the owner of an
+// enrollment key, consuming one use of the key (given back if the approval
+// fails). Challenges presenting a bad key are denied so they don't linger as
+// pending requests.
+func (s *DeviceAuthService) EnrollChallenge(
+	ctx context.Context,
+	challengeID uuid.UUID,
+	secret string,
+	wgManager WireGuardManager,
+	deviceRepo DeviceRepository,
+) (*models.EnrollmentKey, error) {
+	if s.enrollmentKeyRepo == nil {
+		s.deviceAuthRepo.UpdateChallengeStatus(ctx, challengeID, "denied", nil)
+		return nil, ErrEnrollmentKeysDisabled
+	}
+
+	key, err := s.enrollmentKeyRepo.Use(ctx, HashEnrollmentKey(secret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to use enrollment key: %w", err)
+	}
+	if key == nil {
+		s.deviceAuthRepo.UpdateChallengeStatus(ctx, challengeID, "denied", nil)
+		return nil, ErrInvalidEnrollmentKey
+	}
+
+	if err := s.deviceAuthRepo.UpdateChallengeEnrollmentKey(ctx, challengeID, key.ID); err != nil {
+		s.releaseEnrollmentKey(ctx, key)
+		return nil, fmt.Errorf("failed to record enrollment key: %w", err)
+	}
+
+	// The key stands in for the owner's approval, so approval policies don't apply
+	if err := s.completeApproval(ctx, challengeID, key.UserID, wgManager, deviceRepo); err != nil {
+		s.releaseEnrollmentKey(ctx, key)
+		return nil, err
+	}
+
+	if (len(key.Tags) > 0 || key.Ephemeral) && s.deviceService != nil {
+		challenge, err := s.deviceAuthRepo.GetChallenge(ctx, challengeID)
+		if err == nil && challenge != nil && challenge.WgDeviceID != nil {
+			if err := s.deviceService.ApplyEnrollmentKey(ctx, *challenge.WgDeviceID, key); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}
+
+	log.Printf("[DeviceAuth] Challenge %s enrolled with key %s (user %s)", challengeID, key.KeyPrefix, key.UserID)
+	return key, nil
+}
+
+// releaseEnrollmentKey gives back the use of a key whose enrollment failed,
+// so a single-use key isn't spent on a challenge that was never approved
+func (s *DeviceAuthService) releaseEnrollmentKey(ctx context.Context, key *models.EnrollmentKey) {
+	if err := s.enrollmentKeyRepo.Release(ctx, key.ID); err != nil {
+		log.Printf("Warning: failed to release enrollment key %s: %v", key.KeyPrefix, err)
+	}
+}
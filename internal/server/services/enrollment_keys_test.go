@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/google/uuid"
+)
+
+func TestNormalizeEnrollmentTags(t *testing.T) {
+	tags, err := normalizeEnrollmentTags([]string{" CI ", "build-01", "ci", "", "k8s_node"})
+	if err != nil {
+		t.Fatalf("normalizeEnrollmentTags failed: %v", err)
+	}
+	if strings.Join(tags, ",") != "ci,build-01,k8s_node" {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+
+	for _, bad := range []string{"has space", "-leading", "emoji🙂", strings.Repeat("a", 33)} {
+		if _, err := normalizeEnrollmentTags([]string{bad}); !errors.Is(err, ErrInvalidEnrollmentKeyOptions) {
+			t.Errorf("Tag %q: expected ErrInvalidEnrollmentKeyOptions, got %v", bad, err)
+		}
+	}
+
+	tooMany := make([]string, maxEnrollmentKeyTags+1)
+	for i := range tooMany {
+		tooMany[i] = "tag" + string(rune('a'+i))
+	}
+	if _, err := normalizeEnrollmentTags(tooMany); !errors.Is(err, ErrInvalidEnrollmentKeyOptions) {
+		t.Errorf("Expected too many tags to be rejected, got %v", err)
+	}
+}
+
+func TestHashEnrollmentKey_IgnoresSurroundingWhitespace(t *testing.T) {
+	if HashEnrollmentKey("roamie_ek_abc\n") != HashEnrollmentKey("roamie_ek_abc") {
+		t.Error("Keys pasted with a trailing newline should still match")
+	}
+	if HashEnrollmentKey("roamie_ek_abc") == HashEnrollmentKey("roamie_ek_abd") {
+		t.Error("Different keys should hash differently")
+	}
+}
+
+func TestDeviceAuthService_CreateEnrollmentKey_Disabled(t *testing.T) {
+	service := NewDeviceAuthService(nil, nil)
+
+	if _, _, err := service.CreateEnrollmentKey(context.Background(), uuid.New(), EnrollmentKeyOptions{}); !errors.Is(err, ErrEnrollmentKeysDisabled) {
+		t.Errorf("Expected ErrEnrollmentKeysDisabled, got %v", err)
+	}
+}
+
+func TestDeviceAuthService_EnrollChallenge(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+
+	t.Setenv("WG_BASE_NETWORK", "10.200.0.0/16")
+	t.Setenv("WG_SUBNET_SIZE", "29")
+
+	subnetPool, err := NewSubnetPool(repos.Users, repos.Conflicts)
+	if err != nil {
+		t.Fatalf("Failed to create subnet pool: %v", err)
+	}
+
+	service := NewDeviceAuthService(repos.DeviceAuth, repos.Users)
+	deviceService := NewDeviceService(repos.Devices, repos.Users, subnetPool, repos.DeviceAuth)
+	service.SetDeviceService(deviceService)
+	service.SetEnrollmentKeys(repos.EnrollmentKeys)
+
+	testUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(105))
+	defer tdb.DeleteTestUser(ctx, testUser.ID)
+
+	maxUses := 1
+	key, secret, err := service.CreateEnrollmentKey(ctx, testUser.ID, EnrollmentKeyOptions{
+		Description: "ci runners",
+		MaxUses:     &maxUses,
+		TTL:         time.Hour,
+		Tags:        []string{"CI"},
+		Tunnel:      true,
+	})
+	if err != nil {
+		t.Fatalf("CreateEnrollmentKey failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, EnrollmentKeyPrefix) || !strings.HasPrefix(secret, key.KeyPrefix) {
+		t.Errorf("Unexpected key %q (prefix %q)", secret, key.KeyPrefix)
+	}
+	if key.KeyHash == secret {
+		t.Error("The key itself must not be stored")
+	}
+
+	newChallenge := func() uuid.UUID {
+		publicKey := testutil.GenerateTestWireGuardKey()
+		challenge, err := service.CreateChallenge(ctx, uuid.New(), "ci-runner", "192.0.2.1", nil, &publicKey, nil, nil, "")
+		if err != nil {
+			t.Fatalf("CreateChallenge failed: %v", err)
+		}
+		return challenge.ID
+	}
+
+	// Test: a failed enrollment doesn't spend the single use
+	if _, err := service.EnrollChallenge(ctx, uuid.New(), secret, nil, nil); err == nil || errors.Is(err, ErrInvalidEnrollmentKey) {
+		t.Errorf("Expected enrolling an unknown challenge to fail, got %v", err)
+	}
+
+	// Test: a valid key approves the challenge as the key's owner and tags the device
+	challengeID := newChallenge()
+	if _, err := service.EnrollChallenge(ctx, challengeID, secret+"\n", nil, nil); err != nil {
+		t.Fatalf("EnrollChallenge failed: %v", err)
+	}
+
+	challenge, err := service.GetChallenge(ctx, challengeID)
+	if err != nil {
+		t.Fatalf("GetChallenge failed: %v", err)
+	}
+	if challenge.Status != "approved" || challenge.UserID == nil || *challenge.UserID != testUser.ID {
+		t.Fatalf("Expected challenge approved for the key owner, got %s", challenge.Status)
+	}
+	if challenge.EnrollmentKeyID == nil || *challenge.EnrollmentKeyID != key.ID {
+		t.Error("Challenge should record the enrollment key")
+	}
+	if challenge.WgDeviceID == nil {
+		t.Fatal("Device should be registered")
+	}
+	device, err := deviceService.GetDeviceByID(ctx, *challenge.WgDeviceID)
+	if err != nil {
+		t.Fatalf("GetDeviceByID failed: %v", err)
+	}
+	if strings.Join(device.Tags, ",") != "ci" {
+		t.Errorf("Expected device tagged ci, got %v", device.Tags)
+	}
+
+	// Test: a single-use key can't be used twice, and the challenge is denied
+	challengeID = newChallenge()
+	if _, err := service.EnrollChallenge(ctx, challengeID, secret, nil, nil); !errors.Is(err, ErrInvalidEnrollmentKey) {
+		t.Errorf("Expected ErrInvalidEnrollmentKey, got %v", err)
+	}
+	if challenge, _ := service.GetChallenge(ctx, challengeID); challenge.Status != "denied" {
+		t.Errorf("Expected challenge denied, got %s", challenge.Status)
+	}
+
+	// Test: revoked keys stop working, and only their owner can revoke them
+	reusable, reusableSecret, err := service.CreateEnrollmentKey(ctx, testUser.ID, EnrollmentKeyOptions{})
+	if err != nil {
+		t.Fatalf("CreateEnrollmentKey failed: %v", err)
+	}
+	otherUser := uuid.New()
+	if err := service.RevokeEnrollmentKey(ctx, reusable.ID, &otherUser); !errors.Is(err, ErrEnrollmentKeyNotFound) {
+		t.Errorf("Expected ErrEnrollmentKeyNotFound for another user, got %v", err)
+	}
+	if err := service.RevokeEnrollmentKey(ctx, reusable.ID, &testUser.ID); err != nil {
+		t.Fatalf("RevokeEnrollmentKey failed: %v", err)
+	}
+	if _, err := service.EnrollChallenge(ctx, newChallenge(), reusableSecret, nil, nil); !errors.Is(err, ErrInvalidEnrollmentKey) {
+		t.Errorf("Expected revoked key to be rejected, got %v", err)
+	}
+
+	keys, err := service.ListEnrollmentKeys(ctx, testUser.ID)
+	if err != nil {
+		t.Fatalf("ListEnrollmentKeys failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ID != key.ID || keys[0].Status() != "used" {
+		t.Errorf("Expected only the used key to be listed, got %+v", keys)
+	}
+}
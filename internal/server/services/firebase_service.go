@@ -57,3 +57,29 @@ func (s *FirebaseService) GetFirebaseUser(ctx context.Context, uid string) (*aut
 	}
 	return user, nil
 }
+
+// Name implements IdentityProvider
+func (s *FirebaseService) Name() string {
+	return "firebase"
+}
+
+// Authenticate implements IdentityProvider for Firebase ID tokens. Firebase
+// accounts have always been matched by email, so the email counts as verified.
+func (s *FirebaseService) Authenticate(ctx context.Context, creds Credentials) (*Identity, error) {
+	token, err := s.VerifyIDToken(ctx, creds.IDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	email, _ := token.Claims["email"].(string)
+	if email == "" {
+		return nil, fmt.Errorf("email not found in Firebase token")
+	}
+
+	return &Identity{
+		Provider:      s.Name(),
+		Subject:       token.UID,
+		Email:         email,
+		EmailVerified: true,
+	}, nil
+}
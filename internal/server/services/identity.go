@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrInvalidCredentials is returned when a provider rejects a login
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrEmailNotVerified is returned when an unverified email would be
+	// linked to an existing account
+	ErrEmailNotVerified = errors.New("email not verified by identity provider")
+)
+
+// Identity is a user as asserted by an identity provider
+type Identity struct {
+	Provider string // Provider name, see IdentityProvider.Name
+	Subject  string // Stable user ID within the provider
+	Email    string
+	// Whether the provider vouches for the email. Unverified emails never
+	// link to an existing account.
+	EmailVerified bool
+}
+
+// Credentials is what a client presents to log in. Token-based providers
+// (Firebase, OIDC) read IDToken; local accounts read Email and Password.
+type Credentials struct {
+	IDToken  string
+	Nonce    string // OIDC nonce the client sent in the authorization request
+	Email    string
+	Password string
+}
+
+// IdentityProvider verifies login credentials and returns who they belong to
+type IdentityProvider interface {
+	// Name identifies the provider in login requests and user_identities
+	Name() string
+
+	// Authenticate verifies credentials, returning ErrInvalidCredentials
+	// when they are rejected
+	Authenticate(ctx context.Context, creds Credentials) (*Identity, error)
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// sealedKeyPrefix marks a private key stored encrypted; keys without it are
+// plaintext from before encryption was configured
+const sealedKeyPrefix = "enc:v1:"
+
+// ErrKeyEncryptionRequired is returned when stored keys are encrypted but no
+// key encryption key is configured
+var ErrKeyEncryptionRequired = errors.New("private keys are encrypted but KEY_ENCRYPTION_KEY is not set")
+
+// KeyEncryption encrypts private keys stored in the database with a key kept
+// outside of it, so a database dump or backup doesn't give them away
+type KeyEncryption struct {
+	aead cipher.AEAD
+}
+
+// KeyEncryptionFromEnv reads the key encryption key, 32 bytes in base64, from
+// KEY_ENCRYPTION_KEY or from the file named by KEY_ENCRYPTION_KEY_FILE (e.g. a
+// secret mounted by a KMS or secret manager). Returns nil if neither is set.
+func KeyEncryptionFromEnv() (*KeyEncryption, error) {
+	encoded := os.Getenv("KEY_ENCRYPTION_KEY")
+	if path := os.Getenv("KEY_ENCRYPTION_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read KEY_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded = strings.TrimSpace(encoded); encoded == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encryption key: %w", err)
+	}
+	return NewKeyEncryption(key)
+}
+
+// NewKeyEncryption uses a 32-byte key with XChaCha20-Poly1305
+func NewKeyEncryption(key []byte) (*KeyEncryption, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("key encryption key must be %d bytes, got %d", chacha20poly1305.KeySize, len(key))
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyEncryption{aead: aead}, nil
+}
+
+// Seal encrypts a private key. name (e.g. the kid) is authenticated with it,
+// so a sealed key can't be swapped into another row.
+func (e *KeyEncryption) Seal(plaintext, name string) (string, error) {
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(plaintext)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := e.aead.Seal(nonce, nonce, []byte(plaintext), []byte(name))
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a private key sealed under name
+func (e *KeyEncryption) Open(stored, name string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedKeyPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < e.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:e.aead.NonceSize()], data[e.aead.NonceSize():]
+	plaintext, err := e.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", errors.New("wrong key encryption key or corrupted key")
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether a stored private key is encrypted
+func IsSealed(stored string) bool {
+	return strings.HasPrefix(stored, sealedKeyPrefix)
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyEncryption(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	encryption, err := NewKeyEncryption(key)
+	if err != nil {
+		t.Fatalf("NewKeyEncryption failed: %v", err)
+	}
+
+	sealed, err := encryption.Seal("private-key", "kid-1")
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "private-key") {
+		t.Fatalf("sealed = %q", sealed)
+	}
+	if opened, err := encryption.Open(sealed, "kid-1"); err != nil || opened != "private-key" {
+		t.Errorf("Open = %q, %v", opened, err)
+	}
+
+	// A sealed key moved to another row doesn't open
+	if _, err := encryption.Open(sealed, "kid-2"); err == nil {
+		t.Error("key sealed for another kid should not open")
+	}
+
+	other, _ := NewKeyEncryption(make([]byte, 32))
+	if _, err := other.Open(sealed, "kid-1"); err == nil {
+		t.Error("key sealed with another key encryption key should not open")
+	}
+
+	if _, err := NewKeyEncryption(key[:16]); err == nil {
+		t.Error("short key should be rejected")
+	}
+}
+
+func TestKeyEncryptionFromEnv(t *testing.T) {
+	t.Setenv("KEY_ENCRYPTION_KEY", "")
+	t.Setenv("KEY_ENCRYPTION_KEY_FILE", "")
+	if encryption, err := KeyEncryptionFromEnv(); encryption != nil || err != nil {
+		t.Errorf("unset = %v, %v", encryption, err)
+	}
+
+	t.Setenv("KEY_ENCRYPTION_KEY", "not base64!")
+	if _, err := KeyEncryptionFromEnv(); err == nil {
+		t.Error("invalid key should be rejected")
+	}
+
+	path := filepath.Join(t.TempDir(), "kek")
+	os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0600)
+	t.Setenv("KEY_ENCRYPTION_KEY_FILE", path)
+	if encryption, err := KeyEncryptionFromEnv(); encryption == nil || err != nil {
+		t.Errorf("key file = %v, %v", encryption, err)
+	}
+}
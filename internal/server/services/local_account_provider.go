@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password accepted for local accounts
+const MinPasswordLength = 10
+
+// LocalAccountProvider logs users in with an email and password stored on the
+// server, for deployments without Firebase, email or an OIDC issuer.
+// Accounts are created by an admin (roamie-server admin set-password).
+type LocalAccountProvider struct {
+	userRepo *storage.UserRepository
+
+	// Compared against when the account doesn't exist, so unknown emails
+	// take as long to reject as wrong passwords
+	dummyHash []byte
+}
+
+func NewLocalAccountProvider(userRepo *storage.UserRepository) *LocalAccountProvider {
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("roamie-dummy-password"), bcrypt.DefaultCost)
+	return &LocalAccountProvider{
+		userRepo:  userRepo,
+		dummyHash: dummyHash,
+	}
+}
+
+// Name implements IdentityProvider
+func (p *LocalAccountProvider) Name() string {
+	return "local"
+}
+
+// Authenticate implements IdentityProvider for email and password logins
+func (p *LocalAccountProvider) Authenticate(ctx context.Context, creds Credentials) (*Identity, error) {
+	email := strings.ToLower(strings.TrimSpace(creds.Email))
+	if email == "" || creds.Password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := p.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user == nil || user.PasswordHash == nil {
+		bcrypt.CompareHashAndPassword(p.dummyHash, []byte(creds.Password))
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(*user.PasswordHash), []byte(creds.Password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Provider:      p.Name(),
+		Subject:       user.ID.String(),
+		Email:         user.Email,
+		EmailVerified: true, // Set by an admin
+	}, nil
+}
+
+// SetPassword sets a user's password, turning them into a local account
+func (p *LocalAccountProvider) SetPassword(ctx context.Context, userID uuid.UUID, password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	hashStr := string(hash)
+	if err := p.userRepo.SetPasswordHash(ctx, userID, &hashStr); err != nil {
+		return fmt.Errorf("failed to store password: %w", err)
+	}
+	return nil
+}
+
+// ClearPassword disables password login for a user
+func (p *LocalAccountProvider) ClearPassword(ctx context.Context, userID uuid.UUID) error {
+	if err := p.userRepo.SetPasswordHash(ctx, userID, nil); err != nil {
+		return fmt.Errorf("failed to clear password: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Unknown kids trigger a JWKS refetch (the issuer may have rotated keys),
+	// but at most this often
+	oidcJWKSRefreshThrottle = time.Minute
+
+	oidcDefaultScopes = "openid email profile"
+)
+
+// OIDCProvider logs users in with ID tokens from any OpenID Connect issuer.
+// Clients run the authorization code flow with PKCE against the issuer
+// themselves and present the resulting ID token; the server only verifies it.
+type OIDCProvider struct {
+	issuer   string
+	clientID string
+	scopes   []string
+	jwksURI  string
+	client   *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time
+}
+
+// oidcDiscovery is the subset of /.well-known/openid-configuration we use
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// NewOIDCProviderFromEnv configures the provider from OIDC_ISSUER,
+// OIDC_CLIENT_ID and OIDC_SCOPES (default "openid email profile"). The
+// client ID must belong to a public client that allows loopback redirects.
+func NewOIDCProviderFromEnv(ctx context.Context) (*OIDCProvider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER not set")
+	}
+
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID not set")
+	}
+
+	scopes := os.Getenv("OIDC_SCOPES")
+	if scopes == "" {
+		scopes = oidcDefaultScopes
+	}
+
+	return NewOIDCProvider(ctx, issuer, clientID, strings.Fields(scopes))
+}
+
+// NewOIDCProvider discovers the issuer's configuration and loads its keys
+func NewOIDCProvider(ctx context.Context, issuer, clientID string, scopes []string) (*OIDCProvider, error) {
+	p := &OIDCProvider{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		clientID: clientID,
+		scopes:   scopes,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		keys:     make(map[string]crypto.PublicKey),
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", discovery.Issuer, p.issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery returned no jwks_uri")
+	}
+	p.jwksURI = discovery.JWKSURI
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Name implements IdentityProvider
+func (p *OIDCProvider) Name() string {
+	return "oidc"
+}
+
+// Issuer returns the issuer URL clients authenticate against
+func (p *OIDCProvider) Issuer() string {
+	return p.issuer
+}
+
+// ClientID returns the public client ID clients use for the authorization request
+func (p *OIDCProvider) ClientID() string {
+	return p.clientID
+}
+
+// Scopes returns the scopes clients request
+func (p *OIDCProvider) Scopes() []string {
+	return p.scopes
+}
+
+// oidcClaims are the ID token claims we read
+type oidcClaims struct {
+	Email string `json:"email"`
+	// Some issuers send "true"/"false" strings instead of booleans
+	EmailVerified interface{} `json:"email_verified"`
+	Nonce         string      `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+func (c *oidcClaims) emailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// Authenticate implements IdentityProvider for ID tokens issued to our client
+func (p *OIDCProvider) Authenticate(ctx context.Context, creds Credentials) (*Identity, error) {
+	var claims oidcClaims
+	_, err := jwt.ParseWithClaims(creds.IDToken, &claims, p.keyfunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "EdDSA"}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	// A nonce on either side must match the other, so a token can't be
+	// replayed into a different authorization request
+	if claims.Nonce != creds.Nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidCredentials)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: ID token has no subject", ErrInvalidCredentials)
+	}
+	if claims.Email == "" {
+		return nil, fmt.Errorf("email not found in ID token (is the email scope granted?)")
+	}
+
+	return &Identity{
+		Provider:      p.Name(),
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: claims.emailVerified(),
+	}, nil
+}
+
+// keyfunc finds the issuer key that signed a token, refetching the JWKS once
+// if the kid is unknown
+func (p *OIDCProvider) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+
+	p.mu.RLock()
+	stale := time.Since(p.lastRefresh) > oidcJWKSRefreshThrottle
+	p.mu.RUnlock()
+
+	if stale {
+		if err := p.refreshKeys(context.Background()); err != nil {
+			log.Printf("Warning: %v", err)
+		} else if key, ok := p.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *OIDCProvider) lookup(kid string) (crypto.PublicKey, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// Tokens without a kid are fine when the issuer has a single key
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// oidcJWK is a public key from the issuer's JWKS
+type oidcJWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	var jwks struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURI, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch OIDC keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Warning: skipping OIDC key %q: %v", jwk.KeyID, err)
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.lastRefresh = time.Now()
+	p.mu.Unlock()
+
+	if len(keys) == 0 {
+		return fmt.Errorf("OIDC issuer published no usable signing keys")
+	}
+	return nil
+}
+
+func (k *oidcJWK) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Curve)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("malformed Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("malformed key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
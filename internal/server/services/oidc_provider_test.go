@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP is a minimal OpenID Connect issuer serving discovery and a JWKS
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	idp := &mockIdP{key: key, kid: "test-key"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   idp.server.URL,
+			"jwks_uri": idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": idp.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (idp *mockIdP) idToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.kid
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatalf("Failed to sign ID token: %v", err)
+	}
+	return signed
+}
+
+func (idp *mockIdP) claims(overrides jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"aud":            "roamie-cli",
+		"sub":            "user-123",
+		"email":          "Alice@Example.com",
+		"email_verified": true,
+		"nonce":          "nonce-1",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestOIDCProvider_Authenticate(t *testing.T) {
+	idp := newMockIdP(t)
+
+	provider, err := NewOIDCProvider(context.Background(), idp.server.URL, "roamie-cli", []string{"openid", "email"})
+	if err != nil {
+		t.Fatalf("NewOIDCProvider failed: %v", err)
+	}
+
+	token := idp.idToken(t, idp.claims(nil))
+	identity, err := provider.Authenticate(context.Background(), Credentials{IDToken: token, Nonce: "nonce-1"})
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	if identity.Provider != "oidc" || identity.Subject != "user-123" {
+		t.Errorf("Unexpected identity %s/%s", identity.Provider, identity.Subject)
+	}
+	if identity.Email != "alice@example.com" {
+		t.Errorf("Email should be normalized, got %s", identity.Email)
+	}
+	if !identity.EmailVerified {
+		t.Error("Email should be verified")
+	}
+}
+
+func TestOIDCProvider_RejectsInvalidTokens(t *testing.T) {
+	idp := newMockIdP(t)
+
+	provider, err := NewOIDCProvider(context.Background(), idp.server.URL, "roamie-cli", nil)
+	if err != nil {
+		t.Fatalf("NewOIDCProvider failed: %v", err)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims(nil))
+	forged.Header["kid"] = idp.kid
+	forgedToken, _ := forged.SignedString(otherKey)
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"wrong audience", idp.idToken(t, idp.claims(jwt.MapClaims{"aud": "other-client"})), "nonce-1"},
+		{"wrong issuer", idp.idToken(t, idp.claims(jwt.MapClaims{"iss": "https://evil.example.com"})), "nonce-1"},
+		{"expired", idp.idToken(t, idp.claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})), "nonce-1"},
+		{"nonce mismatch", idp.idToken(t, idp.claims(nil)), "nonce-2"},
+		{"missing nonce", idp.idToken(t, idp.claims(nil)), ""},
+		{"wrong signature", forgedToken, "nonce-1"},
+		{"no subject", idp.idToken(t, idp.claims(jwt.MapClaims{"sub": nil})), "nonce-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.Authenticate(context.Background(), Credentials{IDToken: tt.token, Nonce: tt.nonce})
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_EmailVerifiedString(t *testing.T) {
+	idp := newMockIdP(t)
+
+	provider, err := NewOIDCProvider(context.Background(), idp.server.URL, "roamie-cli", nil)
+	if err != nil {
+		t.Fatalf("NewOIDCProvider failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		value    interface{}
+		verified bool
+	}{
+		{"true", true},
+		{"false", false},
+		{false, false},
+	} {
+		token := idp.idToken(t, idp.claims(jwt.MapClaims{"email_verified": tt.value}))
+		identity, err := provider.Authenticate(context.Background(), Credentials{IDToken: token, Nonce: "nonce-1"})
+		if err != nil {
+			t.Fatalf("Authenticate failed: %v", err)
+		}
+		if identity.EmailVerified != tt.verified {
+			t.Errorf("email_verified=%v: expected %v, got %v", tt.value, tt.verified, identity.EmailVerified)
+		}
+	}
+}
+
+func TestNewOIDCProvider_IssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+
+	if _, err := NewOIDCProvider(context.Background(), idp.server.URL+"/realms/other", "roamie-cli", nil); err == nil {
+		t.Error("Expected discovery at an unknown path to fail")
+	}
+}
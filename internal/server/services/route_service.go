@@ -0,0 +1,548 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+// maxAdvertisedRoutes caps how many routes a single device may advertise
+const maxAdvertisedRoutes = 32
+
+var (
+	ErrRouteNotFound = errors.New("route not found")
+	ErrRouteConflict = errors.New("route overlaps a route approved for another device")
+	ErrExitNodeTaken = errors.New("another device is already the approved exit node")
+	ErrNotExitNode   = errors.New("device is not an approved exit node")
+	ErrNoExitDevice  = errors.New("exit node device not found")
+	ErrSelfExitNode  = errors.New("a device cannot be its own exit node")
+	ErrTooManyRoutes = fmt.Errorf("a device may advertise at most %d routes", maxAdvertisedRoutes)
+)
+
+// RouteProgrammer applies approved routes to the server's WireGuard interface
+// and kernel routing tables
+type RouteProgrammer interface {
+	SetPeerAllowedIPs(publicKey string, cidrs []string) error
+	EnsureRoute(cidr string) error
+	RemoveRoute(cidr string) error
+	EnsureExitFirewall(vpnNetworks []string) error
+	EnsureExitRule(srcIP string) error
+	RemoveExitRule(srcIP string) error
+}
+
+// RouteService manages routes advertised by devices acting as subnet routers
+// or exit nodes. Routes only take effect after an admin approves them.
+type RouteService struct {
+	routeRepo     *storage.RouteRepository
+	deviceRepo    *storage.DeviceRepository
+	deviceService *DeviceService
+	vpnNetworks   []string
+	router        RouteProgrammer
+}
+
+func NewRouteService(
+	routeRepo *storage.RouteRepository,
+	deviceRepo *storage.DeviceRepository,
+	deviceService *DeviceService,
+	vpnNetworks []string,
+	router RouteProgrammer,
+) *RouteService {
+	return &RouteService{
+		routeRepo:     routeRepo,
+		deviceRepo:    deviceRepo,
+		deviceService: deviceService,
+		vpnNetworks:   vpnNetworks,
+		router:        router,
+	}
+}
+
+// ValidateRouteCIDR checks that a CIDR can be advertised as a subnet route
+// and returns it in canonical form (host bits cleared)
+func ValidateRouteCIDR(cidr string, vpnNetworks []string) (string, error) {
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	if ip.To4() == nil {
+		return "", fmt.Errorf("%s: only IPv4 routes are supported", cidr)
+	}
+	if ones, _ := ipnet.Mask.Size(); ones == 0 {
+		return "", fmt.Errorf("%s: advertise an exit node instead of a default route", cidr)
+	}
+	if ip.IsLoopback() || ip.IsMulticast() || ip.IsLinkLocalUnicast() {
+		return "", fmt.Errorf("%s: range cannot be routed", cidr)
+	}
+
+	normalized := ipnet.String()
+	for _, network := range vpnNetworks {
+		if len(OverlappingCIDRs(network, []string{normalized})) > 0 {
+			return "", fmt.Errorf("%s: overlaps the VPN network %s", cidr, network)
+		}
+	}
+	return normalized, nil
+}
+
+// Advertise replaces the set of routes advertised by a device. New routes
+// start pending; withdrawn routes are removed from the server immediately.
+func (s *RouteService) Advertise(ctx context.Context, userID, deviceID uuid.UUID, cidrs []string, exitNode bool) ([]models.RouteInfo, error) {
+	device, err := s.deviceService.GetDevice(ctx, deviceID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cidrs) > maxAdvertisedRoutes {
+		return nil, ErrTooManyRoutes
+	}
+
+	wanted := make(map[string]bool)
+	for _, cidr := range cidrs {
+		normalized, err := ValidateRouteCIDR(cidr, s.vpnNetworks)
+		if err != nil {
+			return nil, err
+		}
+		wanted[normalized] = true
+	}
+	if exitNode {
+		wanted[models.ExitRouteCIDR] = true
+	}
+
+	existing, err := s.routeRepo.GetByDevice(ctx, device.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routes: %w", err)
+	}
+
+	var withdrawn []models.DeviceRoute
+	for _, route := range existing {
+		if wanted[route.CIDR] {
+			delete(wanted, route.CIDR)
+			continue
+		}
+		if err := s.routeRepo.Delete(ctx, route.ID); err != nil {
+			return nil, fmt.Errorf("failed to withdraw route %s: %w", route.CIDR, err)
+		}
+		if route.Status == models.RouteStatusApproved {
+			withdrawn = append(withdrawn, route)
+		}
+	}
+
+	for cidr := range wanted {
+		route := &models.DeviceRoute{
+			DeviceID: device.ID,
+			CIDR:     cidr,
+			Status:   models.RouteStatusPending,
+		}
+		if err := s.routeRepo.Create(ctx, route); err != nil {
+			return nil, fmt.Errorf("failed to store route %s: %w", cidr, err)
+		}
+		log.Printf("Device %s advertised route %s (pending approval)", device.ID, cidr)
+	}
+
+	if len(withdrawn) > 0 {
+		s.applyDevice(ctx, device, withdrawn)
+	}
+
+	routes, err := s.routeRepo.GetByDevice(ctx, device.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routes: %w", err)
+	}
+	infos := make([]models.RouteInfo, 0, len(routes))
+	for _, route := range routes {
+		infos = append(infos, routeInfo(route, device))
+	}
+	return infos, nil
+}
+
+// ListForUser returns the routes advertised by all of a user's devices
+func (s *RouteService) ListForUser(ctx context.Context, userID uuid.UUID) ([]models.RouteInfo, error) {
+	routes, err := s.routeRepo.GetByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routes: %w", err)
+	}
+	return s.routeInfos(ctx, routes)
+}
+
+// ListPending returns routes waiting for admin review
+func (s *RouteService) ListPending(ctx context.Context) ([]models.RouteInfo, error) {
+	routes, err := s.routeRepo.GetByStatus(ctx, models.RouteStatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routes: %w", err)
+	}
+	return s.routeInfos(ctx, routes)
+}
+
+// Approve enables an advertised route. Subnet routes may not overlap routes
+// of other devices, and only one device per server can be the exit node:
+// the server has a single WireGuard interface, which maps each destination
+// to one peer, so a second user's exit node is refused with ErrExitNodeTaken.
+func (s *RouteService) Approve(ctx context.Context, routeID uuid.UUID) (*models.RouteInfo, error) {
+	route, device, err := s.getRoute(ctx, routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	approved, err := s.routeRepo.GetByStatus(ctx, models.RouteStatusApproved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get approved routes: %w", err)
+	}
+	for _, other := range approved {
+		if other.DeviceID == route.DeviceID {
+			continue
+		}
+		if route.IsExit() && other.IsExit() {
+			return nil, ErrExitNodeTaken
+		}
+		if !route.IsExit() && !other.IsExit() && len(OverlappingCIDRs(route.CIDR, []string{other.CIDR})) > 0 {
+			return nil, ErrRouteConflict
+		}
+	}
+
+	if err := s.routeRepo.UpdateStatus(ctx, route.ID, models.RouteStatusApproved); err != nil {
+		return nil, fmt.Errorf("failed to approve route: %w", err)
+	}
+	route.Status = models.RouteStatusApproved
+	s.applyDevice(ctx, device, nil)
+
+	log.Printf("Approved route %s via device %s", route.CIDR, device.ID)
+	info := routeInfo(*route, device)
+	return &info, nil
+}
+
+// Reject disables a route, removing it from the server if it was approved
+func (s *RouteService) Reject(ctx context.Context, routeID uuid.UUID) (*models.RouteInfo, error) {
+	route, device, err := s.getRoute(ctx, routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.routeRepo.UpdateStatus(ctx, route.ID, models.RouteStatusRejected); err != nil {
+		return nil, fmt.Errorf("failed to reject route: %w", err)
+	}
+	if route.Status == models.RouteStatusApproved {
+		s.applyDevice(ctx, device, []models.DeviceRoute{*route})
+	}
+	route.Status = models.RouteStatusRejected
+
+	log.Printf("Rejected route %s via device %s", route.CIDR, device.ID)
+	info := routeInfo(*route, device)
+	return &info, nil
+}
+
+// SetExitNode routes a device's internet traffic through another device of
+// the same user that is an approved exit node. A nil exit node clears it.
+func (s *RouteService) SetExitNode(ctx context.Context, userID, deviceID uuid.UUID, exitNodeID *uuid.UUID) (*models.SetExitNodeResponse, error) {
+	device, err := s.deviceService.GetDevice(ctx, deviceID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if exitNodeID != nil {
+		if *exitNodeID == device.ID {
+			return nil, ErrSelfExitNode
+		}
+		exitNode, err := s.deviceRepo.GetByID(ctx, *exitNodeID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get exit node: %w", err)
+		}
+		if exitNode == nil || exitNode.UserID != userID {
+			return nil, ErrNoExitDevice
+		}
+		isExit, err := s.isApprovedExit(ctx, exitNode.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !isExit {
+			return nil, ErrNotExitNode
+		}
+	}
+
+	if err := s.deviceRepo.UpdateExitNode(ctx, device.ID, exitNodeID); err != nil {
+		return nil, fmt.Errorf("failed to update exit node: %w", err)
+	}
+
+	response := &models.SetExitNodeResponse{}
+	if exitNodeID != nil {
+		response.ExitNodeID = exitNodeID.String()
+	}
+
+	if s.router == nil {
+		return response, nil
+	}
+	if exitNodeID == nil {
+		if err := s.router.RemoveExitRule(device.VpnIP); err != nil {
+			log.Printf("Warning: failed to remove exit rule for device %s: %v", device.ID, err)
+		}
+	} else if err := s.router.EnsureExitRule(device.VpnIP); err != nil {
+		return nil, fmt.Errorf("failed to route traffic through exit node: %w", err)
+	}
+	return response, nil
+}
+
+// ApplyAll programs every approved route and exit node selection. Called on
+// startup since kernel routes and policy rules do not survive a reboot.
+func (s *RouteService) ApplyAll(ctx context.Context) error {
+	if s.router != nil {
+		// Without it, exit rules can't be added either, so devices keep
+		// their traffic rather than using an unfiltered exit node
+		if err := s.router.EnsureExitFirewall(s.vpnNetworks); err != nil {
+			log.Printf("Warning: failed to set up exit node firewall: %v", err)
+		}
+	}
+
+	approved, err := s.routeRepo.GetByStatus(ctx, models.RouteStatusApproved)
+	if err != nil {
+		return fmt.Errorf("failed to get approved routes: %w", err)
+	}
+
+	applied := make(map[uuid.UUID]bool)
+	exitNodes := make(map[uuid.UUID]bool)
+	for _, route := range approved {
+		if route.IsExit() {
+			exitNodes[route.DeviceID] = true
+		}
+		if applied[route.DeviceID] {
+			continue
+		}
+		applied[route.DeviceID] = true
+
+		device, err := s.deviceRepo.GetByID(ctx, route.DeviceID)
+		if err != nil {
+			return fmt.Errorf("failed to get device %s: %w", route.DeviceID, err)
+		}
+		if device != nil {
+			s.applyDevice(ctx, device, nil)
+		}
+	}
+
+	users, err := s.deviceRepo.GetUsingExitNode(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get exit node users: %w", err)
+	}
+	for _, device := range users {
+		if !exitNodes[*device.ExitNodeID] {
+			// Exit node was removed or its route withdrawn while we were down
+			if err := s.deviceRepo.UpdateExitNode(ctx, device.ID, nil); err != nil {
+				log.Printf("Warning: failed to clear exit node for device %s: %v", device.ID, err)
+			}
+			continue
+		}
+		if s.router != nil {
+			if err := s.router.EnsureExitRule(device.VpnIP); err != nil {
+				log.Printf("Warning: failed to add exit rule for device %s: %v", device.ID, err)
+			}
+		}
+	}
+
+	log.Printf("Applied %d approved routes (%d devices using an exit node)", len(approved), len(users))
+	return nil
+}
+
+// ReapplyUser re-programs a user's routes after their devices were
+// re-addressed, since re-homing resets peer allowed IPs
+func (s *RouteService) ReapplyUser(ctx context.Context, userID uuid.UUID, rehomed []models.RehomedDevice) {
+	if s.router != nil {
+		for _, device := range rehomed {
+			if err := s.router.RemoveExitRule(device.OldVpnIP); err != nil {
+				log.Printf("Warning: failed to remove exit rule for %s: %v", device.OldVpnIP, err)
+			}
+		}
+	}
+
+	devices, err := s.deviceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		log.Printf("Warning: failed to get devices of user %s: %v", userID, err)
+		return
+	}
+	for i := range devices {
+		device := &devices[i]
+		s.applyDevice(ctx, device, nil)
+		if device.ExitNodeID != nil && s.router != nil {
+			if err := s.router.EnsureExitRule(device.VpnIP); err != nil {
+				log.Printf("Warning: failed to add exit rule for device %s: %v", device.ID, err)
+			}
+		}
+	}
+}
+
+// applyDevice sets a device's peer allowed IPs to its VPN IP plus its
+// approved routes and updates kernel routes. Removed routes are taken off
+// the server; a removed exit route also clears it for devices using it.
+func (s *RouteService) applyDevice(ctx context.Context, device *models.Device, removed []models.DeviceRoute) {
+	for _, route := range removed {
+		if route.IsExit() {
+			s.clearExitUsers(ctx, device.ID)
+		} else if s.router != nil {
+			if err := s.router.RemoveRoute(route.CIDR); err != nil {
+				log.Printf("Warning: failed to remove route %s: %v", route.CIDR, err)
+			}
+		}
+	}
+
+	if s.router == nil {
+		return
+	}
+
+	routes, err := s.routeRepo.GetByDevice(ctx, device.ID)
+	if err != nil {
+		log.Printf("Warning: failed to get routes of device %s: %v", device.ID, err)
+		return
+	}
+
+	allowed := []string{device.VpnIP + "/32"}
+	var subnets []string
+	for _, route := range routes {
+		if route.Status != models.RouteStatusApproved {
+			continue
+		}
+		if route.IsExit() {
+			// Anything but the VPN networks, so that the exit node can't
+			// send from other devices' addresses
+			allowed = append(allowed, ExitAllowedIPs(s.vpnNetworks)...)
+			continue
+		}
+		allowed = append(allowed, route.CIDR)
+		subnets = append(subnets, route.CIDR)
+	}
+
+	if err := s.router.SetPeerAllowedIPs(device.PublicKey, allowed); err != nil {
+		log.Printf("Warning: failed to update WireGuard peer for device %s: %v", device.ID, err)
+		return
+	}
+	for _, cidr := range subnets {
+		if err := s.router.EnsureRoute(cidr); err != nil {
+			log.Printf("Warning: failed to add route %s: %v", cidr, err)
+		}
+	}
+}
+
+// ExitAllowedIPs returns the IPv4 address space outside the VPN networks as
+// a list of CIDRs: the allowed IPs of an exit node's peer
+func ExitAllowedIPs(vpnNetworks []string) []string {
+	prefixes := []netip.Prefix{netip.MustParsePrefix(models.ExitRouteCIDR)}
+	for _, network := range vpnNetworks {
+		excluded, err := netip.ParsePrefix(network)
+		if err != nil || !excluded.Addr().Is4() {
+			continue
+		}
+		excluded = excluded.Masked()
+
+		var remaining []netip.Prefix
+		for _, prefix := range prefixes {
+			remaining = append(remaining, excludePrefix(prefix, excluded)...)
+		}
+		prefixes = remaining
+	}
+
+	cidrs := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		cidrs = append(cidrs, prefix.String())
+	}
+	return cidrs
+}
+
+// excludePrefix returns the parts of prefix outside excluded
+func excludePrefix(prefix, excluded netip.Prefix) []netip.Prefix {
+	if !prefix.Overlaps(excluded) {
+		return []netip.Prefix{prefix}
+	}
+	if excluded.Bits() <= prefix.Bits() {
+		return nil // Entirely excluded
+	}
+
+	// Split in halves until they no longer overlap the excluded range
+	bits := prefix.Bits() + 1
+	lower := netip.PrefixFrom(prefix.Addr(), bits)
+	upperAddr := prefix.Addr().As4()
+	upperAddr[(bits-1)/8] |= 0x80 >> ((bits - 1) % 8)
+	upper := netip.PrefixFrom(netip.AddrFrom4(upperAddr), bits)
+	return append(excludePrefix(lower, excluded), excludePrefix(upper, excluded)...)
+}
+
+// clearExitUsers stops routing traffic through an exit node that went away
+func (s *RouteService) clearExitUsers(ctx context.Context, exitNodeID uuid.UUID) {
+	users, err := s.deviceRepo.GetByExitNode(ctx, exitNodeID)
+	if err != nil {
+		log.Printf("Warning: failed to get devices using exit node %s: %v", exitNodeID, err)
+		return
+	}
+	for _, device := range users {
+		if err := s.deviceRepo.UpdateExitNode(ctx, device.ID, nil); err != nil {
+			log.Printf("Warning: failed to clear exit node for device %s: %v", device.ID, err)
+		}
+		if s.router != nil {
+			if err := s.router.RemoveExitRule(device.VpnIP); err != nil {
+				log.Printf("Warning: failed to remove exit rule for device %s: %v", device.ID, err)
+			}
+		}
+	}
+}
+
+func (s *RouteService) isApprovedExit(ctx context.Context, deviceID uuid.UUID) (bool, error) {
+	routes, err := s.routeRepo.GetByDevice(ctx, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get routes: %w", err)
+	}
+	for _, route := range routes {
+		if route.IsExit() && route.Status == models.RouteStatusApproved {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *RouteService) getRoute(ctx context.Context, routeID uuid.UUID) (*models.DeviceRoute, *models.Device, error) {
+	route, err := s.routeRepo.GetByID(ctx, routeID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get route: %w", err)
+	}
+	if route == nil {
+		return nil, nil, ErrRouteNotFound
+	}
+	device, err := s.deviceRepo.GetByID(ctx, route.DeviceID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get device: %w", err)
+	}
+	if device == nil {
+		return nil, nil, ErrRouteNotFound
+	}
+	return route, device, nil
+}
+
+func (s *RouteService) routeInfos(ctx context.Context, routes []models.DeviceRoute) ([]models.RouteInfo, error) {
+	devices := make(map[uuid.UUID]*models.Device)
+	infos := make([]models.RouteInfo, 0, len(routes))
+	for _, route := range routes {
+		device, ok := devices[route.DeviceID]
+		if !ok {
+			var err error
+			device, err = s.deviceRepo.GetByID(ctx, route.DeviceID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get device: %w", err)
+			}
+			devices[route.DeviceID] = device
+		}
+		if device == nil {
+			continue
+		}
+		infos = append(infos, routeInfo(route, device))
+	}
+	return infos, nil
+}
+
+func routeInfo(route models.DeviceRoute, device *models.Device) models.RouteInfo {
+	return models.RouteInfo{
+		ID:         route.ID.String(),
+		DeviceID:   device.ID.String(),
+		DeviceName: device.Name(),
+		VpnIP:      device.VpnIP,
+		CIDR:       route.CIDR,
+		Status:     route.Status,
+		ExitNode:   route.IsExit(),
+	}
+}
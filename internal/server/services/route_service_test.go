@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+func TestValidateRouteCIDR(t *testing.T) {
+	vpnNetworks := []string{"10.100.0.0/16"}
+
+	tests := []struct {
+		cidr    string
+		want    string
+		wantErr bool
+	}{
+		{"192.168.1.0/24", "192.168.1.0/24", false},
+		{"192.168.1.77/24", "192.168.1.0/24", false}, // Host bits cleared
+		{"172.16.0.0/12", "172.16.0.0/12", false},
+		{"0.0.0.0/0", "", true},     // Exit nodes are advertised separately
+		{"10.100.5.0/24", "", true}, // Inside the VPN network
+		{"10.0.0.0/8", "", true},    // Covers the VPN network
+		{"127.0.0.0/8", "", true},
+		{"224.0.0.0/4", "", true},
+		{"169.254.0.0/16", "", true},
+		{"fd00::/64", "", true},
+		{"not-a-cidr", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ValidateRouteCIDR(tt.cidr, vpnNetworks)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRouteCIDR(%q) error = %v, wantErr %v", tt.cidr, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ValidateRouteCIDR(%q) = %q, want %q", tt.cidr, got, tt.want)
+		}
+	}
+}
+
+// fakeRouter records what RouteService programs
+type fakeRouter struct {
+	allowed   map[string][]string
+	routes    map[string]bool
+	exitRules map[string]bool
+}
+
+func newFakeRouter() *fakeRouter {
+	return &fakeRouter{
+		allowed:   make(map[string][]string),
+		routes:    make(map[string]bool),
+		exitRules: make(map[string]bool),
+	}
+}
+
+func (f *fakeRouter) SetPeerAllowedIPs(publicKey string, cidrs []string) error {
+	f.allowed[publicKey] = cidrs
+	return nil
+}
+
+func (f *fakeRouter) EnsureRoute(cidr string) error {
+	f.routes[cidr] = true
+	return nil
+}
+
+func (f *fakeRouter) RemoveRoute(cidr string) error {
+	delete(f.routes, cidr)
+	return nil
+}
+
+func (f *fakeRouter) EnsureExitFirewall(vpnNetworks []string) error {
+	return nil
+}
+
+func (f *fakeRouter) EnsureExitRule(srcIP string) error {
+	f.exitRules[srcIP] = true
+	return nil
+}
+
+func (f *fakeRouter) RemoveExitRule(srcIP string) error {
+	delete(f.exitRules, srcIP)
+	return nil
+}
+
+func TestRouteService_AdvertiseApproveAndExitNode(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+
+	t.Setenv("WG_BASE_NETWORK", "10.200.0.0/16")
+	t.Setenv("WG_SUBNET_SIZE", "29")
+
+	subnetPool, err := NewSubnetPool(repos.Users, repos.Conflicts)
+	if err != nil {
+		t.Fatalf("Failed to create subnet pool: %v", err)
+	}
+
+	deviceService := NewDeviceService(repos.Devices, repos.Users, subnetPool, repos.DeviceAuth)
+	router := newFakeRouter()
+	service := NewRouteService(repos.Routes, repos.Devices, deviceService, subnetPool.Networks(), router)
+
+	testUser := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(21))
+	defer tdb.DeleteTestUser(ctx, testUser.ID)
+
+	homeServer := tdb.CreateTestDevice(ctx, testUser.ID, "home-server", "10.200.21.2")
+	defer tdb.DeleteTestDevice(ctx, homeServer.ID)
+	laptop := tdb.CreateTestDevice(ctx, testUser.ID, "laptop", "10.200.21.3")
+	defer tdb.DeleteTestDevice(ctx, laptop.ID)
+
+	// Test: advertised routes start pending and are not programmed
+	routes, err := service.Advertise(ctx, testUser.ID, homeServer.ID, []string{"192.168.50.1/24"}, true)
+	if err != nil {
+		t.Fatalf("Failed to advertise routes: %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %d: %v", len(routes), routes)
+	}
+	for _, route := range routes {
+		if route.Status != models.RouteStatusPending {
+			t.Errorf("Expected route %s to be pending, got %s", route.CIDR, route.Status)
+		}
+	}
+	if len(router.allowed) != 0 {
+		t.Errorf("Pending routes should not be programmed, got %v", router.allowed)
+	}
+
+	// Test: the exit node can't be selected before approval
+	if _, err := service.SetExitNode(ctx, testUser.ID, laptop.ID, &homeServer.ID); !errors.Is(err, ErrNotExitNode) {
+		t.Errorf("Expected ErrNotExitNode, got %v", err)
+	}
+
+	// Test: approval programs allowed IPs and kernel routes
+	for _, route := range routes {
+		if _, err := service.Approve(ctx, uuid.MustParse(route.ID)); err != nil {
+			t.Fatalf("Failed to approve route %s: %v", route.CIDR, err)
+		}
+	}
+	allowed := router.allowed[homeServer.PublicKey]
+	if len(allowed) < 3 || allowed[0] != "10.200.21.2/32" {
+		t.Errorf("Unexpected allowed IPs: %v", allowed)
+	}
+	for _, cidr := range allowed {
+		// The exit node must not be able to send from other VPN addresses
+		if cidr == models.ExitRouteCIDR || (cidr != allowed[0] && len(OverlappingCIDRs(cidr, subnetPool.Networks())) > 0) {
+			t.Errorf("Allowed IPs %v cover the VPN network with %s", allowed, cidr)
+		}
+	}
+	if !router.routes["192.168.50.0/24"] {
+		t.Errorf("Expected kernel route for 192.168.50.0/24, got %v", router.routes)
+	}
+
+	// Test: a device can't be its own exit node
+	if _, err := service.SetExitNode(ctx, testUser.ID, homeServer.ID, &homeServer.ID); !errors.Is(err, ErrSelfExitNode) {
+		t.Errorf("Expected ErrSelfExitNode, got %v", err)
+	}
+
+	// Test: selecting the exit node adds a policy rule for the laptop
+	if _, err := service.SetExitNode(ctx, testUser.ID, laptop.ID, &homeServer.ID); err != nil {
+		t.Fatalf("Failed to set exit node: %v", err)
+	}
+	if !router.exitRules["10.200.21.3"] {
+		t.Errorf("Expected exit rule for laptop, got %v", router.exitRules)
+	}
+
+	// Test: withdrawing the exit route clears it for the laptop
+	if _, err := service.Advertise(ctx, testUser.ID, homeServer.ID, []string{"192.168.50.0/24"}, false); err != nil {
+		t.Fatalf("Failed to re-advertise routes: %v", err)
+	}
+	if router.exitRules["10.200.21.3"] {
+		t.Error("Expected exit rule to be removed after withdrawal")
+	}
+	stored, _ := repos.Devices.GetByID(ctx, laptop.ID)
+	if stored.ExitNodeID != nil {
+		t.Errorf("Expected exit node to be cleared, got %v", stored.ExitNodeID)
+	}
+	if allowed := router.allowed[homeServer.PublicKey]; len(allowed) != 2 {
+		t.Errorf("Expected exit route removed from allowed IPs, got %v", allowed)
+	}
+
+	// Test: an overlapping route from another device can't be approved
+	routes, err = service.Advertise(ctx, testUser.ID, laptop.ID, []string{"192.168.50.128/25"}, false)
+	if err != nil {
+		t.Fatalf("Failed to advertise overlapping route: %v", err)
+	}
+	if _, err := service.Approve(ctx, uuid.MustParse(routes[0].ID)); !errors.Is(err, ErrRouteConflict) {
+		t.Errorf("Expected ErrRouteConflict, got %v", err)
+	}
+}
+
+func TestExitAllowedIPs(t *testing.T) {
+	vpnNetworks := []string{"10.100.0.0/16", "10.200.0.0/16"}
+	cidrs := ExitAllowedIPs(vpnNetworks)
+
+	// Every address outside the VPN networks is covered exactly once
+	for _, addr := range []string{"0.0.0.0", "1.1.1.1", "10.99.255.255", "10.101.0.0", "10.150.0.1", "192.168.1.1", "255.255.255.255"} {
+		covering := OverlappingCIDRs(addr+"/32", cidrs)
+		if len(covering) != 1 {
+			t.Errorf("%s is covered by %v", addr, covering)
+		}
+	}
+	for _, addr := range []string{"10.100.0.1", "10.100.255.255", "10.200.5.9"} {
+		if covering := OverlappingCIDRs(addr+"/32", cidrs); len(covering) != 0 {
+			t.Errorf("VPN address %s is covered by %v", addr, covering)
+		}
+	}
+
+	if cidrs := ExitAllowedIPs(nil); len(cidrs) != 1 || cidrs[0] != models.ExitRouteCIDR {
+		t.Errorf("ExitAllowedIPs(nil) = %v", cidrs)
+	}
+}
+
+func TestRouteService_SingleExitNode(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+
+	t.Setenv("WG_BASE_NETWORK", "10.200.0.0/16")
+	t.Setenv("WG_SUBNET_SIZE", "29")
+
+	subnetPool, err := NewSubnetPool(repos.Users, repos.Conflicts)
+	if err != nil {
+		t.Fatalf("Failed to create subnet pool: %v", err)
+	}
+	deviceService := NewDeviceService(repos.Devices, repos.Users, subnetPool, repos.DeviceAuth)
+	service := NewRouteService(repos.Routes, repos.Devices, deviceService, subnetPool.Networks(), newFakeRouter())
+
+	alice := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(32))
+	defer tdb.DeleteTestUser(ctx, alice.ID)
+	bob := tdb.CreateTestUser(ctx, testutil.GenerateTestEmail(), testutil.GenerateTestSubnet(33))
+	defer tdb.DeleteTestUser(ctx, bob.ID)
+	aliceExit := tdb.CreateTestDevice(ctx, alice.ID, "alice-exit", "10.200.32.2")
+	bobExit := tdb.CreateTestDevice(ctx, bob.ID, "bob-exit", "10.200.33.2")
+
+	approveExit := func(userID uuid.UUID, device *models.Device) error {
+		routes, err := service.Advertise(ctx, userID, device.ID, nil, true)
+		if err != nil {
+			t.Fatalf("Failed to advertise exit node: %v", err)
+		}
+		_, err = service.Approve(ctx, uuid.MustParse(routes[0].ID))
+		return err
+	}
+
+	// Test: the server has room for one exit node, whoever it belongs to
+	if err := approveExit(alice.ID, aliceExit); err != nil {
+		t.Fatalf("Failed to approve first exit node: %v", err)
+	}
+	if err := approveExit(bob.ID, bobExit); !errors.Is(err, ErrExitNodeTaken) {
+		t.Errorf("Expected ErrExitNodeTaken for a second exit node, got %v", err)
+	}
+
+	// Test: the slot frees up once the first exit node is withdrawn
+	if _, err := service.Advertise(ctx, alice.ID, aliceExit.ID, nil, false); err != nil {
+		t.Fatalf("Failed to withdraw exit node: %v", err)
+	}
+	if err := approveExit(bob.ID, bobExit); err != nil {
+		t.Errorf("Failed to approve exit node after withdrawal: %v", err)
+	}
+}
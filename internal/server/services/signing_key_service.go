@@ -0,0 +1,413 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+)
+
+const (
+	signingAlgorithm = "EdDSA"
+
+	// How often the server reloads keys (picks up rotations from the admin CLI)
+	// and checks whether a scheduled rotation is due
+	signingKeyCheckInterval = time.Minute
+
+	// Unknown kids trigger a reload, but at most this often
+	signingKeyReloadThrottle = 10 * time.Second
+
+	// Longest lifetime of HS256 tokens issued before signing keys (user tokens)
+	legacyHS256Lifetime = 30 * 24 * time.Hour
+)
+
+// SigningKeyService signs access tokens with rotating Ed25519 keys and
+// verifies them against every key that hasn't retired yet
+type SigningKeyService struct {
+	repo             *storage.SigningKeyRepository
+	rotationInterval time.Duration
+	overlap          time.Duration
+	encryption       *KeyEncryption
+
+	mu         sync.RWMutex
+	legacy     utils.LegacyHS256
+	current    *models.SigningKey
+	signer     ed25519.PrivateKey
+	verifiers  map[string]ed25519.PublicKey
+	keys       []models.SigningKey
+	lastReload time.Time
+}
+
+// NewSigningKeyService reads the rotation schedule from the environment:
+// JWT_KEY_ROTATION_INTERVAL (default 30 days) is how long a key signs tokens,
+// JWT_KEY_OVERLAP (default 30 days) how long it keeps verifying afterwards and
+// must cover the longest token lifetime. HS256 tokens signed with JWT_SECRET
+// are still accepted while JWT_SECRET is set, until LEGACY_HS256_UNTIL
+// (RFC 3339) or, by default, 30 days after the switch to signing keys.
+func NewSigningKeyService(repo *storage.SigningKeyRepository) *SigningKeyService {
+	legacy := utils.LegacyHS256{Secret: os.Getenv("JWT_SECRET")}
+	if value := os.Getenv("LEGACY_HS256_UNTIL"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Printf("Warning: invalid LEGACY_HS256_UNTIL %q, using the stored cutoff", value)
+		}
+		legacy.Until = until
+	}
+
+	return &SigningKeyService{
+		repo:             repo,
+		rotationInterval: durationFromEnv("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+		overlap:          durationFromEnv("JWT_KEY_OVERLAP", 30*24*time.Hour),
+		legacy:           legacy,
+		verifiers:        make(map[string]ed25519.PublicKey),
+	}
+}
+
+// SetEncryption encrypts private keys at rest. Without it keys are stored in
+// plaintext, as they were before encryption was configured.
+func (s *SigningKeyService) SetEncryption(encryption *KeyEncryption) {
+	s.encryption = encryption
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// Init loads the keys, encrypts keys stored in plaintext and creates the
+// first signing key if there is none
+func (s *SigningKeyService) Init(ctx context.Context) error {
+	if err := s.loadLegacyCutoff(ctx); err != nil {
+		return err
+	}
+
+	return s.repo.WithLock(ctx, func() error {
+		if err := s.Reload(ctx); err != nil {
+			return err
+		}
+		if err := s.encryptStoredKeys(ctx); err != nil {
+			return err
+		}
+
+		s.mu.RLock()
+		hasKey := s.current != nil
+		s.mu.RUnlock()
+
+		if !hasKey {
+			key, err := s.rotate(ctx)
+			if err != nil {
+				return err
+			}
+			log.Printf("Created JWT signing key %s", key.KID)
+		}
+		return nil
+	})
+}
+
+// loadLegacyCutoff reads when HS256 tokens stop being accepted, unless
+// LEGACY_HS256_UNTIL set it
+func (s *SigningKeyService) loadLegacyCutoff(ctx context.Context) error {
+	s.mu.RLock()
+	legacy := s.legacy
+	s.mu.RUnlock()
+
+	if legacy.Secret == "" || !legacy.Until.IsZero() {
+		return nil
+	}
+
+	until, err := s.repo.LegacyHS256Until(ctx, legacyHS256Lifetime)
+	if err != nil {
+		return fmt.Errorf("failed to load the HS256 cutoff: %w", err)
+	}
+	if time.Now().Before(until) {
+		log.Printf("Accepting legacy HS256 tokens until %s", until.Format(time.RFC3339))
+	}
+
+	s.mu.Lock()
+	s.legacy.Until = until
+	s.mu.Unlock()
+	return nil
+}
+
+// encryptStoredKeys encrypts private keys stored before encryption was
+// configured
+func (s *SigningKeyService) encryptStoredKeys(ctx context.Context) error {
+	var plaintext []models.SigningKey
+	for _, key := range s.Keys() {
+		if !IsSealed(key.PrivateKey) {
+			plaintext = append(plaintext, key)
+		}
+	}
+	if len(plaintext) == 0 {
+		return nil
+	}
+	if s.encryption == nil {
+		log.Printf("Warning: JWT signing keys are stored unencrypted, set KEY_ENCRYPTION_KEY to encrypt them")
+		return nil
+	}
+
+	for _, key := range plaintext {
+		sealed, err := s.encryption.Seal(key.PrivateKey, key.KID)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt signing key %s: %w", key.KID, err)
+		}
+		if err := s.repo.UpdatePrivateKey(ctx, key.KID, sealed); err != nil {
+			return fmt.Errorf("failed to store encrypted signing key %s: %w", key.KID, err)
+		}
+	}
+	log.Printf("Encrypted %d JWT signing key(s)", len(plaintext))
+
+	return s.Reload(ctx)
+}
+
+// Reload reads the valid keys from the database
+func (s *SigningKeyService) Reload(ctx context.Context) error {
+	keys, err := s.repo.ListValid(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	verifiers := make(map[string]ed25519.PublicKey, len(keys))
+	var current *models.SigningKey
+	var signer ed25519.PrivateKey
+
+	for i := range keys {
+		key := &keys[i]
+		public, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil || len(public) != ed25519.PublicKeySize {
+			log.Printf("Warning: skipping malformed signing key %s", key.KID)
+			continue
+		}
+		verifiers[key.KID] = ed25519.PublicKey(public)
+
+		// Keys are ordered newest first
+		if current == nil && key.IsCurrent() {
+			private, err := s.privateKey(key)
+			if errors.Is(err, ErrKeyEncryptionRequired) {
+				return err
+			}
+			if err != nil {
+				log.Printf("Warning: skipping signing key %s: %v", key.KID, err)
+				continue
+			}
+			current = key
+			signer = private
+		}
+	}
+
+	s.mu.Lock()
+	s.current = current
+	s.signer = signer
+	s.verifiers = verifiers
+	s.keys = keys
+	s.lastReload = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// privateKey decodes the private key of key, decrypting it if it is stored
+// encrypted
+func (s *SigningKeyService) privateKey(key *models.SigningKey) (ed25519.PrivateKey, error) {
+	encoded := key.PrivateKey
+	if IsSealed(encoded) {
+		if s.encryption == nil {
+			return nil, ErrKeyEncryptionRequired
+		}
+		opened, err := s.encryption.Open(encoded, key.KID)
+		if err != nil {
+			return nil, err
+		}
+		encoded = opened
+	}
+
+	private, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(private) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("malformed private key")
+	}
+	return ed25519.PrivateKey(private), nil
+}
+
+// Rotate creates a new signing key. The previous key keeps verifying tokens
+// for the overlap period.
+func (s *SigningKeyService) Rotate(ctx context.Context) (*models.SigningKey, error) {
+	var key *models.SigningKey
+	err := s.repo.WithLock(ctx, func() error {
+		var err error
+		key, err = s.rotate(ctx)
+		return err
+	})
+	return key, err
+}
+
+// rotate creates a new signing key; the caller holds the lock
+func (s *SigningKeyService) rotate(ctx context.Context) (*models.SigningKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	key := &models.SigningKey{
+		KID:        keyID(public),
+		Algorithm:  signingAlgorithm,
+		PrivateKey: base64.StdEncoding.EncodeToString(private),
+		PublicKey:  base64.StdEncoding.EncodeToString(public),
+	}
+	if s.encryption != nil {
+		if key.PrivateKey, err = s.encryption.Seal(key.PrivateKey, key.KID); err != nil {
+			return nil, fmt.Errorf("failed to encrypt signing key: %w", err)
+		}
+	}
+
+	if err := s.repo.Rotate(ctx, key, s.overlap); err != nil {
+		return nil, fmt.Errorf("failed to store signing key: %w", err)
+	}
+
+	if err := s.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// keyID derives a short, stable kid from the public key
+func keyID(public ed25519.PublicKey) string {
+	sum := sha256.Sum256(public)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// Run reloads keys, rotates on schedule and prunes retired keys until ctx is done
+func (s *SigningKeyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(signingKeyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.maintain(ctx); err != nil {
+				log.Printf("Warning: JWT signing key maintenance failed: %v", err)
+			}
+		}
+	}
+}
+
+// maintain runs under the lock, so that only one server instance rotates a
+// key that is due
+func (s *SigningKeyService) maintain(ctx context.Context) error {
+	return s.repo.WithLock(ctx, func() error {
+		return s.maintainLocked(ctx)
+	})
+}
+
+func (s *SigningKeyService) maintainLocked(ctx context.Context) error {
+	if removed, err := s.repo.DeleteRetired(ctx); err != nil {
+		return fmt.Errorf("failed to prune retired keys: %w", err)
+	} else if removed > 0 {
+		log.Printf("Pruned %d retired JWT signing key(s)", removed)
+	}
+
+	if err := s.Reload(ctx); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	due := s.current == nil || time.Since(s.current.CreatedAt) >= s.rotationInterval
+	s.mu.RUnlock()
+
+	if due {
+		key, err := s.rotate(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rotated JWT signing key, new kid %s", key.KID)
+	}
+	return nil
+}
+
+// Sign signs claims with the current key
+func (s *SigningKeyService) Sign(claims utils.Claims) (string, error) {
+	s.mu.RLock()
+	current, signer := s.current, s.signer
+	s.mu.RUnlock()
+
+	if current == nil {
+		return "", fmt.Errorf("no JWT signing key available")
+	}
+	return utils.SignJWT(claims, current.KID, signer)
+}
+
+// Verify validates an access token signed by any valid key, or a legacy
+// HS256 token while JWT_SECRET is set and the cutoff hasn't passed
+func (s *SigningKeyService) Verify(tokenString string) (*utils.Claims, error) {
+	s.mu.RLock()
+	legacy := s.legacy
+	s.mu.RUnlock()
+	return utils.ParseJWT(tokenString, s.lookup, legacy)
+}
+
+// lookup finds a verification key, reloading once if the kid is unknown (the
+// admin CLI may have rotated keys since the last reload)
+func (s *SigningKeyService) lookup(kid string) (ed25519.PublicKey, bool) {
+	s.mu.RLock()
+	key, ok := s.verifiers[kid]
+	stale := time.Since(s.lastReload) > signingKeyReloadThrottle
+	s.mu.RUnlock()
+
+	if ok || !stale {
+		return key, ok
+	}
+
+	if err := s.Reload(context.Background()); err != nil {
+		log.Printf("Warning: %v", err)
+		return nil, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok = s.verifiers[kid]
+	return key, ok
+}
+
+// Keys returns the current and retired keys that still verify, newest first
+func (s *SigningKeyService) Keys() []models.SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.SigningKey(nil), s.keys...)
+}
+
+// JWKS returns the public verification keys
+func (s *SigningKeyService) JWKS() models.JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jwks := models.JWKS{Keys: []models.JWK{}}
+	for _, key := range s.keys {
+		public, ok := s.verifiers[key.KID]
+		if !ok {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, models.JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(public),
+			KeyID:     key.KID,
+			Algorithm: signingAlgorithm,
+			Use:       "sig",
+		})
+	}
+	return jwks
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/kamikazebr/roamie-desktop/pkg/utils"
+	"github.com/google/uuid"
+)
+
+func TestSigningKeyService_Rotate(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	tdb.CleanupTable(ctx, "jwt_signing_keys")
+	defer tdb.CleanupTable(ctx, "jwt_signing_keys")
+
+	t.Setenv("JWT_SECRET", "")
+	service := NewSigningKeyService(tdb.Repositories().SigningKeys)
+
+	// Test: Init creates the first key
+	if err := service.Init(ctx); err != nil {
+		t.Fatalf("Failed to init signing keys: %v", err)
+	}
+	if keys := service.Keys(); len(keys) != 1 || !keys[0].IsCurrent() {
+		t.Fatalf("Expected one current key, got %+v", keys)
+	}
+
+	claims := utils.NewClaims(uuid.New(), uuid.Nil, "user@example.com", time.Minute)
+	before, err := service.Sign(claims)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	// Test: after rotation old tokens still verify and both keys are published
+	if _, err := service.Rotate(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if _, err := service.Verify(before); err != nil {
+		t.Errorf("Token signed before rotation should verify: %v", err)
+	}
+	if jwks := service.JWKS(); len(jwks.Keys) != 2 {
+		t.Errorf("Expected 2 published keys, got %d", len(jwks.Keys))
+	}
+
+	after, err := service.Sign(claims)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if _, err := service.Verify(after); err != nil {
+		t.Errorf("Token signed after rotation should verify: %v", err)
+	}
+
+	// Test: retired keys stop verifying once their overlap ends
+	tdb.Exec(ctx, `UPDATE jwt_signing_keys SET retires_at = NOW() - INTERVAL '1 minute' WHERE retires_at IS NOT NULL`)
+	if err := service.maintain(ctx); err != nil {
+		t.Fatalf("Maintenance failed: %v", err)
+	}
+	if _, err := service.Verify(before); err == nil {
+		t.Error("Token signed by a retired key should be rejected")
+	}
+
+	// Test: legacy HS256 tokens are rejected without JWT_SECRET
+	legacy, _ := utils.GenerateJWT(uuid.New(), "user@example.com", "old-secret", time.Minute)
+	if _, err := service.Verify(legacy); err == nil {
+		t.Error("Legacy token should be rejected when JWT_SECRET is unset")
+	}
+}
+
+func TestSigningKeyService_Encryption(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	tdb.CleanupTable(ctx, "jwt_signing_keys")
+	defer tdb.CleanupTable(ctx, "jwt_signing_keys")
+
+	t.Setenv("JWT_SECRET", "")
+	repo := tdb.Repositories().SigningKeys
+
+	// A key stored before encryption was configured
+	plain := NewSigningKeyService(repo)
+	if err := plain.Init(ctx); err != nil {
+		t.Fatalf("Failed to init signing keys: %v", err)
+	}
+	token, err := plain.Sign(utils.NewClaims(uuid.New(), uuid.Nil, "user@example.com", time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	// Test: Init encrypts it and keeps signing with it
+	encryption, _ := NewKeyEncryption(make([]byte, 32))
+	service := NewSigningKeyService(repo)
+	service.SetEncryption(encryption)
+	if err := service.Init(ctx); err != nil {
+		t.Fatalf("Failed to init with encryption: %v", err)
+	}
+	if _, err := service.Rotate(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	for _, key := range service.Keys() {
+		if !IsSealed(key.PrivateKey) {
+			t.Errorf("Key %s is stored unencrypted", key.KID)
+		}
+	}
+	if _, err := service.Verify(token); err != nil {
+		t.Errorf("Token signed before encryption should verify: %v", err)
+	}
+	if _, err := service.Sign(utils.NewClaims(uuid.New(), uuid.Nil, "user@example.com", time.Minute)); err != nil {
+		t.Errorf("Failed to sign with an encrypted key: %v", err)
+	}
+
+	// Test: encrypted keys can't be loaded without the key encryption key
+	if err := NewSigningKeyService(repo).Reload(ctx); !errors.Is(err, ErrKeyEncryptionRequired) {
+		t.Errorf("Expected ErrKeyEncryptionRequired, got %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// Certificates are valid from slightly before they are issued so a device
+// whose clock runs behind still accepts them
+const sshCertClockSkew = time.Minute
+
+// SSHCertificateAuthority signs SSH certificates. User certificates are
+// short-lived and issued per connection, so removing a device or user stops
+// new SSH logins immediately instead of at the next key sync.
+type SSHCertificateAuthority struct {
+	repo          *storage.SSHCARepository
+	userCertTTL   time.Duration
+	hostCertTTL   time.Duration
+	tunnelCertTTL time.Duration
+	encryption    *KeyEncryption
+
+	userCA ssh.Signer
+	hostCA ssh.Signer
+}
+
+// NewSSHCertificateAuthority reads certificate lifetimes from the
+// environment: SSH_USER_CERT_TTL (default 5 minutes, only needs to cover the
+// login), SSH_TUNNEL_CERT_TTL (default 10 minutes) and SSH_HOST_CERT_TTL
+// (default 90 days, devices renew when a third is left).
+func NewSSHCertificateAuthority(repo *storage.SSHCARepository) *SSHCertificateAuthority {
+	return &SSHCertificateAuthority{
+		repo:          repo,
+		userCertTTL:   durationFromEnv("SSH_USER_CERT_TTL", 5*time.Minute),
+		hostCertTTL:   durationFromEnv("SSH_HOST_CERT_TTL", 90*24*time.Hour),
+		tunnelCertTTL: durationFromEnv("SSH_TUNNEL_CERT_TTL", 10*time.Minute),
+	}
+}
+
+// SetEncryption encrypts the CA private keys at rest. Without it keys are
+// stored in plaintext, as they were before encryption was configured.
+func (a *SSHCertificateAuthority) SetEncryption(encryption *KeyEncryption) {
+	a.encryption = encryption
+}
+
+// Init loads the CA keys, creating them on first start and encrypting keys
+// stored in plaintext
+func (a *SSHCertificateAuthority) Init(ctx context.Context) error {
+	var err error
+	if a.userCA, err = a.loadOrCreate(ctx, models.SSHCAKindUser); err != nil {
+		return err
+	}
+	if a.hostCA, err = a.loadOrCreate(ctx, models.SSHCAKindHost); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *SSHCertificateAuthority) loadOrCreate(ctx context.Context, kind string) (ssh.Signer, error) {
+	key, err := a.repo.Get(ctx, kind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SSH %s CA: %w", kind, err)
+	}
+
+	if key == nil {
+		generated, err := generateSSHCAKey(kind)
+		if err != nil {
+			return nil, err
+		}
+		if a.encryption != nil {
+			if generated.PrivateKey, err = a.encryption.Seal(generated.PrivateKey, sshCAKeyName(kind)); err != nil {
+				return nil, fmt.Errorf("failed to encrypt SSH %s CA: %w", kind, err)
+			}
+		}
+		if key, err = a.repo.Create(ctx, generated); err != nil {
+			return nil, fmt.Errorf("failed to store SSH %s CA: %w", kind, err)
+		}
+		if key == nil {
+			return nil, fmt.Errorf("SSH %s CA was not stored", kind)
+		}
+		log.Printf("Created SSH %s CA", kind)
+	}
+
+	private, err := a.privateKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(private))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH %s CA: %w", kind, err)
+	}
+	return signer, nil
+}
+
+// privateKey returns the PEM private key of a CA key, decrypting it, or
+// encrypting it in the database if it was stored before encryption was
+// configured
+func (a *SSHCertificateAuthority) privateKey(ctx context.Context, key *models.SSHCAKey) (string, error) {
+	if IsSealed(key.PrivateKey) {
+		if a.encryption == nil {
+			return "", fmt.Errorf("failed to load SSH %s CA: %w", key.Kind, ErrKeyEncryptionRequired)
+		}
+		private, err := a.encryption.Open(key.PrivateKey, sshCAKeyName(key.Kind))
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt SSH %s CA: %w", key.Kind, err)
+		}
+		return private, nil
+	}
+
+	if a.encryption == nil {
+		log.Printf("Warning: SSH %s CA is stored unencrypted, set KEY_ENCRYPTION_KEY to encrypt it", key.Kind)
+		return key.PrivateKey, nil
+	}
+	sealed, err := a.encryption.Seal(key.PrivateKey, sshCAKeyName(key.Kind))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt SSH %s CA: %w", key.Kind, err)
+	}
+	if err := a.repo.UpdatePrivateKey(ctx, key.Kind, key.PrivateKey, sealed); err != nil {
+		return "", fmt.Errorf("failed to store encrypted SSH %s CA: %w", key.Kind, err)
+	}
+	log.Printf("Encrypted SSH %s CA", key.Kind)
+	return key.PrivateKey, nil
+}
+
+// sshCAKeyName is authenticated with an encrypted CA key, so the user and
+// host CA keys can't be swapped
+func sshCAKeyName(kind string) string {
+	return "ssh-ca:" + kind
+}
+
+func generateSSHCAKey(kind string) (*models.SSHCAKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SSH CA key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "roamie "+kind+" CA")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SSH CA key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SSHCAKey{
+		Kind:       kind,
+		PrivateKey: string(pem.EncodeToMemory(block)),
+		PublicKey:  authorizedKey(signer.PublicKey()),
+	}, nil
+}
+
+// UserCA returns the key sshd should trust for user certificates
+func (a *SSHCertificateAuthority) UserCA() ssh.PublicKey {
+	return a.userCA.PublicKey()
+}
+
+// HostCA returns the key ssh clients should trust for host certificates
+func (a *SSHCertificateAuthority) HostCA() ssh.PublicKey {
+	return a.hostCA.PublicKey()
+}
+
+// PublicKeys returns both CA public keys
+func (a *SSHCertificateAuthority) PublicKeys() models.SSHCAResponse {
+	return models.SSHCAResponse{
+		UserCA: authorizedKey(a.UserCA()),
+		HostCA: authorizedKey(a.HostCA()),
+	}
+}
+
+// SignUserCertificate lets key log in as principals on devices that trust
+// the user CA
+func (a *SSHCertificateAuthority) SignUserCertificate(key ssh.PublicKey, keyID string, principals []string) (*ssh.Certificate, error) {
+	extensions := map[string]string{
+		"permit-X11-forwarding":   "",
+		"permit-agent-forwarding": "",
+		"permit-port-forwarding":  "",
+		"permit-pty":              "",
+		"permit-user-rc":          "",
+	}
+	return a.sign(a.userCA, key, ssh.UserCert, keyID, principals, a.userCertTTL, extensions)
+}
+
+// SignTunnelCertificate lets a device's tunnel key open its reverse tunnel
+func (a *SSHCertificateAuthority) SignTunnelCertificate(key ssh.PublicKey, deviceID string) (*ssh.Certificate, error) {
+	extensions := map[string]string{
+		"permit-port-forwarding": "",
+	}
+	return a.sign(a.userCA, key, ssh.UserCert, deviceID, []string{models.SSHTunnelPrincipal}, a.tunnelCertTTL, extensions)
+}
+
+// SignHostCertificate certifies a device's sshd host key for principals
+// (its VPN IP and DNS name)
+func (a *SSHCertificateAuthority) SignHostCertificate(key ssh.PublicKey, keyID string, principals []string) (*ssh.Certificate, error) {
+	return a.sign(a.hostCA, key, ssh.HostCert, keyID, principals, a.hostCertTTL, nil)
+}
+
+func (a *SSHCertificateAuthority) sign(ca ssh.Signer, key ssh.PublicKey, certType uint32, keyID string, principals []string, ttl time.Duration, extensions map[string]string) (*ssh.Certificate, error) {
+	if len(principals) == 0 {
+		return nil, fmt.Errorf("certificate needs at least one principal")
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("cannot sign a certificate")
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        certType,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-sshCertClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(ttl).Unix()),
+		Permissions:     ssh.Permissions{Extensions: extensions},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// authorizedKey formats key as a single authorized_keys line
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newTestCA(t *testing.T) *SSHCertificateAuthority {
+	return &SSHCertificateAuthority{
+		userCertTTL:   5 * time.Minute,
+		hostCertTTL:   24 * time.Hour,
+		tunnelCertTTL: 10 * time.Minute,
+		userCA:        newTestSigner(t),
+		hostCA:        newTestSigner(t),
+	}
+}
+
+func TestSSHCertificateAuthority_SignUserCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	key := newTestSigner(t).PublicKey()
+
+	cert, err := ca.SignUserCertificate(key, "user=alice@example.com", []string{"alice"})
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	checker := &ssh.CertChecker{}
+	if err := checker.CheckCert("alice", cert); err != nil {
+		t.Errorf("Certificate should be valid for alice: %v", err)
+	}
+	if err := checker.CheckCert("root", cert); err == nil {
+		t.Error("Certificate should not be valid for root")
+	}
+	if cert.CertType != ssh.UserCert || !bytes.Equal(cert.SignatureKey.Marshal(), ca.UserCA().Marshal()) {
+		t.Error("Expected a user certificate signed by the user CA")
+	}
+	if _, ok := cert.Permissions.Extensions["permit-pty"]; !ok {
+		t.Error("Expected permit-pty extension")
+	}
+
+	// Short-lived: expires after the TTL
+	checker.Clock = func() time.Time { return time.Now().Add(6 * time.Minute) }
+	if err := checker.CheckCert("alice", cert); err == nil {
+		t.Error("Certificate should have expired")
+	}
+}
+
+func TestSSHCertificateAuthority_SignHostAndTunnelCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	key := newTestSigner(t).PublicKey()
+
+	host, err := ca.SignHostCertificate(key, "host device=1", []string{"10.100.0.2", "laptop.alice.roamie.internal"})
+	if err != nil {
+		t.Fatalf("Failed to sign host certificate: %v", err)
+	}
+	if host.CertType != ssh.HostCert || !bytes.Equal(host.SignatureKey.Marshal(), ca.HostCA().Marshal()) {
+		t.Error("Expected a host certificate signed by the host CA")
+	}
+
+	tunnel, err := ca.SignTunnelCertificate(key, "device-id")
+	if err != nil {
+		t.Fatalf("Failed to sign tunnel certificate: %v", err)
+	}
+	if tunnel.KeyId != "device-id" || len(tunnel.ValidPrincipals) != 1 || tunnel.ValidPrincipals[0] != models.SSHTunnelPrincipal {
+		t.Errorf("Unexpected tunnel certificate: key ID %q, principals %v", tunnel.KeyId, tunnel.ValidPrincipals)
+	}
+
+	// Certificates can't be re-signed, and every certificate needs a principal
+	if _, err := ca.SignUserCertificate(tunnel, "user", []string{"alice"}); err == nil {
+		t.Error("Expected signing a certificate to fail")
+	}
+	if _, err := ca.SignUserCertificate(key, "user", nil); err == nil {
+		t.Error("Expected signing without principals to fail")
+	}
+}
+
+func TestSSHCertificateAuthority_Init(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	tdb.CleanupTable(ctx, "ssh_ca_keys")
+	defer tdb.CleanupTable(ctx, "ssh_ca_keys")
+
+	// Test: Init creates both CAs, and a second instance loads the same keys
+	first := NewSSHCertificateAuthority(tdb.Repositories().SSHCA)
+	if err := first.Init(ctx); err != nil {
+		t.Fatalf("Failed to init SSH CA: %v", err)
+	}
+	second := NewSSHCertificateAuthority(tdb.Repositories().SSHCA)
+	if err := second.Init(ctx); err != nil {
+		t.Fatalf("Failed to init second SSH CA: %v", err)
+	}
+
+	if first.PublicKeys() != second.PublicKeys() {
+		t.Error("Expected both instances to share the CA keys")
+	}
+	if first.PublicKeys().UserCA == first.PublicKeys().HostCA {
+		t.Error("Expected separate user and host CAs")
+	}
+}
+
+func TestSSHCertificateAuthority_Encryption(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	tdb.CleanupTable(ctx, "ssh_ca_keys")
+	defer tdb.CleanupTable(ctx, "ssh_ca_keys")
+	repo := tdb.Repositories().SSHCA
+
+	// CA keys stored before encryption was configured
+	plain := NewSSHCertificateAuthority(repo)
+	if err := plain.Init(ctx); err != nil {
+		t.Fatalf("Failed to init SSH CA: %v", err)
+	}
+
+	// Test: Init encrypts them and keeps the same CAs
+	encryption, _ := NewKeyEncryption(make([]byte, 32))
+	ca := NewSSHCertificateAuthority(repo)
+	ca.SetEncryption(encryption)
+	if err := ca.Init(ctx); err != nil {
+		t.Fatalf("Failed to init with encryption: %v", err)
+	}
+	if ca.PublicKeys() != plain.PublicKeys() {
+		t.Error("Expected encryption to keep the CA keys")
+	}
+	for _, kind := range []string{models.SSHCAKindUser, models.SSHCAKindHost} {
+		key, err := repo.Get(ctx, kind)
+		if err != nil || key == nil || !IsSealed(key.PrivateKey) {
+			t.Errorf("SSH %s CA is stored unencrypted", kind)
+		}
+	}
+
+	// Test: encrypted CA keys can't be loaded without the key encryption key
+	if err := NewSSHCertificateAuthority(repo).Init(ctx); !errors.Is(err, ErrKeyEncryptionRequired) {
+		t.Errorf("Expected ErrKeyEncryptionRequired, got %v", err)
+	}
+
+	// Test: a fresh CA is created encrypted
+	tdb.CleanupTable(ctx, "ssh_ca_keys")
+	if err := ca.Init(ctx); err != nil {
+		t.Fatalf("Failed to create encrypted CA: %v", err)
+	}
+	if key, _ := repo.Get(ctx, models.SSHCAKindUser); key == nil || !IsSealed(key.PrivateKey) {
+		t.Error("New SSH user CA is stored unencrypted")
+	}
+}
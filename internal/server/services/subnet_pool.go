@@ -59,7 +59,22 @@ func NewSubnetPool(userRepo *storage.UserRepository, conflictRepo *storage.Confl
 	}, nil
 }
 
+// Networks returns the base and fallback networks user subnets are allocated from
+func (p *SubnetPool) Networks() []string {
+	networks := []string{p.baseNetwork.String()}
+	for _, fb := range p.fallbackNetworks {
+		networks = append(networks, fb.String())
+	}
+	return networks
+}
+
 func (p *SubnetPool) AllocateSubnet(ctx context.Context) (string, error) {
+	return p.AllocateSubnetAvoiding(ctx, nil)
+}
+
+// AllocateSubnetAvoiding allocates a subnet that, in addition to the server-wide
+// conflicts, does not overlap any of the given CIDRs (e.g. networks reported by a client)
+func (p *SubnetPool) AllocateSubnetAvoiding(ctx context.Context, avoid []string) (string, error) {
 	// Get all existing subnets
 	existingSubnets, err := p.userRepo.GetAllSubnets(ctx)
 	if err != nil {
@@ -71,6 +86,7 @@ func (p *SubnetPool) AllocateSubnet(ctx context.Context) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to get conflicts: %w", err)
 	}
+	conflicts = append(conflicts, avoid...)
 
 	// Try base network first
 	subnet, err := p.findAvailableSubnet(p.baseNetwork, existingSubnets, conflicts)
@@ -208,6 +224,26 @@ func subnetsOverlap(a, b *net.IPNet) bool {
 	return a.Contains(b.IP) || b.Contains(a.IP)
 }
 
+// OverlappingCIDRs returns the CIDRs from the list that overlap the given subnet
+func OverlappingCIDRs(subnet string, cidrs []string) []string {
+	_, subnetNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil
+	}
+
+	var overlapping []string
+	for _, cidr := range cidrs {
+		_, cidrNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if subnetsOverlap(subnetNet, cidrNet) {
+			overlapping = append(overlapping, cidr)
+		}
+	}
+	return overlapping
+}
+
 // IsIPInSubnet checks if an IP address is within a given subnet
 func IsIPInSubnet(ip string, subnet string) bool {
 	parsedIP := net.ParseIP(ip)
@@ -92,3 +92,25 @@ func TestSubnetPool_IsIPInSubnet(t *testing.T) {
 	}
 }
 
+
+func TestOverlappingCIDRs(t *testing.T) {
+	cidrs := []string{
+		"192.168.1.0/24", // Home LAN, no overlap
+		"10.100.0.0/24",  // Covers the subnet
+		"10.100.0.4/30",  // Inside the subnet
+		"10.100.0.8/29",  // Adjacent subnet
+		"invalid",
+	}
+
+	result := OverlappingCIDRs("10.100.0.0/29", cidrs)
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 overlapping CIDRs, got %d: %v", len(result), result)
+	}
+	if result[0] != "10.100.0.0/24" || result[1] != "10.100.0.4/30" {
+		t.Errorf("Unexpected overlapping CIDRs: %v", result)
+	}
+
+	if result := OverlappingCIDRs("invalid", cidrs); result != nil {
+		t.Errorf("Expected nil for invalid subnet, got %v", result)
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/ratelimit"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+)
+
+// User codes follow RFC 8628 section 6.1: eight characters from 20
+// consonants (no vowels, so no words; no easily confused letters), about 34
+// bits of entropy. With a handful of pending challenges and
+// maxUserCodeFailures guesses per window, guessing one is impractical.
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+
+	maxUserCodeFailures   = 5
+	userCodeFailureWindow = 15 * time.Minute
+)
+
+var (
+	ErrInvalidUserCode         = errors.New("invalid or expired code")
+	ErrTooManyUserCodeAttempts = errors.New("too many invalid codes, try again later")
+)
+
+// GenerateUserCode returns a random user code formatted as XXXX-XXXX
+func GenerateUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return FormatUserCode(string(code)), nil
+}
+
+// NormalizeUserCode uppercases a code as typed by a user and drops
+// separators, returning "" if it can't be a user code
+func NormalizeUserCode(input string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		switch {
+		case strings.ContainsRune(userCodeAlphabet, r):
+			b.WriteRune(r)
+		case r == '-' || r == ' ':
+			// Separators are optional
+		default:
+			return ""
+		}
+	}
+	if b.Len() != userCodeLength {
+		return ""
+	}
+	return b.String()
+}
+
+// FormatUserCode formats a normalized code for display (XXXX-XXXX)
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+func newUserCodeAttemptsLimiter(store ratelimit.Store) *ratelimit.Limiter {
+	return ratelimit.New(store, "user_code_failures", ratelimit.Limit{Requests: maxUserCodeFailures, Window: userCodeFailureWindow})
+}
+
+// FindChallengeByUserCode finds the pending challenge a user code belongs
+// to. attemptKey identifies who is entering codes (a user or client IP);
+// after too many invalid codes it is locked out for a while.
+func (s *DeviceAuthService) FindChallengeByUserCode(ctx context.Context, attemptKey, code string) (*models.DeviceAuthChallenge, error) {
+	attempts, err := s.userCodeAttempts.Check(ctx, attemptKey)
+	if err != nil {
+		return nil, err
+	}
+	if !attempts.Allowed {
+		return nil, &ratelimit.ExceededError{Err: ErrTooManyUserCodeAttempts, RetryAfter: attempts.RetryAfter}
+	}
+
+	normalized := NormalizeUserCode(code)
+	if normalized == "" {
+		s.RecordUserCodeFailure(ctx, attemptKey)
+		return nil, ErrInvalidUserCode
+	}
+
+	challenge, err := s.deviceAuthRepo.GetPendingChallengeByUserCode(ctx, normalized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenge: %w", err)
+	}
+	if challenge == nil {
+		s.RecordUserCodeFailure(ctx, attemptKey)
+		return nil, ErrInvalidUserCode
+	}
+
+	return challenge, nil
+}
+
+// RecordUserCodeFailure counts a failed attempt that got past the code, such
+// as a wrong password on the verification page
+func (s *DeviceAuthService) RecordUserCodeFailure(ctx context.Context, attemptKey string) {
+	result, err := s.userCodeAttempts.Allow(ctx, attemptKey)
+	if err != nil {
+		log.Printf("Warning: failed to record invalid device code: %v", err)
+		return
+	}
+	if !result.Allowed {
+		log.Printf("🚨 SECURITY: %s blocked from entering device codes for %s", attemptKey, result.RetryAfter.Round(time.Second))
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kamikazebr/roamie-desktop/internal/server/ratelimit"
+	"github.com/kamikazebr/roamie-desktop/internal/testutil"
+	"github.com/google/uuid"
+)
+
+func TestGenerateUserCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := GenerateUserCode()
+		if err != nil {
+			t.Fatalf("GenerateUserCode failed: %v", err)
+		}
+		if len(code) != 9 || code[4] != '-' {
+			t.Fatalf("Expected XXXX-XXXX, got %q", code)
+		}
+		if NormalizeUserCode(code) == "" {
+			t.Fatalf("Generated code %q doesn't normalize", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 95 {
+		t.Errorf("Expected random codes, got %d distinct out of 100", len(seen))
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"BCDF-GHJK", "BCDFGHJK"},
+		{"bcdf-ghjk", "BCDFGHJK"},
+		{" bcdf ghjk ", "BCDFGHJK"},
+		{"BCDFGHJK", "BCDFGHJK"},
+		{"BCDF-GHJ", ""},     // too short
+		{"BCDF-GHJKL", ""},   // too long
+		{"ABCD-1234", ""},    // vowels and digits aren't in the alphabet
+		{"BCDF-GHJK'--", ""}, // anything else is rejected outright
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeUserCode(tt.input); got != tt.want {
+			t.Errorf("NormalizeUserCode(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFindChallengeByUserCode_LocksOutAfterInvalidCodes(t *testing.T) {
+	service := &DeviceAuthService{userCodeAttempts: newUserCodeAttemptsLimiter(ratelimit.NewMemoryStore())}
+	ctx := context.Background()
+
+	// Malformed codes are rejected before the database is queried
+	for i := 0; i < maxUserCodeFailures; i++ {
+		if _, err := service.FindChallengeByUserCode(ctx, "ip:192.0.2.1", "nope"); !errors.Is(err, ErrInvalidUserCode) {
+			t.Fatalf("Attempt %d: expected ErrInvalidUserCode, got %v", i+1, err)
+		}
+	}
+
+	_, err := service.FindChallengeByUserCode(ctx, "ip:192.0.2.1", "nope")
+	if !errors.Is(err, ErrTooManyUserCodeAttempts) {
+		t.Fatalf("Expected ErrTooManyUserCodeAttempts, got %v", err)
+	}
+	var exceeded *ratelimit.ExceededError
+	if !errors.As(err, &exceeded) || exceeded.RetryAfter <= 0 {
+		t.Errorf("Expected a retry delay, got %v", err)
+	}
+
+	if _, err := service.FindChallengeByUserCode(ctx, "ip:192.0.2.2", "nope"); !errors.Is(err, ErrInvalidUserCode) {
+		t.Errorf("Other callers should not be locked out, got %v", err)
+	}
+}
+
+func TestDeviceAuthService_FindChallengeByUserCode(t *testing.T) {
+	tdb := testutil.GetTestDB(t)
+	if tdb == nil {
+		return
+	}
+	defer tdb.Close()
+
+	ctx := context.Background()
+	repos := tdb.Repositories()
+	service := NewDeviceAuthService(repos.DeviceAuth, repos.Users)
+
+	challenge, err := service.CreateChallenge(ctx, uuid.New(), "laptop", "192.0.2.30", nil, nil, nil, nil, "")
+	if err != nil {
+		t.Fatalf("CreateChallenge failed: %v", err)
+	}
+	if challenge.UserCode == nil || NormalizeUserCode(*challenge.UserCode) != *challenge.UserCode {
+		t.Fatalf("Expected a normalized user code, got %v", challenge.UserCode)
+	}
+
+	// Test: codes are found however the user typed them
+	typed := strings.ToLower(FormatUserCode(*challenge.UserCode))
+	found, err := service.FindChallengeByUserCode(ctx, "ip:192.0.2.31", typed)
+	if err != nil {
+		t.Fatalf("FindChallengeByUserCode failed: %v", err)
+	}
+	if found.ID != challenge.ID {
+		t.Errorf("Found challenge %s, want %s", found.ID, challenge.ID)
+	}
+
+	// Test: too many wrong codes lock the caller out, even for the right code
+	for i := 0; i < maxUserCodeFailures; i++ {
+		if _, err := service.FindChallengeByUserCode(ctx, "ip:192.0.2.32", "XXXX-XXXX"); !errors.Is(err, ErrInvalidUserCode) {
+			t.Fatalf("Expected ErrInvalidUserCode, got %v", err)
+		}
+	}
+	if _, err := service.FindChallengeByUserCode(ctx, "ip:192.0.2.32", typed); !errors.Is(err, ErrTooManyUserCodeAttempts) {
+		t.Errorf("Expected ErrTooManyUserCodeAttempts, got %v", err)
+	}
+
+	// Test: codes stop working once the challenge is no longer pending
+	if err := repos.DeviceAuth.UpdateChallengeStatus(ctx, challenge.ID, "denied", nil); err != nil {
+		t.Fatalf("UpdateChallengeStatus failed: %v", err)
+	}
+	if _, err := service.FindChallengeByUserCode(ctx, "ip:192.0.2.31", typed); !errors.Is(err, ErrInvalidUserCode) {
+		t.Errorf("Expected ErrInvalidUserCode for a denied challenge, got %v", err)
+	}
+}
@@ -251,6 +251,16 @@ PostDown = iptables -D FORWARD -i %s -j ACCEPT; iptables -t nat -D POSTROUTING -
 	return os.WriteFile(configPath, []byte(config), 0600)
 }
 
+// ServerIP returns the server's address on the WireGuard interface
+// (first host of WG_BASE_NETWORK, e.g. 10.100.0.1)
+func ServerIP() string {
+	baseNetwork := os.Getenv("WG_BASE_NETWORK")
+	if baseNetwork == "" {
+		baseNetwork = "10.100.0.0/16"
+	}
+	return getServerIPFromNetwork(baseNetwork)
+}
+
 func getServerIPFromNetwork(cidr string) string {
 	// Extract IP from CIDR (e.g., "10.100.0.0/16" -> "10.100.0.1")
 	parts := strings.Split(cidr, "/")
@@ -35,11 +35,58 @@ func (r *ConflictRepository) GetAll(ctx context.Context) ([]models.NetworkConfli
 
 func (r *ConflictRepository) GetAllCIDRs(ctx context.Context) ([]string, error) {
 	var cidrs []string
-	query := `SELECT cidr FROM network_conflicts WHERE active = true`
+	// Client-reported conflicts are scoped to their user and excluded here
+	query := `SELECT cidr FROM network_conflicts WHERE active = true AND user_id IS NULL`
 	err := r.db.SelectContext(ctx, &cidrs, query)
 	return cidrs, err
 }
 
+// GetByUser returns the active conflicts reported by any of a user's devices
+func (r *ConflictRepository) GetByUser(ctx context.Context, userID uuid.UUID) ([]models.NetworkConflict, error) {
+	var conflicts []models.NetworkConflict
+	query := `SELECT * FROM network_conflicts WHERE user_id = $1 AND active = true ORDER BY detected_at DESC`
+	err := r.db.SelectContext(ctx, &conflicts, query, userID)
+	return conflicts, err
+}
+
+// GetCIDRsByUser returns the CIDRs of active conflicts reported by a user's devices
+func (r *ConflictRepository) GetCIDRsByUser(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	var cidrs []string
+	query := `SELECT DISTINCT cidr FROM network_conflicts WHERE user_id = $1 AND active = true`
+	err := r.db.SelectContext(ctx, &cidrs, query, userID)
+	return cidrs, err
+}
+
+// ReplaceForDevice deactivates the previous report from a device and stores the new one.
+// Each client scan is a full snapshot, so stale overlaps disappear once the LAN changes.
+func (r *ConflictRepository) ReplaceForDevice(ctx context.Context, userID, deviceID uuid.UUID, conflicts []models.NetworkConflict) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE network_conflicts SET active = false WHERE device_id = $1 AND active = true`, deviceID,
+	); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO network_conflicts (cidr, source, description, active, user_id, device_id)
+		VALUES ($1, $2, $3, true, $4, $5)
+	`
+	for _, conflict := range conflicts {
+		if _, err := tx.ExecContext(ctx, query,
+			conflict.CIDR, conflict.Source, conflict.Description, userID, deviceID,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (r *ConflictRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	query := `UPDATE network_conflicts SET active = false WHERE id = $1`
 	_, err := r.db.ExecContext(ctx, query, id)
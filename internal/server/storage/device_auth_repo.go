@@ -4,9 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type DeviceAuthRepository struct {
@@ -20,15 +22,19 @@ func NewDeviceAuthRepository(db *DB) *DeviceAuthRepository {
 // CreateChallenge creates a new device authorization challenge
 func (r *DeviceAuthRepository) CreateChallenge(ctx context.Context, challenge *models.DeviceAuthChallenge) error {
 	query := `
-		INSERT INTO device_auth_challenges (id, device_id, hostname, ip_address, username, public_key, os_type, hardware_id, status, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO device_auth_challenges (id, device_id, hostname, ip_address, username, public_key, os_type, hardware_id, status, expires_at, requested_user_id, required_approvals, user_code)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		RETURNING created_at
 	`
+	if challenge.RequiredApprovals == 0 {
+		challenge.RequiredApprovals = 1
+	}
 	return r.db.QueryRowContext(ctx, query,
 		challenge.ID, challenge.DeviceID, challenge.Hostname,
 		challenge.IPAddress, challenge.Username, challenge.PublicKey,
 		challenge.OSType, challenge.HardwareID,
 		challenge.Status, challenge.ExpiresAt,
+		challenge.RequestedUserID, challenge.RequiredApprovals, challenge.UserCode,
 	).Scan(&challenge.CreatedAt)
 }
 
@@ -58,6 +64,111 @@ func (r *DeviceAuthRepository) ListPendingChallenges(ctx context.Context) ([]*mo
 	return challenges, err
 }
 
+// GetPendingChallengeByUserCode finds the unexpired pending challenge with a user code
+func (r *DeviceAuthRepository) GetPendingChallengeByUserCode(ctx context.Context, userCode string) (*models.DeviceAuthChallenge, error) {
+	var challenge models.DeviceAuthChallenge
+	query := `
+		SELECT * FROM device_auth_challenges
+		WHERE user_code = $1 AND status = 'pending' AND expires_at > NOW()
+	`
+	err := r.db.GetContext(ctx, &challenge, query, userCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// IsUniqueViolation reports whether err is a unique constraint violation
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// ListPendingChallengesForUser lists pending challenges that asked to join userID's network
+func (r *DeviceAuthRepository) ListPendingChallengesForUser(ctx context.Context, userID uuid.UUID) ([]*models.DeviceAuthChallenge, error) {
+	var challenges []*models.DeviceAuthChallenge
+	query := `
+		SELECT * FROM device_auth_challenges
+		WHERE requested_user_id = $1 AND status = 'pending' AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`
+	err := r.db.SelectContext(ctx, &challenges, query, userID)
+	return challenges, err
+}
+
+// CountPendingChallengesByIP counts unexpired pending challenges requested from ipAddress
+func (r *DeviceAuthRepository) CountPendingChallengesByIP(ctx context.Context, ipAddress string) (int, error) {
+	var count int
+	query := `
+		SELECT COUNT(*) FROM device_auth_challenges
+		WHERE ip_address = $1::inet AND status = 'pending' AND expires_at > NOW()
+	`
+	err := r.db.GetContext(ctx, &count, query, ipAddress)
+	return count, err
+}
+
+// SetChallengeOwner records the user a challenge joins and how many approvers
+// it needs, unless an owner was already set
+func (r *DeviceAuthRepository) SetChallengeOwner(ctx context.Context, challengeID, userID uuid.UUID, requiredApprovals int) (bool, error) {
+	query := `
+		UPDATE device_auth_challenges
+		SET requested_user_id = $2, required_approvals = $3
+		WHERE id = $1 AND requested_user_id IS NULL AND status = 'pending'
+	`
+	result, err := r.db.ExecContext(ctx, query, challengeID, userID, requiredApprovals)
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// AddChallengeApproval records userID's approval of a challenge and returns
+// the number of distinct approvers so far
+func (r *DeviceAuthRepository) AddChallengeApproval(ctx context.Context, challengeID, userID uuid.UUID) (int, error) {
+	query := `
+		INSERT INTO device_auth_approvals (challenge_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (challenge_id, user_id) DO NOTHING
+	`
+	if _, err := r.db.ExecContext(ctx, query, challengeID, userID); err != nil {
+		return 0, err
+	}
+
+	var count int
+	err := r.db.GetContext(ctx, &count, `SELECT COUNT(*) FROM device_auth_approvals WHERE challenge_id = $1`, challengeID)
+	return count, err
+}
+
+// ListChallengeApprovers returns the emails of users who approved a challenge, oldest first
+func (r *DeviceAuthRepository) ListChallengeApprovers(ctx context.Context, challengeID uuid.UUID) ([]string, error) {
+	var emails []string
+	query := `
+		SELECT u.email FROM device_auth_approvals a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.challenge_id = $1
+		ORDER BY a.approved_at
+	`
+	err := r.db.SelectContext(ctx, &emails, query, challengeID)
+	return emails, err
+}
+
+// HasSessionFromIP reports whether one of userID's live sessions was last used from ipAddress
+func (r *DeviceAuthRepository) HasSessionFromIP(ctx context.Context, userID uuid.UUID, ipAddress string) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM refresh_tokens
+			WHERE user_id = $1 AND last_used_ip = $2 AND rotated_at IS NULL AND expires_at > NOW()
+		)
+	`
+	err := r.db.GetContext(ctx, &exists, query, userID, ipAddress)
+	return exists, err
+}
+
 // UpdateChallengeStatus updates the status of a challenge
 func (r *DeviceAuthRepository) UpdateChallengeStatus(ctx context.Context, id uuid.UUID, status string, userID *uuid.UUID) error {
 	query := `
@@ -100,16 +211,91 @@ func (r *DeviceAuthRepository) UpdateChallengeDeviceID(ctx context.Context, chal
 	return err
 }
 
+// UpdateChallengeEnrollmentKey records the enrollment key a challenge was approved with
+func (r *DeviceAuthRepository) UpdateChallengeEnrollmentKey(ctx context.Context, challengeID, keyID uuid.UUID) error {
+	query := `
+		UPDATE device_auth_challenges
+		SET enrollment_key_id = $1
+		WHERE id = $2
+	`
+	_, err := r.db.ExecContext(ctx, query, keyID, challengeID)
+	return err
+}
+
 // CreateRefreshToken creates a new refresh token
 func (r *DeviceAuthRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
 	query := `
-		INSERT INTO refresh_tokens (id, user_id, device_id, token, expires_at)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING created_at
+		INSERT INTO refresh_tokens (id, user_id, device_id, token, expires_at, family_id, session_started_at, last_used_ip, device_bound)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, NOW()), $8, $9)
+		RETURNING created_at, session_started_at
 	`
+	if token.FamilyID == uuid.Nil {
+		token.FamilyID = token.ID
+	}
 	return r.db.QueryRowContext(ctx, query,
-		token.ID, token.UserID, token.DeviceID, token.Token, token.ExpiresAt,
-	).Scan(&token.CreatedAt)
+		token.ID, token.UserID, token.DeviceID, token.Token, token.ExpiresAt, token.FamilyID,
+		nullTime(token.SessionStartedAt), token.LastUsedIP, token.DeviceBound,
+	).Scan(&token.CreatedAt, &token.SessionStartedAt)
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// RotateRefreshToken marks oldID as rotated and stores its replacement in one
+// transaction. Returns false (and stores nothing) if oldID was already rotated,
+// so two concurrent refreshes with the same token cannot both succeed.
+func (r *DeviceAuthRepository) RotateRefreshToken(ctx context.Context, oldID uuid.UUID, next *models.RefreshToken) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens SET rotated_at = NOW(), last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1 AND rotated_at IS NULL`, oldID, next.LastUsedIP,
+	)
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return false, nil
+	}
+
+	// The replacement carries the session forward: same family and start
+	// time, last used now
+	query := `
+		INSERT INTO refresh_tokens (id, user_id, device_id, token, expires_at, family_id, session_started_at, last_used_at, last_used_ip, device_bound)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8, $9)
+		RETURNING created_at, last_used_at
+	`
+	if err := tx.QueryRowContext(ctx, query,
+		next.ID, next.UserID, next.DeviceID, next.Token, next.ExpiresAt, next.FamilyID,
+		next.SessionStartedAt, next.LastUsedIP, next.DeviceBound,
+	).Scan(&next.CreatedAt, &next.LastUsedAt); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// BindRefreshTokenFamily marks every token of a session as issued for a
+// registered device
+func (r *DeviceAuthRepository) BindRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	query := `UPDATE refresh_tokens SET device_bound = true WHERE family_id = $1`
+	_, err := r.db.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// DeleteRefreshTokenFamily deletes every token descended from the same login
+func (r *DeviceAuthRepository) DeleteRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	query := `DELETE FROM refresh_tokens WHERE family_id = $1`
+	_, err := r.db.ExecContext(ctx, query, familyID)
+	return err
 }
 
 // GetRefreshToken gets a refresh token by token string
@@ -143,12 +329,40 @@ func (r *DeviceAuthRepository) DeleteRefreshToken(ctx context.Context, token str
 	return err
 }
 
-// ListUserRefreshTokens lists all refresh tokens for a user
+// DeleteUserRefreshTokenFamily deletes a user's token family. Returns false if
+// the family doesn't exist or belongs to another user.
+func (r *DeviceAuthRepository) DeleteUserRefreshTokenFamily(ctx context.Context, userID, familyID uuid.UUID) (bool, error) {
+	query := `DELETE FROM refresh_tokens WHERE family_id = $1 AND user_id = $2`
+	result, err := r.db.ExecContext(ctx, query, familyID, userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// DeleteUserRefreshTokensExcept deletes every token family of a user except
+// keepFamilyID. Returns the number of sessions ended.
+func (r *DeviceAuthRepository) DeleteUserRefreshTokensExcept(ctx context.Context, userID, keepFamilyID uuid.UUID) (int64, error) {
+	var families int64
+	query := `
+		WITH deleted AS (
+			DELETE FROM refresh_tokens
+			WHERE user_id = $1 AND family_id <> $2
+			RETURNING family_id
+		)
+		SELECT COUNT(DISTINCT family_id) FROM deleted
+	`
+	err := r.db.GetContext(ctx, &families, query, userID, keepFamilyID)
+	return families, err
+}
+
+// ListUserRefreshTokens lists the current refresh token of each of a user's sessions
 func (r *DeviceAuthRepository) ListUserRefreshTokens(ctx context.Context, userID uuid.UUID) ([]*models.RefreshToken, error) {
 	var tokens []*models.RefreshToken
 	query := `
 		SELECT * FROM refresh_tokens
-		WHERE user_id = $1 AND expires_at > NOW()
+		WHERE user_id = $1 AND expires_at > NOW() AND rotated_at IS NULL
 		ORDER BY created_at DESC
 	`
 	err := r.db.SelectContext(ctx, &tokens, query, userID)
@@ -4,9 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type DeviceRepository struct {
@@ -45,6 +47,20 @@ func (r *DeviceRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.D
 	return &device, nil
 }
 
+// GetStatus reports whether a device row exists and whether it is active,
+// without filtering out deactivated devices like GetByID does
+func (r *DeviceRepository) GetStatus(ctx context.Context, id uuid.UUID) (exists, active bool, err error) {
+	query := `SELECT active FROM devices WHERE id = $1`
+	err = r.db.GetContext(ctx, &active, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, active, nil
+}
+
 func (r *DeviceRepository) GetByPublicKey(ctx context.Context, publicKey string) (*models.Device, error) {
 	var device models.Device
 	query := `SELECT * FROM devices WHERE public_key = $1 AND active = true`
@@ -58,6 +74,20 @@ func (r *DeviceRepository) GetByPublicKey(ctx context.Context, publicKey string)
 	return &device, nil
 }
 
+// GetByVpnIP finds the active device holding a VPN IP (used to identify DNS clients)
+func (r *DeviceRepository) GetByVpnIP(ctx context.Context, vpnIP string) (*models.Device, error) {
+	var device models.Device
+	query := `SELECT * FROM devices WHERE vpn_ip = $1 AND active = true`
+	err := r.db.GetContext(ctx, &device, query, vpnIP)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &device, nil
+}
+
 func (r *DeviceRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
 	var devices []models.Device
 	query := `SELECT * FROM devices WHERE user_id = $1 AND active = true ORDER BY created_at DESC`
@@ -122,6 +152,43 @@ func (r *DeviceRepository) UpdateLastSeen(ctx context.Context, deviceID uuid.UUI
 	return err
 }
 
+// UpdateDisplayName sets the user-friendly name of a device (nil clears it)
+func (r *DeviceRepository) UpdateDisplayName(ctx context.Context, deviceID uuid.UUID, displayName *string) error {
+	query := `UPDATE devices SET display_name = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, displayName, deviceID)
+	return err
+}
+
+// UpdateExitNode sets the exit node used by a device (nil clears it)
+func (r *DeviceRepository) UpdateExitNode(ctx context.Context, deviceID uuid.UUID, exitNodeID *uuid.UUID) error {
+	query := `UPDATE devices SET exit_node_id = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, exitNodeID, deviceID)
+	return err
+}
+
+// GetByExitNode returns the active devices routing their internet traffic through an exit node
+func (r *DeviceRepository) GetByExitNode(ctx context.Context, exitNodeID uuid.UUID) ([]models.Device, error) {
+	var devices []models.Device
+	query := `SELECT * FROM devices WHERE exit_node_id = $1 AND active = true`
+	err := r.db.SelectContext(ctx, &devices, query, exitNodeID)
+	return devices, err
+}
+
+// GetUsingExitNode returns all active devices that selected an exit node
+func (r *DeviceRepository) GetUsingExitNode(ctx context.Context) ([]models.Device, error) {
+	var devices []models.Device
+	query := `SELECT * FROM devices WHERE exit_node_id IS NOT NULL AND active = true`
+	err := r.db.SelectContext(ctx, &devices, query)
+	return devices, err
+}
+
+// UpdateVpnIP assigns a new VPN IP to a device (used when re-homing a user's subnet)
+func (r *DeviceRepository) UpdateVpnIP(ctx context.Context, deviceID uuid.UUID, vpnIP string) error {
+	query := `UPDATE devices SET vpn_ip = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, vpnIP, deviceID)
+	return err
+}
+
 // GetAllTunnelPorts returns all currently allocated tunnel ports
 // Used by TunnelPortPool to find available ports
 func (r *DeviceRepository) GetAllTunnelPorts(ctx context.Context) ([]int, error) {
@@ -182,6 +249,39 @@ func (r *DeviceRepository) UpdateTunnelEnabled(ctx context.Context, deviceID uui
 	return err
 }
 
+// UpdateTunnelExposure sets where a device's tunnel port listens (nil bind
+// uses the server's settings)
+func (r *DeviceRepository) UpdateTunnelExposure(ctx context.Context, deviceID uuid.UUID, bind *string, allowedCIDRs []string) error {
+	var cidrs pq.StringArray
+	if len(allowedCIDRs) > 0 {
+		cidrs = pq.StringArray(allowedCIDRs)
+	}
+	query := `UPDATE devices SET tunnel_bind = $1, tunnel_allowed_cidrs = $2 WHERE id = $3`
+	_, err := r.db.ExecContext(ctx, query, bind, cidrs, deviceID)
+	return err
+}
+
+// UpdateEnrollment sets the tags and ephemeral flag a device enrolled with
+func (r *DeviceRepository) UpdateEnrollment(ctx context.Context, deviceID uuid.UUID, tags []string, ephemeral bool) error {
+	query := `UPDATE devices SET tags = $1, ephemeral = $2 WHERE id = $3`
+	_, err := r.db.ExecContext(ctx, query, pq.StringArray(tags), ephemeral, deviceID)
+	return err
+}
+
+// GetStale returns ephemeral devices last seen before ephemeralBefore, and
+// any device last seen before anyBefore (nil skips the latter)
+func (r *DeviceRepository) GetStale(ctx context.Context, ephemeralBefore time.Time, anyBefore *time.Time) ([]models.Device, error) {
+	var devices []models.Device
+	query := `
+		SELECT * FROM devices
+		WHERE (ephemeral AND last_seen < $1)
+		   OR ($2::timestamp IS NOT NULL AND last_seen < $2)
+		ORDER BY last_seen
+	`
+	err := r.db.SelectContext(ctx, &devices, query, ephemeralBefore, anyBefore)
+	return devices, err
+}
+
 // GetByTunnelPort finds a device by its allocated tunnel port
 // Used by tunnel server for authorization checks
 func (r *DeviceRepository) GetByTunnelPort(ctx context.Context, port int) (*models.Device, error) {
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type EnrollmentKeyRepository struct {
+	db *DB
+}
+
+func NewEnrollmentKeyRepository(db *DB) *EnrollmentKeyRepository {
+	return &EnrollmentKeyRepository{db: db}
+}
+
+// Create stores a new enrollment key
+func (r *EnrollmentKeyRepository) Create(ctx context.Context, key *models.EnrollmentKey) error {
+	if key.Tags == nil {
+		key.Tags = pq.StringArray{}
+	}
+	query := `
+		INSERT INTO enrollment_keys (id, user_id, key_hash, key_prefix, description, max_uses, tags, tunnel, ephemeral, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING created_at
+	`
+	return r.db.QueryRowContext(ctx, query,
+		key.ID, key.UserID, key.KeyHash, key.KeyPrefix, key.Description,
+		key.MaxUses, key.Tags, key.Tunnel, key.Ephemeral, key.ExpiresAt,
+	).Scan(&key.CreatedAt)
+}
+
+// GetByID gets an enrollment key by ID
+func (r *EnrollmentKeyRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.EnrollmentKey, error) {
+	var key models.EnrollmentKey
+	query := `SELECT * FROM enrollment_keys WHERE id = $1`
+	err := r.db.GetContext(ctx, &key, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListByUser lists a user's keys that haven't been revoked, newest first
+func (r *EnrollmentKeyRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.EnrollmentKey, error) {
+	var keys []models.EnrollmentKey
+	query := `
+		SELECT * FROM enrollment_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+	err := r.db.SelectContext(ctx, &keys, query, userID)
+	return keys, err
+}
+
+// ListAll lists every key that hasn't been revoked, newest first
+func (r *EnrollmentKeyRepository) ListAll(ctx context.Context) ([]models.EnrollmentKey, error) {
+	var keys []models.EnrollmentKey
+	query := `SELECT * FROM enrollment_keys WHERE revoked_at IS NULL ORDER BY created_at DESC`
+	err := r.db.SelectContext(ctx, &keys, query)
+	return keys, err
+}
+
+// Use consumes one use of the key with the given hash, in a single statement
+// so concurrent enrollments can't exceed max_uses. Returns nil if the key
+// doesn't exist or is revoked, expired or used up.
+func (r *EnrollmentKeyRepository) Use(ctx context.Context, keyHash string) (*models.EnrollmentKey, error) {
+	var key models.EnrollmentKey
+	query := `
+		UPDATE enrollment_keys
+		SET uses = uses + 1, last_used_at = NOW()
+		WHERE key_hash = $1
+		  AND revoked_at IS NULL
+		  AND expires_at > NOW()
+		  AND (max_uses IS NULL OR uses < max_uses)
+		RETURNING *
+	`
+	err := r.db.GetContext(ctx, &key, query, keyHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Release gives back a use consumed by Use when the enrollment it was for
+// failed
+func (r *EnrollmentKeyRepository) Release(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE enrollment_keys SET uses = uses - 1 WHERE id = $1 AND uses > 0`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// Revoke revokes a key owned by userID (nil revokes regardless of owner).
+// Returns false if no such active key exists.
+func (r *EnrollmentKeyRepository) Revoke(ctx context.Context, id uuid.UUID, userID *uuid.UUID) (bool, error) {
+	query := `
+		UPDATE enrollment_keys SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+		  AND ($2::uuid IS NULL OR user_id = $2)
+	`
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
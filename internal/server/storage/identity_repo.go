@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+type IdentityRepository struct {
+	db *DB
+}
+
+func NewIdentityRepository(db *DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+// GetUser returns the active user linked to a provider subject
+func (r *IdentityRepository) GetUser(ctx context.Context, provider, subject string) (*models.User, error) {
+	var user models.User
+	query := `
+		SELECT u.* FROM users u
+		JOIN user_identities i ON i.user_id = u.id
+		WHERE i.provider = $1 AND i.subject = $2 AND u.active = true
+	`
+	err := r.db.GetContext(ctx, &user, query, provider, subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Link links a provider subject to a user and records the login. The email
+// is refreshed on every login since providers may change it.
+func (r *IdentityRepository) Link(ctx context.Context, identity *models.UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (provider, subject) DO UPDATE
+		SET email = EXCLUDED.email, last_login_at = NOW()
+		RETURNING created_at, last_login_at
+	`
+	return r.db.QueryRowContext(ctx, query,
+		identity.Provider, identity.Subject, identity.UserID, identity.Email,
+	).Scan(&identity.CreatedAt, &identity.LastLoginAt)
+}
+
+// ListByUser returns the identities linked to a user
+func (r *IdentityRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	query := `SELECT * FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+	err := r.db.SelectContext(ctx, &identities, query, userID)
+	return identities, err
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimitRepository stores rate limit counters in Postgres so every server
+// instance shares them (implements ratelimit.Store)
+type RateLimitRepository struct {
+	db *DB
+}
+
+func NewRateLimitRepository(db *DB) *RateLimitRepository {
+	return &RateLimitRepository{db: db}
+}
+
+// Add adds n hits to key's counter for the window starting at windowStart and
+// returns the hits in that window and the previous one
+func (r *RateLimitRepository) Add(ctx context.Context, key string, windowStart time.Time, window time.Duration, n int) (int, int, error) {
+	query := `
+		WITH hit AS (
+			INSERT INTO rate_limit_counters (key, window_start, count, expires_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limit_counters.count + EXCLUDED.count
+			RETURNING count
+		)
+		SELECT
+			(SELECT count FROM hit),
+			COALESCE((SELECT count FROM rate_limit_counters WHERE key = $1 AND window_start = $5), 0)
+	`
+	var current, previous int
+	err := r.db.QueryRowContext(ctx, query,
+		key, windowStart, n, windowStart.Add(2*window), windowStart.Add(-window),
+	).Scan(&current, &previous)
+	return current, previous, err
+}
+
+// Reset deletes all counters for key
+func (r *RateLimitRepository) Reset(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM rate_limit_counters WHERE key = $1`, key)
+	return err
+}
+
+// Cleanup deletes counters that no longer affect any sliding window
+func (r *RateLimitRepository) Cleanup(ctx context.Context, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM rate_limit_counters WHERE expires_at < $1`, now)
+	return err
+}
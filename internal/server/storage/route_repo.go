@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+type RouteRepository struct {
+	db *DB
+}
+
+func NewRouteRepository(db *DB) *RouteRepository {
+	return &RouteRepository{db: db}
+}
+
+func (r *RouteRepository) Create(ctx context.Context, route *models.DeviceRoute) error {
+	query := `
+		INSERT INTO device_routes (device_id, cidr, status)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRowContext(ctx, query, route.DeviceID, route.CIDR, route.Status).
+		Scan(&route.ID, &route.CreatedAt)
+}
+
+func (r *RouteRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.DeviceRoute, error) {
+	var route models.DeviceRoute
+	query := `SELECT * FROM device_routes WHERE id = $1`
+	err := r.db.GetContext(ctx, &route, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &route, nil
+}
+
+func (r *RouteRepository) GetByDevice(ctx context.Context, deviceID uuid.UUID) ([]models.DeviceRoute, error) {
+	var routes []models.DeviceRoute
+	query := `SELECT * FROM device_routes WHERE device_id = $1 ORDER BY created_at`
+	err := r.db.SelectContext(ctx, &routes, query, deviceID)
+	return routes, err
+}
+
+// GetByUser returns the routes advertised by all active devices of a user
+func (r *RouteRepository) GetByUser(ctx context.Context, userID uuid.UUID) ([]models.DeviceRoute, error) {
+	var routes []models.DeviceRoute
+	query := `
+		SELECT r.* FROM device_routes r
+		JOIN devices d ON d.id = r.device_id
+		WHERE d.user_id = $1 AND d.active = true
+		ORDER BY r.created_at
+	`
+	err := r.db.SelectContext(ctx, &routes, query, userID)
+	return routes, err
+}
+
+// GetByStatus returns routes of active devices in the given state (pending for review, approved to program)
+func (r *RouteRepository) GetByStatus(ctx context.Context, status string) ([]models.DeviceRoute, error) {
+	var routes []models.DeviceRoute
+	query := `
+		SELECT r.* FROM device_routes r
+		JOIN devices d ON d.id = r.device_id
+		WHERE r.status = $1 AND d.active = true
+		ORDER BY r.created_at
+	`
+	err := r.db.SelectContext(ctx, &routes, query, status)
+	return routes, err
+}
+
+func (r *RouteRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status string) error {
+	query := `UPDATE device_routes SET status = $1, reviewed_at = NOW() WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, status, id)
+	return err
+}
+
+func (r *RouteRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM device_routes WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+)
+
+// signingKeyLockID is the advisory lock serializing key maintenance across
+// server instances
+const signingKeyLockID = 0x726f616d6965 // "roamie"
+
+type SigningKeyRepository struct {
+	db *DB
+}
+
+func NewSigningKeyRepository(db *DB) *SigningKeyRepository {
+	return &SigningKeyRepository{db: db}
+}
+
+// ListValid returns the current key and retired keys that still verify, newest first
+func (r *SigningKeyRepository) ListValid(ctx context.Context) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	query := `
+		SELECT * FROM jwt_signing_keys
+		WHERE retires_at IS NULL OR retires_at > NOW()
+		ORDER BY created_at DESC
+	`
+	err := r.db.SelectContext(ctx, &keys, query)
+	return keys, err
+}
+
+// Rotate retires the current key (it keeps verifying for overlap) and stores
+// the new signing key in one transaction
+func (r *SigningKeyRepository) Rotate(ctx context.Context, key *models.SigningKey, overlap time.Duration) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE jwt_signing_keys SET retires_at = $1 WHERE retires_at IS NULL`, time.Now().Add(overlap),
+	); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO jwt_signing_keys (kid, algorithm, private_key, public_key)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`
+	if err := tx.QueryRowContext(ctx, query,
+		key.KID, key.Algorithm, key.PrivateKey, key.PublicKey,
+	).Scan(&key.CreatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRetired removes keys whose overlap period has ended
+func (r *SigningKeyRepository) DeleteRetired(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM jwt_signing_keys WHERE retires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UpdatePrivateKey replaces the stored private key of kid (e.g. to encrypt it)
+func (r *SigningKeyRepository) UpdatePrivateKey(ctx context.Context, kid, privateKey string) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE jwt_signing_keys SET private_key = $1 WHERE kid = $2`, privateKey, kid,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("signing key %s not found", kid)
+	}
+	return nil
+}
+
+// WithLock runs fn while holding a PostgreSQL advisory lock, so that server
+// instances sharing the database don't rotate or prune keys concurrently
+func (r *SigningKeyRepository) WithLock(ctx context.Context, fn func() error) error {
+	conn, err := r.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, signingKeyLockID); err != nil {
+		return fmt.Errorf("failed to lock signing keys: %w", err)
+	}
+	// Unlock even if ctx was cancelled, or the pooled connection keeps the lock
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, signingKeyLockID)
+
+	return fn()
+}
+
+// LegacyHS256Until returns when HS256 tokens stop being accepted. The cutoff
+// is fixed the first time it is read: lifetime after the oldest signing key
+// (when the server switched to them), or from now on a fresh install.
+func (r *SigningKeyRepository) LegacyHS256Until(ctx context.Context, lifetime time.Duration) (time.Time, error) {
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO jwt_settings (hs256_until)
+		SELECT COALESCE(MIN(created_at), NOW()) + $1 * INTERVAL '1 second' FROM jwt_signing_keys
+		ON CONFLICT (id) DO NOTHING
+	`, lifetime.Seconds()); err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	err := r.db.GetContext(ctx, &until, `SELECT hs256_until FROM jwt_settings`)
+	return until, err
+}
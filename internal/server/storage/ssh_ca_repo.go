@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+)
+
+type SSHCARepository struct {
+	db *DB
+}
+
+func NewSSHCARepository(db *DB) *SSHCARepository {
+	return &SSHCARepository{db: db}
+}
+
+// Get returns the CA key of the given kind, or nil if there is none yet
+func (r *SSHCARepository) Get(ctx context.Context, kind string) (*models.SSHCAKey, error) {
+	var key models.SSHCAKey
+	err := r.db.GetContext(ctx, &key, `SELECT * FROM ssh_ca_keys WHERE kind = $1`, kind)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Create stores a CA key unless one of that kind already exists (another
+// server instance may have created it first). It returns the stored key.
+func (r *SSHCARepository) Create(ctx context.Context, key *models.SSHCAKey) (*models.SSHCAKey, error) {
+	query := `
+		INSERT INTO ssh_ca_keys (kind, private_key, public_key)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (kind) DO NOTHING
+	`
+	if _, err := r.db.ExecContext(ctx, query, key.Kind, key.PrivateKey, key.PublicKey); err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, key.Kind)
+}
+
+// UpdatePrivateKey replaces a CA private key (e.g. to encrypt it). It only
+// replaces the key it was read as, so instances encrypting at the same time
+// don't overwrite each other.
+func (r *SSHCARepository) UpdatePrivateKey(ctx context.Context, kind, current, privateKey string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE ssh_ca_keys SET private_key = $1 WHERE kind = $2 AND private_key = $3`,
+		privateKey, kind, current,
+	)
+	return err
+}
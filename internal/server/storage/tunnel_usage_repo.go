@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+// TunnelUsageRepository stores daily tunnel usage per device (implements
+// tunnel.UsageStore)
+type TunnelUsageRepository struct {
+	db *DB
+}
+
+func NewTunnelUsageRepository(db *DB) *TunnelUsageRepository {
+	return &TunnelUsageRepository{db: db}
+}
+
+// Add adds usage to the device's counters for usage.Day
+func (r *TunnelUsageRepository) Add(ctx context.Context, usage models.TunnelUsage) error {
+	query := `
+		INSERT INTO tunnel_usage (device_id, day, bytes_in, bytes_out, connections, rejected_connections)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (device_id, day) DO UPDATE SET
+			bytes_in = tunnel_usage.bytes_in + EXCLUDED.bytes_in,
+			bytes_out = tunnel_usage.bytes_out + EXCLUDED.bytes_out,
+			connections = tunnel_usage.connections + EXCLUDED.connections,
+			rejected_connections = tunnel_usage.rejected_connections + EXCLUDED.rejected_connections
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		usage.DeviceID, usage.Day, usage.BytesIn, usage.BytesOut, usage.Connections, usage.RejectedConnections,
+	)
+	return err
+}
+
+// GetByUser returns daily usage of the user's devices since the given day
+func (r *TunnelUsageRepository) GetByUser(ctx context.Context, userID uuid.UUID, since time.Time) ([]models.TunnelUsage, error) {
+	usage := []models.TunnelUsage{}
+	query := `
+		SELECT u.* FROM tunnel_usage u
+		JOIN devices d ON d.id = u.device_id
+		WHERE d.user_id = $1 AND u.day >= $2
+		ORDER BY u.day, u.device_id
+	`
+	err := r.db.SelectContext(ctx, &usage, query, userID, since)
+	return usage, err
+}
+
+// GetByDevice returns the device's daily usage since the given day
+func (r *TunnelUsageRepository) GetByDevice(ctx context.Context, deviceID uuid.UUID, since time.Time) ([]models.TunnelUsage, error) {
+	usage := []models.TunnelUsage{}
+	query := `SELECT * FROM tunnel_usage WHERE device_id = $1 AND day >= $2 ORDER BY day`
+	err := r.db.SelectContext(ctx, &usage, query, deviceID, since)
+	return usage, err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/google/uuid"
@@ -78,6 +79,33 @@ func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 	return err
 }
 
+// RehomeSubnet moves a user to a new subnet and re-addresses their devices
+// (used when re-homing around conflicts). Either everything moves or nothing does.
+func (r *UserRepository) RehomeSubnet(ctx context.Context, id uuid.UUID, subnet string, vpnIPs map[uuid.UUID]string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET subnet = $1 WHERE id = $2`, subnet, id); err != nil {
+		return err
+	}
+	for deviceID, vpnIP := range vpnIPs {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE devices SET vpn_ip = $1 WHERE id = $2 AND user_id = $3`, vpnIP, deviceID, id,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("device %s not found", deviceID)
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (r *UserRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	query := `UPDATE users SET active = false WHERE id = $1`
 	_, err := r.db.ExecContext(ctx, query, id)
@@ -107,3 +135,10 @@ func (r *UserRepository) CreateWithFirebaseUID(ctx context.Context, user *models
 		user.Email, user.Subnet, user.MaxDevices, user.Active, firebaseUID,
 	).Scan(&user.ID, &user.CreatedAt)
 }
+
+// SetPasswordHash sets (or with nil clears) the password of a local account
+func (r *UserRepository) SetPasswordHash(ctx context.Context, id uuid.UUID, hash *string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, hash, id)
+	return err
+}
@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrDeviceNotConnected  = errors.New("device is not connected to the tunnel server")
+	ErrCommandNotSupported = errors.New("device doesn't support this command")
+)
+
+// handleHello records the commands a device handles and replies with the
+// server's protocol version
+func (s *Server) handleHello(req *ssh.Request, sshConn *ssh.ServerConn, deviceID uuid.UUID) {
+	var hello control.Hello
+	if err := json.Unmarshal(req.Payload, &hello); err != nil {
+		log.Printf("Invalid hello from device %s: %v", deviceID, err)
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	if current, ok := s.sessions[deviceID]; ok && current.conn == sshConn {
+		current.hello = &hello
+		s.sessions[deviceID] = current
+	}
+	s.mu.Unlock()
+
+	log.Printf("Device %s (client %s) handles commands: %v", deviceID, hello.ClientVersion, hello.Commands)
+	if req.WantReply {
+		reply, _ := json.Marshal(control.Hello{Version: control.ProtocolVersion})
+		req.Reply(true, reply)
+	}
+}
+
+// DeviceCommands returns the commands a connected device handles (nil if it
+// isn't connected or its client predates commands)
+func (s *Server) DeviceCommands(deviceID uuid.UUID) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if hello := s.sessions[deviceID].hello; hello != nil {
+		return hello.Commands
+	}
+	return nil
+}
+
+// RunCommand runs a command on a connected device, passing output lines to
+// onOutput (may be nil) as they arrive
+func (s *Server) RunCommand(ctx context.Context, deviceID uuid.UUID, req control.Request, onOutput func(line string)) (*control.Event, error) {
+	s.mu.RLock()
+	current, ok := s.sessions[deviceID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrDeviceNotConnected
+	}
+	if !current.hello.Supports(req.Command) {
+		return nil, ErrCommandNotSupported
+	}
+
+	log.Printf("Running %s on device %s", req.Command, deviceID)
+	return control.Run(ctx, current.conn, req, onOutput)
+}
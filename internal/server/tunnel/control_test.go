@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunCommand(t *testing.T) {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, devicePrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceKey, err := ssh.NewSignerFromKey(devicePrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deviceID := uuid.New()
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{
+				"device_id":   deviceID.String(),
+				"tunnel_port": "10000",
+			}}, nil
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &Server{ctx: ctx, cancel: cancel, sshConfig: sshConfig, sessions: make(map[uuid.UUID]session)}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		s.handleConnection(conn, TransportTCP)
+	}()
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "device",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(deviceKey)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	mux := control.NewMux()
+	mux.Handle(control.CommandLogs, func(ctx context.Context, call *control.Call) (any, error) {
+		fmt.Fprintln(call.Output, "line")
+		return nil, nil
+	})
+	go mux.Serve(ctx, client.HandleChannelOpen(control.ChannelType))
+
+	logs := control.Request{Command: control.CommandLogs}
+	if _, err := s.RunCommand(ctx, uuid.New(), logs, nil); !errors.Is(err, ErrDeviceNotConnected) {
+		t.Errorf("RunCommand on unknown device = %v, want %v", err, ErrDeviceNotConnected)
+	}
+
+	// Clients that don't say hello get no commands
+	deadline := time.Now().Add(5 * time.Second)
+	for s.SessionTransport(deviceID) == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("session wasn't tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := s.RunCommand(ctx, deviceID, logs, nil); !errors.Is(err, ErrCommandNotSupported) {
+		t.Errorf("RunCommand before hello = %v, want %v", err, ErrCommandNotSupported)
+	}
+
+	hello, _ := json.Marshal(mux.Hello("v1.0.0"))
+	ok, reply, err := client.SendRequest(control.HelloRequest, true, hello)
+	if err != nil || !ok {
+		t.Fatalf("hello = %v, %v", ok, err)
+	}
+	var serverHello control.Hello
+	if err := json.Unmarshal(reply, &serverHello); err != nil || serverHello.Version != control.ProtocolVersion {
+		t.Errorf("server hello = %s (%v)", reply, err)
+	}
+	if got := s.DeviceCommands(deviceID); !reflect.DeepEqual(got, []string{control.CommandLogs}) {
+		t.Errorf("DeviceCommands = %v", got)
+	}
+
+	var output []string
+	result, err := s.RunCommand(ctx, deviceID, logs, func(line string) { output = append(output, line) })
+	if err != nil || !result.OK {
+		t.Fatalf("RunCommand = %+v, %v", result, err)
+	}
+	if !reflect.DeepEqual(output, []string{"line"}) {
+		t.Errorf("output = %q", output)
+	}
+	if _, err := s.RunCommand(ctx, deviceID, control.Request{Command: control.CommandDoctor}, nil); !errors.Is(err, ErrCommandNotSupported) {
+		t.Errorf("RunCommand(doctor) = %v, want %v", err, ErrCommandNotSupported)
+	}
+}
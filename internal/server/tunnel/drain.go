@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+)
+
+const (
+	defaultReconnectWindow = 10 * time.Second
+	defaultDrainTimeout    = 20 * time.Second
+
+	// How often Drain checks whether devices have left
+	drainPollInterval = 100 * time.Millisecond
+)
+
+// DrainConfig is how the server hands devices over to its next instance
+type DrainConfig struct {
+	// Devices reconnect at a random moment within this window
+	ReconnectWindow time.Duration
+	// Sessions still open after this long are closed
+	Timeout time.Duration
+}
+
+// DrainConfigFromEnv reads TUNNEL_RECONNECT_WINDOW and TUNNEL_DRAIN_TIMEOUT
+func DrainConfigFromEnv() DrainConfig {
+	cfg := DrainConfig{
+		ReconnectWindow: defaultReconnectWindow,
+		Timeout:         defaultDrainTimeout,
+	}
+	for name, value := range map[string]*time.Duration{
+		"TUNNEL_RECONNECT_WINDOW": &cfg.ReconnectWindow,
+		"TUNNEL_DRAIN_TIMEOUT":    &cfg.Timeout,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d >= 0 {
+				*value = d
+			} else {
+				log.Printf("Warning: invalid %s %q, using %v", name, raw, *value)
+			}
+		}
+	}
+	return cfg
+}
+
+// Drain stops accepting tunnels and asks connected devices to reconnect,
+// which takes them to the next server instance once this one's listener is
+// closed. It returns when the devices have left or the timeout passes; Stop
+// closes whatever is left.
+func (s *Server) Drain(cfg DrainConfig) {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	reconnect, _ := json.Marshal(control.Reconnect{WindowMS: cfg.ReconnectWindow.Milliseconds()})
+	s.mu.RLock()
+	log.Printf("Draining SSH tunnel server: asking %d devices to reconnect within %v", len(s.sessions), cfg.ReconnectWindow)
+	for _, current := range s.sessions {
+		// No reply: clients that don't know the request ignore it
+		go current.conn.SendRequest(control.ReconnectRequest, false, reconnect)
+	}
+	s.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(s.ctx, cfg.Timeout)
+	defer cancel()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		remaining := s.sessionCount()
+		if remaining == 0 {
+			log.Println("✓ All devices left the SSH tunnel server")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("⚠️  %d devices still connected after draining", remaining)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Draining reports whether the server is handing devices over to its next
+// instance
+func (s *Server) Draining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.draining
+}
+
+func (s *Server) sessionCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.sessions)
+}
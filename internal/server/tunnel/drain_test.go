@@ -0,0 +1,166 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestDrainConfigFromEnv(t *testing.T) {
+	if cfg := DrainConfigFromEnv(); cfg.ReconnectWindow != defaultReconnectWindow || cfg.Timeout != defaultDrainTimeout {
+		t.Errorf("default config = %+v", cfg)
+	}
+
+	t.Setenv("TUNNEL_RECONNECT_WINDOW", "30s")
+	t.Setenv("TUNNEL_DRAIN_TIMEOUT", "soon")
+	if cfg := DrainConfigFromEnv(); cfg.ReconnectWindow != 30*time.Second || cfg.Timeout != defaultDrainTimeout {
+		t.Errorf("config = %+v", cfg)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Devices log in with certificates naming their ID
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{
+				"device_id":   key.(*ssh.Certificate).KeyId,
+				"tunnel_port": "10000",
+			}}, nil
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{ctx: ctx, cancel: cancel, sshConfig: sshConfig, sessions: make(map[uuid.UUID]session), listener: l}
+	defer s.Stop()
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	dial := func(deviceID uuid.UUID) (*ssh.Client, <-chan *ssh.Request, error) {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := &ssh.Certificate{Key: signer.PublicKey(), KeyId: deviceID.String(), CertType: ssh.UserCert}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatal(err)
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.DialTimeout("tcp", l.Addr().String(), time.Second)
+		if err != nil {
+			return nil, nil, err
+		}
+		sshConn, chans, reqs, err := ssh.NewClientConn(conn, l.Addr().String(), &ssh.ClientConfig{
+			User:            "tunnel",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+			HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		// The test reads the global requests itself
+		return ssh.NewClient(sshConn, chans, nil), reqs, nil
+	}
+
+	// A current client leaves when asked; an older one ignores the request
+	current, old := uuid.New(), uuid.New()
+	currentClient, currentReqs, err := dial(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer currentClient.Close()
+	oldClient, oldReqs, err := dial(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldClient.Close()
+	go ssh.DiscardRequests(oldReqs)
+
+	windows := make(chan time.Duration, 1)
+	go func() {
+		for req := range currentReqs {
+			if req.Type == control.ReconnectRequest {
+				var msg control.Reconnect
+				json.Unmarshal(req.Payload, &msg)
+				windows <- msg.Window()
+				currentClient.Close()
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.sessionCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("sessions weren't tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	s.Drain(DrainConfig{ReconnectWindow: 3 * time.Second, Timeout: 500 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("Drain returned after %v with a device still connected", elapsed)
+	}
+	select {
+	case window := <-windows:
+		if window != 3*time.Second {
+			t.Errorf("reconnect window = %v", window)
+		}
+	default:
+		t.Error("current client wasn't asked to reconnect")
+	}
+	if s.SessionTransport(current) != "" || s.SessionTransport(old) == "" {
+		t.Errorf("after drain: current connected %v, old connected %v", s.SessionTransport(current) != "", s.SessionTransport(old) != "")
+	}
+	if !s.Draining() {
+		t.Error("Draining = false after Drain")
+	}
+
+	// New devices go to the next instance
+	if client, _, err := dial(uuid.New()); err == nil {
+		client.Close()
+		t.Error("connected to a draining server")
+	}
+
+	// Once every device has left, draining is immediate
+	oldClient.Close()
+	for s.sessionCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("old session wasn't untracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	start = time.Now()
+	s.Drain(DrainConfig{Timeout: time.Minute})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Drain without devices took %v", elapsed)
+	}
+}
@@ -0,0 +1,291 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// Where tunnel ports listen
+const (
+	BindVPN      = "vpn"      // The WireGuard server address: reachable over the VPN only
+	BindLoopback = "loopback" // 127.0.0.1: reachable only through the server (roamie forward)
+	BindPublic   = "public"   // All interfaces, optionally only from AllowedCIDRs
+)
+
+// Narrowest prefixes device owners may allow without TUNNEL_ALLOW_PUBLIC when
+// the server has no allow-list of its own: broader ones are as good as public
+const (
+	minOverrideBitsIPv4 = 16
+	minOverrideBitsIPv6 = 48
+)
+
+// ExposurePolicy decides where tunnel ports listen and who may connect
+type ExposurePolicy struct {
+	Bind         string
+	VPNAddress   string         // The server's WireGuard address, for BindVPN
+	AllowedCIDRs []netip.Prefix // Sources accepted with BindPublic; empty accepts all
+
+	// Whether device owners may make their tunnel port public without an
+	// allow-list
+	AllowPublicOverride bool
+}
+
+// ValidBind reports whether bind names a bind mode
+func ValidBind(bind string) bool {
+	switch bind {
+	case BindVPN, BindLoopback, BindPublic:
+		return true
+	}
+	return false
+}
+
+// ExposurePolicyFromEnv reads TUNNEL_BIND (vpn by default),
+// TUNNEL_ALLOWED_CIDRS and TUNNEL_ALLOW_PUBLIC. vpnAddress is the server's
+// WireGuard address.
+func ExposurePolicyFromEnv(vpnAddress string) ExposurePolicy {
+	policy := ExposurePolicy{Bind: BindVPN, VPNAddress: vpnAddress}
+
+	if raw := os.Getenv("TUNNEL_BIND"); raw != "" {
+		if bind := strings.ToLower(strings.TrimSpace(raw)); ValidBind(bind) {
+			policy.Bind = bind
+		} else {
+			log.Printf("Warning: invalid TUNNEL_BIND %q, using %s", raw, policy.Bind)
+		}
+	}
+
+	if raw := os.Getenv("TUNNEL_ALLOWED_CIDRS"); raw != "" {
+		cidrs, err := ParseCIDRs(strings.Split(raw, ","))
+		if err != nil {
+			// Failing open would publish every tunnel port
+			log.Printf("Warning: invalid TUNNEL_ALLOWED_CIDRS %q, using %s: %v", raw, BindLoopback, err)
+			policy.Bind = BindLoopback
+		}
+		policy.AllowedCIDRs = cidrs
+	}
+
+	if raw := os.Getenv("TUNNEL_ALLOW_PUBLIC"); raw != "" {
+		allow, err := strconv.ParseBool(raw)
+		if err != nil {
+			log.Printf("Warning: invalid TUNNEL_ALLOW_PUBLIC %q, using false", raw)
+		}
+		policy.AllowPublicOverride = allow
+	}
+
+	return policy
+}
+
+// ParseCIDRs parses source CIDRs; a bare address allows just that address
+func ParseCIDRs(values []string) ([]netip.Prefix, error) {
+	var cidrs []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", value)
+			}
+			cidrs = append(cidrs, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		cidrs = append(cidrs, prefix.Masked())
+	}
+	return cidrs, nil
+}
+
+// CheckOverride validates a device's exposure override ("" clears it)
+func (p ExposurePolicy) CheckOverride(bind string, allowedCIDRs []string) error {
+	if bind == "" {
+		if len(allowedCIDRs) > 0 {
+			return errors.New("allowed_cidrs need bind public")
+		}
+		return nil
+	}
+	if !ValidBind(bind) {
+		return fmt.Errorf("invalid bind %q (vpn, loopback or public)", bind)
+	}
+	if bind != BindPublic {
+		if len(allowedCIDRs) > 0 {
+			return errors.New("allowed_cidrs need bind public")
+		}
+		return nil
+	}
+
+	cidrs, err := ParseCIDRs(allowedCIDRs)
+	if err != nil {
+		return err
+	}
+	// Owners can't open more than the server already does
+	openByDefault := p.Bind == BindPublic && len(p.AllowedCIDRs) == 0
+	if p.AllowPublicOverride || openByDefault {
+		return nil
+	}
+	if len(cidrs) == 0 {
+		return errors.New("this server only allows public tunnel ports with allowed_cidrs")
+	}
+	for _, prefix := range cidrs {
+		if err := p.checkOverrideCIDR(prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOverrideCIDR refuses an allowed CIDR that would open a tunnel port
+// wider than the server's policy: outside TUNNEL_ALLOWED_CIDRS if set,
+// otherwise broader than minOverrideBitsIPv4/IPv6 (e.g. 0.0.0.0/0)
+func (p ExposurePolicy) checkOverrideCIDR(prefix netip.Prefix) error {
+	if len(p.AllowedCIDRs) > 0 {
+		for _, allowed := range p.AllowedCIDRs {
+			if allowed.Addr().Is4() == prefix.Addr().Is4() && prefix.Bits() >= allowed.Bits() && allowed.Contains(prefix.Addr()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("allowed CIDR %s is outside this server's TUNNEL_ALLOWED_CIDRS", prefix)
+	}
+
+	minBits := minOverrideBitsIPv6
+	if prefix.Addr().Is4() {
+		minBits = minOverrideBitsIPv4
+	}
+	if prefix.Bits() < minBits {
+		return fmt.Errorf("allowed CIDR %s is too broad for this server (at least /%d)", prefix, minBits)
+	}
+	return nil
+}
+
+// forDevice applies a device's override from its connection permissions
+func (p ExposurePolicy) forDevice(perms *ssh.Permissions) ExposurePolicy {
+	if perms == nil {
+		return p
+	}
+	if bind := perms.Extensions["tunnel_bind"]; ValidBind(bind) {
+		p.Bind = bind
+		cidrs, err := ParseCIDRs(strings.Split(perms.Extensions["tunnel_allowed_cidrs"], ","))
+		if err != nil {
+			p.Bind = BindLoopback
+		}
+		p.AllowedCIDRs = cidrs
+	}
+	return p
+}
+
+// exposureExtensions carries a device's override into its connection
+// permissions
+func exposureExtensions(device *models.Device, extensions map[string]string) {
+	if device.TunnelBind != nil {
+		extensions["tunnel_bind"] = *device.TunnelBind
+		extensions["tunnel_allowed_cidrs"] = strings.Join(device.TunnelAllowedCIDRs, ",")
+	}
+}
+
+// listenHost is the address tunnel listeners bind to
+func (p ExposurePolicy) listenHost() string {
+	switch p.Bind {
+	case BindPublic:
+		return "0.0.0.0"
+	case BindVPN:
+		if p.VPNAddress != "" {
+			return p.VPNAddress
+		}
+	}
+	return "127.0.0.1"
+}
+
+// allows reports whether a connection from addr may use the tunnel port
+func (p ExposurePolicy) allows(addr net.Addr) bool {
+	if p.Bind != BindPublic || len(p.AllowedCIDRs) == 0 {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := addrPort.Addr().Unmap()
+	// The server itself forwards through loopback
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, prefix := range p.AllowedCIDRs {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns the exposure of a tunnel port
+func (p ExposurePolicy) describe(port int, override bool) models.TunnelExposure {
+	exposure := models.TunnelExposure{Bind: p.Bind, Override: override}
+	if exposure.Bind == "" || (exposure.Bind == BindVPN && p.VPNAddress == "") {
+		exposure.Bind = BindLoopback
+	}
+	if port > 0 {
+		exposure.Address = net.JoinHostPort(p.listenHost(), strconv.Itoa(port))
+	}
+	if p.Bind == BindPublic {
+		for _, prefix := range p.AllowedCIDRs {
+			exposure.AllowedCIDRs = append(exposure.AllowedCIDRs, prefix.String())
+		}
+	}
+	return exposure
+}
+
+// SetExposurePolicy sets where tunnel ports listen. Tunnels already
+// listening keep their address until the device reconnects.
+func (s *Server) SetExposurePolicy(policy ExposurePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exposure = policy
+}
+
+func (s *Server) exposurePolicy() ExposurePolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exposure
+}
+
+// Exposure returns where a device's tunnel port listens, with its override
+func (s *Server) Exposure(device *models.Device) models.TunnelExposure {
+	extensions := map[string]string{}
+	exposureExtensions(device, extensions)
+	port := 0
+	if device.TunnelPort != nil {
+		port = *device.TunnelPort
+	}
+	policy := s.exposurePolicy().forDevice(&ssh.Permissions{Extensions: extensions})
+	return policy.describe(port, device.TunnelBind != nil)
+}
+
+// CheckExposureOverride validates a device's exposure override
+func (s *Server) CheckExposureOverride(bind string, allowedCIDRs []string) error {
+	return s.exposurePolicy().CheckOverride(bind, allowedCIDRs)
+}
+
+// DisconnectDevice closes a device's tunnel connection; its client
+// reconnects and picks up changed settings
+func (s *Server) DisconnectDevice(deviceID uuid.UUID) {
+	s.mu.RLock()
+	current, ok := s.sessions[deviceID]
+	s.mu.RUnlock()
+	if ok {
+		log.Printf("Disconnecting tunnel of device %s", deviceID)
+		current.conn.Close()
+	}
+}
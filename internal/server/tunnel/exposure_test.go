@@ -0,0 +1,153 @@
+package tunnel
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExposurePolicyFromEnv(t *testing.T) {
+	policy := ExposurePolicyFromEnv("10.100.0.1")
+	if policy.Bind != BindVPN || policy.listenHost() != "10.100.0.1" || policy.AllowPublicOverride {
+		t.Errorf("default policy = %+v", policy)
+	}
+
+	t.Setenv("TUNNEL_BIND", "Public")
+	t.Setenv("TUNNEL_ALLOWED_CIDRS", "203.0.113.0/24, 198.51.100.7")
+	t.Setenv("TUNNEL_ALLOW_PUBLIC", "true")
+	policy = ExposurePolicyFromEnv("10.100.0.1")
+	if policy.Bind != BindPublic || policy.listenHost() != "0.0.0.0" || !policy.AllowPublicOverride {
+		t.Errorf("public policy = %+v", policy)
+	}
+	if got := policy.describe(10005, false).AllowedCIDRs; !reflect.DeepEqual(got, []string{"203.0.113.0/24", "198.51.100.7/32"}) {
+		t.Errorf("allowed CIDRs = %v", got)
+	}
+
+	// A broken allow-list must not leave the ports public
+	t.Setenv("TUNNEL_ALLOWED_CIDRS", "203.0.113.0/33")
+	if policy = ExposurePolicyFromEnv("10.100.0.1"); policy.Bind != BindLoopback {
+		t.Errorf("policy with invalid CIDRs = %+v", policy)
+	}
+}
+
+func TestExposurePolicyAllows(t *testing.T) {
+	cidrs, err := ParseCIDRs([]string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := func(s string) net.Addr {
+		a, err := net.ResolveTCPAddr("tcp", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	public := ExposurePolicy{Bind: BindPublic, AllowedCIDRs: cidrs}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"203.0.113.9:40000", true},
+		{"[::ffff:203.0.113.9]:40000", true},
+		{"198.51.100.7:40000", false},
+		{"127.0.0.1:40000", true},
+	}
+	for _, tt := range tests {
+		if got := public.allows(addr(tt.addr)); got != tt.want {
+			t.Errorf("allows(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	// Without an allow-list, or bound to the VPN, the listen address decides
+	if !(ExposurePolicy{Bind: BindPublic}).allows(addr("198.51.100.7:40000")) {
+		t.Error("public without allow-list refused a source")
+	}
+	if !(ExposurePolicy{Bind: BindVPN, AllowedCIDRs: cidrs}).allows(addr("10.100.0.3:40000")) {
+		t.Error("VPN bind applied the public allow-list")
+	}
+}
+
+func TestExposurePolicyCheckOverride(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverBind  string
+		serverCIDRs []string
+		allowPublic bool
+		bind        string
+		cidrs       []string
+		err         string
+	}{
+		{"clear", BindVPN, nil, false, "", nil, ""},
+		{"vpn", BindVPN, nil, false, BindVPN, nil, ""},
+		{"loopback", BindVPN, nil, false, BindLoopback, nil, ""},
+		{"public with allow-list", BindVPN, nil, false, BindPublic, []string{"203.0.113.0/24"}, ""},
+		{"public without allow-list", BindVPN, nil, false, BindPublic, nil, "only allows public"},
+		{"public allowed", BindVPN, nil, true, BindPublic, nil, ""},
+		{"public by default", BindPublic, nil, false, BindPublic, nil, ""},
+		{"unknown bind", BindVPN, nil, false, "lan", nil, "invalid bind"},
+		{"allow-list without public", BindVPN, nil, false, BindVPN, []string{"203.0.113.0/24"}, "need bind public"},
+		{"invalid CIDR", BindVPN, nil, false, BindPublic, []string{"not-a-cidr"}, "invalid CIDR"},
+		{"everything", BindVPN, nil, false, BindPublic, []string{"0.0.0.0/0"}, "too broad"},
+		{"everything IPv6", BindVPN, nil, false, BindPublic, []string{"::/0"}, "too broad"},
+		{"broad prefix", BindVPN, nil, false, BindPublic, []string{"203.0.113.0/24", "10.0.0.0/8"}, "too broad"},
+		{"everything allowed", BindVPN, nil, true, BindPublic, []string{"0.0.0.0/0"}, ""},
+		{"inside server allow-list", BindPublic, []string{"203.0.0.0/16"}, false, BindPublic, []string{"203.0.113.0/24"}, ""},
+		{"outside server allow-list", BindPublic, []string{"203.0.0.0/16"}, false, BindPublic, []string{"198.51.100.0/24"}, "outside"},
+		{"wider than server allow-list", BindPublic, []string{"203.0.0.0/16"}, false, BindPublic, []string{"203.0.0.0/8"}, "outside"},
+		{"everything with server allow-list", BindVPN, []string{"203.0.0.0/16"}, false, BindPublic, []string{"::/0"}, "outside"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverCIDRs, _ := ParseCIDRs(tt.serverCIDRs)
+			policy := ExposurePolicy{Bind: tt.serverBind, AllowedCIDRs: serverCIDRs, AllowPublicOverride: tt.allowPublic}
+			err := policy.CheckOverride(tt.bind, tt.cidrs)
+			if tt.err == "" && err != nil {
+				t.Errorf("CheckOverride = %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("CheckOverride = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServerExposure(t *testing.T) {
+	s := &Server{}
+	s.SetExposurePolicy(ExposurePolicy{Bind: BindVPN, VPNAddress: "10.100.0.1"})
+
+	port := 10005
+	device := &models.Device{TunnelPort: &port}
+	if got, want := s.Exposure(device), (models.TunnelExposure{Bind: BindVPN, Address: "10.100.0.1:10005"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Exposure = %+v, want %+v", got, want)
+	}
+
+	// A device's override reaches its tunnel listener through the
+	// connection's permissions
+	bind := BindPublic
+	device.TunnelBind = &bind
+	device.TunnelAllowedCIDRs = []string{"203.0.113.0/24"}
+	want := models.TunnelExposure{Bind: BindPublic, Address: "0.0.0.0:10005", AllowedCIDRs: []string{"203.0.113.0/24"}, Override: true}
+	if got := s.Exposure(device); !reflect.DeepEqual(got, want) {
+		t.Errorf("Exposure with override = %+v, want %+v", got, want)
+	}
+
+	extensions := map[string]string{}
+	exposureExtensions(device, extensions)
+	policy := s.exposurePolicy().forDevice(&ssh.Permissions{Extensions: extensions})
+	if policy.listenHost() != "0.0.0.0" || len(policy.AllowedCIDRs) != 1 {
+		t.Errorf("listener policy = %+v", policy)
+	}
+
+	// Without a VPN address, a VPN bind falls back to loopback rather than
+	// all interfaces
+	s.SetExposurePolicy(ExposurePolicy{Bind: BindVPN})
+	device.TunnelBind = nil
+	if got := s.Exposure(device); got.Bind != BindLoopback || got.Address != "127.0.0.1:10005" {
+		t.Errorf("Exposure without VPN address = %+v", got)
+	}
+}
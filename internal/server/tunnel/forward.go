@@ -0,0 +1,171 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// ForwardUser is the SSH user of connections that forward to the user's
+	// other devices (roamie forward). They don't need a tunnel of their own.
+	ForwardUser = "forward"
+
+	// directChannelType carries a forwarded connection to one of the
+	// device's local ports
+	directChannelType = "direct-tcpip"
+)
+
+// connectionAuthorizer decides whether a device may reach another device's
+// tunnel
+type connectionAuthorizer interface {
+	AuthorizeConnection(ctx context.Context, sourceDeviceID uuid.UUID, targetPort int) (*models.Device, error)
+}
+
+// directTCPIP is the payload of a direct-tcpip channel (RFC 4254 7.2). Host
+// is the target device's ID when a forward client opens it, and localhost
+// when the server passes it on to the device.
+type directTCPIP struct {
+	Host       string
+	Port       uint32
+	OriginHost string
+	OriginPort uint32
+}
+
+// handleForwardSession serves a forward connection: it may only open
+// direct-tcpip channels to the user's connected devices
+func (s *Server) handleForwardSession(sshConn *ssh.ServerConn, reqs <-chan *ssh.Request, chans <-chan ssh.NewChannel, sourceDeviceID uuid.UUID) {
+	go func() {
+		for req := range reqs {
+			if req.WantReply {
+				req.Reply(req.Type == "keepalive@roamie", nil)
+			}
+		}
+	}()
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != directChannelType {
+			newChannel.Reject(ssh.UnknownChannelType, "forward connections only open direct-tcpip channels")
+			continue
+		}
+		go s.forwardToDevice(newChannel, sshConn.RemoteAddr(), sourceDeviceID)
+	}
+}
+
+// forwardToDevice carries a direct-tcpip channel to a port on the target
+// device, after checking both devices belong to the same user
+func (s *Server) forwardToDevice(newChannel ssh.NewChannel, remoteAddr net.Addr, sourceDeviceID uuid.UUID) {
+	var target directTCPIP
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip request")
+		return
+	}
+	targetDeviceID, err := uuid.Parse(target.Host)
+	if err != nil || target.Port == 0 || target.Port > 65535 {
+		newChannel.Reject(ssh.ConnectionFailed, "target must be <device id>:<port>")
+		return
+	}
+
+	s.mu.RLock()
+	targetSession, connected := s.sessions[targetDeviceID]
+	s.mu.RUnlock()
+	if !connected {
+		newChannel.Reject(ssh.ConnectionFailed, "device is not connected to the tunnel server")
+		return
+	}
+
+	// Ownership is checked like any other access to the device's tunnel port
+	tunnelPort, _ := strconv.Atoi(targetSession.conn.Permissions.Extensions["tunnel_port"])
+	device, err := s.authMgr.AuthorizeConnection(s.ctx, sourceDeviceID, tunnelPort)
+	if err != nil {
+		newChannel.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+	if device.ID != targetDeviceID {
+		newChannel.Reject(ssh.Prohibited, "access denied")
+		return
+	}
+
+	lease, err := s.limits.acquire(targetDeviceID, device.UserID)
+	if err != nil {
+		log.Printf("Rejected forward from device %s to device %s: %v", sourceDeviceID, targetDeviceID, err)
+		newChannel.Reject(ssh.ResourceShortage, err.Error())
+		return
+	}
+	defer lease.release()
+
+	originHost, originPort := splitOrigin(remoteAddr.String())
+	deviceChannel, err := openDeviceChannel(targetSession.conn, tunnelPort, int(target.Port), originHost, originPort)
+	if err != nil {
+		log.Printf("Failed to forward from device %s to device %s port %d: %v", sourceDeviceID, targetDeviceID, target.Port, err)
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer deviceChannel.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	log.Printf("✓ Forward established: device %s → device %s port %d", sourceDeviceID, targetDeviceID, target.Port)
+	reason := lease.pipe(s.ctx, channel, deviceChannel)
+	log.Printf("Forward closed: device %s → device %s port %d (%s)", sourceDeviceID, targetDeviceID, target.Port, reason)
+}
+
+// openDeviceChannel opens a channel to a local port on a device. Clients
+// older than forwarding only take forwarded-tcpip channels for their tunnel
+// listener, which always leads to their SSH server.
+func openDeviceChannel(conn ssh.Conn, tunnelPort, port int, originHost string, originPort uint32) (ssh.Channel, error) {
+	payload := directTCPIP{Host: "localhost", Port: uint32(port), OriginHost: originHost, OriginPort: originPort}
+	channel, requests, err := conn.OpenChannel(directChannelType, ssh.Marshal(&payload))
+	if openErr, ok := err.(*ssh.OpenChannelError); ok && openErr.Reason == ssh.UnknownChannelType {
+		if port != 22 {
+			return nil, fmt.Errorf("device's client is too old to forward port %d (only 22)", port)
+		}
+		channel, requests, err = openForwardedChannel(conn, tunnelPort, originHost, originPort)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(requests)
+	return channel, nil
+}
+
+// openForwardedChannel opens a channel to the device's tunnel listener
+func openForwardedChannel(conn ssh.Conn, tunnelPort int, originHost string, originPort uint32) (ssh.Channel, <-chan *ssh.Request, error) {
+	// IMPORTANT: The Address/Port fields must match what the client requested in tcpip-forward!
+	// Go's SSH library uses these fields to match the channel to the correct listener.
+	// The Address should be the bind address from tcpip-forward (e.g., "0.0.0.0")
+	// The Port should be the tunnel port (e.g., 10000)
+	payload := struct {
+		Address           string
+		Port              uint32
+		OriginatorAddress string
+		OriginatorPort    uint32
+	}{
+		Address:           "0.0.0.0",
+		Port:              uint32(tunnelPort), // Must match what client requested!
+		OriginatorAddress: originHost,
+		OriginatorPort:    originPort,
+	}
+	return conn.OpenChannel("forwarded-tcpip", ssh.Marshal(&payload))
+}
+
+// splitOrigin splits a remote address for a channel's originator fields
+func splitOrigin(addr string) (string, uint32) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.ParseUint(portStr, 10, 32)
+	return host, uint32(port)
+}
@@ -0,0 +1,245 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeAuthorizer allows devices of one user to reach each other
+type fakeAuthorizer struct {
+	owners  map[uuid.UUID]uuid.UUID // device → user
+	devices map[int]uuid.UUID       // tunnel port → device
+}
+
+func (a *fakeAuthorizer) AuthorizeConnection(ctx context.Context, sourceDeviceID uuid.UUID, targetPort int) (*models.Device, error) {
+	targetID, ok := a.devices[targetPort]
+	if !ok {
+		return nil, errors.New("tunnel port not found")
+	}
+	if a.owners[sourceDeviceID] != a.owners[targetID] {
+		return nil, errors.New("access denied: cross-account access not allowed")
+	}
+	return &models.Device{ID: targetID, UserID: a.owners[targetID]}, nil
+}
+
+// freePort returns a TCP port that was free a moment ago
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestForwardToDevice(t *testing.T) {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, mallory := uuid.New(), uuid.New()
+	target, oldTarget, source, stranger := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	targetPort, oldTargetPort := freePort(t), freePort(t)
+	auth := &fakeAuthorizer{
+		owners:  map[uuid.UUID]uuid.UUID{target: alice, oldTarget: alice, source: alice, stranger: mallory},
+		devices: map[int]uuid.UUID{targetPort: target, oldTargetPort: oldTarget},
+	}
+	ports := map[uuid.UUID]int{target: targetPort, oldTarget: oldTargetPort}
+
+	// Devices log in with certificates naming their ID, standing in for the
+	// registered key lookup
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			cert := key.(*ssh.Certificate)
+			deviceID := uuid.MustParse(cert.KeyId)
+			ext := map[string]string{
+				"device_id": deviceID.String(),
+				"user_id":   auth.owners[deviceID].String(),
+			}
+			if conn.User() != ForwardUser {
+				ext["tunnel_port"] = strconv.Itoa(ports[deviceID])
+			}
+			return &ssh.Permissions{Extensions: ext}, nil
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &Server{
+		ctx:       ctx,
+		cancel:    cancel,
+		sshConfig: sshConfig,
+		sessions:  make(map[uuid.UUID]session),
+		authMgr:   auth,
+		limits:    newLimiter(),
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go s.handleConnection(conn, TransportTCP)
+		}
+	}()
+
+	dial := func(user string, deviceID uuid.UUID) *ssh.Client {
+		t.Helper()
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := &ssh.Certificate{Key: signer.PublicKey(), KeyId: deviceID.String(), CertType: ssh.UserCert}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatal(err)
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+			HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+
+	// A current client answers direct-tcpip channels for any local port
+	targetClient := dial("tunnel", target)
+	go func() {
+		for newChannel := range targetClient.HandleChannelOpen(directChannelType) {
+			var req directTCPIP
+			ssh.Unmarshal(newChannel.ExtraData(), &req)
+			channel, reqs, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				defer channel.Close()
+				fmt.Fprintf(channel, "%s:%d ", req.Host, req.Port)
+				io.Copy(channel, channel)
+			}()
+		}
+	}()
+
+	// An older client only listens for its tunnel, which leads to sshd
+	oldClient := dial("tunnel", oldTarget)
+	tunnelListener, err := oldClient.Listen("tcp", net.JoinHostPort("0.0.0.0", strconv.Itoa(oldTargetPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := tunnelListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fmt.Fprint(conn, "sshd ")
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.SessionTransport(target) == "" || s.SessionTransport(oldTarget) == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("target sessions weren't tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	roundTrip := func(client *ssh.Client, deviceID uuid.UUID, port int) (string, error) {
+		conn, err := client.Dial("tcp", net.JoinHostPort(deviceID.String(), strconv.Itoa(port)))
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return "", err
+		}
+		conn.(interface{ CloseWrite() error }).CloseWrite()
+		reply, err := io.ReadAll(conn)
+		return string(reply), err
+	}
+
+	forwardClient := dial(ForwardUser, source)
+	tests := []struct {
+		name   string
+		device uuid.UUID
+		port   int
+		want   string
+		err    string
+	}{
+		{"any port", target, 5432, "localhost:5432 ping", ""},
+		{"old client, ssh", oldTarget, 22, "sshd ping", ""},
+		{"old client, other port", oldTarget, 5432, "", "too old"},
+		{"not connected", uuid.New(), 22, "", "not connected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := roundTrip(forwardClient, tt.device, tt.port)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got %q, %v, want error containing %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	// Other accounts' devices are refused
+	if _, err := roundTrip(dial(ForwardUser, stranger), target, 22); err == nil || !strings.Contains(err.Error(), "cross-account") {
+		t.Errorf("forward from another account: %v", err)
+	}
+
+	// Forward connections don't replace the device's tunnel session, and
+	// can't open a tunnel listener
+	if s.SessionTransport(source) != "" {
+		t.Error("forward connection was tracked as a tunnel session")
+	}
+	if _, err := forwardClient.Listen("tcp", "0.0.0.0:10000"); err == nil {
+		t.Error("forward connection opened a tunnel listener")
+	}
+}
@@ -0,0 +1,217 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// Host key rotation: a new key is first announced as "next" (published to
+// clients, not yet used), then promoted to "current" while the old key stays
+// published as "previous" until it is retired. Clients pin every published
+// key, so by the time the new key is used they already trust it.
+const (
+	NextHostKeyFile     = HostKeyFile + ".next"
+	PreviousHostKeyFile = HostKeyFile + ".previous"
+
+	HostKeyStatusCurrent  = "current"
+	HostKeyStatusNext     = "next"
+	HostKeyStatusPrevious = "previous"
+)
+
+var (
+	ErrNoNextHostKey       = errors.New("no host key announced, run prepare-tunnel-host-key first")
+	ErrNextHostKeyExists   = errors.New("a host key is already announced, promote it first")
+	ErrNoPreviousHostKey   = errors.New("no previous host key to retire")
+	ErrPreviousHostKeyLive = errors.New("the previous host key is still published, retire it first")
+)
+
+// HostKeySet is the tunnel server's host keys. Only Current and Previous
+// are served; SSH servers offer one key per algorithm, so Previous is only
+// used by clients when its algorithm differs from Current's.
+type HostKeySet struct {
+	Current  ssh.Signer
+	Next     ssh.Signer
+	Previous ssh.Signer
+}
+
+// LoadHostKeys reads the host keys in dir. The current key is required.
+func LoadHostKeys(dir string) (*HostKeySet, error) {
+	current, err := readHostKey(filepath.Join(dir, HostKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no host key in %s", dir)
+	}
+
+	set := &HostKeySet{Current: current}
+	if set.Next, err = readHostKey(filepath.Join(dir, NextHostKeyFile)); err != nil {
+		return nil, err
+	}
+	if set.Previous, err = readHostKey(filepath.Join(dir, PreviousHostKeyFile)); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Published returns the keys clients should trust, current first
+func (s *HostKeySet) Published() []models.TunnelHostKey {
+	var keys []models.TunnelHostKey
+	add := func(signer ssh.Signer, status string) {
+		if signer == nil {
+			return
+		}
+		key := signer.PublicKey()
+		keys = append(keys, models.TunnelHostKey{
+			Algorithm:   key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+			Status:      status,
+		})
+	}
+
+	add(s.Current, HostKeyStatusCurrent)
+	add(s.Next, HostKeyStatusNext)
+	add(s.Previous, HostKeyStatusPrevious)
+	return keys
+}
+
+// fingerprints identifies the set, to tell whether a reload changed it
+func (s *HostKeySet) fingerprints() string {
+	var parts []string
+	for _, key := range s.Published() {
+		parts = append(parts, key.Status+"="+key.Fingerprint)
+	}
+	return strings.Join(parts, ",")
+}
+
+// PrepareHostKey generates the next host key and announces it to clients
+func PrepareHostKey(dir string) (ssh.Signer, error) {
+	nextPath := filepath.Join(dir, NextHostKeyFile)
+	if _, err := os.Stat(nextPath); err == nil {
+		return nil, ErrNextHostKeyExists
+	}
+	// Promoting would overwrite a previous key clients may still rely on
+	if _, err := os.Stat(filepath.Join(dir, PreviousHostKeyFile)); err == nil {
+		return nil, ErrPreviousHostKeyLive
+	}
+	return generateHostKey(nextPath)
+}
+
+// PromoteHostKey makes the announced key current. The old key stays
+// published (and served if its algorithm differs) until RetireHostKey.
+func PromoteHostKey(dir string) error {
+	currentPath := filepath.Join(dir, HostKeyFile)
+	nextPath := filepath.Join(dir, NextHostKeyFile)
+	previousPath := filepath.Join(dir, PreviousHostKeyFile)
+
+	if _, err := os.Stat(nextPath); os.IsNotExist(err) {
+		return ErrNoNextHostKey
+	}
+	if _, err := os.Stat(previousPath); err == nil {
+		return ErrPreviousHostKeyLive
+	}
+
+	if err := os.Rename(currentPath, previousPath); err != nil {
+		return fmt.Errorf("failed to keep current host key: %w", err)
+	}
+	if err := os.Rename(nextPath, currentPath); err != nil {
+		// Put the current key back rather than leaving the server without one
+		os.Rename(previousPath, currentPath)
+		return fmt.Errorf("failed to promote host key: %w", err)
+	}
+	return nil
+}
+
+// RetireHostKey stops publishing and serving the previous host key
+func RetireHostKey(dir string) error {
+	if err := os.Remove(filepath.Join(dir, PreviousHostKeyFile)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoPreviousHostKey
+		}
+		return err
+	}
+	return nil
+}
+
+// readHostKey returns nil if path doesn't exist
+func readHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// generateHostKey creates an Ed25519 host key at path
+func generateHostKey(path string) (ssh.Signer, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	privateKeyBytes := pem.EncodeToMemory(block)
+
+	if err := os.WriteFile(path, privateKeyBytes, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save host key: %w", err)
+	}
+
+	return ssh.ParsePrivateKey(privateKeyBytes)
+}
+
+// StaticHostKeys publishes the host keys of an external SSH server used
+// instead of the embedded one (DISABLE_TUNNEL_SERVER=true)
+type StaticHostKeys []models.TunnelHostKey
+
+// HostKeys returns the published keys
+func (k StaticHostKeys) HostKeys() []models.TunnelHostKey {
+	return k
+}
+
+// LoadStaticHostKeys reads public host keys, one per line in authorized_keys
+// format (e.g. /etc/ssh/ssh_host_ed25519_key.pub)
+func LoadStaticHostKeys(path string) (StaticHostKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host keys: %w", err)
+	}
+
+	var keys StaticHostKeys
+	for len(data) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		keys = append(keys, models.TunnelHostKey{
+			Algorithm:   key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+			Status:      HostKeyStatusCurrent,
+		})
+		data = rest
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys in %s", path)
+	}
+	return keys, nil
+}
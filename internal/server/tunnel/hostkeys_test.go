@@ -0,0 +1,115 @@
+package tunnel
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	original, err := generateHostKey(filepath.Join(dir, HostKeyFile))
+	if err != nil {
+		t.Fatalf("generateHostKey failed: %v", err)
+	}
+	if original.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("New host keys should be Ed25519, got %s", original.PublicKey().Type())
+	}
+
+	// Test: nothing to promote or retire yet
+	if err := PromoteHostKey(dir); !errors.Is(err, ErrNoNextHostKey) {
+		t.Errorf("Expected ErrNoNextHostKey, got %v", err)
+	}
+	if err := RetireHostKey(dir); !errors.Is(err, ErrNoPreviousHostKey) {
+		t.Errorf("Expected ErrNoPreviousHostKey, got %v", err)
+	}
+
+	// Step 1: announce a new key, published but not current
+	next, err := PrepareHostKey(dir)
+	if err != nil {
+		t.Fatalf("PrepareHostKey failed: %v", err)
+	}
+	if _, err := PrepareHostKey(dir); !errors.Is(err, ErrNextHostKeyExists) {
+		t.Errorf("Expected ErrNextHostKeyExists, got %v", err)
+	}
+	assertPublished(t, dir, map[string]ssh.Signer{
+		HostKeyStatusCurrent: original,
+		HostKeyStatusNext:    next,
+	})
+
+	// Step 2: promote, the old key stays published as previous
+	if err := PromoteHostKey(dir); err != nil {
+		t.Fatalf("PromoteHostKey failed: %v", err)
+	}
+	assertPublished(t, dir, map[string]ssh.Signer{
+		HostKeyStatusCurrent:  next,
+		HostKeyStatusPrevious: original,
+	})
+
+	// Test: a new rotation waits until the previous key is retired
+	if _, err := PrepareHostKey(dir); !errors.Is(err, ErrPreviousHostKeyLive) {
+		t.Errorf("Expected ErrPreviousHostKeyLive, got %v", err)
+	}
+
+	// Step 3: retire the old key
+	if err := RetireHostKey(dir); err != nil {
+		t.Fatalf("RetireHostKey failed: %v", err)
+	}
+	assertPublished(t, dir, map[string]ssh.Signer{
+		HostKeyStatusCurrent: next,
+	})
+}
+
+func assertPublished(t *testing.T, dir string, want map[string]ssh.Signer) {
+	t.Helper()
+
+	set, err := LoadHostKeys(dir)
+	if err != nil {
+		t.Fatalf("LoadHostKeys failed: %v", err)
+	}
+	published := set.Published()
+	if len(published) != len(want) {
+		t.Fatalf("Expected %d published keys, got %d", len(want), len(published))
+	}
+	if published[0].Status != HostKeyStatusCurrent {
+		t.Errorf("The current key should be published first, got %s", published[0].Status)
+	}
+	for _, key := range published {
+		signer, ok := want[key.Status]
+		if !ok {
+			t.Errorf("Unexpected %s key", key.Status)
+			continue
+		}
+		if key.Fingerprint != ssh.FingerprintSHA256(signer.PublicKey()) {
+			t.Errorf("Wrong %s key: %s", key.Status, key.Fingerprint)
+		}
+	}
+}
+
+func TestLoadStaticHostKeys(t *testing.T) {
+	dir := t.TempDir()
+	signer, err := generateHostKey(filepath.Join(dir, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "host_keys.pub")
+	os.WriteFile(path, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0644)
+
+	keys, err := LoadStaticHostKeys(path)
+	if err != nil {
+		t.Fatalf("LoadStaticHostKeys failed: %v", err)
+	}
+	if len(keys.HostKeys()) != 1 || keys[0].Fingerprint != ssh.FingerprintSHA256(signer.PublicKey()) {
+		t.Errorf("Unexpected keys: %+v", keys)
+	}
+
+	os.WriteFile(path, []byte("not a key\n"), 0644)
+	if _, err := LoadStaticHostKeys(path); err == nil {
+		t.Error("Expected an error for a file without keys")
+	}
+}
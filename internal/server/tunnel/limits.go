@@ -0,0 +1,380 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/forward"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMaxConnectionsPerDevice = 64
+	defaultMaxConnectionsPerUser   = 256
+	defaultIdleTimeout             = time.Hour
+
+	// How often usage counters are written to the store
+	usageFlushInterval = time.Minute
+)
+
+var (
+	ErrDeviceConnectionLimit = errors.New("too many connections to this device")
+	ErrUserConnectionLimit   = errors.New("too many connections to this user's devices")
+)
+
+// Policy limits the connections forwarded through each tunnel. Zero values
+// are unlimited.
+type Policy struct {
+	// Concurrent forwarded connections to one device, and to all devices of
+	// one user
+	MaxConnectionsPerDevice int
+	MaxConnectionsPerUser   int
+
+	// Bandwidth in bytes per second. In is traffic from connecting clients to
+	// the device, out is the device's replies. Device limits are shared by
+	// the device's connections, user limits by all connections to the user's
+	// devices.
+	DeviceBandwidthIn  int64
+	DeviceBandwidthOut int64
+	UserBandwidthIn    int64
+	UserBandwidthOut   int64
+
+	// Connections are closed after IdleTimeout without traffic in either
+	// direction, and after MaxLifetime regardless
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+}
+
+// PolicyFromEnv reads the TUNNEL_* limit settings
+func PolicyFromEnv() Policy {
+	policy := Policy{
+		MaxConnectionsPerDevice: defaultMaxConnectionsPerDevice,
+		MaxConnectionsPerUser:   defaultMaxConnectionsPerUser,
+		IdleTimeout:             defaultIdleTimeout,
+	}
+
+	for name, value := range map[string]*int{
+		"TUNNEL_MAX_CONNECTIONS_PER_DEVICE": &policy.MaxConnectionsPerDevice,
+		"TUNNEL_MAX_CONNECTIONS_PER_USER":   &policy.MaxConnectionsPerUser,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+				*value = n
+			} else {
+				log.Printf("Warning: invalid %s %q, using %d", name, raw, *value)
+			}
+		}
+	}
+
+	for name, value := range map[string]*int64{
+		"TUNNEL_BANDWIDTH_IN_PER_DEVICE":  &policy.DeviceBandwidthIn,
+		"TUNNEL_BANDWIDTH_OUT_PER_DEVICE": &policy.DeviceBandwidthOut,
+		"TUNNEL_BANDWIDTH_IN_PER_USER":    &policy.UserBandwidthIn,
+		"TUNNEL_BANDWIDTH_OUT_PER_USER":   &policy.UserBandwidthOut,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			if n, err := ParseByteRate(raw); err == nil {
+				*value = n
+			} else {
+				log.Printf("Warning: invalid %s %q, not limiting bandwidth: %v", name, raw, err)
+			}
+		}
+	}
+
+	for name, value := range map[string]*time.Duration{
+		"TUNNEL_IDLE_TIMEOUT":            &policy.IdleTimeout,
+		"TUNNEL_MAX_CONNECTION_LIFETIME": &policy.MaxLifetime,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d >= 0 {
+				*value = d
+			} else {
+				log.Printf("Warning: invalid %s %q, using %v", name, raw, *value)
+			}
+		}
+	}
+
+	return policy
+}
+
+// ParseByteRate parses a rate in bytes per second with an optional K, M or G
+// suffix (powers of 1024), e.g. "512K" or "10M". "0" and "off" are unlimited.
+func ParseByteRate(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "OFF" {
+		return 0, nil
+	}
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "/S"), "B")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected bytes per second like 512K or 10M")
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// UsageStore persists tunnel usage (storage.TunnelUsageRepository)
+type UsageStore interface {
+	Add(ctx context.Context, usage models.TunnelUsage) error
+}
+
+// limiter enforces a Policy and counts usage per device per day
+type limiter struct {
+	mu      sync.Mutex
+	policy  Policy
+	devices map[uuid.UUID]*limitScope
+	users   map[uuid.UUID]*limitScope
+	usage   map[usageKey]*models.TunnelUsage
+}
+
+// limitScope is the state shared by connections to a device or a user's devices
+type limitScope struct {
+	connections int
+	in, out     *rate.Limiter
+}
+
+type usageKey struct {
+	deviceID uuid.UUID
+	day      time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		devices: make(map[uuid.UUID]*limitScope),
+		users:   make(map[uuid.UUID]*limitScope),
+		usage:   make(map[usageKey]*models.TunnelUsage),
+	}
+}
+
+func (l *limiter) setPolicy(policy Policy) {
+	l.mu.Lock()
+	l.policy = policy
+	l.mu.Unlock()
+}
+
+// acquire admits a connection to a device owned by userID (uuid.Nil skips
+// the user limits), or records it as rejected
+func (l *limiter) acquire(deviceID, userID uuid.UUID) (*lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	policy := l.policy
+	device := l.scope(l.devices, deviceID, policy.DeviceBandwidthIn, policy.DeviceBandwidthOut)
+	var user *limitScope
+	if userID != uuid.Nil {
+		user = l.scope(l.users, userID, policy.UserBandwidthIn, policy.UserBandwidthOut)
+	}
+
+	var err error
+	if policy.MaxConnectionsPerDevice > 0 && device.connections >= policy.MaxConnectionsPerDevice {
+		err = ErrDeviceConnectionLimit
+	} else if user != nil && policy.MaxConnectionsPerUser > 0 && user.connections >= policy.MaxConnectionsPerUser {
+		err = ErrUserConnectionLimit
+	}
+	if err != nil {
+		l.usageFor(deviceID).RejectedConnections++
+		l.releaseScope(l.devices, deviceID, device)
+		if user != nil {
+			l.releaseScope(l.users, userID, user)
+		}
+		return nil, err
+	}
+
+	device.connections++
+	if user != nil {
+		user.connections++
+	}
+	l.usageFor(deviceID).Connections++
+
+	lease := &lease{limiter: l, policy: policy, deviceID: deviceID, userID: userID}
+	for _, scope := range []*limitScope{device, user} {
+		if scope == nil {
+			continue
+		}
+		if scope.in != nil {
+			lease.in = append(lease.in, scope.in)
+		}
+		if scope.out != nil {
+			lease.out = append(lease.out, scope.out)
+		}
+	}
+	return lease, nil
+}
+
+// scope returns the state for id, creating it with the given bandwidth limits.
+// Call with l.mu held.
+func (l *limiter) scope(scopes map[uuid.UUID]*limitScope, id uuid.UUID, in, out int64) *limitScope {
+	scope, ok := scopes[id]
+	if !ok {
+		scope = &limitScope{in: newRateLimiter(in), out: newRateLimiter(out)}
+		scopes[id] = scope
+	}
+	return scope
+}
+
+// releaseScope forgets a scope without connections. Call with l.mu held.
+func (l *limiter) releaseScope(scopes map[uuid.UUID]*limitScope, id uuid.UUID, scope *limitScope) {
+	if scope.connections <= 0 {
+		delete(scopes, id)
+	}
+}
+
+// usageFor returns today's counters for a device. Call with l.mu held.
+func (l *limiter) usageFor(deviceID uuid.UUID) *models.TunnelUsage {
+	key := usageKey{deviceID: deviceID, day: time.Now().UTC().Truncate(24 * time.Hour)}
+	usage, ok := l.usage[key]
+	if !ok {
+		usage = &models.TunnelUsage{DeviceID: deviceID, Day: key.day}
+		l.usage[key] = usage
+	}
+	return usage
+}
+
+func (l *limiter) addBytes(deviceID uuid.UUID, in, out int64) {
+	l.mu.Lock()
+	usage := l.usageFor(deviceID)
+	usage.BytesIn += in
+	usage.BytesOut += out
+	l.mu.Unlock()
+}
+
+// takeUsage returns the counters collected since the last call
+func (l *limiter) takeUsage() []models.TunnelUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	usage := make([]models.TunnelUsage, 0, len(l.usage))
+	for _, u := range l.usage {
+		usage = append(usage, *u)
+	}
+	l.usage = make(map[usageKey]*models.TunnelUsage)
+	return usage
+}
+
+// restoreUsage puts back counters that couldn't be stored
+func (l *limiter) restoreUsage(usage models.TunnelUsage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := usageKey{deviceID: usage.DeviceID, day: usage.Day}
+	if current, ok := l.usage[key]; ok {
+		current.BytesIn += usage.BytesIn
+		current.BytesOut += usage.BytesOut
+		current.Connections += usage.Connections
+		current.RejectedConnections += usage.RejectedConnections
+		return
+	}
+	l.usage[key] = &usage
+}
+
+// newRateLimiter returns a token bucket for bytesPerSecond, or nil for
+// unlimited. The burst fits at least one forwarded chunk.
+func newRateLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := int(bytesPerSecond)
+	if burst < forward.BufferSize {
+		burst = forward.BufferSize
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// lease is one admitted connection
+type lease struct {
+	limiter  *limiter
+	policy   Policy
+	deviceID uuid.UUID
+	userID   uuid.UUID
+	in, out  []*rate.Limiter
+}
+
+func (ls *lease) release() {
+	l := ls.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if device, ok := l.devices[ls.deviceID]; ok {
+		device.connections--
+		l.releaseScope(l.devices, ls.deviceID, device)
+	}
+	if user, ok := l.users[ls.userID]; ok && ls.userID != uuid.Nil {
+		user.connections--
+		l.releaseScope(l.users, ls.userID, user)
+	}
+}
+
+// pipe copies between a connecting client and the device's channel until
+// both sides finish, either fails, the connection idles out or reaches its
+// maximum lifetime, or ctx is done. It closes both sides and returns why it
+// stopped.
+func (ls *lease) pipe(ctx context.Context, client, device io.ReadWriteCloser) string {
+	if ls.policy.MaxLifetime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ls.policy.MaxLifetime)
+		defer cancel()
+	}
+
+	_, err := forward.Pipe(ctx, client, device, forward.Options{
+		IdleTimeout: ls.policy.IdleTimeout,
+		BeforeWrite: ls.wait,
+		AfterWrite:  ls.count,
+	})
+	switch {
+	case err == nil:
+		return "closed"
+	case errors.Is(err, forward.ErrIdleTimeout):
+		return "idle timeout"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "maximum lifetime reached"
+	case errors.Is(err, context.Canceled):
+		return "server stopping"
+	}
+	return err.Error()
+}
+
+// wait waits until the rate limits allow n more bytes
+func (ls *lease) wait(ctx context.Context, dir forward.Direction, n int) error {
+	limits := ls.in
+	if dir == forward.BToA {
+		limits = ls.out
+	}
+	for _, limit := range limits {
+		if err := limit.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// count adds forwarded bytes to the device's usage
+func (ls *lease) count(dir forward.Direction, n int) {
+	if dir == forward.AToB {
+		ls.limiter.addBytes(ls.deviceID, int64(n), 0)
+	} else {
+		ls.limiter.addBytes(ls.deviceID, 0, int64(n))
+	}
+}
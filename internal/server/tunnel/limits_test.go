@@ -0,0 +1,227 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/forward"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"github.com/google/uuid"
+)
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"1000", 1000, true},
+		{"512K", 512 << 10, true},
+		{"10M", 10 << 20, true},
+		{"1.5MB/s", 3 << 19, true},
+		{"1g", 1 << 30, true},
+		{"off", 0, true},
+		{"fast", 0, false},
+		{"-1K", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteRate(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseByteRate(%q) = %d, %v; want %d, ok=%v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLimiterConnectionCaps(t *testing.T) {
+	l := newLimiter()
+	l.setPolicy(Policy{MaxConnectionsPerDevice: 2, MaxConnectionsPerUser: 3})
+	user := uuid.New()
+	deviceA, deviceB := uuid.New(), uuid.New()
+
+	a1, err := l.acquire(deviceA, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire(deviceA, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire(deviceA, user); !errors.Is(err, ErrDeviceConnectionLimit) {
+		t.Errorf("third connection to device = %v, want %v", err, ErrDeviceConnectionLimit)
+	}
+	if _, err := l.acquire(deviceB, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire(deviceB, user); !errors.Is(err, ErrUserConnectionLimit) {
+		t.Errorf("fourth connection for user = %v, want %v", err, ErrUserConnectionLimit)
+	}
+
+	// Closing a connection frees a slot
+	a1.release()
+	if _, err := l.acquire(deviceB, user); err != nil {
+		t.Errorf("connection after release = %v", err)
+	}
+
+	counts := map[uuid.UUID]models.TunnelUsage{}
+	for _, u := range l.takeUsage() {
+		counts[u.DeviceID] = u
+	}
+	if u := counts[deviceA]; u.Connections != 2 || u.RejectedConnections != 1 {
+		t.Errorf("device A usage = %+v", u)
+	}
+	if u := counts[deviceB]; u.Connections != 2 || u.RejectedConnections != 1 {
+		t.Errorf("device B usage = %+v", u)
+	}
+}
+
+func TestLeasePipe(t *testing.T) {
+	l := newLimiter()
+	l.setPolicy(Policy{IdleTimeout: time.Minute})
+	deviceID := uuid.New()
+	lease, err := l.acquire(deviceID, uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.release()
+
+	client, clientPeer := net.Pipe()
+	device, devicePeer := net.Pipe()
+	reason := make(chan string, 1)
+	go func() {
+		reason <- lease.pipe(context.Background(), clientPeer, devicePeer)
+		clientPeer.Close()
+		devicePeer.Close()
+	}()
+
+	// Echo on the device side
+	go func() {
+		io.Copy(device, device)
+	}()
+	client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+	client.Close()
+
+	if got := <-reason; got != "closed" {
+		t.Errorf("reason = %q, want closed", got)
+	}
+	usage := l.takeUsage()
+	if len(usage) != 1 || usage[0].BytesIn != 5 || usage[0].BytesOut != 5 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+func TestLeasePipeTimeouts(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{"idle", Policy{IdleTimeout: 50 * time.Millisecond}, "idle timeout"},
+		{"lifetime", Policy{MaxLifetime: 50 * time.Millisecond}, "maximum lifetime reached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter()
+			l.setPolicy(tt.policy)
+			lease, err := l.acquire(uuid.New(), uuid.Nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lease.release()
+
+			client, clientPeer := net.Pipe()
+			device, devicePeer := net.Pipe()
+			defer client.Close()
+			defer device.Close()
+
+			done := make(chan string, 1)
+			go func() {
+				done <- lease.pipe(context.Background(), clientPeer, devicePeer)
+				clientPeer.Close()
+				devicePeer.Close()
+			}()
+
+			select {
+			case got := <-done:
+				if got != tt.want {
+					t.Errorf("reason = %q, want %q", got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("connection wasn't closed")
+			}
+		})
+	}
+}
+
+func TestLeasePipeBandwidth(t *testing.T) {
+	l := newLimiter()
+	// The first forward.BufferSize*2 bytes pass as a burst, the rest at the rate
+	l.setPolicy(Policy{DeviceBandwidthIn: 2 * forward.BufferSize})
+	lease, err := l.acquire(uuid.New(), uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.release()
+
+	client, clientPeer := net.Pipe()
+	device, devicePeer := net.Pipe()
+	defer device.Close()
+	go lease.pipe(context.Background(), clientPeer, devicePeer)
+
+	data := bytes.Repeat([]byte("x"), 3*forward.BufferSize)
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+
+	start := time.Now()
+	if _, err := io.ReadFull(device, make([]byte, len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("transfer took %v, want about 500ms", elapsed)
+	}
+}
+
+type fakeUsageStore struct {
+	fail  bool
+	added []models.TunnelUsage
+}
+
+func (f *fakeUsageStore) Add(ctx context.Context, usage models.TunnelUsage) error {
+	if f.fail {
+		return errors.New("database unavailable")
+	}
+	f.added = append(f.added, usage)
+	return nil
+}
+
+func TestFlushUsage(t *testing.T) {
+	s := &Server{limits: newLimiter()}
+	deviceID := uuid.New()
+	s.limits.addBytes(deviceID, 100, 200)
+
+	// Failed writes are kept for the next flush
+	store := &fakeUsageStore{fail: true}
+	s.SetUsageStore(store)
+	s.flushUsage()
+	s.limits.addBytes(deviceID, 1, 2)
+
+	store.fail = false
+	s.flushUsage()
+	if len(store.added) != 1 || store.added[0].BytesIn != 101 || store.added[0].BytesOut != 202 {
+		t.Errorf("stored usage = %+v", store.added)
+	}
+
+	s.flushUsage()
+	if len(store.added) != 1 {
+		t.Errorf("flushed again without new usage: %+v", store.added)
+	}
+}
@@ -1,22 +1,24 @@
 package tunnel
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/kamikazebr/roamie-desktop/internal/server/activation"
 	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
+	"github.com/kamikazebr/roamie-desktop/pkg/control"
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/ssh"
 )
@@ -27,16 +29,36 @@ const (
 	LegacyConfigPath = "/etc/roamie-desktop"
 	HostKeyFile      = "ssh_host_key"
 	BackupDirName    = "backups"
+
+	// How often the server reloads host keys (picks up rotations from the admin CLI)
+	hostKeyCheckInterval = time.Minute
 )
 
 type Server struct {
 	deviceRepo *storage.DeviceRepository
-	authMgr    *AuthorizationManager
+	authMgr    connectionAuthorizer
 	listener   net.Listener
-	sshConfig  *ssh.ServerConfig
 	ctx        context.Context
 	cancel     context.CancelFunc
 	wg         sync.WaitGroup
+
+	mu        sync.RWMutex
+	hostKeys  *HostKeySet
+	sshConfig *ssh.ServerConfig
+	userCA    ssh.PublicKey // Signs tunnel certificates; nil accepts registered keys only
+	sessions  map[uuid.UUID]session
+
+	limits     *limiter
+	usageStore UsageStore // nil keeps usage in memory only
+	exposure   ExposurePolicy
+	draining   bool // Devices are being handed to the next server instance
+}
+
+// session is a device's live tunnel connection
+type session struct {
+	conn      *ssh.ServerConn
+	transport string
+	hello     *control.Hello // Commands the device handles; nil for old clients
 }
 
 // NewServer creates a new SSH tunnel server
@@ -48,6 +70,8 @@ func NewServer(deviceRepo *storage.DeviceRepository) (*Server, error) {
 		authMgr:    NewAuthorizationManager(deviceRepo),
 		ctx:        ctx,
 		cancel:     cancel,
+		sessions:   make(map[uuid.UUID]session),
+		limits:     newLimiter(),
 	}
 
 	// Migrate from old path if needed
@@ -60,23 +84,77 @@ func NewServer(deviceRepo *storage.DeviceRepository) (*Server, error) {
 		log.Printf("Warning: failed to backup existing keys: %v", err)
 	}
 
-	// Load or generate host key
-	hostKey, err := s.loadOrGenerateHostKey()
-	if err != nil {
+	// Load or generate host keys
+	if err := s.loadOrGenerateHostKey(); err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to load host key: %w", err)
 	}
 
-	// Configure SSH server
-	s.sshConfig = &ssh.ServerConfig{
+	return s, nil
+}
+
+// loadHostKeys loads the host keys from disk and serves them to new connections
+func (s *Server) loadHostKeys() error {
+	set, err := LoadHostKeys(ServerConfigPath)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hostKeys != nil && s.hostKeys.fingerprints() == set.fingerprints() {
+		return nil
+	}
+
+	config := &ssh.ServerConfig{
 		NoClientAuth: false,
 		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 			return s.authenticateClient(conn, key)
 		},
 	}
-	s.sshConfig.AddHostKey(hostKey)
+	// Keys of the same algorithm replace each other, so the current key wins
+	if set.Previous != nil {
+		config.AddHostKey(set.Previous)
+	}
+	config.AddHostKey(set.Current)
 
-	return s, nil
+	if s.hostKeys != nil {
+		log.Printf("✓ Reloaded SSH host keys (current %s)", ssh.FingerprintSHA256(set.Current.PublicKey()))
+	}
+	s.hostKeys = set
+	s.sshConfig = config
+	return nil
+}
+
+// HostKeys returns the host keys clients should pin
+func (s *Server) HostKeys() []models.TunnelHostKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.hostKeys == nil {
+		return nil
+	}
+	return s.hostKeys.Published()
+}
+
+// reloadHostKeysLoop picks up host key rotations until the server stops
+func (s *Server) reloadHostKeysLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(hostKeyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.loadHostKeys(); err != nil {
+				log.Printf("Warning: failed to reload SSH host keys: %v", err)
+			}
+		}
+	}
 }
 
 // migrateConfigPath migrates from /etc/roamie-desktop to /etc/roamie-server
@@ -185,56 +263,98 @@ To restore:
 	return nil
 }
 
-// loadOrGenerateHostKey loads existing host key or generates a new one
-func (s *Server) loadOrGenerateHostKey() (ssh.Signer, error) {
+// loadOrGenerateHostKey loads the host keys, generating an Ed25519 host key
+// on first start
+func (s *Server) loadOrGenerateHostKey() error {
 	// Ensure config directory exists
 	if err := os.MkdirAll(ServerConfigPath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create config directory: %w", err)
+		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
 	hostKeyPath := filepath.Join(ServerConfigPath, HostKeyFile)
 
-	// Try to load existing key
-	if data, err := os.ReadFile(hostKeyPath); err == nil {
-		signer, err := ssh.ParsePrivateKey(data)
-		if err == nil {
-			log.Printf("✓ Loaded existing SSH host key from: %s", hostKeyPath)
-			return signer, nil
+	if _, err := os.Stat(hostKeyPath); os.IsNotExist(err) {
+		log.Println("Generating new SSH host key...")
+		if _, err := generateHostKey(hostKeyPath); err != nil {
+			return err
 		}
-		log.Printf("Warning: failed to parse existing host key: %v", err)
+		log.Printf("✓ Generated and saved new SSH host key to: %s", hostKeyPath)
 	}
 
-	// Generate new key
-	log.Println("Generating new SSH host key...")
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err := s.loadHostKeys(); err != nil {
+		return err
+	}
+	for _, key := range s.HostKeys() {
+		log.Printf("✓ SSH host key (%s): %s %s", key.Status, key.Algorithm, key.Fingerprint)
+	}
+	return nil
+}
+
+// authenticateClient validates the client's SSH public key against the database
+func (s *Server) authenticateClient(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	var device *models.Device
+	var err error
+	if cert, ok := key.(*ssh.Certificate); ok {
+		device, err = s.deviceForCertificate(cert)
+	} else {
+		device, err = s.deviceForKey(key)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate key: %w", err)
+		return nil, err
 	}
 
-	// Encode private key to PEM format
-	privateKeyPEM := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	// Check if device is active
+	if !device.Active {
+		log.Printf("Rejected connection: device %s is inactive", device.ID)
+		return nil, fmt.Errorf("device inactive")
 	}
-	privateKeyBytes := pem.EncodeToMemory(privateKeyPEM)
 
-	// Save to disk
-	if err := os.WriteFile(hostKeyPath, privateKeyBytes, 0600); err != nil {
-		return nil, fmt.Errorf("failed to save host key: %w", err)
+	// Forward connections only reach other devices, so they don't need this
+	// device's tunnel
+	if conn.User() == ForwardUser {
+		log.Printf("✓ Authenticated device %s (forwarding)", device.ID)
+		return &ssh.Permissions{
+			Extensions: map[string]string{
+				"device_id": device.ID.String(),
+				"user_id":   device.UserID.String(),
+			},
+		}, nil
 	}
 
-	// Parse to ssh.Signer
-	signer, err := ssh.ParsePrivateKey(privateKeyBytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse generated key: %w", err)
+	// Check if tunnel is enabled for this device
+	if !device.TunnelEnabled {
+		log.Printf("Rejected connection: tunnel disabled for device %s", device.ID)
+		return nil, fmt.Errorf("tunnel disabled for this device")
+	}
+
+	// Check if device has allocated tunnel port
+	if device.TunnelPort == nil {
+		log.Printf("Rejected connection: no tunnel port allocated for device %s", device.ID)
+		return nil, fmt.Errorf("no tunnel port allocated")
 	}
 
-	log.Printf("✓ Generated and saved new SSH host key to: %s", hostKeyPath)
-	return signer, nil
+	log.Printf("✓ Authenticated device %s (port %d)", device.ID, *device.TunnelPort)
+
+	// Return permissions with device info
+	extensions := map[string]string{
+		"device_id":   device.ID.String(),
+		"user_id":     device.UserID.String(),
+		"tunnel_port": fmt.Sprintf("%d", *device.TunnelPort),
+	}
+	exposureExtensions(device, extensions)
+	return &ssh.Permissions{Extensions: extensions}, nil
 }
 
-// authenticateClient validates the client's SSH public key against the database
-func (s *Server) authenticateClient(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+// SetUserCA accepts tunnel certificates signed by the SSH user CA in
+// addition to registered tunnel keys
+func (s *Server) SetUserCA(key ssh.PublicKey) {
+	s.mu.Lock()
+	s.userCA = key
+	s.mu.Unlock()
+}
+
+// deviceForKey looks up the device that registered key as its tunnel key
+func (s *Server) deviceForKey(key ssh.PublicKey) (*models.Device, error) {
 	// Get authorized key in SSH format and trim whitespace for comparison
 	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
 
@@ -252,49 +372,83 @@ func (s *Server) authenticateClient(conn ssh.ConnMetadata, key ssh.PublicKey) (*
 		log.Printf("Rejected connection: SSH key not found in database (key: %.100s...)", authorizedKey)
 		return nil, fmt.Errorf("public key not authorized")
 	}
+	return device, nil
+}
 
-	// Check if device is active
-	if !device.Active {
-		log.Printf("Rejected connection: device %s is inactive", device.ID)
-		return nil, fmt.Errorf("device inactive")
+// deviceForCertificate checks a tunnel certificate and returns the device
+// named by its key ID
+func (s *Server) deviceForCertificate(cert *ssh.Certificate) (*models.Device, error) {
+	s.mu.RLock()
+	userCA := s.userCA
+	s.mu.RUnlock()
+
+	if err := checkTunnelCertificate(userCA, cert); err != nil {
+		log.Printf("Rejected connection: %v", err)
+		return nil, fmt.Errorf("certificate not authorized")
 	}
 
-	// Check if tunnel is enabled for this device
-	if !device.TunnelEnabled {
-		log.Printf("Rejected connection: tunnel disabled for device %s", device.ID)
-		return nil, fmt.Errorf("tunnel disabled for this device")
+	deviceID, err := uuid.Parse(cert.KeyId)
+	if err != nil {
+		log.Printf("Rejected connection: certificate key ID %q is not a device ID", cert.KeyId)
+		return nil, fmt.Errorf("certificate not authorized")
 	}
 
-	// Check if device has allocated tunnel port
-	if device.TunnelPort == nil {
-		log.Printf("Rejected connection: no tunnel port allocated for device %s", device.ID)
-		return nil, fmt.Errorf("no tunnel port allocated")
+	device, err := s.deviceRepo.GetByID(s.ctx, deviceID)
+	if err != nil {
+		log.Printf("Database error during authentication: %v", err)
+		return nil, fmt.Errorf("authentication failed")
 	}
+	if device == nil {
+		log.Printf("Rejected connection: certificate for unknown or removed device %s", deviceID)
+		return nil, fmt.Errorf("certificate not authorized")
+	}
+	return device, nil
+}
 
-	log.Printf("✓ Authenticated device %s (port %d)", device.ID, *device.TunnelPort)
+// checkTunnelCertificate verifies cert is a current tunnel certificate
+// signed by userCA
+func checkTunnelCertificate(userCA ssh.PublicKey, cert *ssh.Certificate) error {
+	if userCA == nil {
+		return fmt.Errorf("certificate authentication is not enabled")
+	}
+	if cert.CertType != ssh.UserCert {
+		return fmt.Errorf("certificate %q is not a user certificate", cert.KeyId)
+	}
 
-	// Return permissions with device info
-	return &ssh.Permissions{
-		Extensions: map[string]string{
-			"device_id":   device.ID.String(),
-			"tunnel_port": fmt.Sprintf("%d", *device.TunnelPort),
-		},
-	}, nil
+	// CheckCert verifies the signature but not who made it
+	if !bytes.Equal(cert.SignatureKey.Marshal(), userCA.Marshal()) {
+		return fmt.Errorf("certificate %q is not signed by the Roamie CA", cert.KeyId)
+	}
+	checker := &ssh.CertChecker{}
+	if err := checker.CheckCert(models.SSHTunnelPrincipal, cert); err != nil {
+		return fmt.Errorf("invalid tunnel certificate %q: %w", cert.KeyId, err)
+	}
+	return nil
 }
 
 // Start starts the SSH tunnel server
 func (s *Server) Start() error {
-	addr := fmt.Sprintf("0.0.0.0:%d", TunnelPort)
-	listener, err := net.Listen("tcp", addr)
+	// A socket passed by systemd stays open across restarts
+	listener, err := activation.Listener(activation.Tunnel)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		return err
+	}
+	if listener != nil {
+		log.Printf("✓ SSH tunnel server listening on %s (socket activation)", listener.Addr())
+	} else {
+		addr := fmt.Sprintf("0.0.0.0:%d", TunnelPort)
+		if listener, err = net.Listen("tcp", addr); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		log.Printf("✓ SSH tunnel server listening on %s", addr)
 	}
 
 	s.listener = listener
-	log.Printf("✓ SSH tunnel server listening on %s", addr)
 
-	s.wg.Add(1)
+	s.wg.Add(3)
 	go s.acceptLoop()
+	go s.reloadHostKeysLoop()
+	go s.flushUsageLoop()
 
 	return nil
 }
@@ -310,23 +464,30 @@ func (s *Server) acceptLoop() {
 			case <-s.ctx.Done():
 				return // Server stopped
 			default:
+				if errors.Is(err, net.ErrClosed) {
+					return // Draining
+				}
 				log.Printf("Failed to accept connection: %v", err)
 				continue
 			}
 		}
 
 		s.wg.Add(1)
-		go s.handleConnection(conn)
+		go s.handleConnection(conn, TransportTCP)
 	}
 }
 
-// handleConnection handles a single SSH connection
-func (s *Server) handleConnection(conn net.Conn) {
+// handleConnection handles a single SSH connection arriving over transport
+func (s *Server) handleConnection(conn net.Conn, transport string) {
 	defer s.wg.Done()
 	defer conn.Close()
 
 	// SSH handshake
-	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
+	s.mu.RLock()
+	config := s.sshConfig
+	s.mu.RUnlock()
+
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
 	if err != nil {
 		log.Printf("SSH handshake failed: %v", err)
 		return
@@ -336,7 +497,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 	deviceID := sshConn.Permissions.Extensions["device_id"]
 	tunnelPort := sshConn.Permissions.Extensions["tunnel_port"]
 
-	log.Printf("SSH connection established for device %s (port %s)", deviceID, tunnelPort)
+	log.Printf("SSH connection established for device %s (port %s, %s)", deviceID, tunnelPort, transport)
 
 	// Parse device ID from permissions
 	sourceDeviceID, err := uuid.Parse(deviceID)
@@ -345,15 +506,29 @@ func (s *Server) handleConnection(conn net.Conn) {
 		return
 	}
 
+	// Owner of the device, for the per-user limits
+	userID, _ := uuid.Parse(sshConn.Permissions.Extensions["user_id"])
+
+	// Forward connections reach other devices; they aren't this device's tunnel
+	if sshConn.User() == ForwardUser {
+		go s.handleForwardSession(sshConn, reqs, chans, sourceDeviceID)
+		sshConn.Wait()
+		log.Printf("Forward connection closed for device %s", deviceID)
+		return
+	}
+
+	s.trackSession(sourceDeviceID, sshConn, transport)
+	defer s.untrackSession(sourceDeviceID, sshConn)
+
 	// Handle global requests (port forwarding setup) and channels together
-	go s.handleTunnelSession(sshConn, reqs, chans, sourceDeviceID, deviceID, tunnelPort)
+	go s.handleTunnelSession(sshConn, reqs, chans, sourceDeviceID, userID, deviceID, tunnelPort)
 
 	// Wait for connection to close
 	sshConn.Wait()
 }
 
 // handleTunnelSession manages the tunnel session including port forwarding and authorization
-func (s *Server) handleTunnelSession(sshConn *ssh.ServerConn, reqs <-chan *ssh.Request, chans <-chan ssh.NewChannel, deviceID uuid.UUID, deviceIDStr, tunnelPortStr string) {
+func (s *Server) handleTunnelSession(sshConn *ssh.ServerConn, reqs <-chan *ssh.Request, chans <-chan ssh.NewChannel, deviceID, userID uuid.UUID, deviceIDStr, tunnelPortStr string) {
 	var tunnelListener net.Listener
 	defer func() {
 		if tunnelListener != nil {
@@ -400,8 +575,10 @@ func (s *Server) handleTunnelSession(sshConn *ssh.ServerConn, reqs <-chan *ssh.R
 				continue
 			}
 
-			// Start listening on the allocated port
-			listenAddr := fmt.Sprintf("0.0.0.0:%d", requestedPort)
+			// Start listening on the allocated port, where the device's
+			// exposure allows
+			policy := s.exposurePolicy().forDevice(sshConn.Permissions)
+			listenAddr := net.JoinHostPort(policy.listenHost(), strconv.Itoa(requestedPort))
 			listener, err := net.Listen("tcp", listenAddr)
 			if err != nil {
 				log.Printf("Failed to listen on %s: %v", listenAddr, err)
@@ -426,7 +603,7 @@ func (s *Server) handleTunnelSession(sshConn *ssh.ServerConn, reqs <-chan *ssh.R
 			}
 
 			// Handle incoming connections on this port
-			go s.handleTunnelConnections(listener, sshConn, deviceID, requestedPort)
+			go s.handleTunnelConnections(listener, sshConn, deviceID, userID, requestedPort, policy)
 
 		case "cancel-tcpip-forward":
 			log.Printf("Device %s canceling reverse tunnel", deviceIDStr)
@@ -438,6 +615,9 @@ func (s *Server) handleTunnelSession(sshConn *ssh.ServerConn, reqs <-chan *ssh.R
 				req.Reply(true, nil)
 			}
 
+		case control.HelloRequest:
+			s.handleHello(req, sshConn, deviceID)
+
 		case "keepalive@roamie":
 			// Respond to client keepalive to maintain connection
 			if req.WantReply {
@@ -454,7 +634,7 @@ func (s *Server) handleTunnelSession(sshConn *ssh.ServerConn, reqs <-chan *ssh.R
 }
 
 // handleTunnelConnections handles incoming TCP connections on a tunnel port
-func (s *Server) handleTunnelConnections(listener net.Listener, sshConn *ssh.ServerConn, targetDeviceID uuid.UUID, tunnelPort int) {
+func (s *Server) handleTunnelConnections(listener net.Listener, sshConn *ssh.ServerConn, targetDeviceID, userID uuid.UUID, tunnelPort int, policy ExposurePolicy) {
 	for {
 		// Accept incoming TCP connection
 		tcpConn, err := listener.Accept()
@@ -470,56 +650,44 @@ func (s *Server) handleTunnelConnections(listener net.Listener, sshConn *ssh.Ser
 			}
 		}
 
+		if !policy.allows(tcpConn.RemoteAddr()) {
+			log.Printf("Rejected tunnel connection to port %d from %s: not in allowed CIDRs", tunnelPort, tcpConn.RemoteAddr())
+			tcpConn.Close()
+			continue
+		}
+
 		// Handle this connection in a goroutine
-		go s.forwardTunnelConnection(tcpConn, sshConn, targetDeviceID, tunnelPort)
+		go s.forwardTunnelConnection(tcpConn, sshConn, targetDeviceID, userID, tunnelPort)
 	}
 }
 
-// forwardTunnelConnection forwards a TCP connection through an SSH channel with authorization
-func (s *Server) forwardTunnelConnection(tcpConn net.Conn, sshConn *ssh.ServerConn, targetDeviceID uuid.UUID, tunnelPort int) {
+// forwardTunnelConnection forwards a TCP connection through an SSH channel
+// within the device's and user's limits
+func (s *Server) forwardTunnelConnection(tcpConn net.Conn, sshConn *ssh.ServerConn, targetDeviceID, userID uuid.UUID, tunnelPort int) {
 	defer tcpConn.Close()
 
 	// Get remote address for logging
 	remoteAddr := tcpConn.RemoteAddr().String()
 	log.Printf("Incoming connection to tunnel port %d from %s", tunnelPort, remoteAddr)
 
-	// Parse originator address and port
-	originHost, originPortStr, err := net.SplitHostPort(remoteAddr)
+	lease, err := s.limits.acquire(targetDeviceID, userID)
 	if err != nil {
-		log.Printf("Failed to parse remote address %s: %v", remoteAddr, err)
-		originHost = remoteAddr
-		originPortStr = "0"
-	}
-	var originPort uint32
-	if p, err := fmt.Sscanf(originPortStr, "%d", &originPort); err != nil || p != 1 {
-		originPort = 0
+		log.Printf("Rejected tunnel connection from %s to device %s: %v", remoteAddr, targetDeviceID, err)
+		return
 	}
+	defer lease.release()
+
+	// Parse originator address and port
+	originHost, originPort := splitOrigin(remoteAddr)
 
-	// For now, we allow all connections to the tunnel port and rely on
-	// the final SSH authentication at the target device.
-	// In a future enhancement, we could add IP-based or certificate-based auth here.
+	// Sources were checked against the exposure policy on accept; the
+	// target device's SSH authentication does the rest
 
 	// Create a forwarded-tcpip channel to the target device
 	// This goes through the device's existing SSH connection
-	// IMPORTANT: The Address/Port fields must match what the client requested in tcpip-forward!
-	// Go's SSH library uses these fields to match the channel to the correct listener.
-	// The Address should be the bind address from tcpip-forward (e.g., "0.0.0.0")
-	// The Port should be the tunnel port (e.g., 10000)
-	payload := struct {
-		Address           string
-		Port              uint32
-		OriginatorAddress string
-		OriginatorPort    uint32
-	}{
-		Address:           "0.0.0.0",
-		Port:              uint32(tunnelPort), // Must match what client requested!
-		OriginatorAddress: originHost,
-		OriginatorPort:    originPort,
-	}
-
-	log.Printf("DEBUG: Opening forwarded-tcpip channel to device %s (dest: %s:%d, origin: %s)",
-		targetDeviceID, payload.Address, payload.Port, payload.OriginatorAddress)
-	channel, requests, err := sshConn.OpenChannel("forwarded-tcpip", ssh.Marshal(&payload))
+	log.Printf("DEBUG: Opening forwarded-tcpip channel to device %s (port %d, origin: %s)",
+		targetDeviceID, tunnelPort, originHost)
+	channel, requests, err := openForwardedChannel(sshConn, tunnelPort, originHost, originPort)
 	if err != nil {
 		log.Printf("Failed to open forwarded channel to device %s: %v", targetDeviceID, err)
 		return
@@ -534,23 +702,10 @@ func (s *Server) forwardTunnelConnection(tcpConn net.Conn, sshConn *ssh.ServerCo
 		remoteAddr, targetDeviceID, tunnelPort)
 
 	// Bidirectional forwarding between TCP connection and SSH channel
-	done := make(chan struct{}, 2)
+	reason := lease.pipe(s.ctx, tcpConn, channel)
 
-	go func() {
-		io.Copy(channel, tcpConn)
-		done <- struct{}{}
-	}()
-
-	go func() {
-		io.Copy(tcpConn, channel)
-		done <- struct{}{}
-	}()
-
-	// Wait for either direction to complete
-	<-done
-
-	log.Printf("Tunnel connection closed: %s → Device %s (port %d)",
-		remoteAddr, targetDeviceID, tunnelPort)
+	log.Printf("Tunnel connection closed: %s → Device %s (port %d, %s)",
+		remoteAddr, targetDeviceID, tunnelPort, reason)
 }
 
 // isClosedError checks if an error is due to a closed network connection
@@ -559,10 +714,86 @@ func isClosedError(err error) bool {
 		err == io.EOF)
 }
 
+// SessionTransport returns how a device's tunnel is connected, or "" if it
+// isn't
+func (s *Server) SessionTransport(deviceID uuid.UUID) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sessions[deviceID].transport
+}
+
+func (s *Server) trackSession(deviceID uuid.UUID, conn *ssh.ServerConn, transport string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[deviceID] = session{conn: conn, transport: transport}
+}
+
+// untrackSession forgets a session unless the device has reconnected since
+func (s *Server) untrackSession(deviceID uuid.UUID, conn *ssh.ServerConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[deviceID].conn == conn {
+		delete(s.sessions, deviceID)
+	}
+}
+
+// SetPolicy sets the limits for forwarded connections. Connections already
+// forwarding keep their bandwidth limits until the device has none left.
+func (s *Server) SetPolicy(policy Policy) {
+	s.limits.setPolicy(policy)
+}
+
+// SetUsageStore persists usage counters (optional)
+func (s *Server) SetUsageStore(store UsageStore) {
+	s.mu.Lock()
+	s.usageStore = store
+	s.mu.Unlock()
+}
+
+// flushUsageLoop writes usage counters to the store until the server stops
+func (s *Server) flushUsageLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.flushUsage()
+		}
+	}
+}
+
+// flushUsage adds the counters collected since the last flush to the store,
+// keeping the ones that fail for the next flush
+func (s *Server) flushUsage() {
+	s.mu.RLock()
+	store := s.usageStore
+	s.mu.RUnlock()
+	if store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, usage := range s.limits.takeUsage() {
+		if err := store.Add(ctx, usage); err != nil {
+			log.Printf("Warning: failed to save tunnel usage for device %s: %v", usage.DeviceID, err)
+			s.limits.restoreUsage(usage)
+		}
+	}
+}
+
 // Stop gracefully stops the SSH tunnel server
 func (s *Server) Stop() error {
 	log.Println("Stopping SSH tunnel server...")
+	// Under mu so WebSocket connections see it before adding to wg
+	s.mu.Lock()
 	s.cancel()
+	s.mu.Unlock()
 
 	if s.listener != nil {
 		s.listener.Close()
@@ -582,10 +813,6 @@ func (s *Server) Stop() error {
 		log.Println("⚠️  SSH tunnel server stop timeout")
 	}
 
+	s.flushUsage()
 	return nil
 }
-
-// forwardConnection forwards data between two connections
-func forwardConnection(dst io.Writer, src io.Reader) {
-	io.Copy(dst, src)
-}
@@ -0,0 +1,62 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/kamikazebr/roamie-desktop/pkg/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCheckTunnelCertificate(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+	userCA, otherCA, deviceKey := newSigner(), newSigner(), newSigner()
+
+	sign := func(ca ssh.Signer, certType uint32, principal string, validBefore time.Time) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             deviceKey.PublicKey(),
+			CertType:        certType,
+			KeyId:           "0b3f6c1e-0000-4000-8000-000000000000",
+			ValidPrincipals: []string{principal},
+			ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(validBefore.Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	later := time.Now().Add(10 * time.Minute)
+
+	if err := checkTunnelCertificate(userCA.PublicKey(), sign(userCA, ssh.UserCert, models.SSHTunnelPrincipal, later)); err != nil {
+		t.Errorf("Expected valid tunnel certificate, got %v", err)
+	}
+
+	rejected := map[string]*ssh.Certificate{
+		"other CA":       sign(otherCA, ssh.UserCert, models.SSHTunnelPrincipal, later),
+		"host cert":      sign(userCA, ssh.HostCert, models.SSHTunnelPrincipal, later),
+		"user principal": sign(userCA, ssh.UserCert, "alice", later),
+		"expired":        sign(userCA, ssh.UserCert, models.SSHTunnelPrincipal, time.Now().Add(-time.Second)),
+	}
+	for name, cert := range rejected {
+		if err := checkTunnelCertificate(userCA.PublicKey(), cert); err == nil {
+			t.Errorf("%s: expected certificate to be rejected", name)
+		}
+	}
+
+	if err := checkTunnelCertificate(nil, sign(userCA, ssh.UserCert, models.SSHTunnelPrincipal, later)); err == nil {
+		t.Error("Expected certificates to be rejected without a CA")
+	}
+}
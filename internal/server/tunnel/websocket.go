@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Transports a tunnel connection can arrive over
+const (
+	TransportTCP       = "tcp"       // Raw SSH on TunnelPort
+	TransportWebSocket = "websocket" // SSH in binary WebSocket frames on the API listener
+)
+
+// ServeWebSocket carries a tunnel SSH connection over a WebSocket, for
+// networks that only allow HTTPS. Authentication is the same SSH handshake as
+// on TunnelPort.
+func (s *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	if s.ctx.Err() != nil || s.Draining() {
+		http.Error(w, "tunnel server stopped", http.StatusServiceUnavailable)
+		return
+	}
+
+	remote := remoteAddr(r.RemoteAddr)
+	server := websocket.Server{
+		// Tunnel clients aren't browsers and send no Origin
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			// The API server's read/write timeouts still apply to the hijacked connection
+			ws.SetDeadline(time.Time{})
+
+			if !s.beginConnection() {
+				ws.Close()
+				return
+			}
+			s.handleConnection(&wsConn{Conn: ws, remote: remote}, TransportWebSocket)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// beginConnection counts a connection arriving outside the accept loop in
+// wg, unless the server is stopping or draining. It holds mu, under which
+// Stop cancels, so wg can't be added to once Stop is waiting on it.
+func (s *Server) beginConnection() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil || s.draining {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+// wsConn reports the HTTP client's address; websocket.Conn reports the Origin
+type wsConn struct {
+	*websocket.Conn
+	remote net.Addr
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func remoteAddr(addr string) net.Addr {
+	if tcpAddr, err := net.ResolveTCPAddr("tcp", addr); err == nil {
+		return tcpAddr
+	}
+	return &net.TCPAddr{}
+}
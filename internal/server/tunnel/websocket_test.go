@@ -0,0 +1,99 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/websocket"
+)
+
+func TestServeWebSocket(t *testing.T) {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, devicePrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceKey, err := ssh.NewSignerFromKey(devicePrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deviceID := uuid.New()
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{
+				"device_id":   deviceID.String(),
+				"tunnel_port": "10000",
+			}}, nil
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{ctx: ctx, cancel: cancel, sshConfig: sshConfig, sessions: make(map[uuid.UUID]session)}
+	httpServer := httptest.NewServer(http.HandlerFunc(s.ServeWebSocket))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/tunnel/ws"
+	ws, err := websocket.Dial(wsURL, "", httpServer.URL)
+	if err != nil {
+		t.Fatalf("websocket dial: %v", err)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(ws, "tunnel", &ssh.ClientConfig{
+		User:            "device",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(deviceKey)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatalf("SSH handshake over WebSocket: %v", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for s.SessionTransport(deviceID) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("SessionTransport = %q, want %q", s.SessionTransport(deviceID), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor(TransportWebSocket)
+
+	client.Close()
+	waitFor("")
+
+	// A stopped server refuses new tunnels
+	cancel()
+	resp, err := http.Get(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status after stop = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	// Nor counts connections that were upgraded while it stopped
+	if s.beginConnection() {
+		t.Error("beginConnection succeeded after stop")
+	}
+	s.wg.Wait()
+}
@@ -32,16 +32,16 @@ func (m *Manager) UpdatePeerAllowedIPs(publicKey string, allowedIPs []string) er
 	return nil
 }
 
-func GenerateClientConfig(privateKey, clientIP, serverPublicKey, serverEndpoint, allowedIPs string) string {
+func GenerateClientConfig(privateKey, clientIP, serverPublicKey, serverEndpoint, allowedIPs, dns string) string {
 	return fmt.Sprintf(`[Interface]
 PrivateKey = %s
 Address = %s/32
-DNS = 1.1.1.1, 8.8.8.8
+DNS = %s
 
 [Peer]
 PublicKey = %s
 Endpoint = %s
 AllowedIPs = %s
 PersistentKeepalive = 25
-`, privateKey, clientIP, serverPublicKey, serverEndpoint, allowedIPs)
+`, privateKey, clientIP, dns, serverPublicKey, serverEndpoint, allowedIPs)
 }
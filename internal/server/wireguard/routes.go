@@ -0,0 +1,170 @@
+package wireguard
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ExitRouteTable is the policy routing table that sends traffic from devices
+// using an exit node back into the WireGuard interface
+const ExitRouteTable = "51821"
+
+// exitRulePriority keeps exit node rules ahead of the main table
+const exitRulePriority = "5210"
+
+// exitChain filters traffic into devices using an exit node. It lives in the
+// mangle table so that ACCEPT rules inserted into the filter FORWARD chain
+// (see EnsureForwardRule) can't get ahead of it.
+const exitChain = "ROAMIE-EXIT"
+
+// SetPeerAllowedIPs replaces a peer's allowed IPs with the given CIDRs.
+// Used for devices acting as subnet routers or exit nodes, whose peer must
+// accept traffic for the advertised ranges in addition to its VPN IP.
+func (m *Manager) SetPeerAllowedIPs(publicKey string, cidrs []string) error {
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+
+	allowedIPs := make([]net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			cidr += "/32"
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid allowed IP %s: %w", cidr, err)
+		}
+		allowedIPs = append(allowedIPs, *ipnet)
+	}
+
+	config := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:         key,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        allowedIPs,
+		}},
+	}
+
+	if err := m.client.ConfigureDevice(m.interfaceName, config); err != nil {
+		return fmt.Errorf("failed to update peer allowed IPs: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureRoute routes a CIDR into the WireGuard interface so traffic from
+// other peers reaches the subnet router advertising it
+func (m *Manager) EnsureRoute(cidr string) error {
+	cmd := exec.Command("ip", "route", "replace", cidr, "dev", m.interfaceName)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add route %s: %w\nOutput: %s", cidr, err, string(output))
+	}
+	return nil
+}
+
+// RemoveRoute deletes a route added by EnsureRoute (missing routes are ignored)
+func (m *Manager) RemoveRoute(cidr string) error {
+	cmd := exec.Command("ip", "route", "del", cidr, "dev", m.interfaceName)
+	if output, err := cmd.CombinedOutput(); err != nil && !strings.Contains(string(output), "No such process") {
+		return fmt.Errorf("failed to remove route %s: %w\nOutput: %s", cidr, err, string(output))
+	}
+	return nil
+}
+
+// EnsureExitFirewall sets up the chain that keeps an exit node from opening
+// connections to the devices routed through it: from outside the VPN
+// networks, only return traffic of connections the devices opened gets in.
+// The exit node can't use VPN source addresses other than its own, since its
+// peer's allowed IPs leave the VPN networks out (WireGuard drops the rest).
+// Devices are added to the chain by EnsureExitRule.
+func (m *Manager) EnsureExitFirewall(vpnNetworks []string) error {
+	// The chain may exist from a previous run; its device rules are re-added
+	if output, err := exec.Command("iptables", "-t", "mangle", "-N", exitChain).CombinedOutput(); err != nil &&
+		!strings.Contains(string(output), "exists") {
+		return fmt.Errorf("failed to create %s chain: %w\nOutput: %s", exitChain, err, string(output))
+	}
+	if output, err := exec.Command("iptables", "-t", "mangle", "-F", exitChain).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to flush %s chain: %w\nOutput: %s", exitChain, err, string(output))
+	}
+
+	rules := [][]string{{"-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "RETURN"}}
+	for _, network := range vpnNetworks {
+		rules = append(rules, []string{"-s", network, "-j", "RETURN"})
+	}
+	for _, rule := range rules {
+		args := append([]string{"-t", "mangle", "-A", exitChain}, rule...)
+		if output, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add %s rule: %w\nOutput: %s", exitChain, err, string(output))
+		}
+	}
+
+	jump := []string{"FORWARD", "-i", m.interfaceName, "-j", exitChain}
+	if exec.Command("iptables", append([]string{"-t", "mangle", "-C"}, jump...)...).Run() == nil {
+		return nil
+	}
+	if output, err := exec.Command("iptables", append([]string{"-t", "mangle", "-I"}, jump...)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add %s jump: %w\nOutput: %s", exitChain, err, string(output))
+	}
+	return nil
+}
+
+// EnsureExitRule sends all traffic from a device's VPN IP through the exit
+// node table, whose default route points back into the WireGuard interface,
+// and lets only replies from outside the VPN networks back in
+func (m *Manager) EnsureExitRule(srcIP string) error {
+	tableCmd := exec.Command("ip", "route", "replace", "default", "dev", m.interfaceName, "table", ExitRouteTable)
+	if output, err := tableCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set exit route table: %w\nOutput: %s", err, string(output))
+	}
+
+	// Replies from the internet arrive on the WireGuard interface, which strict
+	// reverse path filtering would drop
+	rpCmd := exec.Command("sysctl", "-w", fmt.Sprintf("net.ipv4.conf.%s.rp_filter=2", m.interfaceName))
+	if output, err := rpCmd.CombinedOutput(); err != nil {
+		log.Printf("Warning: Could not relax rp_filter on %s: %v\nOutput: %s", m.interfaceName, err, string(output))
+	}
+
+	drop := []string{exitChain, "-d", srcIP + "/32", "-j", "DROP"}
+	if exec.Command("iptables", append([]string{"-t", "mangle", "-C"}, drop...)...).Run() != nil {
+		if output, err := exec.Command("iptables", append([]string{"-t", "mangle", "-A"}, drop...)...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to filter traffic to %s: %w\nOutput: %s", srcIP, err, string(output))
+		}
+	}
+
+	// Check if rule already exists
+	checkCmd := exec.Command("ip", "rule", "show", "from", srcIP, "lookup", ExitRouteTable)
+	if output, err := checkCmd.Output(); err == nil && strings.TrimSpace(string(output)) != "" {
+		return nil
+	}
+
+	addCmd := exec.Command("ip", "rule", "add", "from", srcIP, "lookup", ExitRouteTable, "priority", exitRulePriority)
+	if output, err := addCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add exit rule for %s: %w\nOutput: %s", srcIP, err, string(output))
+	}
+
+	log.Printf("✓ Traffic from %s now leaves through the exit node", srcIP)
+	return nil
+}
+
+// RemoveExitRule stops routing a device's traffic through the exit node table
+func (m *Manager) RemoveExitRule(srcIP string) error {
+	cmd := exec.Command("ip", "rule", "del", "from", srcIP, "lookup", ExitRouteTable)
+	if output, err := cmd.CombinedOutput(); err != nil && !strings.Contains(string(output), "No such file") {
+		return fmt.Errorf("failed to remove exit rule for %s: %w\nOutput: %s", srcIP, err, string(output))
+	}
+
+	// Only after traffic stopped going through the exit node
+	drop := []string{exitChain, "-d", srcIP + "/32", "-j", "DROP"}
+	if exec.Command("iptables", append([]string{"-t", "mangle", "-C"}, drop...)...).Run() == nil {
+		if output, err := exec.Command("iptables", append([]string{"-t", "mangle", "-D"}, drop...)...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove exit filter for %s: %w\nOutput: %s", srcIP, err, string(output))
+		}
+	}
+	return nil
+}
@@ -5,8 +5,8 @@ import (
 	"os"
 	"testing"
 
-	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
 	"github.com/jmoiron/sqlx"
+	"github.com/kamikazebr/roamie-desktop/internal/server/storage"
 	_ "github.com/lib/pq"
 )
 
@@ -70,19 +70,29 @@ func (tdb *TestDB) StorageDB() *storage.DB {
 func (tdb *TestDB) Repositories() *TestRepositories {
 	db := tdb.StorageDB()
 	return &TestRepositories{
-		Users:      storage.NewUserRepository(db),
-		Devices:    storage.NewDeviceRepository(db),
-		DeviceAuth: storage.NewDeviceAuthRepository(db),
-		Conflicts:  storage.NewConflictRepository(db),
-		Auth:       storage.NewAuthRepository(db),
+		Users:          storage.NewUserRepository(db),
+		Devices:        storage.NewDeviceRepository(db),
+		DeviceAuth:     storage.NewDeviceAuthRepository(db),
+		Conflicts:      storage.NewConflictRepository(db),
+		Auth:           storage.NewAuthRepository(db),
+		Routes:         storage.NewRouteRepository(db),
+		SigningKeys:    storage.NewSigningKeyRepository(db),
+		Identities:     storage.NewIdentityRepository(db),
+		EnrollmentKeys: storage.NewEnrollmentKeyRepository(db),
+		SSHCA:          storage.NewSSHCARepository(db),
 	}
 }
 
 // TestRepositories contains all repositories for testing
 type TestRepositories struct {
-	Users      *storage.UserRepository
-	Devices    *storage.DeviceRepository
-	DeviceAuth *storage.DeviceAuthRepository
-	Conflicts  *storage.ConflictRepository
-	Auth       *storage.AuthRepository
+	Users          *storage.UserRepository
+	Devices        *storage.DeviceRepository
+	DeviceAuth     *storage.DeviceAuthRepository
+	Conflicts      *storage.ConflictRepository
+	Auth           *storage.AuthRepository
+	Routes         *storage.RouteRepository
+	SigningKeys    *storage.SigningKeyRepository
+	Identities     *storage.IdentityRepository
+	EnrollmentKeys *storage.EnrollmentKeyRepository
+	SSHCA          *storage.SSHCARepository
 }
@@ -0,0 +1,53 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Run runs a command on the device at the other end of conn, passing output
+// lines to onOutput (may be nil) as they arrive, and returns the result.
+// Cancelling ctx closes the channel.
+func Run(ctx context.Context, conn ssh.Conn, req Request, onOutput func(line string)) (*Event, error) {
+	channel, reqs, err := conn.OpenChannel(ChannelType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open control channel: %w", err)
+	}
+	go ssh.DiscardRequests(reqs)
+	defer channel.Close()
+	stop := context.AfterFunc(ctx, func() { channel.Close() })
+	defer stop()
+
+	if err := json.NewEncoder(channel).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send command: %w", err)
+	}
+	channel.CloseWrite()
+
+	decoder := json.NewDecoder(channel)
+	for {
+		var event Event
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("device closed the control channel without a result")
+			}
+			return nil, fmt.Errorf("invalid reply from device: %w", err)
+		}
+
+		switch event.Type {
+		case EventOutput:
+			if onOutput != nil {
+				onOutput(event.Data)
+			}
+		case EventResult:
+			return &event, nil
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package control is how the tunnel server pushes commands to devices over
+// the SSH connection each device already keeps open for its tunnel.
+//
+// After connecting, a device that supports commands sends a HelloRequest
+// global request listing the commands it handles. Servers that don't know
+// the request reject it, and the server only sends commands a device listed,
+// so old clients and old servers keep working.
+//
+// For each command the server opens a ChannelType channel, writes one JSON
+// Request and closes its side. The device streams back JSON Events, one per
+// line: output lines while the command runs, then one result.
+//
+// Before the server stops it sends every device a ReconnectRequest global
+// request without asking for a reply. Devices that know it reconnect at a
+// random moment within the window it gives, so a restarted server isn't hit
+// by all of them at once; others ignore it and reconnect when dropped.
+package control
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+const (
+	ChannelType      = "roamie-control@roamie"
+	HelloRequest     = "roamie-hello@roamie"
+	ReconnectRequest = "roamie-reconnect@roamie"
+	ProtocolVersion  = 1
+
+	// Requests are small; anything bigger is not a request
+	maxRequestSize = 1 << 20
+)
+
+// Commands devices may handle
+const (
+	CommandDoctor        = "doctor"         // Run diagnostics and return the report
+	CommandSyncKeys      = "sync-keys"      // Sync authorized SSH keys and the SSH CA now
+	CommandRestartTunnel = "restart-tunnel" // Reconnect the tunnel (after replying)
+	CommandLogs          = "logs"           // Return recent daemon log lines
+	CommandApplyConfig   = "apply-config"   // Change client settings
+)
+
+// Hello is exchanged in the HelloRequest: the device sends the commands it
+// handles, the server replies with its protocol version
+type Hello struct {
+	Version       int      `json:"version"`
+	ClientVersion string   `json:"client_version,omitempty"`
+	Commands      []string `json:"commands,omitempty"`
+}
+
+// Supports reports whether the device handles command
+func (h *Hello) Supports(command string) bool {
+	return h != nil && slices.Contains(h.Commands, command)
+}
+
+// Reconnect is sent in the ReconnectRequest
+type Reconnect struct {
+	WindowMS int64 `json:"window_ms"` // Reconnect at a random moment within this many milliseconds
+}
+
+// Window returns the reconnect window as a duration
+func (r Reconnect) Window() time.Duration {
+	return time.Duration(max(r.WindowMS, 0)) * time.Millisecond
+}
+
+// Request is a command for the device
+type Request struct {
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+// Event types
+const (
+	EventOutput = "output"
+	EventResult = "result"
+)
+
+// Event is a line of output or the command's result
+type Event struct {
+	Type   string          `json:"type"`
+	Data   string          `json:"data,omitempty"` // Output line
+	OK     bool            `json:"ok,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
@@ -0,0 +1,166 @@
+package control
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// connect returns the server's side of an SSH connection whose client (the
+// device) serves mux
+func connect(t *testing.T, mux *Mux) ssh.Conn {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	serverConn := make(chan ssh.Conn, 1)
+	go func() {
+		serverSide, err := l.Accept()
+		if err != nil {
+			serverConn <- nil
+			return
+		}
+		conn, chans, reqs, err := ssh.NewServerConn(serverSide, config)
+		if err != nil {
+			serverConn <- nil
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			for ch := range chans {
+				ch.Reject(ssh.Prohibited, "")
+			}
+		}()
+		serverConn <- conn
+	}()
+
+	deviceSide, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(deviceSide, "server", &ssh.ClientConfig{
+		User:            "device",
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(conn, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+	go mux.Serve(context.Background(), client.HandleChannelOpen(ChannelType))
+
+	server := <-serverConn
+	if server == nil {
+		t.Fatal("SSH handshake failed")
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestRun(t *testing.T) {
+	replied := make(chan struct{})
+	mux := NewMux()
+	mux.Handle("echo", func(ctx context.Context, call *Call) (any, error) {
+		var args struct{ Lines []string }
+		if err := call.DecodeArgs(&args); err != nil {
+			return nil, err
+		}
+		for _, line := range args.Lines {
+			fmt.Fprintln(call.Output, line)
+		}
+		fmt.Fprint(call.Output, "no newline")
+		call.AfterReply(func() { close(replied) })
+		return map[string]int{"lines": len(args.Lines)}, nil
+	})
+	mux.Handle("fail", func(ctx context.Context, call *Call) (any, error) {
+		return nil, errors.New("broken")
+	})
+	server := connect(t, mux)
+
+	if hello := mux.Hello("v1.2.3"); !reflect.DeepEqual(hello.Commands, []string{"echo", "fail"}) || !hello.Supports("echo") {
+		t.Errorf("hello = %+v", hello)
+	}
+
+	var output []string
+	result, err := Run(context.Background(), server, Request{
+		Command: "echo",
+		Args:    json.RawMessage(`{"lines":["one","two"]}`),
+	}, func(line string) { output = append(output, line) })
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := []string{"one", "two", "no newline"}; !reflect.DeepEqual(output, want) {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+	if !result.OK || string(result.Result) != `{"lines":2}` {
+		t.Errorf("result = %+v", result)
+	}
+	select {
+	case <-replied:
+	case <-time.After(5 * time.Second):
+		t.Error("AfterReply didn't run")
+	}
+
+	tests := []struct {
+		command string
+		err     string
+	}{
+		{"fail", "broken"},
+		{"unknown", `unknown command "unknown"`},
+	}
+	for _, tt := range tests {
+		result, err := Run(context.Background(), server, Request{Command: tt.command}, nil)
+		if err != nil {
+			t.Fatalf("Run(%s): %v", tt.command, err)
+		}
+		if result.OK || result.Error != tt.err {
+			t.Errorf("Run(%s) = %+v, want error %q", tt.command, result, tt.err)
+		}
+	}
+}
+
+func TestRun_Cancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	mux := NewMux()
+	mux.Handle("hang", func(ctx context.Context, call *Call) (any, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, nil
+	})
+	server := connect(t, mux)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Run(ctx, server, Request{Command: "hang"}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The device stops the command when the server gives up on it
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("handler wasn't cancelled")
+	}
+}
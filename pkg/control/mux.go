@@ -0,0 +1,186 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Handler runs a command. Lines written to call.Output are streamed to the
+// server while it runs; the returned value is marshaled as the result.
+type Handler func(ctx context.Context, call *Call) (any, error)
+
+// Call is one command being handled
+type Call struct {
+	Args   json.RawMessage
+	Output io.Writer
+
+	afterReply []func()
+}
+
+// DecodeArgs unmarshals the command's arguments into v (no arguments leave
+// v unchanged)
+func (c *Call) DecodeArgs(v any) error {
+	if len(c.Args) == 0 || string(c.Args) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(c.Args, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// AfterReply runs fn once the result has been sent, for commands that take
+// down the connection they arrived on
+func (c *Call) AfterReply(fn func()) {
+	c.afterReply = append(c.afterReply, fn)
+}
+
+// Mux dispatches commands from the server to handlers on the device
+type Mux struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler for command
+func (m *Mux) Handle(command string, handler Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[command] = handler
+}
+
+// Hello returns the hello the device sends to announce its commands
+func (m *Mux) Hello(clientVersion string) Hello {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	commands := make([]string, 0, len(m.handlers))
+	for command := range m.handlers {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return Hello{Version: ProtocolVersion, ClientVersion: clientVersion, Commands: commands}
+}
+
+// Serve handles control channels until chans is closed (the SSH connection
+// ended). Get chans from ssh.Client.HandleChannelOpen(ChannelType).
+func (m *Mux) Serve(ctx context.Context, chans <-chan ssh.NewChannel) {
+	for newChannel := range chans {
+		go m.serveChannel(ctx, newChannel)
+	}
+}
+
+func (m *Mux) serveChannel(ctx context.Context, newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	// Requests end when the server closes the channel (not when it only
+	// closes its side after sending the request), e.g. its caller gave up
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ssh.DiscardRequests(reqs)
+		cancel()
+	}()
+
+	events := &eventWriter{encoder: json.NewEncoder(channel)}
+
+	var req Request
+	if err := json.NewDecoder(io.LimitReader(channel, maxRequestSize)).Decode(&req); err != nil {
+		events.write(Event{Type: EventResult, Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	m.mu.RLock()
+	handler, ok := m.handlers[req.Command]
+	m.mu.RUnlock()
+	if !ok {
+		events.write(Event{Type: EventResult, Error: fmt.Sprintf("unknown command %q", req.Command)})
+		return
+	}
+
+	log.Printf("Running remote command: %s", req.Command)
+	output := &lineWriter{emit: func(line string) {
+		events.write(Event{Type: EventOutput, Data: line})
+	}}
+	call := &Call{Args: req.Args, Output: output}
+	value, err := handler(ctx, call)
+	output.flush()
+
+	result := Event{Type: EventResult, OK: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	} else if value != nil {
+		if data, err := json.Marshal(value); err != nil {
+			result.OK, result.Error = false, fmt.Sprintf("failed to encode result: %v", err)
+		} else {
+			result.Result = data
+		}
+	}
+	if err := events.write(result); err != nil {
+		log.Printf("Failed to send result of %s: %v", req.Command, err)
+	}
+	channel.CloseWrite()
+
+	for _, fn := range call.afterReply {
+		fn()
+	}
+}
+
+// eventWriter writes events from the handler and its output concurrently
+type eventWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func (w *eventWriter) write(event Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.encoder.Encode(event)
+}
+
+// lineWriter emits complete lines; flush emits a trailing partial line
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	emit func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimSuffix(w.buf.Next(i + 1)[:i], []byte("\r")))
+		w.emit(line)
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() > 0 {
+		w.emit(w.buf.String())
+		w.buf.Reset()
+	}
+}
@@ -0,0 +1,205 @@
+// Package forward copies between two connections in both directions the way
+// a TCP proxy should: when one side finishes sending, the other side is told
+// with a half-close (CloseWrite) and the reverse direction keeps flowing
+// until it finishes too. Protocols that half-close (rsync, git and scp over
+// ssh, shell pipelines) survive being tunneled.
+//
+// It works with net.Conn and ssh.Channel alike. Sides that can't half-close
+// are closed instead, which ends both directions.
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// BufferSize is the largest chunk copied at a time
+const BufferSize = 32 * 1024
+
+// ErrIdleTimeout is returned when no data moved for Options.IdleTimeout
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// Direction is the way a chunk of data flows
+type Direction int
+
+const (
+	AToB Direction = iota // From the first connection passed to Pipe to the second
+	BToA
+)
+
+// Options customize Pipe. The zero value copies without limits.
+type Options struct {
+	// Close both sides after this long without data in either direction
+	IdleTimeout time.Duration
+
+	// BeforeWrite is called with the size of each chunk before it is
+	// written, e.g. to wait for a rate limiter. An error aborts the pipe.
+	BeforeWrite func(ctx context.Context, dir Direction, n int) error
+
+	// AfterWrite is called with the bytes of each chunk written
+	AfterWrite func(dir Direction, n int)
+}
+
+// Stats counts the bytes forwarded in each direction
+type Stats struct {
+	AToB int64
+	BToA int64
+}
+
+// closeWriter is implemented by *net.TCPConn, *net.UnixConn, *tls.Conn,
+// ssh.Channel and the net.Conn of ssh.Client.Dial/Listen
+type closeWriter interface {
+	CloseWrite() error
+}
+
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// errNoHalfClose ends the pipe after a side finished sending to a
+// connection that can't half-close
+var errNoHalfClose = errors.New("connection can't half-close")
+
+// Pipe forwards a to b and b to a until both directions reach EOF, either
+// side fails, the idle timeout passes or ctx is done. A deadline on ctx is
+// also set on both sides if they support deadlines. Both a and b are closed
+// when Pipe returns.
+//
+// The error is nil when both directions finished, ErrIdleTimeout, ctx.Err()
+// or the first read, write or BeforeWrite error.
+func Pipe(ctx context.Context, a, b io.ReadWriteCloser, opts Options) (Stats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer a.Close()
+	defer b.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		for _, conn := range []io.ReadWriteCloser{a, b} {
+			if d, ok := conn.(deadliner); ok {
+				d.SetDeadline(deadline)
+			}
+		}
+	}
+
+	p := &pipe{opts: opts}
+	p.touch()
+
+	var stats Stats
+	errs := make(chan error, 2)
+	go func() {
+		errs <- p.copy(ctx, b, a, AToB, &stats.AToB)
+	}()
+	go func() {
+		errs <- p.copy(ctx, a, b, BToA, &stats.BToA)
+	}()
+
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if opts.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(opts.IdleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	var err error
+	done := ctx.Done()
+	stop := func(reason error) {
+		if done == nil {
+			return // Already stopping; later errors are fallout from closing
+		}
+		err = reason
+		done, idle = nil, nil
+		cancel()
+		a.Close()
+		b.Close()
+	}
+
+	for remaining := 2; remaining > 0; {
+		select {
+		case copyErr := <-errs:
+			remaining--
+			if errors.Is(copyErr, errNoHalfClose) {
+				stop(nil)
+			} else if copyErr != nil {
+				stop(contextError(ctx, copyErr))
+			}
+		case <-done:
+			stop(ctx.Err())
+		case <-idle:
+			idleFor := p.idleFor()
+			if idleFor >= opts.IdleTimeout {
+				stop(ErrIdleTimeout)
+			} else {
+				idleTimer.Reset(opts.IdleTimeout - idleFor)
+			}
+		}
+	}
+
+	// Both goroutines have returned, so the counters are final
+	return stats, err
+}
+
+// contextError reports err as the context's error when ctx ended it, e.g. a
+// read that hit the deadline set from ctx just before ctx itself expired
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+type pipe struct {
+	opts       Options
+	lastActive atomic.Int64 // Unix nanoseconds
+}
+
+func (p *pipe) touch() {
+	p.lastActive.Store(time.Now().UnixNano())
+}
+
+func (p *pipe) idleFor() time.Duration {
+	return time.Since(time.Unix(0, p.lastActive.Load()))
+}
+
+// copy copies src to dst, then half-closes dst at EOF
+func (p *pipe) copy(ctx context.Context, dst io.Writer, src io.Reader, dir Direction, count *int64) error {
+	buf := make([]byte, BufferSize)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if p.opts.BeforeWrite != nil {
+				if err := p.opts.BeforeWrite(ctx, dir, n); err != nil {
+					return err
+				}
+			}
+			written, err := dst.Write(buf[:n])
+			*count += int64(written)
+			p.touch()
+			if p.opts.AfterWrite != nil && written > 0 {
+				p.opts.AfterWrite(dir, written)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			cw, ok := dst.(closeWriter)
+			if !ok {
+				return errNoHalfClose
+			}
+			// Failing means dst is gone, and the other direction ends too
+			cw.CloseWrite()
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
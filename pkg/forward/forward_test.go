@@ -0,0 +1,346 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const transferSize = 8 << 20
+
+// listen serves each accepted connection with handle until the test ends
+func listen(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// hashServer reads until EOF, then replies with the SHA-256 of what it read,
+// like an rsync or git peer that answers after the request is complete
+func hashServer(conn net.Conn) {
+	defer conn.Close()
+	h := sha256.New()
+	io.Copy(h, conn)
+	conn.Write(h.Sum(nil))
+}
+
+// echoServer echoes until EOF, then half-closes
+func echoServer(conn net.Conn) {
+	defer conn.Close()
+	io.Copy(conn, conn)
+	conn.(*net.TCPConn).CloseWrite()
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// proxy forwards each connection to target with Pipe and reports the result
+func proxy(t *testing.T, target string, opts Options, results chan<- error) string {
+	return listen(t, func(conn net.Conn) {
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			conn.Close()
+			results <- err
+			return
+		}
+		_, err = Pipe(context.Background(), conn, upstream, opts)
+		results <- err
+	})
+}
+
+func TestPipe_HalfClose(t *testing.T) {
+	var counted atomic.Int64
+	results := make(chan error, 1)
+	addr := proxy(t, listen(t, hashServer), Options{
+		AfterWrite: func(dir Direction, n int) { counted.Add(int64(n)) },
+	}, results)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data := randomData(t, transferSize)
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	// The server only answers after EOF, which has to make it through the proxy
+	conn.(*net.TCPConn).CloseWrite()
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(reply, sum[:]) {
+		t.Fatalf("reply = %x, want %x", reply, sum)
+	}
+	if err := <-results; err != nil {
+		t.Errorf("Pipe = %v", err)
+	}
+	if got := counted.Load(); got != transferSize+sha256.Size {
+		t.Errorf("AfterWrite counted %d bytes, want %d", got, transferSize+sha256.Size)
+	}
+}
+
+// sshPair starts an in-process SSH server that forwards direct-tcpip
+// channels with Pipe, and returns a client connected to it
+func sshPair(t *testing.T, results chan<- error) *ssh.Client {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	addr := listen(t, func(conn net.Conn) {
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			var target struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+				newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+				continue
+			}
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, channelReqs, err := newChannel.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(channelReqs)
+			go func() {
+				_, err := Pipe(context.Background(), channel, upstream, Options{})
+				results <- err
+			}()
+		}
+	})
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestPipe_OverSSH(t *testing.T) {
+	serverResults := make(chan error, 2)
+	client := sshPair(t, serverResults)
+
+	// Like ssh -L: local TCP connections are piped into channels of the SSH
+	// client, which the server pipes to the target
+	forwardTo := func(target string) (string, chan error) {
+		results := make(chan error, 1)
+		return listen(t, func(conn net.Conn) {
+			channel, err := client.Dial("tcp", target)
+			if err != nil {
+				conn.Close()
+				results <- err
+				return
+			}
+			_, err = Pipe(context.Background(), conn, channel, Options{})
+			results <- err
+		}), results
+	}
+
+	t.Run("large echo", func(t *testing.T) {
+		addr, results := forwardTo(listen(t, echoServer))
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// Write and read at the same time so both directions are busy
+		data := randomData(t, transferSize)
+		go func() {
+			conn.Write(data)
+			conn.(*net.TCPConn).CloseWrite()
+		}()
+		echoed, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(echoed, data) {
+			t.Fatalf("echoed %d bytes, want the %d sent", len(echoed), len(data))
+		}
+		if err := <-results; err != nil {
+			t.Errorf("client Pipe = %v", err)
+		}
+		if err := <-serverResults; err != nil {
+			t.Errorf("server Pipe = %v", err)
+		}
+	})
+
+	t.Run("reply after half-close", func(t *testing.T) {
+		addr, results := forwardTo(listen(t, hashServer))
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		data := randomData(t, transferSize)
+		if _, err := conn.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		conn.(*net.TCPConn).CloseWrite()
+
+		reply, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := sha256.Sum256(data); !bytes.Equal(reply, sum[:]) {
+			t.Fatalf("reply = %x, want %x", reply, sum)
+		}
+		if err := <-results; err != nil {
+			t.Errorf("client Pipe = %v", err)
+		}
+		if err := <-serverResults; err != nil {
+			t.Errorf("server Pipe = %v", err)
+		}
+	})
+}
+
+func TestPipe_Timeouts(t *testing.T) {
+	deadline, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		opts Options
+		want error
+	}{
+		{"idle", context.Background(), Options{IdleTimeout: 50 * time.Millisecond}, ErrIdleTimeout},
+		{"deadline", deadline, Options{}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A server that never sends or closes
+			silent := listen(t, func(conn net.Conn) {
+				io.Copy(io.Discard, conn)
+				conn.Close()
+			})
+			upstream, err := net.Dial("tcp", silent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, b := net.Pipe()
+			defer a.Close()
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := Pipe(tt.ctx, b, upstream, tt.opts)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("Pipe = %v, want %v", err, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Pipe didn't return")
+			}
+		})
+	}
+}
+
+func TestPipe_WithoutHalfClose(t *testing.T) {
+	// net.Pipe can't half-close, so EOF from one side ends both directions
+	a, aPeer := net.Pipe()
+	b, bPeer := net.Pipe()
+	defer b.Close()
+
+	done := make(chan error, 1)
+	var stats Stats
+	go func() {
+		var err error
+		stats, err = Pipe(context.Background(), aPeer, bPeer, Options{})
+		done <- err
+	}()
+
+	go io.Copy(io.Discard, b)
+	a.Write([]byte("hello"))
+	a.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("Pipe = %v", err)
+	}
+	if stats.AToB != 5 || stats.BToA != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestPipe_BeforeWriteError(t *testing.T) {
+	a, aPeer := net.Pipe()
+	b, bPeer := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errLimit := errors.New("limit")
+	done := make(chan error, 1)
+	go func() {
+		_, err := Pipe(context.Background(), aPeer, bPeer, Options{
+			BeforeWrite: func(context.Context, Direction, int) error { return errLimit },
+		})
+		done <- err
+	}()
+
+	a.Write([]byte("hello"))
+	if err := <-done; !errors.Is(err, errLimit) {
+		t.Errorf("Pipe = %v, want %v", err, errLimit)
+	}
+}
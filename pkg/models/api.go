@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 // Auth API types
 type RequestCodeRequest struct {
 	Email string `json:"email" validate:"required,email"`
@@ -20,6 +22,20 @@ type VerifyCodeResponse struct {
 	ExpiresAt string `json:"expires_at"`
 }
 
+// AuthProviderInfo describes a login method. OIDC providers include what a
+// client needs for the authorization code flow.
+type AuthProviderInfo struct {
+	Name     string   `json:"name"` // firebase, oidc or local
+	Issuer   string   `json:"issuer,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+type AuthProvidersResponse struct {
+	Providers  []AuthProviderInfo `json:"providers"`
+	EmailCodes bool               `json:"email_codes"` // request-code/verify-code available
+}
+
 // Device API types
 type RegisterDeviceRequest struct {
 	DeviceName string `json:"device_name" validate:"required,min=1,max=100"`
@@ -43,9 +59,15 @@ type RegisterDeviceResponse struct {
 
 type ListDevicesResponse struct {
 	UserSubnet string   `json:"user_subnet"`
+	DNSServer  string   `json:"dns_server,omitempty"` // VPN resolver address (empty when disabled)
+	DNSZone    string   `json:"dns_zone,omitempty"`   // e.g. "roamie.internal"
 	Devices    []Device `json:"devices"`
 }
 
+type RenameDeviceRequest struct {
+	DisplayName string `json:"display_name" validate:"max=100"`
+}
+
 type DeviceConfigResponse struct {
 	Config string `json:"config"`
 }
@@ -63,6 +85,197 @@ type AddConflictRequest struct {
 	Description string `json:"description"`
 }
 
+// Network conflict API types
+type LocalNetwork struct {
+	CIDR        string `json:"cidr"`
+	Source      string `json:"source"` // "route", "interface", "docker"
+	Description string `json:"description,omitempty"`
+}
+
+type ReportConflictsRequest struct {
+	DeviceID string         `json:"device_id" validate:"required"`
+	Networks []LocalNetwork `json:"networks"`
+}
+
+type ReportConflictsResponse struct {
+	UserSubnet  string   `json:"user_subnet"`
+	Overlapping []string `json:"overlapping"`
+	Recorded    int      `json:"recorded"`
+}
+
+type RehomeSubnetResponse struct {
+	OldSubnet string          `json:"old_subnet"`
+	NewSubnet string          `json:"new_subnet"`
+	Devices   []RehomedDevice `json:"devices"`
+}
+
+type RehomedDevice struct {
+	DeviceID string `json:"device_id"`
+	OldVpnIP string `json:"old_vpn_ip"`
+	NewVpnIP string `json:"new_vpn_ip"`
+}
+
+// Subnet route and exit node API types
+type AdvertiseRoutesRequest struct {
+	Routes   []string `json:"routes"`    // LAN ranges behind the device
+	ExitNode bool     `json:"exit_node"` // Also offer the device as an internet exit
+}
+
+type RouteInfo struct {
+	ID         string `json:"id"`
+	DeviceID   string `json:"device_id"`
+	DeviceName string `json:"device_name"`
+	VpnIP      string `json:"vpn_ip"`
+	CIDR       string `json:"cidr"`
+	Status     string `json:"status"`
+	ExitNode   bool   `json:"exit_node"`
+}
+
+type ListRoutesResponse struct {
+	Routes []RouteInfo `json:"routes"`
+}
+
+type SetExitNodeRequest struct {
+	ExitNodeID string `json:"exit_node_id"` // Empty clears the exit node
+}
+
+type SetExitNodeResponse struct {
+	ExitNodeID string `json:"exit_node_id,omitempty"`
+}
+
+// Session API types
+type SessionInfo struct {
+	ID         string  `json:"id"` // Stays the same across refresh token rotations
+	DeviceID   string  `json:"device_id"`
+	DeviceName string  `json:"device_name,omitempty"`
+	CreatedAt  string  `json:"created_at"`
+	LastUsedAt *string `json:"last_used_at,omitempty"`
+	LastUsedIP string  `json:"last_used_ip,omitempty"`
+	ExpiresAt  string  `json:"expires_at"`
+	Current    bool    `json:"current"` // Session of the device making the request
+}
+
+type ListSessionsResponse struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+type RevokeSessionsResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// Enrollment key API types
+type CreateEnrollmentKeyRequest struct {
+	Description string   `json:"description,omitempty"`
+	Reusable    bool     `json:"reusable,omitempty"`   // Unlimited uses until expiry
+	MaxUses     int      `json:"max_uses,omitempty"`   // Ignored when reusable, default 1
+	ExpiresIn   int64    `json:"expires_in,omitempty"` // Seconds, default 24 hours
+	Tags        []string `json:"tags,omitempty"`
+	Tunnel      bool     `json:"tunnel,omitempty"`
+	Ephemeral   bool     `json:"ephemeral,omitempty"`
+}
+
+type EnrollmentKeyInfo struct {
+	ID          string   `json:"id"`
+	KeyPrefix   string   `json:"key_prefix"`
+	Description string   `json:"description,omitempty"`
+	MaxUses     *int     `json:"max_uses,omitempty"` // Absent for reusable keys
+	Uses        int      `json:"uses"`
+	Tags        []string `json:"tags"`
+	Tunnel      bool     `json:"tunnel"`
+	Ephemeral   bool     `json:"ephemeral"`
+	Status      string   `json:"status"` // active, expired or used
+	CreatedAt   string   `json:"created_at"`
+	ExpiresAt   string   `json:"expires_at"`
+	LastUsedAt  *string  `json:"last_used_at,omitempty"`
+}
+
+type CreateEnrollmentKeyResponse struct {
+	Key           string            `json:"key"` // Only returned once
+	EnrollmentKey EnrollmentKeyInfo `json:"enrollment_key"`
+}
+
+type ListEnrollmentKeysResponse struct {
+	Keys []EnrollmentKeyInfo `json:"keys"`
+}
+
+// Device challenge API types
+type DeviceChallengeInfo struct {
+	ID                string   `json:"id"`
+	DeviceID          string   `json:"device_id"`
+	Hostname          string   `json:"hostname"`
+	OSType            string   `json:"os_type,omitempty"`
+	Username          string   `json:"username,omitempty"`
+	HardwareID        string   `json:"hardware_id,omitempty"`
+	IPAddress         string   `json:"ip_address"`
+	RequestedUser     string   `json:"requested_user,omitempty"` // Email of the user the device asked to join
+	UserCode          string   `json:"user_code,omitempty"`      // Code shown by the device (XXXX-XXXX)
+	Approvals         int      `json:"approvals"`
+	RequiredApprovals int      `json:"required_approvals"`
+	ApprovedBy        []string `json:"approved_by"`
+	CreatedAt         string   `json:"created_at"`
+	ExpiresAt         string   `json:"expires_at"`
+}
+
+type ListDeviceChallengesResponse struct {
+	Challenges []DeviceChallengeInfo `json:"challenges"`
+}
+
+// LookupDeviceChallengeRequest finds a pending device request by the user
+// code the device displays
+type LookupDeviceChallengeRequest struct {
+	UserCode string `json:"user_code"`
+}
+
+type ReviewDeviceChallengeRequest struct {
+	Email string `json:"email,omitempty"` // Admin approvals: user the device joins if it didn't name one
+}
+
+type ReviewDeviceChallengeResponse struct {
+	Status            string `json:"status"` // pending (more approvals needed), approved or denied
+	Approvals         int    `json:"approvals"`
+	RequiredApprovals int    `json:"required_approvals"`
+}
+
+// Tunnel host key API types
+type TunnelHostKey struct {
+	Algorithm   string `json:"algorithm"`   // e.g. ssh-ed25519
+	Fingerprint string `json:"fingerprint"` // SHA256:...
+	PublicKey   string `json:"public_key"`  // authorized_keys format
+	Status      string `json:"status"`      // current, next (announced) or previous (retiring)
+}
+
+type TunnelHostKeysResponse struct {
+	HostKeys []TunnelHostKey `json:"host_keys"`
+}
+
+// TunnelExposure is where a device's tunnel port listens and who may
+// connect to it
+type TunnelExposure struct {
+	Bind         string   `json:"bind"`                    // vpn, loopback or public
+	Address      string   `json:"address,omitempty"`       // host:port the port listens on
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"` // Sources allowed when public (empty allows all)
+	Override     bool     `json:"override"`                // Set for this device rather than by the server
+}
+
+type SetTunnelExposureRequest struct {
+	Bind         string   `json:"bind"`                    // vpn, loopback, public or "" for the server's setting
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"` // Only with public
+}
+
+// Device command API types
+type RunDeviceCommandRequest struct {
+	Command string          `json:"command"`        // e.g. doctor, sync-keys, restart-tunnel, logs, apply-config
+	Args    json.RawMessage `json:"args,omitempty"` // Command-specific
+}
+
+type RunDeviceCommandResponse struct {
+	Command string          `json:"command"`
+	OK      bool            `json:"ok"`
+	Output  []string        `json:"output"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
 // Error response
 type ErrorResponse struct {
 	Error   string `json:"error"`
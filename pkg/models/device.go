@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type Device struct {
@@ -29,17 +30,42 @@ type Device struct {
 	LastSeen time.Time `json:"last_seen" db:"last_seen"`
 	IsOnline bool      `json:"is_online" db:"-"` // Calculated field, not stored in DB
 
+	// VPN DNS name, e.g. "laptop.alice.roamie.internal" (calculated, not stored)
+	DNSName string `json:"dns_name,omitempty" db:"-"`
+
 	// SSH Tunnel fields
 	TunnelPort    *int    `json:"tunnel_port,omitempty" db:"tunnel_port"`       // Allocated port 10000-20000
 	TunnelSSHKey  *string `json:"tunnel_ssh_key,omitempty" db:"tunnel_ssh_key"` // SSH public key for tunnel auth
 	TunnelEnabled bool    `json:"tunnel_enabled" db:"tunnel_enabled"`           // Per-device tunnel control
 
+	// Where the tunnel port listens, overriding the server's TUNNEL_BIND
+	// (nil uses the server's settings)
+	TunnelBind         *string        `json:"tunnel_bind,omitempty" db:"tunnel_bind"`                   // vpn, loopback or public
+	TunnelAllowedCIDRs pq.StringArray `json:"tunnel_allowed_cidrs,omitempty" db:"tunnel_allowed_cidrs"` // Sources allowed when public
+
+	// Exit node selected by this device (its internet traffic leaves through that device)
+	ExitNodeID *uuid.UUID `json:"exit_node_id,omitempty" db:"exit_node_id"`
+
+	// Tags from the enrollment key the device enrolled with
+	Tags pq.StringArray `json:"tags,omitempty" db:"tags"`
+
+	// Ephemeral devices are deleted once they have been offline for a while
+	Ephemeral bool `json:"ephemeral" db:"ephemeral"`
+
 	// Metadata
 	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
 	LastHandshake *time.Time `json:"last_handshake,omitempty" db:"last_handshake"`
 	Active        bool       `json:"active" db:"active"`
 }
 
+// Name returns the display name if set, otherwise the device name
+func (d *Device) Name() string {
+	if d.DisplayName != nil && *d.DisplayName != "" {
+		return *d.DisplayName
+	}
+	return d.DeviceName
+}
+
 // ParseDeviceName extracts os_type and hardware_id from device_name
 // Expected format: "android-username-a1b2c3d4"
 // Separator: "-" (hyphen)
@@ -51,6 +77,31 @@ func (d *Device) ParseDeviceName() {
 	}
 }
 
+// Route approval states
+const (
+	RouteStatusPending  = "pending"
+	RouteStatusApproved = "approved"
+	RouteStatusRejected = "rejected"
+)
+
+// ExitRouteCIDR is the route advertised by a device offering itself as an exit node
+const ExitRouteCIDR = "0.0.0.0/0"
+
+// DeviceRoute is a CIDR advertised by a device (subnet router or exit node)
+type DeviceRoute struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	DeviceID   uuid.UUID  `json:"device_id" db:"device_id"`
+	CIDR       string     `json:"cidr" db:"cidr"`
+	Status     string     `json:"status" db:"status"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty" db:"reviewed_at"`
+}
+
+// IsExit reports whether the route offers the device as an exit node
+func (r *DeviceRoute) IsExit() bool {
+	return r.CIDR == ExitRouteCIDR
+}
+
 type NetworkConflict struct {
 	ID          uuid.UUID `json:"id" db:"id"`
 	CIDR        string    `json:"cidr" db:"cidr"`
@@ -58,4 +109,8 @@ type NetworkConflict struct {
 	Description string    `json:"description,omitempty" db:"description"`
 	DetectedAt  time.Time `json:"detected_at" db:"detected_at"`
 	Active      bool      `json:"active" db:"active"`
+
+	// Set only for conflicts reported by a client device (scoped to that user)
+	UserID   *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	DeviceID *uuid.UUID `json:"device_id,omitempty" db:"device_id"`
 }
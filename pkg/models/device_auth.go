@@ -22,6 +22,17 @@ type DeviceAuthChallenge struct {
 	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
 	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
 	ApprovedAt *time.Time `json:"approved_at,omitempty" db:"approved_at"`
+
+	// Set when the challenge was approved with an enrollment key
+	EnrollmentKeyID *uuid.UUID `json:"enrollment_key_id,omitempty" db:"enrollment_key_id"`
+
+	// Approval policy: the user the device asked to join and how many
+	// distinct approvers it needs
+	RequestedUserID   *uuid.UUID `json:"requested_user_id,omitempty" db:"requested_user_id"`
+	RequiredApprovals int        `json:"required_approvals" db:"required_approvals"`
+
+	// Short code users can type instead of scanning the QR code (normalized)
+	UserCode *string `json:"user_code,omitempty" db:"user_code"`
 }
 
 // RefreshToken represents a long-lived refresh token for device authentication
@@ -33,4 +44,17 @@ type RefreshToken struct {
 	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
 	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
 	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+
+	// Rotation: tokens from the same login share a family; a rotated token
+	// must never be presented again
+	FamilyID  uuid.UUID  `json:"family_id" db:"family_id"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+
+	// Session tracking (a session is a token family)
+	SessionStartedAt time.Time `json:"session_started_at" db:"session_started_at"`
+	LastUsedIP       *string   `json:"last_used_ip,omitempty" db:"last_used_ip"`
+
+	// Issued for a registered device (tunnel-only clients have none); the
+	// token is revoked once that device is deleted
+	DeviceBound bool `json:"device_bound" db:"device_bound"`
 }
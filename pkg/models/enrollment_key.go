@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// EnrollmentKey pre-authorizes devices to join a user's network without
+// interactive approval (roamie auth login --enroll-key)
+type EnrollmentKey struct {
+	ID          uuid.UUID      `json:"id" db:"id"`
+	UserID      uuid.UUID      `json:"user_id" db:"user_id"`
+	KeyHash     string         `json:"-" db:"key_hash"`
+	KeyPrefix   string         `json:"key_prefix" db:"key_prefix"`
+	Description string         `json:"description" db:"description"`
+	MaxUses     *int           `json:"max_uses,omitempty" db:"max_uses"` // nil = unlimited until expiry
+	Uses        int            `json:"uses" db:"uses"`
+	Tags        pq.StringArray `json:"tags" db:"tags"`
+	Tunnel      bool           `json:"tunnel" db:"tunnel"`       // Enrolled devices register an SSH tunnel
+	Ephemeral   bool           `json:"ephemeral" db:"ephemeral"` // Enrolled devices are deleted once offline
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time      `json:"expires_at" db:"expires_at"`
+	LastUsedAt  *time.Time     `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt   *time.Time     `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// Status describes whether the key can still enroll devices
+func (k *EnrollmentKey) Status() string {
+	switch {
+	case k.RevokedAt != nil:
+		return "revoked"
+	case time.Now().After(k.ExpiresAt):
+		return "expired"
+	case k.MaxUses != nil && k.Uses >= *k.MaxUses:
+		return "used"
+	}
+	return "active"
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// SigningKey is an Ed25519 key used to sign access tokens
+type SigningKey struct {
+	KID        string     `json:"kid" db:"kid"`
+	Algorithm  string     `json:"algorithm" db:"algorithm"`
+	PrivateKey string     `json:"-" db:"private_key"` // Base64, never serialized
+	PublicKey  string     `json:"public_key" db:"public_key"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	RetiresAt  *time.Time `json:"retires_at,omitempty" db:"retires_at"`
+}
+
+// IsCurrent reports whether the key signs new tokens
+func (k *SigningKey) IsCurrent() bool {
+	return k.RetiresAt == nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 8037 for Ed25519)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
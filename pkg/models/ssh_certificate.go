@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// SSH CA key kinds
+const (
+	SSHCAKindUser = "user"
+	SSHCAKindHost = "host"
+
+	// SSHTunnelPrincipal is the only principal of tunnel certificates, whose
+	// key ID is the device ID
+	SSHTunnelPrincipal = "roamie-tunnel"
+)
+
+// SSHDevicePrincipal is the only principal of user certificates for logging
+// in to a device. Each device accepts its own principal only, so a
+// certificate for one device can't log in to another user's device that
+// happens to have the same OS username.
+func SSHDevicePrincipal(deviceID string) string {
+	return "roamie-device:" + deviceID
+}
+
+// SSHCAKey is an Ed25519 key the server signs SSH certificates with
+type SSHCAKey struct {
+	Kind       string    `json:"kind" db:"kind"`
+	PrivateKey string    `json:"-" db:"private_key"` // OpenSSH PEM, never serialized
+	PublicKey  string    `json:"public_key" db:"public_key"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// SSHCAResponse publishes the CA public keys (authorized_keys format)
+type SSHCAResponse struct {
+	UserCA string `json:"user_ca"` // Trusted by sshd (TrustedUserCAKeys)
+	HostCA string `json:"host_ca"` // Trusted by ssh clients (@cert-authority)
+}
+
+// SSHCertificateRequest asks the CA to sign public_key. For user
+// certificates device_id is the device to connect to; for host certificates
+// it is the device whose host key is signed.
+type SSHCertificateRequest struct {
+	DeviceID  string `json:"device_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// SSHCertificateResponse is a signed certificate in authorized_keys format
+type SSHCertificateResponse struct {
+	Certificate string    `json:"certificate"`
+	Principals  []string  `json:"principals"`
+	ValidBefore time.Time `json:"valid_before"`
+	HostCA      string    `json:"host_ca,omitempty"` // Returned with user certificates to verify the device
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TunnelUsage is a device's tunnel traffic on one day (UTC). In is traffic
+// from connecting clients to the device, out is the device's replies.
+type TunnelUsage struct {
+	DeviceID            uuid.UUID `json:"device_id" db:"device_id"`
+	Day                 time.Time `json:"day" db:"day"`
+	BytesIn             int64     `json:"bytes_in" db:"bytes_in"`
+	BytesOut            int64     `json:"bytes_out" db:"bytes_out"`
+	Connections         int64     `json:"connections" db:"connections"`
+	RejectedConnections int64     `json:"rejected_connections" db:"rejected_connections"`
+}
+
+// TunnelUsageResponse lists daily usage, oldest first
+type TunnelUsageResponse struct {
+	Usage []TunnelUsage `json:"usage"`
+	Since time.Time     `json:"since"`
+}
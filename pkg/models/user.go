@@ -7,13 +7,24 @@ import (
 )
 
 type User struct {
-	ID          uuid.UUID `json:"id" db:"id"`
-	Email       string    `json:"email" db:"email"`
-	Subnet      string    `json:"subnet" db:"subnet"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	MaxDevices  int       `json:"max_devices" db:"max_devices"`
-	Active      bool      `json:"active" db:"active"`
-	FirebaseUID *string   `json:"firebase_uid,omitempty" db:"firebase_uid"`
+	ID           uuid.UUID `json:"id" db:"id"`
+	Email        string    `json:"email" db:"email"`
+	Subnet       string    `json:"subnet" db:"subnet"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	MaxDevices   int       `json:"max_devices" db:"max_devices"`
+	Active       bool      `json:"active" db:"active"`
+	FirebaseUID  *string   `json:"firebase_uid,omitempty" db:"firebase_uid"`
+	PasswordHash *string   `json:"-" db:"password_hash"` // bcrypt hash for local accounts
+}
+
+// UserIdentity links an identity provider subject to a user
+type UserIdentity struct {
+	Provider    string     `json:"provider" db:"provider"`
+	Subject     string     `json:"subject" db:"subject"`
+	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
+	Email       string     `json:"email" db:"email"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
 }
 
 type AuthCode struct {
@@ -48,6 +48,11 @@ func GetHardwareID() string {
 	return hex.EncodeToString(hash[:4]) // 8 hex chars from 4 bytes
 }
 
+// MachineID returns the OS machine ID (/etc/machine-id on Linux)
+func MachineID() (string, error) {
+	return getMachineID()
+}
+
 // getMachineID attempts to read the machine ID from common locations
 func getMachineID() (string, error) {
 	// Try systemd machine-id (most Linux distros)
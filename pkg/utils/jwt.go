@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"time"
 
@@ -9,24 +10,103 @@ import (
 )
 
 type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
+	UserID   uuid.UUID `json:"user_id"`
+	Email    string    `json:"email"`
+	DeviceID uuid.UUID `json:"device_id"` // uuid.Nil unless the token was issued to a registered device
+	// Session (refresh token family) the token was issued for; uuid.Nil for
+	// tokens that can't be refreshed
+	SessionID uuid.UUID `json:"sid"`
 	jwt.RegisteredClaims
 }
 
+// HasDevice reports whether the token is bound to a device
+func (c *Claims) HasDevice() bool {
+	return c.DeviceID != uuid.Nil
+}
+
 func GenerateJWT(userID uuid.UUID, email, secret string, expiration time.Duration) (string, error) {
-	claims := Claims{
-		UserID: userID,
-		Email:  email,
+	return GenerateDeviceJWT(userID, uuid.Nil, email, secret, expiration)
+}
+
+// GenerateDeviceJWT generates an HS256 JWT bound to a device (uuid.Nil for a user-only token)
+func GenerateDeviceJWT(userID, deviceID uuid.UUID, email, secret string, expiration time.Duration) (string, error) {
+	return SignHS256(NewClaims(userID, deviceID, email, expiration), secret)
+}
+
+// SignHS256 signs claims with a shared secret
+func SignHS256(claims Claims, secret string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// NewClaims builds the claims of an access token valid from now for expiration
+func NewClaims(userID, deviceID uuid.UUID, email string, expiration time.Duration) Claims {
+	now := time.Now()
+	return Claims{
+		UserID:   userID,
+		Email:    email,
+		DeviceID: deviceID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+// SignJWT signs claims with an Ed25519 key; kid lets verifiers pick the
+// matching public key
+func SignJWT(claims Claims, kid string, key ed25519.PrivateKey) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// KeyLookup returns the Ed25519 public key with the given kid
+type KeyLookup func(kid string) (ed25519.PublicKey, bool)
+
+// LegacyHS256 accepts HS256 tokens signed with Secret until Until, so tokens
+// issued before the switch to signing keys keep working until they expire
+type LegacyHS256 struct {
+	Secret string
+	Until  time.Time
+}
+
+// Accepts reports whether HS256 tokens are still accepted at now
+func (l LegacyHS256) Accepts(now time.Time) bool {
+	return l.Secret != "" && now.Before(l.Until)
+}
+
+// ParseJWT validates an EdDSA token against the keys from lookup, or an
+// HS256 token while legacy accepts them
+func ParseJWT(tokenString string, lookup KeyLookup, legacy LegacyHS256) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodEd25519:
+			kid, _ := token.Header["kid"].(string)
+			key, ok := lookup(kid)
+			if !ok {
+				return nil, fmt.Errorf("unknown signing key %q", kid)
+			}
+			return key, nil
+		case *jwt.SigningMethodHMAC:
+			if !legacy.Accepts(time.Now()) {
+				return nil, fmt.Errorf("HS256 tokens are no longer accepted")
+			}
+			return []byte(legacy.Secret), nil
+		}
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodHS256.Alg()}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
 }
 
 func ValidateJWT(tokenString, secret string) (*Claims, error) {
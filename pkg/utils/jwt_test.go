@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestGenerateDeviceJWT(t *testing.T) {
+	userID := uuid.New()
+	deviceID := uuid.New()
+
+	token, err := GenerateDeviceJWT(userID, deviceID, "user@example.com", "secret", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateDeviceJWT failed: %v", err)
+	}
+
+	claims, err := ValidateJWT(token, "secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+
+	if claims.UserID != userID {
+		t.Errorf("UserID = %s, want %s", claims.UserID, userID)
+	}
+	if claims.DeviceID != deviceID || !claims.HasDevice() {
+		t.Errorf("DeviceID = %s, want %s", claims.DeviceID, deviceID)
+	}
+	if remaining := time.Until(claims.ExpiresAt.Time); remaining > 15*time.Minute || remaining < 14*time.Minute {
+		t.Errorf("token expires in %s, want ~15m", remaining)
+	}
+}
+
+func TestGenerateJWT_NoDevice(t *testing.T) {
+	token, err := GenerateJWT(uuid.New(), "user@example.com", "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+
+	claims, err := ValidateJWT(token, "secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT failed: %v", err)
+	}
+
+	if claims.HasDevice() {
+		t.Errorf("user token should not carry a device, got %s", claims.DeviceID)
+	}
+}
+
+func TestValidateJWT_Rejects(t *testing.T) {
+	expired, _ := GenerateJWT(uuid.New(), "user@example.com", "secret", -time.Minute)
+	if _, err := ValidateJWT(expired, "secret"); err == nil {
+		t.Error("expired token should be rejected")
+	}
+
+	valid, _ := GenerateJWT(uuid.New(), "user@example.com", "secret", time.Hour)
+	if _, err := ValidateJWT(valid, "other-secret"); err == nil {
+		t.Error("token signed with another secret should be rejected")
+	}
+}
+
+func TestSignJWT_Ed25519(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	lookup := func(kid string) (ed25519.PublicKey, bool) {
+		if kid == "key-1" {
+			return public, true
+		}
+		return nil, false
+	}
+
+	userID := uuid.New()
+	token, err := SignJWT(NewClaims(userID, uuid.Nil, "user@example.com", time.Minute), "key-1", private)
+	if err != nil {
+		t.Fatalf("SignJWT failed: %v", err)
+	}
+
+	claims, err := ParseJWT(token, lookup, LegacyHS256{})
+	if err != nil {
+		t.Fatalf("ParseJWT failed: %v", err)
+	}
+	if claims.UserID != userID {
+		t.Errorf("UserID = %s, want %s", claims.UserID, userID)
+	}
+
+	// Unknown kid
+	other, _ := SignJWT(NewClaims(userID, uuid.Nil, "user@example.com", time.Minute), "key-2", private)
+	if _, err := ParseJWT(other, lookup, LegacyHS256{}); err == nil {
+		t.Error("token with unknown kid should be rejected")
+	}
+
+	// Key ID pointing at a different key
+	_, wrongKey, _ := ed25519.GenerateKey(rand.Reader)
+	forged, _ := SignJWT(NewClaims(userID, uuid.Nil, "user@example.com", time.Minute), "key-1", wrongKey)
+	if _, err := ParseJWT(forged, lookup, LegacyHS256{}); err == nil {
+		t.Error("token signed by another key should be rejected")
+	}
+}
+
+func TestParseJWT_LegacyHS256(t *testing.T) {
+	noKeys := func(kid string) (ed25519.PublicKey, bool) { return nil, false }
+
+	legacy, err := GenerateJWT(uuid.New(), "user@example.com", "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+
+	if _, err := ParseJWT(legacy, noKeys, LegacyHS256{Secret: "secret", Until: time.Now().Add(time.Hour)}); err != nil {
+		t.Errorf("legacy token should be accepted during migration: %v", err)
+	}
+	if _, err := ParseJWT(legacy, noKeys, LegacyHS256{Until: time.Now().Add(time.Hour)}); err == nil {
+		t.Error("legacy token should be rejected once JWT_SECRET is removed")
+	}
+	if _, err := ParseJWT(legacy, noKeys, LegacyHS256{Secret: "secret", Until: time.Now().Add(-time.Minute)}); err == nil {
+		t.Error("legacy token should be rejected after the cutoff even with JWT_SECRET set")
+	}
+}